@@ -6,19 +6,28 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/gvallee/go_util/pkg/util"
 	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/checker"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/containerizer"
+	"github.com/sylabs/singularity-mpi/pkg/events"
 	"github.com/sylabs/singularity-mpi/pkg/launcher"
 	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
@@ -29,6 +38,30 @@ func main() {
 	debug := flag.Bool("d", false, "Enable debug mode")
 	appContainizer := flag.String("conf", "", "Path to the configuration file for automatically containerization an application")
 	upload := flag.Bool("upload", false, "Upload generated images (appropriate configuration files need to specify the registry's URL")
+	keepScratch := flag.Bool("keep-scratch", false, "Keep the scratch and build directories when the creation of the container fails")
+	cleanupPolicy := flag.String("cleanup", "", "What to do with the resources once they are not needed anymore: always, on-success or never for all of them, or a comma-separated list of <resource>=<policy> where resource is host-mpi, build, scratch or image, e.g., -cleanup build=on-success,image=never")
+	nameTemplate := flag.String("name-template", "", "Template used to name the image, overwriting the 'container_name' key of the configuration file, e.g., -name-template \"{app}-{mpi}-{version}-{date}\". Available tags: {distro}, {mpi}, {version}, {app}, {model} and {date}")
+	outputDir := flag.String("output-dir", "", "Directory where the image is created, e.g., a site image repository")
+	mirrorHostMPI := flag.String("mirror-host-mpi", "", "Installation directory of a MPI on the host whose configuration (threading level, Fortran bindings, CUDA support) is mirrored when building MPI in the container")
+	tagPolicy := flag.String("tag-policy", "", "Comma-separated list of the tags given to the image when uploaded, overwriting the 'tag_policy' key of the configuration file, e.g., -tag-policy semver,latest. Available tags: semver (value of the 'app_version' key), git (git describe of the application's source directory), date and latest")
+	resolveBase := flag.Bool("resolve-base", false, "Look up the current digest of the base image and pin it in the definition file, warning when it changed since the previous build")
+	dockerfile := flag.Bool("dockerfile", false, "Generate a Dockerfile equivalent to the definition file next to the image, like the 'dockerfile = true' key of the configuration file")
+	baseDigest := flag.String("base-digest", "", "Digest the base image is pinned to, overwriting the 'base_image_digest' key of the configuration file, e.g., -base-digest sha256:<hex>")
+	sandboxEnv := flag.Bool("sandbox-env", false, "Execute all the build commands with a minimal environment instead of the environment of the host, overwriting the 'sandbox_env' key of the configuration file; the environment of each command is recorded in the ledger")
+	envAllowlist := flag.String("env-allowlist", "", "With -sandbox-env, comma-separated list of the host environment variables passed to the commands, e.g., -env-allowlist http_proxy,https_proxy")
+	proxy := flag.String("proxy", "", "Proxy of all the network operations (downloads, Git checkouts, registries), overwriting the 'proxy' key of the configuration file, e.g., -proxy http://proxy.example.com:3128")
+	noProxy := flag.String("no-proxy", "", "Comma-separated list of the hosts reached without proxy, overwriting the 'no_proxy' key of the configuration file, e.g., -no-proxy localhost,.example.com")
+	buildProfile := flag.String("build-profile", "", "Build profile of the tool's configuration file selecting the toolchain building MPI and the application, overwriting the 'build_profile' key of the configuration files, e.g., -build-profile nvidia")
+	caFile := flag.String("ca-file", "", "Bundle of PEM certificates of extra CAs trusted by the network operations, overwriting the 'ca_file' key of the configuration file, e.g., -ca-file /etc/pki/corporate-ca.pem")
+	listTags := flag.String("list-tags", "", "List the tags previously uploaded to a repository, e.g., library://user/collection/app ('all' for all the repositories)")
+	retractTag := flag.String("retract-tag", "", "Delete a previously uploaded tag from the registry, e.g., library://user/collection/app:1.2.0; 'latest' is rolled back to the previous image if it pointed to the retracted one")
+	batch := flag.String("batch", "", "Create the containers of several applications, specified with a directory of configuration files (*.conf), a glob pattern or a manifest listing the configuration files")
+	parallelism := flag.Int("j", containerizer.DefaultBatchParallelism, "Maximum number of containers created concurrently in batch mode")
+	quick := flag.Bool("quick", false, "Build the container on top of a pre-built base image with the Linux distribution and MPI, overwriting the 'build_strategy' key of the configuration file; the base image is built and cached in the workspace the first time")
+	listBaseImages := flag.Bool("list-base-images", false, "List the pre-built base images used by -quick")
+	removeBaseImage := flag.String("remove-base-image", "", "Remove a pre-built base image used by -quick, e.g., ubuntu-20.04_openmpi-4.0.2")
+	eventsTarget := flag.String("events", "", "Emit the progress events (phase completed, build failed) as JSON lines to a file or a file descriptor, e.g., -events events.jsonl or -events fd:3")
+	watch := flag.Bool("watch", false, "Rebuild the container every time the sources of the application change, the application's URL must be a local directory (e.g., file:///path/to/src)")
 	noinstall := flag.Bool("noinstall", false, "Keep the MPI installations on the host and the container images in the specified directory (instead of deleting everything once an experiment terminates). Default is '~/.sympi', set SYMPI_INSTALL_DIR to overwrite")
 
 	flag.Parse()
@@ -44,6 +77,17 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
+	// All the commands executed are recorded in the ledger of the run, see 'sympi -show-ledger'
+	syexec.SetLedger(syexec.NewLedgerPath(filepath.Join(sys.GetSympiDir(), syexec.LedgerDirName)))
+
+	if *eventsTarget != "" {
+		err := events.Open(*eventsTarget)
+		if err != nil {
+			log.Fatalf("cannot emit the events: %s", err)
+		}
+		defer events.Close()
+	}
+
 	sysCfg, _, _, err := launcher.Load()
 	if err != nil {
 		log.Fatalf("unable to load configuration: %s", err)
@@ -63,10 +107,84 @@ func main() {
 	sysCfg.Upload = *upload
 	sysCfg.Verbose = *verbose
 	sysCfg.Debug = *debug
+	sysCfg.KeepScratch = *keepScratch
+	sysCfg.Cleanup, err = sys.ParseCleanupPolicy(*cleanupPolicy)
+	if err != nil {
+		log.Fatalf("invalid cleanup policy: %s", err)
+	}
+	sysCfg.OutputDir = *outputDir
+	sysCfg.MirrorHostMPI = *mirrorHostMPI
+	if *nameTemplate != "" {
+		err = container.ValidateNameTemplate(*nameTemplate)
+		if err != nil {
+			log.Fatalf("invalid naming template: %s", err)
+		}
+		sysCfg.ContainerNameTemplate = *nameTemplate
+	}
+	if *tagPolicy != "" {
+		err = container.ValidateTagPolicy(*tagPolicy)
+		if err != nil {
+			log.Fatalf("invalid tag policy: %s", err)
+		}
+		sysCfg.TagPolicy = *tagPolicy
+	}
+	sysCfg.ResolveBaseImage = *resolveBase
+	sysCfg.QuickBuild = *quick
+	sysCfg.Dockerfile = *dockerfile
+	if *baseDigest != "" {
+		err = container.ValidateDigest(*baseDigest)
+		if err != nil {
+			log.Fatalf("invalid base image digest: %s", err)
+		}
+		sysCfg.BaseImageDigest = *baseDigest
+	}
+	if *sandboxEnv {
+		sysCfg.SandboxEnv = true
+	}
+	if *envAllowlist != "" {
+		sysCfg.EnvAllowlist = sys.ParseEnvAllowlist(*envAllowlist)
+	}
+	if *proxy != "" {
+		err := sys.ValidateProxy(*proxy)
+		if err != nil {
+			log.Fatalf("invalid proxy: %s", err)
+		}
+		sysCfg.Proxy = *proxy
+	}
+	if *noProxy != "" {
+		sysCfg.NoProxy = *noProxy
+	}
+	if *caFile != "" {
+		sysCfg.CAFile = *caFile
+	}
+	if *buildProfile != "" {
+		err = sysCfg.SelectBuildProfile(*buildProfile)
+		if err != nil {
+			log.Fatalf("invalid build profile: %s", err)
+		}
+	}
+	// From now, the network operations use the proxy and the CA bundle of the configuration
+	err = sys.SetNetwork(&sysCfg)
+	if err != nil {
+		log.Fatalf("invalid network configuration: %s", err)
+	}
+	// From now, the commands are executed with a minimal environment if requested and the builds
+	// with their resource limits
+	syexec.SetSandbox(sysCfg.SandboxEnv, append(sysCfg.EnvAllowlist, sys.GetNetworkEnvNames(&sysCfg)...))
+	syexec.SetLimits(launcher.GetBuildLimits(&sysCfg))
+	syexec.SetHeartbeat(sysCfg.Heartbeat)
 	if !*noinstall {
 		sysCfg.Persistent = sys.GetSympiDir()
 	}
 
+	// Scratch directories abandoned by interrupted builds are reported or removed depending on the
+	// 'scratch_gc' key of the configuration file
+	scratchGC := sysCfg.ScratchGC
+	if scratchGC == "" {
+		scratchGC = buildenv.ScratchGCWarn
+	}
+	buildenv.CollectScratch(buildenv.GetScratchRoots(&sysCfg), scratchGC, sysCfg.ScratchMaxAge)
+
 	// Check if we can figure out any detail about the installation of Singularity
 	// that may change the way we use Singularity. For instance, do we need to use
 	// sudo or fakeroot to create an image?
@@ -75,13 +193,55 @@ func main() {
 		log.Fatalf("failed to get the Singularity configuration: %s", err)
 	}
 
+	if *listTags != "" {
+		repo := *listTags
+		if repo == "all" {
+			repo = ""
+		}
+		tags, err := container.ListUploadedTags(repo)
+		if err != nil {
+			log.Fatalf("failed to list the uploaded tags: %s", err)
+		}
+		for _, t := range tags {
+			fmt.Printf("%s\t%s\t%s\n", t.Ref(), t.Date, t.Path)
+		}
+		return
+	}
+
+	if *listBaseImages {
+		images, err := containerizer.ListBaseImages()
+		if err != nil {
+			log.Fatalf("failed to list the base images: %s", err)
+		}
+		for _, i := range images {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", i.Name, i.Distro, i.MPI, i.Created.Format("2006-01-02 15:04:05"), i.Path)
+		}
+		return
+	}
+
+	if *removeBaseImage != "" {
+		err = containerizer.RemoveBaseImage(*removeBaseImage)
+		if err != nil {
+			log.Fatalf("failed to remove base image: %s", err)
+		}
+		return
+	}
+
+	if *retractTag != "" {
+		err = container.RetractTag(*retractTag, &sysCfg)
+		if err != nil {
+			log.Fatalf("failed to retract %s: %s", *retractTag, err)
+		}
+		return
+	}
+
 	// Make sure the tool's configuration file is set and load its data
 	log.Println("* Loading the tool's configuration...")
 	toolConfigFile, err := sy.CreateMPIConfigFile()
 	if err != nil {
 		log.Fatalf("cannot setup configuration file: %s", err)
 	}
-	kvs, err := kv.LoadKeyValueConfig(toolConfigFile)
+	kvs, err := configparser.Load(toolConfigFile)
 	if err != nil {
 		log.Fatalf("cannot load the tool's configuration file (%s): %s", toolConfigFile, err)
 	}
@@ -91,8 +251,33 @@ func main() {
 		log.Fatalf("failed to load the tool's configuration: %s", err)
 	}
 
+	if *batch != "" {
+		configs, err := containerizer.ListAppConfigs(*batch)
+		if err != nil {
+			log.Fatalf("failed to get the list of applications: %s", err)
+		}
+		report := containerizer.ContainerizeApps(configs, *parallelism, &sysCfg)
+		fmt.Print(report.String())
+		if report.Failed() > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *watch {
+		err = containerizer.WatchApp(&sysCfg, containerizer.DefaultWatchInterval, nil)
+		if err != nil {
+			log.Fatalf("failed to watch the application: %s", err)
+		}
+		return
+	}
+
 	log.Println("* Creating container for your application...")
 	_, err = containerizer.ContainerizeApp(&sysCfg)
+	if errors.Is(err, sympierr.ErrImageExists) {
+		fmt.Printf("%s, stopping\n", err)
+		return
+	}
 	if err != nil {
 		log.Fatalf("failed to create container for app: %s", err)
 	}
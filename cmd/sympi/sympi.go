@@ -6,6 +6,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,35 +15,28 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/gvallee/go_util/pkg/util"
-	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
-	"github.com/sylabs/singularity-mpi/pkg/builder"
 	"github.com/sylabs/singularity-mpi/pkg/checker"
-	"github.com/sylabs/singularity-mpi/pkg/implem"
-	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/events"
+	"github.com/sylabs/singularity-mpi/pkg/launcher"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/remote"
+	"github.com/sylabs/singularity-mpi/pkg/results"
 	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sympi"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
-func getContainerInstalls(entries []os.FileInfo) ([]string, error) {
-	var containers []string
-	for _, entry := range entries {
-		matched, err := regexp.MatchString(sys.ContainerInstallDirPrefix+`.*`, entry.Name())
-		if err != nil {
-			return containers, fmt.Errorf("failed to parse %s: %s", entry, err)
-		}
-		if matched {
-			containers = append(containers, strings.Replace(entry.Name(), sys.ContainerInstallDirPrefix, "", -1))
-		}
-	}
-	return containers, nil
-}
-
 func getSingularityInstalls(basedir string, entries []os.FileInfo) ([]string, error) {
 	var singularities []string
 
@@ -74,6 +68,62 @@ func getSingularityInstalls(basedir string, entries []os.FileInfo) ([]string, er
 	return singularities, nil
 }
 
+// displayPaths displays the directories used by SyMPI and where they come from
+func displayPaths(sysCfg *sys.Config) {
+	fmt.Println("Configuration directory (by order of precedence):")
+	for _, c := range sys.GetEtcDirCandidates() {
+		status := "not found"
+		if c.Path == sysCfg.EtcDir {
+			status = "selected"
+		} else if util.IsDir(c.Path) {
+			status = "ignored"
+		}
+		fmt.Printf("\t%s (%s): %s\n", c.Path, c.Origin, status)
+	}
+	fmt.Printf("Resolved paths:\n")
+	fmt.Printf("\tetc directory: %s (from %s)\n", sysCfg.EtcDir, sysCfg.EtcDirOrigin)
+	fmt.Printf("\ttemplate directory: %s\n", sysCfg.TemplateDir)
+	fmt.Printf("\tOFI configuration file: %s\n", sysCfg.OfiCfgFile)
+	fmt.Printf("\tSyMPI directory: %s\n", sys.GetSympiDir())
+	fmt.Printf("\tSyMPI configuration file: %s\n", configparser.GetConfigFilePath(sysCfg.SyConfigFile))
+}
+
+// displayConfig displays the content of the tool's configuration file and its last change
+func displayConfig(sysCfg *sys.Config) error {
+	kvs, err := configparser.Load(sysCfg.SyConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %s", sysCfg.SyConfigFile, err)
+	}
+	fmt.Printf("Configuration file: %s\n", configparser.GetConfigFilePath(sysCfg.SyConfigFile))
+	for _, e := range kvs {
+		fmt.Printf("\t%s = %s\n", e.Key, e.Value)
+	}
+	changes, err := sy.LoadConfigHistory(sysCfg.SyConfigFile)
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		fmt.Printf("Last change: %s\n", changes[len(changes)-1].String())
+	}
+	return nil
+}
+
+// displayConfigHistory displays the changes of the tool's configuration file, the oldest first
+func displayConfigHistory(sysCfg *sys.Config) error {
+	changes, err := sy.LoadConfigHistory(sysCfg.SyConfigFile)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("No change recorded")
+		return nil
+	}
+	for _, c := range changes {
+		fmt.Println(c.String())
+	}
+	return nil
+}
+
 func displayInstalled(dir string, filter string) error {
 
 	entries, err := ioutil.ReadDir(dir)
@@ -81,8 +131,15 @@ func displayInstalled(dir string, filter string) error {
 		return fmt.Errorf("failed to read %s: %s", dir, err)
 	}
 
-	curMPIVersion := getLoadedMPI()
-	curSingularityVersion := getLoadedSingularity()
+	// Loaded components are marked with their role
+	loadedRoles := make(map[string]string)
+	envFile, _ := sympi.GetEnvFile()
+	for _, c := range sympi.GetLoaded(envFile) {
+		loadedRoles[c.Name] = " (L)"
+		if c.Role != sympi.RoleAll {
+			loadedRoles[c.Name] = " (L, " + c.Role + ")"
+		}
+	}
 
 	if filter == "all" || filter == "singularity" {
 		singularities, err := getSingularityInstalls(dir, entries)
@@ -92,10 +149,7 @@ func displayInstalled(dir string, filter string) error {
 		if len(singularities) > 0 {
 			fmt.Printf("Available Singularity installation(s) on the host:\n")
 			for _, sy := range singularities {
-				if curSingularityVersion != "" && strings.Contains(sy, curSingularityVersion) {
-					sy = sy + " (L)"
-				}
-				fmt.Printf("\tsingularity:%s\n", sy)
+				fmt.Printf("\tsingularity:%s%s\n", sy, loadedRoles["singularity:"+strings.Fields(sy)[0]])
 			}
 			fmt.Printf("\n")
 		} else {
@@ -111,10 +165,7 @@ func displayInstalled(dir string, filter string) error {
 		if len(hostInstalls) > 0 {
 			fmt.Printf("Available MPI installation(s) on the host:\n")
 			for _, mpi := range hostInstalls {
-				if mpi == curMPIVersion {
-					mpi = mpi + " (L)"
-				}
-				fmt.Printf("\t%s\n", mpi)
+				fmt.Printf("\t%s%s\n", mpi, loadedRoles[mpi])
 			}
 			fmt.Printf("\n")
 		} else {
@@ -123,7 +174,7 @@ func displayInstalled(dir string, filter string) error {
 	}
 
 	if filter == "all" || strings.Contains(filter, "container") {
-		containers, err := getContainerInstalls(entries)
+		containers, err := sympi.GetContainerInstalls(entries)
 		if err != nil {
 			return fmt.Errorf("unable to get the list of containers stored on the host: %s", err)
 		}
@@ -139,311 +190,227 @@ func displayInstalled(dir string, filter string) error {
 	return nil
 }
 
-func getSyDetails(desc string) string {
-	tokens := strings.Split(desc, ":")
-	if len(tokens) != 2 {
-		fmt.Println("invalid Singularity description string, execute 'sympi -list' to get the list of available installations")
-		return ""
-	}
-	return tokens[1]
-}
-
-func getSyMPIBaseDir() string {
-	baseDir := sys.GetSympiDir()
-	// We need to make sure that we do not end up with a / we do not want
-	if string(baseDir[len(baseDir)-1]) != "/" {
-		baseDir = baseDir + "/"
+// displayLoaded displays the loaded components by order of precedence
+func displayLoaded() {
+	envFile, _ := sympi.GetEnvFile()
+	stack := sympi.GetLoaded(envFile)
+	if len(stack) == 0 {
+		fmt.Println("Nothing is loaded")
+		return
 	}
-	return baseDir
-}
-
-func getLoadedSingularity() string {
-	curPath := os.Getenv("PATH")
-	pathTokens := strings.Split(curPath, ":")
-	for _, t := range pathTokens {
-		if strings.Contains(t, sys.SingularityInstallDirPrefix) {
-			baseDir := getSyMPIBaseDir()
-			t = strings.Replace(t, baseDir, "", -1)
-			t = strings.Replace(t, sys.SingularityInstallDirPrefix, "", -1)
-			t = strings.Replace(t, "/bin", "", -1)
-			return strings.Replace(t, "-", ":", -1)
-		}
+	fmt.Printf("%-12s%-10s%s\n", "Precedence", "Role", "Component")
+	for i, c := range stack {
+		fmt.Printf("%-12d%-10s%s\n", i+1, c.Role, c.Name)
 	}
-
-	return ""
 }
 
-func getLoadedMPI() string {
-	curPath := os.Getenv("PATH")
-	pathTokens := strings.Split(curPath, ":")
-	for _, t := range pathTokens {
-		if strings.Contains(t, sys.MPIInstallDirPrefix) {
-			baseDir := getSyMPIBaseDir()
-			t = strings.Replace(t, baseDir, "", -1)
-			t = strings.Replace(t, sys.MPIInstallDirPrefix, "", -1)
-			t = strings.Replace(t, "/bin", "", -1)
-			return strings.Replace(t, "-", ":", -1)
-		}
+func listAvail(sysCfg *sys.Config, online bool, addVersions bool) error {
+	fmt.Println("The following versions of Singularity can be installed:")
+	cfgFile := filepath.Join(sysCfg.EtcDir, "sympi_singularity.conf")
+	kvs, err := configparser.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration from %s: %s", cfgFile, err)
 	}
-
-	return ""
-}
-
-func loadSingularity(id string) error {
-	// We can change the env multiple times during the execution of a single command
-	// and these modifications will NOT be reflected in the actual environment until
-	// we exit the command and let bash do some magic to update it. Fortunately, we
-	// know that we can have one and only one Singularity in the environment of a
-	// single time so when we load a specific version of Singularity, we make sure
-	// that we remove a previous load changes.
-	cleanedPath, cleanedLDLIB := sympi.GetCleanedUpSyEnvVars()
-
-	ver := getSyDetails(id)
-	if ver == "" {
-		fmt.Println("invalid installation of MPI, execute 'sympi -list' to get the list of available installations")
-		return nil
+	for _, e := range kvs {
+		fmt.Printf("\tsingularity:%s\n", e.Key)
 	}
 
-	sympiDir := sys.GetSympiDir()
-	syBaseDir := filepath.Join(sympiDir, sys.SingularityInstallDirPrefix+ver)
-	syBinDir := filepath.Join(syBaseDir, "bin")
-	syLibDir := filepath.Join(syBaseDir, "lib")
-
-	path := strings.Join(cleanedPath, ":")
-	ldlib := strings.Join(cleanedLDLIB, ":")
-	path = syBinDir + ":" + path
-	ldlib = syLibDir + ":" + ldlib
-
-	file, err := sympi.GetEnvFile()
-	if err != nil || !util.FileExists(file) {
-		return fmt.Errorf("file %s does not exist", file)
+	for _, id := range mpiplugin.List() {
+		kvs, err = mpiplugin.Get(id).Versions(sysCfg)
+		if err != nil {
+			log.Printf("[WARN] %s\n", err)
+			continue
+		}
+		fmt.Printf("The following versions of %s can be installed:\n", id)
+		for _, e := range kvs {
+			fmt.Printf("\t%s:%s\n", id, e.Key)
+		}
 	}
 
-	err = sympi.UpdateEnvFile(file, path, ldlib)
-	if err != nil {
-		return fmt.Errorf("failed to update %s: %s", file, err)
+	if online {
+		return listUpstreamReleases(sysCfg, addVersions)
 	}
-
 	return nil
 }
 
-func updateEnv(newPath []string, newLDLIB []string) error {
-	// Sanity checks
-	if len(newPath) == 0 {
-		return fmt.Errorf("new PATH is empty")
-	}
+// listUpstreamReleases lists the versions published upstream that are newer than the versions of
+// the configuration files and, when requested, adds them to the configuration files
+func listUpstreamReleases(sysCfg *sys.Config, addVersions bool) error {
+	hasNew := false
+	for _, feed := range sympi.GetUpstreamFeeds() {
+		releases, err := feed.Fetch()
+		if err != nil {
+			// Other feeds may still be available
+			fmt.Printf("[WARN] %s\n", err)
+			continue
+		}
+		if feed.ConfigFile == "" {
+			if len(releases) > 0 {
+				fmt.Printf("The latest version of %s is %s (it cannot be installed by SyMPI)\n", feed.ID, releases[0].Version)
+			}
+			continue
+		}
 
-	file, err := sympi.GetEnvFile()
-	if err != nil || !util.FileExists(file) {
-		return fmt.Errorf("file %s does not exist", file)
+		cfgFile := filepath.Join(sysCfg.EtcDir, feed.ConfigFile)
+		newReleases, err := sympi.GetNewReleases(releases, cfgFile)
+		if err != nil {
+			return err
+		}
+		if len(newReleases) == 0 {
+			fmt.Printf("No new version of %s upstream\n", feed.ID)
+			continue
+		}
+		hasNew = true
+		fmt.Printf("The following new versions of %s are available upstream:\n", feed.ID)
+		for _, r := range newReleases {
+			fmt.Printf("\t%s:%s\t%s\n", feed.ID, r.Version, r.URL)
+		}
+		if addVersions {
+			err = sympi.AppendReleases(cfgFile, newReleases)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("-> %d version(s) added to %s\n", len(newReleases), cfgFile)
+		}
 	}
-	err = sympi.UpdateEnvFile(file, strings.Join(newPath, ":"), strings.Join(newLDLIB, ":"))
-	if err != nil {
-		return fmt.Errorf("failed to update %s: %s", file, err)
+	if hasNew && !addVersions {
+		fmt.Println("Execute 'sympi -avail -online -add-versions' to make the new versions available for installation")
 	}
-
 	return nil
 }
 
-func unloadSingularity() error {
-	newPath, newLDLIB := sympi.GetCleanedUpSyEnvVars()
-
-	return updateEnv(newPath, newLDLIB)
-}
-
-func unloadMPI() error {
-	newPath, newLDLIB := sympi.GetCleanedUpMPIEnvVars()
-
-	return updateEnv(newPath, newLDLIB)
-}
-
-func uninstallMPIfromHost(mpiDesc string, sysCfg *sys.Config) error {
-	var mpiCfg implem.Info
-	mpiCfg.ID, mpiCfg.Version = sympi.GetMPIDetails(mpiDesc)
-
-	var buildEnv buildenv.Info
-	err := buildenv.CreateDefaultHostEnvCfg(&buildEnv, &mpiCfg, sysCfg)
-	if err != nil {
-		return fmt.Errorf("failed to set host build environment: %s", err)
+// getErrorHint returns a suggestion to fix a failure based on the class of the error
+func getErrorHint(err error) string {
+	switch {
+	case errors.Is(err, sympierr.ErrDownloadFailed):
+		return "check the URL of the software and the proxy settings"
+	case errors.Is(err, sympierr.ErrTimeout):
+		return "the command did not complete in time, the system may be overloaded"
+	case errors.Is(err, sympierr.ErrConfigureFailed):
+		return "check that all the prerequisites are installed with 'sympi -config'"
 	}
-
-	b, err := builder.Load(&mpiCfg)
-	if err != nil {
-		return fmt.Errorf("failed to load a builder: %s", err)
-	}
-
-	execRes := b.UninstallHost(&mpiCfg, &buildEnv, sysCfg)
-	if execRes.Err != nil {
-		return fmt.Errorf("failed to install MPI on the host: %s", execRes.Err)
-	}
-
-	return nil
+	return ""
 }
 
-func parseSingularityInstallParams(params []string, sysCfg *sys.Config) error {
-	for _, p := range params {
-		switch p {
-		case "no-suid":
-			sysCfg.Nopriv = true
-			sysCfg.SudoSyCmds = []string{}
-		}
+// fatalWithHint terminates after displaying an error and, when available, a suggestion to fix it
+func fatalWithHint(err error, format string, a ...interface{}) {
+	hint := getErrorHint(err)
+	if hint != "" {
+		format += " (hint: " + hint + ")"
 	}
-
-	return nil
+	log.Fatalf(format, a...)
 }
 
-func installSingularity(id string, params []string, sysCfg *sys.Config) error {
-	// We create a new sysCfg structure just for this command since we may have passed
-	// installation parameters that will change the behavior extracted from the configuration
-	// file.
-	var mySysCfg sys.Config
-	mySysCfg = *sysCfg
-	err := parseSingularityInstallParams(params, &mySysCfg)
+func importContainerImg(imgPath string, sysCfg *sys.Config) error {
+	imgName, err := sympi.GetExportedImageName(imgPath)
 	if err != nil {
-		return fmt.Errorf("failed to parse Singularity installation parameters: %s", err)
+		return err
 	}
-
-	kvs, err := sy.LoadSingularityReleaseConf(&mySysCfg)
+	targetDir := filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+strings.Replace(imgName, ".sif", "", -1))
+	err = os.MkdirAll(targetDir, 0755)
 	if err != nil {
-		return fmt.Errorf("failed to load data about Singularity releases: %s", err)
+		return fmt.Errorf("unable to create %s: %s", targetDir, err)
 	}
-
-	var sy implem.Info
-	sy.ID = implem.SY
-	tokens := strings.Split(id, ":")
-	if len(tokens) != 2 {
-		return fmt.Errorf("%s had an invalid format, it should of the form 'singularity:<version>'", id)
+	targetFile := filepath.Join(targetDir, imgName)
+	if util.FileExists(targetFile) {
+		return sympierr.Wrap(sympierr.ErrImageExists, nil, "%s", targetFile)
 	}
 
-	sy.Version = tokens[1]
-	sy.URL = kv.GetValue(kvs, sy.Version)
-
-	b, err := builder.Load(&sy)
+	// Exported images are reassembled and decompressed directly in the proper directory under
+	// SyMPI, other images are copied there
+	_, err = sympi.ImportExportedImage(imgPath, targetDir)
 	if err != nil {
-		return fmt.Errorf("failed to load a builder: %s", err)
+		os.Remove(targetDir)
+		return fmt.Errorf("failed to import %s: %s", imgPath, err)
 	}
-	if !mySysCfg.Nopriv {
-		b.PrivInstall = true
-	}
-
-	var buildEnv buildenv.Info
-	buildEnv.InstallDir = filepath.Join(sys.GetSympiDir(), sys.SingularityInstallDirPrefix+sy.Version)
-	buildEnv.ScratchDir = filepath.Join(sys.GetSympiDir(), sys.SingularityScratchDirPrefix+sy.Version)
 
-	// Building any version of Singularity, even if limiting ourselves to Singularity >= 3.0.0, in
-	// a generic way is not trivial, the installation procedure changed quite a bit over time. The
-	// best option at the moment is to assume that Singularity is simply a standard Go software
-	// with all the associated requirements, e.g., to be built from:
-	//   GOPATH/src/github.com/sylab/singularity
-	buildEnv.BuildDir = filepath.Join(sys.GetSympiDir(), sys.SingularityBuildDirPrefix+sy.Version, "src", "github.com", "sylabs")
-	err = util.DirInit(buildEnv.ScratchDir)
+	// Check the architecture of the container, if does not match, remove it and error out
+	arch, err := sy.GetSIFArchs(targetFile, sysCfg)
 	if err != nil {
-		return fmt.Errorf("failed to initialize %s: %s", buildEnv.ScratchDir, err)
+		err = fmt.Errorf("failed to extract architecture from %s: %s", imgPath, err)
+	} else if !sys.CompatibleArch(arch) {
+		err = sympierr.Wrap(sympierr.ErrIncompatibleArch, nil, "%s's architecture (%s) is incompatible with host", imgPath, arch)
 	}
-	defer os.RemoveAll(buildEnv.ScratchDir)
-	err = util.DirInit(buildEnv.BuildDir)
 	if err != nil {
-		return fmt.Errorf("failed to initializat %s: %s", buildEnv.BuildDir, err)
-	}
-	defer os.RemoveAll(buildEnv.BuildDir)
-
-	execRes := b.InstallOnHost(&sy, &buildEnv, &mySysCfg)
-	if execRes.Err != nil {
-		return fmt.Errorf("failed to install %s: %s", id, execRes.Err)
+		os.Remove(targetFile)
+		os.Remove(targetDir)
+		return err
 	}
 
-	// Create manifest for the Singularity binary
-	syBin := filepath.Join(buildEnv.InstallDir, "bin", "singularity")
-	manifestPath := filepath.Join(buildEnv.InstallDir, "singularity.MANIFEST")
-	hashes := manifest.HashFiles([]string{syBin})
-	err = manifest.Create(manifestPath, hashes)
+	// The MPI of images created by SyMPI is recorded in their metadata, the container is then
+	// linked to the MPI it requires on the host
+	containerInfo, containerMPI, err := container.GetMetadata(targetFile, sysCfg)
 	if err != nil {
-		// This is not an error, we just log the error
-		log.Printf("failed to create the MANIFEST for %s\n", id)
+		log.Printf("[WARN] failed to get the metadata of %s: %s", targetFile, err)
+	} else if containerMPI.ID != "" {
+		name := strings.TrimPrefix(filepath.Base(targetDir), sys.ContainerInstallDirPrefix)
+		err = container.RecordMPILink(container.NewMPILink(name, &containerInfo, &containerMPI))
+		if err != nil {
+			log.Printf("[WARN] failed to link %s to the MPI of the host: %s", name, err)
+		}
 	}
 
 	return nil
 }
 
-func listAvail(sysCfg *sys.Config) error {
-	fmt.Println("The following versions of Singularity can be installed:")
-	cfgFile := filepath.Join(sysCfg.EtcDir, "sympi_singularity.conf")
-	kvs, err := kv.LoadKeyValueConfig(cfgFile)
-	if err != nil {
-		return fmt.Errorf("failed to load configuration from %s: %s", cfgFile, err)
-	}
-	for _, e := range kvs {
-		fmt.Printf("\tsingularity:%s\n", e.Key)
-	}
-
-	fmt.Println("The following versions of Open MPI can be installed:")
-	cfgFile = filepath.Join(sysCfg.EtcDir, sys.GetMPIConfigFileName("openmpi"))
-	kvs, err = kv.LoadKeyValueConfig(cfgFile)
+func exportContainerImg(containerID string, opts *sympi.ExportOptions) string {
+	// Copy the image to the export directory, compressed and split if requested
+	targetPath, err := sympi.ExportContainer(containerID, opts)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration from %s: %s", cfgFile, err)
-	}
-	for _, e := range kvs {
-		fmt.Printf("\topenmpi:%s\n", e.Key)
-	}
-
-	fmt.Println("The following versions of MPICH can be installed:")
-	cfgFile = filepath.Join(sysCfg.EtcDir, sys.GetMPIConfigFileName("mpich"))
-	kvs, err = kv.LoadKeyValueConfig(cfgFile)
-	if err != nil {
-		return fmt.Errorf("failed to load configuration from %s: %s", cfgFile, err)
-	}
-	for _, e := range kvs {
-		fmt.Printf("\tmpich:%s\n", e.Key)
+		log.Printf("failed to export container %s to %s: %s", containerID, opts.Dir, err)
+		return ""
 	}
 
-	return nil
+	// Return the path
+	return targetPath
 }
 
-func importContainerImg(imgPath string, sysCfg *sys.Config) error {
-	// Check the architecture of the container, if does not match, error out
-	arch, err := sy.GetSIFArchs(imgPath, sysCfg)
-	if err != nil {
-		return fmt.Errorf("failed to extract architecture from %s: %s", imgPath, err)
+// manageWorkspaces creates, deletes and lists the workspaces
+func manageWorkspaces(list bool, create string, del string) error {
+	if create != "" {
+		dir, err := sympi.CreateWorkspace(create)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Workspace %s created in %s, use it with 'sympi -workspace %s' or 'sympi_init %s'\n", create, dir, create, create)
 	}
 
-	if !sys.CompatibleArch(arch) {
-		return fmt.Errorf("%s's architecture is incompatible with host", imgPath)
+	if del != "" {
+		err := sympi.DeleteWorkspace(del)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Workspace %s deleted\n", del)
 	}
 
-	// Copy the image in the proper directory under SyMPI
-	imgName := filepath.Base(imgPath)
-	targetDir := filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+strings.Replace(imgName, ".sif", "", -1))
-	err = os.MkdirAll(targetDir, 0755)
-	if err != nil {
-		return fmt.Errorf("unable to create %s: %s", targetDir, err)
-	}
-	targetFile := filepath.Join(targetDir, imgName)
-	err = util.CopyFile(imgPath, targetFile)
-	if err != nil {
-		return fmt.Errorf("unable to copy %s to %s: %s", imgPath, targetDir, err)
+	if list {
+		workspaces, err := sympi.ListWorkspaces()
+		if err != nil {
+			return err
+		}
+		for _, w := range workspaces {
+			current := " "
+			if w == sys.GetWorkspace() {
+				current = "*"
+			}
+			fmt.Printf("%s %s\t%s\n", current, w, sys.GetWorkspaceDir(w))
+		}
 	}
 
 	return nil
 }
 
-func exportContainerImg(containerID string) string {
-	// Figure out the path to the image
-	imgStoredPath := filepath.Join(getSyMPIBaseDir(), sys.ContainerInstallDirPrefix+containerID, containerID+".sif")
-	if !util.FileExists(imgStoredPath) {
-		log.Printf("%s does not exist", imgStoredPath)
-		return ""
-	}
+// runSubcommand is the subcommand equivalent to the -run option
+const runSubcommand = "run"
 
-	// Copy the image to /tmp
-	targetPath := filepath.Join("/tmp", containerID+".sif")
-	err := util.CopyFile(imgStoredPath, targetPath)
-	if err != nil {
-		log.Printf("failed to copy image from %s to %s: %s", imgStoredPath, targetPath, err)
-		return ""
+// expandRunSubcommand replaces the run subcommand of a command line, i.e.,
+// 'sympi run <container> [-app <application>] [-- <arguments>]', by the equivalent -run option so
+// both forms are parsed the same way
+func expandRunSubcommand(args []string) []string {
+	if len(args) < 3 || args[1] != runSubcommand {
+		return args
 	}
-
-	// Return the path
-	return targetPath
+	return append([]string{args[0], "-" + runSubcommand, args[2]}, args[3:]...)
 }
 
 func main() {
@@ -451,16 +418,82 @@ func main() {
 	debug := flag.Bool("d", false, "Enable debug mode")
 	list := flag.Bool("list", false, "List all MPIs and Singularity versions on the host, and all MPI containers. 'singularity', 'mpi' and 'container' can be used as filters.")
 	load := flag.String("load", "", "The version of MPI/Singularity installed on the host to load")
-	unload := flag.String("unload", "", "Unload current version of MPI/Singularity that is used, e.g., sympi -unload [mpi|singularity]")
+	role := flag.String("role", sympi.RoleAll, "Role of the MPI loaded with -load: compile (compiler wrappers), run (mpirun and libraries) or all, e.g., sympi -load mpich:3.3.2 -role compile")
+	unload := flag.String("unload", "", "Unload current version of MPI/Singularity that is used, e.g., sympi -unload [mpi|singularity|compile|run|<name>]")
+	loaded := flag.Bool("loaded", false, "Display the loaded components with their role, by order of precedence")
+	buildProfile := flag.String("build-profile", "", "With -install, build profile of the tool's configuration file selecting the toolchain building MPI, overwriting the 'build_profile' key of the configuration file, e.g., -build-profile nvidia")
 	install := flag.String("install", "", "MPI/Singularity to install, e.g., openmpi:4.0.2 or singularity:master; for Singularity, the option -no-suid can also be used.")
 	nosetuid := flag.Bool("no-suid", false, "When and only when installing Singularity, you may use the -no-suid flag to ensure a full userspace installation")
 	uninstall := flag.String("uninstall", "", "MPI implementation to uninstall, e.g., openmpi:4.0.2")
-	run := flag.String("run", "", "Run a container")
+	force := flag.Bool("force", false, "With -uninstall, uninstall MPI even when it is loaded in a running shell or used by containers of the workspace")
+	run := flag.String("run", "", "Run a container, the arguments of the application following '--', e.g., -run <container> [-app <application>] -- <arguments>; 'sympi run <container> ...' is equivalent")
+	repeatExact := flag.String("repeat-exact", "", "Execute again a run recorded in a summary, e.g., the summary of a reproducibility bundle, with identical parameters (container, arguments, MPI on the host and benchmark settings, including the seed), e.g., -repeat-exact <path/to/summary.json> [<experiment>]")
+	appName := flag.String("app", "", "When running a multi-app container, name of the application to execute, e.g., -run <container> -app <application>; also used with -deps")
+	probe := flag.String("probe", "", "Check whether a container is expected to run with the MPI installed on the host, without running its application, e.g., -probe <container>")
+	planExps := flag.String("plan", "", "Display the resolved list of the experiments of a configuration file or of a preset, in the order they are executed, without executing anything (dry run), e.g., -plan experiments.conf or -plan openmpi-4-series")
+	includeExps := flag.String("include", "", "With -plan, only keep the experiments matching filters separated by ';', e.g., -include 'host>=4.0;upper-triangular'")
+	excludeExps := flag.String("exclude", "", "With -plan, remove the experiments matching filters separated by ';', e.g., -exclude same-version")
+	sampleExps := flag.String("sample", "", "With -plan, sampling strategy replacing the one of the configuration file, e.g., -sample diagonal or -sample random:10:42")
+	diffImages := flag.Bool("diff", false, "Compare two images, e.g., two builds of the same container: their labels, environment, MPI, packages and the files of the MPI installation, e.g., -diff <imageA> <imageB>; the images are containers of the workspace or paths")
+	depsTarget := flag.String("deps", "", "Report the shared libraries a binary of the host or the application of a container depends on, whether they are satisfied by the container or the host, and which ones are missing, e.g., -deps <container> or -deps <path/to/binary>")
+	bundle := flag.String("bundle", "", "When running a container, export everything needed to reproduce the run (configuration, definition files, manifests, host details, command lines, environment and results) into a directory or a tarball, e.g., -run <container> -bundle <path/to/bundle.tar.gz>")
 	avail := flag.Bool("avail", false, "List all available versions of MPI implementations and Singularity that can be installed on the host")
-	config := flag.Bool("config", false, "Check and configure the system for SyMPI")
-	importCmd := flag.String("import", "", "Import an existing image into SyMPI, e.g., -import <path/to/image>")
+	online := flag.Bool("online", false, "With -avail, also query the upstream release feeds of Open MPI, MPICH, Singularity and Apptainer for versions newer than the ones of the configuration files; set GITHUB_TOKEN to avoid the rate limit of the GitHub API")
+	addVersions := flag.Bool("add-versions", false, "With -avail -online, add the new upstream versions to the configuration files so they can be installed")
+	config := flag.Bool("config", false, "Check and configure the system for SyMPI; 'sympi -config paths' displays the directories used by SyMPI and where they come from, 'sympi -config show' the tool's configuration, 'sympi -config history' its changes, 'sympi -config set <key> <value>' changes it, e.g., sympi -config set sudo_build never, and 'sympi -config revert <change>' restores it as it was before a change")
+	importCmd := flag.String("import", "", "Import an existing image into SyMPI, e.g., -import <path/to/image>; images exported compressed or split are reassembled and verified from their manifest, e.g., -import <path/to/image.export.json>")
 	export := flag.String("export", "", "Export a container image")
-
+	exportDir := flag.String("export-dir", "/tmp", "Directory where the container image is exported, e.g., -export <container> -export-dir <path/to/dir>")
+	compress := flag.String("compress", "", "Compress the exported container image with gz or zstd, e.g., -export <container> -compress zstd")
+	exportChunkSize := flag.Int64("export-chunk-size", 0, "Split the exported container image into files whose size in MB is smaller than the specified value, with a manifest to verify them when imported (0 disables the splitting)")
+	cleanupEnv := flag.Bool("cleanup-env", false, "Remove the environment files of terminated SyMPI shells")
+	gc := flag.Bool("gc", false, "Remove the scratch directories abandoned by interrupted installations, i.e., the ones that are not used by a running SyMPI and were not modified for 'scratch_max_age' (24h by default)")
+	remoteExec := flag.Bool("remote", false, "Execute the command on the remote host defined in the remote section of the tool's configuration file, e.g., 'sympi -remote -install openmpi:4.0.2'; the results are copied back in the current directory")
+	doctor := flag.Bool("doctor", false, "Diagnose the system, the workspace and the environment of SyMPI and display the problems with suggested fixes, the most severe first")
+	fix := flag.Bool("fix", false, "With -doctor, automatically perform the safe repairs, e.g., removal of orphaned scratch directories")
+	installDeps := flag.Bool("install-deps", false, "Install the host dependencies of SyMPI that are missing with the package manager of the Linux distribution (apt, dnf or zypper) and sudo")
+	dryRun := flag.Bool("dry-run", false, "With -install-deps, only display the commands that would be executed")
+	keepScratch := flag.Bool("keep-scratch", false, "Keep the scratch and build directories when an installation fails")
+	cleanupPolicy := flag.String("cleanup", "", "What to do with the resources once they are not needed anymore: always, on-success or never for all of them, or a comma-separated list of <resource>=<policy> where resource is host-mpi, build, scratch or image, e.g., -cleanup build=on-success,image=never")
+	artifactsMaxSize := flag.Int64("artifacts-max-size", 0, "When running a container fails, archive the build and scratch directories in the errors directory if their size in MB is smaller than the specified value (0 disables the archiving)")
+	wrapper := flag.String("wrapper", "", "When running a container, execute each rank under a wrapper: valgrind, strace, perf ('perf stat') or a custom command where #OUTDIR is replaced by the directory saving its output files, e.g., -wrapper \"ltrace -f -o #OUTDIR/ltrace.txt\"")
+	features := flag.Bool("features", false, "When running a container, also execute the MPI feature probes (one-sided communications, derived datatypes, MPI-IO and threads) once its application succeeded and report which features work, e.g., -run <container> -features; the 'feature_probes' key of the configuration file enables them for all the experiments")
+	checkpointDelay := flag.Duration("checkpoint", 0, "When running a container including a checkpointing tool (DMTCP or MANA), checkpoint the job after the specified time, terminate it and restart it from its checkpoint, e.g., -checkpoint 30s")
+	glibcSkewPolicy := flag.String("glibc-skew-policy", "", "When running a container, compare the versions of glibc on the host and in the container and, when they differ by more than -glibc-max-skew minor versions, 'warn' or 'skip' the execution")
+	glibcMaxSkew := flag.Int("glibc-max-skew", 0, "Maximum number of minor versions between the glibc of the host and of the container with -glibc-skew-policy, e.g., 2.31 and 2.27 differ by 4")
+	launcherTmpl := flag.String("launcher", "", "Template of the command used to start MPI jobs, overwriting the 'launcher' key of the configuration file, e.g., -launcher \"mpiexec.hydra -n {np} {cmd}\"")
+	hostfile := flag.String("hostfile", "", "Hostfile of the MPI jobs, overwriting the 'hostfile' key of the configuration file, e.g., -run <container> -hostfile <path/to/hostfile>; passed with the option of the launcher of the MPI implementation (-hostfile, -f or -machinefile)")
+	rankfile := flag.String("rankfile", "", "Rankfile of the MPI jobs, overwriting the 'rankfile' key of the configuration file, e.g., -run <container> -rankfile <path/to/rankfile>; only supported by Open MPI (-rf, or --map-by rankfile:file=<path> from Open MPI 5)")
+	downloadRateLimit := flag.String("download-rate-limit", "", "Maximum bandwidth used to download software, overwriting the 'download_rate_limit' key of the configuration file, e.g., -download-rate-limit 10m")
+	ifnet := flag.String("ifnet", "", "Network interface used by MPI, overwriting the 'ifnet' key of the configuration file and the detected interface, e.g., -ifnet eth0")
+	exportTests := flag.String("export-tests", "", "Write the sources of the MPI tests embedded in SyMPI in a directory, e.g., -export-tests <path/to/dir>")
+	completion := flag.String("completion", "", "Display the completion script for a shell, e.g., 'source <(sympi -completion bash)'; bash and zsh are supported")
+	completionWords := flag.String("completion-words", "", "Display the possible values of an option, used by the completion scripts, e.g., -completion-words -install")
+	shellHook := flag.String("shell-hook", "", "Display the initialization script of the shells started by sympi_init for bash or zsh")
+	exportMPI := flag.String("export-mpi", "", "Create a tarball of an installation of MPI with its relocation metadata in the current directory, e.g., -export-mpi openmpi:4.0.2")
+	importMPI := flag.String("import-mpi", "", "Import a tarball created with -export-mpi in the workspace and relocate the installation of MPI, e.g., -import-mpi <path/to/mpi_install_openmpi-4.0.2.tar.gz>")
+	relocate := flag.Bool("relocate", false, "Update the installations of MPI of the workspace after the workspace was moved")
+	workspace := flag.String("workspace", "", "Name of the workspace to use, overwriting the SYMPI_WORKSPACE environment variable, e.g., -workspace gcc9")
+	listWorkspaces := flag.Bool("list-workspaces", false, "List all the workspaces, the current one being marked with '*'")
+	createWorkspace := flag.String("create-workspace", "", "Create a new named workspace with its own installations, containers and configuration files, e.g., -create-workspace gcc9")
+	deleteWorkspace := flag.String("delete-workspace", "", "Delete a named workspace and everything it contains, e.g., -delete-workspace gcc9")
+	showLedger := flag.String("show-ledger", "", "Display the commands recorded in a ledger, i.e., everything SyMPI executed during a run: the path to a ledger or 'last' for the most recent one of the workspace")
+	builderImage := flag.String("builder", "", "Build the software for the host, e.g., MPI, and execute mpirun in a builder container instead of the host, overwriting the 'builder_image' key of the configuration file, e.g., -builder <path/to/builder.sif>; the compilers and libraries of MPI are then only required in the builder image, the sources still being downloaded on the host")
+	sandboxEnv := flag.Bool("sandbox-env", false, "Execute all the build and launch commands with a minimal environment instead of the environment of the host, overwriting the 'sandbox_env' key of the configuration file; the environment of each command is recorded in the ledger")
+	envAllowlist := flag.String("env-allowlist", "", "With -sandbox-env, comma-separated list of the host environment variables passed to the commands, e.g., -env-allowlist http_proxy,https_proxy")
+	proxy := flag.String("proxy", "", "Proxy of all the network operations (downloads, Git checkouts, registries), overwriting the 'proxy' key of the configuration file, e.g., -proxy http://proxy.example.com:3128")
+	noProxy := flag.String("no-proxy", "", "Comma-separated list of the hosts reached without proxy, overwriting the 'no_proxy' key of the configuration file, e.g., -no-proxy localhost,.example.com")
+	caFile := flag.String("ca-file", "", "Bundle of PEM certificates of extra CAs trusted by the network operations, overwriting the 'ca_file' key of the configuration file, e.g., -ca-file /etc/pki/corporate-ca.pem")
+	convertConfig := flag.String("convert-config", "", "Convert a key=value configuration file into the equivalent YAML file, e.g., -convert-config <path/to/file.conf>")
+	checkConfig := flag.String("check-config", "", "Check a configuration file (tool, versions, registry, network, application or experiments) and report all the problems found, e.g., -check-config <path/to/file.conf>")
+	tui := flag.Bool("tui", false, "Start an interactive terminal UI to browse the installed MPIs and containers, the recent runs, the compatibility matrices of the current directory and the failures, and to run, delete or export containers")
+	runtimeMode := flag.String("runtime-mode", "", "Runtime mode of Singularity used to execute the containers, overwriting the 'runtime_mode' key of the configuration file: native, oci (singularity exec --oci, Singularity 4.0 or later) or both to validate a container in the two modes, e.g., -run <container> -runtime-mode both")
+	analyse := flag.String("analyse", "", "Analyse the results of the experiments of a MPI implementation in the current directory, or of a result file or compatibility matrix: display the pass-rate matrix, the asymmetric failures and, with -baseline, the regressions, e.g., -analyse openmpi -baseline <path/to/previous_compatibility_matrix.txt>")
+	baseline := flag.String("baseline", "", "With -analyse, result file or compatibility matrix of a previous execution used to detect the regressions; sympi exits with an error when regressions are found")
+	eventsTarget := flag.String("events", "", "Emit the progress events (experiment started, phase completed, build failed, run passed or failed) as JSON lines to a file or a file descriptor, e.g., -events events.jsonl or -events fd:3")
+	checkURLs := flag.Bool("check-urls", false, "With -check-config, also check whether the URLs of the source code are reachable")
+
+	os.Args = expandRunSubcommand(os.Args)
 	flag.Parse()
 
 	// Initialize the log file. Log messages will both appear on stdout and the log file if the verbose option is used
@@ -473,44 +506,435 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
+	if *completion != "" || *shellHook != "" {
+		bin, err := os.Executable()
+		if err != nil {
+			log.Fatalf("cannot detect the path to sympi: %s", err)
+		}
+		var script string
+		if *completion != "" {
+			var options []string
+			flag.VisitAll(func(f *flag.Flag) {
+				options = append(options, "-"+f.Name)
+			})
+			options = append(options, runSubcommand)
+			script, err = sympi.GetCompletionScript(*completion, bin, options)
+		} else {
+			script, err = sympi.GetShellHook(*shellHook, bin)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(script)
+		os.Exit(0)
+	}
+
+	// The workspace must be selected before anything uses the sympi directory
+	if os.Getenv(sys.SYMPI_WORKSPACE_ENV) != "" {
+		err := sympi.ValidateWorkspaceName(os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+		if err != nil {
+			fmt.Printf("invalid %s: %s\n", sys.SYMPI_WORKSPACE_ENV, err)
+			os.Exit(1)
+		}
+	}
+	if *workspace != "" {
+		err := sympi.SetWorkspace(*workspace)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *listWorkspaces || *createWorkspace != "" || *deleteWorkspace != "" {
+		err := manageWorkspaces(*listWorkspaces, *createWorkspace, *deleteWorkspace)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	ledgerDir := filepath.Join(sys.GetSympiDir(), syexec.LedgerDirName)
+	if *showLedger != "" {
+		path := *showLedger
+		if path == "last" {
+			var err error
+			path, err = syexec.FindLatestLedger(ledgerDir)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		entries, err := syexec.LoadLedger(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Ledger %s\n%s", path, syexec.FormatLedger(entries))
+		os.Exit(0)
+	}
+
+	// From now, all the commands executed are recorded in the ledger of the run
+	syexec.SetLedger(syexec.NewLedgerPath(ledgerDir))
+
+	if *eventsTarget != "" {
+		err := events.Open(*eventsTarget)
+		if err != nil {
+			log.Fatalf("cannot emit the events: %s", err)
+		}
+		defer events.Close()
+	}
+
+	if *remoteExec {
+		kvs, err := configparser.Load(sy.GetPathToSyMPIConfigFile())
+		if err != nil {
+			log.Fatalf("cannot load the tool's configuration file: %s", err)
+		}
+		remoteCfg := remote.LoadConfig(kvs)
+		if remoteCfg == nil {
+			fmt.Printf("No remote host is defined, set %s in %s\n", remote.HostKey, sy.GetPathToSyMPIConfigFile())
+			os.Exit(1)
+		}
+		etc, err := sys.FindEtcDir()
+		if err != nil {
+			log.Fatalf("cannot find the etc directory: %s", err)
+		}
+		var args []string
+		for _, a := range os.Args[1:] {
+			if a != "-remote" && a != "--remote" {
+				args = append(args, a)
+			}
+		}
+		err = remote.Execute(remoteCfg, etc.Path, "sympi", args, ".")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// The doctor runs before loading the configuration and any automatic cleanup so it reports
+	// everything it finds, even when the configuration cannot be loaded
+	if *doctor {
+		report := sympi.Doctor()
+		if *fix {
+			repaired, err := report.Fix()
+			fmt.Printf("%d problem(s) repaired\n", repaired)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+		fmt.Print(report.String())
+		for _, p := range report.Problems {
+			if p.Severity == sympi.SeverityError {
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}
+
+	// Installing packages requires sudo, it is therefore only done when explicitly requested
+	if *installDeps {
+		report := checker.RunSystemChecks()
+		cmds, err := checker.InstallDeps(report, *dryRun)
+		if len(cmds) == 0 && err == nil {
+			fmt.Println("Nothing to install")
+		}
+		if *dryRun {
+			for _, c := range cmds {
+				fmt.Println(c)
+			}
+		}
+		if err != nil {
+			fmt.Printf("failed to install dependencies: %s\n", err)
+			os.Exit(1)
+		}
+		if !*dryRun && len(cmds) > 0 {
+			fmt.Printf("System configuration:\n%s", checker.RunSystemChecks().String())
+		}
+		os.Exit(0)
+	}
+
 	sysCfg := sympi.GetDefaultSysConfig()
 	sysCfg.Verbose = *verbose
 	sysCfg.Debug = *debug
+	sysCfg.KeepScratch = *keepScratch
+	cleanup, err := sys.ParseCleanupPolicy(*cleanupPolicy)
+	if err != nil {
+		log.Fatalf("invalid cleanup policy: %s", err)
+	}
+	sysCfg.Cleanup = cleanup
+	sysCfg.ArtifactsMaxSize = *artifactsMaxSize * 1024 * 1024
+	sysCfg.Wrapper = *wrapper
+	sysCfg.CheckpointDelay = *checkpointDelay
+	if *features {
+		sysCfg.FeatureProbes = true
+	}
+	if *glibcSkewPolicy != "" {
+		err := launcher.ValidateGlibcSkewPolicy(*glibcSkewPolicy)
+		if err != nil {
+			log.Fatalf("invalid glibc skew policy: %s", err)
+		}
+		sysCfg.GlibcSkewPolicy = *glibcSkewPolicy
+		sysCfg.GlibcMaxSkew = *glibcMaxSkew
+	}
+	if *launcherTmpl != "" {
+		err := mpi.ValidateLaunchTemplate(*launcherTmpl)
+		if err != nil {
+			log.Fatalf("invalid launcher: %s", err)
+		}
+		sysCfg.LaunchTemplate = *launcherTmpl
+	}
+	if *hostfile != "" {
+		err := mpi.ValidateHostfile(*hostfile)
+		if err != nil {
+			log.Fatalf("invalid hostfile: %s", err)
+		}
+		// The jobs are not necessarily started from the current directory
+		sysCfg.Hostfile, err = filepath.Abs(*hostfile)
+		if err != nil {
+			log.Fatalf("failed to get the absolute path of %s: %s", *hostfile, err)
+		}
+	}
+	if *rankfile != "" {
+		err := mpi.ValidateHostfile(*rankfile)
+		if err != nil {
+			log.Fatalf("invalid rankfile: %s", err)
+		}
+		// The jobs are not necessarily started from the current directory
+		sysCfg.Rankfile, err = filepath.Abs(*rankfile)
+		if err != nil {
+			log.Fatalf("failed to get the absolute path of %s: %s", *rankfile, err)
+		}
+	}
+	if *downloadRateLimit != "" {
+		err := buildenv.ValidateRateLimit(*downloadRateLimit)
+		if err != nil {
+			log.Fatalf("invalid download rate limit: %s", err)
+		}
+		sysCfg.DownloadRateLimit = *downloadRateLimit
+	}
+	if *ifnet != "" {
+		sysCfg.Ifnet = *ifnet
+	}
+	if *builderImage != "" {
+		err := container.ValidateBuilderImage(*builderImage)
+		if err != nil {
+			log.Fatalf("invalid builder image: %s", err)
+		}
+		sysCfg.BuilderImage = *builderImage
+	}
+	if *runtimeMode != "" && *runtimeMode != sys.RuntimeModeBoth {
+		err := sys.ValidateRuntimeMode(*runtimeMode)
+		if err != nil {
+			log.Fatalf("invalid runtime mode: %s", err)
+		}
+		sysCfg.RuntimeMode = *runtimeMode
+	}
+	if *sandboxEnv {
+		sysCfg.SandboxEnv = true
+	}
+	if *envAllowlist != "" {
+		sysCfg.EnvAllowlist = sys.ParseEnvAllowlist(*envAllowlist)
+	}
+	if *proxy != "" {
+		err := sys.ValidateProxy(*proxy)
+		if err != nil {
+			log.Fatalf("invalid proxy: %s", err)
+		}
+		sysCfg.Proxy = *proxy
+	}
+	if *noProxy != "" {
+		sysCfg.NoProxy = *noProxy
+	}
+	if *caFile != "" {
+		sysCfg.CAFile = *caFile
+	}
+	if *buildProfile != "" {
+		err = sysCfg.SelectBuildProfile(*buildProfile)
+		if err != nil {
+			log.Fatalf("invalid build profile: %s", err)
+		}
+	}
+	// From now, the network operations use the proxy and the CA bundle of the configuration
+	err = sys.SetNetwork(&sysCfg)
+	if err != nil {
+		log.Fatalf("invalid network configuration: %s", err)
+	}
+	// From now, the commands are executed with a minimal environment if requested and the builds
+	// with their resource limits
+	syexec.SetSandbox(sysCfg.SandboxEnv, append(sysCfg.EnvAllowlist, sys.GetNetworkEnvNames(&sysCfg)...))
+	syexec.SetLimits(launcher.GetBuildLimits(&sysCfg))
+	syexec.SetHeartbeat(sysCfg.Heartbeat)
+	if *config && flag.Arg(0) == "paths" {
+		displayPaths(&sysCfg)
+		os.Exit(0)
+	}
+	if *config && (flag.Arg(0) == "show" || flag.Arg(0) == "history" || flag.Arg(0) == "revert" || flag.Arg(0) == "set") {
+		var err error
+		switch flag.Arg(0) {
+		case "show":
+			err = displayConfig(&sysCfg)
+		case "history":
+			err = displayConfigHistory(&sysCfg)
+		case "set":
+			if flag.NArg() != 3 {
+				log.Fatalf("invalid arguments, e.g., sympi -config set %s %s", sy.GetSudoKey(sy.SudoBuild), sy.SudoNever)
+			}
+			err = sympi.SetToolConfig(flag.Arg(1), flag.Arg(2), &sysCfg)
+			if err == nil {
+				fmt.Printf("%s set to %s\n", flag.Arg(1), flag.Arg(2))
+			}
+		case "revert":
+			var id int
+			id, err = strconv.Atoi(flag.Arg(1))
+			if err != nil {
+				log.Fatalf("invalid change '%s', e.g., sympi -config revert 3", flag.Arg(1))
+			}
+			err = sy.RevertConfigFile(sysCfg.SyConfigFile, id)
+			if err == nil {
+				fmt.Printf("Configuration restored as it was before change #%d\n", id)
+			}
+		}
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		os.Exit(0)
+	}
+
 	// Save the options passed in through the command flags
 	if sysCfg.Debug || *config {
 		sysCfg.Verbose = true
-		err := checker.CheckSystemConfig()
-		if err != nil && err != sympierr.ErrSingularityNotInstalled {
-			fmt.Printf("\nThe system is not correctly setup.\nOn Debian based systems, the following commands can ensure that all required packages are install:\n" +
-				"\tsudo apt -y install build-essential \\ \n" +
-				"\t\tlibssl-dev \\ \n" +
-				"\t\tuuid-dev \\ \n" +
-				"\t\tlibgpgme11-dev \\ \n" +
-				"\t\tsquashfs-tools \\ \n" +
-				"\t\tlibseccomp-dev \\ \n" +
-				"\t\twget \\ \n" +
-				"\t\tpkg-config \\ \n" +
-				"\t\tgit \\ \n" +
-				"\t\tcryptsetup \\ \n" +
-				"\t\ttar bzip2 \\ \n" +
-				"\t\tgcc gfortran g++ make \\ \n" +
-				"\t\tsquashfs-tools \\ \n" +
-				"\t\tuidmap\n" +
-				"On RPM based systems:\n" +
-				"\tyum groupinstall -y 'Development Tools' && \\ \n" +
-				"\t\tsudo yum install -y openssl-devel \\ \n" +
-				"\t\tlibuuid-devel \\ \n" +
-				"\t\tlibseccomp-devel \\ \n" +
-				"\t\twget \\ \n" +
-				"\t\tsquashfs-tools \\ \n" +
-				"\t\tcryptsetup shadow-utils \\ \n" +
-				"\t\tgcc gcc-gfortran gcc-c++ make \\ \n")
-			fmt.Printf("On RPM systems, you may also want to run the following commands as root to enable fakeroot:\n\tgrubby --args=\"user_namespace.enable=1\" --update-kernel=\"$(grubby --default-kernel)\" \\ \n" +
-				"\tsudo echo \"user.max_user_namespaces=15000\" >> /etc/sysctl.conf\n")
+		report := checker.RunSystemChecks()
+		fmt.Printf("System configuration:\n%s", report.String())
+		fmt.Printf("Network interface used by MPI: %s\n", sysCfg.Ifnet)
+		err := report.Err()
+		if err != nil && !errors.Is(err, sympierr.ErrSingularityNotInstalled) {
 			log.Fatalf("System not setup properly: %s", err)
 		}
 	}
 
+	if *completionWords != "" {
+		for _, w := range sympi.GetCompletionWords(*completionWords, &sysCfg) {
+			fmt.Println(w)
+		}
+		os.Exit(0)
+	}
+
+	// Environment files of shells that are not running anymore are useless, we clean them up
+	removedEnvFiles, err := sympi.ReapStaleEnvFiles()
+	if err != nil {
+		log.Printf("[WARN] failed to remove stale environment files: %s", err)
+	}
+
+	if *cleanupEnv {
+		fmt.Printf("%d stale environment file(s) removed\n", len(removedEnvFiles))
+		os.Exit(0)
+	}
+
+	// Scratch directories abandoned by interrupted installations are reported or removed
+	// depending on the 'scratch_gc' key of the configuration file
+	scratchGC := sysCfg.ScratchGC
+	if *gc {
+		scratchGC = buildenv.ScratchGCRemove
+	} else if scratchGC == "" {
+		scratchGC = buildenv.ScratchGCWarn
+	}
+	removedScratch := buildenv.CollectScratch(buildenv.GetScratchRoots(&sysCfg), scratchGC, sysCfg.ScratchMaxAge)
+	if *gc {
+		fmt.Printf("%d abandoned scratch directory(ies) removed\n", len(removedScratch))
+		os.Exit(0)
+	}
+
+	if *exportTests != "" {
+		paths, err := app.WriteTestSources(*exportTests)
+		if err != nil {
+			fmt.Printf("Failed to write the sources of the tests: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Sources of the tests: %s\n", strings.Join(paths, ", "))
+		os.Exit(0)
+	}
+
+	if *analyse != "" {
+		var a *results.Analysis
+		var err error
+		if util.FileExists(*analyse) {
+			a, err = results.AnalyseFile(*analyse, *baseline)
+		} else {
+			a, err = results.AnalyseWithBaseline(*analyse, *baseline)
+		}
+		if err != nil {
+			fmt.Printf("Failed to analyse the results of %s: %s\n", *analyse, err)
+			os.Exit(1)
+		}
+		fmt.Print(a.String())
+		if len(a.Regressions) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *convertConfig != "" {
+		yamlFile, err := configparser.ConvertToYAML(*convertConfig)
+		if err != nil {
+			fmt.Printf("Failed to convert %s: %s\n", *convertConfig, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s successfully converted to %s\n", *convertConfig, yamlFile)
+		os.Exit(0)
+	}
+
+	if *checkConfig != "" {
+		problems, err := sympi.CheckConfigFile(*checkConfig, *checkURLs, &sysCfg)
+		if err != nil {
+			fmt.Printf("Failed to check %s: %s\n", *checkConfig, err)
+			os.Exit(1)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("%d problem(s) found in %s:\n", len(problems), *checkConfig)
+			for _, p := range problems {
+				fmt.Printf("\t%s\n", p)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", *checkConfig)
+		os.Exit(0)
+	}
+
+	if *exportMPI != "" {
+		tarball, err := sympi.ExportMPI(*exportMPI, ".")
+		if err != nil {
+			fmt.Printf("Failed to export %s: %s\n", *exportMPI, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s successfully exported to %s\n", *exportMPI, tarball)
+		os.Exit(0)
+	}
+
+	if *importMPI != "" {
+		installDir, err := sympi.ImportMPI(*importMPI)
+		if err != nil {
+			fmt.Printf("Failed to import %s: %s\n", *importMPI, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s successfully imported in %s\n", *importMPI, installDir)
+		os.Exit(0)
+	}
+
+	if *relocate {
+		relocated, err := sympi.RelocateWorkspace()
+		for _, dir := range relocated {
+			fmt.Printf("%s relocated\n", dir)
+		}
+		if err != nil {
+			fmt.Printf("Failed to relocate the workspace: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	envFile, err := sympi.GetEnvFile()
 	if err != nil || !util.FileExists(envFile) {
 		fmt.Println("SyMPI is not initialize, please run the 'sympi_init' command first")
@@ -523,6 +947,17 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *tui {
+		ui := sympi.NewTUI(os.Stdin, os.Stdout, &sysCfg)
+		ui.ExportDir = *exportDir
+		err := ui.Run()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if *list {
 		filter := "all"
 		if len(os.Args) >= 3 {
@@ -532,37 +967,23 @@ func main() {
 	}
 
 	if *load != "" {
-		re := regexp.MustCompile(`^singularity:`)
-		if re.Match([]byte(*load)) {
-			err := loadSingularity(*load)
-			if err != nil {
-				log.Fatalf("impossible to load Singularity: %s", err)
-			}
-		} else {
-			err := sympi.LoadMPI(*load)
-			if err != nil {
-				log.Fatalf("impossible to load MPI: %s", err)
-			}
+		err := sympi.Load(*load, *role)
+		if err != nil {
+			log.Fatalf("impossible to load %s: %s", *load, err)
 		}
 	}
 
 	if *unload != "" {
-		switch *unload {
-		case "mpi":
-			err := unloadMPI()
-			if err != nil {
-				log.Fatalf("impossible to unload MPI: %s", err)
-			}
-		case "singularity":
-			err := unloadSingularity()
-			if err != nil {
-				log.Fatalf("impossible to unload Singularity: %s", err)
-			}
-		default:
-			log.Fatalf("unload only access the following arguments: mpi, singularity")
+		err := sympi.Unload(*unload)
+		if err != nil {
+			log.Fatalf("impossible to unload %s: %s", *unload, err)
 		}
 	}
 
+	if *loaded {
+		displayLoaded()
+	}
+
 	if *install != "" {
 		re := regexp.MustCompile("^singularity")
 
@@ -572,27 +993,54 @@ func main() {
 			if *nosetuid {
 				singularityParameters = append(singularityParameters, "no-suid")
 			}
-			err := installSingularity(*install, singularityParameters, &sysCfg)
+			err := sympi.InstallSingularity(*install, singularityParameters, &sysCfg)
 			if err != nil {
-				log.Fatalf("failed to install Singularity %s: %s", *install, err)
+				fatalWithHint(err, "failed to install Singularity %s: %s", *install, err)
 			}
 		} else {
 			err := sympi.InstallMPIonHost(*install, &sysCfg)
 			if err != nil {
-				log.Fatalf("failed to install MPI %s: %s", *install, err)
+				fatalWithHint(err, "failed to install MPI %s: %s", *install, err)
 			}
 		}
 	}
 
 	if *uninstall != "" {
-		err := uninstallMPIfromHost(*uninstall, &sysCfg)
+		err := sympi.UninstallMPIfromHostWithOptions(*uninstall, &sympi.UninstallOptions{Force: *force}, &sysCfg)
 		if err != nil {
 			log.Fatalf("impossible to uninstall %s: %s", *uninstall, err)
 		}
 	}
 
-	if *run != "" {
-		err := sympi.RunContainer(*run, nil, &sysCfg)
+	if *run != "" && *runtimeMode == sys.RuntimeModeBoth {
+		if *bundle != "" {
+			log.Fatalf("-bundle cannot be used with -runtime-mode %s", sys.RuntimeModeBoth)
+		}
+		// The container is executed in each runtime mode and the result of each mode is reported
+		failed := false
+		for _, mode := range sys.GetRuntimeModes(*runtimeMode) {
+			modeCfg := sysCfg
+			modeCfg.RuntimeMode = mode
+			err := sympi.RunContainerApp(*run, *appName, nil, flag.Args(), &modeCfg)
+			if err != nil {
+				fmt.Printf("Runtime mode %s: FAIL (%s)\n", mode, err)
+				failed = true
+			} else {
+				fmt.Printf("Runtime mode %s: PASS\n", mode)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	} else if *run != "" {
+		var err error
+		// The arguments following the options, i.e., after '--', are the arguments of the application
+		appArgs := flag.Args()
+		if *bundle != "" {
+			err = sympi.RunContainerWithBundle(*run, *appName, nil, appArgs, *bundle, &sysCfg)
+		} else {
+			err = sympi.RunContainerApp(*run, *appName, nil, appArgs, &sysCfg)
+		}
 		if err != nil {
 			fmt.Printf("Impossible to run container %s: %s\n", *run, err)
 			os.Exit(1)
@@ -600,22 +1048,104 @@ func main() {
 
 	}
 
+	if *repeatExact != "" {
+		err := sympi.RepeatExact(*repeatExact, flag.Arg(0), &sysCfg)
+		if err != nil {
+			fmt.Printf("Impossible to repeat the run of %s: %s\n", *repeatExact, err)
+			os.Exit(1)
+		}
+	}
+
+	if *probe != "" {
+		res, err := sympi.ProbeContainer(*probe, &sysCfg)
+		if err != nil {
+			fmt.Printf("Impossible to probe container %s: %s\n", *probe, err)
+			os.Exit(1)
+		}
+		fmt.Print(res)
+		if !res.Compatible {
+			os.Exit(1)
+		}
+	}
+
+	if *planExps != "" {
+		sel, err := sympi.GetExperimentsSelection(*includeExps, *excludeExps, *sampleExps)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		plan, err := sympi.PlanExperiments(*planExps, sel, &sysCfg)
+		if err != nil {
+			fmt.Printf("Impossible to resolve the experiments of %s: %s\n", *planExps, err)
+			os.Exit(1)
+		}
+		fmt.Print(plan)
+		os.Exit(0)
+	}
+
+	if *diffImages {
+		if flag.NArg() != 2 {
+			log.Fatalf("-diff requires two images, e.g., sympi -diff <imageA> <imageB>")
+		}
+		d, err := sympi.DiffImages(flag.Arg(0), flag.Arg(1), &sysCfg)
+		if err != nil {
+			fmt.Printf("Impossible to compare %s and %s: %s\n", flag.Arg(0), flag.Arg(1), err)
+			os.Exit(1)
+		}
+		fmt.Print(d)
+		if d.Differs() {
+			os.Exit(1)
+		}
+		if d.Incomplete() {
+			os.Exit(2)
+		}
+	}
+
+	if *depsTarget != "" {
+		report, err := sympi.GetDependencyReport(*depsTarget, *appName, &sysCfg)
+		if err != nil {
+			fmt.Printf("Impossible to analyze the dependencies of %s: %s\n", *depsTarget, err)
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		if len(report.Missing()) > 0 {
+			os.Exit(1)
+		}
+	}
+
 	if *avail {
-		err := listAvail(&sysCfg)
+		err := listAvail(&sysCfg, *online, *addVersions)
 		if err != nil {
-			log.Fatalf("impossible to list available software that can be installed")
+			log.Fatalf("impossible to list available software that can be installed: %s", err)
 		}
 	}
 
 	if *importCmd != "" {
 		err := importContainerImg(*importCmd, &sysCfg)
+		if errors.Is(err, sympierr.ErrImageExists) {
+			fmt.Printf("Container already imported: %s\n", err)
+			os.Exit(0)
+		}
 		if err != nil {
 			log.Fatalf("failed to import container: %s", err)
 		}
 	}
 
 	if *export != "" {
-		imgPath := exportContainerImg(*export)
+		if *compress != "" {
+			err := sympi.ValidateCompression(*compress)
+			if err != nil {
+				log.Fatalf("invalid -compress option: %s", err)
+			}
+		}
+		if *exportChunkSize < 0 {
+			log.Fatalf("invalid -export-chunk-size option: %d", *exportChunkSize)
+		}
+		exportOpts := sympi.ExportOptions{
+			Dir:         *exportDir,
+			Compression: *compress,
+			ChunkSize:   *exportChunkSize * 1024 * 1024,
+		}
+		imgPath := exportContainerImg(*export, &exportOpts)
 		if imgPath == "" {
 			log.Fatalf("failed to export container %s", *export)
 		}
@@ -1,8 +1,9 @@
 module github.com/sylabs/singularity-mpi
 
-go 1.11
+go 1.16
 
 require (
 	github.com/gvallee/go_util v1.0.0
 	github.com/gvallee/kv v1.0.0
+	gopkg.in/yaml.v2 v2.4.0
 )
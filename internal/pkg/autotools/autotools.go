@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 )
 
@@ -25,6 +26,13 @@ type Config struct {
 
 	// ExtraConfigureArgs is a set of string that are passed to configure
 	ExtraConfigureArgs []string
+
+	// Builder is the container in which configure is executed, the host being used when nil
+	Builder *syexec.Container
+
+	// Env is the environment of configure, e.g., to select the compilers; the environment of the
+	// host is used when empty
+	Env []string
 }
 
 // Configure handles the classic configure commands
@@ -54,10 +62,52 @@ func Configure(cfg *Config) error {
 		cmd.CmdArgs = cmdArgs
 	}
 	cmd.ExecDir = cfg.Source
+	cmd.Container = cfg.Builder
+	cmd.Env = cfg.Env
+	cmd.Limited = true
+	cmd.StreamPrefix = "[configure]"
 	res := cmd.Run()
 	if res.Err != nil {
-		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", res.Err, res.Stdout, res.Stderr)
+		return sympierr.Wrap(sympierr.ErrConfigureFailed, res.Err, "stdout: %s - stderr: %s", res.Stdout, res.Stderr)
 	}
 
 	return nil
 }
+
+// ContainerCUDADir is the directory of the CUDA toolkit in the containers, where the images of
+// NVIDIA install it, used instead of the directory of the toolkit on the host when mirroring the
+// configuration of a host MPI
+const ContainerCUDADir = "/usr/local/cuda"
+
+// RelocateCUDAArgs replaces the directories of the CUDA toolkit of the host in arguments of
+// configure, e.g., --with-cuda=/opt/cuda-10.2, with ContainerCUDADir; the other arguments are
+// returned as is
+func RelocateCUDAArgs(args []string) []string {
+	var relocated []string
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "--with-cuda="):
+			a = "--with-cuda=" + ContainerCUDADir
+		case strings.HasPrefix(a, "--with-cuda-libdir="):
+			a = "--with-cuda-libdir=" + filepath.Join(ContainerCUDADir, "lib64")
+		}
+		relocated = append(relocated, a)
+	}
+	return relocated
+}
+
+// FilterConfigureArgs returns the arguments of a configure command line, e.g., as reported by
+// ompi_info, that start with one of the given prefixes, e.g., --with-cuda
+func FilterConfigureArgs(cmdLine string, prefixes []string) []string {
+	var args []string
+	for _, a := range strings.Fields(cmdLine) {
+		a = strings.Trim(a, "'\"")
+		for _, p := range prefixes {
+			if strings.HasPrefix(a, p) {
+				args = append(args, a)
+				break
+			}
+		}
+	}
+	return args
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/container"
+)
+
+// getHeaderValue returns the value of a keyword of the header of a definition file, e.g., From
+func getHeaderValue(line string, keyword string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(strings.ToLower(line), strings.ToLower(keyword)+":") {
+		return "", false
+	}
+	return strings.TrimSpace(line[len(keyword)+1:]), true
+}
+
+// GetBaseImage returns the bootstrap agent and the base image of a definition file, e.g., docker
+// and ubuntu:disco
+func GetBaseImage(path string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	var bootstrap, from string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "%") {
+			// The header is over
+			break
+		}
+		if v, ok := getHeaderValue(line, "Bootstrap"); ok {
+			bootstrap = v
+		}
+		if v, ok := getHeaderValue(line, "From"); ok {
+			from = v
+		}
+	}
+	if bootstrap == "" {
+		return "", "", fmt.Errorf("%s does not specify a bootstrap agent", path)
+	}
+	return bootstrap, from, nil
+}
+
+// SetBaseImage replaces the base image of a definition file, e.g., by a pinned reference, and
+// records it in the container.BaseImageLabel label
+func SetBaseImage(path string, from string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	inHeader := true
+	hasLabels := false
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "%") {
+			inHeader = false
+		}
+		if _, ok := getHeaderValue(line, "From"); ok && inHeader {
+			line = "From: " + from
+		}
+		if !inHeader && strings.HasPrefix(trimmed, container.BaseImageLabel+" ") {
+			continue
+		}
+		lines = append(lines, line)
+		if trimmed == "%labels" {
+			hasLabels = true
+			lines = append(lines, "\t"+container.BaseImageLabel+" "+from)
+		}
+	}
+	if !hasLabels {
+		lines = append(lines, "%labels", "\t"+container.BaseImageLabel+" "+from, "")
+	}
+
+	err = ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
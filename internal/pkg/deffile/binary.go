@@ -0,0 +1,147 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// getBinaryContainerPath returns the path to the executable of an application with prebuilt
+// binaries in the container
+func getBinaryContainerPath(info *app.Info) string {
+	return filepath.Join("/opt", filepath.Base(info.BinName))
+}
+
+// addBinaryFiles adds the files section copying the prebuilt binaries of an application in /opt
+func addBinaryFiles(f *os.File, info *app.Info) error {
+	src := info.BinPath + " " + getBinaryContainerPath(info)
+	if info.BinDir != "" {
+		src = info.BinDir + " " + filepath.Join("/opt", filepath.Base(info.BinDir))
+	}
+	_, err := f.WriteString("%files\n\t" + src + "\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+	return nil
+}
+
+// addBinaryLink makes the executable of an application with prebuilt binaries available in /opt
+// when the binaries come from a directory
+func addBinaryLink(f *os.File, info *app.Info) error {
+	if info.BinDir == "" {
+		return nil
+	}
+	rel, err := filepath.Rel(filepath.Dir(info.BinDir), info.BinPath)
+	if err != nil {
+		return fmt.Errorf("%s is not in %s: %s", info.BinPath, info.BinDir, err)
+	}
+	_, err = f.WriteString("\tcd /opt && ln -sf " + rel + " " + filepath.Base(info.BinName) + "\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+	return nil
+}
+
+// CreateBinaryDefFile creates a definition file for an application only available as prebuilt
+// binaries, for both the hybrid and bind models. Nothing is compiled: the binaries are copied in
+// the container and the packages they depend on are detected with ldd, like with the bind model.
+// With the hybrid model, MPI is still installed in the container.
+//
+// Note that the binaries must have been copied on the host prior to calling this function.
+func CreateBinaryDefFile(info *app.Info, data *DefFileData, sysCfg *sys.Config) error {
+	// Some sanity checks
+	if data.Path == "" || info.BinPath == "" {
+		return fmt.Errorf("invalid parameter(s)")
+	}
+
+	pkgs, err := getBinaryDependencies(info.BinPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(data.Path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", data.Path, err)
+	}
+	defer f.Close()
+
+	err = AddBootstrap(f, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create the bootstrap section of the definition file: %s", err)
+	}
+
+	// The labels refer to the executable in the container, not on the host
+	labelInfo := *info
+	labelInfo.BinName = filepath.Base(info.BinName)
+	labelInfo.BinPath = getBinaryContainerPath(info)
+	err = addLabels(f, &labelInfo, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the labels section of the definition file: %s", err)
+	}
+
+	err = addBinaryFiles(f, info)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the definition file: %s", err)
+	}
+
+	err = addTuningFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the tuning file: %s", err)
+	}
+
+	err = addMPIEnv(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the environment section of the definition file: %s", err)
+	}
+
+	err = addDistroInit(f, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to add the code initializing the distro: %s", err)
+	}
+
+	err = addDependencies(f, data, pkgs)
+	if err != nil {
+		return fmt.Errorf("failed to add package dependencies to the definition file: %s", err)
+	}
+
+	switch data.Model {
+	case container.HybridModel:
+		err = AddMPIInstall(f, data)
+		if err != nil {
+			return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+		}
+		err = addMPICleanup(f, info, data)
+		if err != nil {
+			return fmt.Errorf("failed to add code to cleanup MPI files: %s", err)
+		}
+	case container.BindModel:
+		// Create the directory where MPI will be mounted
+		_, err = f.WriteString("\tmkdir -p " + data.InternalEnv.InstallDir + "\n\n")
+		if err != nil {
+			return fmt.Errorf("failed to write to definition file: %s", err)
+		}
+	default:
+		return fmt.Errorf("unsupported model: %s", data.Model)
+	}
+
+	err = addBinaryLink(f, info)
+	if err != nil {
+		return fmt.Errorf("failed to add the link to the executable: %s", err)
+	}
+
+	err = addCleanUp(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to add code to clean up: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/checkpoint"
+)
+
+// addCheckpointToolInstall adds the installation of the checkpointing tool to the post section
+// of the definition file, once MPI is installed; nothing is added when no tool is requested
+func addCheckpointToolInstall(f *os.File, data *DefFileData) error {
+	if data.CheckpointTool == "" {
+		return nil
+	}
+
+	tool, err := checkpoint.Get(data.CheckpointTool)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("\t" + strings.Join(tool.Install, "\n\t") + "\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+	return nil
+}
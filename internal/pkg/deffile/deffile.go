@@ -19,15 +19,22 @@ import (
 	"github.com/sylabs/singularity-mpi/internal/pkg/ldd"
 	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/checker"
 	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
+	"github.com/sylabs/singularity-mpi/pkg/toolchain"
 )
 
 const (
 	distroCodenameTag = "DISTROCODENAME"
 )
 
+func init() {
+	checker.RegisterTemplateTags(distroCodenameTag)
+}
+
 // TemplateTags gathers all the data related to a given template
 type TemplateTags struct {
 	// Verion is the version of the MPI implementation tag
@@ -65,6 +72,33 @@ type DefFileData struct {
 
 	// Model specifies the model to follow for MPI inside the container
 	Model string
+
+	// Apps is the list of applications of a multi-app container, each one being installed as a
+	// SCIF application; empty when the container has a single application
+	Apps []app.Info
+
+	// MPIConfigureArgs is the list of extra arguments used to configure MPI in the container
+	MPIConfigureArgs []string
+
+	// MPIEnv is the list of the commands setting up the runtime environment specific to the MPI
+	// implementation, added to the environment section of the definition file after MPI_DIR is set
+	MPIEnv []string
+
+	// CheckpointTool is the checkpointing tool installed in the container, e.g., checkpoint.DMTCP;
+	// empty when no tool is installed
+	CheckpointTool string
+
+	// BuildHostCPU describes the CPUs of the host where the container is built, recorded in the
+	// labels of the image; nil when unknown
+	BuildHostCPU *sys.CPUInfo
+
+	// Toolchain is the toolchain building MPI and the application in the container; the compilers
+	// of the Linux distribution are used when nil
+	Toolchain *toolchain.Toolchain
+
+	// Tuning is the tuning file of the site copied in the container, its environment pointing MPI
+	// to it (see MPIEnv); nil when the site has no tuning file for the implementation
+	Tuning *sys.TuningProfile
 }
 
 func setMPIInstallDir(mpiImplm string, mpiVersion string) string {
@@ -118,6 +152,52 @@ func addLabels(f *os.File, app *app.Info, deffile *DefFileData) error {
 		return err
 	}
 
+	if app.ThreadLevel != "" {
+		_, err = f.WriteString("\t" + container.ThreadLevelLabel + " " + app.ThreadLevel + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if deffile.CheckpointTool != "" {
+		_, err = f.WriteString("\t" + container.CheckpointToolLabel + " " + deffile.CheckpointTool + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if deffile.Tuning != nil {
+		_, err = f.WriteString("\t" + container.TuningProfileLabel + " " + deffile.Tuning.Label() + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if deffile.BuildHostCPU != nil {
+		_, err = f.WriteString("\t" + container.ISABaselineLabel + " " + deffile.BuildHostCPU.ISABaseline + "\n")
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString("\t" + container.CPUFeaturesLabel + " " + strings.Join(deffile.BuildHostCPU.Features, ",") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if app.Bench.IsSet() {
+		_, err = f.WriteString("\t" + container.BenchLabel + " " + app.Bench.String() + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(app.OutputArtifacts) > 0 {
+		_, err = f.WriteString("\t" + container.OutputArtifactsLabel + " " + strings.Join(app.OutputArtifacts, ",") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
 	if deffile.Model == container.BindModel {
 		// When dealing with the bind model, we explicitly copy the binary in /opt
 		_, err = f.WriteString("\tApp_exe /opt/" + app.BinName + "\n")
@@ -126,7 +206,11 @@ func addLabels(f *os.File, app *app.Info, deffile *DefFileData) error {
 		}
 	} else {
 		// When dealing with the hybrid model, we do not really know the path to the executable
-		// so we rely on the data in the app.Config structure (from user input)
+		// so we rely on the data in the app.Config structure (from user input). With multiple
+		// applications, the first one is the default.
+		if app.BinPath == "" && len(deffile.Apps) > 0 {
+			app.BinPath = GetSCIFAppExe(&deffile.Apps[0])
+		}
 		if app.BinPath == "" {
 			app.BinPath = "/opt/" + app.BinName
 		}
@@ -136,6 +220,11 @@ func addLabels(f *os.File, app *app.Info, deffile *DefFileData) error {
 		}
 	}
 
+	err = addSCIFLabels(f, deffile)
+	if err != nil {
+		return err
+	}
+
 	_, err = f.WriteString("\n")
 	if err != nil {
 		return err
@@ -198,8 +287,8 @@ func addDistroInit(f *os.File, deffile *DefFileData, sysCfg *sys.Config) error {
 			return fmt.Errorf("failed to add ubuntu initialization code to definition file: %s", err)
 		}
 	case "centos":
-		// We use yum only if we are not in the fakeroot case, i.e., nopriv case
-		if !sysCfg.Nopriv {
+		// We use yum only if we are not in the fakeroot case
+		if !useFakeroot(sysCfg) {
 			_, err := f.WriteString("\trpm --rebuilddb\n")
 			if err != nil {
 				return err
@@ -222,6 +311,13 @@ func addDistroInit(f *os.File, deffile *DefFileData, sysCfg *sys.Config) error {
 	return nil
 }
 
+// useFakeroot checks whether the image is built with --fakeroot, in which case yum cannot be used
+// to bootstrap it; the lack of privileges, if any, is reported when the image is built
+func useFakeroot(sysCfg *sys.Config) bool {
+	fakeroot, _ := sy.UseFakeroot(sysCfg)
+	return fakeroot
+}
+
 // AddBoostrap adds all the data to the definition file related to bootstrapping
 func AddBootstrap(f *os.File, deffile *DefFileData, sysCfg *sys.Config) error {
 	libraryURL := distro.GetBaseImageLibraryURL(deffile.DistroID, sysCfg)
@@ -236,7 +332,7 @@ func AddBootstrap(f *os.File, deffile *DefFileData, sysCfg *sys.Config) error {
 		case "ubuntu":
 			return addDebootstrapBootstrap(f, deffile)
 		case "centos":
-			if !sysCfg.Nopriv {
+			if !useFakeroot(sysCfg) {
 				return addYumBootstrap(f, deffile)
 			} else {
 				return addDockerBootstrap(f, deffile)
@@ -249,7 +345,12 @@ func AddBootstrap(f *os.File, deffile *DefFileData, sysCfg *sys.Config) error {
 
 // AddMPIInstall adds all the data to the definition file related to the installation of MPI
 func AddMPIInstall(f *os.File, deffile *DefFileData) error {
-	_, err := f.WriteString("\texport MPI_VERSION=" + deffile.MpiImplm.Version + "\n\texport MPI_URL=\"" + deffile.MpiImplm.URL + "\"\n")
+	_, err := f.WriteString(getToolchainPostEnv(deffile))
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString("\texport MPI_VERSION=" + deffile.MpiImplm.Version + "\n\texport MPI_URL=\"" + deffile.MpiImplm.URL + "\"\n")
 	if err != nil {
 		return err
 	}
@@ -272,7 +373,11 @@ func AddMPIInstall(f *os.File, deffile *DefFileData) error {
 		return err
 	}
 
-	_, err = f.WriteString("\tcd $MPI_BUILDDIR/" + deffile.MpiImplm.ID + "-$MPI_VERSION && ./configure --prefix=$MPI_DIR && make -j8 install\n")
+	configureArgs := ""
+	if len(deffile.MPIConfigureArgs) > 0 {
+		configureArgs = " " + strings.Join(deffile.MPIConfigureArgs, " ")
+	}
+	_, err = f.WriteString("\tcd $MPI_BUILDDIR/" + deffile.MpiImplm.ID + "-$MPI_VERSION && ./configure --prefix=$MPI_DIR" + configureArgs + " && make -j8 install\n")
 	if err != nil {
 		return err
 	}
@@ -292,7 +397,7 @@ func addMPIEnv(f *os.File, deffile *DefFileData) error {
 		return err
 	}
 
-	_, err = f.WriteString("\texport MPI_DIR\n\texport PATH=$MPI_DIR/bin:$PATH\n\texport LD_LIBRARY_PATH=$MPI_DIR/lib:$LD_LIBRARY_PATH\n\n")
+	_, err = f.WriteString("\texport MPI_DIR\n\texport PATH=$MPI_DIR/bin:$PATH\n\texport LD_LIBRARY_PATH=$MPI_DIR/lib:$LD_LIBRARY_PATH\n" + getMPIEnv(deffile) + getToolchainRuntimeEnv(deffile) + "\n")
 	if err != nil {
 		return err
 	}
@@ -300,6 +405,16 @@ func addMPIEnv(f *os.File, deffile *DefFileData) error {
 	return nil
 }
 
+// getMPIEnv returns the lines of the environment section setting up the runtime environment
+// specific to the MPI implementation
+func getMPIEnv(deffile *DefFileData) string {
+	env := ""
+	for _, cmd := range deffile.MPIEnv {
+		env += "\t" + cmd + "\n"
+	}
+	return env
+}
+
 // UpdateDefFileDistroCodename replaces the tag for the distro codename in a definition file by the actual target distro codename
 func UpdateDistroCodename(data, distro string) string {
 	return strings.Replace(data, distroCodenameTag, distro, -1)
@@ -356,8 +471,13 @@ func UpdateDeffileTemplate(data DefFileData, sysCfg *sys.Config) error {
 	return nil
 }
 
-func createFilesSection(f *os.File, app *app.Info, data *DefFileData, sysCfg *sys.Config) error {
-	_, err := f.WriteString("%files\n")
+func createFilesSection(f *os.File, appInfo *app.Info, data *DefFileData, sysCfg *sys.Config) error {
+	err := app.PrepareTestSource(appInfo)
+	if err != nil {
+		return fmt.Errorf("failed to write the source of %s: %s", appInfo.Name, err)
+	}
+
+	_, err = f.WriteString("%files\n")
 	if err != nil {
 		return fmt.Errorf("failed to write to definition file: %s", err)
 	}
@@ -366,15 +486,21 @@ func createFilesSection(f *os.File, app *app.Info, data *DefFileData, sysCfg *sy
 	case container.BindModel:
 		// In the context of the bind model, we compile the application on the host and copy it over
 		// This means this is most certainly a file
-		_, err = f.WriteString("\t" + app.BinPath + " /opt\n\n")
+		_, err = f.WriteString("\t" + appInfo.BinPath + " /opt\n\n")
 		if err != nil {
 			return fmt.Errorf("failed to write to definition file: %s", err)
 		}
 	case container.HybridModel:
+		if appInfo.IsPython() && appInfo.Python.Requirements != "" {
+			_, err = f.WriteString("\t" + appInfo.Python.Requirements + " " + getPythonRequirementsPath(appInfo) + "\n")
+			if err != nil {
+				return fmt.Errorf("failed to write to definition file: %s", err)
+			}
+		}
 		// If the application is a file that we compiled, we copy it into the container
-		if util.DetectTarballFormat(app.Source) == util.UnknownFormat {
-			// This means this is most certainly a file
-			src := strings.Replace(app.Source, "file://", "", 1)
+		if getSourceType(appInfo) == util.FileURL && util.DetectTarballFormat(appInfo.Source) == util.UnknownFormat {
+			// This means this is most certainly a file or a local directory
+			src := filepath.Clean(strings.Replace(appInfo.Source, "file://", "", 1))
 			_, err = f.WriteString("\t" + src + " /opt\n\n")
 			if err != nil {
 				return fmt.Errorf("failed to write to definition file: %s", err)
@@ -383,7 +509,7 @@ func createFilesSection(f *os.File, app *app.Info, data *DefFileData, sysCfg *sy
 	default:
 		log.Println("It does not seem to be a MPI application, simply copying files...")
 		// This means this is most certainly a file
-		src := strings.Replace(app.Source, "file://", "", 1)
+		src := strings.Replace(appInfo.Source, "file://", "", 1)
 		_, err = f.WriteString("\t" + src + " /opt\n\n")
 		if err != nil {
 			return fmt.Errorf("failed to write to definition file: %s", err)
@@ -408,12 +534,16 @@ func createUbuntuDockerBootstrapSection(f *os.File, data *DefFileData, sysCfg *s
 }
 
 func addAppInstall(f *os.File, app *app.Info, data *DefFileData) error {
+	if app.IsPython() {
+		return addPythonAppInstall(f, app, data)
+	}
+
 	installCmd := "make install"
 	if app.InstallCmd != "" {
 		installCmd = app.InstallCmd
 	}
 
-	urlType := util.DetectURLType(app.Source)
+	urlType := getSourceType(app)
 	switch urlType {
 	case util.GitURL:
 		srcDir := path.Base(app.Source)
@@ -424,8 +554,15 @@ func addAppInstall(f *os.File, app *app.Info, data *DefFileData) error {
 		}
 	case util.FileURL:
 		containerSrcPath := filepath.Join(data.InternalEnv.SrcDir, filepath.Base(app.Source))
-		if app.BinPath != "" {
-			_, err := f.WriteString("\tcd /opt/$APPDIR && mpicc -o " + app.BinPath + " " + containerSrcPath + "\n")
+		if appIsLocalDir(app) {
+			// The directory is copied in /opt by the files section
+			_, err := f.WriteString("\tcd /opt/$APPDIR && " + installCmd + "\n")
+			if err != nil {
+				return fmt.Errorf("failed to write to definition file: %s", err)
+			}
+		} else if app.BinPath != "" {
+			compiler := implem.GetCompiler(data.MpiImplm, filepath.Ext(app.Source) == ".f90")
+			_, err := f.WriteString("\tcd /opt/$APPDIR && " + compiler + " -o " + app.BinPath + " " + containerSrcPath + "\n")
 			if err != nil {
 				return fmt.Errorf("failed to write to definition file: %s", err)
 			}
@@ -485,7 +622,7 @@ func addDetectAppDir(f *os.File, app *app.Info, data *DefFileData) error {
 // Note that the function assumes that /opt is empty when called so it needs to be
 // called before downloading/installing anything else.
 func addAppDownload(f *os.File, app *app.Info, data *DefFileData) error {
-	urlType := util.DetectURLType(app.Source)
+	urlType := getSourceType(app)
 	switch urlType {
 	case util.GitURL:
 		srcDir := path.Base(app.Source)
@@ -511,11 +648,30 @@ func addAppDownload(f *os.File, app *app.Info, data *DefFileData) error {
 		if err != nil {
 			return fmt.Errorf("failed to add code to get the directory of the app to the definition file: %s", err)
 		}
+	case util.FileURL:
+		if appIsLocalDir(app) {
+			// Nothing to download, the directory is copied in /opt by the files section
+			_, err := f.WriteString("\tAPPDIR=" + getAppLocalDirName(app) + "\n\n")
+			if err != nil {
+				return fmt.Errorf("failed to write to definition file: %s", err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// appIsLocalDir checks whether the source of an application is a local directory
+func appIsLocalDir(info *app.Info) bool {
+	return app.IsLocalDir(info.Source)
+}
+
+// getAppLocalDirName returns the name of the local directory of an application, which is
+// also the name of the directory in /opt in the container
+func getAppLocalDirName(info *app.Info) string {
+	return filepath.Base(filepath.Clean(app.GetLocalPath(info.Source)))
+}
+
 func addDebianDependencies(f *os.File, list []string) error {
 	if len(list) > 0 {
 		_, err := f.WriteString("\tapt install -y " + strings.Join(list, " ") + "\n")
@@ -599,13 +755,23 @@ func CreateHybridDefFile(app *app.Info, data *DefFileData, sysCfg *sys.Config) e
 		return fmt.Errorf("failed to create the labels section of the definition file: %s", err)
 	}
 
-	if util.DetectURLType(app.Source) == util.FileURL {
+	if needsFilesSection(app) {
 		err = createFilesSection(f, app, data, sysCfg)
 		if err != nil {
 			return fmt.Errorf("failed to create the files section of the definition file: %s", err)
 		}
 	}
 
+	err = addToolchainFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the toolchain: %s", err)
+	}
+
+	err = addTuningFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the tuning file: %s", err)
+	}
+
 	err = addMPIEnv(f, data)
 	if err != nil {
 		return fmt.Errorf("failed to create the environment section of the definition file: %s", err)
@@ -626,9 +792,11 @@ func CreateHybridDefFile(app *app.Info, data *DefFileData, sysCfg *sys.Config) e
 		return fmt.Errorf("failed to create the post section of the definition file: %s", err)
 	}
 
-	err = addAppInstall(f, app, data)
-	if err != nil {
-		return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+	if len(data.Apps) == 0 {
+		err = addAppInstall(f, app, data)
+		if err != nil {
+			return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+		}
 	}
 
 	err = addMPICleanup(f, app, data)
@@ -636,11 +804,49 @@ func CreateHybridDefFile(app *app.Info, data *DefFileData, sysCfg *sys.Config) e
 		return fmt.Errorf("failed to add code to cleanup MPI files: %s", err)
 	}
 
+	err = addCheckpointToolInstall(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to add the installation of the checkpointing tool: %s", err)
+	}
+
+	// The sections of the applications of a multi-app container follow the post section
+	err = addSCIFApps(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the application sections of the definition file: %s", err)
+	}
+
 	f.Close()
 
 	return nil
 }
 
+// getBinaryDependencies returns the list of packages required in the container by a binary
+// compiled on the host, based on the libraries it depends on
+func getBinaryDependencies(binPath string) ([]string, error) {
+	lddMod, err := ldd.Detect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load a workable ldd module")
+	}
+	log.Printf("* Getting dependencies for %s\n", binPath)
+	pkgs := lddMod.GetPackageDependenciesForFile(binPath)
+
+	// Add some packages we always want in the image
+	// todo: find a way to do this in a clean and maintainable way
+	pkgs = append(pkgs, "libc-bin")
+	pkgs = append(pkgs, "libopensm-dev")
+	pkgs = append(pkgs, "librdmacm-dev")
+	pkgs = append(pkgs, "librdmacm1")
+	pkgs = append(pkgs, "kmod")
+	pkgs = append(pkgs, "libmlx4-1")
+	pkgs = append(pkgs, "libibverbs-dev")
+	pkgs = append(pkgs, "libibverbs1")
+	pkgs = append(pkgs, "libnl-3-dev")
+	pkgs = append(pkgs, "infiniband-diags")
+	pkgs = append(pkgs, "ibverbs-utils")
+
+	return pkgs, nil
+}
+
 // CreateBindDefFile creates a definition file for a given bind-based configuration.
 //
 // Note that the application must have been compiled on the host prior to calling this function.
@@ -659,26 +865,10 @@ func CreateBindDefFile(app *app.Info, data *DefFileData, sysCfg *sys.Config) err
 	// At this point the application already has been installed on the host.
 	// Detect the list of dependencies required for the binary that we are about to copy in
 	// the container.
-	lddMod, err := ldd.Detect()
+	pkgs, err := getBinaryDependencies(app.BinPath)
 	if err != nil {
-		return fmt.Errorf("failed to load a workable ldd module")
+		return err
 	}
-	log.Printf("* Getting dependencies for %s\n", app.BinPath)
-	pkgs := lddMod.GetPackageDependenciesForFile(app.BinPath)
-
-	// Add some packages we always want in the image
-	// todo: find a way to do this in a clean and maintainable way
-	pkgs = append(pkgs, "libc-bin")
-	pkgs = append(pkgs, "libopensm-dev")
-	pkgs = append(pkgs, "librdmacm-dev")
-	pkgs = append(pkgs, "librdmacm1")
-	pkgs = append(pkgs, "kmod")
-	pkgs = append(pkgs, "libmlx4-1")
-	pkgs = append(pkgs, "libibverbs-dev")
-	pkgs = append(pkgs, "libibverbs1")
-	pkgs = append(pkgs, "libnl-3-dev")
-	pkgs = append(pkgs, "infiniband-diags")
-	pkgs = append(pkgs, "ibverbs-utils")
 
 	err = AddBootstrap(f, data, sysCfg)
 	if err != nil {
@@ -696,6 +886,16 @@ func CreateBindDefFile(app *app.Info, data *DefFileData, sysCfg *sys.Config) err
 		return fmt.Errorf("failed to create the files section of the definition file: %s", err)
 	}
 
+	err = addToolchainFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the toolchain: %s", err)
+	}
+
+	err = addTuningFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the tuning file: %s", err)
+	}
+
 	err = addMPIEnv(f, data)
 	if err != nil {
 		return fmt.Errorf("failed to create the environment section of the definition file: %s", err)
@@ -10,13 +10,17 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/gvallee/go_util/pkg/util"
 	"github.com/sylabs/singularity-mpi/internal/pkg/distro"
 	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
+	"github.com/sylabs/singularity-mpi/pkg/toolchain"
 )
 
 func TestCreateDefFile(t *testing.T) {
@@ -63,33 +67,511 @@ func TestCreateDefFile(t *testing.T) {
 	helloworldData.DistroID = distro.ParseDescr("ubuntu:disco")
 	helloworldData.MpiImplm = &openmpi
 	helloworldData.InternalEnv = &helloworldEnv
+	helloworldData.BuildHostCPU = &sys.CPUInfo{Arch: "amd64", ISABaseline: "x86-64-v2", Features: []string{"popcnt", "sse4_2"}}
 
 	var netpipeData DefFileData
 	netpipeData.Path = filepath.Join(tempDir, "netpipe.def")
 	netpipeData.DistroID = distro.ParseDescr("ubuntu:disco")
 	netpipeData.MpiImplm = &openmpi
 	netpipeData.InternalEnv = &netpipeEnv
+	netpipeData.Toolchain, err = toolchain.Get(toolchain.NVHPC, "/opt/nvidia/hpc_sdk/Linux_x86_64/23.11")
+	if err != nil {
+		t.Fatalf("failed to get the nvhpc toolchain: %s", err)
+	}
 
 	var imbData DefFileData
 	imbData.Path = filepath.Join(tempDir, "imb.def")
 	imbData.DistroID = distro.ParseDescr("ubuntu:disco")
 	imbData.MpiImplm = &openmpi
 	imbData.InternalEnv = &imbEnv
+	imbData.Tuning = &sys.TuningProfile{MPI: implem.OMPI, File: "/etc/site/mca-params.conf", Name: "site-ib", Digest: "sha256:1234"}
+	imbData.MPIEnv = []string{"export OMPI_MCA_mca_base_param_files=" + imbData.Tuning.ContainerPath()}
 
 	err = CreateHybridDefFile(&helloworld, &helloworldData, &sysCfg)
 	if err != nil {
 		t.Fatalf("failed to create definition file for helloworld: %s", err)
 	}
+	content, err := ioutil.ReadFile(helloworldData.Path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", helloworldData.Path, err)
+	}
+	if !strings.Contains(string(content), container.ISABaselineLabel+" x86-64-v2\n") || !strings.Contains(string(content), container.CPUFeaturesLabel+" popcnt,sse4_2\n") {
+		t.Fatalf("the CPU features of the build host are not in the labels:\n%s", string(content))
+	}
 
 	err = CreateHybridDefFile(&netpipe, &netpipeData, &sysCfg)
 	if err != nil {
 		t.Fatalf("failed to create definition file for netpipe: %s", err)
 	}
+	content, err = ioutil.ReadFile(netpipeData.Path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", netpipeData.Path, err)
+	}
+	for _, expected := range []string{
+		"%files\n\t/opt/nvidia/hpc_sdk/Linux_x86_64/23.11/compilers/lib /opt/nvidia/hpc_sdk/Linux_x86_64/23.11/compilers/lib\n",
+		"\texport CC=\"nvc\"\n",
+		"\texport CFLAGS=\"-fPIC\"\n",
+		"\texport PATH=\"/opt/nvidia/hpc_sdk/Linux_x86_64/23.11/compilers/bin:$PATH\"\n",
+		"\texport LD_LIBRARY_PATH=/opt/nvidia/hpc_sdk/Linux_x86_64/23.11/compilers/lib:$LD_LIBRARY_PATH\n",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("%s does not include %q:\n%s", netpipeData.Path, expected, string(content))
+		}
+	}
+
+	buildArgs := strings.Join(GetToolchainBuildArgs(&netpipeData), " ")
+	if buildArgs != "--bind /opt/nvidia/hpc_sdk/Linux_x86_64/23.11:/opt/nvidia/hpc_sdk/Linux_x86_64/23.11:ro" {
+		t.Fatalf("invalid build flags of the toolchain: %s", buildArgs)
+	}
+	if len(GetToolchainBuildArgs(&helloworldData)) != 0 {
+		t.Fatalf("build flags returned without vendor toolchain")
+	}
 
 	err = CreateHybridDefFile(&imb, &imbData, &sysCfg)
 	if err != nil {
 		t.Fatalf("failed to create definition file for IMB: %s", err)
 	}
+	content, err = ioutil.ReadFile(imbData.Path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", imbData.Path, err)
+	}
+	for _, expected := range []string{
+		"%files\n\t/etc/site/mca-params.conf /etc/sympi/tuning/openmpi/mca-params.conf\n",
+		"\texport OMPI_MCA_mca_base_param_files=/etc/sympi/tuning/openmpi/mca-params.conf\n",
+		"\t" + container.TuningProfileLabel + " site-ib@sha256:1234\n",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("%s does not include %q:\n%s", imbData.Path, expected, string(content))
+		}
+	}
 
 	fmt.Printf("Definition files are in %s", tempDir)
 }
+
+func TestCreateLayeredDefFiles(t *testing.T) {
+	var sysCfg sys.Config
+
+	curDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get the current work directory: %s", err)
+	}
+	sysCfg.BinPath = filepath.Join(curDir, "../../..")
+	sysCfg.EtcDir = filepath.Join(sysCfg.BinPath, "etc")
+	sysCfg.TemplateDir = filepath.Join(sysCfg.EtcDir, "templates")
+
+	helloworld := app.GetHelloworld(&sysCfg)
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var openmpi implem.Info
+	openmpi.ID = implem.OMPI
+	openmpi.URL = "https://download.open-mpi.org/release/open-mpi/v3.1/openmpi-3.1.4.tar.bz2"
+	openmpi.Tarball = "openmpi-3.1.4.tar.bz2"
+	openmpi.Version = "3.1.4"
+
+	var env buildenv.Info
+	env.InstallDir = "/opt/mpi"
+	env.SrcDir = "/opt"
+
+	var data DefFileData
+	data.DistroID = distro.ParseDescr("ubuntu:disco")
+	data.MpiImplm = &openmpi
+	data.InternalEnv = &env
+
+	data.Path = filepath.Join(tempDir, "base.def")
+	err = CreateHybridBaseDefFile(&data, &sysCfg)
+	if err != nil {
+		t.Fatalf("failed to create definition file of the base image: %s", err)
+	}
+	content, err := ioutil.ReadFile(data.Path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", data.Path, err)
+	}
+	if strings.Contains(string(content), helloworld.Name) {
+		t.Fatalf("definition file of the base image includes the application:\n%s", content)
+	}
+
+	baseImage := filepath.Join(tempDir, "base.sif")
+	data.Path = filepath.Join(tempDir, "helloworld.def")
+	err = CreateHybridAppDefFile(&helloworld, &data, baseImage, &sysCfg)
+	if err != nil {
+		t.Fatalf("failed to create definition file of the application layer: %s", err)
+	}
+	content, err = ioutil.ReadFile(data.Path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", data.Path, err)
+	}
+	if !strings.HasPrefix(string(content), "Bootstrap: localimage\nFrom: "+baseImage+"\n") {
+		t.Fatalf("definition file of the application layer does not use the base image:\n%s", content)
+	}
+	if strings.Contains(string(content), "MPI_BUILDDIR") {
+		t.Fatalf("definition file of the application layer installs MPI:\n%s", content)
+	}
+}
+
+func TestCreatePythonDefFile(t *testing.T) {
+	var sysCfg sys.Config
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	requirements := filepath.Join(tempDir, "requirements.txt")
+	tests := []struct {
+		name       string
+		python     app.PythonInfo
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name:       "pip",
+			python:     app.PythonInfo{Requirements: requirements, PipInstall: "horovod"},
+			expected:   []string{requirements + " /opt/requirements.txt", "apt-get install -y python3 python3-pip", "--no-binary=mpi4py mpi4py", "pip install --no-cache-dir -r /opt/requirements.txt", "pip install --no-cache-dir horovod"},
+			unexpected: []string{"conda"},
+		},
+		{
+			name:       "conda",
+			python:     app.PythonInfo{Version: "3.8", CondaInstall: "tensorflow"},
+			expected:   []string{"Miniconda3-latest-Linux-$(uname -m).sh", "conda install -y python=3.8", "conda install -y tensorflow", "--no-binary=mpi4py mpi4py"},
+			unexpected: []string{"python3-pip", "requirements.txt"},
+		},
+	}
+
+	var openmpi implem.Info
+	openmpi.ID = implem.OMPI
+	openmpi.URL = "https://download.open-mpi.org/release/open-mpi/v3.1/openmpi-3.1.4.tar.bz2"
+	openmpi.Version = "3.1.4"
+
+	for _, tt := range tests {
+		var env buildenv.Info
+		env.InstallDir = "/opt/mpi"
+		env.SrcDir = "/opt"
+
+		var data DefFileData
+		data.Path = filepath.Join(tempDir, tt.name+".def")
+		data.DistroID = distro.ParseDescr("ubuntu:disco")
+		data.MpiImplm = &openmpi
+		data.InternalEnv = &env
+		data.Model = "hybrid"
+
+		info := app.Info{Name: "mpi4py-app", BinName: "app.py", Type: app.PythonType, Python: tt.python}
+		err = CreateHybridDefFile(&info, &data, &sysCfg)
+		if err != nil {
+			t.Fatalf("failed to create definition file (%s): %s", tt.name, err)
+		}
+		content, err := ioutil.ReadFile(data.Path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", data.Path, err)
+		}
+		for _, s := range tt.expected {
+			if !strings.Contains(string(content), s) {
+				t.Fatalf("definition file (%s) does not include %q:\n%s", tt.name, s, content)
+			}
+		}
+		for _, s := range tt.unexpected {
+			if strings.Contains(string(content), s) {
+				t.Fatalf("definition file (%s) includes %q:\n%s", tt.name, s, content)
+			}
+		}
+	}
+}
+
+func TestCreateMultiAppDefFile(t *testing.T) {
+	var sysCfg sys.Config
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var openmpi implem.Info
+	openmpi.ID = implem.OMPI
+	openmpi.URL = "https://download.open-mpi.org/release/open-mpi/v3.1/openmpi-3.1.4.tar.bz2"
+	openmpi.Version = "3.1.4"
+
+	var env buildenv.Info
+	env.InstallDir = "/opt/mpi"
+	env.SrcDir = "/opt"
+
+	var data DefFileData
+	data.Path = filepath.Join(tempDir, "multi.def")
+	data.DistroID = distro.ParseDescr("ubuntu:disco")
+	data.MpiImplm = &openmpi
+	data.InternalEnv = &env
+	data.Model = "hybrid"
+	data.Apps = []app.Info{
+		{Name: "netpipe", Source: "http://netpipe.cs.ksu.edu/download/NetPIPE-5.1.4.tar.gz", BinName: "NPmpi", InstallCmd: "make mpi"},
+		{Name: "hello", Source: "file://" + filepath.Join(tempDir, "hello.c"), BinName: "hello"},
+	}
+
+	info := app.Info{Name: "benchmarks"}
+	err = CreateHybridDefFile(&info, &data, &sysCfg)
+	if err != nil {
+		t.Fatalf("failed to create definition file: %s", err)
+	}
+	content, err := ioutil.ReadFile(data.Path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", data.Path, err)
+	}
+	mpiEnv := "\texport MPI_DIR=/opt/mpi\n\texport PATH=$MPI_DIR/bin:$PATH\n\texport LD_LIBRARY_PATH=$MPI_DIR/lib:$LD_LIBRARY_PATH\n"
+	expected := []string{
+		"App_exe /scif/apps/netpipe/bin/NPmpi\n",
+		"Apps netpipe,hello\n",
+		"App_exe_hello /scif/apps/hello/bin/hello\n",
+		"%appfiles hello\n\t" + filepath.Join(tempDir, "hello.c") + " /scif/apps/hello/hello.c\n\n",
+		"%appinstall netpipe\n" + mpiEnv +
+			"\tcd /scif/apps/netpipe\n" +
+			"\twget http://netpipe.cs.ksu.edu/download/NetPIPE-5.1.4.tar.gz && tar -xzf NetPIPE-5.1.4.tar.gz\n" +
+			"\tAPPDIR=`tar -tf NetPIPE-5.1.4.tar.gz | head -1 | cut -d/ -f1`\n" +
+			"\tcd /scif/apps/netpipe/$APPDIR && make mpi\n" +
+			"\tmkdir -p /scif/apps/netpipe/bin && ln -sf /scif/apps/netpipe/$APPDIR/NPmpi /scif/apps/netpipe/bin/NPmpi\n\n",
+		"%appinstall hello\n" + mpiEnv +
+			"\tcd /scif/apps/hello\n" +
+			"\tAPPDIR=.\n" +
+			"\tcd /scif/apps/hello/$APPDIR && mpicc -o hello hello.c\n" +
+			"\tmkdir -p /scif/apps/hello/bin && ln -sf /scif/apps/hello/$APPDIR/hello /scif/apps/hello/bin/hello\n\n",
+		"%apprun hello\n\texec /scif/apps/hello/bin/hello \"$@\"\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(string(content), s) {
+			t.Fatalf("definition file does not include %q:\n%s", s, content)
+		}
+	}
+	if strings.Index(string(content), "rm -rf $MPI_BUILDDIR") > strings.Index(string(content), "%appinstall") {
+		t.Fatalf("post section is after the application sections:\n%s", content)
+	}
+}
+
+func TestCreateBinaryDefFile(t *testing.T) {
+	var sysCfg sys.Config
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	binDir := filepath.Join(tempDir, "app-1.0")
+	binPath := filepath.Join(binDir, "bin", "app")
+	err = os.MkdirAll(filepath.Dir(binPath), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", filepath.Dir(binPath), err)
+	}
+	err = ioutil.WriteFile(binPath, []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", binPath, err)
+	}
+
+	tests := []struct {
+		name       string
+		model      string
+		info       app.Info
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name:       "hybrid",
+			model:      container.HybridModel,
+			info:       app.Info{Name: "app", BinName: "app", BinPath: binPath, BinDir: binDir, Type: app.BinaryType},
+			expected:   []string{"App_exe /opt/app", binDir + " /opt/app-1.0", "./configure --prefix=$MPI_DIR", "cd /opt && ln -sf app-1.0/bin/app app", "libibverbs1"},
+			unexpected: []string{"mpicc", "make install\n"},
+		},
+		{
+			name:       "bind",
+			model:      container.BindModel,
+			info:       app.Info{Name: "app", BinName: "app", BinPath: binPath, Type: app.BinaryType},
+			expected:   []string{"App_exe /opt/app", binPath + " /opt/app", "mkdir -p /opt/mpi"},
+			unexpected: []string{"mpicc", "MPI_BUILDDIR", "ln -sf"},
+		},
+	}
+
+	var openmpi implem.Info
+	openmpi.ID = implem.OMPI
+	openmpi.URL = "https://download.open-mpi.org/release/open-mpi/v3.1/openmpi-3.1.4.tar.bz2"
+	openmpi.Version = "3.1.4"
+
+	for _, tt := range tests {
+		var env buildenv.Info
+		env.InstallDir = "/opt/mpi"
+
+		var data DefFileData
+		data.Path = filepath.Join(tempDir, tt.name+".def")
+		data.DistroID = distro.ParseDescr("ubuntu:disco")
+		data.MpiImplm = &openmpi
+		data.InternalEnv = &env
+		data.Model = tt.model
+
+		err = CreateBinaryDefFile(&tt.info, &data, &sysCfg)
+		if err != nil {
+			t.Fatalf("failed to create definition file (%s): %s", tt.name, err)
+		}
+		content, err := ioutil.ReadFile(data.Path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", data.Path, err)
+		}
+		for _, s := range tt.expected {
+			if !strings.Contains(string(content), s) {
+				t.Fatalf("definition file (%s) does not include %q:\n%s", tt.name, s, content)
+			}
+		}
+		for _, s := range tt.unexpected {
+			if strings.Contains(string(content), s) {
+				t.Fatalf("definition file (%s) includes %q:\n%s", tt.name, s, content)
+			}
+		}
+	}
+}
+
+func TestCreateIMPIDefFile(t *testing.T) {
+	var sysCfg sys.Config
+	sysCfg.Ifnet = "eth0"
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := []struct {
+		name       string
+		mpi        implem.Info
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name:       "oneapi",
+			mpi:        implem.Info{ID: implem.IMPI, Version: "2021.1.1", URL: "https://registrationcenter-download.intel.com/l_mpi_oneapi_p_2021.1.1.76_offline.sh"},
+			expected:   []string{"MPI_DIR=/opt/impi/mpi/latest", "$MPI_DIR/lib/release", "wget \"https://registrationcenter-download.intel.com/", "sh ./l_mpi_oneapi_p_2021.1.1.76_offline.sh -a -s --eula accept --install-dir /opt/impi", "FI_SOCKETS_IFACE=eth0", "export I_MPI_ROOT=$MPI_DIR\n", "MANPATH=$MPI_DIR/man:", "mpicc -o"},
+			unexpected: []string{" /tmp/impi/\n", "silent_install.cfg", "./configure"},
+		},
+		{
+			name:       "legacy",
+			mpi:        implem.Info{ID: implem.IMPI, Version: "2019.6.166", URL: "file:///sources/l_mpi_2019.6.166.tgz"},
+			expected:   []string{"MPI_DIR=/opt/impi/compilers_and_libraries/linux/mpi/intel64", "/sources/l_mpi_2019.6.166.tgz /tmp/impi/", "PSET_INSTALL_DIR=/opt/impi\n", "tar -xzf l_mpi_2019.6.166.tgz", "./install.sh --silent /tmp/impi/silent_install.cfg", "MANPATH=$MPI_DIR/../man:"},
+			unexpected: []string{"wget \"", "--eula", "./configure"},
+		},
+	}
+
+	helloworld := app.Info{Name: "helloworld", BinName: "helloworld", BinPath: "/opt/helloworld", Source: "file:///sources/helloworld.c"}
+	for _, tt := range tests {
+		var env buildenv.Info
+		env.InstallDir = "/opt/impi"
+
+		var data DefFileData
+		data.Path = filepath.Join(tempDir, tt.name+".def")
+		data.DistroID = distro.ParseDescr("ubuntu:disco")
+		data.MpiImplm = &tt.mpi
+		data.InternalEnv = &env
+		data.Model = container.HybridModel
+		data.MPIEnv = GetIMPIContainerEnv(&tt.mpi)
+
+		err = CreateIMPIDefFile(&helloworld, &data, &sysCfg)
+		if err != nil {
+			t.Fatalf("failed to create definition file (%s): %s", tt.name, err)
+		}
+		content, err := ioutil.ReadFile(data.Path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", data.Path, err)
+		}
+		for _, s := range tt.expected {
+			if !strings.Contains(string(content), s) {
+				t.Fatalf("definition file (%s) does not include %q:\n%s", tt.name, s, content)
+			}
+		}
+		for _, s := range tt.unexpected {
+			if strings.Contains(string(content), s) {
+				t.Fatalf("definition file (%s) includes %q:\n%s", tt.name, s, content)
+			}
+		}
+	}
+
+	// The legacy installer must be a tarball
+	var data DefFileData
+	data.Path = filepath.Join(tempDir, "invalid.def")
+	data.MpiImplm = &implem.Info{ID: implem.IMPI, Version: "2019.6.166", URL: "file:///sources/l_mpi_2019.6.166.zip"}
+	data.InternalEnv = &buildenv.Info{InstallDir: "/opt/impi"}
+	if CreateIMPIDefFile(&helloworld, &data, &sysCfg) == nil {
+		t.Fatalf("definition file created with an unsupported installer")
+	}
+}
+
+func TestCreateDockerfile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	src := filepath.Join(tempDir, "app.c")
+	err = ioutil.WriteFile(src, []byte("int main() { return 0; }\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", src, err)
+	}
+	defFile := filepath.Join(tempDir, "app.def")
+	content := "Bootstrap: docker\nFrom: ubuntu:DISTROCODENAME\n\n" +
+		"%labels\n\tLinux_distribution ubuntu\n\tApp_exe /opt/app\n\n" +
+		"%files\n\t" + src + " /opt\n\n" +
+		"%environment\n\tMPI_DIR=/opt/openmpi\n\texport MPI_DIR\n\texport PATH=$MPI_DIR/bin:$PATH\n\n" +
+		"%post\n\tapt-get update\n\tcd /opt && mpicc -o app app.c\n\tcat > /opt/silent.cfg << EOF\nACCEPT_EULA=accept\nEOF\n\n" +
+		"%appinstall netpipe\n\tmake install\n\n" +
+		"%apprun netpipe\n\texec /scif/apps/netpipe/bin/NPmpi \"$@\"\n"
+	err = ioutil.WriteFile(defFile, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", defFile, err)
+	}
+
+	var data DefFileData
+	data.DistroID = distro.ParseDescr("ubuntu:disco")
+	contextDir := filepath.Join(tempDir, "app.docker")
+	err = CreateDockerfile(defFile, contextDir, &data)
+	if err != nil {
+		t.Fatalf("CreateDockerfile() failed: %s", err)
+	}
+	dockerfile, err := ioutil.ReadFile(filepath.Join(contextDir, DockerfileName))
+	if err != nil {
+		t.Fatalf("failed to read the Dockerfile: %s", err)
+	}
+	expected := []string{
+		"FROM ubuntu:disco\n",
+		"LABEL Linux_distribution=\"ubuntu\"\nLABEL App_exe=\"/opt/app\"\n",
+		"COPY app.c /opt/\n",
+		"COPY post.sh /tmp/post.sh\nRUN sh -e /tmp/post.sh && rm -f /tmp/post.sh\n",
+		"COPY appinstall-netpipe.sh /tmp/appinstall-netpipe.sh\nRUN sh -e /tmp/appinstall-netpipe.sh && rm -f /tmp/appinstall-netpipe.sh\n",
+		"ENV MPI_DIR=/opt/openmpi\nENV PATH=$MPI_DIR/bin:$PATH\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(string(dockerfile), s) {
+			t.Fatalf("Dockerfile does not include %q:\n%s", s, dockerfile)
+		}
+	}
+	if !util.FileExists(filepath.Join(contextDir, "app.c")) {
+		t.Fatalf("app.c is not in the build context")
+	}
+	script, err := ioutil.ReadFile(filepath.Join(contextDir, "post.sh"))
+	if err != nil {
+		t.Fatalf("failed to read the script of the post section: %s", err)
+	}
+	if !strings.Contains(string(script), "\tcd /opt && mpicc -o app app.c\n\tcat > /opt/silent.cfg << EOF\nACCEPT_EULA=accept\nEOF\n") {
+		t.Fatalf("invalid script of the post section:\n%s", script)
+	}
+	script, err = ioutil.ReadFile(filepath.Join(contextDir, "appinstall-netpipe.sh"))
+	if err != nil || !strings.Contains(string(script), "mkdir -p /scif/apps/netpipe\ncd /scif/apps/netpipe\n\tmake install\n") {
+		t.Fatalf("invalid script of the appinstall section: %s\n%s", err, script)
+	}
+
+	// The base image of layered builds is only available to Singularity
+	err = ioutil.WriteFile(defFile, []byte("Bootstrap: localimage\nFrom: /tmp/base.sif\n\n%post\n\tmake install\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", defFile, err)
+	}
+	if CreateDockerfile(defFile, contextDir, &data) == nil {
+		t.Fatalf("Dockerfile created from a local image")
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+)
+
+const (
+	// DockerfileName is the name of the Dockerfile in its build context, i.e., the directory
+	// with the Dockerfile and the files copied in the image
+	DockerfileName = "Dockerfile"
+
+	// dockerScriptDir is the directory of the image where the scripts of the post and appinstall
+	// sections are copied before being executed
+	dockerScriptDir = "/tmp"
+)
+
+// scriptSections are the sections of a definition file that are shell scripts, whose lines are
+// kept as is, e.g., to preserve heredocs
+var scriptSections = map[string]bool{
+	"%post":       true,
+	"%appinstall": true,
+}
+
+// defFileSection is a section of a definition file, e.g., %post, with its argument, e.g., the name
+// of the application of a SCIF section, and its lines
+type defFileSection struct {
+	name  string
+	arg   string
+	lines []string
+}
+
+// parseDefFile returns the bootstrap agent and the base image from the header of a definition
+// file, and its sections. The lines of the scripts, e.g., the post section, are kept as is while
+// the lines of the other sections are trimmed, without empty lines and comments.
+func parseDefFile(content string) (string, string, []defFileSection) {
+	var bootstrap, from string
+	var sections []defFileSection
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "%") {
+			tokens := strings.Fields(trimmed)
+			s := defFileSection{name: tokens[0]}
+			if len(tokens) > 1 {
+				s.arg = tokens[1]
+			}
+			sections = append(sections, s)
+			continue
+		}
+		if len(sections) == 0 {
+			if v, ok := getHeaderValue(line, "Bootstrap"); ok {
+				bootstrap = v
+			}
+			if v, ok := getHeaderValue(line, "From"); ok {
+				from = v
+			}
+			continue
+		}
+		s := &sections[len(sections)-1]
+		if scriptSections[s.name] {
+			s.lines = append(s.lines, line)
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		s.lines = append(s.lines, trimmed)
+	}
+	return bootstrap, from, sections
+}
+
+// getDockerBaseImage returns the image of the FROM instruction of a Dockerfile. Only images
+// bootstrapped from docker can be used as is, the image of the Linux distribution from Docker Hub
+// is used with the other bootstrap agents, e.g., library or debootstrap. Local images, e.g., the
+// base image of layered builds, are not available to Docker.
+func getDockerBaseImage(bootstrap string, from string, data *DefFileData) (string, error) {
+	if bootstrap == "localimage" {
+		return "", fmt.Errorf("images bootstrapped from a local image are not supported")
+	}
+	if bootstrap == container.DockerBootstrap && from != "" {
+		return UpdateDistroCodename(from, data.DistroID.Codename), nil
+	}
+	if data.DistroID.Name == "" {
+		return "", fmt.Errorf("unable to find a Docker image for bootstrap agent %s", bootstrap)
+	}
+	tag := data.DistroID.Version
+	if data.DistroID.Codename != "" {
+		tag = data.DistroID.Codename
+	}
+	if tag == "" {
+		return data.DistroID.Name, nil
+	}
+	return data.DistroID.Name + ":" + tag, nil
+}
+
+// copyToContext copies a file or a directory of the host to the build context of a Dockerfile
+func copyToContext(src string, contextDir string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Dir(src), path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(contextDir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		return util.CopyFile(path, dst)
+	})
+}
+
+// getCopyInstruction returns the COPY instruction of a file of a files section, the file being
+// copied to the build context. Like the files section, a directory is copied in the destination
+// directory; a destination without extension, e.g., /opt, is assumed to be a directory.
+func getCopyInstruction(line string, defaultDir string, contextDir string) (string, error) {
+	tokens := strings.Fields(line)
+	src := filepath.Clean(tokens[0])
+	dst := src
+	if defaultDir != "" {
+		dst = defaultDir
+	}
+	if len(tokens) > 1 {
+		dst = tokens[1]
+	}
+
+	err := copyToContext(src, contextDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy %s to %s: %s", src, contextDir, err)
+	}
+
+	name := filepath.Base(src)
+	switch {
+	case util.IsDir(src):
+		if dst != src {
+			dst = filepath.Join(dst, name)
+		}
+	case filepath.Ext(dst) == "" && dst != src:
+		dst = strings.TrimSuffix(dst, "/") + "/"
+	}
+	return "COPY " + name + " " + dst + "\n", nil
+}
+
+// getEnvInstructions returns the ENV instructions of an environment section: variables set with or
+// without export become ENV instructions, export without value is implied by ENV and the other
+// commands, which cannot be translated, are kept as comments
+func getEnvInstructions(lines []string) string {
+	s := ""
+	for _, line := range lines {
+		assignment := strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		tokens := strings.SplitN(assignment, "=", 2)
+		if len(tokens) != 2 {
+			if !strings.HasPrefix(line, "export ") {
+				log.Printf("[WARN] unable to translate '%s' from the environment section to the Dockerfile", line)
+				s += "# " + line + "\n"
+			}
+			continue
+		}
+		s += "ENV " + tokens[0] + "=" + tokens[1] + "\n"
+	}
+	return s
+}
+
+// getRunInstructions returns the instructions executing a script section, e.g., the post section.
+// The script is written as is to the build context, so multi-line commands such as heredocs are
+// preserved, then copied in the image and, like the post section, executed by a single shell that
+// stops at the first error.
+func getRunInstructions(name string, lines []string, contextDir string) (string, error) {
+	if strings.TrimSpace(strings.Join(lines, "")) == "" {
+		return "", nil
+	}
+	path := filepath.Join(contextDir, name)
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+strings.Join(lines, "\n")+"\n"), 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	script := filepath.Join(dockerScriptDir, name)
+	return "COPY " + name + " " + script + "\nRUN sh -e " + script + " && rm -f " + script + "\n", nil
+}
+
+// CreateDockerfile creates a Dockerfile equivalent to a definition file, in the build context
+// directory contextDir: the bootstrap becomes the FROM instruction, the labels become LABEL
+// instructions, the files section COPY instructions, the environment section ENV instructions
+// and the post section a script executed by a RUN instruction. The files copied in the image and
+// the scripts are copied to the build context so the image can be built with
+// 'docker build <contextDir>'. The applications of a
+// multi-app container are installed in the same directories as with SCIF.
+func CreateDockerfile(defFile string, contextDir string, data *DefFileData) error {
+	content, err := ioutil.ReadFile(defFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", defFile, err)
+	}
+
+	bootstrap, from, sections := parseDefFile(string(content))
+	if bootstrap == "" {
+		return fmt.Errorf("%s does not specify a bootstrap agent", defFile)
+	}
+	baseImage, err := getDockerBaseImage(bootstrap, from, data)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(contextDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", contextDir, err)
+	}
+
+	dockerfile := "FROM " + baseImage + "\n\n"
+	var copies, env, run string
+	for _, s := range sections {
+		switch s.name {
+		case "%labels":
+			for _, line := range s.lines {
+				tokens := strings.SplitN(line, " ", 2)
+				value := ""
+				if len(tokens) == 2 {
+					value = strings.TrimSpace(tokens[1])
+				}
+				dockerfile += "LABEL " + tokens[0] + "=" + strconv.Quote(value) + "\n"
+			}
+		case "%files", "%appfiles":
+			appDir := ""
+			if s.name == "%appfiles" {
+				appDir = filepath.Join(SCIFAppsDir, s.arg)
+			}
+			for _, line := range s.lines {
+				instruction, err := getCopyInstruction(line, appDir, contextDir)
+				if err != nil {
+					return err
+				}
+				copies += instruction
+			}
+		case "%environment":
+			env += getEnvInstructions(s.lines)
+		case "%post":
+			instructions, err := getRunInstructions("post.sh", s.lines, contextDir)
+			if err != nil {
+				return err
+			}
+			run += instructions
+		case "%appinstall":
+			// Like SCIF, the installation of an application starts in its directory
+			appDir := filepath.Join(SCIFAppsDir, s.arg)
+			instructions, err := getRunInstructions("appinstall-"+s.arg+".sh", append([]string{"mkdir -p " + appDir, "cd " + appDir}, s.lines...), contextDir)
+			if err != nil {
+				return err
+			}
+			run += instructions
+		case "%apprun":
+			// The executables of the applications are referenced by the labels
+		default:
+			log.Printf("[WARN] section %s of %s is not supported in Dockerfiles", s.name, defFile)
+		}
+	}
+
+	// Like with Singularity, the files are copied before the post section is executed and the
+	// environment section only applies to the execution of the container, not to the post section
+	if copies != "" {
+		dockerfile += "\n" + copies
+	}
+	if run != "" {
+		dockerfile += "\n" + run
+	}
+	if env != "" {
+		dockerfile += "\n" + env
+	}
+
+	path := filepath.Join(contextDir, DockerfileName)
+	err = ioutil.WriteFile(path, []byte(dockerfile), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
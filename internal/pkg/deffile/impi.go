@@ -0,0 +1,272 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// impiOneAPIVersion is the first version of Intel MPI distributed with oneAPI
+	impiOneAPIVersion = "2021"
+
+	// impiLegacyInstallPath is the path of MPI in an installation of the Intel Parallel Studio
+	impiLegacyInstallPath = "compilers_and_libraries/linux/mpi/intel64"
+
+	// impiOneAPIInstallPath is the path of the latest version of MPI in an installation of oneAPI
+	impiOneAPIInstallPath = "mpi/latest"
+
+	// impiInstallerDir is the directory where the installer of Intel MPI is copied or downloaded in the container
+	impiInstallerDir = "/tmp/impi"
+
+	// impiSilentInstallConfig is the configuration of the silent installation of the legacy installer of
+	// Intel MPI, MPIINSTALLDIR being replaced by the installation directory
+	impiSilentInstallConfig = `ACCEPT_EULA=accept
+CONTINUE_WITH_OPTIONAL_ERROR=yes
+PSET_INSTALL_DIR=MPIINSTALLDIR
+CONTINUE_WITH_INSTALLDIR_OVERWRITE=yes
+COMPONENTS=DEFAULTS
+PSET_MODE=install
+SIGNING_ENABLED=yes
+ARCH_SELECTED=ALL
+`
+)
+
+// isIMPIOneAPI checks whether a version of Intel MPI is distributed with oneAPI, based on its
+// version or, when the version is not conclusive, on its installer (a shell script for oneAPI)
+func isIMPIOneAPI(mpi *implem.Info) bool {
+	if filepath.Ext(path.Base(mpi.URL)) == ".sh" {
+		return true
+	}
+	return implem.CompareVersions(mpi.Version, impiOneAPIVersion) >= 0
+}
+
+// getIMPIDir returns the directory with the bin and lib directories of Intel MPI once installed
+// in a given directory
+func getIMPIDir(mpi *implem.Info, installDir string) string {
+	if isIMPIOneAPI(mpi) {
+		return filepath.Join(installDir, impiOneAPIInstallPath)
+	}
+	return filepath.Join(installDir, impiLegacyInstallPath)
+}
+
+// getIMPIInstallerTarArgs returns the arguments of tar to unpack the legacy installer of Intel MPI
+func getIMPIInstallerTarArgs(installer string) (string, error) {
+	if filepath.Ext(installer) == ".tgz" {
+		return "-xzf", nil
+	}
+	tarArgs := util.GetTarArgs(util.DetectTarballFormat(installer))
+	if tarArgs == "" {
+		return "", fmt.Errorf("un-supported format for the installer %s", installer)
+	}
+	return tarArgs, nil
+}
+
+// addIMPIFiles adds the installer of Intel MPI to the files section when it is available on the
+// host, which is the case for the installers requiring a registration
+func addIMPIFiles(f *os.File, app *app.Info, data *DefFileData, sysCfg *sys.Config) error {
+	hasFilesSection := needsFilesSection(app)
+	if hasFilesSection {
+		err := createFilesSection(f, app, data, sysCfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	if util.DetectURLType(data.MpiImplm.URL) != util.FileURL {
+		return nil
+	}
+	if !hasFilesSection {
+		_, err := f.WriteString("%files\n")
+		if err != nil {
+			return fmt.Errorf("failed to write to definition file: %s", err)
+		}
+	}
+	installer := strings.Replace(data.MpiImplm.URL, "file://", "", 1)
+	_, err := f.WriteString("\t" + installer + " " + impiInstallerDir + "/\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+	return nil
+}
+
+// addIMPIEnv adds the environment of Intel MPI, which relies on libfabric, to the definition file
+func addIMPIEnv(f *os.File, data *DefFileData, sysCfg *sys.Config) error {
+	mpiDir := getIMPIDir(data.MpiImplm, data.InternalEnv.InstallDir)
+	ldPath := "$MPI_DIR/lib:$MPI_DIR/libfabric/lib"
+	if isIMPIOneAPI(data.MpiImplm) {
+		// The release version of the library is in a sub-directory with oneAPI
+		ldPath = "$MPI_DIR/lib/release:" + ldPath
+	}
+
+	env := "%environment\n\tMPI_DIR=" + mpiDir + "\n\texport MPI_DIR\n"
+	env += "\texport PATH=$MPI_DIR/bin:$MPI_DIR/libfabric/bin:$PATH\n"
+	env += "\texport LD_LIBRARY_PATH=" + ldPath + ":$LD_LIBRARY_PATH\n"
+	env += "\texport FI_PROVIDER_PATH=$MPI_DIR/libfabric/lib/prov\n"
+	env += "\texport I_MPI_FABRICS=ofi\n\texport FI_PROVIDER=sockets\n"
+	if sysCfg.Ifnet != "" {
+		env += "\texport FI_SOCKETS_IFACE=" + sysCfg.Ifnet + "\n"
+	}
+	_, err := f.WriteString(env + getMPIEnv(data) + "\n")
+	return err
+}
+
+// GetIMPIContainerEnv returns the commands setting up the runtime environment of Intel MPI in a
+// container, MPI_DIR being the directory with the bin and lib directories. They export what the
+// vars.sh (oneAPI) and mpivars.sh (legacy) scripts would, the scripts not being able to find the
+// installation when sourced by the shell executing the environment section.
+func GetIMPIContainerEnv(mpi *implem.Info) []string {
+	manDir := "$MPI_DIR/man"
+	if !isIMPIOneAPI(mpi) {
+		// The legacy layout has the man pages next to the intel64 directory
+		manDir = "$MPI_DIR/../man"
+	}
+	return []string{
+		"export I_MPI_ROOT=$MPI_DIR",
+		"export CLASSPATH=$MPI_DIR/lib/mpi.jar:$CLASSPATH",
+		"export MANPATH=" + manDir + ":$MANPATH",
+	}
+}
+
+// addIMPIInstall adds the installation of Intel MPI to the post section of the definition file,
+// using the oneAPI offline installer or the legacy installer and its silent configuration
+func addIMPIInstall(f *os.File, data *DefFileData) error {
+	installer := path.Base(data.MpiImplm.URL)
+	installDir := data.InternalEnv.InstallDir
+
+	var pkgCmd string
+	switch data.DistroID.Name {
+	case "ubuntu":
+		pkgCmd = "apt-get install -y cpio"
+	case "centos":
+		pkgCmd = "yum -y install cpio which"
+	default:
+		return fmt.Errorf("unsupported distro: %s", data.DistroID.Name)
+	}
+
+	cmds := []string{
+		pkgCmd,
+		"export MPI_VERSION=" + data.MpiImplm.Version,
+		"mkdir -p " + impiInstallerDir + " " + installDir,
+	}
+	if util.DetectURLType(data.MpiImplm.URL) != util.FileURL {
+		cmds = append(cmds, "cd "+impiInstallerDir+" && wget \""+data.MpiImplm.URL+"\"")
+	}
+
+	if isIMPIOneAPI(data.MpiImplm) {
+		cmds = append(cmds, "cd "+impiInstallerDir+" && sh ./"+installer+" -a -s --eula accept --install-dir "+installDir)
+	} else {
+		tarArgs, err := getIMPIInstallerTarArgs(installer)
+		if err != nil {
+			return err
+		}
+		silentConfig := strings.Replace(impiSilentInstallConfig, "MPIINSTALLDIR", installDir, -1)
+		cmds = append(cmds,
+			"cat > "+impiInstallerDir+"/silent_install.cfg << EOF\n"+silentConfig+"EOF",
+			"cd "+impiInstallerDir+" && tar "+tarArgs+" "+installer,
+			"cd "+impiInstallerDir+"/`ls -l "+impiInstallerDir+" | egrep '^d' | head -1 | awk '{print $9}'` && ./install.sh --silent "+impiInstallerDir+"/silent_install.cfg")
+	}
+
+	cmds = append(cmds,
+		"rm -rf "+impiInstallerDir,
+		"export MPI_DIR="+getIMPIDir(data.MpiImplm, installDir),
+		"export PATH=$MPI_DIR/bin:$PATH\n\texport LD_LIBRARY_PATH=$MPI_DIR/lib/release:$MPI_DIR/lib:$MPI_DIR/libfabric/lib:$LD_LIBRARY_PATH")
+
+	_, err := f.WriteString("\t" + strings.Join(cmds, "\n\t") + "\n\n")
+	return err
+}
+
+// CreateIMPIDefFile creates a definition file for a hybrid configuration based on Intel MPI, which
+// is installed with its own installer. Both the legacy layout of the Intel Parallel Studio and
+// the layout of oneAPI are supported.
+func CreateIMPIDefFile(app *app.Info, data *DefFileData, sysCfg *sys.Config) error {
+	// Some sanity checks
+	if data.Path == "" || data.MpiImplm == nil || data.MpiImplm.URL == "" || data.InternalEnv == nil || data.InternalEnv.InstallDir == "" {
+		return fmt.Errorf("invalid parameter(s)")
+	}
+	if !isIMPIOneAPI(data.MpiImplm) {
+		_, err := getIMPIInstallerTarArgs(path.Base(data.MpiImplm.URL))
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("- Defintion file is %s\n", data.Path)
+	f, err := os.Create(data.Path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", data.Path, err)
+	}
+	defer f.Close()
+
+	err = AddBootstrap(f, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create the bootstrap section of the definition file: %s", err)
+	}
+
+	err = addLabels(f, app, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the labels section of the definition file: %s", err)
+	}
+
+	err = addIMPIFiles(f, app, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the definition file: %s", err)
+	}
+
+	err = addTuningFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the tuning file: %s", err)
+	}
+
+	err = addIMPIEnv(f, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create the environment section of the definition file: %s", err)
+	}
+
+	err = addDistroInit(f, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to add the code initializing the distro: %s", err)
+	}
+
+	err = addAppDownload(f, app, data)
+	if err != nil {
+		return fmt.Errorf("failed to add the section to download the app: %s", err)
+	}
+
+	err = addIMPIInstall(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+	}
+
+	if len(data.Apps) == 0 {
+		err = addAppInstall(f, app, data)
+		if err != nil {
+			return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+		}
+	}
+
+	err = addCheckpointToolInstall(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to add the installation of the checkpointing tool: %s", err)
+	}
+
+	err = addSCIFApps(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the application sections of the definition file: %s", err)
+	}
+
+	return nil
+}
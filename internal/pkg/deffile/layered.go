@@ -0,0 +1,151 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// addBaseLabels adds the labels describing a base image, i.e., an image with only the Linux
+// distribution and MPI
+func addBaseLabels(f *os.File, deffile *DefFileData) error {
+	_, err := f.WriteString("%labels\n\tLinux_distribution " + deffile.DistroID.Name + "\n\tLinux_version " + deffile.DistroID.Version + "\n")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString("\tMPI_Implementation " + deffile.MpiImplm.ID + "\n\tMPI_Version " + deffile.MpiImplm.Version + "\n\n")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateHybridBaseDefFile creates the definition file of the base image used when building
+// hybrid containers in layers: the base image only includes the Linux distribution and MPI so
+// it can be cached and reused when the application changes.
+func CreateHybridBaseDefFile(data *DefFileData, sysCfg *sys.Config) error {
+	// Some sanity checks
+	if data.Path == "" || data.MpiImplm == nil || data.InternalEnv == nil {
+		return fmt.Errorf("invalid parameter(s)")
+	}
+
+	log.Printf("- Definition file of the base image is %s\n", data.Path)
+	f, err := os.Create(data.Path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", data.Path, err)
+	}
+	defer f.Close()
+
+	err = AddBootstrap(f, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create the bootstrap section of the definition file: %s", err)
+	}
+
+	err = addBaseLabels(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the labels section of the definition file: %s", err)
+	}
+
+	err = addToolchainFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the toolchain: %s", err)
+	}
+
+	err = addTuningFiles(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the files section of the tuning file: %s", err)
+	}
+
+	err = addMPIEnv(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the environment section of the definition file: %s", err)
+	}
+
+	err = addDistroInit(f, data, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to add the code initializing the distro: %s", err)
+	}
+
+	err = AddMPIInstall(f, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+	}
+
+	// The application is installed in /opt on top of the base image so /opt must be left empty
+	_, err = f.WriteString("\trm -rf $MPI_BUILDDIR\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to add MPI cleanup section: %s", err)
+	}
+
+	return nil
+}
+
+// CreateHybridAppDefFile creates the definition file of the application layer of a hybrid
+// container built in layers, i.e., the application is installed on top of a base image
+// created with CreateHybridBaseDefFile
+func CreateHybridAppDefFile(app *app.Info, data *DefFileData, baseImage string, sysCfg *sys.Config) error {
+	// Some sanity checks
+	if data.Path == "" || baseImage == "" || data.InternalEnv == nil {
+		return fmt.Errorf("invalid parameter(s)")
+	}
+
+	log.Printf("- Definition file of the application layer is %s\n", data.Path)
+	f, err := os.Create(data.Path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", data.Path, err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString("Bootstrap: localimage\nFrom: " + baseImage + "\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to add bootstrap section to definition file: %s", err)
+	}
+
+	err = addLabels(f, app, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the labels section of the definition file: %s", err)
+	}
+
+	if needsFilesSection(app) {
+		err = createFilesSection(f, app, data, sysCfg)
+		if err != nil {
+			return fmt.Errorf("failed to create the files section of the definition file: %s", err)
+		}
+	}
+
+	// The environment of the base image is not available in the post section
+	_, err = f.WriteString("%post\n" + getToolchainPostEnv(data) + "\texport MPI_DIR=" + data.InternalEnv.InstallDir + "\n\texport PATH=$MPI_DIR/bin:$PATH\n\texport LD_LIBRARY_PATH=$MPI_DIR/lib:$LD_LIBRARY_PATH\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+	}
+
+	if len(data.Apps) > 0 {
+		err = addSCIFApps(f, data)
+		if err != nil {
+			return fmt.Errorf("failed to create the application sections of the definition file: %s", err)
+		}
+		return nil
+	}
+
+	err = addAppDownload(f, app, data)
+	if err != nil {
+		return fmt.Errorf("failed to add the section to download the app: %s", err)
+	}
+
+	err = addAppInstall(f, app, data)
+	if err != nil {
+		return fmt.Errorf("failed to create the post section of the definition file: %s", err)
+	}
+
+	return nil
+}
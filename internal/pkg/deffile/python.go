@@ -0,0 +1,143 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+)
+
+const (
+	// minicondaURL is the URL of the installer of conda, for the architecture of the container
+	// given by uname -m when the image is built, e.g., x86_64, aarch64 or ppc64le
+	minicondaURL = "https://repo.anaconda.com/miniconda/Miniconda3-latest-Linux-$(uname -m).sh"
+
+	// defaultPythonInstallCmd is the command used to install a Python application from its sources
+	defaultPythonInstallCmd = "python3 -m pip install --no-cache-dir ."
+)
+
+// getPythonRequirementsPath returns the path to the requirements file of a Python application in the container
+func getPythonRequirementsPath(info *app.Info) string {
+	return filepath.Join("/opt", filepath.Base(info.Python.Requirements))
+}
+
+// getSourceType returns the type of the URL of the source of an application, an empty string when
+// the application has no source, e.g., a Python application only coming from PyPI
+func getSourceType(info *app.Info) string {
+	if info.Source == "" {
+		return ""
+	}
+	return util.DetectURLType(info.Source)
+}
+
+// needsFilesSection checks whether files from the host must be copied in a hybrid container
+func needsFilesSection(info *app.Info) bool {
+	return getSourceType(info) == util.FileURL || (info.IsPython() && info.Python.Requirements != "")
+}
+
+// addPythonDistroPackages adds the installation of Python and pip with the package manager of the Linux distribution
+func addPythonDistroPackages(f *os.File, data *DefFileData) error {
+	var cmd string
+	switch data.DistroID.Name {
+	case "ubuntu":
+		cmd = "apt-get install -y python3 python3-pip python3-dev"
+	case "centos":
+		cmd = "yum -y install python3 python3-pip python3-devel"
+	default:
+		return fmt.Errorf("unsupported Linux distribution for Python applications: %s", data.DistroID.Name)
+	}
+	_, err := f.WriteString("\t" + cmd + "\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+	return nil
+}
+
+// addConda adds the installation of conda and of the requested version of Python
+func addConda(f *os.File, info *app.Info) error {
+	python := "python=3"
+	if info.Python.Version != "" {
+		python = "python=" + info.Python.Version
+	}
+	cmds := []string{
+		"wget -q " + minicondaURL + " -O /tmp/miniconda.sh && bash /tmp/miniconda.sh -b -p " + app.CondaDir + " && rm -f /tmp/miniconda.sh",
+		"export PATH=" + app.CondaDir + "/bin:$PATH",
+		"echo 'export PATH=" + app.CondaDir + "/bin:$PATH' >> $SINGULARITY_ENVIRONMENT",
+		"conda install -y " + python,
+	}
+	if info.Python.CondaInstall != "" {
+		cmds = append(cmds, "conda install -y "+info.Python.CondaInstall)
+	}
+	for _, cmd := range cmds {
+		_, err := f.WriteString("\t" + cmd + "\n")
+		if err != nil {
+			return fmt.Errorf("failed to write to definition file: %s", err)
+		}
+	}
+	return nil
+}
+
+// addPythonAppInstall adds the installation of a Python application: Python itself, mpi4py
+// compiled against the MPI of the container, the requirements and the application
+func addPythonAppInstall(f *os.File, info *app.Info, data *DefFileData) error {
+	var err error
+	if info.UseConda() {
+		err = addConda(f, info)
+	} else {
+		err = addPythonDistroPackages(f, data)
+	}
+	if err != nil {
+		return err
+	}
+
+	// mpi4py must be compiled from source to use the MPI of the container, not a binary package
+	cmds := []string{"MPICC=$MPI_DIR/bin/mpicc python3 -m pip install --no-cache-dir --no-binary=mpi4py mpi4py"}
+	if info.Python.Requirements != "" {
+		cmds = append(cmds, "python3 -m pip install --no-cache-dir -r "+getPythonRequirementsPath(info))
+	}
+	if info.Python.PipInstall != "" {
+		cmds = append(cmds, "python3 -m pip install --no-cache-dir "+info.Python.PipInstall)
+	}
+
+	switch getSourceType(info) {
+	case "":
+		// The application only comes from PyPI or conda
+	case util.FileURL:
+		if appIsLocalDir(info) {
+			installCmd := defaultPythonInstallCmd
+			if info.InstallCmd != "" {
+				installCmd = info.InstallCmd
+			}
+			cmds = append(cmds, "cd /opt/$APPDIR && "+installCmd)
+		} else if info.InstallCmd != "" {
+			// Scripts are copied in /opt by the files section and do not need to be installed
+			cmds = append(cmds, "cd /opt && "+info.InstallCmd)
+		}
+	default:
+		installCmd := defaultPythonInstallCmd
+		if info.InstallCmd != "" {
+			installCmd = info.InstallCmd
+		}
+		cmds = append(cmds, "cd /opt/$APPDIR && "+installCmd)
+	}
+
+	for _, cmd := range cmds {
+		_, err := f.WriteString("\t" + cmd + "\n")
+		if err != nil {
+			return fmt.Errorf("failed to write to definition file: %s", err)
+		}
+	}
+	_, err = f.WriteString("\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+
+	return nil
+}
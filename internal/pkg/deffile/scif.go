@@ -0,0 +1,149 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+const (
+	// SCIFAppsDir is the directory where the applications of a multi-app container are installed
+	SCIFAppsDir = "/scif/apps"
+)
+
+// GetSCIFAppExe returns the path to the executable of an application of a multi-app container
+func GetSCIFAppExe(info *app.Info) string {
+	return filepath.Join(SCIFAppsDir, info.Name, "bin", info.BinName)
+}
+
+// addSCIFLabels adds the labels listing the applications of a multi-app container and their executable
+func addSCIFLabels(f *os.File, data *DefFileData) error {
+	if len(data.Apps) == 0 {
+		return nil
+	}
+
+	var names []string
+	for _, a := range data.Apps {
+		names = append(names, a.Name)
+	}
+	_, err := f.WriteString("\t" + container.AppsLabel + " " + strings.Join(names, ",") + "\n")
+	if err != nil {
+		return err
+	}
+	for i := range data.Apps {
+		_, err = f.WriteString("\t" + container.AppExeLabelPrefix + data.Apps[i].Name + " " + GetSCIFAppExe(&data.Apps[i]) + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSCIFAppInstall returns the commands installing an application in its own directory
+func getSCIFAppInstall(info *app.Info, data *DefFileData) ([]string, error) {
+	root := filepath.Join(SCIFAppsDir, info.Name)
+	installCmd := "make install"
+	if info.InstallCmd != "" {
+		installCmd = info.InstallCmd
+	}
+
+	// The environment of the container is not available when installing applications
+	cmds := []string{
+		"export MPI_DIR=" + data.InternalEnv.InstallDir,
+		"export PATH=$MPI_DIR/bin:$PATH",
+		"export LD_LIBRARY_PATH=$MPI_DIR/lib:$LD_LIBRARY_PATH",
+		"cd " + root,
+	}
+
+	switch getSourceType(info) {
+	case util.GitURL:
+		cmds = append(cmds, "git clone "+info.Source, "APPDIR="+strings.TrimSuffix(path.Base(info.Source), ".git"))
+	case util.HttpURL:
+		tarArgs := util.GetTarArgs(util.DetectTarballFormat(info.Source))
+		// The source is in the top-level directory of the tarball; the directory of the application
+		// already has a bin directory so it cannot be guessed from the content of the directory
+		tarball := path.Base(info.Source)
+		cmds = append(cmds, "wget "+info.Source+" && tar "+tarArgs+" "+tarball, "APPDIR=`tar -tf "+tarball+" | head -1 | cut -d/ -f1`")
+	case util.FileURL:
+		if appIsLocalDir(info) {
+			cmds = append(cmds, "APPDIR="+getAppLocalDirName(info))
+			break
+		}
+		// Single source file copied by the files section of the application
+		cmds = append(cmds, "APPDIR=.")
+		if info.InstallCmd == "" {
+			compiler := implem.GetCompiler(data.MpiImplm, filepath.Ext(info.Source) == ".f90")
+			installCmd = compiler + " -o " + info.BinName + " " + filepath.Base(info.Source)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported URL for application %s: %s", info.Name, info.Source)
+	}
+
+	cmds = append(cmds,
+		"cd "+root+"/$APPDIR && "+installCmd,
+		"mkdir -p "+root+"/bin && ln -sf "+root+"/$APPDIR/"+info.BinName+" "+GetSCIFAppExe(info))
+
+	return cmds, nil
+}
+
+// addSCIFApp adds the SCIF sections of an application of a multi-app container: the files to
+// copy, the installation of the application in its own directory and how to run it
+func addSCIFApp(f *os.File, info *app.Info, data *DefFileData) error {
+	if info.Name == "" || info.Source == "" || info.BinName == "" {
+		return fmt.Errorf("invalid application: name, URL and executable are required")
+	}
+
+	err := app.PrepareTestSource(info)
+	if err != nil {
+		return fmt.Errorf("failed to write the source of %s: %s", info.Name, err)
+	}
+
+	// The files are copied in the directory of the application, where getSCIFAppInstall expects them
+	if getSourceType(info) == util.FileURL {
+		src := filepath.Clean(app.GetLocalPath(info.Source))
+		_, err := f.WriteString("%appfiles " + info.Name + "\n\t" + src + " " + filepath.Join(SCIFAppsDir, info.Name, filepath.Base(src)) + "\n\n")
+		if err != nil {
+			return fmt.Errorf("failed to write to definition file: %s", err)
+		}
+	}
+
+	cmds, err := getSCIFAppInstall(info, data)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("%appinstall " + info.Name + "\n\t" + strings.Join(cmds, "\n\t") + "\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+
+	_, err = f.WriteString("%apprun " + info.Name + "\n\texec " + GetSCIFAppExe(info) + " \"$@\"\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+
+	return nil
+}
+
+// addSCIFApps adds the SCIF sections of all the applications of a multi-app container
+func addSCIFApps(f *os.File, data *DefFileData) error {
+	for i := range data.Apps {
+		err := addSCIFApp(f, &data.Apps[i], data)
+		if err != nil {
+			return fmt.Errorf("failed to add application %s: %s", data.Apps[i].Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// hasVendorToolchain checks whether MPI and the application are built with a toolchain other than
+// the compilers of the Linux distribution
+func hasVendorToolchain(deffile *DefFileData) bool {
+	return deffile.Toolchain != nil && !deffile.Toolchain.IsDefault()
+}
+
+// GetToolchainBuildArgs returns the flags of 'singularity build' making the toolchain of the host
+// available, read-only and at the same location, while the image is built, so MPI and the
+// application are built with the same compilers as on the host without copying the compilers in
+// the image
+func GetToolchainBuildArgs(deffile *DefFileData) []string {
+	if !hasVendorToolchain(deffile) {
+		return nil
+	}
+	return []string{"--bind", deffile.Toolchain.Root + ":" + deffile.Toolchain.Root + ":ro"}
+}
+
+// addToolchainFiles adds a files section copying the runtime libraries of the toolchain of the
+// host in the container, at the same location, so they are available when the application runs;
+// the compilers are only available while the image is built (see GetToolchainBuildArgs)
+func addToolchainFiles(f *os.File, deffile *DefFileData) error {
+	if !hasVendorToolchain(deffile) {
+		return nil
+	}
+
+	libDir := deffile.Toolchain.GetLibPath()
+	_, err := f.WriteString("%files\n\t" + libDir + " " + libDir + "\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+	return nil
+}
+
+// getToolchainPostEnv returns the lines of the post section selecting the compilers of the
+// toolchain, e.g., 'export CC="nvc"'
+func getToolchainPostEnv(deffile *DefFileData) string {
+	if !hasVendorToolchain(deffile) {
+		return ""
+	}
+
+	env := ""
+	for _, v := range deffile.Toolchain.GetEnv("$PATH", "$LD_LIBRARY_PATH") {
+		tokens := strings.SplitN(v, "=", 2)
+		env += "\texport " + tokens[0] + "=\"" + tokens[1] + "\"\n"
+	}
+	return env
+}
+
+// getToolchainRuntimeEnv returns the lines of the environment section making the runtime
+// libraries of the toolchain available to MPI and the application
+func getToolchainRuntimeEnv(deffile *DefFileData) string {
+	if !hasVendorToolchain(deffile) {
+		return ""
+	}
+	return "\texport LD_LIBRARY_PATH=" + deffile.Toolchain.GetLibPath() + ":$LD_LIBRARY_PATH\n"
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deffile
+
+import (
+	"fmt"
+	"os"
+)
+
+// addTuningFiles adds a files section copying the tuning file of the site in the container, where
+// the environment of the container points MPI to it
+func addTuningFiles(f *os.File, deffile *DefFileData) error {
+	if deffile.Tuning == nil {
+		return nil
+	}
+
+	_, err := f.WriteString("%files\n\t" + deffile.Tuning.File + " " + deffile.Tuning.ContainerPath() + "\n\n")
+	if err != nil {
+		return fmt.Errorf("failed to write to definition file: %s", err)
+	}
+	return nil
+}
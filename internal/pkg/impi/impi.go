@@ -18,6 +18,7 @@ import (
 	"github.com/gvallee/go_util/pkg/util"
 	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
@@ -73,10 +74,12 @@ func updateIntelMPIDefFile(impiCfg *Config, env *buildenv.Info, sysCfg *sys.Conf
 	// First we need to specialy prepare install & uninstall configuration file
 	// Assumptions:
 	// - code unpacked in /tmp/impi
-	// - code installed in /opt/impi (but remember that the binaries and libraries are deep a sub-directory)
-	const (
-		containerIMPIInstallDir = "/opt/impi"
-	)
+	// - code installed in /opt/impi, or the MPI prefix of the configuration (but remember that the
+	//   binaries and libraries are deep a sub-directory)
+	containerIMPIInstallDir := "/opt/impi"
+	if sysCfg.ContainerMPIPrefix != "" {
+		containerIMPIInstallDir = container.ExpandMPIPrefix(sysCfg.ContainerMPIPrefix, impiCfg.Info.ID, impiCfg.Info.Version)
+	}
 
 	if impiCfg.Info.Tarball == "" {
 		impiCfg.Info.Tarball = path.Base(impiCfg.Info.URL)
@@ -218,21 +221,30 @@ func RunScript(env *buildenv.Info, sysCfg *sys.Config, phase string) syexec.Resu
 	// Run the install or uninstall script
 	var stdout, stderr bytes.Buffer
 	cmd := exec.Command("./install.sh", "--silent", configFile)
+	if env.Builder != nil {
+		bin, args, cmdEnv := env.Builder.Wrap("./install.sh", []string{"--silent", configFile}, env.SrcDir, nil)
+		cmd = exec.Command(bin, args...)
+		cmd.Env = cmdEnv
+	}
 	cmd.Dir = env.SrcDir
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
-	res.Err = cmd.Run()
+	res.Err = syexec.RunCmd(cmd)
 	res.Stderr = stderr.String()
 	res.Stdout = stdout.String()
 
 	return res
 }
 
-// GetExtraMpirunArgs returns all the required additional arguments required to use
+// IntelGetExtraMpirunArgs returns all the required additional arguments required to use
 // mpirun for a given configuration of MPI
 func IntelGetExtraMpirunArgs(mpiCfg *Config, sys *sys.Config) []string {
 	// Intel MPI is based on OFI so even for a simple TCP test, we need some extra arguments
-	return []string{"-env", "FI_PROVIDER", "socket", "-env", "I_MPI_FABRICS", "ofi"}
+	extraArgs := []string{"-env", "FI_PROVIDER", "socket", "-env", "I_MPI_FABRICS", "ofi"}
+	if sys.Ifnet != "" {
+		extraArgs = append(extraArgs, "-iface", sys.Ifnet)
+	}
+	return extraArgs
 }
 
 // IntelGetConfigureExtraArgs returns the extra arguments required to configure IMPI
@@ -0,0 +1,33 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package impi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestMpirunArgs(t *testing.T) {
+	tests := []struct {
+		ifnet    string
+		expected string
+	}{
+		{expected: "-env FI_PROVIDER socket -env I_MPI_FABRICS ofi"},
+		{ifnet: "ib0", expected: "-env FI_PROVIDER socket -env I_MPI_FABRICS ofi -iface ib0"},
+	}
+
+	for _, tt := range tests {
+		sysCfg := sys.Config{Ifnet: tt.ifnet}
+		args := strings.Join(mpiplugin.Get(implem.IMPI).MpirunArgs(&implem.Info{ID: implem.IMPI, Version: "2019.6.166"}, nil, &sysCfg), " ")
+		if args != tt.expected {
+			t.Fatalf("mpirun arguments with interface '%s' are '%s' instead of '%s'", tt.ifnet, args, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package impi
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// intelMPI is the implementation of the mpiplugin.MPIImplementation interface for Intel MPI,
+// which is installed with its own installer, both on the host and in containers. Intel MPI is
+// also installing the binaries and libraries in a quite complex setup.
+type intelMPI struct {
+	mpiplugin.Base
+}
+
+func init() {
+	mpiplugin.Register(&intelMPI{mpiplugin.Base{Name: implem.IMPI}})
+}
+
+func (i *intelMPI) DeffileTemplateTags() deffile.TemplateTags {
+	return GetDeffileTemplateTags()
+}
+
+func (i *intelMPI) DeffileTemplate(distroName string, sysCfg *sys.Config) string {
+	switch {
+	case sysCfg.IMB:
+		return distroName + "_intel_imb.def"
+	case sysCfg.NetPipe:
+		return distroName + "_intel_netpipe.def"
+	}
+	return distroName + "_intel.def"
+}
+
+// CreateHybridDeffile generates the definition file without any template, the templates of
+// DeffileTemplate being only used as a fallback
+func (i *intelMPI) CreateHybridDeffile(info *app.Info, data *deffile.DefFileData, sysCfg *sys.Config) error {
+	return deffile.CreateIMPIDefFile(info, data, sysCfg)
+}
+
+func (i *intelMPI) ContainerEnv(pkg *implem.Info, sysCfg *sys.Config) []string {
+	return deffile.GetIMPIContainerEnv(pkg)
+}
+
+// RelocationEnv sets I_MPI_ROOT, which Intel MPI uses to find its files, e.g., the libfabric
+// providers, instead of the directory where it was installed
+func (i *intelMPI) RelocationEnv(pkg *implem.Info, prefix string) []string {
+	return []string{"I_MPI_ROOT=" + filepath.Join(prefix, IntelInstallPathPrefix)}
+}
+
+// TuningEnv points Intel MPI to a tuning file of the site, e.g., generated with mpitune
+func (i *intelMPI) TuningEnv(pkg *implem.Info, path string) []string {
+	return []string{"I_MPI_TUNING_BIN=" + path}
+}
+
+// MpirunArgs configures OFI, which Intel MPI requires even for a simple TCP test, and the network
+// interface of the system configuration, if any
+func (i *intelMPI) MpirunArgs(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) []string {
+	return IntelGetExtraMpirunArgs(nil, sysCfg)
+}
+
+func (i *intelMPI) MpirunPath(env *buildenv.Info) string {
+	return GetPathToMpirun(env)
+}
+
+func (i *intelMPI) EnvPath(env *buildenv.Info) string {
+	return filepath.Join(env.InstallDir, IntelInstallPathPrefix, "bin") + ":" + os.Getenv("PATH")
+}
+
+func (i *intelMPI) EnvLDPath(env *buildenv.Info) string {
+	return filepath.Join(env.InstallDir, IntelInstallPathPrefix, "lib") + ":" + os.Getenv("LD_LIBRARY_PATH")
+}
+
+func (i *intelMPI) HasInstaller() bool {
+	return true
+}
+
+func (i *intelMPI) Install(env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	var res syexec.Result
+	res.Err = SetupInstallScript(env, sysCfg)
+	if res.Err != nil {
+		return res
+	}
+	return RunScript(env, sysCfg, "install")
+}
+
+func (i *intelMPI) Uninstall(env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	return RunScript(env, sysCfg, "uninstall")
+}
+
+func (i *intelMPI) LibraryVersionPrefix() string {
+	return "Intel(R) MPI Library"
+}
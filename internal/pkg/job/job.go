@@ -60,4 +60,18 @@ type Job struct {
 
 	// Args is a set of arguments to be used for launching the job
 	Args []string
+
+	// Hostfile is the path to the hostfile to use for the job (optional)
+	Hostfile string
+
+	// Rankfile is the path to the rankfile to use for the job (optional)
+	Rankfile string
+
+	// Wrapper is the command executing each rank, inserted between mpirun and singularity exec,
+	// e.g., valgrind (optional)
+	Wrapper []string
+
+	// AppWrapper is the command executing the application in the container, inserted between the
+	// image and the application, e.g., dmtcp_launch (optional)
+	AppWrapper []string
 }
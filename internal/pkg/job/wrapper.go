@@ -0,0 +1,91 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package job
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// WrapperValgrind is the preset running each rank under valgrind, one log per process
+	WrapperValgrind = "valgrind"
+
+	// WrapperStrace is the preset running each rank under strace, one trace per process
+	WrapperStrace = "strace"
+
+	// WrapperPerf is the preset running each rank under 'perf stat', one report per rank
+	WrapperPerf = "perf"
+
+	// WrapperOutputDirTag is the tag replaced by the directory where the output files of the
+	// wrapper are saved in a custom wrapper, e.g., "ltrace -f -o #OUTDIR/ltrace.txt"
+	WrapperOutputDirTag = "#OUTDIR"
+)
+
+// GetWrapperPresets returns the names of the predefined wrappers
+func GetWrapperPresets() []string {
+	return []string{WrapperValgrind, WrapperStrace, WrapperPerf}
+}
+
+// GetWrapper returns the command executing each rank from either the name of a preset or a
+// custom command, the output files of the wrapper being saved in outputDir
+func GetWrapper(spec string, outputDir string) ([]string, error) {
+	switch spec {
+	case WrapperValgrind:
+		return []string{"valgrind", "--trace-children=yes", "--log-file=" + filepath.Join(outputDir, "valgrind.%p.log")}, nil
+	case WrapperStrace:
+		return []string{"strace", "-ff", "-o", filepath.Join(outputDir, "strace")}, nil
+	case WrapperPerf:
+		// perf does not expand the PID in the name of its output file so a shell does it
+		return []string{"sh", "-c", `exec perf stat -o "$0/perf-stat.$$.txt" "$@"`, outputDir}, nil
+	}
+
+	wrapper := strings.Fields(spec)
+	if len(wrapper) == 0 {
+		return nil, fmt.Errorf("empty wrapper")
+	}
+	for i := range wrapper {
+		wrapper[i] = strings.ReplaceAll(wrapper[i], WrapperOutputDirTag, outputDir)
+	}
+	return wrapper, nil
+}
+
+// wrapApp inserts the wrapper of the application of a job between the image of the container and
+// the application
+func (j *Job) wrapApp(args []string) []string {
+	if len(j.AppWrapper) == 0 || j.Container == nil {
+		return args
+	}
+	for i, a := range args {
+		if a == j.Container.Path {
+			wrapped := append([]string{}, args[:i+1]...)
+			wrapped = append(wrapped, j.AppWrapper...)
+			return append(wrapped, args[i+1:]...)
+		}
+	}
+	return args
+}
+
+// Wrap inserts the wrapper of a job before the command executing a rank, i.e., between mpirun
+// and singularity exec, the arguments being the arguments of mpirun; the wrapper of the
+// application, if any, is inserted before the application
+func (j *Job) Wrap(args []string) []string {
+	args = j.wrapApp(args)
+	if len(j.Wrapper) == 0 {
+		return args
+	}
+	for i, a := range args {
+		if base := filepath.Base(a); base == sys.RuntimeSingularity || base == sys.RuntimeApptainer {
+			wrapped := append([]string{}, args[:i]...)
+			wrapped = append(wrapped, j.Wrapper...)
+			return append(wrapped, args[i:]...)
+		}
+	}
+	return append(append([]string{}, j.Wrapper...), args...)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package job
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/container"
+)
+
+func TestWrap(t *testing.T) {
+	mpirunArgs := []string{"--mca", "btl", "self,vader", "/usr/bin/singularity", "exec", "app.sif", "/opt/app"}
+
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{
+			spec:     "",
+			expected: "--mca btl self,vader /usr/bin/singularity exec app.sif /opt/app",
+		},
+		{
+			spec:     WrapperValgrind,
+			expected: "--mca btl self,vader valgrind --trace-children=yes --log-file=/out/valgrind.%p.log /usr/bin/singularity exec app.sif /opt/app",
+		},
+		{
+			spec:     WrapperStrace,
+			expected: "--mca btl self,vader strace -ff -o /out/strace /usr/bin/singularity exec app.sif /opt/app",
+		},
+		{
+			spec:     "ltrace -f -o #OUTDIR/ltrace.txt",
+			expected: "--mca btl self,vader ltrace -f -o /out/ltrace.txt /usr/bin/singularity exec app.sif /opt/app",
+		},
+	}
+
+	for _, tt := range tests {
+		var j Job
+		if tt.spec != "" {
+			var err error
+			j.Wrapper, err = GetWrapper(tt.spec, "/out")
+			if err != nil {
+				t.Fatalf("GetWrapper(%s) failed: %s", tt.spec, err)
+			}
+		}
+		args := strings.Join(j.Wrap(mpirunArgs), " ")
+		if args != tt.expected {
+			t.Fatalf("wrapped command with %q is %s instead of %s", tt.spec, args, tt.expected)
+		}
+	}
+
+	_, err := GetWrapper("  ", "/out")
+	if err == nil {
+		t.Fatalf("GetWrapper succeeded with an empty wrapper")
+	}
+
+	// The wrapper is also inserted before apptainer
+	j := Job{Wrapper: []string{"strace"}}
+	args := strings.Join(j.Wrap([]string{"-np", "2", "/usr/bin/apptainer", "exec", "app.sif"}), " ")
+	if args != "-np 2 strace /usr/bin/apptainer exec app.sif" {
+		t.Fatalf("wrapped apptainer command is %s", args)
+	}
+
+	// The wrapper of the application is inserted in the container, before the application
+	j = Job{Wrapper: []string{"strace"}, AppWrapper: []string{"dmtcp_launch", "--join-coordinator"}, Container: &container.Config{Path: "app.sif"}}
+	args = strings.Join(j.Wrap(mpirunArgs), " ")
+	if args != "--mca btl self,vader strace /usr/bin/singularity exec app.sif dmtcp_launch --join-coordinator /opt/app" {
+		t.Fatalf("wrapped application is %s", args)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
@@ -73,7 +74,7 @@ func DebianGetDependencies(output string) []string {
 		var dpkgStdout, dpkgStderr bytes.Buffer
 		cmd.Stdout = &dpkgStdout
 		cmd.Stderr = &dpkgStderr
-		err = cmd.Run()
+		err = syexec.RunCmd(cmd)
 		if err != nil {
 			log.Printf("dpkg returned an error for %s, skipping... (%s; stdout: %s; stderr: %s)", words[0], err, dpkgStdout.String(), dpkgStderr.String())
 			continue
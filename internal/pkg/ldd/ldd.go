@@ -13,6 +13,7 @@ import (
 	"os/exec"
 	"time"
 
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
@@ -47,7 +48,7 @@ func (m *Module) GetPackageDependenciesForFile(file string) []string {
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err = cmd.Run()
+	err = syexec.RunCmd(cmd)
 	if err != nil {
 		log.Printf("failed to execute dpkg: %s; stdout: %s; stderr: %s", err, stdout.String(), stderr.String())
 		return dependencies
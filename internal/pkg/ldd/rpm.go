@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
@@ -42,7 +43,7 @@ func RPMGetDependencies(output string) []string {
 		var rpmStdout, rpmStderr bytes.Buffer
 		cmd.Stdout = &rpmStdout
 		cmd.Stderr = &rpmStderr
-		err = cmd.Run()
+		err = syexec.RunCmd(cmd)
 		if err != nil {
 			log.Printf("rpm returned an error for %s, skipping... (%s; stdout: %s; stderr: %s)", words[0], err, rpmStdout.String(), rpmStderr.String())
 			continue
@@ -0,0 +1,77 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+/*
+ * lockfile is a package that serializes processes with flock on lock files, which are removed
+ * once they are not locked anymore so they do not accumulate next to the files they protect.
+ */
+package lockfile
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// isCurrent checks whether an open lock file is still the file at its path: the file is
+// unlinked when it is released, a process that opened it before may then hold a lock that does
+// not protect anything anymore
+func isCurrent(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pathFi, err := os.Stat(f.Name())
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pathFi)
+}
+
+// Lock opens, or creates, a lock file and locks it with flock, how being the operation, e.g.,
+// syscall.LOCK_SH, syscall.LOCK_EX or syscall.LOCK_EX|syscall.LOCK_NB
+func Lock(path string, how int) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %s", path, err)
+		}
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %s", path, err)
+		}
+		if isCurrent(f) {
+			return f, nil
+		}
+		// The file was removed by its previous owner while we were waiting for the lock
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}
+}
+
+// Unlock releases a lock file and closes it; the file is removed when no other process holds or
+// waits for the lock, i.e., when the lock can be made exclusive without waiting
+func Unlock(f *os.File) error {
+	if f == nil {
+		return nil
+	}
+	defer f.Close()
+
+	var err error
+	if syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil && isCurrent(f) {
+		err = os.Remove(f.Name())
+		if err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("failed to remove %s: %s", f.Name(), err)
+		} else {
+			err = nil
+		}
+	}
+	unlockErr := syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	if err == nil && unlockErr != nil {
+		err = fmt.Errorf("failed to unlock %s: %s", f.Name(), unlockErr)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package lockfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestLockUnlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-lockfile-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.txt.lock")
+
+	// The lock file is kept as long as another lock is held
+	l1, err := Lock(path, syscall.LOCK_SH)
+	if err != nil {
+		t.Fatalf("Lock() failed: %s", err)
+	}
+	l2, err := Lock(path, syscall.LOCK_SH)
+	if err != nil {
+		t.Fatalf("Lock() failed: %s", err)
+	}
+	_, err = Lock(path, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		t.Fatalf("exclusive lock acquired while shared locks are held")
+	}
+	err = Unlock(l1)
+	if err != nil {
+		t.Fatalf("Unlock() failed: %s", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("%s was removed while it is still locked", path)
+	}
+	err = Unlock(l2)
+	if err != nil {
+		t.Fatalf("Unlock() failed: %s", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatalf("%s was not removed once unlocked", path)
+	}
+
+	// A lock file opened before it was removed is not used
+	stale, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+	defer stale.Close()
+	os.Remove(path)
+	if isCurrent(stale) {
+		t.Fatalf("a removed lock file is considered as current")
+	}
+	l3, err := Lock(path, syscall.LOCK_EX)
+	if err != nil {
+		t.Fatalf("Lock() failed: %s", err)
+	}
+	if !isCurrent(l3) {
+		t.Fatalf("the lock file is not the file at %s", path)
+	}
+	err = Unlock(l3)
+	if err != nil {
+		t.Fatalf("Unlock() failed: %s", err)
+	}
+}
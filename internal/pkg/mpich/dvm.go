@@ -0,0 +1,20 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpich
+
+import (
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+)
+
+// GetDVMCommands returns the commands to use persistent daemons with Hydra: hydra_persist runs in
+// the background and mpiexec uses the persist bootstrap server. The daemons are stopped with the
+// instance running them.
+func GetDVMCommands() mpiplugin.DVMCommands {
+	return mpiplugin.DVMCommands{
+		Start:  []string{"sh", "-c", "hydra_persist > " + mpiplugin.DVMURITag + " 2>&1 &"},
+		Submit: []string{"mpiexec", "-bootstrap", "persist", "-np", mpiplugin.DVMNPTag},
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpich
+
+import (
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/autotools"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+)
+
+// mirroredConfigureArgs is the list of the prefixes of the arguments of configure used on the host
+// that are mirrored as is
+var mirroredConfigureArgs = []string{"--enable-threads", "--enable-thread-cs", "--enable-fortran", "--disable-fortran", "--with-cuda", "--enable-cuda"}
+
+// parseMpichversion returns the arguments of configure matching the settings of an installation of
+// MPICH based on the output of mpichversion
+func parseMpichversion(output string) []string {
+	var args []string
+	device := ""
+	hasFortranCompiler := false
+
+	for _, line := range strings.Split(output, "\n") {
+		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(tokens[1])
+		switch strings.TrimSpace(tokens[0]) {
+		case "MPICH configure":
+			args = autotools.RelocateCUDAArgs(autotools.FilterConfigureArgs(value, mirroredConfigureArgs))
+		case "MPICH Device":
+			device = value
+		case "MPICH F77", "MPICH FC":
+			if value != "" {
+				hasFortranCompiler = true
+			}
+		}
+	}
+
+	// The device is always mirrored, it has precedence over the device selected for the version
+	if device != "" {
+		args = append(args, "--with-device="+device)
+	}
+	if !hasFortranCompiler && len(autotools.FilterConfigureArgs(strings.Join(args, " "), []string{"--disable-fortran", "--enable-fortran"})) == 0 {
+		args = append(args, "--disable-fortran")
+	}
+
+	return args
+}
+
+// GetMirrorConfigureArgs returns the arguments of configure to build MPICH with the same
+// important settings as an installation on the host
+func GetMirrorConfigureArgs(installDir string) ([]string, error) {
+	output, err := mpiplugin.RunInstallTool(installDir, "mpichversion")
+	if err != nil {
+		return nil, err
+	}
+	return parseMpichversion(output), nil
+}
@@ -6,27 +6,123 @@
 package mpich
 
 import (
+	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
 const (
 	// VersionTag is the tag used to refer to the MPI version in MPICH template(s)
 	VersionTag = "MPICHVERSION"
 	// URLTag is the tag used to refer to the MPI URL in MPICH template(s)
-	URLTag     = "MPICHURL"
+	URLTag = "MPICHURL"
 	// TarballTag is the tag used to refer to the MPI tarball in MPICH template(s)
 	TarballTag = "MPICHTARBALL"
+
+	// DeviceKey is the key used in the tool's configuration file to force the device used
+	// by MPICH, e.g., ch4:ucx
+	DeviceKey = "mpich_device"
+
+	// ch4Version is the first version of MPICH for which the ch4 device must be used
+	ch4Version = "3.4.0"
 )
 
+// deviceRule specifies the device to use for a range of MPICH versions
+type deviceRule struct {
+	// minVersion is the first version the rule applies to (empty for no lower bound)
+	minVersion string
+
+	// maxVersion is the first version the rule does not apply to anymore (empty for no upper bound)
+	maxVersion string
+
+	// condition specifies whether the rule applies to the platform (nil when it always applies)
+	condition func(*sys.Config) bool
+
+	// device is the device to use
+	device string
+}
+
+// deviceRules is the list of rules used to select the device MPICH is configured with, the
+// first matching rule being used
+var deviceRules = []deviceRule{
+	{maxVersion: ch4Version, device: "ch3:nemesis"},
+	{minVersion: ch4Version, condition: ibEnabled, device: "ch4:ucx"},
+	{minVersion: ch4Version, device: "ch4:ofi"},
+}
+
+func ibEnabled(sysCfg *sys.Config) bool {
+	return sysCfg.IBEnabled
+}
+
 // MPICHGetExtraMpirunArgs returns the extra mpirun arguments required by MPICH for a specific configuration
-func MPICHGetExtraMpirunArgs() []string {
+func MPICHGetExtraMpirunArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
 	var extraArgs []string
+
+	if sysCfg.Ifnet == "" {
+		return extraArgs
+	}
+
+	// Hydra resolves the address of the interface on each node and, with ch3, passes it to the
+	// ranks through MPIR_CVAR_CH3_INTERFACE_HOSTNAME
+	extraArgs = append(extraArgs, "-iface", sysCfg.Ifnet)
+
+	// With ch4, the interface must be specified to the network module
+	switch getDevice(pkg.Version, sysCfg) {
+	case "ch4:ofi":
+		extraArgs = append(extraArgs, "-genv", "FI_TCP_IFACE", sysCfg.Ifnet)
+		extraArgs = append(extraArgs, "-genv", "FI_SOCKETS_IFACE", sysCfg.Ifnet)
+	case "ch4:ucx":
+		extraArgs = append(extraArgs, "-genv", "UCX_NET_DEVICES", sysCfg.Ifnet)
+	}
+
 	return extraArgs
 }
 
-// MPICHGetConfigureExtraArgs returns the extra arguments required to configure MPICH
-func MPICHGetConfigureExtraArgs() []string {
+// getDevice returns the device to use with a given version of MPICH
+func getDevice(version string, sysCfg *sys.Config) string {
+	// The device specified in the tool's configuration file has precedence
+	kvs, err := sy.LoadMPIConfigFile()
+	if err == nil && kv.GetValue(kvs, DeviceKey) != "" {
+		return kv.GetValue(kvs, DeviceKey)
+	}
+
+	for _, r := range deviceRules {
+		if !implem.VersionInRange(version, r.minVersion, r.maxVersion) {
+			continue
+		}
+		if r.condition != nil && !r.condition(sysCfg) {
+			continue
+		}
+		return r.device
+	}
+	return ""
+}
+
+// GetThreadLevelConfigureArgs returns the arguments of configure required by MPICH to provide a thread level
+func GetThreadLevelConfigureArgs(level string) []string {
+	if level == "" {
+		return nil
+	}
+	return []string{"--enable-threads=" + level}
+}
+
+// GetExtraConfigureArgs returns the extra arguments required to configure a specific version of MPICH
+func GetExtraConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
 	var extraArgs []string
+
+	device := getDevice(pkg.Version, sysCfg)
+	if device != "" {
+		extraArgs = append(extraArgs, "--with-device="+device)
+	}
+
+	if sysCfg.SlurmEnabled {
+		extraArgs = append(extraArgs, "--with-slurm")
+	}
+
+	extraArgs = append(extraArgs, GetThreadLevelConfigureArgs(sysCfg.ThreadLevel)...)
+
 	return extraArgs
 }
 
@@ -38,4 +134,3 @@ func GetDeffileTemplateTags() deffile.TemplateTags {
 	tags.Version = VersionTag
 	return tags
 }
-
@@ -0,0 +1,51 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpich
+
+import (
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// mpich is the implementation of the mpiplugin.MPIImplementation interface for MPICH
+type mpich struct {
+	mpiplugin.Base
+}
+
+func init() {
+	mpiplugin.Register(&mpich{mpiplugin.Base{Name: implem.MPICH}})
+}
+
+func (m *mpich) ConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
+	return GetExtraConfigureArgs(pkg, sysCfg)
+}
+
+func (m *mpich) DeffileTemplateTags() deffile.TemplateTags {
+	return GetDeffileTemplateTags()
+}
+
+func (m *mpich) MpirunArgs(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) []string {
+	return MPICHGetExtraMpirunArgs(pkg, sysCfg)
+}
+
+func (m *mpich) MirrorConfigureArgs(installDir string) ([]string, error) {
+	return GetMirrorConfigureArgs(installDir)
+}
+
+func (m *mpich) DVMCommands(mpi *implem.Info) (mpiplugin.DVMCommands, error) {
+	return GetDVMCommands(), nil
+}
+
+func (m *mpich) ThreadLevelConfigureArgs(mpi *implem.Info, level string) []string {
+	return GetThreadLevelConfigureArgs(level)
+}
+
+func (m *mpich) LibraryVersionPrefix() string {
+	return "MPICH"
+}
@@ -25,6 +25,9 @@ type SaveFn func(sysCfg *sys.Config) error
 type Info struct {
 	ID   string
 	Save SaveFn
+
+	// Ifnet is the network interface used by MPI
+	Ifnet string
 }
 
 // Detect is the function called to detect the network on the system and load the corresponding networking component
@@ -34,10 +37,14 @@ func Detect(sysCfg *sys.Config) Info {
 		log.Fatalln("unable to find a default network configuration")
 	}
 
+	ifnet := LoadIfnet(sysCfg)
+
 	loaded, ibComp := LoadInfiniband(sysCfg)
 	if loaded {
+		ibComp.Ifnet = ifnet
 		return ibComp
 	}
 
+	comp.Ifnet = ifnet
 	return comp
 }
@@ -25,6 +25,9 @@ const (
 
 	// KNEMDirKey is the key used in the configuration file to specify where knem files are installed
 	KNEMDirKey = "knem_dir"
+
+	// UCXDirKey is the key used in the configuration file to specify where UCX files are installed
+	UCXDirKey = "ucx_dir"
 )
 
 // LoadInfiniband is the function called to load the IB component
@@ -0,0 +1,97 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package network
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// IfnetKey is the key used in the configuration file to specify the network interface used by MPI
+	IfnetKey = "ifnet"
+
+	// routeFile is the kernel's IPv4 routing table
+	routeFile = "/proc/net/route"
+
+	// defaultDestination is the destination of the default route in the kernel's routing table
+	defaultDestination = "00000000"
+)
+
+// getDefaultRouteInterface parses a routing table with the format of /proc/net/route and
+// returns the interface of the default route, an empty string if there is none
+func getDefaultRouteInterface(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: Iface Destination Gateway Flags ...; the first line is the header
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] == "Iface" {
+			continue
+		}
+		if fields[1] == defaultDestination {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// getFirstInterface returns the first network interface that is up and is not a loopback
+func getFirstInterface() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
+			return iface.Name
+		}
+	}
+	return ""
+}
+
+// DetectIfnet proposes a default network interface: the interface of the default route or,
+// if there is no default route, the first interface that is up and is not a loopback
+func DetectIfnet() string {
+	ifnet := getDefaultRouteInterface(routeFile)
+	if ifnet == "" {
+		ifnet = getFirstInterface()
+	}
+	return ifnet
+}
+
+// LoadIfnet sets the network interface used by MPI. The interface specified in the tool's
+// configuration file has precedence over the interface that is detected.
+func LoadIfnet(sysCfg *sys.Config) string {
+	kvs, err := sy.LoadMPIConfigFile()
+	if err != nil {
+		log.Printf("[WARN] Unable to load the configuration of the tool: %s\n", err)
+	}
+
+	sysCfg.Ifnet = kv.GetValue(kvs, IfnetKey)
+	if sysCfg.Ifnet != "" {
+		log.Printf("* Using network interface %s from the configuration file\n", sysCfg.Ifnet)
+		return sysCfg.Ifnet
+	}
+
+	sysCfg.Ifnet = DetectIfnet()
+	if sysCfg.Ifnet != "" {
+		log.Printf("* Using detected network interface %s, set '%s' in the configuration file to overwrite\n", sysCfg.Ifnet, IfnetKey)
+	}
+	return sysCfg.Ifnet
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetDefaultRouteInterface(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-network-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name          string
+		routes        string
+		expectedIfnet string
+	}{
+		{
+			name:          "default route",
+			routes:        "Iface\tDestination\tGateway\tFlags\nib0\t0000A8C0\t00000000\t0001\neth1\t00000000\t0101A8C0\t0003\n",
+			expectedIfnet: "eth1",
+		},
+		{
+			name:          "no default route",
+			routes:        "Iface\tDestination\tGateway\tFlags\nib0\t0000A8C0\t00000000\t0001\n",
+			expectedIfnet: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "route")
+			err := ioutil.WriteFile(path, []byte(tt.routes), 0644)
+			if err != nil {
+				t.Fatalf("failed to create %s: %s", path, err)
+			}
+			ifnet := getDefaultRouteInterface(path)
+			if ifnet != tt.expectedIfnet {
+				t.Fatalf("detected interface is %s instead of %s", ifnet, tt.expectedIfnet)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package openmpi
+
+import (
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+)
+
+// GetDVMCommands returns the commands to use a persistent distributed virtual machine: PRRTE
+// (prte, prun and pterm) starting with Open MPI 5.0.0, orte-dvm for previous versions
+func GetDVMCommands(version string) mpiplugin.DVMCommands {
+	if implem.VersionInRange(version, "5.0.0", "") {
+		return mpiplugin.DVMCommands{
+			Start:      []string{"prte", "--daemonize", "--report-uri", mpiplugin.DVMURITag},
+			ReportsURI: true,
+			Submit:     []string{"prun", "--dvm-uri", "file:" + mpiplugin.DVMURITag, "-np", mpiplugin.DVMNPTag},
+			Stop:       []string{"pterm", "--dvm-uri", "file:" + mpiplugin.DVMURITag},
+		}
+	}
+	return mpiplugin.DVMCommands{
+		Start:      []string{"orte-dvm", "--daemonize", "--report-uri", mpiplugin.DVMURITag},
+		ReportsURI: true,
+		Submit:     []string{"mpirun", "--hnp", "file:" + mpiplugin.DVMURITag, "-np", mpiplugin.DVMNPTag},
+		Stop:       []string{"mpirun", "--hnp", "file:" + mpiplugin.DVMURITag, "--terminate"},
+	}
+}
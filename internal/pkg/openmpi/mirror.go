@@ -0,0 +1,70 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package openmpi
+
+import (
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/autotools"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+)
+
+// mirroredConfigureArgs is the list of the prefixes of the arguments of configure used on the host
+// that are mirrored as is
+var mirroredConfigureArgs = []string{"--with-cuda", "--enable-mpi-thread-multiple", "--disable-mpi-fortran", "--enable-mpi-fortran"}
+
+// parseOmpiInfo returns the arguments of configure matching the settings of an installation of
+// Open MPI based on the output of ompi_info
+func parseOmpiInfo(output string) []string {
+	var args []string
+	threadMultiple := false
+	noFortran := false
+	cudaExtension := false
+
+	for _, line := range strings.Split(output, "\n") {
+		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(tokens[1])
+		switch strings.TrimSpace(tokens[0]) {
+		case "Configure command line":
+			args = autotools.RelocateCUDAArgs(autotools.FilterConfigureArgs(value, mirroredConfigureArgs))
+		case "Thread support":
+			threadMultiple = strings.Contains(value, "MPI_THREAD_MULTIPLE: yes")
+		case "Fort mpif.h":
+			noFortran = strings.HasPrefix(value, "no")
+		case "MPI extensions":
+			cudaExtension = strings.Contains(value, "cuda")
+		}
+	}
+
+	// Settings that are not explicitly specified on the host
+	hasArg := func(prefix string) bool {
+		return len(autotools.FilterConfigureArgs(strings.Join(args, " "), []string{prefix})) > 0
+	}
+	if threadMultiple && !hasArg("--enable-mpi-thread-multiple") {
+		args = append(args, "--enable-mpi-thread-multiple")
+	}
+	if noFortran && !hasArg("--disable-mpi-fortran") {
+		args = append(args, "--disable-mpi-fortran")
+	}
+	if cudaExtension && !hasArg("--with-cuda") {
+		args = append(args, "--with-cuda")
+	}
+
+	return args
+}
+
+// GetMirrorConfigureArgs returns the arguments of configure to build Open MPI with the same
+// important settings as an installation on the host
+func GetMirrorConfigureArgs(installDir string) ([]string, error) {
+	output, err := mpiplugin.RunInstallTool(installDir, "ompi_info")
+	if err != nil {
+		return nil, err
+	}
+	return parseOmpiInfo(output), nil
+}
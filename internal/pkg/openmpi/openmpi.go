@@ -8,12 +8,15 @@ package openmpi
 import (
 	"fmt"
 	"log"
+	"path/filepath"
 
+	"github.com/gvallee/go_util/pkg/util"
 	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/internal/pkg/autotools"
 	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
 	"github.com/sylabs/singularity-mpi/internal/pkg/network"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
@@ -27,6 +30,9 @@ const (
 
 	// TarballTag is the tag used to refer to the MPI tarball in Open MPI template(s)
 	TarballTag = "OMPITARBALL"
+
+	// prrteVersion is the first version of Open MPI using PRRTE instead of ORTE as runtime
+	prrteVersion = "5.0.0"
 )
 
 // Configure executes the appropriate command to configure Open MPI on the target platform
@@ -36,17 +42,26 @@ func Configure(env *buildenv.Info, sysCfg *sys.Config, extraArgs []string) error
 	ac.Install = env.InstallDir
 	ac.Source = env.SrcDir
 	ac.ExtraConfigureArgs = extraArgs
+	ac.Builder = env.Builder
+	ac.Env = env.Env
 
 	err := autotools.Configure(&ac)
 	if err != nil {
-		return fmt.Errorf("Unable to run configure: %s", err)
+		return fmt.Errorf("Unable to run configure: %w", err)
 	}
 
 	return nil
 }
 
-// GetExtraMpirunArgs returns the set of arguments required for the mpirun command for the target platform
-func GetExtraMpirunArgs(sys *sys.Config) []string {
+// usesPRRTE checks whether a version of Open MPI uses PRRTE as runtime: Open MPI 5.x removed ORTE
+// (orterun and orted), mpirun being then a wrapper of prterun
+func usesPRRTE(version string) bool {
+	return implem.VersionInRange(version, prrteVersion, "")
+}
+
+// GetExtraMpirunArgs returns the set of arguments required for the mpirun command of a version of
+// Open MPI, installed in installDir, for the target platform
+func GetExtraMpirunArgs(version string, installDir string, sys *sys.Config) []string {
 	var extraArgs []string
 	/*
 		if sys.IBEnabled {
@@ -56,41 +71,174 @@ func GetExtraMpirunArgs(sys *sys.Config) []string {
 		}
 	*/
 
+	// Unlike orterun, prterun does not infer the prefix of the remote daemons from the absolute path
+	// of mpirun
+	if usesPRRTE(version) && installDir != "" {
+		extraArgs = append(extraArgs, "--prefix", installDir)
+	}
+
+	// Restrict the TCP communications, including the ones of the runtime, to the selected interface.
+	// The parameters of the runtime belong to the PRRTE namespace with Open MPI 5.x.
+	if sys.Ifnet != "" {
+		extraArgs = append(extraArgs, "--mca", "btl_tcp_if_include", sys.Ifnet)
+		runtimeMCA := "--mca"
+		if usesPRRTE(version) {
+			runtimeMCA = "--prtemca"
+		}
+		extraArgs = append(extraArgs, runtimeMCA, "oob_tcp_if_include", sys.Ifnet)
+	}
+
 	return extraArgs
 }
 
-// GetExtraConfigureArgs returns the set of arguments required for configure to configure Open MPI on the target platform
-func GetExtraConfigureArgs(sysCfg *sys.Config) []string {
-	var extraArgs []string
-	if sysCfg.SlurmEnabled {
-		extraArgs = append(extraArgs, "--with-slurm")
+// GetMpirunPath returns the path to the launcher of an installation of Open MPI: mpirun or, when
+// Open MPI 5.x is installed without its mpirun wrapper, e.g., with an external PRRTE, prterun
+func GetMpirunPath(installDir string) string {
+	mpirun := filepath.Join(installDir, "bin", "mpirun")
+	prterun := filepath.Join(installDir, "bin", "prterun")
+	if !util.FileExists(mpirun) && util.FileExists(prterun) {
+		return prterun
 	}
+	return mpirun
+}
 
-	if sysCfg.IBEnabled {
-		kvs, err := sy.LoadMPIConfigFile()
-		if err != nil {
-			log.Printf("[WARN] Unable to load the configuration of the tool; unable to fully Infiniband: %s\n", err)
-			return extraArgs
-		}
+// GetContainerEnv returns the commands setting up the runtime environment of a version of Open MPI
+// installed in MPI_DIR: OPAL_PREFIX is used to find its files when it is not in the directory where it
+// was installed, e.g., an installation relocated or bind-mounted elsewhere. PRRTE and PMIx, which are
+// bundled with Open MPI 5.x, have their own variable.
+func GetContainerEnv(version string) []string {
+	var env []string
+	for _, e := range GetRelocationEnv(version, "$MPI_DIR") {
+		env = append(env, "export "+e)
+	}
+	return env
+}
+
+// GetRelocationEnv returns the environment variables making a version of Open MPI find its files
+// in prefix when it is not the directory where it was installed, e.g., OPAL_PREFIX=/opt/openmpi
+func GetRelocationEnv(version string, prefix string) []string {
+	env := []string{"OPAL_PREFIX=" + prefix}
+	if usesPRRTE(version) {
+		env = append(env, "PRTE_PREFIX="+prefix, "PMIX_PREFIX="+prefix)
+	}
+	return env
+}
+
+// GetTuningEnv returns the environment variable making Open MPI read its MCA parameters from a
+// tuning file of the site, e.g., mca-params.conf, instead of the default parameter files
+func GetTuningEnv(path string) []string {
+	return []string{"OMPI_MCA_mca_base_param_files=" + path}
+}
+
+// configureRule specifies arguments to add to configure for a range of Open MPI versions
+type configureRule struct {
+	// minVersion is the first version the rule applies to (empty for no lower bound)
+	minVersion string
+
+	// maxVersion is the first version the rule does not apply to anymore (empty for no upper bound)
+	maxVersion string
+
+	// condition specifies whether the rule applies to the platform (nil when it always applies)
+	condition func(*sys.Config) bool
+
+	// args are the arguments to add to configure
+	args []string
+}
+
+func slurmEnabled(sysCfg *sys.Config) bool {
+	return sysCfg.SlurmEnabled
+}
+
+func ibEnabled(sysCfg *sys.Config) bool {
+	return sysCfg.IBEnabled
+}
 
+// configureRules is the list of rules used to generate the configure arguments
+var configureRules = []configureRule{
+	// ORTE was replaced by PRRTE in Open MPI 5.x
+	{maxVersion: prrteVersion, args: []string{"--enable-orterun-prefix-by-default"}},
+	{minVersion: prrteVersion, args: []string{"--enable-prte-prefix-by-default"}},
+	{condition: slurmEnabled, args: []string{"--with-slurm"}},
+	// The openib BTL is deprecated with Open MPI 4.x and UCX must be used instead
+	{minVersion: "4.0.0", condition: ibEnabled, args: []string{"--without-verbs"}},
+}
+
+// getIBConfigureArgs returns the configure arguments that depend on the location of the
+// Infiniband libraries, as specified in the tool's configuration file
+func getIBConfigureArgs(version string) []string {
+	var extraArgs []string
+
+	kvs, err := sy.LoadMPIConfigFile()
+	if err != nil {
+		log.Printf("[WARN] Unable to load the configuration of the tool; unable to fully Infiniband: %s\n", err)
+		return extraArgs
+	}
+
+	// MXM support was removed in Open MPI 5.x
+	if implem.VersionInRange(version, "", prrteVersion) {
 		mlxDir := kv.GetValue(kvs, network.MXMDirKey)
 		if mlxDir == "" {
 			log.Printf("[WARN] Infiniband detected but the MXM directory is undefined in the configuration file")
 		} else {
 			extraArgs = append(extraArgs, "--with-mxm="+mlxDir)
 		}
+	}
+
+	knemDir := kv.GetValue(kvs, network.KNEMDirKey)
+	if knemDir == "" {
+		log.Printf("[WARN] Infiniband detected but the KNEM directory is undefined in the configuration file")
+	} else {
+		extraArgs = append(extraArgs, "--with-knem="+knemDir)
+	}
 
-		knemDir := kv.GetValue(kvs, network.KNEMDirKey)
-		if knemDir == "" {
-			log.Printf("[WARN] Infiniband detected but the KNEM directory is undefined in the configuration file")
+	if implem.VersionInRange(version, "4.0.0", "") {
+		ucxDir := kv.GetValue(kvs, network.UCXDirKey)
+		if ucxDir == "" {
+			extraArgs = append(extraArgs, "--with-ucx")
 		} else {
-			extraArgs = append(extraArgs, "--with-knem="+knemDir)
+			extraArgs = append(extraArgs, "--with-ucx="+ucxDir)
 		}
 	}
 
 	return extraArgs
 }
 
+// getRulesArgs returns the configure arguments from the rules that apply to a version of Open MPI
+func getRulesArgs(rules []configureRule, version string, sysCfg *sys.Config) []string {
+	var extraArgs []string
+	for _, r := range rules {
+		if !implem.VersionInRange(version, r.minVersion, r.maxVersion) {
+			continue
+		}
+		if r.condition != nil && !r.condition(sysCfg) {
+			continue
+		}
+		extraArgs = append(extraArgs, r.args...)
+	}
+	return extraArgs
+}
+
+// GetThreadLevelConfigureArgs returns the arguments of configure required by a version of Open MPI to
+// provide a thread level: MPI_THREAD_MULTIPLE must be enabled explicitly before Open MPI 3.x
+func GetThreadLevelConfigureArgs(version string, level string) []string {
+	if level == implem.ThreadMultiple && implem.VersionInRange(version, "", "3.0.0") {
+		return []string{"--enable-mpi-thread-multiple"}
+	}
+	return nil
+}
+
+// GetExtraConfigureArgs returns the set of arguments required for configure to configure a specific version
+// of Open MPI on the target platform
+func GetExtraConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
+	extraArgs := getRulesArgs(configureRules, pkg.Version, sysCfg)
+	if sysCfg.IBEnabled {
+		extraArgs = append(extraArgs, getIBConfigureArgs(pkg.Version)...)
+	}
+	extraArgs = append(extraArgs, GetThreadLevelConfigureArgs(pkg.Version, sysCfg.ThreadLevel)...)
+
+	return extraArgs
+}
+
 // GetDeffileTemplateTags returns the tag names used by Open MPI in its template
 func GetDeffileTemplateTags() deffile.TemplateTags {
 	var tags deffile.TemplateTags
@@ -0,0 +1,177 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package openmpi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestGetRulesArgs(t *testing.T) {
+	tests := []struct {
+		version      string
+		slurm        bool
+		ib           bool
+		expectedArgs string
+	}{
+		{
+			version:      "3.1.4",
+			expectedArgs: "--enable-orterun-prefix-by-default",
+		},
+		{
+			version:      "4.0.2",
+			slurm:        true,
+			ib:           true,
+			expectedArgs: "--enable-orterun-prefix-by-default --with-slurm --without-verbs",
+		},
+		{
+			version:      "5.0.0",
+			expectedArgs: "--enable-prte-prefix-by-default",
+		},
+	}
+
+	for _, tt := range tests {
+		var sysCfg sys.Config
+		sysCfg.SlurmEnabled = tt.slurm
+		sysCfg.IBEnabled = tt.ib
+		args := strings.Join(getRulesArgs(configureRules, tt.version, &sysCfg), " ")
+		if args != tt.expectedArgs {
+			t.Fatalf("configure arguments for Open MPI %s are '%s' instead of '%s'", tt.version, args, tt.expectedArgs)
+		}
+	}
+}
+
+func TestGetExtraMpirunArgs(t *testing.T) {
+	tests := []struct {
+		version      string
+		ifnet        string
+		expectedArgs string
+	}{
+		{version: "4.0.2", expectedArgs: ""},
+		{version: "4.0.2", ifnet: "eth1", expectedArgs: "--mca btl_tcp_if_include eth1 --mca oob_tcp_if_include eth1"},
+		{version: "5.0.0", expectedArgs: "--prefix /opt/openmpi"},
+		{version: "5.0.0", ifnet: "eth1", expectedArgs: "--prefix /opt/openmpi --mca btl_tcp_if_include eth1 --prtemca oob_tcp_if_include eth1"},
+	}
+
+	for _, tt := range tests {
+		var sysCfg sys.Config
+		sysCfg.Ifnet = tt.ifnet
+		args := strings.Join(GetExtraMpirunArgs(tt.version, "/opt/openmpi", &sysCfg), " ")
+		if args != tt.expectedArgs {
+			t.Fatalf("mpirun arguments for Open MPI %s are '%s' instead of '%s'", tt.version, args, tt.expectedArgs)
+		}
+	}
+}
+
+func TestGetMpirunPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-openmpi-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "bin"), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", filepath.Join(dir, "bin"), err)
+	}
+
+	// Open MPI 5.x without its mpirun wrapper is launched with prterun
+	for _, launcher := range []string{"prterun", "mpirun"} {
+		err = ioutil.WriteFile(filepath.Join(dir, "bin", launcher), nil, 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", launcher, err)
+		}
+		if GetMpirunPath(dir) != filepath.Join(dir, "bin", launcher) {
+			t.Fatalf("GetMpirunPath() returned %s instead of %s", GetMpirunPath(dir), launcher)
+		}
+	}
+
+	if len(GetContainerEnv("4.0.2")) != 1 || strings.Join(GetContainerEnv("5.0.0"), "\n") != "export OPAL_PREFIX=$MPI_DIR\nexport PRTE_PREFIX=$MPI_DIR\nexport PMIX_PREFIX=$MPI_DIR" {
+		t.Fatalf("invalid environment of Open MPI 5.x: %v", GetContainerEnv("5.0.0"))
+	}
+}
+
+func TestParseOmpiInfo(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		expectedArgs string
+	}{
+		{
+			name:         "default",
+			output:       "  Configure command line: '--prefix=/opt/openmpi'\n  Thread support: posix (MPI_THREAD_MULTIPLE: no, OPAL support: yes)\n  Fort mpif.h: yes (all)\n",
+			expectedArgs: "",
+		},
+		{
+			name:         "thread multiple without Fortran",
+			output:       "  Configure command line: '--prefix=/opt/openmpi'\n  Thread support: posix (MPI_THREAD_MULTIPLE: yes, OPAL support: yes)\n  Fort mpif.h: no\n",
+			expectedArgs: "--enable-mpi-thread-multiple --disable-mpi-fortran",
+		},
+		{
+			name:         "CUDA",
+			output:       "  Configure command line: '--prefix=/opt/openmpi' '--with-cuda=/opt/nvidia/cuda-10.2' '--with-cuda-libdir=/opt/nvidia/cuda-10.2/lib64/stubs'\n  MPI extensions: affinity, cuda\n  Fort mpif.h: yes (all)\n",
+			expectedArgs: "--with-cuda=/usr/local/cuda --with-cuda-libdir=/usr/local/cuda/lib64",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := strings.Join(parseOmpiInfo(tt.output), " ")
+			if args != tt.expectedArgs {
+				t.Fatalf("parseOmpiInfo() returned '%s' instead of '%s'", args, tt.expectedArgs)
+			}
+		})
+	}
+}
+
+func TestGetDVMCommands(t *testing.T) {
+	tests := []struct {
+		version       string
+		expectedStart string
+	}{
+		{
+			version:       "4.0.2",
+			expectedStart: "orte-dvm",
+		},
+		{
+			version:       "5.0.0",
+			expectedStart: "prte",
+		},
+	}
+
+	for _, tt := range tests {
+		cmds := GetDVMCommands(tt.version)
+		if len(cmds.Start) == 0 || cmds.Start[0] != tt.expectedStart {
+			t.Fatalf("invalid command to start the DVM for Open MPI %s: %v", tt.version, cmds.Start)
+		}
+		if len(cmds.Submit) == 0 || len(cmds.Stop) == 0 {
+			t.Fatalf("incomplete DVM commands for Open MPI %s: %v", tt.version, cmds)
+		}
+	}
+}
+
+func TestGetThreadLevelConfigureArgs(t *testing.T) {
+	tests := []struct {
+		version      string
+		level        string
+		expectedArgs string
+	}{
+		{version: "2.1.6", level: "multiple", expectedArgs: "--enable-mpi-thread-multiple"},
+		{version: "2.1.6", level: "serialized", expectedArgs: ""},
+		{version: "4.0.2", level: "multiple", expectedArgs: ""},
+	}
+
+	for _, tt := range tests {
+		args := strings.Join(GetThreadLevelConfigureArgs(tt.version, tt.level), " ")
+		if args != tt.expectedArgs {
+			t.Fatalf("configure arguments for thread level %s with Open MPI %s are '%s' instead of '%s'", tt.level, tt.version, args, tt.expectedArgs)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package openmpi
+
+import (
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// openMPI is the implementation of the mpiplugin.MPIImplementation interface for Open MPI
+type openMPI struct {
+	mpiplugin.Base
+}
+
+func init() {
+	mpiplugin.Register(&openMPI{mpiplugin.Base{Name: implem.OMPI}})
+}
+
+func (o *openMPI) Configure(env *buildenv.Info, sysCfg *sys.Config, extraArgs []string) error {
+	return Configure(env, sysCfg, extraArgs)
+}
+
+func (o *openMPI) ConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
+	return GetExtraConfigureArgs(pkg, sysCfg)
+}
+
+func (o *openMPI) DeffileTemplateTags() deffile.TemplateTags {
+	return GetDeffileTemplateTags()
+}
+
+// ContainerEnv also sets the prefixes Open MPI uses to find its files when it is not in the
+// directory where it was installed, e.g., an installation relocated or bind-mounted elsewhere
+func (o *openMPI) ContainerEnv(pkg *implem.Info, sysCfg *sys.Config) []string {
+	return append(o.Base.ContainerEnv(pkg, sysCfg), GetContainerEnv(pkg.Version)...)
+}
+
+func (o *openMPI) RelocationEnv(pkg *implem.Info, prefix string) []string {
+	return GetRelocationEnv(pkg.Version, prefix)
+}
+
+func (o *openMPI) TuningEnv(pkg *implem.Info, path string) []string {
+	return GetTuningEnv(path)
+}
+
+func (o *openMPI) MpirunArgs(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) []string {
+	installDir := ""
+	if env != nil {
+		installDir = env.InstallDir
+	}
+	return GetExtraMpirunArgs(pkg.Version, installDir, sysCfg)
+}
+
+func (o *openMPI) MpirunPath(env *buildenv.Info) string {
+	return GetMpirunPath(env.InstallDir)
+}
+
+func (o *openMPI) MirrorConfigureArgs(installDir string) ([]string, error) {
+	return GetMirrorConfigureArgs(installDir)
+}
+
+func (o *openMPI) DVMCommands(mpi *implem.Info) (mpiplugin.DVMCommands, error) {
+	return GetDVMCommands(mpi.Version), nil
+}
+
+func (o *openMPI) ThreadLevelConfigureArgs(mpi *implem.Info, level string) []string {
+	return GetThreadLevelConfigureArgs(mpi.Version, level)
+}
+
+func (o *openMPI) LibraryVersionPrefix() string {
+	return "Open MPI"
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oshmem
+
+import (
+	"github.com/sylabs/singularity-mpi/internal/pkg/openmpi"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// ucxVersion is the first version of Open MPI where OSHMEM requires UCX, the yoda SPML
+	// based on the BTLs being removed
+	ucxVersion = "4.0.0"
+)
+
+// GetExtraConfigureArgs returns the set of arguments required for configure to build OSHMEM with a
+// specific version of Open MPI on the target platform
+func GetExtraConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
+	extraArgs := append(openmpi.GetExtraConfigureArgs(pkg, sysCfg), "--enable-oshmem")
+	if implem.VersionInRange(pkg.Version, ucxVersion, "") {
+		extraArgs = append(extraArgs, "--with-ucx")
+	}
+	return extraArgs
+}
+
+// GetMirrorConfigureArgs returns the arguments of configure to build OSHMEM the same way as an
+// installation on the host
+func GetMirrorConfigureArgs(installDir string) ([]string, error) {
+	args, err := openmpi.GetMirrorConfigureArgs(installDir)
+	if err != nil {
+		return nil, err
+	}
+	return append(args, "--enable-oshmem"), nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oshmem
+
+import (
+	"github.com/sylabs/singularity-mpi/internal/pkg/openmpi"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// oshmem is the implementation of the mpiplugin.MPIImplementation interface for OSHMEM, i.e., Open
+// MPI built with its OpenSHMEM layer; everything but the launcher is inherited from Open MPI
+type oshmem struct {
+	mpiplugin.Base
+}
+
+func init() {
+	mpiplugin.Register(&oshmem{mpiplugin.Base{Name: implem.OSHMEM}})
+}
+
+func (o *oshmem) Configure(env *buildenv.Info, sysCfg *sys.Config, extraArgs []string) error {
+	return openmpi.Configure(env, sysCfg, extraArgs)
+}
+
+func (o *oshmem) ConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
+	return GetExtraConfigureArgs(pkg, sysCfg)
+}
+
+func (o *oshmem) ContainerEnv(pkg *implem.Info, sysCfg *sys.Config) []string {
+	return append(o.Base.ContainerEnv(pkg, sysCfg), openmpi.GetContainerEnv(pkg.Version)...)
+}
+
+func (o *oshmem) RelocationEnv(pkg *implem.Info, prefix string) []string {
+	return openmpi.GetRelocationEnv(pkg.Version, prefix)
+}
+
+func (o *oshmem) TuningEnv(pkg *implem.Info, path string) []string {
+	return openmpi.GetTuningEnv(path)
+}
+
+func (o *oshmem) MpirunArgs(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) []string {
+	installDir := ""
+	if env != nil {
+		installDir = env.InstallDir
+	}
+	return openmpi.GetExtraMpirunArgs(pkg.Version, installDir, sysCfg)
+}
+
+func (o *oshmem) MpirunPath(env *buildenv.Info) string {
+	return mpiplugin.GetOshrunPath(env.InstallDir)
+}
+
+func (o *oshmem) MirrorConfigureArgs(installDir string) ([]string, error) {
+	return GetMirrorConfigureArgs(installDir)
+}
+
+func (o *oshmem) DVMCommands(mpi *implem.Info) (mpiplugin.DVMCommands, error) {
+	return openmpi.GetDVMCommands(mpi.Version), nil
+}
+
+func (o *oshmem) ThreadLevelConfigureArgs(mpi *implem.Info, level string) []string {
+	return openmpi.GetThreadLevelConfigureArgs(mpi.Version, level)
+}
+
+func (o *oshmem) LibraryVersionPrefix() string {
+	return "Open MPI"
+}
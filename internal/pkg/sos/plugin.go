@@ -0,0 +1,30 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sos
+
+import (
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// sos is the implementation of the mpiplugin.MPIImplementation interface for Sandia OpenSHMEM
+type sos struct {
+	mpiplugin.Base
+}
+
+func init() {
+	mpiplugin.Register(&sos{mpiplugin.Base{Name: implem.SOS}})
+}
+
+func (s *sos) ConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
+	return GetExtraConfigureArgs(pkg, sysCfg)
+}
+
+func (s *sos) MpirunPath(env *buildenv.Info) string {
+	return mpiplugin.GetOshrunPath(env.InstallDir)
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sos
+
+import (
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// oshrunLauncher is the launcher executed by oshrun, found in the PATH when the jobs are started:
+// the Hydra launcher, e.g., of MPICH, which supports the simple PMI
+const oshrunLauncher = "mpiexec.hydra"
+
+// GetExtraConfigureArgs returns the set of arguments required for configure to configure a specific
+// version of Sandia OpenSHMEM on the target platform: the simple PMI is used so the jobs can be
+// started by oshrun with the Hydra launcher, and libfabric is used on InfiniBand systems
+func GetExtraConfigureArgs(pkg *implem.Info, sysCfg *sys.Config) []string {
+	// Without an explicit launcher, oshrun uses the first launcher found when SOS is configured,
+	// if any, e.g., the mpirun of another MPI not supporting the simple PMI
+	extraArgs := []string{"--enable-pmi-simple", "--with-oshrun-launcher=" + oshrunLauncher}
+	if sysCfg.IBEnabled {
+		extraArgs = append(extraArgs, "--with-ofi")
+	}
+	return extraArgs
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sos
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestGetExtraConfigureArgs(t *testing.T) {
+	tests := []struct {
+		ib           bool
+		expectedArgs string
+	}{
+		{ib: false, expectedArgs: "--enable-pmi-simple --with-oshrun-launcher=mpiexec.hydra"},
+		{ib: true, expectedArgs: "--enable-pmi-simple --with-oshrun-launcher=mpiexec.hydra --with-ofi"},
+	}
+
+	for _, tt := range tests {
+		var sysCfg sys.Config
+		sysCfg.IBEnabled = tt.ib
+		args := GetExtraConfigureArgs(&implem.Info{ID: implem.SOS, Version: "1.5.0"}, &sysCfg)
+		if strings.Join(args, " ") != tt.expectedArgs {
+			t.Fatalf("GetExtraConfigureArgs() returned '%s' instead of '%s'", strings.Join(args, " "), tt.expectedArgs)
+		}
+	}
+}
@@ -5,7 +5,10 @@
 
 package sympierr
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // ErrNotAvailable is the error returned when an element that is being looked up is not available
 var ErrNotAvailable = errors.New("item not available")
@@ -15,3 +18,62 @@ var ErrFileExists = errors.New("file already exists")
 
 // ErrSingularityNotInstalled is the error returned when Singularity is not installed
 var ErrSingularityNotInstalled = errors.New("Singularity not available")
+
+// ErrDownloadFailed is the error returned when the source code of a software cannot be downloaded
+var ErrDownloadFailed = errors.New("download failed")
+
+// ErrConfigureFailed is the error returned when the configuration of a software fails
+var ErrConfigureFailed = errors.New("configure failed")
+
+// ErrTimeout is the error returned when a command, e.g., a build, does not complete in time
+var ErrTimeout = errors.New("command timed out")
+
+// ErrImageExists is the error returned when an image cannot be created because it already exists
+var ErrImageExists = errors.New("image already exists")
+
+// ErrIncompatibleArch is the error returned when the architecture of an image is incompatible with the host
+var ErrIncompatibleArch = errors.New("incompatible architecture")
+
+// ErrNoPrivilege is the error returned when an operation requires privileges the tool cannot get,
+// e.g., a build requiring sudo on a host without sudo
+var ErrNoPrivilege = errors.New("missing privileges")
+
+// Error is an error of a given class, e.g., ErrDownloadFailed, that keeps track of the error that
+// caused the failure. Both the class and the cause can be checked with errors.Is, e.g., a configure
+// step that timed out matches both ErrConfigureFailed and ErrTimeout.
+type Error struct {
+	// Class is the error identifying the class of the failure
+	Class error
+
+	// Msg gives the details of the failure
+	Msg string
+
+	// Err is the error that caused the failure, if any
+	Err error
+}
+
+// Wrap creates an error of a given class, caused by err (which can be nil)
+func Wrap(class error, err error, format string, a ...interface{}) error {
+	return &Error{Class: class, Msg: fmt.Sprintf(format, a...), Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Class.Error()
+	if e.Msg != "" {
+		msg += ": " + e.Msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Is checks whether the error is of a given class, the cause being checked by errors.Is through Unwrap
+func (e *Error) Is(target error) bool {
+	return target == e.Class
+}
+
+// Unwrap returns the error that caused the failure
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympierr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	timeout := Wrap(ErrTimeout, errors.New("signal: killed"), "./configure did not complete within 30 minutes")
+	err := fmt.Errorf("failed to configure openmpi: %w", Wrap(ErrConfigureFailed, timeout, "stdout: ; stderr: "))
+
+	if !errors.Is(err, ErrConfigureFailed) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("the class of the error or of its cause is lost: %s", err)
+	}
+	if errors.Is(err, ErrDownloadFailed) {
+		t.Fatalf("error matches an unrelated class: %s", err)
+	}
+
+	var e *Error
+	if !errors.As(err, &e) || e.Class != ErrConfigureFailed {
+		t.Fatalf("failed to get the typed error from %s", err)
+	}
+
+	expected := "failed to configure openmpi: configure failed: stdout: ; stderr: : command timed out: ./configure did not complete within 30 minutes: signal: killed"
+	if err.Error() != expected {
+		t.Fatalf("invalid message: %s", err)
+	}
+}
@@ -5,6 +5,17 @@
 
 package app
 
+import (
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// fileURLPrefix is the prefix of the URLs of local files and directories
+	fileURLPrefix = "file://"
+)
+
 // Info gathers information about a given application
 type Info struct {
 	// Name is the name of the application
@@ -16,18 +27,50 @@ type Info struct {
 	// BinPath is the path to the binary to start executing the application
 	BinPath string
 
-	// Source is the URL to get the source. It can be a single file or a URI to a file to download
+	// Args are the arguments of the application, appended to the command executing it
+	Args []string
+
+	// BinDir is the directory on the host with the prebuilt binaries of the application, which is
+	// copied in the container; only used when Type is BinaryType and the binaries come in a tarball
+	BinDir string
+
+	// Source is the URL to get the source. It can be a single file, a local directory
+	// (e.g., file:///path/to/src) or a URI to a file to download
 	Source string
 
+	// TestSource is the name of the test source embedded in the tool the application is compiled
+	// from, e.g., MPITestC, written where Source points when the application is built (see
+	// PrepareTestSource); empty for the other applications
+	TestSource string
+
 	// InstallCmd is the command to use to install the application
 	InstallCmd string
 
+	// Type is the type of the application, e.g., PythonType or BinaryType; empty for applications compiled from source
+	Type string
+
+	// ThreadLevel is the thread level the application requires from MPI, e.g., implem.ThreadMultiple;
+	// empty when the application has no specific requirement
+	ThreadLevel string
+
+	// Python gathers the details of a Python application, only used when Type is PythonType
+	Python PythonInfo
+
 	// ExpectedRankOutput specifies what is the expected output from EACH rank
 	// A few keyword can be used for runtime-specific parameters
 	// Use '#NP' to specify the job size
 	// Use '#RANK' to specify the rank number
 	ExpectedRankOutput string
 
+	// OutputArtifacts is the list of patterns of the files produced by the application that are
+	// collected once it completes, e.g., np.out; relative patterns are relative to the directory
+	// from where the application is started
+	OutputArtifacts []string
+
+	// Bench are the settings of the benchmark, e.g., the number of iterations, which replace the
+	// placeholders of BinPath and Args, e.g., {iterations}, and are recorded with the results
+	Bench Bench
+
 	// ExpectedNote specifies what is the expected note from an application
 	//
 	// A note is the result of an application-specific parsing/analysis of the
@@ -36,3 +79,31 @@ type Info struct {
 	// todo: should support regexp here
 	ExpectedNote string
 }
+
+// ParseOutputArtifacts parses a comma-separated list of patterns of output artifacts, e.g., np.out,*.dat
+func ParseOutputArtifacts(str string) []string {
+	var patterns []string
+	for _, p := range strings.Split(str, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// GetLocalPath returns the path to the local file or directory of a source, an empty string
+// if the source is not local
+func GetLocalPath(source string) string {
+	if !strings.HasPrefix(source, fileURLPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(source, fileURLPrefix)
+}
+
+// IsLocalDir checks whether a source is a local directory, which is synchronized into the build
+// directory instead of being copied or downloaded
+func IsLocalDir(source string) bool {
+	path := GetLocalPath(source)
+	return path != "" && util.IsDir(path)
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SeedPlaceholder, IterationsPlaceholder and MsgSizesPlaceholder are replaced by the benchmark
+	// settings in the command and the arguments of an application, e.g., IMB-MPI1 -iter {iterations}
+	SeedPlaceholder       = "{seed}"
+	IterationsPlaceholder = "{iterations}"
+	MsgSizesPlaceholder   = "{msg_sizes}"
+)
+
+// benchOptions are the names of the options describing benchmark settings, e.g., "seed=42 iterations=1000"
+var benchOptions = []string{"seed", "iterations", "msg_sizes"}
+
+// msgSizesFormat is the format of the range of message sizes of a benchmark, as powers of two, e.g., 0:22
+var msgSizesFormat = regexp.MustCompile(`^[0-9]+(:[0-9]+)?$`)
+
+// Bench gathers the settings of a benchmark that make two runs comparable: benchmarks like IMB
+// vary from run to run, the settings are therefore pinned and recorded with the results
+type Bench struct {
+	// Seed is the seed of the random number generators of the application, a new seed being
+	// drawn for each run when 0
+	Seed int64 `json:"seed,omitempty"`
+
+	// Iterations is the number of iterations of each measurement, the default of the application when 0
+	Iterations int `json:"iterations,omitempty"`
+
+	// MsgSizes is the range of message sizes as powers of two, e.g., 0:22 for 1 byte to 4 MB, the
+	// default of the application when empty
+	MsgSizes string `json:"msg_sizes,omitempty"`
+}
+
+// IsSet checks whether any benchmark setting is specified
+func (b *Bench) IsSet() bool {
+	return b.Seed != 0 || b.Iterations != 0 || b.MsgSizes != ""
+}
+
+// Validate checks whether benchmark settings are valid
+func (b *Bench) Validate() error {
+	if b.Seed < 0 {
+		return fmt.Errorf("invalid seed %d", b.Seed)
+	}
+	if b.Iterations < 0 {
+		return fmt.Errorf("invalid number of iterations %d", b.Iterations)
+	}
+	if b.MsgSizes != "" && !msgSizesFormat.MatchString(b.MsgSizes) {
+		return fmt.Errorf("invalid message sizes %s, it should be a range of powers of two, e.g., 0:22", b.MsgSizes)
+	}
+	return nil
+}
+
+// ParseBench parses benchmark settings described by options, e.g., "seed=42 iterations=1000
+// msg_sizes=0:22"; the settings that are not specified are left unset
+func ParseBench(str string) (Bench, error) {
+	var b Bench
+	for _, opt := range strings.Fields(str) {
+		tokens := strings.SplitN(opt, "=", 2)
+		if len(tokens) != 2 || tokens[1] == "" {
+			return b, fmt.Errorf("invalid benchmark setting %s, it should be of the form <name>=<value> with name in %s", opt, strings.Join(benchOptions, ", "))
+		}
+		var err error
+		switch tokens[0] {
+		case "seed":
+			b.Seed, err = strconv.ParseInt(tokens[1], 10, 64)
+		case "iterations":
+			b.Iterations, err = strconv.Atoi(tokens[1])
+		case "msg_sizes":
+			b.MsgSizes = tokens[1]
+		default:
+			return b, fmt.Errorf("unknown benchmark setting %s, supported settings: %s", tokens[0], strings.Join(benchOptions, ", "))
+		}
+		if err != nil {
+			return b, fmt.Errorf("invalid value of %s: %s", tokens[0], err)
+		}
+	}
+	return b, b.Validate()
+}
+
+// String returns the description of benchmark settings parsed by ParseBench, e.g., "seed=42 iterations=1000"
+func (b Bench) String() string {
+	var opts []string
+	if b.Seed != 0 {
+		opts = append(opts, "seed="+strconv.FormatInt(b.Seed, 10))
+	}
+	if b.Iterations != 0 {
+		opts = append(opts, "iterations="+strconv.Itoa(b.Iterations))
+	}
+	if b.MsgSizes != "" {
+		opts = append(opts, "msg_sizes="+b.MsgSizes)
+	}
+	return strings.Join(opts, " ")
+}
+
+// Override returns the settings of b overridden by the settings that are set in o
+func (b Bench) Override(o Bench) Bench {
+	if o.Seed != 0 {
+		b.Seed = o.Seed
+	}
+	if o.Iterations != 0 {
+		b.Iterations = o.Iterations
+	}
+	if o.MsgSizes != "" {
+		b.MsgSizes = o.MsgSizes
+	}
+	return b
+}
+
+// WithSeed returns the settings of a run executing a command with arguments: a new seed is drawn
+// when the settings do not pin one; the seed is only recorded when the command or its arguments
+// have the seed placeholder, the run not depending on it otherwise
+func (b Bench) WithSeed(cmd string, args []string) Bench {
+	if !hasSeedPlaceholder(cmd, args) {
+		b.Seed = 0
+		return b
+	}
+	if b.Seed == 0 {
+		b.Seed = rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(1<<31-1) + 1
+	}
+	return b
+}
+
+// hasSeedPlaceholder checks whether a command or its arguments have the seed placeholder
+func hasSeedPlaceholder(cmd string, args []string) bool {
+	for _, str := range append([]string{cmd}, args...) {
+		if strings.Contains(str, SeedPlaceholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand replaces the placeholders of the benchmark settings in a command or an argument, e.g.,
+// -iter {iterations}; the placeholders of the settings that are not set are left unchanged
+func (b *Bench) Expand(str string) string {
+	if b.Seed != 0 {
+		str = strings.ReplaceAll(str, SeedPlaceholder, strconv.FormatInt(b.Seed, 10))
+	}
+	if b.Iterations != 0 {
+		str = strings.ReplaceAll(str, IterationsPlaceholder, strconv.Itoa(b.Iterations))
+	}
+	if b.MsgSizes != "" {
+		str = strings.ReplaceAll(str, MsgSizesPlaceholder, b.MsgSizes)
+	}
+	return str
+}
+
+// ExpandArgs replaces the placeholders of the benchmark settings in a list of arguments
+func (b *Bench) ExpandArgs(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	expanded := make([]string, len(args))
+	for i, a := range args {
+		expanded[i] = b.Expand(a)
+	}
+	return expanded
+}
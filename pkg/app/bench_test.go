@@ -0,0 +1,52 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	tests := []struct {
+		str      string
+		valid    bool
+		expected Bench
+	}{
+		{str: "", valid: true},
+		{str: "seed=42 iterations=1000 msg_sizes=0:22", valid: true, expected: Bench{Seed: 42, Iterations: 1000, MsgSizes: "0:22"}},
+		{str: "msg_sizes=10", valid: true, expected: Bench{MsgSizes: "10"}},
+		{str: "iterations=-1", valid: false},
+		{str: "msg_sizes=1k:4k", valid: false},
+		{str: "warmup=10", valid: false},
+		{str: "seed", valid: false},
+	}
+
+	for _, tt := range tests {
+		b, err := ParseBench(tt.str)
+		if (err == nil) != tt.valid {
+			t.Fatalf("ParseBench(%q) returned %v", tt.str, err)
+		}
+		if tt.valid && (b != tt.expected || b.String() != tt.str) {
+			t.Fatalf("ParseBench(%q) returned %q", tt.str, b.String())
+		}
+	}
+
+	imb := GetIMB(nil)
+	b := imb.Bench.Override(Bench{Seed: 7, Iterations: 10})
+	args := strings.Join(b.ExpandArgs(append(imb.Args, "-seed", SeedPlaceholder)), " ")
+	if args != "-iter 10 -msglog 0:22 -seed 7" {
+		t.Fatalf("invalid arguments: %s", args)
+	}
+	seedArgs := []string{"-seed", SeedPlaceholder}
+	if b.WithSeed(imb.BinPath, seedArgs).Seed != 7 || imb.Bench.WithSeed(imb.BinPath, seedArgs).Seed <= 0 {
+		t.Fatalf("invalid seed of the run")
+	}
+	// The seed of a run not using it is not recorded
+	if b.WithSeed(imb.BinPath, imb.Args).Seed != 0 || imb.Bench.WithSeed(imb.BinPath, imb.Args).Seed != 0 {
+		t.Fatalf("seed recorded for a run not using it")
+	}
+}
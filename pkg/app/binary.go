@@ -0,0 +1,58 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// BinaryType is the type of the applications only available as prebuilt binaries, which are
+	// packaged in the container without being compiled
+	BinaryType = "binary"
+)
+
+// errBinaryFound is used to stop walking a directory once the binary is found
+var errBinaryFound = errors.New("binary found")
+
+// IsBinary checks whether an application is only available as prebuilt binaries
+func (i *Info) IsBinary() bool {
+	return i.Type == BinaryType
+}
+
+// FindBinary returns the path to the executable of an application in a directory with prebuilt
+// binaries, e.g., an unpacked tarball. binName is either relative to the directory (e.g., bin/app)
+// or the name of a file anywhere in the directory.
+func FindBinary(dir string, binName string) (string, error) {
+	p := filepath.Join(dir, binName)
+	if util.FileExists(p) {
+		return p, nil
+	}
+
+	found := ""
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == filepath.Base(binName) {
+			found = path
+			return errBinaryFound
+		}
+		return nil
+	})
+	if err != nil && err != errBinaryFound {
+		return "", fmt.Errorf("failed to look for %s in %s: %s", binName, dir, err)
+	}
+	if found == "" {
+		return "", fmt.Errorf("%s not found in %s", binName, dir)
+	}
+	return found, nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-binary-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	binPath := filepath.Join(dir, "app-1.0", "bin", "app")
+	err = os.MkdirAll(filepath.Dir(binPath), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", filepath.Dir(binPath), err)
+	}
+	err = ioutil.WriteFile(binPath, []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", binPath, err)
+	}
+
+	tests := []struct {
+		binName string
+		found   bool
+	}{
+		{binName: "app-1.0/bin/app", found: true},
+		{binName: "app", found: true},
+		{binName: "bin/app", found: true},
+		{binName: "other", found: false},
+	}
+	for _, tt := range tests {
+		p, err := FindBinary(dir, tt.binName)
+		if tt.found && (err != nil || p != binPath) {
+			t.Fatalf("FindBinary(%s) returned %s instead of %s: %v", tt.binName, p, binPath, err)
+		}
+		if !tt.found && err == nil {
+			t.Fatalf("FindBinary(%s) found %s", tt.binName, p)
+		}
+	}
+}
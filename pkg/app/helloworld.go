@@ -11,6 +11,22 @@ import (
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
+const (
+	// helloworldSrcDir is the name of the directory in the sympi directory where the sources
+	// of the tests are written
+	helloworldSrcDir = "src"
+
+	helloworldExpectedOutput = "Hello, I am rank #RANK/#NP"
+
+	shmemHelloworldExpectedOutput = "Hello, I am PE #RANK/#NP"
+)
+
+// getTestSourcePath returns the path in the sympi directory where a test source embedded in the
+// tool is written when the test is built (see PrepareTestSource)
+func getTestSourcePath(name string) string {
+	return filepath.Join(sys.GetSympiDir(), helloworldSrcDir, name)
+}
+
 // GetHelloworld returns the app.Info structure with all the details for our
 // helloworld test
 func GetHelloworld(sysCfg *sys.Config) Info {
@@ -18,7 +34,21 @@ func GetHelloworld(sysCfg *sys.Config) Info {
 
 	hw.Name = "helloworld"
 	hw.BinPath = "/opt/mpitest"
-	hw.Source = "file://" + filepath.Join(sysCfg.TemplateDir, "mpitest.c")
-	hw.ExpectedRankOutput = "Hello, I am rank #RANK/#NP"
+	hw.Source = "file://" + getTestSourcePath(MPITestC)
+	hw.TestSource = MPITestC
+	hw.ExpectedRankOutput = helloworldExpectedOutput
+	return hw
+}
+
+// GetSHMEMHelloworld returns the app.Info structure with all the details for
+// the OpenSHMEM variant of our helloworld test, compiled with oshcc
+func GetSHMEMHelloworld(sysCfg *sys.Config) Info {
+	var hw Info
+
+	hw.Name = "helloworld-shmem"
+	hw.BinPath = "/opt/shmemtest"
+	hw.Source = "file://" + getTestSourcePath(SHMEMTestC)
+	hw.TestSource = SHMEMTestC
+	hw.ExpectedRankOutput = shmemHelloworldExpectedOutput
 	return hw
 }
@@ -17,5 +17,8 @@ func GetIMB(sysCfg *sys.Config) Info {
 	imb.BinPath = "/opt/mpi-benchmarks/IMB-MPI1"
 	imb.Source = "https://github.com/intel/mpi-benchmarks.git"
 	imb.InstallCmd = "CC=mpicc CXX=mpic++ make IMB-MPI1"
+	// The default settings of IMB are pinned so the results of different runs are comparable
+	imb.Args = []string{"-iter", IterationsPlaceholder, "-msglog", MsgSizesPlaceholder}
+	imb.Bench = Bench{Iterations: 1000, MsgSizes: "0:22"}
 	return imb
 }
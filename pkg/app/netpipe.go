@@ -18,5 +18,6 @@ func GetNetpipe(sysCfg *sys.Config) Info {
 	netpipe.Source = "http://netpipe.cs.ksu.edu/download/NetPIPE-5.1.4.tar.gz"
 	netpipe.InstallCmd = "make mpi"
 	netpipe.ExpectedNote = "max bandwidth: "
+	netpipe.OutputArtifacts = []string{"np.out"}
 	return netpipe
 }
@@ -0,0 +1,41 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+const (
+	// PythonType is the type of the applications installed with pip or conda, e.g., mpi4py or Horovod applications
+	PythonType = "python"
+
+	// CondaDir is the directory where conda is installed in containers
+	CondaDir = "/opt/conda"
+)
+
+// PythonInfo gathers the details specific to Python applications
+type PythonInfo struct {
+	// Version is the version of Python, e.g., 3.8. When set, Python is installed with conda
+	// instead of the package manager of the Linux distribution
+	Version string
+
+	// Requirements is the path on the host to a pip requirements file
+	Requirements string
+
+	// PipInstall is the list of arguments passed to 'pip install', e.g., "horovod numpy"
+	PipInstall string
+
+	// CondaInstall is the list of arguments passed to 'conda install', e.g., "tensorflow=2.1".
+	// When set, Python is installed with conda
+	CondaInstall string
+}
+
+// IsPython checks whether an application is a Python application
+func (i *Info) IsPython() bool {
+	return i.Type == PythonType
+}
+
+// UseConda checks whether the Python environment of an application is installed with conda
+func (i *Info) UseConda() bool {
+	return i.IsPython() && (i.Python.Version != "" || i.Python.CondaInstall != "")
+}
@@ -0,0 +1,197 @@
+#include <mpi.h>
+#include <pthread.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+/*
+ * Probes of the MPI features beyond point-to-point communications. Each probe is executed by all
+ * the ranks and rank 0 reports its result on a line: MPI_FEATURE:<name>:<pass|fail|skip>[:<detail>]
+ * The errors are returned instead of aborting so a failing feature does not prevent the others from
+ * being probed. The only argument is a directory shared by the ranks, used by the MPI-IO probe.
+ */
+
+#define NUM_THREADS 2
+#define VECTOR_COUNT 4
+
+static int rank;
+static int size;
+
+static void report (const char *name, int ok, const char *detail) {
+    int all_ok = 0;
+    int rc;
+
+    rc = MPI_Allreduce (&ok, &all_ok, 1, MPI_INT, MPI_MIN, MPI_COMM_WORLD);
+    if (rc != MPI_SUCCESS)
+        all_ok = 0;
+    if (rank == 0) {
+        if (all_ok)
+            fprintf (stdout, "MPI_FEATURE:%s:pass\n", name);
+        else
+            fprintf (stdout, "MPI_FEATURE:%s:fail:%s\n", name, ok ? "failed on another rank" : detail);
+        fflush (stdout);
+    }
+}
+
+static void skip (const char *name, const char *detail) {
+    if (rank == 0) {
+        fprintf (stdout, "MPI_FEATURE:%s:skip:%s\n", name, detail);
+        fflush (stdout);
+    }
+}
+
+/* One-sided communications: each rank puts its rank in the window of the next rank */
+static void probe_rma (void) {
+    int buf = -1;
+    int value = rank;
+    MPI_Win win;
+
+    if (MPI_Win_create (&buf, sizeof (int), sizeof (int), MPI_INFO_NULL, MPI_COMM_WORLD, &win) != MPI_SUCCESS) {
+        report ("rma", 0, "MPI_Win_create() failed");
+        return;
+    }
+    MPI_Win_set_errhandler (win, MPI_ERRORS_RETURN);
+    if (MPI_Win_fence (0, win) != MPI_SUCCESS ||
+        MPI_Put (&value, 1, MPI_INT, (rank + 1) % size, 0, 1, MPI_INT, win) != MPI_SUCCESS ||
+        MPI_Win_fence (0, win) != MPI_SUCCESS) {
+        MPI_Win_free (&win);
+        report ("rma", 0, "MPI_Put() failed");
+        return;
+    }
+    MPI_Win_free (&win);
+    report ("rma", buf == (rank + size - 1) % size, "unexpected value in the window");
+}
+
+/* Derived datatypes: a strided vector is sent to the next rank and received contiguously */
+static void probe_datatypes (void) {
+    int send[2 * VECTOR_COUNT];
+    int recv[VECTOR_COUNT];
+    int prev = (rank + size - 1) % size;
+    int ok = 1;
+    int i;
+    MPI_Datatype vector;
+
+    for (i = 0; i < 2 * VECTOR_COUNT; i++)
+        send[i] = rank * 100 + i;
+    if (MPI_Type_vector (VECTOR_COUNT, 1, 2, MPI_INT, &vector) != MPI_SUCCESS ||
+        MPI_Type_commit (&vector) != MPI_SUCCESS) {
+        report ("datatypes", 0, "MPI_Type_vector() failed");
+        return;
+    }
+    if (MPI_Sendrecv (send, 1, vector, (rank + 1) % size, 0,
+                      recv, VECTOR_COUNT, MPI_INT, prev, 0, MPI_COMM_WORLD, MPI_STATUS_IGNORE) != MPI_SUCCESS) {
+        MPI_Type_free (&vector);
+        report ("datatypes", 0, "MPI_Sendrecv() failed");
+        return;
+    }
+    MPI_Type_free (&vector);
+    for (i = 0; i < VECTOR_COUNT; i++) {
+        if (recv[i] != prev * 100 + 2 * i)
+            ok = 0;
+    }
+    report ("datatypes", ok, "unexpected data received");
+}
+
+/* MPI-IO: each rank writes its rank in a file of the shared directory and reads the one of the next rank */
+static void probe_mpiio (const char *dir) {
+    char path[4096];
+    int value = -1;
+    MPI_File fh;
+
+    if (dir == NULL) {
+        skip ("mpi-io", "no directory");
+        return;
+    }
+    snprintf (path, sizeof (path), "%s/mpiio.dat", dir);
+    if (MPI_File_open (MPI_COMM_WORLD, path, MPI_MODE_CREATE | MPI_MODE_RDWR, MPI_INFO_NULL, &fh) != MPI_SUCCESS) {
+        report ("mpi-io", 0, "MPI_File_open() failed");
+        return;
+    }
+    if (MPI_File_write_at_all (fh, rank * sizeof (int), &rank, 1, MPI_INT, MPI_STATUS_IGNORE) != MPI_SUCCESS ||
+        MPI_File_sync (fh) != MPI_SUCCESS ||
+        MPI_Barrier (MPI_COMM_WORLD) != MPI_SUCCESS ||
+        MPI_File_sync (fh) != MPI_SUCCESS ||
+        MPI_File_read_at_all (fh, ((rank + 1) % size) * sizeof (int), &value, 1, MPI_INT, MPI_STATUS_IGNORE) != MPI_SUCCESS) {
+        MPI_File_close (&fh);
+        report ("mpi-io", 0, "MPI_File_write_at_all() or MPI_File_read_at_all() failed");
+        return;
+    }
+    MPI_File_close (&fh);
+    report ("mpi-io", value == (rank + 1) % size, "unexpected data read");
+    if (rank == 0)
+        MPI_File_delete (path, MPI_INFO_NULL);
+}
+
+struct thread_arg {
+    MPI_Comm comm;
+    int result;
+    int rc;
+};
+
+static void *thread_allreduce (void *p) {
+    struct thread_arg *arg = p;
+    int one = 1;
+
+    arg->rc = MPI_Allreduce (&one, &arg->result, 1, MPI_INT, MPI_SUM, arg->comm);
+    return NULL;
+}
+
+/* Threads: several threads of each rank execute collective operations concurrently */
+static void probe_threads (int provided) {
+    pthread_t threads[NUM_THREADS];
+    int created[NUM_THREADS];
+    struct thread_arg args[NUM_THREADS];
+    int ok = 1;
+    int i;
+
+    if (provided < MPI_THREAD_MULTIPLE) {
+        skip ("threads", "MPI_THREAD_MULTIPLE not provided");
+        return;
+    }
+    for (i = 0; i < NUM_THREADS; i++) {
+        if (MPI_Comm_dup (MPI_COMM_WORLD, &args[i].comm) != MPI_SUCCESS) {
+            report ("threads", 0, "MPI_Comm_dup() failed");
+            return;
+        }
+        args[i].result = 0;
+        args[i].rc = MPI_ERR_OTHER;
+    }
+    for (i = 0; i < NUM_THREADS; i++) {
+        /* The operation is executed by the main thread when no thread can be created, so the
+           other ranks do not wait for it forever */
+        created[i] = pthread_create (&threads[i], NULL, thread_allreduce, &args[i]) == 0;
+        if (!created[i]) {
+            ok = 0;
+            thread_allreduce (&args[i]);
+        }
+    }
+    for (i = 0; i < NUM_THREADS; i++) {
+        if (created[i])
+            pthread_join (threads[i], NULL);
+    }
+    for (i = 0; i < NUM_THREADS; i++) {
+        if (args[i].rc != MPI_SUCCESS || args[i].result != size)
+            ok = 0;
+        MPI_Comm_free (&args[i].comm);
+    }
+    report ("threads", ok, "concurrent MPI_Allreduce() failed");
+}
+
+int main (int argc, char **argv) {
+    int provided = MPI_THREAD_SINGLE;
+
+    if (MPI_Init_thread (&argc, &argv, MPI_THREAD_MULTIPLE, &provided) != MPI_SUCCESS) {
+        fprintf (stderr, "MPI_Init_thread() failed");
+        return EXIT_FAILURE;
+    }
+    MPI_Comm_set_errhandler (MPI_COMM_WORLD, MPI_ERRORS_RETURN);
+    MPI_Comm_rank (MPI_COMM_WORLD, &rank);
+    MPI_Comm_size (MPI_COMM_WORLD, &size);
+
+    probe_rma ();
+    probe_datatypes ();
+    probe_mpiio (argc > 1 ? argv[1] : NULL);
+    probe_threads (provided);
+
+    MPI_Finalize ();
+    return EXIT_SUCCESS;
+}
@@ -0,0 +1,82 @@
+#include <mpi.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+static const char *thread_levels[] = {"single", "funneled", "serialized", "multiple"};
+
+static int get_thread_level (const char *name, int *level) {
+    int values[] = {MPI_THREAD_SINGLE, MPI_THREAD_FUNNELED, MPI_THREAD_SERIALIZED, MPI_THREAD_MULTIPLE};
+    int i;
+
+    for (i = 0; i < 4; i++) {
+        if (strcmp (name, thread_levels[i]) == 0) {
+            *level = values[i];
+            return 0;
+        }
+    }
+    return -1;
+}
+
+static const char *get_thread_level_name (int level) {
+    if (level == MPI_THREAD_SINGLE) {
+        return thread_levels[0];
+    }
+    if (level == MPI_THREAD_FUNNELED) {
+        return thread_levels[1];
+    }
+    if (level == MPI_THREAD_SERIALIZED) {
+        return thread_levels[2];
+    }
+    if (level == MPI_THREAD_MULTIPLE) {
+        return thread_levels[3];
+    }
+    return "unknown";
+}
+
+/*
+ * Probe of the MPI library used at run time: MPI_Get_version and MPI_Get_library_version can be
+ * called before MPI_Init, the probe therefore does not need to be started by mpirun.
+ *
+ * When a thread level is passed as argument (single, funneled, serialized or multiple), the probe
+ * also initializes MPI with MPI_Init_thread to report the thread level provided by the library.
+ */
+int main (int argc, char **argv) {
+    int version;
+    int subversion;
+    int len;
+    int required;
+    int provided;
+    char library[MPI_MAX_LIBRARY_VERSION_STRING];
+
+    fprintf (stdout, "COMPILED_MPI_VERSION: %d.%d\n", MPI_VERSION, MPI_SUBVERSION);
+
+    if (MPI_Get_version (&version, &subversion) != MPI_SUCCESS) {
+        fprintf (stderr, "MPI_Get_version() failed");
+        return EXIT_FAILURE;
+    }
+    fprintf (stdout, "MPI_VERSION: %d.%d\n", version, subversion);
+
+    if (MPI_Get_library_version (library, &len) != MPI_SUCCESS) {
+        fprintf (stderr, "MPI_Get_library_version() failed");
+        return EXIT_FAILURE;
+    }
+    fprintf (stdout, "MPI_LIBRARY_VERSION: %s\n", library);
+
+    if (argc < 2) {
+        return EXIT_SUCCESS;
+    }
+
+    if (get_thread_level (argv[1], &required) != 0) {
+        fprintf (stderr, "unknown thread level: %s", argv[1]);
+        return EXIT_FAILURE;
+    }
+    if (MPI_Init_thread (&argc, &argv, required, &provided) != MPI_SUCCESS) {
+        fprintf (stderr, "MPI_Init_thread() failed");
+        return EXIT_FAILURE;
+    }
+    fprintf (stdout, "MPI_THREAD_PROVIDED: %s\n", get_thread_level_name (provided));
+    MPI_Finalize ();
+
+    return EXIT_SUCCESS;
+}
@@ -0,0 +1,31 @@
+program mpitest
+    use mpi
+    implicit none
+    integer :: ierr
+    integer :: size
+    integer :: myrank
+
+    call MPI_Init(ierr)
+    if (ierr /= MPI_SUCCESS) then
+        write (0, *) "MPI_Init() failed"
+        stop 1
+    end if
+
+    call MPI_Comm_size(MPI_COMM_WORLD, size, ierr)
+    if (ierr /= MPI_SUCCESS) then
+        write (0, *) "MPI_Comm_size() failed"
+        call MPI_Finalize(ierr)
+        stop 1
+    end if
+
+    call MPI_Comm_rank(MPI_COMM_WORLD, myrank, ierr)
+    if (ierr /= MPI_SUCCESS) then
+        write (0, *) "MPI_Comm_rank() failed"
+        call MPI_Finalize(ierr)
+        stop 1
+    end if
+
+    write (6, '(A,I0,A,I0)') "Hello, I am rank ", myrank, "/", size
+
+    call MPI_Finalize(ierr)
+end program mpitest
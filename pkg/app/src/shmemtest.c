@@ -0,0 +1,27 @@
+#include <shmem.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+int main (int argc, char **argv) {
+    int npes;
+    int me;
+
+    shmem_init ();
+
+    npes = shmem_n_pes ();
+    me = shmem_my_pe ();
+    if (npes <= 0 || me < 0) {
+        fprintf (stderr, "shmem_init() failed");
+        shmem_finalize ();
+        return EXIT_FAILURE;
+    }
+
+    /* Make sure all the PEs are able to communicate before reporting */
+    shmem_barrier_all ();
+
+    fprintf (stdout, "Hello, I am PE %d/%d\n", me, npes);
+
+    shmem_finalize ();
+
+    return EXIT_SUCCESS;
+}
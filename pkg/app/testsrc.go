@@ -0,0 +1,106 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// MPITestC is the name of the C source of the MPI hello-world test
+	MPITestC = "mpitest.c"
+
+	// MPITestFortran is the name of the Fortran source of the MPI hello-world test
+	MPITestFortran = "mpitest.f90"
+
+	// SHMEMTestC is the name of the C source of the OpenSHMEM hello-world test
+	SHMEMTestC = "shmemtest.c"
+
+	// MPIProbeC is the name of the C source of the probe reporting the version of the MPI library
+	// used at run time, used to predict the compatibility of a container with a MPI on the host, and
+	// the thread level it provides
+	MPIProbeC = "mpiprobe.c"
+
+	// MPIFeaturesC is the name of the C source of the probes of the MPI features beyond point-to-point
+	// communications: one-sided communications, derived datatypes, MPI-IO and threads
+	MPIFeaturesC = "mpifeatures.c"
+
+	// testSourcesDir is the directory of the package with the sources of the tests
+	testSourcesDir = "src"
+)
+
+// testSources are the sources of the tests, embedded in the binary so the tool does not depend
+// on any file installed next to it
+//
+//go:embed src
+var testSources embed.FS
+
+// GetTestSourceNames returns the name of all the test sources embedded in the tool
+func GetTestSourceNames() []string {
+	return []string{MPITestC, MPITestFortran, SHMEMTestC, MPIProbeC, MPIFeaturesC}
+}
+
+// GetTestSource returns the content of a test source embedded in the tool
+func GetTestSource(name string) ([]byte, error) {
+	content, err := testSources.ReadFile(testSourcesDir + "/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown test source %s: %s", name, err)
+	}
+	return content, nil
+}
+
+// WriteTestSource writes a test source embedded in the tool in a directory and returns the path to the file
+func WriteTestSource(name string, dir string) (string, error) {
+	content, err := GetTestSource(name)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", dir, err)
+	}
+
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %s", path, err)
+	}
+
+	return path, nil
+}
+
+// WriteTestSources writes all the test sources embedded in the tool in a directory
+func WriteTestSources(dir string) ([]string, error) {
+	var paths []string
+	for _, name := range GetTestSourceNames() {
+		path, err := WriteTestSource(name, dir)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// PrepareTestSource writes the test source embedded in the tool an application is compiled from,
+// if any, where the Source of the application points; it is called when the application is
+// built so getting the details of a test has no side effect
+func PrepareTestSource(a *Info) error {
+	if a.TestSource == "" {
+		return nil
+	}
+	path := GetLocalPath(a.Source)
+	if path == "" {
+		return fmt.Errorf("the source of %s is not a local file: %s", a.Name, a.Source)
+	}
+	_, err := WriteTestSource(a.TestSource, filepath.Dir(path))
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestWriteTestSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-testsrc-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths, err := WriteTestSources(dir)
+	if err != nil {
+		t.Fatalf("WriteTestSources() failed: %s", err)
+	}
+	if len(paths) != len(GetTestSourceNames()) {
+		t.Fatalf("%d test sources written instead of %d", len(paths), len(GetTestSourceNames()))
+	}
+
+	for i, name := range GetTestSourceNames() {
+		expected, err := GetTestSource(name)
+		if err != nil || len(expected) == 0 {
+			t.Fatalf("test source %s is not embedded: %s", name, err)
+		}
+		content, err := ioutil.ReadFile(paths[i])
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", paths[i], err)
+		}
+		if !bytes.Equal(content, expected) {
+			t.Fatalf("content of %s does not match the embedded source", paths[i])
+		}
+	}
+
+	_, err = GetTestSource("unknown.c")
+	if err == nil {
+		t.Fatalf("GetTestSource() succeeded with an unknown source")
+	}
+}
+
+func TestPrepareTestSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-testsrc-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	// Getting the details of a test does not write anything
+	hw := GetHelloworld(nil)
+	path := GetLocalPath(hw.Source)
+	if util.PathExists(path) {
+		t.Fatalf("%s written when getting the details of the test", path)
+	}
+
+	err = PrepareTestSource(&hw)
+	if err != nil {
+		t.Fatalf("PrepareTestSource() failed: %s", err)
+	}
+	if !util.FileExists(path) {
+		t.Fatalf("%s not written when preparing the test", path)
+	}
+
+	// Nothing is done for the applications that are not tests of the tool
+	imb := GetIMB(nil)
+	err = PrepareTestSource(&imb)
+	if err != nil {
+		t.Fatalf("PrepareTestSource() failed with IMB: %s", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// InitTest, NetpipeTest and IMBTest are the names of the tests validating a MPI implementation
+	// for each pair of versions of the compatibility matrix, used to name their result files,
+	// e.g., openmpi-init-results.txt
+	InitTest    = "init"
+	NetpipeTest = "netpipe"
+	IMBTest     = "imb"
+)
+
+// ValidationTest is a test validating a MPI implementation for each pair of versions of the
+// compatibility matrix
+type ValidationTest struct {
+	// Name is the name of the test, e.g., InitTest
+	Name string
+
+	// App is the application executed by the test
+	App Info
+}
+
+// GetValidationTestNames returns the names of the tests validating a MPI implementation, in the
+// order they are executed: the MPI tests, and only the hello-world test with the OpenSHMEM
+// implementations that do not provide MPI, i.e., Sandia OpenSHMEM
+func GetValidationTestNames(mpiCfg *implem.Info) []string {
+	if mpiCfg != nil && mpiCfg.ID == implem.SOS {
+		return []string{InitTest}
+	}
+	return []string{InitTest, NetpipeTest, IMBTest}
+}
+
+// GetValidationTests returns the tests validating a MPI implementation (see GetValidationTestNames);
+// the hello-world test of the OpenSHMEM implementations is the OpenSHMEM variant compiled with oshcc
+func GetValidationTests(mpiCfg *implem.Info, sysCfg *sys.Config) []ValidationTest {
+	var tests []ValidationTest
+	for _, name := range GetValidationTestNames(mpiCfg) {
+		var a Info
+		switch name {
+		case InitTest:
+			a = GetHelloworld(sysCfg)
+			if implem.IsSHMEM(mpiCfg) {
+				a = GetSHMEMHelloworld(sysCfg)
+			}
+		case NetpipeTest:
+			a = GetNetpipe(sysCfg)
+		case IMBTest:
+			a = GetIMB(sysCfg)
+		}
+		tests = append(tests, ValidationTest{Name: name, App: a})
+	}
+	return tests
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+func TestGetValidationTests(t *testing.T) {
+	tests := []struct {
+		mpiID      string
+		names      string
+		helloworld string
+	}{
+		{mpiID: implem.OMPI, names: "init netpipe imb", helloworld: MPITestC},
+		{mpiID: implem.OSHMEM, names: "init netpipe imb", helloworld: SHMEMTestC},
+		{mpiID: implem.SOS, names: "init", helloworld: SHMEMTestC},
+	}
+
+	for _, tt := range tests {
+		validationTests := GetValidationTests(&implem.Info{ID: tt.mpiID}, nil)
+		var names []string
+		for _, test := range validationTests {
+			names = append(names, test.Name)
+		}
+		if strings.Join(names, " ") != tt.names {
+			t.Fatalf("the validation tests of %s are %v instead of %s", tt.mpiID, names, tt.names)
+		}
+		if validationTests[0].App.TestSource != tt.helloworld {
+			t.Fatalf("the hello-world test of %s is %s instead of %s", tt.mpiID, validationTests[0].App.TestSource, tt.helloworld)
+		}
+	}
+}
@@ -19,11 +19,14 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gvallee/go_util/pkg/util"
 	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/internal/pkg/persistent"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
@@ -62,6 +65,19 @@ type Info struct {
 
 	// Env is the environment to use with the build environment
 	Env []string
+
+	// DownloadRateLimit is the maximum bandwidth used to download software, e.g., 10m (see
+	// ValidateRateLimit), there is no limit when empty
+	DownloadRateLimit string
+
+	// CAFile is the bundle of PEM certificates of the CAs trusted when downloading software and
+	// checking out Git repositories, if any; git only trusts its CAs so it should include the CAs
+	// of the system, see sys.GetCABundle
+	CAFile string
+
+	// Builder is the container in which the software is configured, compiled and installed in
+	// the tool-in-container mode; the host is used when nil
+	Builder *syexec.Container
 }
 
 // Unpack extracts the source code from a package/tarball/zip file.
@@ -100,6 +116,30 @@ func (env *Info) Unpack() error {
 		return fmt.Errorf("unsupported format: %s", format)
 	}
 
+	// List the top-level entries of the tarball so we know what is extracted, even when the build
+	// directory already has other files, e.g., after a failed build kept for debugging
+	entries, err := listTarballEntries(tarPath, tarArg, env.SrcPath)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%s is empty", env.SrcPath)
+	}
+	for _, e := range entries {
+		// Remove what is left from a previous extraction so stale files do not end up in the build
+		p := filepath.Join(env.BuildDir, e)
+		if p == filepath.Clean(env.BuildDir) || !isInDir(p, env.BuildDir) {
+			return fmt.Errorf("unsafe tarball %s: %s is not in %s", env.SrcPath, e, env.BuildDir)
+		}
+		if p != env.SrcPath && util.PathExists(p) {
+			log.Printf("-> Removing %s from a previous build\n", p)
+			err = os.RemoveAll(p)
+			if err != nil {
+				return fmt.Errorf("failed to remove %s: %s", p, err)
+			}
+		}
+	}
+
 	// Untar the package
 	log.Printf("-> Executing from %s: %s %s %s \n", env.BuildDir, tarPath, tarArg, env.SrcPath)
 	var stdout, stderr bytes.Buffer
@@ -107,7 +147,7 @@ func (env *Info) Unpack() error {
 	cmd.Dir = env.BuildDir
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
-	err = cmd.Run()
+	err = syexec.RunCmd(cmd)
 	if err != nil {
 		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 	}
@@ -118,19 +158,69 @@ func (env *Info) Unpack() error {
 		return fmt.Errorf("failed to delete %s: %s", env.SrcPath, err)
 	}
 
-	// We save the directory created while untaring the tarball
-	entries, err := ioutil.ReadDir(env.BuildDir)
-	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %s", env.BuildDir, err)
-	}
-	if len(entries) != 1 {
-		return fmt.Errorf("inconsistent temporary %s directory, %d files instead of 1", env.BuildDir, len(entries))
+	// We save the directory created while untaring the tarball; a tarball without a top-level
+	// directory is extracted directly in the build directory
+	env.SrcDir = env.BuildDir
+	if len(entries) == 1 && util.IsDir(filepath.Join(env.BuildDir, entries[0])) {
+		env.SrcDir = filepath.Join(env.BuildDir, entries[0])
+	} else {
+		log.Printf("[WARN] %s does not have a single top-level directory, using %s as source directory\n", env.SrcPath, env.BuildDir)
 	}
-	env.SrcDir = filepath.Join(env.BuildDir, entries[0].Name())
 
 	return nil
 }
 
+// getTopLevelEntries returns the top-level entries from the list of the files of a tarball, in
+// order; a tarball with absolute paths or paths including '..' is rejected since it would be
+// extracted outside of the build directory
+func getTopLevelEntries(list string) ([]string, error) {
+	var entries []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "/") {
+			return nil, fmt.Errorf("invalid entry %s: absolute paths are not allowed", line)
+		}
+		for _, elt := range strings.Split(line, "/") {
+			if elt == ".." {
+				return nil, fmt.Errorf("invalid entry %s: paths including '..' are not allowed", line)
+			}
+		}
+		line = strings.TrimPrefix(line, "./")
+		entry := strings.Split(line, "/")[0]
+		if entry == "" || entry == "." || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// isInDir checks whether a path, once cleaned, is a directory or is in a directory
+func isInDir(path string, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../") && !filepath.IsAbs(rel)
+}
+
+// listTarballEntries returns the top-level entries of a tarball, tarArg being the arguments to
+// extract it, e.g., -xzf
+func listTarballEntries(tarPath string, tarArg string, tarball string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(tarPath, strings.Replace(tarArg, "x", "t", 1), tarball)
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the content of %s: %s - stderr: %s", tarball, err, stderr.String())
+	}
+	entries, err := getTopLevelEntries(stdout.String())
+	if err != nil {
+		return nil, fmt.Errorf("unsafe tarball %s: %s", tarball, err)
+	}
+	return entries, nil
+}
+
 // RunMake executes the appropriate command to build the software
 func (env *Info) RunMake(priv bool, args []string, stage string) error {
 	// Some sanity checks
@@ -145,8 +235,15 @@ func (env *Info) RunMake(priv bool, args []string, stage string) error {
 		makeCmd.ManifestName = strings.Join(args, "_")
 	}
 
-	args = append([]string{"-j4"}, args...)
+	limits := syexec.GetLimits()
+	args = append([]string{"-j" + strconv.Itoa(limits.GetJobs())}, args...)
 	logMsg := "make " + strings.Join(args, " ")
+	if priv && env.Builder != nil {
+		// sudo is not available in the builder container, the installation directory is
+		// anyway in the workspace
+		log.Printf("[WARN] privileged installations are not supported in the builder container, installing as the current user")
+		priv = false
+	}
 	if !priv {
 		makeCmd.BinPath = "make"
 	} else {
@@ -164,6 +261,12 @@ func (env *Info) RunMake(priv bool, args []string, stage string) error {
 		makeCmd.Env = env.Env
 	}
 	makeCmd.ExecDir = env.SrcDir
+	makeCmd.Container = env.Builder
+	makeCmd.Limited = true
+	makeCmd.StreamPrefix = "[make]"
+	if stage != "" {
+		makeCmd.StreamPrefix = "[make " + stage + "]"
+	}
 	res := makeCmd.Run()
 	if res.Err != nil {
 		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", res.Err, res.Stdout, res.Stderr)
@@ -195,6 +298,48 @@ func (env *Info) copyTarball(p *SoftwarePackage) error {
 	return nil
 }
 
+// syncDir synchronizes a local source directory into the build directory; only the files that
+// changed are copied so the software can be rebuilt incrementally
+func (env *Info) syncDir(p *SoftwarePackage) error {
+	srcDir := filepath.Clean(app.GetLocalPath(p.URL))
+	targetDir := filepath.Join(env.BuildDir, filepath.Base(srcDir))
+
+	var cmd syexec.SyCmd
+	rsyncBin, err := exec.LookPath("rsync")
+	if err == nil {
+		cmd.BinPath = rsyncBin
+		cmd.CmdArgs = []string{"-a", "--delete", srcDir + "/", targetDir + "/"}
+	} else {
+		log.Println("[WARN] rsync is not available, copying the entire directory")
+		err = os.RemoveAll(targetDir)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %s", targetDir, err)
+		}
+		cmd.BinPath = "cp"
+		cmd.CmdArgs = []string{"-a", srcDir, targetDir}
+	}
+	res := cmd.Run()
+	if res.Err != nil {
+		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", res.Err, res.Stdout, res.Stderr)
+	}
+
+	// Like with a Git checkout, there is nothing to unpack
+	env.SrcPath = targetDir
+	env.SrcDir = targetDir
+
+	return nil
+}
+
+// getGitArgs returns the global arguments of git, i.e., the CA bundle of the environment if any;
+// it is passed on the command line rather than with GIT_SSL_CAINFO so it does not apply to the git
+// commands executed by the builds
+func (env *Info) getGitArgs() []string {
+	if env.CAFile == "" {
+		return nil
+	}
+	return []string{"-c", "http.sslCAInfo=" + env.CAFile}
+}
+
 func (env *Info) gitCheckout(p *SoftwarePackage) error {
 	// todo: should it be cached in sysCfg and passed in?
 	gitBin, err := exec.LookPath("git")
@@ -207,25 +352,25 @@ func (env *Info) gitCheckout(p *SoftwarePackage) error {
 	checkoutPath := filepath.Join(env.BuildDir, repoName)
 
 	if util.PathExists(checkoutPath) {
-		gitCmd := exec.Command(gitBin, "pull")
+		gitCmd := exec.Command(gitBin, append(env.getGitArgs(), "pull")...)
 		log.Printf("Running from %s: %s pull\n", checkoutPath, gitBin)
 		gitCmd.Dir = checkoutPath
 		var stderr, stdout bytes.Buffer
 		gitCmd.Stderr = &stderr
 		gitCmd.Stdout = &stdout
-		err = gitCmd.Run()
+		err = syexec.RunCmd(gitCmd)
 		if err != nil {
 			return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 		}
 
 	} else {
-		gitCmd := exec.Command(gitBin, "clone", p.URL)
+		gitCmd := exec.Command(gitBin, append(env.getGitArgs(), "clone", p.URL)...)
 		log.Printf("Running from %s: %s clone %s\n", env.BuildDir, gitBin, p.URL)
 		gitCmd.Dir = env.BuildDir
 		var stderr, stdout bytes.Buffer
 		gitCmd.Stderr = &stderr
 		gitCmd.Stdout = &stdout
-		err = gitCmd.Run()
+		err = syexec.RunCmd(gitCmd)
 		if err != nil {
 			return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 		}
@@ -257,6 +402,13 @@ func (env *Info) Get(p *SoftwarePackage) error {
 
 	switch urlFormat {
 	case util.FileURL:
+		if app.IsLocalDir(p.URL) {
+			err := env.syncDir(p)
+			if err != nil {
+				return fmt.Errorf("impossible to synchronize the source directory: %s", err)
+			}
+			break
+		}
 		err := env.copyTarball(p)
 		if err != nil {
 			return fmt.Errorf("impossible to copy the tarball: %s", err)
@@ -264,12 +416,12 @@ func (env *Info) Get(p *SoftwarePackage) error {
 	case util.HttpURL:
 		err := env.download(p)
 		if err != nil {
-			return fmt.Errorf("impossible to download %s: %s", p.Name, err)
+			return sympierr.Wrap(sympierr.ErrDownloadFailed, err, "impossible to download %s", p.Name)
 		}
 	case util.GitURL:
 		err := env.gitCheckout(p)
 		if err != nil {
-			return fmt.Errorf("impossible to get Git repository %s: %s", p.URL, err)
+			return sympierr.Wrap(sympierr.ErrDownloadFailed, err, "impossible to get Git repository %s", p.URL)
 		}
 	default:
 		return fmt.Errorf("impossible to detect URL type: %s", p.URL)
@@ -278,51 +430,14 @@ func (env *Info) Get(p *SoftwarePackage) error {
 	return nil
 }
 
-func (env *Info) download(p *SoftwarePackage) error {
-	// Sanity checks
-	if p.URL == "" || env.BuildDir == "" {
-		return fmt.Errorf("invalid parameter(s)")
-	}
-
-	log.Printf("- Downloading %s from %s...", p.Name, p.URL)
-
-	// todo: do not assume wget
-	binPath, err := exec.LookPath("wget")
-	if err != nil {
-		return fmt.Errorf("cannot find wget: %s", err)
-	}
-
-	log.Printf("* Executing from %s: %s %s", env.BuildDir, binPath, p.URL)
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Command(binPath, p.URL)
-	cmd.Dir = env.BuildDir
-	cmd.Stderr = &stderr
-	cmd.Stdout = &stdout
-	err = cmd.Run()
-	if err != nil {
-		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
-	}
-
-	// todo: we currently assume that we have one and only one file in the
-	// directory This is not a fair assumption, especially while debugging
-	// when we do not wipe out the temporary directories
-	files, err := ioutil.ReadDir(env.BuildDir)
-	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %s", env.BuildDir, err)
-	}
-	if len(files) != 1 {
-		return fmt.Errorf("inconsistent temporary %s directory, %d files instead of 1", env.BuildDir, len(files))
-	}
-	p.tarball = files[0].Name()
-	env.SrcPath = filepath.Join(env.BuildDir, files[0].Name())
-
-	return nil
-}
-
 // IsInstalled checks whether a specific software package is already installed in a specific build environment
 func (env *Info) IsInstalled(p *SoftwarePackage) bool {
 	switch util.DetectURLType(p.URL) {
 	case util.FileURL:
+		if app.IsLocalDir(p.URL) {
+			// Local directories are always synchronized to get the latest changes
+			return false
+		}
 		filename := path.Base(p.URL)
 		filePathInBuildDir := filepath.Join(env.BuildDir, filename)
 		filePathInInstallDir := filepath.Join(env.InstallDir, filename)
@@ -387,6 +502,7 @@ func (env *Info) Install(p *SoftwarePackage) error {
 	cmd.ManifestName = "install"
 	cmd.ManifestDir = env.InstallDir
 	cmd.Env = env.Env
+	cmd.Container = env.Builder
 
 	log.Printf("Executing from %s: %s %s.", env.SrcDir, cmd.BinPath, strings.Join(cmdElts[1:], " "))
 	log.Printf("Environment: %s\n", strings.Join(env.Env, "\n"))
@@ -480,9 +596,9 @@ func CreateDefaultHostEnvCfg(env *Info, mpi *implem.Info, sysCfg *sys.Config) er
 	return createNoMPIHostEnvCfg(env, sysCfg)
 }
 
-func createContainerNonpersistentMPIBuildEnv(containerBuildEnv *Info, sysCfg *sys.Config) (func(), error) {
+func createContainerNonpersistentMPIBuildEnv(containerBuildEnv *Info, sysCfg *sys.Config) (func(bool), error) {
 	var err error
-	var cleanup func()
+	var cleanup func(bool)
 
 	// If we do not integrate with the sympi (i.e., no persistent mode), all subdirectories
 	// in the system wide scratch directory or, if that directory is not defined, in a new
@@ -500,51 +616,49 @@ func createContainerNonpersistentMPIBuildEnv(containerBuildEnv *Info, sysCfg *sy
 	containerBuildEnv.BuildDir = filepath.Join(containerBuildEnv.ScratchDir, "container", "build")
 	containerBuildEnv.InstallDir = filepath.Join(containerBuildEnv.ScratchDir, "install")
 
-	cleanup = func() {
-		err := os.RemoveAll(containerBuildEnv.ScratchDir)
-		if err != nil {
-			log.Printf("failed to cleanup %s: %s", containerBuildEnv.ScratchDir, err)
-		}
-		err = os.RemoveAll(containerBuildEnv.BuildDir)
-		if err != nil {
-			log.Printf("failed to cleanup %s: %s", containerBuildEnv.BuildDir, err)
-		}
-		err = os.RemoveAll(containerBuildEnv.InstallDir)
-		if err != nil {
-			log.Printf("failed to cleanup %s: %s", containerBuildEnv.InstallDir, err)
-		}
+	cleanup = func(failed bool) {
+		RemoveScratch(failed, sysCfg, sys.ScratchDirResource, containerBuildEnv.ScratchDir, containerBuildEnv.InstallDir)
+		RemoveScratch(failed, sysCfg, sys.BuildDirResource, containerBuildEnv.BuildDir)
 	}
 
 	return cleanup, err
 }
 
-func createContainerPersistentMPIBuildEnv(containerBuildEnv *Info, kvs []kv.KV, sysCfg *sys.Config) (func(), error) {
+func createContainerPersistentMPIBuildEnv(containerBuildEnv *Info, kvs []kv.KV, sysCfg *sys.Config) (func(bool), error) {
 	var err error
-	var cleanup func()
+	var cleanup func(bool)
 
-	containerBuildEnv.ScratchDir = filepath.Join(sysCfg.Persistent, "scratch_"+kv.GetValue(kvs, "app_name"))
-	containerBuildEnv.BuildDir = filepath.Join(sysCfg.Persistent, "build_"+kv.GetValue(kvs, "app_name"))
+	// The scratch and build directories are in the fast scratch root when one is available, the
+	// image being copied back to the installation directory once created
+	scratchBase := sysCfg.Persistent
+	if sysCfg.ScratchRoot != "" {
+		scratchBase = GetScratchBase(sysCfg)
+	}
+	containerBuildEnv.ScratchDir = filepath.Join(scratchBase, "scratch_"+kv.GetValue(kvs, "app_name"))
+	containerBuildEnv.BuildDir = filepath.Join(scratchBase, "build_"+kv.GetValue(kvs, "app_name"))
 	containerBuildEnv.InstallDir = filepath.Join(sysCfg.Persistent, sys.ContainerInstallDirPrefix+kv.GetValue(kvs, "app_name"))
 
-	cleanup = func() {
-		err := os.RemoveAll(containerBuildEnv.ScratchDir)
-		if err != nil {
-			log.Printf("failed to cleanup %s: %s", containerBuildEnv.ScratchDir, err)
-		}
-		err = os.RemoveAll(containerBuildEnv.BuildDir)
-		if err != nil {
-			log.Printf("failed to cleanup %s: %s", containerBuildEnv.BuildDir, err)
-		}
+	// The scratch directory is locked until the build completes so it is not garbage collected
+	lock, err := LockScratch(containerBuildEnv.ScratchDir)
+	if err != nil {
+		return nil, err
+	}
+	cleanup = func(failed bool) {
+		RemoveScratch(failed, sysCfg, sys.ScratchDirResource, containerBuildEnv.ScratchDir)
+		RemoveScratch(failed, sysCfg, sys.BuildDirResource, containerBuildEnv.BuildDir)
+		lock.Unlock()
 	}
 
-	return cleanup, err
+	return cleanup, nil
 }
 
 // CreateDefaultContainerEnvCfg sets all the details for a default build environment for any
-// type of application (it does not have to be a MPI application)
-func CreateDefaultContainerEnvCfg(containerBuildEnv *Info, kvs []kv.KV, sysCfg *sys.Config) (func(), error) {
+// type of application (it does not have to be a MPI application). The returned function removes
+// the scratch and build directories according to the cleanup policy, depending on whether the
+// build failed.
+func CreateDefaultContainerEnvCfg(containerBuildEnv *Info, kvs []kv.KV, sysCfg *sys.Config) (func(bool), error) {
 	var err error
-	var cleanup func()
+	var cleanup func(bool)
 
 	if sys.IsPersistent(sysCfg) {
 		cleanup, err = createContainerPersistentMPIBuildEnv(containerBuildEnv, kvs, sysCfg)
@@ -555,9 +669,28 @@ func CreateDefaultContainerEnvCfg(containerBuildEnv *Info, kvs []kv.KV, sysCfg *
 	return cleanup, err
 }
 
-// GetDefaultScratchDir returns the default directory to use as scratch directory
-func GetDefaultScratchDir(mpi *implem.Info) string {
-	return filepath.Join(sys.GetSympiDir(), "scratch-"+mpi.ID)
+// RemoveScratch removes the temporary directories of a given type, e.g., sys.BuildDirResource,
+// used during a build, unless the cleanup policy specifies to keep them, for instance to reproduce
+// a failure.
+func RemoveScratch(failed bool, sysCfg *sys.Config, resource string, dirs ...string) {
+	for _, d := range dirs {
+		if !sys.ShouldCleanup(sysCfg, resource, failed) {
+			if failed {
+				fmt.Printf("Build failed, %s is kept for debugging\n", d)
+			}
+			continue
+		}
+		err := os.RemoveAll(d)
+		if err != nil {
+			log.Printf("failed to cleanup %s: %s", d, err)
+		}
+	}
+}
+
+// GetDefaultScratchDir returns the default directory to use as scratch directory, in the fast
+// scratch root when one is available (see GetScratchBase)
+func GetDefaultScratchDir(mpi *implem.Info, sysCfg *sys.Config) string {
+	return filepath.Join(GetScratchBase(sysCfg), "scratch-"+mpi.ID)
 }
 
 // Init ensures that the buildenv is correctly initialized
@@ -0,0 +1,208 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+)
+
+func TestGetLocalDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-buildenv-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "myapp")
+	buildDir := filepath.Join(dir, "build")
+	for _, d := range []string{srcDir, buildDir} {
+		err = os.MkdirAll(d, 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(srcDir, "old.c"), []byte("int main() { return 0; }\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create source file: %s", err)
+	}
+
+	env := Info{BuildDir: buildDir}
+	p := SoftwarePackage{Name: "myapp", URL: "file://" + srcDir}
+	err = env.Get(&p)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err)
+	}
+	if env.SrcDir != filepath.Join(buildDir, "myapp") || !util.FileExists(filepath.Join(env.SrcDir, "old.c")) {
+		t.Fatalf("source directory not synchronized in %s", env.SrcDir)
+	}
+
+	// The files removed from the source directory are removed from the build directory
+	err = os.Rename(filepath.Join(srcDir, "old.c"), filepath.Join(srcDir, "new.c"))
+	if err != nil {
+		t.Fatalf("failed to rename source file: %s", err)
+	}
+	err = env.Get(&p)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err)
+	}
+	if util.FileExists(filepath.Join(env.SrcDir, "old.c")) || !util.FileExists(filepath.Join(env.SrcDir, "new.c")) {
+		t.Fatalf("source directory not synchronized after a change")
+	}
+}
+
+// createTarball creates a gzipped tarball with the given files
+func createTarball(path string, files []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	for _, name := range files {
+		content := []byte("content of " + name + "\n")
+		err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUnpackDirtyBuildDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-buildenv-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Leftovers of a previous build, including a stale file in the directory of the tarball
+	for _, f := range []string{"old-download.tar.gz", "notes.txt", "myapp-1.0/stale.c"} {
+		path := filepath.Join(dir, f)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", filepath.Dir(path), err)
+		}
+		err = ioutil.WriteFile(path, []byte("leftover\n"), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+	}
+
+	tests := []struct {
+		files       []string
+		expectedDir string
+	}{
+		{files: []string{"myapp-1.0/main.c", "myapp-1.0/Makefile"}, expectedDir: filepath.Join(dir, "myapp-1.0")},
+		{files: []string{"./main.c", "./Makefile"}, expectedDir: dir},
+	}
+
+	for _, tt := range tests {
+		tarball := filepath.Join(dir, "myapp-1.0.tar.gz")
+		err = createTarball(tarball, tt.files)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", tarball, err)
+		}
+
+		env := Info{BuildDir: dir, SrcPath: tarball}
+		err = env.Unpack()
+		if err != nil {
+			t.Fatalf("Unpack() failed with a dirty build directory: %s", err)
+		}
+		if env.SrcDir != tt.expectedDir {
+			t.Fatalf("source directory is %s instead of %s", env.SrcDir, tt.expectedDir)
+		}
+		if !util.FileExists(filepath.Join(env.SrcDir, "main.c")) || util.FileExists(filepath.Join(env.SrcDir, "stale.c")) {
+			t.Fatalf("%s does not have the content of the tarball", env.SrcDir)
+		}
+		if util.FileExists(tarball) {
+			t.Fatalf("%s was not removed", tarball)
+		}
+	}
+}
+
+func TestUnpackUnsafeTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-buildenv-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	buildDir := filepath.Join(dir, "build")
+	victim := filepath.Join(dir, "victim", "data.txt")
+	for _, d := range []string{buildDir, filepath.Dir(victim)} {
+		err = os.MkdirAll(d, 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+	err = ioutil.WriteFile(victim, []byte("data\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", victim, err)
+	}
+
+	tests := [][]string{
+		{"myapp-1.0/main.c", "../victim/data.txt"},
+		{"myapp-1.0/../../victim/data.txt"},
+		{filepath.Join(dir, "victim", "data.txt")},
+	}
+	for _, files := range tests {
+		tarball := filepath.Join(buildDir, "myapp-1.0.tar.gz")
+		err = createTarball(tarball, files)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", tarball, err)
+		}
+		env := Info{BuildDir: buildDir, SrcPath: tarball}
+		err = env.Unpack()
+		if err == nil {
+			t.Fatalf("Unpack() succeeded with a tarball including %v", files)
+		}
+		content, err := ioutil.ReadFile(victim)
+		if err != nil || string(content) != "data\n" {
+			t.Fatalf("%s was modified while unpacking a tarball including %v", victim, files)
+		}
+	}
+}
+
+func TestRunMake(t *testing.T) {
+	fake := syexec.NewFakeRunner()
+	fake.On("make install", syexec.FakeResult{Stderr: "cannot create /opt/mpi\n", ExitCode: 2})
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	env := Info{SrcDir: "/tmp/openmpi-4.0.2", Env: []string{"PATH=/opt/mpi/bin"}}
+	err := env.RunMake(false, []string{"-C", "builddir"}, "")
+	if err != nil {
+		t.Fatalf("RunMake() failed: %s", err)
+	}
+	err = env.RunMake(false, nil, "install")
+	if err == nil || !strings.Contains(err.Error(), "cannot create /opt/mpi") {
+		t.Fatalf("RunMake() did not report the failure of the installation: %v", err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 2 || calls[0].CmdLine() != "make -j4 -C builddir" || calls[1].CmdLine() != "make -j4 install" {
+		t.Fatalf("invalid commands: %v", fake.CmdLines())
+	}
+	if calls[0].Dir != env.SrcDir || strings.Join(calls[0].Env, " ") != "PATH=/opt/mpi/bin" {
+		t.Fatalf("invalid execution of make: %+v", calls[0])
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// progressInterval is the interval between two progress messages when the download
+	// is not displayed in a terminal
+	progressInterval = 30 * time.Second
+
+	// headTimeout is the maximum time to get the headers of a file to download
+	headTimeout = 30 * time.Second
+
+	// defaultDownloadFilename is the name of a downloaded file when it cannot be figured out from its URL
+	defaultDownloadFilename = "download"
+)
+
+var rateLimitRegex = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmM]?$`)
+
+// ValidateRateLimit checks that a bandwidth limit is valid, i.e., a number of bytes per second
+// with an optional k (kilobytes) or m (megabytes) suffix, e.g., 500k or 10m
+func ValidateRateLimit(limit string) error {
+	if !rateLimitRegex.MatchString(limit) {
+		return fmt.Errorf("invalid bandwidth limit %s, it should be a number of bytes per second with an optional k or m suffix, e.g., 10m", limit)
+	}
+	return nil
+}
+
+// isTerminal checks whether a file is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// getDownloadArgs returns the arguments of wget to download a file. The progress bar is only
+// displayed in a terminal.
+func getDownloadArgs(url string, rateLimit string, tty bool) []string {
+	args := []string{"--no-verbose"}
+	if tty {
+		args = []string{"--progress=bar:force:noscroll"}
+	}
+	if rateLimit != "" {
+		args = append(args, "--limit-rate="+rateLimit)
+	}
+	return append(args, url)
+}
+
+// formatSize returns a human readable size
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+// getDirSize returns the size of the files in a directory
+func getDirSize(dir string) int64 {
+	var size int64
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	for _, f := range files {
+		size += f.Size()
+	}
+	return size
+}
+
+// reportProgress periodically logs the amount of data downloaded in a directory until done is closed
+func reportProgress(name string, dir string, interval time.Duration, done chan struct{}) {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			size := getDirSize(dir)
+			rate := float64(size) / time.Since(start).Seconds()
+			log.Printf("-> Downloading %s: %s downloaded (%s/s)\n", name, formatSize(size), formatSize(int64(rate)))
+		}
+	}
+}
+
+// getFilenameFromHeader returns the name of a file from the Content-Disposition header of a HTTP
+// response, e.g., attachment; filename="openmpi-4.0.2.tar.bz2"
+func getFilenameFromHeader(header string) string {
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	name := filepath.Base(params["filename"])
+	if name == "." || name == string(filepath.Separator) {
+		return ""
+	}
+	return name
+}
+
+// getFilenameFromURL returns the name of a file from its URL, ignoring the query
+func getFilenameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return defaultDownloadFilename
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return defaultDownloadFilename
+	}
+	return name
+}
+
+// getDownloadFilename figures out the name of the file created when downloading a URL: the name
+// from the HTTP headers if any, otherwise the name from the final URL, after redirections
+func getDownloadFilename(rawURL string) string {
+	client := sys.NewHTTPClient(headTimeout)
+	resp, err := client.Head(rawURL)
+	if err != nil {
+		log.Printf("[WARN] failed to get the headers of %s: %s", rawURL, err)
+		return getFilenameFromURL(rawURL)
+	}
+	resp.Body.Close()
+
+	name := getFilenameFromHeader(resp.Header.Get("Content-Disposition"))
+	if name != "" {
+		return name
+	}
+	return getFilenameFromURL(resp.Request.URL.String())
+}
+
+func (env *Info) download(p *SoftwarePackage) error {
+	// Sanity checks
+	if p.URL == "" || env.BuildDir == "" {
+		return fmt.Errorf("invalid parameter(s)")
+	}
+
+	log.Printf("- Downloading %s from %s...", p.Name, p.URL)
+
+	// todo: do not assume wget
+	binPath, err := exec.LookPath("wget")
+	if err != nil {
+		return fmt.Errorf("cannot find wget: %s", err)
+	}
+
+	// The name of the file is set explicitly so the downloaded file is known even when the build
+	// directory is not empty, e.g., after a failed build that was kept for debugging
+	filename := getDownloadFilename(p.URL)
+	target := filepath.Join(env.BuildDir, filename)
+	if util.PathExists(target) {
+		log.Printf("-> Removing %s from a previous download\n", target)
+		err = os.RemoveAll(target)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %s", target, err)
+		}
+	}
+
+	tty := isTerminal(os.Stderr)
+	args := []string{"-O", filename}
+	if env.CAFile != "" {
+		// wget trusts the CAs of the bundle in addition to the ones of the system
+		args = append(args, "--ca-certificate="+env.CAFile)
+	}
+	args = append(args, getDownloadArgs(p.URL, env.DownloadRateLimit, tty)...)
+	log.Printf("* Executing from %s: %s %s", env.BuildDir, binPath, args)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binPath, args...)
+	cmd.Dir = env.BuildDir
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	if tty {
+		// wget displays the progress bar on stderr
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	} else {
+		done := make(chan struct{})
+		defer close(done)
+		go reportProgress(p.Name, env.BuildDir, progressInterval, done)
+	}
+	err = syexec.RunCmd(cmd)
+	if err != nil {
+		// Do not leave a partial file behind
+		os.Remove(target)
+		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("downloaded file %s not found: %s", target, err)
+	}
+	log.Printf("-> %s downloaded (%s)\n", p.Name, formatSize(info.Size()))
+
+	p.tarball = filename
+	env.SrcPath = target
+
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDownloadArgs(t *testing.T) {
+	tests := []struct {
+		limit    string
+		tty      bool
+		valid    bool
+		expected string
+	}{
+		{limit: "", tty: false, valid: false, expected: "--no-verbose http://example.com/a.tar.gz"},
+		{limit: "10m", tty: true, valid: true, expected: "--progress=bar:force:noscroll --limit-rate=10m http://example.com/a.tar.gz"},
+		{limit: "1.5k", tty: false, valid: true, expected: "--no-verbose --limit-rate=1.5k http://example.com/a.tar.gz"},
+		{limit: "2048", tty: false, valid: true, expected: "--no-verbose --limit-rate=2048 http://example.com/a.tar.gz"},
+		{limit: "10mb", valid: false},
+		{limit: "fast", valid: false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateRateLimit(tt.limit)
+		if (err == nil) != tt.valid {
+			t.Fatalf("ValidateRateLimit(%q) returned %v", tt.limit, err)
+		}
+		if tt.expected == "" {
+			continue
+		}
+		args := strings.Join(getDownloadArgs("http://example.com/a.tar.gz", tt.limit, tt.tty), " ")
+		if args != tt.expected {
+			t.Fatalf("invalid arguments with limit %q: %s instead of %s", tt.limit, args, tt.expected)
+		}
+	}
+
+	if formatSize(3*1024*1024) != "3.0 MiB" {
+		t.Fatalf("invalid size: %s", formatSize(3*1024*1024))
+	}
+}
+
+func TestGetDownloadFilename(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/release/openmpi-4.0.2.tar.bz2", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="mpich-3.3.2.tar.gz"`)
+	})
+	mux.HandleFunc("/latest", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/release/openmpi-4.0.2.tar.bz2", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{url: server.URL + "/release/openmpi-4.0.2.tar.bz2?raw=true", expected: "openmpi-4.0.2.tar.bz2"},
+		{url: server.URL + "/download", expected: "mpich-3.3.2.tar.gz"},
+		{url: server.URL + "/latest", expected: "openmpi-4.0.2.tar.bz2"},
+		{url: server.URL + "/", expected: defaultDownloadFilename},
+	}
+
+	for _, tt := range tests {
+		name := getDownloadFilename(tt.url)
+		if name != tt.expected {
+			t.Fatalf("file downloaded from %s is %s instead of %s", tt.url, name, tt.expected)
+		}
+	}
+}
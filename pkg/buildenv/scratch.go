@@ -0,0 +1,241 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/lockfile"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// ScratchGCOff is the policy of the garbage collection of the scratch directories ignoring
+	// the abandoned directories
+	ScratchGCOff = "off"
+
+	// ScratchGCWarn is the policy of the garbage collection of the scratch directories reporting
+	// the abandoned directories when the tool starts, so they can be removed with -gc (default)
+	ScratchGCWarn = "warn"
+
+	// ScratchGCRemove is the policy of the garbage collection of the scratch directories removing
+	// the abandoned directories when the tool starts
+	ScratchGCRemove = "remove"
+
+	// DefaultScratchMaxAge is the time since the last modification of a scratch directory that is
+	// not locked from which it is considered abandoned
+	DefaultScratchMaxAge = 24 * time.Hour
+
+	// scratchLockSuffix is the suffix of the lock file of a scratch directory, which is next to
+	// the directory, e.g., scratch-openmpi.lock
+	scratchLockSuffix = ".lock"
+)
+
+// scratchPrefixes are the prefixes of the names of the scratch directories, e.g., scratch-openmpi
+// for the installations of Open MPI or scratch_singularity-3.5.3 for Singularity
+var scratchPrefixes = []string{"scratch-", "scratch_"}
+
+// ScratchLock is the lock of a scratch directory used by the current process
+type ScratchLock struct {
+	f *os.File
+}
+
+// StaleScratch is a scratch directory abandoned by a process that crashed or was interrupted
+type StaleScratch struct {
+	// Path is the path to the directory
+	Path string
+
+	// Age is the time since the last modification of the directory or its content
+	Age time.Duration
+}
+
+// ValidateScratchGCPolicy checks whether a policy of the garbage collection of the scratch
+// directories is valid
+func ValidateScratchGCPolicy(policy string) error {
+	switch policy {
+	case ScratchGCOff, ScratchGCWarn, ScratchGCRemove:
+		return nil
+	}
+	return fmt.Errorf("invalid scratch garbage collection policy %s, it should be %s, %s or %s", policy, ScratchGCOff, ScratchGCWarn, ScratchGCRemove)
+}
+
+// ValidateScratchMaxAge checks whether the age from which scratch directories are abandoned is a
+// valid duration, e.g., 24h
+func ValidateScratchMaxAge(age string) error {
+	d, err := time.ParseDuration(age)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid age %s, it should be a positive duration, e.g., 24h", age)
+	}
+	return nil
+}
+
+// getScratchLockPath returns the path to the lock file of a scratch directory
+func getScratchLockPath(dir string) string {
+	return filepath.Clean(dir) + scratchLockSuffix
+}
+
+// lockScratchFile opens the lock file of a scratch directory and locks it; the lock is shared by
+// all the processes using the directory and exclusive when the directory is removed
+func lockScratchFile(dir string, how int) (*os.File, error) {
+	path := getScratchLockPath(dir)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+	return lockfile.Lock(path, how)
+}
+
+// LockScratch marks a scratch directory as used by the current process until Unlock is called,
+// so it is never garbage collected, even when it is not modified for a long time
+func LockScratch(dir string) (*ScratchLock, error) {
+	f, err := lockScratchFile(dir, syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	return &ScratchLock{f: f}, nil
+}
+
+// Unlock releases the lock of a scratch directory; the lock file is removed unless other
+// processes use the directory
+func (l *ScratchLock) Unlock() {
+	if l == nil || l.f == nil {
+		return
+	}
+	err := lockfile.Unlock(l.f)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+	}
+	l.f = nil
+}
+
+// IsScratchInUse checks whether a scratch directory is locked by a running process
+func IsScratchInUse(dir string) bool {
+	if _, err := os.Stat(getScratchLockPath(dir)); err != nil {
+		// Directories of the legacy tool or of older versions do not have a lock
+		return false
+	}
+	f, err := lockScratchFile(dir, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		return true
+	}
+	lockfile.Unlock(f)
+	return false
+}
+
+// getLastModification returns the time of the last modification of a directory or of the files
+// and directories it directly contains
+func getLastModification(dir string, info os.FileInfo) time.Time {
+	last := info.ModTime()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return last
+	}
+	for _, e := range entries {
+		if e.ModTime().After(last) {
+			last = e.ModTime()
+		}
+	}
+	return last
+}
+
+// GetScratchRoots returns the directories where scratch directories are created: the workspace,
+// the directory of the user in the fast scratch root, if any, and, for the legacy tool, the
+// directory of the binary
+func GetScratchRoots(sysCfg *sys.Config) []string {
+	roots := []string{sys.GetSympiDir()}
+	if sysCfg.ScratchRoot != "" {
+		roots = append(roots, getUserScratchRoot(sysCfg))
+	}
+	if sysCfg.BinPath != "" && filepath.Clean(sysCfg.BinPath) != filepath.Clean(roots[0]) {
+		roots = append(roots, sysCfg.BinPath)
+	}
+	return roots
+}
+
+// FindStaleScratch returns the scratch directories of a list of directories, e.g., the workspace,
+// that are abandoned: they are not locked by a running process and were not modified for maxAge
+func FindStaleScratch(roots []string, maxAge time.Duration, now time.Time) []StaleScratch {
+	var stale []StaleScratch
+	for _, root := range roots {
+		entries, err := ioutil.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() || !hasScratchPrefix(e.Name()) {
+				continue
+			}
+			path := filepath.Join(root, e.Name())
+			age := now.Sub(getLastModification(path, e))
+			if age < maxAge || IsScratchInUse(path) {
+				continue
+			}
+			stale = append(stale, StaleScratch{Path: path, Age: age})
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Path < stale[j].Path })
+	return stale
+}
+
+// hasScratchPrefix checks whether the name of a directory is the name of a scratch directory
+func hasScratchPrefix(name string) bool {
+	for _, p := range scratchPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveStaleScratch removes an abandoned scratch directory and its lock file; the directory is
+// locked while being removed so a process starting to use it in the meantime is not affected
+func RemoveStaleScratch(s StaleScratch) error {
+	f, err := lockScratchFile(s.Path, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		return fmt.Errorf("%s is in use: %s", s.Path, err)
+	}
+	err = os.RemoveAll(s.Path)
+	if err != nil {
+		lockfile.Unlock(f)
+		return fmt.Errorf("failed to remove %s: %s", s.Path, err)
+	}
+	return lockfile.Unlock(f)
+}
+
+// CollectScratch applies a policy of garbage collection, e.g., ScratchGCWarn, to the abandoned
+// scratch directories of a list of directories and returns the directories that were removed
+func CollectScratch(roots []string, policy string, maxAge time.Duration) []string {
+	if policy == ScratchGCOff {
+		return nil
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultScratchMaxAge
+	}
+
+	var removed []string
+	for _, s := range FindStaleScratch(roots, maxAge, time.Now()) {
+		if policy != ScratchGCRemove {
+			log.Printf("[WARN] %s was abandoned %s ago, remove it with 'sympi -gc'\n", s.Path, s.Age.Round(time.Minute))
+			continue
+		}
+		err := RemoveStaleScratch(s)
+		if err != nil {
+			log.Printf("[WARN] failed to remove the abandoned scratch directory %s: %s\n", s.Path, err)
+			continue
+		}
+		log.Printf("* Abandoned scratch directory %s removed\n", s.Path)
+		removed = append(removed, s.Path)
+	}
+	return removed
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+func TestFindStaleScratch(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	tests := []struct {
+		name  string
+		old   bool
+		lock  bool
+		stale bool
+	}{
+		{name: "scratch-openmpi", old: true, stale: true},
+		{name: "scratch_singularity-3.5.3", old: true, stale: true},
+		{name: "scratch-mpich", old: true, lock: true, stale: false},
+		{name: "scratch-intel", old: false, stale: false},
+		{name: "mpi_install_openmpi-4.0.2", old: true, stale: false},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(root, tt.name)
+		err := os.MkdirAll(filepath.Join(path, "build"), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+		if tt.old {
+			os.Chtimes(filepath.Join(path, "build"), old, old)
+			os.Chtimes(path, old, old)
+		}
+		if tt.lock {
+			lock, err := LockScratch(path)
+			if err != nil {
+				t.Fatalf("failed to lock %s: %s", path, err)
+			}
+			defer lock.Unlock()
+		}
+	}
+
+	stale := FindStaleScratch([]string{root}, DefaultScratchMaxAge, now)
+	found := make(map[string]bool)
+	for _, s := range stale {
+		found[filepath.Base(s.Path)] = true
+		if s.Age < DefaultScratchMaxAge {
+			t.Fatalf("%s is not abandoned, its age is %s", s.Path, s.Age)
+		}
+	}
+	for _, tt := range tests {
+		if found[tt.name] != tt.stale {
+			t.Fatalf("%s was reported as abandoned: %v, expected: %v", tt.name, found[tt.name], tt.stale)
+		}
+	}
+
+	for _, s := range stale {
+		err := RemoveStaleScratch(s)
+		if err != nil {
+			t.Fatalf("failed to remove %s: %s", s.Path, err)
+		}
+		if util.PathExists(s.Path) || util.PathExists(getScratchLockPath(s.Path)) {
+			t.Fatalf("%s was not removed", s.Path)
+		}
+	}
+	if !util.PathExists(filepath.Join(root, "scratch-mpich")) {
+		t.Fatalf("a scratch directory in use was removed")
+	}
+
+	// The lock file is removed once the directory is not used anymore
+	lock, err := LockScratch(filepath.Join(root, "scratch-intel"))
+	if err != nil {
+		t.Fatalf("failed to lock scratch-intel: %s", err)
+	}
+	lock.Unlock()
+	if util.PathExists(getScratchLockPath(filepath.Join(root, "scratch-intel"))) {
+		t.Fatalf("the lock file of scratch-intel was not removed")
+	}
+
+	if ValidateScratchGCPolicy(ScratchGCRemove) != nil || ValidateScratchGCPolicy("always") == nil {
+		t.Fatalf("invalid validation of the garbage collection policies")
+	}
+	if ValidateScratchMaxAge("12h") != nil || ValidateScratchMaxAge("-1h") == nil || ValidateScratchMaxAge("1 day") == nil {
+		t.Fatalf("invalid validation of the ages")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// DefaultScratchMinFree is the space, in bytes, that must be available in the fast scratch root
+	// for it to be used when scratch_min_free is not specified
+	DefaultScratchMinFree = 4 << 30
+
+	// scratchRootDirPrefix is the prefix of the directory of the user in the fast scratch root,
+	// e.g., /dev/shm/sympi-1000, which is shared by the users of the node
+	scratchRootDirPrefix = "sympi-"
+)
+
+// sizeSuffixes are the multipliers of the suffixes of the sizes, e.g., 20G
+var sizeSuffixes = map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+// ParseSize parses a size in bytes with an optional K, M, G or T suffix, e.g., 20G
+func ParseSize(str string) (int64, error) {
+	digits := strings.TrimSpace(str)
+	multiplier := int64(1)
+	if len(digits) > 0 {
+		if m, ok := sizeSuffixes[strings.ToUpper(digits[len(digits)-1:])]; ok {
+			multiplier = m
+			digits = digits[:len(digits)-1]
+		}
+	}
+	size, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %s, it should be a size in bytes with an optional K, M, G or T suffix, e.g., 20G", str)
+	}
+	return size * multiplier, nil
+}
+
+// ValidateScratchMinFree checks whether the space required in the fast scratch root is valid
+func ValidateScratchMinFree(str string) error {
+	_, err := ParseSize(str)
+	return err
+}
+
+// ValidateScratchRoot checks whether a directory can be used as fast scratch root, e.g., /dev/shm
+// or a node-local SSD: it must be an absolute path to an existing directory
+func ValidateScratchRoot(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("%s is not an absolute path", dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to access %s: %s", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// getFreeSpace returns the space, in bytes, available to the user in the file system of a directory
+func getFreeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(dir, &st)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the free space of %s: %s", dir, err)
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// getUserScratchRoot returns the directory of the user in the fast scratch root
+func getUserScratchRoot(sysCfg *sys.Config) string {
+	return filepath.Join(sysCfg.ScratchRoot, scratchRootDirPrefix+strconv.Itoa(os.Getuid()))
+}
+
+// GetScratchBase returns the directory where the scratch and build directories are created: the
+// directory of the user in the fast scratch root when one is specified and has enough free space
+// (see scratch_min_free), the workspace otherwise
+func GetScratchBase(sysCfg *sys.Config) string {
+	if sysCfg.ScratchRoot == "" {
+		return sys.GetSympiDir()
+	}
+
+	minFree := sysCfg.ScratchMinFree
+	if minFree <= 0 {
+		minFree = DefaultScratchMinFree
+	}
+	free, err := getFreeSpace(sysCfg.ScratchRoot)
+	if err != nil {
+		log.Printf("[WARN] %s, using the workspace as scratch", err)
+		return sys.GetSympiDir()
+	}
+	if free < minFree {
+		log.Printf("[WARN] only %s available in %s (%s required), using the workspace as scratch", formatSize(free), sysCfg.ScratchRoot, formatSize(minFree))
+		return sys.GetSympiDir()
+	}
+
+	dir := getUserScratchRoot(sysCfg)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		log.Printf("[WARN] failed to create %s: %s, using the workspace as scratch", dir, err)
+		return sys.GetSympiDir()
+	}
+	return dir
+}
+
+// IsInScratchRoot checks whether a path is in the fast scratch root
+func IsInScratchRoot(path string, sysCfg *sys.Config) bool {
+	if sysCfg.ScratchRoot == "" {
+		return false
+	}
+	root := filepath.Clean(sysCfg.ScratchRoot)
+	path = filepath.Clean(path)
+	return path == root || strings.HasPrefix(path, root+"/")
+}
+
+// CopyBack moves a file created in the fast scratch root, e.g., an image, to its final location
+// in the persistent workspace; the file is copied when both are on different file systems
+func CopyBack(src string, dst string) error {
+	log.Printf("-> Copying %s back to %s\n", src, dst)
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", src, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", src, err)
+	}
+
+	// The copy is written next to the destination and renamed once complete, so an interrupted
+	// copy never leaves a truncated file at the destination
+	tmp := dst + ".part"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", tmp, err)
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to copy %s to %s: %s", src, tmp, err)
+	}
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename %s to %s: %s", tmp, dst, err)
+	}
+	err = os.Remove(src)
+	if err != nil {
+		log.Printf("[WARN] failed to remove %s: %s", src, err)
+	}
+	return nil
+}
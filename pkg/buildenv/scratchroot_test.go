@@ -0,0 +1,82 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected int64
+		valid    bool
+	}{
+		{str: "1024", expected: 1024, valid: true},
+		{str: "20G", expected: 20 << 30, valid: true},
+		{str: "512m", expected: 512 << 20, valid: true},
+		{str: "G", valid: false},
+		{str: "-1K", valid: false},
+		{str: "ten", valid: false},
+	}
+
+	for _, tt := range tests {
+		size, err := ParseSize(tt.str)
+		if (err == nil) != tt.valid || size != tt.expected {
+			t.Fatalf("ParseSize(%s) returned %d, %v", tt.str, size, err)
+		}
+	}
+}
+
+func TestGetScratchBase(t *testing.T) {
+	root, err := ioutil.TempDir("", "sympi-scratchroot-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	var sysCfg sys.Config
+	if GetScratchBase(&sysCfg) != sys.GetSympiDir() {
+		t.Fatalf("the workspace is not used without scratch root")
+	}
+
+	sysCfg.ScratchRoot = root
+	sysCfg.ScratchMinFree = 1
+	base := GetScratchBase(&sysCfg)
+	if !IsInScratchRoot(base, &sysCfg) || !strings.HasPrefix(filepath.Base(base), scratchRootDirPrefix) {
+		t.Fatalf("%s is not in the scratch root %s", base, root)
+	}
+
+	// Not enough space in the scratch root
+	sysCfg.ScratchMinFree = 1 << 48
+	if GetScratchBase(&sysCfg) != sys.GetSympiDir() {
+		t.Fatalf("the scratch root is used without enough free space")
+	}
+
+	src := filepath.Join(base, "app.sif")
+	dst := filepath.Join(root, "app.sif")
+	err = ioutil.WriteFile(src, []byte("image"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", src, err)
+	}
+	err = CopyBack(src, dst)
+	if err != nil {
+		t.Fatalf("CopyBack() failed: %s", err)
+	}
+	content, err := ioutil.ReadFile(dst)
+	if err != nil || string(content) != "image" {
+		t.Fatalf("%s was not copied back to %s", src, dst)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Fatalf("%s was not removed", src)
+	}
+}
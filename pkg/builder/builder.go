@@ -17,29 +17,27 @@ import (
 	"strings"
 
 	"github.com/gvallee/go_util/pkg/util"
-	"github.com/sylabs/singularity-mpi/internal/pkg/autotools"
 	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
 	"github.com/sylabs/singularity-mpi/internal/pkg/distro"
-	"github.com/sylabs/singularity-mpi/internal/pkg/impi"
-	"github.com/sylabs/singularity-mpi/internal/pkg/mpich"
-	"github.com/sylabs/singularity-mpi/internal/pkg/openmpi"
 	"github.com/sylabs/singularity-mpi/internal/pkg/persistent"
 	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
 	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
+	"github.com/sylabs/singularity-mpi/pkg/toolchain"
 )
 
 const (
 	DefaultUbuntuDistro = "ubuntu:disco"
 )
 
-// GetConfigureExtraArgsFn is the function prootype for getting extra arguments to configure a software
-type GetConfigureExtraArgsFn func(*sys.Config) []string
+// GetConfigureExtraArgsFn is the function prootype for getting extra arguments to configure a specific version of a software
+type GetConfigureExtraArgsFn func(*implem.Info, *sys.Config) []string
 
 // ConfigureFn is the function prototype to configuration a specific software
 type ConfigureFn func(*buildenv.Info, *sys.Config, []string) error
@@ -60,19 +58,15 @@ type Builder struct {
 
 	// GetDeffileTemplateTags is the function to call to get all template tags
 	GetDeffileTemplateTags GetDeffileTemplateTagsFn
+
+	// hooks are the hooks registered for the different steps of the build pipeline
+	hooks map[string]*stepHooks
 }
 
 // GenericConfigure is a generic function to configure a software, basically a wrapper around autotool's configure
 func GenericConfigure(env *buildenv.Info, sysCfg *sys.Config, extraArgs []string) error {
-	var ac autotools.Config
-	ac.Install = env.InstallDir
-	ac.Source = env.SrcDir
-	err := autotools.Configure(&ac)
-	if err != nil {
-		return fmt.Errorf("failed to configure MPI: %s", err)
-	}
-
-	return nil
+	var generic mpiplugin.Base
+	return generic.Configure(env, sysCfg, extraArgs)
 }
 
 func findMakefile(env *buildenv.Info) ([]string, error) {
@@ -103,12 +97,9 @@ func (b *Builder) compile(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Conf
 	makeExtraArgs, err := findMakefile(env)
 	if err != nil {
 		fmt.Println("-> No Makefile, trying to figure out how to compile/install MPI...")
-		if pkg.ID == implem.IMPI {
-			res.Err = impi.SetupInstallScript(env, sysCfg)
-			if res.Err != nil {
-				return res
-			}
-			return impi.RunScript(env, sysCfg, "install")
+		impl := mpiplugin.Get(pkg.ID)
+		if impl.HasInstaller() {
+			return impl.Install(env, sysCfg)
 		}
 		res.Err = fmt.Errorf("failed to figure out how to compile %s", pkg.ID)
 		return res
@@ -118,11 +109,19 @@ func (b *Builder) compile(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Conf
 	return res
 }
 
+func (b *Builder) compileStep(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	res := b.compile(pkg, env, sysCfg)
+	if res.Err != nil {
+		res.Stderr = fmt.Sprintf("failed to compile %s: %s", pkg.ID, res.Err)
+	}
+	return res
+}
+
 func (b *Builder) install(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
 	var res syexec.Result
 
-	if pkg.ID == implem.IMPI {
-		fmt.Println("-> Intel MPI detected, no install step, compile step installed the software...")
+	if mpiplugin.Get(pkg.ID).HasInstaller() {
+		fmt.Printf("-> %s has its own installer, no install step, compile step installed the software...\n", pkg.ID)
 		return res
 	}
 
@@ -141,7 +140,36 @@ func (b *Builder) install(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Conf
 	return res
 }
 
-// InstallHostMPI installs a specific version of MPI on the host
+func (b *Builder) installStep(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	res := b.install(pkg, env, sysCfg)
+	if res.Err != nil {
+		res.Stderr = fmt.Sprintf("failed to install MPI: %s", res.Err)
+	}
+	return res
+}
+
+// getToolchainEnv returns the environment of a build with a toolchain, based on the environment
+// of the build or, when empty, the environment of the host
+func getToolchainEnv(env []string, tc *toolchain.Toolchain) []string {
+	if len(env) == 0 {
+		env = os.Environ()
+	}
+	path := os.Getenv("PATH")
+	ldPath := os.Getenv("LD_LIBRARY_PATH")
+	for _, e := range env {
+		if strings.HasPrefix(e, "PATH=") {
+			path = strings.TrimPrefix(e, "PATH=")
+		}
+		if strings.HasPrefix(e, "LD_LIBRARY_PATH=") {
+			ldPath = strings.TrimPrefix(e, "LD_LIBRARY_PATH=")
+		}
+	}
+	// The last value of a variable is the one used
+	return append(append([]string{}, env...), tc.GetEnv(path, ldPath)...)
+}
+
+// InstallOnHost installs a specific version of a software (e.g., MPI) on the host by running
+// the install pipeline (see GetInstallPipeline)
 func (b *Builder) InstallOnHost(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
 	var res syexec.Result
 
@@ -153,73 +181,84 @@ func (b *Builder) InstallOnHost(pkg *implem.Info, env *buildenv.Info, sysCfg *sy
 
 	log.Printf("Installing %s on host...", pkg.ID)
 	if sysCfg.Persistent != "" && util.PathExists(env.InstallDir) {
-		log.Printf("* %s already exists, skipping installation...\n", env.InstallDir)
-		return res
+		err := CheckInstall(env.InstallDir)
+		if err == nil {
+			log.Printf("* %s already exists, skipping installation...\n", env.InstallDir)
+			return res
+		}
+		// A previous installation failed or was interrupted, it cannot be used
+		log.Printf("[WARN] %s is an incomplete installation (%s), installing again from scratch\n", env.InstallDir, err)
+		_, err = RollbackInstall(env.InstallDir, sysCfg)
+		if err != nil {
+			res.Err = err
+			return res
+		}
 	}
 
-	log.Printf("* %s does not exists, installing from scratch\n", env.InstallDir)
-	var s buildenv.SoftwarePackage
-	s.URL = pkg.URL
-	s.Name = pkg.ID + "-" + pkg.Version
-	res.Err = env.Get(&s)
-	if res.Err != nil {
-		res.Err = fmt.Errorf("failed to download MPI from %s: %s", pkg.URL, res.Err)
+	tc, err := toolchain.Get(sysCfg.Toolchain, sysCfg.ToolchainDir)
+	if err != nil {
+		res.Err = err
 		return res
 	}
 
-	res.Err = env.Unpack()
-	if res.Err != nil {
-		res.Err = fmt.Errorf("failed to unpack %s: %s", pkg.ID, res.Err)
+	log.Printf("* %s does not exists, installing from scratch\n", env.InstallDir)
+	err = SetInstallStatus(env.InstallDir, InstallStatusInProgress)
+	if err != nil {
+		res.Err = err
 		return res
 	}
-
-	// Right now, we assume we do not have to install autotools, which is a bad assumption
-	var extraArgs []string
-	if b.GetConfigureExtraArgs != nil {
-		extraArgs = b.GetConfigureExtraArgs(sysCfg)
-	}
-	res.Err = b.Configure(env, sysCfg, extraArgs)
-	if res.Err != nil {
-		res.Err = fmt.Errorf("failed to configure %s: %s", pkg.ID, res.Err)
-		return res
+	if !tc.IsDefault() {
+		log.Printf("* Building with the %s toolchain from %s\n", tc.Name, tc.Root)
+		env.Env = getToolchainEnv(env.Env, tc)
 	}
-
-	res = b.compile(pkg, env, sysCfg)
+	// In the tool-in-container mode, the software is built in the builder container, where the
+	// toolchain is mounted as well
+	env.Builder = syexec.NewBuilderContainer(sysCfg, env.BuildDir, env.InstallDir, env.ScratchDir, tc.Root)
+	p := b.GetInstallPipeline()
+	res = p.Run(pkg, env, sysCfg)
 	if res.Err != nil {
-		res.Stderr = fmt.Sprintf("failed to compile %s: %s", pkg.ID, res.Err)
+		_, err = RollbackInstall(env.InstallDir, sysCfg)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
+		}
 		return res
 	}
 
-	res = b.install(pkg, env, sysCfg)
-	if res.Err != nil {
-		res.Stderr = fmt.Sprintf("failed to install MPI: %s", res.Err)
-		return res
+	err = SetInstallStatus(env.InstallDir, InstallStatusComplete)
+	if err != nil {
+		// The installation can still be used, it is only checked against its manifest later on
+		log.Printf("[WARN] %s", err)
 	}
-
 	return res
 }
 
-// UninstallHost uninstalls a version of MPI on the host that was previously installed by our tool
+// UninstallHost uninstalls a version of MPI on the host that was previously installed by our tool.
+// In persistent mode, MPI is only uninstalled when a cleanup policy is explicitly set for the
+// installations of MPI on the host, the caller being in charge of applying it.
 func (b *Builder) UninstallHost(mpiCfg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
 	var res syexec.Result
 
-	if sysCfg.Persistent == "" {
-		log.Println("Uninstalling MPI on host...")
-
-		if mpiCfg.ID == implem.IMPI {
-			return impi.RunScript(env, sysCfg, "uninstall")
-		} else {
-			mpiDir := filepath.Join(sys.GetSympiDir(), env.InstallDir)
-			if util.PathExists(mpiDir) {
-				err := os.RemoveAll(mpiDir)
-				if err != nil {
-					res.Err = err
-					return res
-				}
-			}
-		}
-	} else {
+	if sys.IsPersistent(sysCfg) && !sys.HasCleanupPolicy(sysCfg, sys.HostMPIResource) {
 		log.Printf("Persistent installs mode, not uninstalling MPI from host")
+		return res
+	}
+
+	log.Println("Uninstalling MPI on host...")
+	impl := mpiplugin.Get(mpiCfg.ID)
+	if impl.HasInstaller() {
+		return impl.Uninstall(env, sysCfg)
+	}
+
+	mpiDir := env.InstallDir
+	if !filepath.IsAbs(mpiDir) {
+		mpiDir = filepath.Join(sys.GetSympiDir(), env.InstallDir)
+	}
+	if util.PathExists(mpiDir) {
+		err := os.RemoveAll(mpiDir)
+		if err != nil {
+			res.Err = err
+			return res
+		}
 	}
 
 	return res
@@ -234,18 +273,13 @@ func Load(pkg *implem.Info) (Builder, error) {
 		return builder, nil
 	}
 
-	switch pkg.ID {
-	case implem.OMPI:
-		builder.Configure = openmpi.Configure
-		builder.GetConfigureExtraArgs = openmpi.GetExtraConfigureArgs
-		//		builder.GetMpirunExtraArgs = openmpi.GetMpirunExtraArgs // deprecated
-		builder.GetDeffileTemplateTags = openmpi.GetDeffileTemplateTags
-	case implem.MPICH:
-		builder.GetDeffileTemplateTags = mpich.GetDeffileTemplateTags
-	case implem.IMPI:
-		builder.GetDeffileTemplateTags = impi.GetDeffileTemplateTags
-	case implem.SY:
+	if pkg.ID == implem.SY {
 		builder.Configure = sy.Configure
+	} else if mpiplugin.IsRegistered(pkg.ID) {
+		impl := mpiplugin.Get(pkg.ID)
+		builder.Configure = impl.Configure
+		builder.GetConfigureExtraArgs = impl.ConfigureArgs
+		builder.GetDeffileTemplateTags = impl.DeffileTemplateTags
 	}
 
 	return builder, nil
@@ -264,13 +298,11 @@ func (b *Builder) createDefFileFromTemplate(defFileName string, mpiCfg *implem.I
 		return f, fmt.Errorf("failed to copy %s to %s: %s", templateDefFile, container.DefFile, err)
 	}
 
-	// Copy the test file
+	// Write the test file
 	// todo: rely on app info instead of hardcoding
-	testFile := filepath.Join(sysCfg.TemplateDir, "mpitest.c")
-	destTestFile := filepath.Join(env.BuildDir, "mpitest.c")
-	err = util.CopyFile(testFile, destTestFile)
+	_, err = app.WriteTestSource(app.MPITestC, env.BuildDir)
 	if err != nil {
-		return f, fmt.Errorf("failed to copy %s to %s: %s", testFile, destTestFile, err)
+		return f, fmt.Errorf("failed to write the test file in %s: %s", env.BuildDir, err)
 	}
 
 	// Update the definition file for the specific version of MPI we are testing
@@ -300,38 +332,33 @@ func (b *Builder) GenerateDeffile(appInfo *app.Info, mpiCfg *implem.Info, env *b
 
 	distroName, _ := sys.ParseDistroID(sysCfg.TargetDistro)
 
-	// For IMPI, we generate the definition file from a template, for other MPI implementations,
-	// we create a definition file from scratch
-	if mpiCfg.ID == implem.IMPI {
-		defFileName = distroName + "_intel.def"
-		if sysCfg.NetPipe {
-			defFileName = distroName + "_intel_netpipe.def"
-		}
-		if sysCfg.IMB {
-			defFileName = distroName + "_intel_imb.def"
-		}
-		f, err = b.createDefFileFromTemplate(defFileName, mpiCfg, env, container, sysCfg)
-		if err != nil {
-			return fmt.Errorf("failed to create definition file from template: %s", err)
-		}
-	} else {
-		distroID := sys.GetDistroID(sysCfg.TargetDistro)
-		defFileName = distroID + "_" + mpiCfg.ID + "_" + appInfo.Name + ".def"
-		container.DefFile = filepath.Join(env.BuildDir, defFileName)
-		if container.AppExe == "" {
-			container.AppExe = appInfo.BinPath
-		}
+	impl := mpiplugin.Get(mpiCfg.ID)
+	distroID := sys.GetDistroID(sysCfg.TargetDistro)
+	defFileName = distroID + "_" + mpiCfg.ID + "_" + appInfo.Name + ".def"
+	container.DefFile = filepath.Join(env.BuildDir, defFileName)
+	if container.AppExe == "" {
+		container.AppExe = appInfo.BinPath
+	}
 
-		f.DistroID = distro.ParseDescr(sysCfg.TargetDistro)
-		f.InternalEnv = env
-		f.MpiImplm = mpiCfg
-		f.Path = container.DefFile
-		f.Model = container.Model
+	f.DistroID = distro.ParseDescr(sysCfg.TargetDistro)
+	f.InternalEnv = env
+	f.MpiImplm = mpiCfg
+	f.Path = container.DefFile
+	f.Model = container.Model
 
-		err = deffile.CreateHybridDefFile(appInfo, &f, sysCfg)
-		if err != nil {
+	err = impl.CreateHybridDeffile(appInfo, &f, sysCfg)
+	if err != nil {
+		// Some implementations (e.g., IMPI) also ship definition file templates, which are used
+		// when the definition file cannot be generated
+		templateName := impl.DeffileTemplate(distroName, sysCfg)
+		if templateName == "" || !util.FileExists(filepath.Join(sysCfg.TemplateDir, templateName+".tmpl")) {
 			return fmt.Errorf("failed to create definition file: %s", err)
 		}
+		log.Printf("[WARN] failed to generate the definition file, using template %s: %s\n", templateName, err)
+		f, err = b.createDefFileFromTemplate(templateName, mpiCfg, env, container, sysCfg)
+		if err != nil {
+			return fmt.Errorf("failed to create definition file from template: %s", err)
+		}
 	}
 
 	log.Printf("-> Definition file created: %s\n", f.Path)
@@ -373,9 +400,15 @@ func (b *Builder) CompileAppOnHost(appInfo *app.Info, buildEnv *buildenv.Info, s
 	log.Printf("Install the application in %s\n", buildEnv.InstallDir)
 
 	// Download the app
-	err := buildEnv.Get(&s)
+	err := app.PrepareTestSource(appInfo)
 	if err != nil {
-		return fmt.Errorf("unable to get the application from %s: %s", s.URL, err)
+		return fmt.Errorf("failed to write the source of %s: %s", appInfo.Name, err)
+	}
+	buildEnv.DownloadRateLimit = sysCfg.DownloadRateLimit
+	buildEnv.CAFile = sys.GetCABundle(sysCfg)
+	err = buildEnv.Get(&s)
+	if err != nil {
+		return fmt.Errorf("unable to get the application from %s: %w", s.URL, err)
 	}
 
 	// Unpacking the app
@@ -386,6 +419,7 @@ func (b *Builder) CompileAppOnHost(appInfo *app.Info, buildEnv *buildenv.Info, s
 
 	// Install the app
 	log.Println("-> Building the application...")
+	buildEnv.Builder = syexec.NewBuilderContainer(sysCfg, buildEnv.BuildDir, buildEnv.InstallDir)
 	err = buildEnv.Install(&s)
 	if err != nil {
 		return fmt.Errorf("unable to install package: %s", err)
@@ -401,14 +435,9 @@ func (b *Builder) CompileAppOnHost(appInfo *app.Info, buildEnv *buildenv.Info, s
 
 }
 
-// CompileMPIAppOnHost compiles and installs a given application on the host, as well
-// as the required MPI implementation when necessary
-func (b *Builder) CompileMPIAppOnHost(appInfo *app.Info, mpiCfg *mpi.Config, buildEnv *buildenv.Info, sysCfg *sys.Config) error {
-	var s buildenv.SoftwarePackage
-	s.URL = appInfo.Source
-	s.Name = appInfo.Name
-	s.InstallCmd = appInfo.InstallCmd
-
+// installAppMPIOnHost installs on the host, when necessary, the MPI implementation required by an
+// application compiled on the host
+func (b *Builder) installAppMPIOnHost(mpiCfg *mpi.Config, buildEnv *buildenv.Info, sysCfg *sys.Config) error {
 	// Check whether the required MPI is already installed, if not install it
 	var mpi buildenv.SoftwarePackage
 
@@ -436,7 +465,11 @@ func (b *Builder) CompileMPIAppOnHost(appInfo *app.Info, mpiCfg *mpi.Config, bui
 		return fmt.Errorf("failed to install MPI on host: %s", res.Err)
 	}
 
-	// Install the app on the host
+	return nil
+}
+
+// getAppOnHost downloads and unpacks an application on the host
+func getAppOnHost(s *buildenv.SoftwarePackage, appInfo *app.Info, buildEnv *buildenv.Info, sysCfg *sys.Config) error {
 	buildEnv.BuildDir = filepath.Join(sysCfg.ScratchDir, appInfo.Name)
 	buildEnv.InstallDir = filepath.Join(sysCfg.ScratchDir, "install")
 	if !util.PathExists(buildEnv.BuildDir) {
@@ -456,9 +489,15 @@ func (b *Builder) CompileMPIAppOnHost(appInfo *app.Info, mpiCfg *mpi.Config, bui
 	log.Printf("Install the application in %s\n", buildEnv.InstallDir)
 
 	// Download the app
-	err := buildEnv.Get(&s)
+	err := app.PrepareTestSource(appInfo)
+	if err != nil {
+		return fmt.Errorf("failed to write the source of %s: %s", appInfo.Name, err)
+	}
+	buildEnv.DownloadRateLimit = sysCfg.DownloadRateLimit
+	buildEnv.CAFile = sys.GetCABundle(sysCfg)
+	err = buildEnv.Get(s)
 	if err != nil {
-		return fmt.Errorf("unable to get the application from %s: %s", s.URL, err)
+		return fmt.Errorf("unable to get the application from %s: %w", s.URL, err)
 	}
 
 	// Unpacking the app
@@ -467,14 +506,46 @@ func (b *Builder) CompileMPIAppOnHost(appInfo *app.Info, mpiCfg *mpi.Config, bui
 		return fmt.Errorf("unable to unpack the application %s: %s", buildEnv.SrcPath, err)
 	}
 
+	return nil
+}
+
+// CompileMPIAppOnHost compiles and installs a given application on the host, as well
+// as the required MPI implementation when necessary
+func (b *Builder) CompileMPIAppOnHost(appInfo *app.Info, mpiCfg *mpi.Config, buildEnv *buildenv.Info, sysCfg *sys.Config) error {
+	var s buildenv.SoftwarePackage
+	s.URL = appInfo.Source
+	s.Name = appInfo.Name
+	s.InstallCmd = appInfo.InstallCmd
+
+	err := b.installAppMPIOnHost(mpiCfg, buildEnv, sysCfg)
+	if err != nil {
+		return err
+	}
+
+	// Install the app on the host
+	err = getAppOnHost(&s, appInfo, buildEnv, sysCfg)
+	if err != nil {
+		return err
+	}
+
 	// Install the app
 	log.Println("-> Building the application...")
 	mpiPath := mpiCfg.Buildenv.GetEnvPath()
 	mpiLdPath := mpiCfg.Buildenv.GetEnvLDPath()
+	// The wrapper compilers of MPI execute the compilers of the toolchain MPI was built with
+	tc, err := toolchain.Get(sysCfg.Toolchain, sysCfg.ToolchainDir)
+	if err != nil {
+		return err
+	}
+	if !tc.IsDefault() {
+		mpiPath = tc.GetBinPath() + ":" + mpiPath
+		mpiLdPath = tc.GetLibPath() + ":" + mpiLdPath
+	}
 	//buildEnv.Env = append([]string{"LD_LIBRARY_PATH=" + mpiLdPath}, os.Environ()...)
 	buildEnv.Env = []string{"LD_LIBRARY_PATH=" + mpiLdPath}
 	buildEnv.Env = append([]string{"PATH=" + mpiPath}, buildEnv.Env...)
 	log.Printf("* env:\n\t%s", strings.Join(buildEnv.Env, "\n\t"))
+	buildEnv.Builder = syexec.NewBuilderContainer(sysCfg, buildEnv.BuildDir, buildEnv.InstallDir, mpiCfg.Buildenv.InstallDir, tc.Root)
 	err = buildEnv.Install(&s)
 	if err != nil {
 		return fmt.Errorf("unable to install package: %s", err)
@@ -488,3 +559,40 @@ func (b *Builder) CompileMPIAppOnHost(appInfo *app.Info, mpiCfg *mpi.Config, bui
 
 	return nil
 }
+
+// GetMPIBinaryOnHost gets the prebuilt binaries of an application on the host, without compiling
+// anything, and sets the path to its executable. With the bind model, the MPI implementation
+// mounted in the container is also installed on the host when necessary.
+func (b *Builder) GetMPIBinaryOnHost(appInfo *app.Info, mpiCfg *mpi.Config, buildEnv *buildenv.Info, sysCfg *sys.Config) error {
+	var s buildenv.SoftwarePackage
+	s.URL = appInfo.Source
+	s.Name = appInfo.Name
+
+	if mpiCfg.Container.Model == container.BindModel {
+		err := b.installAppMPIOnHost(mpiCfg, buildEnv, sysCfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := getAppOnHost(&s, appInfo, buildEnv, sysCfg)
+	if err != nil {
+		return err
+	}
+
+	// A single binary is used as is, the binaries of a tarball or a local directory are copied
+	// with the entire directory since they may depend on each other, e.g., libraries in a lib directory
+	if !util.IsDir(buildEnv.SrcPath) && util.DetectTarballFormat(buildEnv.SrcPath) == util.UnknownFormat {
+		appInfo.BinPath = buildEnv.SrcPath
+		appInfo.BinDir = ""
+	} else {
+		appInfo.BinDir = buildEnv.SrcDir
+		appInfo.BinPath, err = app.FindBinary(buildEnv.SrcDir, appInfo.BinName)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("-> Using the prebuilt binary %s\n", appInfo.BinPath)
+
+	return nil
+}
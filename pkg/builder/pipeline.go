@@ -0,0 +1,295 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// StepGet is the name of the step getting the source code of the software
+	StepGet = "get"
+
+	// StepUnpack is the name of the step unpacking the source code of the software
+	StepUnpack = "unpack"
+
+	// StepConfigure is the name of the step configuring the software
+	StepConfigure = "configure"
+
+	// StepCompile is the name of the step compiling the software
+	StepCompile = "compile"
+
+	// StepInstall is the name of the step installing the software
+	StepInstall = "install"
+
+	// preHookKeyPrefix and hookKeySuffix are used to build the keys of the tool's configuration
+	// file that specify shell hooks, e.g., 'pre_configure_hook = /path/to/script.sh'
+	preHookKeyPrefix  = "pre_"
+	postHookKeyPrefix = "post_"
+	hookKeySuffix     = "_hook"
+)
+
+// StepFn is the function prototype of a step of the build pipeline
+type StepFn func(*implem.Info, *buildenv.Info, *sys.Config) syexec.Result
+
+// HookFn is the function prototype of a hook executed before or after a step of the build pipeline.
+// The first parameter is the name of the step the hook is associated to.
+type HookFn func(string, *implem.Info, *buildenv.Info, *sys.Config) error
+
+// Step is a named step of the build pipeline
+type Step struct {
+	// Name is the name of the step, e.g., StepConfigure
+	Name string
+
+	// Run is the function implementing the step
+	Run StepFn
+
+	// PreHooks is the list of hooks to execute before the step
+	PreHooks []HookFn
+
+	// PostHooks is the list of hooks to execute after the step
+	PostHooks []HookFn
+}
+
+// Pipeline is an ordered list of steps to build and install a software
+type Pipeline struct {
+	// Steps is the ordered list of steps of the pipeline
+	Steps []Step
+}
+
+type stepHooks struct {
+	pre  []HookFn
+	post []HookFn
+}
+
+// GetDefaultSteps returns the names of the steps used to install a software, in the order
+// they are executed
+func GetDefaultSteps() []string {
+	return []string{StepGet, StepUnpack, StepConfigure, StepCompile, StepInstall}
+}
+
+func isValidStep(name string) bool {
+	for _, s := range GetDefaultSteps() {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHookKeys returns the keys of the tool's configuration file that specify shell hooks, e.g.,
+// pre_configure_hook
+func GetHookKeys() []string {
+	var keys []string
+	for _, step := range GetDefaultSteps() {
+		keys = append(keys, preHookKeyPrefix+step+hookKeySuffix, postHookKeyPrefix+step+hookKeySuffix)
+	}
+	return keys
+}
+
+func (b *Builder) getStepHooks(step string) (*stepHooks, error) {
+	if !isValidStep(step) {
+		return nil, fmt.Errorf("unknown build step: %s", step)
+	}
+
+	if b.hooks == nil {
+		b.hooks = make(map[string]*stepHooks)
+	}
+	if _, ok := b.hooks[step]; !ok {
+		b.hooks[step] = new(stepHooks)
+	}
+
+	return b.hooks[step], nil
+}
+
+// AddPreHook registers a hook that is executed before a given step of the build pipeline
+func (b *Builder) AddPreHook(step string, fn HookFn) error {
+	h, err := b.getStepHooks(step)
+	if err != nil {
+		return err
+	}
+	h.pre = append(h.pre, fn)
+	return nil
+}
+
+// AddPostHook registers a hook that is executed after a given step of the build pipeline
+func (b *Builder) AddPostHook(step string, fn HookFn) error {
+	h, err := b.getStepHooks(step)
+	if err != nil {
+		return err
+	}
+	h.post = append(h.post, fn)
+	return nil
+}
+
+// ShellHook returns a hook executing a shell command, e.g., a script with its arguments, through
+// sh -c so quoted arguments are preserved. The command is executed from the source directory (or
+// the build directory if the source is not available yet) and the following environment variables
+// are set: SYMPI_STEP, SYMPI_PKG_ID, SYMPI_PKG_VERSION, SYMPI_SRC_DIR, SYMPI_BUILD_DIR and
+// SYMPI_INSTALL_DIR.
+func ShellHook(script string) HookFn {
+	return func(step string, pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) error {
+		var cmd syexec.SyCmd
+		cmd.BinPath = "sh"
+		cmd.CmdArgs = []string{"-c", script}
+		cmd.ExecDir = env.SrcDir
+		if cmd.ExecDir == "" {
+			cmd.ExecDir = env.BuildDir
+		}
+		cmd.Container = env.Builder
+		cmd.Env = append(os.Environ(), "SYMPI_STEP="+step,
+			"SYMPI_PKG_ID="+pkg.ID,
+			"SYMPI_PKG_VERSION="+pkg.Version,
+			"SYMPI_SRC_DIR="+env.SrcDir,
+			"SYMPI_BUILD_DIR="+env.BuildDir,
+			"SYMPI_INSTALL_DIR="+env.InstallDir)
+
+		log.Printf("-> Running hook %s for step %s", script, step)
+		res := cmd.Run()
+		if res.Err != nil {
+			return fmt.Errorf("hook %s failed: %s (stdout: %s; stderr: %s)", script, res.Err, res.Stdout, res.Stderr)
+		}
+		return nil
+	}
+}
+
+// getConfigHooks returns the shell hooks specified in the tool's configuration file
+func getConfigHooks() map[string]*stepHooks {
+	hooks := make(map[string]*stepHooks)
+
+	kvs, err := sy.LoadMPIConfigFile()
+	if err != nil {
+		// Not a fatal error, it only means we cannot have hooks from the configuration file
+		log.Printf("[WARN] unable to load shell hooks from the tool's configuration file: %s", err)
+		return hooks
+	}
+
+	for _, step := range GetDefaultSteps() {
+		h := new(stepHooks)
+		script := strings.TrimSpace(kv.GetValue(kvs, preHookKeyPrefix+step+hookKeySuffix))
+		if script != "" {
+			h.pre = append(h.pre, ShellHook(script))
+		}
+		script = strings.TrimSpace(kv.GetValue(kvs, postHookKeyPrefix+step+hookKeySuffix))
+		if script != "" {
+			h.post = append(h.post, ShellHook(script))
+		}
+		hooks[step] = h
+	}
+
+	return hooks
+}
+
+// Run executes all the steps of a pipeline, including their hooks, and stops at the first error
+func (p *Pipeline) Run(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	var res syexec.Result
+
+	for _, s := range p.Steps {
+		for _, hook := range s.PreHooks {
+			res.Err = hook(s.Name, pkg, env, sysCfg)
+			if res.Err != nil {
+				res.Err = fmt.Errorf("pre-%s hook failed: %s", s.Name, res.Err)
+				return res
+			}
+		}
+
+		res = s.Run(pkg, env, sysCfg)
+		if res.Err != nil {
+			return res
+		}
+
+		for _, hook := range s.PostHooks {
+			res.Err = hook(s.Name, pkg, env, sysCfg)
+			if res.Err != nil {
+				res.Err = fmt.Errorf("post-%s hook failed: %s", s.Name, res.Err)
+				return res
+			}
+		}
+	}
+
+	return res
+}
+
+func (b *Builder) get(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	var res syexec.Result
+	var s buildenv.SoftwarePackage
+	s.URL = pkg.URL
+	s.Name = pkg.ID + "-" + pkg.Version
+	env.DownloadRateLimit = sysCfg.DownloadRateLimit
+	env.CAFile = sys.GetCABundle(sysCfg)
+	res.Err = env.Get(&s)
+	if res.Err != nil {
+		res.Err = fmt.Errorf("failed to download MPI from %s: %w", pkg.URL, res.Err)
+	}
+	return res
+}
+
+func (b *Builder) unpack(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	var res syexec.Result
+	res.Err = env.Unpack()
+	if res.Err != nil {
+		res.Err = fmt.Errorf("failed to unpack %s: %s", pkg.ID, res.Err)
+	}
+	return res
+}
+
+func (b *Builder) configure(pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	var res syexec.Result
+
+	// Right now, we assume we do not have to install autotools, which is a bad assumption
+	var extraArgs []string
+	if b.GetConfigureExtraArgs != nil {
+		extraArgs = b.GetConfigureExtraArgs(pkg, sysCfg)
+	}
+	res.Err = b.Configure(env, sysCfg, extraArgs)
+	if res.Err != nil {
+		res.Err = fmt.Errorf("failed to configure %s: %w", pkg.ID, res.Err)
+	}
+	return res
+}
+
+// GetInstallPipeline returns the pipeline used to install a software on the host, with all the
+// hooks registered through the Go API and the ones specified in the tool's configuration file
+func (b *Builder) GetInstallPipeline() Pipeline {
+	var p Pipeline
+
+	stepFns := map[string]StepFn{
+		StepGet:       b.get,
+		StepUnpack:    b.unpack,
+		StepConfigure: b.configure,
+		StepCompile:   b.compileStep,
+		StepInstall:   b.installStep,
+	}
+
+	configHooks := getConfigHooks()
+	for _, name := range GetDefaultSteps() {
+		s := Step{
+			Name: name,
+			Run:  stepFns[name],
+		}
+		if h, ok := b.hooks[name]; ok {
+			s.PreHooks = append(s.PreHooks, h.pre...)
+			s.PostHooks = append(s.PostHooks, h.post...)
+		}
+		if h, ok := configHooks[name]; ok {
+			s.PreHooks = append(s.PreHooks, h.pre...)
+			s.PostHooks = append(s.PostHooks, h.post...)
+		}
+		p.Steps = append(p.Steps, s)
+	}
+
+	return p
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestPipelineRun(t *testing.T) {
+	var trace []string
+
+	step := func(name string, fail bool) StepFn {
+		return func(*implem.Info, *buildenv.Info, *sys.Config) syexec.Result {
+			var res syexec.Result
+			trace = append(trace, name)
+			if fail {
+				res.Err = fmt.Errorf("%s failed", name)
+			}
+			return res
+		}
+	}
+	hook := func(name string) HookFn {
+		return func(step string, pkg *implem.Info, env *buildenv.Info, sysCfg *sys.Config) error {
+			trace = append(trace, name+":"+step)
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name          string
+		failingStep   string
+		expectedTrace string
+	}{
+		{
+			name:          "all steps succeed",
+			expectedTrace: "pre:get get post:get pre:configure configure post:configure",
+		},
+		{
+			name:          "configure fails",
+			failingStep:   StepConfigure,
+			expectedTrace: "pre:get get post:get pre:configure configure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace = nil
+			var p Pipeline
+			for _, name := range []string{StepGet, StepConfigure} {
+				p.Steps = append(p.Steps, Step{
+					Name:      name,
+					Run:       step(name, name == tt.failingStep),
+					PreHooks:  []HookFn{hook("pre")},
+					PostHooks: []HookFn{hook("post")},
+				})
+			}
+
+			var pkg implem.Info
+			var env buildenv.Info
+			var sysCfg sys.Config
+			res := p.Run(&pkg, &env, &sysCfg)
+			if tt.failingStep == "" && res.Err != nil {
+				t.Fatalf("pipeline failed: %s", res.Err)
+			}
+			if tt.failingStep != "" && res.Err == nil {
+				t.Fatalf("pipeline succeeded while %s was expected to fail", tt.failingStep)
+			}
+			if strings.Join(trace, " ") != tt.expectedTrace {
+				t.Fatalf("execution trace is '%s' instead of '%s'", strings.Join(trace, " "), tt.expectedTrace)
+			}
+		})
+	}
+}
+
+func TestAddHook(t *testing.T) {
+	var b Builder
+	noop := func(string, *implem.Info, *buildenv.Info, *sys.Config) error { return nil }
+
+	err := b.AddPreHook(StepConfigure, noop)
+	if err != nil {
+		t.Fatalf("failed to add hook: %s", err)
+	}
+	err = b.AddPostHook("dummy", noop)
+	if err == nil {
+		t.Fatalf("adding a hook to an unknown step succeeded")
+	}
+}
+
+func TestShellHook(t *testing.T) {
+	fake := syexec.NewFakeRunner()
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	script := `/path/to/patch_sources.sh "my patch.diff"`
+	hook := ShellHook(script)
+	err := hook(StepConfigure, &implem.Info{ID: implem.OMPI, Version: "4.0.2"}, &buildenv.Info{BuildDir: "/tmp/build"}, &sys.Config{})
+	if err != nil {
+		t.Fatalf("hook failed: %s", err)
+	}
+	calls := fake.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("%d commands executed instead of 1", len(calls))
+	}
+	if filepath.Base(calls[0].Binary) != "sh" || len(calls[0].Args) != 2 || calls[0].Args[0] != "-c" || calls[0].Args[1] != script {
+		t.Fatalf("hook executed as %s %q instead of sh -c %q", calls[0].Binary, calls[0].Args, script)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// InstallStatusInProgress is the status of an installation that started and did not complete,
+	// e.g., because the build failed or was interrupted
+	InstallStatusInProgress = "in-progress"
+
+	// InstallStatusComplete is the status of an installation that successfully completed
+	InstallStatusComplete = "complete"
+
+	// installStatusFilename is the name of the file of the installation directory with the status
+	// of the installation
+	installStatusFilename = ".sympi_install_status"
+
+	// QuarantineDirName is the name of the directory, next to the installation directories, where
+	// incomplete installations are moved when they are kept for debugging
+	QuarantineDirName = "quarantine"
+)
+
+// installManifests are the manifests of an installation with the hashes of the installed files;
+// the manifests of the commands executed during the installation, e.g., configure.MANIFEST,
+// describe the commands and hash files of the source directory, which is removed afterwards
+var installManifests = []string{"mpi.MANIFEST", "singularity.MANIFEST"}
+
+// SetInstallStatus records the status of the installation of a software in its installation directory
+func SetInstallStatus(installDir string, status string) error {
+	err := os.MkdirAll(installDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", installDir, err)
+	}
+	path := filepath.Join(installDir, installStatusFilename)
+	err = ioutil.WriteFile(path, []byte(status+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// GetInstallStatus returns the status of the installation of a software; it is empty for
+// installations performed before the status was recorded
+func GetInstallStatus(installDir string) string {
+	content, err := ioutil.ReadFile(filepath.Join(installDir, installStatusFilename))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// CheckInstall checks that the installation of a software is complete: its status must be
+// complete, or undefined for old installations, and the files recorded in its manifests of
+// installed files must be unmodified. Old installations without manifest are considered incomplete.
+func CheckInstall(installDir string) error {
+	status := GetInstallStatus(installDir)
+	if status != "" && status != InstallStatusComplete {
+		return fmt.Errorf("installation status is %s", status)
+	}
+
+	var manifests []string
+	for _, name := range installManifests {
+		m := filepath.Join(installDir, name)
+		if util.FileExists(m) {
+			manifests = append(manifests, m)
+		}
+	}
+	if status == "" && len(manifests) == 0 {
+		return fmt.Errorf("neither installation status nor manifest")
+	}
+	for _, m := range manifests {
+		err := manifest.Check(m)
+		if err != nil {
+			return fmt.Errorf("%s does not match the installation: %s", filepath.Base(m), err)
+		}
+	}
+	return nil
+}
+
+// RollbackInstall removes an incomplete installation. When the scratch directories are kept for
+// debugging, or the cleanup policy of host installations keeps them on failure, the installation
+// is moved to a quarantine directory, whose path is returned, instead.
+func RollbackInstall(installDir string, sysCfg *sys.Config) (string, error) {
+	if !util.PathExists(installDir) {
+		return "", nil
+	}
+
+	keep := sys.HasCleanupPolicy(sysCfg, sys.HostMPIResource) && !sys.ShouldCleanup(sysCfg, sys.HostMPIResource, true)
+	if sysCfg.KeepScratch || keep {
+		quarantineDir := filepath.Join(filepath.Dir(installDir), QuarantineDirName, filepath.Base(installDir)+"-"+time.Now().Format("20060102-150405"))
+		err := os.MkdirAll(filepath.Dir(quarantineDir), 0755)
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s: %s", filepath.Dir(quarantineDir), err)
+		}
+		err = os.Rename(installDir, quarantineDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to move %s to %s: %s", installDir, quarantineDir, err)
+		}
+		log.Printf("* Incomplete installation %s moved to %s\n", installDir, quarantineDir)
+		return quarantineDir, nil
+	}
+
+	err := os.RemoveAll(installDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to remove %s: %s", installDir, err)
+	}
+	log.Printf("* Incomplete installation %s removed\n", installDir)
+	return "", nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestCheckInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-status-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		status   string
+		manifest bool
+		modified bool
+		complete bool
+	}{
+		{name: "complete", status: InstallStatusComplete, manifest: true, complete: true},
+		{name: "complete-no-manifest", status: InstallStatusComplete, complete: true},
+		{name: "in-progress", status: InstallStatusInProgress, manifest: true, complete: false},
+		{name: "legacy", manifest: true, complete: true},
+		{name: "legacy-no-manifest", complete: false},
+		{name: "modified", status: InstallStatusComplete, manifest: true, modified: true, complete: false},
+	}
+
+	for _, tt := range tests {
+		installDir := filepath.Join(dir, tt.name)
+		bin := filepath.Join(installDir, "bin", "mpiexec")
+		err := os.MkdirAll(filepath.Dir(bin), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", filepath.Dir(bin), err)
+		}
+		err = ioutil.WriteFile(bin, []byte("mpiexec"), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", bin, err)
+		}
+		if tt.status != "" {
+			err = SetInstallStatus(installDir, tt.status)
+			if err != nil {
+				t.Fatalf("SetInstallStatus() failed: %s", err)
+			}
+		}
+		if tt.manifest {
+			err = manifest.Create(filepath.Join(installDir, "mpi.MANIFEST"), manifest.HashFiles([]string{bin}))
+			if err != nil {
+				t.Fatalf("failed to create manifest: %s", err)
+			}
+		}
+		// Manifest of configure, as created by syexec, whose source directory was removed
+		srcDir := filepath.Join(dir, "src-"+tt.name)
+		configure := []string{"Command: ./configure --prefix=" + installDir + "\n", "Execution path: " + srcDir, "Execution time: 2019-12-03 10:00:00"}
+		configure = append(configure, manifest.HashFiles([]string{filepath.Join(srcDir, "configure")})...)
+		err = manifest.Create(filepath.Join(installDir, "configure.MANIFEST"), configure)
+		if err != nil {
+			t.Fatalf("failed to create manifest: %s", err)
+		}
+		if tt.modified {
+			err = ioutil.WriteFile(bin, []byte("truncated"), 0755)
+			if err != nil {
+				t.Fatalf("failed to modify %s: %s", bin, err)
+			}
+		}
+
+		err = CheckInstall(installDir)
+		if tt.complete && err != nil {
+			t.Fatalf("%s: installation is incomplete: %s", tt.name, err)
+		}
+		if !tt.complete && err == nil {
+			t.Fatalf("%s: installation is complete", tt.name)
+		}
+	}
+
+	// Incomplete installations are moved to the quarantine directory when kept for debugging,
+	// removed otherwise
+	installDir := filepath.Join(dir, "in-progress")
+	quarantineDir, err := RollbackInstall(installDir, &sys.Config{KeepScratch: true})
+	if err != nil {
+		t.Fatalf("RollbackInstall() failed: %s", err)
+	}
+	if util.PathExists(installDir) || filepath.Dir(quarantineDir) != filepath.Join(dir, QuarantineDirName) || !util.PathExists(quarantineDir) {
+		t.Fatalf("%s was not moved to the quarantine directory (%s)", installDir, quarantineDir)
+	}
+	installDir = filepath.Join(dir, "modified")
+	_, err = RollbackInstall(installDir, &sys.Config{})
+	if err != nil {
+		t.Fatalf("RollbackInstall() failed: %s", err)
+	}
+	if util.PathExists(installDir) {
+		t.Fatalf("%s was not removed", installDir)
+	}
+}
@@ -6,7 +6,6 @@
 package checker
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"io/ioutil"
@@ -14,46 +13,21 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
 const (
-	cmdTimeout     = 10
-	prereqBinaries = "wget gfortran gcc g++ make file mksquashfs bzip2 newuidmap tar"
+	cmdTimeout = 10
 )
 
-// CheckDefFile does some checking on a definition file to ensure it can be used
-func CheckDefFile(path string) error {
-	log.Printf("* Checking definition file %s...", path)
-	f, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("failed to open %s: %s", path, err)
-	}
-	defer f.Close()
-
-	// For now we just check the first line that should start with "Bootstrap:"
-	scanner := bufio.NewScanner(f)
-	tok := scanner.Scan()
-	if tok {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "Bootstrap:") {
-			return fmt.Errorf("%s is an invalid definition file", path)
-		}
-	} else {
-		return fmt.Errorf("* Error while scanning the definition file: %s", scanner.Err())
-	}
-
-	log.Println("... successfully checked the definition file.")
-	return nil
-}
-
-// checkSingularityInstall makes sure that Singularity is correctly installed and works properly
+// checkSingularityInstall makes sure that Singularity, or Apptainer, is correctly installed and works properly
 func checkSingularityInstall() error {
 
-	binPath, err := exec.LookPath("singularity")
+	binPath, err := sys.FindContainerRuntime("")
 	if err != nil {
 		log.Printf("* Checking for Singularity\tfail")
 		return sympierr.ErrSingularityNotInstalled
@@ -71,7 +45,7 @@ func checkSingularityInstall() error {
 	defer cancel()
 	singularityCmd := exec.CommandContext(ctx, binPath, "build", "alpine.sif", "library://sylabsed/examples/alpine")
 	singularityCmd.Dir = dir
-	err = singularityCmd.Run()
+	err = syexec.RunCmd(singularityCmd)
 	if err != nil {
 		log.Printf("* Checking for Singularity\tfail")
 		return fmt.Errorf("failed to build test image: %s", err)
@@ -82,33 +56,13 @@ func checkSingularityInstall() error {
 	return nil
 }
 
-func checkPrereqBinaries() error {
-	binaries := strings.Split(prereqBinaries, " ")
-
-	for _, b := range binaries {
-		_, err := exec.LookPath(b)
-		if err != nil {
-			log.Printf("* Checking for %s\tfail", b)
-			return fmt.Errorf("%s not found: %s", b, err)
-		}
-		log.Printf("* Checking for %s\tpass", b)
-	}
-	return nil
-}
-
-// CheckSystemConfig checks the system configuration to ensure that the tool can run correctly
+// CheckSystemConfig checks the system configuration to ensure that the tool can run correctly.
+// sympierr.ErrSingularityNotInstalled is returned when the system is correctly setup but Singularity
+// is not installed. Use RunSystemChecks to get the result of each individual check.
 func CheckSystemConfig() error {
-	err := checkSingularityInstall()
-	if err != nil && err != sympierr.ErrSingularityNotInstalled {
-		return err
-	}
-
-	prereqErr := checkPrereqBinaries()
-	if prereqErr != nil {
-		return prereqErr
-	}
-
-	return err
+	report := RunSystemChecks()
+	log.Printf("* System configuration:\n%s", report.String())
+	return report.Err()
 }
 
 // CheckBuildPrivilege checks if we can build an image for a definition file on the system
@@ -134,14 +88,19 @@ func CheckBuildPrivilege() error {
 		return fmt.Errorf("Impossible to create definition file: %s", err)
 	}
 
+	runtimeBin, err := sys.FindContainerRuntime("")
+	if err != nil {
+		return err
+	}
+
 	// Try to run the Singularity command
 	testImg := filepath.Join(dir, "test.sif")
-	log.Printf("* Trying to create image with: sudo singularity build %s %s\n", testImg, dummyDefFile)
+	log.Printf("* Trying to create image with: sudo %s build %s %s\n", runtimeBin, testImg, dummyDefFile)
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute) // We try only for one minute
 	defer cancel()
-	singularityCmd := exec.CommandContext(ctx, binPath, "singularity", "build", testImg, dummyDefFile)
+	singularityCmd := exec.CommandContext(ctx, binPath, runtimeBin, "build", testImg, dummyDefFile)
 	singularityCmd.Dir = dir
-	err = singularityCmd.Run()
+	err = syexec.RunCmd(singularityCmd)
 	if err != nil {
 		return fmt.Errorf("failed to build test image: %s", err)
 	}
@@ -0,0 +1,246 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package checker
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// DefFileError is a problem found in a definition file
+type DefFileError struct {
+	// Line is the number of the line where the problem is, 0 when it is not specific to a line
+	Line int
+
+	// Msg is the description of the problem
+	Msg string
+}
+
+// bootstrapAgents is the list of the bootstrap agents supported by Singularity; the value specifies
+// whether the agent requires the From keyword
+var bootstrapAgents = map[string]bool{
+	"library":        true,
+	"docker":         true,
+	"docker-daemon":  true,
+	"docker-archive": true,
+	"shub":           true,
+	"oras":           true,
+	"localimage":     true,
+	"debootstrap":    false,
+	"yum":            false,
+	"zypper":         false,
+	"busybox":        false,
+	"arch":           false,
+	"scratch":        false,
+}
+
+// defFileSections is the list of the sections of definition files; the value specifies whether the
+// section requires the name of an application
+var defFileSections = map[string]bool{
+	"setup":       false,
+	"files":       false,
+	"environment": false,
+	"post":        false,
+	"runscript":   false,
+	"startscript": false,
+	"test":        false,
+	"labels":      false,
+	"help":        false,
+	"appfiles":    true,
+	"appinstall":  true,
+	"appenv":      true,
+	"apprun":      true,
+	"applabels":   true,
+	"apphelp":     true,
+	"apptest":     true,
+}
+
+var (
+	templateTagsLock sync.RWMutex
+	templateTags     = make(map[string]bool)
+)
+
+// RegisterTemplateTags adds tags used in definition file templates to the list of tags that must
+// be replaced before building a container
+func RegisterTemplateTags(tags ...string) {
+	templateTagsLock.Lock()
+	defer templateTagsLock.Unlock()
+	for _, t := range tags {
+		if t != "" {
+			templateTags[t] = true
+		}
+	}
+}
+
+func getTemplateTags() []string {
+	templateTagsLock.RLock()
+	defer templateTagsLock.RUnlock()
+	var tags []string
+	for t := range templateTags {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// checkFileReference checks that the source of an entry of a files section exists on the host
+func checkFileReference(line string, buildDir string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	src := fields[0]
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(buildDir, src)
+	}
+	if !util.PathExists(src) {
+		return fmt.Sprintf("file %s does not exist", src)
+	}
+	return ""
+}
+
+// LintDefFile checks a definition file: header with a known bootstrap agent, known sections,
+// template tags that were not replaced and files to copy that do not exist on the host (relative
+// paths being relative to buildDir, where the container is built). All the sections are optional,
+// e.g., an image only adding files or labels to its base image has no post section.
+func LintDefFile(path string, buildDir string) ([]DefFileError, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	var errs []DefFileError
+	addErr := func(line int, format string, a ...interface{}) {
+		errs = append(errs, DefFileError{Line: line, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	tags := getTemplateTags()
+	bootstrap := ""
+	bootstrapLine := 0
+	hasFrom := false
+	section := ""
+	checkFiles := false
+	header := true
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		for _, t := range tags {
+			if strings.Contains(line, t) {
+				addErr(lineNum, "template tag %s was not replaced", t)
+			}
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Like Singularity, only lines starting with % are section headers
+		if strings.HasPrefix(line, "%") {
+			header = false
+			fields := strings.Fields(trimmed[1:])
+			if len(fields) == 0 {
+				addErr(lineNum, "empty section name")
+				continue
+			}
+			section = fields[0]
+			needsApp, ok := defFileSections[section]
+			if !ok {
+				addErr(lineNum, "unknown section %%%s", section)
+				continue
+			}
+			if needsApp && len(fields) < 2 {
+				addErr(lineNum, "section %%%s requires the name of an application", section)
+			}
+			// Files copied from another stage of a multi-stage build are not on the host
+			checkFiles = (section == "files" || section == "appfiles") && !strings.Contains(trimmed, " from ")
+			continue
+		}
+
+		if header {
+			tokens := strings.SplitN(trimmed, ":", 2)
+			if len(tokens) != 2 {
+				addErr(lineNum, "invalid header entry %q, expected 'Keyword: value'", trimmed)
+				continue
+			}
+			keyword := strings.TrimSpace(tokens[0])
+			value := strings.TrimSpace(tokens[1])
+			switch strings.ToLower(keyword) {
+			case "bootstrap":
+				bootstrap = value
+				bootstrapLine = lineNum
+				if _, ok := bootstrapAgents[value]; !ok {
+					addErr(lineNum, "unknown bootstrap agent %q", value)
+				}
+			case "from":
+				hasFrom = true
+				if value == "" {
+					addErr(lineNum, "empty From value")
+				}
+			}
+			continue
+		}
+
+		if checkFiles {
+			msg := checkFileReference(trimmed, buildDir)
+			if msg != "" {
+				addErr(lineNum, "%s", msg)
+			}
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	if bootstrap == "" {
+		addErr(1, "missing Bootstrap keyword, the header must start with 'Bootstrap: <agent>'")
+	} else if bootstrapAgents[bootstrap] && !hasFrom {
+		addErr(bootstrapLine, "bootstrap agent %s requires the From keyword", bootstrap)
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	return errs, nil
+}
+
+// CheckDefFile checks a definition file before building a container (see LintDefFile) and returns
+// an error listing all the problems found, with their line number
+func CheckDefFile(path string, buildDir string) error {
+	log.Printf("* Checking definition file %s...", path)
+	errs, err := LintDefFile(path, buildDir)
+	if err != nil {
+		return err
+	}
+	if len(errs) == 0 {
+		log.Println("... successfully checked the definition file.")
+		return nil
+	}
+
+	var msgs []string
+	for _, e := range errs {
+		if e.Line > 0 {
+			msgs = append(msgs, fmt.Sprintf("%s:%d: %s", path, e.Line, e.Msg))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", path, e.Msg))
+		}
+	}
+	return fmt.Errorf("invalid definition file:\n%s", strings.Join(msgs, "\n"))
+}
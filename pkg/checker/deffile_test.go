@@ -0,0 +1,79 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package checker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintDefFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-checker-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "mpitest.c"), "int main() { return 0; }\n")
+	RegisterTemplateTags("TESTMPIVERSION")
+
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name:    "valid",
+			content: "Bootstrap: docker\nFrom: ubuntu:disco\n\n%files\n\tmpitest.c /opt\n\n%post\n\t# comment\n\tapt-get update\n\n%apprun hello\n\texec /opt/hello\n",
+		},
+		{
+			name:    "no post section",
+			content: "Bootstrap: localimage\nFrom: base.sif\n\n%files\n\tmpitest.c /opt\n\n%labels\n\tApp hello\n",
+		},
+		{
+			name:     "unknown bootstrap",
+			content:  "Bootstrap: dockr\nFrom: ubuntu:disco\n\n%post\n\tapt-get update\n",
+			expected: []string{":1: unknown bootstrap agent \"dockr\""},
+		},
+		{
+			name:     "missing from",
+			content:  "Bootstrap: library\n\n%post\n\tapt-get update\n",
+			expected: []string{":1: bootstrap agent library requires the From keyword"},
+		},
+		{
+			name:     "missing sections and files",
+			content:  "# header comment\nBootstrap: docker\nFrom: ubuntu:disco\n\n%files\n\tmissing.c /opt\n%enviroment\n\texport A=1\n%appinstall\n",
+			expected: []string{":6: file " + filepath.Join(dir, "missing.c") + " does not exist", ":7: unknown section %enviroment", ":9: section %appinstall requires the name of an application"},
+		},
+		{
+			name:     "template tag",
+			content:  "Bootstrap: docker\nFrom: ubuntu:disco\n\n%post\n\texport MPI_VERSION=TESTMPIVERSION\n",
+			expected: []string{":5: template tag TESTMPIVERSION was not replaced"},
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".def")
+		writeFile(t, path, tt.content)
+		err := CheckDefFile(path, dir)
+		if len(tt.expected) == 0 {
+			if err != nil {
+				t.Fatalf("%s: CheckDefFile() failed: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("%s: CheckDefFile() succeeded", tt.name)
+		}
+		for _, e := range tt.expected {
+			if !strings.Contains(err.Error(), e) {
+				t.Fatalf("%s: %q is not reported:\n%s", tt.name, e, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package checker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+)
+
+const (
+	// AptPackageManager is the package manager of Debian based systems
+	AptPackageManager = "apt"
+
+	// DnfPackageManager is the package manager of RPM based systems such as CentOS, RHEL or Fedora
+	DnfPackageManager = "dnf"
+
+	// ZypperPackageManager is the package manager of SUSE based systems
+	ZypperPackageManager = "zypper"
+
+	// yumBin is the package manager of older RPM based systems, used when dnf is not available
+	yumBin = "yum"
+)
+
+// packageManager describes how to install packages with a given package manager
+type packageManager struct {
+	// update is the command refreshing the list of available packages, if any
+	update []string
+
+	// install is the command installing packages, the packages being added at the end
+	install []string
+
+	// packages maps the checks to the packages fixing them
+	packages map[string][]string
+}
+
+var packageManagers = map[string]packageManager{
+	AptPackageManager: {
+		update:  []string{"apt-get", "update"},
+		install: []string{"apt-get", "install", "-y"},
+		packages: map[string][]string{
+			BuildToolsCheck:   {"build-essential", "gfortran", "file", "bzip2", "tar"},
+			SquashfsCheck:     {"squashfs-tools"},
+			NetworkToolsCheck: {"wget"},
+			GitCheck:          {"git"},
+			UIDMapCheck:       {"uidmap"},
+			CryptsetupCheck:   {"cryptsetup"},
+		},
+	},
+	DnfPackageManager: {
+		install: []string{"dnf", "install", "-y"},
+		packages: map[string][]string{
+			BuildToolsCheck:   {"gcc", "gcc-c++", "gcc-gfortran", "make", "file", "bzip2", "tar"},
+			SquashfsCheck:     {"squashfs-tools"},
+			NetworkToolsCheck: {"wget"},
+			GitCheck:          {"git"},
+			UIDMapCheck:       {"shadow-utils"},
+			CryptsetupCheck:   {"cryptsetup"},
+		},
+	},
+	ZypperPackageManager: {
+		update:  []string{"zypper", "--non-interactive", "refresh"},
+		install: []string{"zypper", "--non-interactive", "install"},
+		packages: map[string][]string{
+			BuildToolsCheck:   {"gcc", "gcc-c++", "gcc-fortran", "make", "file", "bzip2", "tar"},
+			SquashfsCheck:     {"squashfs"},
+			NetworkToolsCheck: {"wget"},
+			GitCheck:          {"git"},
+			UIDMapCheck:       {"shadow"},
+			CryptsetupCheck:   {"cryptsetup"},
+		},
+	},
+}
+
+// getOSReleaseValues returns the values of the ID and ID_LIKE keys of /etc/os-release, e.g., ubuntu debian
+func getOSReleaseValues(content string) []string {
+	var ids []string
+	for _, line := range strings.Split(content, "\n") {
+		tokens := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(tokens) != 2 || (tokens[0] != "ID" && tokens[0] != "ID_LIKE") {
+			continue
+		}
+		ids = append(ids, strings.Fields(strings.Trim(tokens[1], "\"'"))...)
+	}
+	return ids
+}
+
+// getPackageManager returns the package manager of a Linux distribution based on the content of its /etc/os-release file
+func getPackageManager(osRelease string) (string, error) {
+	ids := getOSReleaseValues(osRelease)
+	for _, id := range ids {
+		switch id {
+		case "debian", "ubuntu":
+			return AptPackageManager, nil
+		case "rhel", "centos", "fedora", "rocky", "almalinux":
+			return DnfPackageManager, nil
+		case "suse", "opensuse", "sles":
+			return ZypperPackageManager, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported Linux distribution (%s)", strings.Join(ids, ", "))
+}
+
+// getDepsCommands returns the commands installing the packages fixing the failed checks of a
+// report with a given package manager; no command is returned when nothing needs to be installed
+func getDepsCommands(r *Report, manager string, sudoBin string) [][]string {
+	pm := packageManagers[manager]
+	var pkgs []string
+	for _, c := range r.Checks {
+		if !c.Pass {
+			pkgs = append(pkgs, pm.packages[c.Name]...)
+		}
+	}
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	var cmds [][]string
+	if len(pm.update) > 0 {
+		cmds = append(cmds, append([]string{sudoBin}, pm.update...))
+	}
+	install := append([]string{sudoBin}, pm.install...)
+	cmds = append(cmds, append(install, pkgs...))
+	return cmds
+}
+
+// InstallDeps installs with the package manager of the Linux distribution, and sudo, the packages
+// fixing the failed checks of a report. The command lines are returned; with dryRun, they are
+// not executed. The checks that cannot be fixed by installing packages, e.g., user namespaces,
+// are ignored.
+func InstallDeps(r *Report, dryRun bool) ([]string, error) {
+	data, err := ioutil.ReadFile(distroInfoFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", distroInfoFile, err)
+	}
+	manager, err := getPackageManager(string(data))
+	if err != nil {
+		return nil, err
+	}
+	sudoBin, err := exec.LookPath("sudo")
+	if err != nil && !dryRun {
+		return nil, fmt.Errorf("sudo not available: %s", err)
+	}
+	if sudoBin == "" {
+		sudoBin = "sudo"
+	}
+
+	var cmdLines []string
+	for _, args := range getDepsCommands(r, manager, sudoBin) {
+		// Older RPM based distributions only have yum, which accepts the same arguments
+		if args[1] == DnfPackageManager {
+			if _, err := exec.LookPath(DnfPackageManager); err != nil {
+				args[1] = yumBin
+			}
+		}
+		cmdLines = append(cmdLines, strings.Join(args, " "))
+		if dryRun {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := syexec.RunCmd(cmd)
+		if err != nil {
+			return cmdLines, fmt.Errorf("failed to execute %s: %s", strings.Join(args, " "), err)
+		}
+	}
+	return cmdLines, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package checker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGetDepsCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease string
+		manager   string
+	}{
+		{name: "ubuntu", osRelease: "NAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\n", manager: AptPackageManager},
+		{name: "centos", osRelease: "NAME=\"CentOS Linux\"\nID=\"centos\"\nID_LIKE=\"rhel fedora\"\n", manager: DnfPackageManager},
+		{name: "opensuse", osRelease: "ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n", manager: ZypperPackageManager},
+		{name: "unknown", osRelease: "ID=alpine\n"},
+	}
+	for _, tt := range tests {
+		manager, err := getPackageManager(tt.osRelease)
+		if tt.manager == "" {
+			if err == nil {
+				t.Fatalf("%s: getPackageManager() succeeded with an unsupported distribution", tt.name)
+			}
+			continue
+		}
+		if err != nil || manager != tt.manager {
+			t.Fatalf("%s: getPackageManager() returned %s instead of %s: %v", tt.name, manager, tt.manager, err)
+		}
+	}
+
+	r := Report{Checks: []CheckResult{
+		{Name: BuildToolsCheck, Pass: true},
+		{Name: SquashfsCheck, Pass: false, Err: fmt.Errorf("mksquashfs not found")},
+		{Name: GitCheck, Pass: false, Err: fmt.Errorf("git not found")},
+		{Name: UserNamespacesCheck, Pass: false, Err: fmt.Errorf("disabled")},
+	}}
+	cmds := getDepsCommands(&r, AptPackageManager, "sudo")
+	if len(cmds) != 2 || strings.Join(cmds[0], " ") != "sudo apt-get update" || strings.Join(cmds[1], " ") != "sudo apt-get install -y squashfs-tools git" {
+		t.Fatalf("invalid commands: %q", cmds)
+	}
+	cmds = getDepsCommands(&r, DnfPackageManager, "sudo")
+	if len(cmds) != 1 || strings.Join(cmds[0], " ") != "sudo dnf install -y squashfs-tools git" {
+		t.Fatalf("invalid commands: %q", cmds)
+	}
+	if len(getDepsCommands(&Report{Checks: []CheckResult{{Name: GitCheck, Pass: true}}}, AptPackageManager, "sudo")) != 0 {
+		t.Fatalf("commands returned while all the checks passed")
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package checker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+)
+
+const (
+	// SingularityCheck is the name of the check of the Singularity installation
+	SingularityCheck = "singularity"
+
+	// BuildToolsCheck is the name of the check of the tools required to build MPI and applications
+	BuildToolsCheck = "build tools"
+
+	// SquashfsCheck is the name of the check of the squashfs tools
+	SquashfsCheck = "squashfs tools"
+
+	// NetworkToolsCheck is the name of the check of the tools used to download software
+	NetworkToolsCheck = "network tools"
+
+	// GitCheck is the name of the check of git, used to get software from Git repositories
+	GitCheck = "git"
+
+	// UIDMapCheck is the name of the check of the tools used to map user IDs in user namespaces
+	UIDMapCheck = "uidmap"
+
+	// UserNamespacesCheck is the name of the check of unprivileged user namespaces
+	UserNamespacesCheck = "user namespaces"
+
+	// SubIDsCheck is the name of the check of the subuid/subgid configuration of the current user
+	SubIDsCheck = "subuid/subgid"
+
+	// CryptsetupCheck is the name of the check of cryptsetup, used for encrypted containers
+	CryptsetupCheck = "cryptsetup"
+
+	buildToolsBinaries   = "gfortran gcc g++ make file bzip2 tar"
+	squashfsBinaries     = "mksquashfs unsquashfs"
+	networkToolsBinaries = "wget"
+
+	procDir      = "/proc/sys"
+	subUIDFile   = "/etc/subuid"
+	subGIDFile   = "/etc/subgid"
+	maxUserNSKey = "user/max_user_namespaces"
+	userNSKey    = "kernel/unprivileged_userns_clone"
+)
+
+// CheckResult is the result of an individual check of the system configuration
+type CheckResult struct {
+	// Name is the name of the check
+	Name string
+
+	// Pass specifies whether the check succeeded
+	Pass bool
+
+	// Required specifies whether the tool cannot run correctly when the check fails
+	Required bool
+
+	// Err is the reason of the failure of the check
+	Err error
+
+	// Hint is the advice to fix the configuration of the system when the check fails
+	Hint string
+}
+
+// Report gathers the results of all the checks of the system configuration
+type Report struct {
+	// Checks is the ordered list of the results of the checks
+	Checks []CheckResult
+}
+
+// Get returns the result of a specific check, nil if the check is not part of the report
+func (r *Report) Get(name string) *CheckResult {
+	for i := range r.Checks {
+		if r.Checks[i].Name == name {
+			return &r.Checks[i]
+		}
+	}
+	return nil
+}
+
+// Passed checks whether all the required checks succeeded
+func (r *Report) Passed() bool {
+	for _, c := range r.Checks {
+		if c.Required && !c.Pass {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns the error of the first required check that failed. sympierr.ErrSingularityNotInstalled
+// is returned when all the required checks succeeded but Singularity is not installed.
+func (r *Report) Err() error {
+	for _, c := range r.Checks {
+		if c.Required && !c.Pass {
+			return fmt.Errorf("%s: %s", c.Name, c.Err)
+		}
+	}
+
+	c := r.Get(SingularityCheck)
+	if c != nil && !c.Pass {
+		return sympierr.ErrSingularityNotInstalled
+	}
+	return nil
+}
+
+// String renders the report so it can be displayed to users
+func (r *Report) String() string {
+	width := 0
+	for _, c := range r.Checks {
+		if len(c.Name) > width {
+			width = len(c.Name)
+		}
+	}
+
+	var sb strings.Builder
+	for _, c := range r.Checks {
+		status := "pass"
+		if !c.Pass && c.Required {
+			status = "FAIL"
+		} else if !c.Pass {
+			status = "warn"
+		}
+		sb.WriteString(fmt.Sprintf("* %-*s  %s\n", width, c.Name, status))
+		if !c.Pass {
+			sb.WriteString(fmt.Sprintf("    %s\n", c.Err))
+			if c.Hint != "" {
+				sb.WriteString(fmt.Sprintf("    hint: %s\n", c.Hint))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// checkBinaries checks that a list of binaries is available
+func checkBinaries(name string, binaries string, required bool, hint string) CheckResult {
+	res := CheckResult{Name: name, Pass: true, Required: required}
+	var missing []string
+	for _, b := range strings.Split(binaries, " ") {
+		_, err := exec.LookPath(b)
+		if err != nil {
+			missing = append(missing, b)
+		}
+	}
+	if len(missing) > 0 {
+		res.Pass = false
+		res.Err = fmt.Errorf("%s not found", strings.Join(missing, ", "))
+		res.Hint = hint
+	}
+	return res
+}
+
+// readProcValue reads an integer value from a kernel configuration file, e.g., /proc/sys/user/max_user_namespaces
+func readProcValue(dir string, key string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, key))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// checkUserNamespaces checks whether unprivileged users can create user namespaces, which is
+// required to build images with --fakeroot and to run Singularity without setuid
+func checkUserNamespaces(dir string) CheckResult {
+	res := CheckResult{Name: UserNamespacesCheck, Pass: true}
+	hint := "run 'sysctl -w user.max_user_namespaces=15000' as root; on RPM systems, also run 'grubby --args=\"user_namespace.enable=1\" --update-kernel=\"$(grubby --default-kernel)\"' and reboot"
+
+	max, err := readProcValue(dir, maxUserNSKey)
+	if err != nil {
+		res.Pass = false
+		res.Err = fmt.Errorf("unable to get the maximum number of user namespaces: %s", err)
+		res.Hint = hint
+		return res
+	}
+	if max == 0 {
+		res.Pass = false
+		res.Err = fmt.Errorf("user namespaces are disabled")
+		res.Hint = hint
+		return res
+	}
+
+	// This setting only exists on some kernels, e.g., Debian
+	if util.FileExists(filepath.Join(dir, userNSKey)) {
+		enabled, err := readProcValue(dir, userNSKey)
+		if err != nil || enabled == 0 {
+			res.Pass = false
+			res.Err = fmt.Errorf("unprivileged user namespaces are disabled")
+			res.Hint = "run 'sysctl -w kernel.unprivileged_userns_clone=1' as root"
+		}
+	}
+
+	return res
+}
+
+// hasSubIDs checks whether a subuid/subgid file has an entry for a user
+func hasSubIDs(path string, u *user.User) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tokens := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(tokens) == 3 && (tokens[0] == u.Username || tokens[0] == u.Uid) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// checkSubIDs checks whether subordinate user and group IDs are configured for a user, which is
+// required to build images with --fakeroot
+func checkSubIDs(uidFile string, gidFile string, u *user.User) CheckResult {
+	res := CheckResult{Name: SubIDsCheck, Pass: true}
+	for _, path := range []string{uidFile, gidFile} {
+		found, err := hasSubIDs(path, u)
+		if err != nil {
+			res.Pass = false
+			res.Err = fmt.Errorf("unable to read %s: %s", path, err)
+		} else if !found {
+			res.Pass = false
+			res.Err = fmt.Errorf("no entry for %s in %s", u.Username, path)
+		}
+		if !res.Pass {
+			res.Hint = fmt.Sprintf("run 'usermod --add-subuids 100000-165535 --add-subgids 100000-165535 %s' as root", u.Username)
+			return res
+		}
+	}
+	return res
+}
+
+// singularityBuildDeps is the hint listing the packages required to build Singularity from its
+// sources
+const singularityBuildDeps = "building Singularity requires libssl-dev, uuid-dev, libgpgme11-dev, libseccomp-dev and pkg-config (openssl-devel, libuuid-devel, gpgme-devel, libseccomp-devel and pkgconfig on RPM based systems)"
+
+// checkSingularity checks the installation of Singularity
+func checkSingularity() CheckResult {
+	res := CheckResult{Name: SingularityCheck, Pass: true}
+	err := checkSingularityInstall()
+	if errors.Is(err, sympierr.ErrSingularityNotInstalled) {
+		res.Pass = false
+		res.Err = err
+		res.Hint = "run 'sympi -install singularity:<version>' or install Apptainer; " + singularityBuildDeps
+	} else if err != nil {
+		// Singularity is installed but does not work
+		res.Pass = false
+		res.Required = true
+		res.Err = err
+		res.Hint = "check the installation of Singularity or reinstall it with 'sympi -install singularity:<version>'; " + singularityBuildDeps
+	}
+	return res
+}
+
+// CheckFakeroot checks whether the current user can build images with --fakeroot, i.e., without
+// any privilege, which is the fallback of the builds when sudo is not available
+func CheckFakeroot() error {
+	res := checkUserNamespaces(procDir)
+	if res.Pass {
+		u, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("unable to get the current user: %s", err)
+		}
+		res = checkSubIDs(subUIDFile, subGIDFile, u)
+	}
+	if !res.Pass {
+		return fmt.Errorf("%s (%s)", res.Err, res.Hint)
+	}
+	return nil
+}
+
+// RunSystemChecks individually checks all the aspects of the system configuration the tool depends on
+func RunSystemChecks() *Report {
+	var r Report
+
+	r.Checks = append(r.Checks, checkSingularity())
+	r.Checks = append(r.Checks, checkBinaries(BuildToolsCheck, buildToolsBinaries, true,
+		"on Debian based systems: apt -y install build-essential gfortran file bzip2 tar; on RPM based systems: yum groupinstall -y 'Development Tools' && yum install -y gcc-gfortran file bzip2 tar"))
+	r.Checks = append(r.Checks, checkBinaries(SquashfsCheck, squashfsBinaries, true, "install the squashfs-tools package"))
+	r.Checks = append(r.Checks, checkBinaries(NetworkToolsCheck, networkToolsBinaries, true, "install the wget package"))
+	r.Checks = append(r.Checks, checkBinaries(GitCheck, GitCheck, false, "install the git package to use software from Git repositories"))
+	r.Checks = append(r.Checks, checkBinaries(UIDMapCheck, "newuidmap", true, "on Debian based systems, install the uidmap package; on RPM based systems, install the shadow-utils package"))
+	r.Checks = append(r.Checks, checkUserNamespaces(procDir))
+	u, err := user.Current()
+	if err != nil {
+		r.Checks = append(r.Checks, CheckResult{Name: SubIDsCheck, Pass: false, Err: fmt.Errorf("unable to get the current user: %s", err)})
+	} else {
+		r.Checks = append(r.Checks, checkSubIDs(subUIDFile, subGIDFile, u))
+	}
+	r.Checks = append(r.Checks, checkBinaries(CryptsetupCheck, CryptsetupCheck, false, "install the cryptsetup package to use encrypted containers"))
+
+	return &r
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package checker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", path, err)
+	}
+}
+
+func TestCheckUserNamespaces(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxUserNS    string
+		userNSClone  string
+		expectedPass bool
+	}{
+		{
+			name:         "enabled",
+			maxUserNS:    "15000\n",
+			expectedPass: true,
+		},
+		{
+			name:         "disabled",
+			maxUserNS:    "0\n",
+			expectedPass: false,
+		},
+		{
+			name:         "unprivileged disabled",
+			maxUserNS:    "15000\n",
+			userNSClone:  "0\n",
+			expectedPass: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "sympi-checker-")
+			if err != nil {
+				t.Fatalf("failed to create temporary directory: %s", err)
+			}
+			defer os.RemoveAll(dir)
+
+			writeFile(t, filepath.Join(dir, maxUserNSKey), tt.maxUserNS)
+			if tt.userNSClone != "" {
+				writeFile(t, filepath.Join(dir, userNSKey), tt.userNSClone)
+			}
+
+			res := checkUserNamespaces(dir)
+			if res.Pass != tt.expectedPass {
+				t.Fatalf("checkUserNamespaces() returned %t instead of %t (%s)", res.Pass, tt.expectedPass, res.Err)
+			}
+			if !res.Pass && res.Hint == "" {
+				t.Fatalf("failed check does not provide any hint")
+			}
+		})
+	}
+}
+
+func TestCheckSubIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-checker-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := user.User{Username: "sympi", Uid: "1234"}
+	uidFile := filepath.Join(dir, "subuid")
+	gidFile := filepath.Join(dir, "subgid")
+	writeFile(t, uidFile, "other:100000:65536\nsympi:165536:65536\n")
+	writeFile(t, gidFile, "1234:165536:65536\n")
+
+	res := checkSubIDs(uidFile, gidFile, &u)
+	if !res.Pass {
+		t.Fatalf("checkSubIDs() failed: %s", res.Err)
+	}
+
+	writeFile(t, gidFile, "other:100000:65536\n")
+	res = checkSubIDs(uidFile, gidFile, &u)
+	if res.Pass {
+		t.Fatalf("checkSubIDs() succeeded without subgid entry")
+	}
+}
+
+func TestReportErr(t *testing.T) {
+	var r Report
+	r.Checks = []CheckResult{
+		{Name: SingularityCheck, Pass: false, Err: sympierr.ErrSingularityNotInstalled},
+		{Name: CryptsetupCheck, Pass: false, Err: fmt.Errorf("cryptsetup not found")},
+	}
+	if !r.Passed() {
+		t.Fatalf("report with only optional failures is reported as failed")
+	}
+	if r.Err() != sympierr.ErrSingularityNotInstalled {
+		t.Fatalf("Err() returned %s instead of %s", r.Err(), sympierr.ErrSingularityNotInstalled)
+	}
+
+	r.Checks = append(r.Checks, CheckResult{Name: SquashfsCheck, Pass: false, Required: true, Err: fmt.Errorf("mksquashfs not found")})
+	if r.Passed() || r.Err() == nil || r.Err() == sympierr.ErrSingularityNotInstalled {
+		t.Fatalf("failed required check is not reported")
+	}
+}
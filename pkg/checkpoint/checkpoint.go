@@ -0,0 +1,130 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package checkpoint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DMTCP is the identifier of DMTCP (http://dmtcp.sourceforge.net)
+	DMTCP = "dmtcp"
+
+	// MANA is the identifier of MANA (MPI-Agnostic Network-Agnostic checkpointing), which is
+	// based on DMTCP and restarts MPI applications with a different MPI
+	MANA = "mana"
+
+	// CkptDirTag is the tag replaced by the directory where checkpoints are saved in the commands
+	// of a tool
+	CkptDirTag = "#CKPTDIR"
+
+	// PortTag is the tag replaced by the port of the coordinator in the commands of a tool
+	PortTag = "#PORT"
+
+	// dmtcpURL is the URL of the repository of DMTCP
+	dmtcpURL = "https://github.com/dmtcp/dmtcp.git"
+
+	// manaURL is the URL of the repository of MANA
+	manaURL = "https://github.com/mpickpt/mana.git"
+)
+
+// Tool describes how a checkpointing tool is installed in a container and how it checkpoints
+// and restarts an application, all the commands being executed in the container
+type Tool struct {
+	// Name is the identifier of the tool, e.g., DMTCP
+	Name string
+
+	// Install is the list of commands installing the tool in the post section of a definition
+	// file, once MPI is installed
+	Install []string
+
+	// Coordinator is the command starting the coordinator of the tool in the background
+	Coordinator []string
+
+	// Launch is the command executing the application under the tool
+	Launch []string
+
+	// Checkpoint is the command checkpointing the application and waiting for the checkpoint
+	// to complete
+	Checkpoint []string
+
+	// Quit is the command terminating the application and the coordinator
+	Quit []string
+
+	// Restart is the command restarting the application from its checkpoint
+	Restart []string
+
+	// RestartWithMPI specifies whether the restart command is executed by each rank, i.e.,
+	// started by mpirun, or only once, the tool restarting all the ranks
+	RestartWithMPI bool
+}
+
+// GetTools returns the identifiers of the supported checkpointing tools
+func GetTools() []string {
+	return []string{DMTCP, MANA}
+}
+
+// Validate checks whether a checkpointing tool is supported
+func Validate(name string) error {
+	for _, t := range GetTools() {
+		if name == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported checkpointing tool %s, supported tools: %s", name, strings.Join(GetTools(), ", "))
+}
+
+// Get returns the description of a checkpointing tool
+func Get(name string) (*Tool, error) {
+	switch name {
+	case DMTCP:
+		return &Tool{
+			Name: DMTCP,
+			Install: []string{
+				"git clone " + dmtcpURL + " /tmp/build-dmtcp",
+				"cd /tmp/build-dmtcp && ./configure --prefix=/usr/local && make -j8 install",
+				"rm -rf /tmp/build-dmtcp",
+			},
+			Coordinator: []string{"dmtcp_coordinator", "--daemon", "--exit-on-last", "--coord-port", PortTag, "--ckptdir", CkptDirTag},
+			Launch:      []string{"dmtcp_launch", "--join-coordinator", "--coord-port", PortTag, "--ckptdir", CkptDirTag},
+			Checkpoint:  []string{"dmtcp_command", "--coord-port", PortTag, "--bcheckpoint"},
+			Quit:        []string{"dmtcp_command", "--coord-port", PortTag, "--quit"},
+			// The restart script generated by the coordinator restarts all the processes
+			Restart: []string{"sh", CkptDirTag + "/dmtcp_restart_script.sh", "--coord-port", PortTag},
+		}, nil
+	case MANA:
+		// MANA must be compiled with the MPI of the container, which is installed first
+		return &Tool{
+			Name: MANA,
+			Install: []string{
+				"git clone " + manaURL + " /usr/local/mana",
+				"cd /usr/local/mana && git submodule update --init && ./configure --prefix=/usr/local/mana && make -j8 mana",
+				"ln -sf /usr/local/mana/bin/* /usr/local/bin/",
+			},
+			Coordinator:    []string{"mana_coordinator", "--coord-port", PortTag, "--ckptdir", CkptDirTag},
+			Launch:         []string{"mana_launch", "--coord-port", PortTag, "--ckptdir", CkptDirTag},
+			Checkpoint:     []string{"dmtcp_command", "--coord-port", PortTag, "--bcheckpoint"},
+			Quit:           []string{"dmtcp_command", "--coord-port", PortTag, "--quit"},
+			Restart:        []string{"mana_restart", "--coord-port", PortTag, "--restartdir", CkptDirTag},
+			RestartWithMPI: true,
+		}, nil
+	}
+	return nil, Validate(name)
+}
+
+// Expand replaces the tags of a command of a tool by the directory where checkpoints are saved
+// and the port of the coordinator
+func Expand(cmd []string, ckptDir string, port int) []string {
+	var expanded []string
+	for _, arg := range cmd {
+		arg = strings.ReplaceAll(arg, CkptDirTag, ckptDir)
+		arg = strings.ReplaceAll(arg, PortTag, strconv.Itoa(port))
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
@@ -8,8 +8,6 @@ package configparser
 import (
 	"fmt"
 	"strings"
-
-	"github.com/gvallee/kv/pkg/kv"
 )
 
 // Config represents the configuration of the tests to run
@@ -103,7 +101,7 @@ func Parse(file string) (*Config, error) {
 
 	config.MpiMap = make(map[string]string)
 
-	kvs, err := kv.LoadKeyValueConfig(file)
+	kvs, err := Load(file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
 	}
@@ -7,8 +7,6 @@ package configparser
 
 import (
 	"fmt"
-
-	"github.com/gvallee/kv/pkg/kv"
 )
 
 // OFIConfig is the structure gathering all the configuration details relevant for OFI.
@@ -20,7 +18,7 @@ type OFIConfig struct {
 
 // LoadOFIConfig reads the OFI configuration file and return the associated data structure.
 func LoadOFIConfig(filepath string) (*OFIConfig, error) {
-	kvs, err := kv.LoadKeyValueConfig(filepath)
+	kvs, err := Load(filepath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load key/value from %s: %s", filepath, err)
 	}
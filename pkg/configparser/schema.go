@@ -0,0 +1,132 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package configparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gvallee/kv/pkg/kv"
+)
+
+// KeySpec describes a key accepted in a configuration file
+type KeySpec struct {
+	// Name is the name of the key or, when Prefix is set, the prefix of the names of a family of
+	// keys, e.g., build_env.
+	Name string
+
+	// Prefix specifies whether Name is the prefix of the names of the keys
+	Prefix bool
+
+	// Required specifies whether the key must be defined
+	Required bool
+
+	// Validate checks the value of the key, nil when any value is valid
+	Validate func(string) error
+}
+
+// Schema is the list of the keys accepted in a configuration file
+type Schema []KeySpec
+
+// ValidateBool checks whether a value is a boolean, e.g., true or false
+func ValidateBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+// lookup returns the specification of a key, nil if the key is unknown
+func (s Schema) lookup(key string) *KeySpec {
+	for i := range s {
+		if s[i].Name == key || s[i].Prefix && strings.HasPrefix(key, s[i].Name) {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+// getDistance returns the Levenshtein distance between two strings
+func getDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// suggest returns the known key the closest to an unknown key, empty if none is close enough to
+// be a typo
+func (s Schema) suggest(key string) string {
+	suggestion := ""
+	best := len(key)/3 + 1
+	for _, spec := range s {
+		if spec.Prefix {
+			continue
+		}
+		d := getDistance(key, spec.Name)
+		if d <= best {
+			suggestion = spec.Name
+			best = d - 1
+		}
+	}
+	return suggestion
+}
+
+// Check checks key/value pairs against the schema and returns all the problems found: unknown
+// keys, with the closest known key when it looks like a typo, keys defined more than once,
+// required keys that are not defined and invalid values
+func (s Schema) Check(kvs []kv.KV) []error {
+	var problems []error
+	defined := make(map[string]bool)
+	for _, e := range kvs {
+		if defined[e.Key] {
+			problems = append(problems, fmt.Errorf("%s is defined more than once", e.Key))
+			continue
+		}
+		defined[e.Key] = true
+
+		spec := s.lookup(e.Key)
+		if spec == nil {
+			if suggestion := s.suggest(e.Key); suggestion != "" {
+				problems = append(problems, fmt.Errorf("unknown key %s, did you mean %s?", e.Key, suggestion))
+			} else {
+				problems = append(problems, fmt.Errorf("unknown key %s", e.Key))
+			}
+			continue
+		}
+		if spec.Validate != nil && e.Value != "" {
+			err := spec.Validate(e.Value)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("invalid value of %s: %s", e.Key, err))
+			}
+		}
+	}
+
+	for _, spec := range s {
+		if spec.Required && kv.GetValue(kvs, spec.Name) == "" {
+			problems = append(problems, fmt.Errorf("%s is not defined", spec.Name))
+		}
+	}
+	return problems
+}
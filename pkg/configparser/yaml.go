@@ -0,0 +1,337 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package configparser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// SectionTool is the section of a YAML configuration file gathering the tool's settings
+	SectionTool = "tool"
+
+	// SectionVersions is the section of a YAML configuration file gathering the versions of
+	// a software (MPI implementation or Singularity) and the URL of the associated source code
+	SectionVersions = "versions"
+
+	// SectionRegistry is the section of a YAML configuration file gathering the versions of
+	// a MPI implementation and the URL of the associated pre-built image
+	SectionRegistry = "registry"
+
+	// SectionNetwork is the section of a YAML configuration file gathering the network settings
+	SectionNetwork = "network"
+
+	// SectionApp is the section of a YAML configuration file describing an application to containerize
+	SectionApp = "app"
+
+	// SectionRemote is the section of a YAML configuration file describing the remote host where
+	// the tool is executed, e.g., a cluster login node. Its keys are prefixed with RemoteKeyPrefix
+	// when loaded, e.g., host becomes remote_host.
+	SectionRemote = "remote"
+
+	// RemoteKeyPrefix is the prefix of the keys of the remote section once loaded, which is also
+	// how they are specified in a key=value configuration file, e.g., remote_host = login.cluster
+	RemoteKeyPrefix = "remote_"
+
+	toolConfigFilename = "singularity-mpi.conf"
+	ofiConfigFilename  = "sympi_ofi.conf"
+	registryFileSuffix = "-images.conf"
+	confFilePrefix     = "sympi_"
+	appNameKey         = "app_name"
+)
+
+// YAMLConfig represents a YAML configuration file. Each section is a set of key/value pairs
+// equivalent to the content of a key=value configuration file; a file can have any number of
+// sections, all the key/value pairs being loaded.
+type YAMLConfig struct {
+	// Tool gathers the tool's settings, e.g., build_privilege
+	Tool map[string]string `yaml:"tool,omitempty"`
+
+	// Versions maps versions of a MPI implementation or Singularity to the URL of the source code
+	Versions map[string]string `yaml:"versions,omitempty"`
+
+	// Registry maps versions of a MPI implementation to the URL of a pre-built image
+	Registry map[string]string `yaml:"registry,omitempty"`
+
+	// Network gathers the network settings, e.g., the OFI network interface
+	Network map[string]string `yaml:"network,omitempty"`
+
+	// App gathers the details of an application to containerize, e.g., app_name
+	App map[string]string `yaml:"app,omitempty"`
+
+	// Remote gathers the details of the remote host where the tool is executed, e.g., host, user and workdir
+	Remote map[string]string `yaml:"remote,omitempty"`
+}
+
+// IsYAMLFile checks whether a configuration file is a YAML file based on its extension
+func IsYAMLFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// getYAMLPath returns the path of the YAML file equivalent to a key=value configuration file,
+// e.g., sympi_openmpi.yaml for sympi_openmpi.conf
+func getYAMLPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".yaml"
+}
+
+// GetConfigFilePath returns the path to the file actually used when loading a configuration
+// file: the file itself if it exists, otherwise the equivalent YAML file if it exists.
+func GetConfigFilePath(path string) string {
+	if IsYAMLFile(path) || util.FileExists(path) {
+		return path
+	}
+	for _, ext := range []string{".yaml", ".yml"} {
+		yamlPath := strings.TrimSuffix(path, filepath.Ext(path)) + ext
+		if util.FileExists(yamlPath) {
+			return yamlPath
+		}
+	}
+	return path
+}
+
+// LoadYAMLConfig parses a YAML configuration file
+func LoadYAMLConfig(path string) (*YAMLConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	cfg := new(YAMLConfig)
+	err = yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+
+	return cfg, nil
+}
+
+func mapToKV(m map[string]string) []kv.KV {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var kvs []kv.KV
+	for _, k := range keys {
+		kvs = append(kvs, kv.KV{Key: k, Value: m[k]})
+	}
+	return kvs
+}
+
+func kvToMap(kvs []kv.KV) map[string]string {
+	m := make(map[string]string)
+	for _, e := range kvs {
+		m[e.Key] = e.Value
+	}
+	return m
+}
+
+// ToKV returns the key/value pairs of all the sections of a YAML configuration
+func (c *YAMLConfig) ToKV() []kv.KV {
+	var kvs []kv.KV
+	for _, section := range []map[string]string{c.Tool, c.Versions, c.Registry, c.Network, c.App} {
+		kvs = append(kvs, mapToKV(section)...)
+	}
+	for _, e := range mapToKV(c.Remote) {
+		kvs = append(kvs, kv.KV{Key: RemoteKeyPrefix + e.Key, Value: e.Value})
+	}
+	return kvs
+}
+
+// GetSectionKV returns the key/value pairs of a section of a YAML configuration; the keys of the
+// remote section are prefixed with RemoteKeyPrefix
+func (c *YAMLConfig) GetSectionKV(section string) []kv.KV {
+	switch section {
+	case SectionTool:
+		return mapToKV(c.Tool)
+	case SectionVersions:
+		return mapToKV(c.Versions)
+	case SectionRegistry:
+		return mapToKV(c.Registry)
+	case SectionNetwork:
+		return mapToKV(c.Network)
+	case SectionApp:
+		return mapToKV(c.App)
+	case SectionRemote:
+		var kvs []kv.KV
+		for _, e := range mapToKV(c.Remote) {
+			kvs = append(kvs, kv.KV{Key: RemoteKeyPrefix + e.Key, Value: e.Value})
+		}
+		return kvs
+	}
+	return nil
+}
+
+// Load reads a configuration file into a slice of key/value pairs. Both the key=value and
+// YAML formats are supported; when a key=value configuration file does not exist, the
+// equivalent YAML file (same name with the .yaml or .yml extension) is used if available.
+func Load(path string) ([]kv.KV, error) {
+	path = GetConfigFilePath(path)
+	if !IsYAMLFile(path) {
+		return kv.LoadKeyValueConfig(path)
+	}
+
+	cfg, err := LoadYAMLConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.ToKV(), nil
+}
+
+// isSection checks whether a name is a section of a YAML configuration file
+func isSection(name string) bool {
+	switch name {
+	case SectionTool, SectionVersions, SectionRegistry, SectionNetwork, SectionApp, SectionRemote:
+		return true
+	}
+	return false
+}
+
+// getSectionKeyPrefix returns the prefix of the keys of a section once loaded (see GetSectionKV)
+func getSectionKeyPrefix(section string) string {
+	if section == SectionRemote {
+		return RemoteKeyPrefix
+	}
+	return ""
+}
+
+// updateYAMLDocument updates a YAML document with key/value pairs saved under a section: the keys
+// that are in another section of the document, e.g., the keys of all the sections returned by
+// Load, are updated in their section, the other keys replacing the content of the section. The
+// other sections and the unknown keys of the document are kept as is.
+func updateYAMLDocument(doc yaml.MapSlice, section string, kvs []kv.KV) yaml.MapSlice {
+	values := kvToMap(kvs)
+	for _, item := range doc {
+		name := fmt.Sprint(item.Key)
+		entries, ok := item.Value.(yaml.MapSlice)
+		if name == section || !isSection(name) || !ok {
+			continue
+		}
+		prefix := getSectionKeyPrefix(name)
+		for i := range entries {
+			key := prefix + fmt.Sprint(entries[i].Key)
+			if value, ok := values[key]; ok {
+				entries[i].Value = value
+				delete(values, key)
+			}
+		}
+	}
+
+	var entries yaml.MapSlice
+	for _, e := range mapToKV(values) {
+		entries = append(entries, yaml.MapItem{Key: strings.TrimPrefix(e.Key, getSectionKeyPrefix(section)), Value: e.Value})
+	}
+	for i, item := range doc {
+		if fmt.Sprint(item.Key) != section {
+			continue
+		}
+		if len(entries) == 0 {
+			return append(doc[:i], doc[i+1:]...)
+		}
+		doc[i].Value = entries
+		return doc
+	}
+	if len(entries) == 0 {
+		return doc
+	}
+	return append(doc, yaml.MapItem{Key: section, Value: entries})
+}
+
+// Save writes key/value pairs to a configuration file, using the YAML format, under a given
+// section, when the path is a YAML file and the key=value format otherwise. An existing YAML file
+// is updated, its other sections being preserved (see updateYAMLDocument).
+func Save(path string, section string, kvs []kv.KV) error {
+	var data []byte
+	if !IsYAMLFile(path) {
+		data = []byte(strings.Join(kv.ToStringSlice(kvs), "\n"))
+	} else {
+		if !isSection(section) {
+			return fmt.Errorf("unknown section: %s", section)
+		}
+
+		var doc yaml.MapSlice
+		if util.FileExists(path) {
+			current, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %s", path, err)
+			}
+			err = yaml.Unmarshal(current, &doc)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %s", path, err)
+			}
+		}
+
+		var err error
+		data, err = yaml.Marshal(updateYAMLDocument(doc, section, kvs))
+		if err != nil {
+			return fmt.Errorf("failed to create YAML data: %s", err)
+		}
+	}
+
+	err := ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// GetSection figures out the section of a YAML configuration file that is equivalent to a
+// key=value configuration file, based on its name and content
+func GetSection(path string, kvs []kv.KV) string {
+	filename := filepath.Base(path)
+	switch {
+	case filename == toolConfigFilename:
+		return SectionTool
+	case strings.HasSuffix(filename, registryFileSuffix):
+		return SectionRegistry
+	case filename == ofiConfigFilename:
+		return SectionNetwork
+	case kv.KeyExists(kvs, appNameKey):
+		return SectionApp
+	case strings.HasPrefix(filename, confFilePrefix):
+		return SectionVersions
+	}
+	return SectionTool
+}
+
+// ConvertToYAML converts a key=value configuration file into the equivalent YAML file, saved
+// next to the original file, and returns the path to the new file.
+func ConvertToYAML(path string) (string, error) {
+	if IsYAMLFile(path) {
+		return "", fmt.Errorf("%s is already a YAML file", path)
+	}
+
+	yamlPath := getYAMLPath(path)
+	if util.PathExists(yamlPath) {
+		return "", sympierr.Wrap(sympierr.ErrFileExists, nil, "%s", yamlPath)
+	}
+
+	kvs, err := kv.LoadKeyValueConfig(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s: %s", path, err)
+	}
+
+	section := GetSection(path, kvs)
+	log.Printf("-> Converting %s into %s (section: %s)", path, yamlPath, section)
+	err = Save(yamlPath, section, kvs)
+	if err != nil {
+		return "", err
+	}
+
+	return yamlPath, nil
+}
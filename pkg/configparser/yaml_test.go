@@ -0,0 +1,157 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package configparser
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+)
+
+func TestConvertToYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-configparser-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		filename        string
+		content         string
+		expectedSection string
+		expectedKey     string
+		expectedValue   string
+	}{
+		{
+			filename:        "sympi_openmpi.conf",
+			content:         "3.10=https://download.open-mpi.org/release/open-mpi/v3.1/openmpi-3.1.0.tar.bz2\n",
+			expectedSection: SectionVersions,
+			expectedKey:     "3.10",
+			expectedValue:   "https://download.open-mpi.org/release/open-mpi/v3.1/openmpi-3.1.0.tar.bz2",
+		},
+		{
+			filename:        "sympi_openmpi-images.conf",
+			content:         "4.0.0=library://vallee/mpi/ubuntu-disco-openmpi-4.0.0-netpipe-5.1.4:20190925\n",
+			expectedSection: SectionRegistry,
+			expectedKey:     "4.0.0",
+			expectedValue:   "library://vallee/mpi/ubuntu-disco-openmpi-4.0.0-netpipe-5.1.4:20190925",
+		},
+		{
+			filename:        "singularity-mpi.conf",
+			content:         "build_privilege = true\n",
+			expectedSection: SectionTool,
+			expectedKey:     "build_privilege",
+			expectedValue:   "true",
+		},
+		{
+			filename:        "helloworld.conf",
+			content:         "app_name = helloworld\n",
+			expectedSection: SectionApp,
+			expectedKey:     "app_name",
+			expectedValue:   "helloworld",
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.filename)
+		err := ioutil.WriteFile(path, []byte(tt.content), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+
+		kvs, err := kv.LoadKeyValueConfig(path)
+		if err != nil {
+			t.Fatalf("failed to load %s: %s", path, err)
+		}
+		section := GetSection(path, kvs)
+		if section != tt.expectedSection {
+			t.Fatalf("section of %s is %s instead of %s", tt.filename, section, tt.expectedSection)
+		}
+
+		yamlPath, err := ConvertToYAML(path)
+		if err != nil {
+			t.Fatalf("failed to convert %s: %s", path, err)
+		}
+		_, err = ConvertToYAML(path)
+		if !errors.Is(err, sympierr.ErrFileExists) || !strings.Contains(err.Error(), yamlPath) {
+			t.Fatalf("converting %s again did not report that %s exists: %v", path, yamlPath, err)
+		}
+
+		// Once the key=value file is removed, the YAML file must be used transparently
+		err = os.Remove(path)
+		if err != nil {
+			t.Fatalf("failed to remove %s: %s", path, err)
+		}
+		if GetConfigFilePath(path) != yamlPath {
+			t.Fatalf("%s is used instead of %s", GetConfigFilePath(path), yamlPath)
+		}
+		kvs, err = Load(path)
+		if err != nil {
+			t.Fatalf("failed to load %s: %s", yamlPath, err)
+		}
+		if kv.GetValue(kvs, tt.expectedKey) != tt.expectedValue {
+			t.Fatalf("value of %s is %s instead of %s", tt.expectedKey, kv.GetValue(kvs, tt.expectedKey), tt.expectedValue)
+		}
+	}
+}
+
+func TestSaveYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-configparser-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "singularity-mpi.yaml")
+	content := "# Settings of the cluster\ncomment: kept as is\ntool:\n  build_privilege: true\n  sandbox_env: false\nnetwork:\n  ofi_ifnet: ib0\nremote:\n  host: login.cluster\n"
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", path, err)
+	}
+
+	// The key/value pairs of all the sections are saved under the tool section, e.g., when a key
+	// of the tool's configuration is updated
+	var kvs []kv.KV
+	kvs = append(kvs, kv.KV{Key: "build_privilege", Value: "false"})
+	kvs = append(kvs, kv.KV{Key: "ofi_ifnet", Value: "ib1"})
+	kvs = append(kvs, kv.KV{Key: RemoteKeyPrefix + "host", Value: "login2.cluster"})
+	kvs = append(kvs, kv.KV{Key: "heartbeat", Value: "5m"})
+	err = Save(path, SectionTool, kvs)
+	if err != nil {
+		t.Fatalf("Save() failed: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	if !strings.Contains(string(data), "comment: kept as is") {
+		t.Fatalf("unknown key of %s not preserved:\n%s", path, data)
+	}
+	err = ioutil.WriteFile(path, []byte(strings.Replace(string(data), "comment: kept as is\n", "", 1)), 0644)
+	if err != nil {
+		t.Fatalf("failed to update %s: %s", path, err)
+	}
+	cfg, err := LoadYAMLConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	if len(cfg.Tool) != 2 || cfg.Tool["build_privilege"] != "false" || cfg.Tool["heartbeat"] != "5m" {
+		t.Fatalf("invalid tool section: %v", cfg.Tool)
+	}
+	if len(cfg.Network) != 1 || cfg.Network["ofi_ifnet"] != "ib1" {
+		t.Fatalf("invalid network section: %v", cfg.Network)
+	}
+	if len(cfg.Remote) != 1 || cfg.Remote["host"] != "login2.cluster" {
+		t.Fatalf("invalid remote section: %v", cfg.Remote)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+)
+
+// ParseBuildArgs parses the flags passed to 'singularity build', e.g., '--nv --bind /opt/license'
+func ParseBuildArgs(str string) ([]string, error) {
+	args := strings.Fields(str)
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return nil, fmt.Errorf("invalid build flags '%s': the first argument is not a flag", str)
+	}
+	return args, nil
+}
+
+// ParseBuildEnv parses a build-time environment variable, i.e., NAME=VALUE; a variable without value,
+// i.e., NAME, takes its value from the environment of the host and is ignored if it is not set
+func ParseBuildEnv(str string) (string, bool, error) {
+	tokens := strings.SplitN(str, "=", 2)
+	name := strings.TrimSpace(tokens[0])
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return "", false, fmt.Errorf("invalid build environment variable '%s'", str)
+	}
+	if len(tokens) == 2 && tokens[1] != "" {
+		return name + "=" + tokens[1], true, nil
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", false, nil
+	}
+	return name + "=" + value, true, nil
+}
+
+// getBuildEnvNames returns the names of the build-time environment variables
+func getBuildEnvNames(env []string) []string {
+	var names []string
+	for _, e := range env {
+		names = append(names, strings.SplitN(e, "=", 2)[0])
+	}
+	return names
+}
+
+// getBuildManifestData returns the base image, the build flags and the environment of a container
+// to record in the build manifest, secret values being redacted
+func getBuildManifestData(container *Config) []string {
+	var data []string
+	if container.BaseImage != "" {
+		data = append(data, "Base image: "+container.BaseImage)
+	}
+	if len(container.BuildArgs) > 0 {
+		data = append(data, "Build flags: "+strings.Join(container.BuildArgs, " "))
+	}
+	for _, e := range container.BuildEnv {
+		tokens := strings.SplitN(e, "=", 2)
+		value := ""
+		if len(tokens) == 2 {
+			value = tokens[1]
+		}
+		if syexec.IsSecretEnvVar(tokens[0], value) {
+			value = syexec.Redacted
+		}
+		data = append(data, "Build environment: "+tokens[0]+"="+value)
+	}
+	return data
+}
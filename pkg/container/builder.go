@@ -0,0 +1,34 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// builderImageSchemes are the schemes of the URLs of builder images the container runtime can
+// execute directly
+var builderImageSchemes = []string{"library://", "docker://", "oras://", "shub://"}
+
+// ValidateBuilderImage checks that the image of the builder container of the tool-in-container
+// mode is either an existing image on the host or the URL of an image in a registry
+func ValidateBuilderImage(ref string) error {
+	for _, scheme := range builderImageSchemes {
+		if strings.HasPrefix(ref, scheme) {
+			return nil
+		}
+	}
+	if strings.Contains(ref, "://") {
+		return fmt.Errorf("unsupported builder image URL %s, it should start with %s", ref, strings.Join(builderImageSchemes, ", "))
+	}
+	if !util.PathExists(ref) {
+		return fmt.Errorf("builder image %s does not exist", ref)
+	}
+	return nil
+}
@@ -14,13 +14,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/checker"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sif"
 	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
@@ -41,6 +45,44 @@ const (
 
 	// defaultExecArgs
 	defaultExecArgs = "--no-home"
+
+	// AppsLabel is the label listing the applications of a multi-app container
+	AppsLabel = "Apps"
+
+	// ThreadLevelLabel is the label specifying the thread level the application requires from MPI
+	ThreadLevelLabel = "MPI_Thread_level"
+
+	// OutputArtifactsLabel is the label listing the patterns of the output artifacts of the
+	// application, e.g., np.out,*.dat
+	OutputArtifactsLabel = "Output_artifacts"
+
+	// BenchLabel is the label specifying the settings of the benchmark pinned by the application's
+	// configuration file, e.g., iterations=1000 msg_sizes=0:22
+	BenchLabel = "Bench"
+
+	// CheckpointToolLabel is the label specifying the checkpointing tool installed in the
+	// container, e.g., dmtcp
+	CheckpointToolLabel = "Checkpoint_tool"
+
+	// TuningProfileLabel is the label specifying the tuning profile of the site copied in the
+	// container and the digest of its file, e.g., mca-params@sha256:<hex>
+	TuningProfileLabel = "MPI_Tuning_Profile"
+
+	// ISABaselineLabel is the label specifying the instruction set of the CPUs of the host where the
+	// container was built, e.g., x86-64-v3
+	ISABaselineLabel = "Build_host_ISA"
+
+	// CPUFeaturesLabel is the label listing the features of the CPUs of the host where the container
+	// was built, e.g., avx,avx2; binaries compiled with -march=native require them
+	CPUFeaturesLabel = "Build_host_CPU_features"
+
+	// BaseImageLabel is the label specifying the base image of the container, pinned to its digest
+	// when resolved at build time, e.g., ubuntu@sha256:<hex>
+	BaseImageLabel = "Base_image"
+
+	// AppExeLabelPrefix is the prefix of the labels specifying the executable of each application
+	// of a multi-app container, e.g., App_exe_netpipe
+	AppExeLabelPrefix = "App_exe_"
 )
 
 // Config is a structure representing a container
@@ -72,11 +114,48 @@ type Config struct {
 	// AppExe is the command to start the application in the container
 	AppExe string
 
+	// Apps maps the name of each application of a multi-app container to its executable
+	Apps map[string]string
+
 	// MPIDir is the directory in the container where MPI is supposed to be installed or mounted
 	MPIDir string
 
+	// ThreadLevel is the thread level the application of the container requires from MPI, e.g.,
+	// multiple; empty when the application has no specific requirement
+	ThreadLevel string
+
+	// OutputArtifacts is the list of patterns of the files produced by the application that are
+	// collected once it completes
+	OutputArtifacts []string
+
+	// Bench are the settings of the benchmark pinned when the container was created, if any
+	Bench app.Bench
+
+	// BaseImage is the base image of the container, e.g., ubuntu@sha256:<hex>; empty when unknown
+	BaseImage string
+
+	// CheckpointTool is the checkpointing tool installed in the container, e.g., dmtcp; empty
+	// when the application cannot be checkpointed
+	CheckpointTool string
+
+	// Tuning is the tuning profile of the site copied in the container and the digest of its
+	// file, e.g., mca-params@sha256:<hex> (see sys.TuningProfile.Label); empty when none
+	Tuning string
+
+	// ISABaseline is the instruction set of the CPUs of the host where the container was built, e.g.,
+	// x86-64-v3, and CPUFeatures the features of these CPUs; empty when unknown
+	ISABaseline string
+	CPUFeatures []string
+
 	// Binds is the set of bind options to use while starting the container
 	Binds []string
+
+	// BuildArgs is the list of extra flags passed to 'singularity build', e.g., --nv
+	BuildArgs []string
+
+	// BuildEnv is the environment available while building the image, e.g., a proxy used in the
+	// %post section; each element is NAME=VALUE
+	BuildEnv []string
 }
 
 // Create builds a container based on a MPI configuration
@@ -89,7 +168,7 @@ func Create(container *Config, sysCfg *sys.Config) error {
 	}
 
 	if sysCfg.SingularityBin == "" {
-		sysCfg.SingularityBin, err = exec.LookPath("singularity")
+		sysCfg.SingularityBin, err = sys.FindContainerRuntime(sysCfg.ContainerRuntime)
 		if err != nil {
 			return fmt.Errorf("singularity not available: %s", err)
 		}
@@ -110,14 +189,18 @@ func Create(container *Config, sysCfg *sys.Config) error {
 		container.Path = filepath.Join(container.InstallDir, container.Name)
 	}
 
+	// We do not overwrite images, ever
+	if util.FileExists(container.Path) {
+		return sympierr.Wrap(sympierr.ErrImageExists, nil, "%s", container.Path)
+	}
+
 	log.Printf("- Creating image %s...", container.Path)
 
-	// The definition file is ready so we simple build the container using the Singularity command
-	if sysCfg.Debug {
-		err = checker.CheckDefFile(container.DefFile)
-		if err != nil {
-			return fmt.Errorf("unable to check definition file: %s", err)
-		}
+	// The definition file is ready so we check it and simply build the container using the
+	// Singularity command; problems are reported with their line number before starting a long build
+	err = checker.CheckDefFile(container.DefFile, container.BuildDir)
+	if err != nil {
+		return err
 	}
 
 	log.Printf("-> Using definition file %s", container.DefFile)
@@ -125,24 +208,45 @@ func Create(container *Config, sysCfg *sys.Config) error {
 	var cmd syexec.SyCmd
 	singularityVersion := sy.GetVersion(sysCfg)
 	cmd.ManifestName = "build"
-	cmd.ManifestData = []string{"Singularity version: " + singularityVersion}
+	cmd.ManifestData = []string{"Container runtime: " + sys.GetContainerRuntime(sysCfg.SingularityBin), "Singularity version: " + singularityVersion}
+	cmd.ManifestData = append(cmd.ManifestData, getBuildManifestData(container)...)
 	cmd.ManifestDir = container.InstallDir
 	cmd.ManifestFileHash = []string{container.DefFile, container.Path}
 	cmd.ExecDir = container.BuildDir
-	if sysCfg.Nopriv {
+	// The build-time variables of the container override the network configuration of the tool,
+	// e.g., to use another proxy in the %post section
+	buildEnv := append(sys.GetCAEnv(sysCfg), container.BuildEnv...)
+	if len(buildEnv) > 0 {
+		cmd.Env = append(os.Environ(), buildEnv...)
+	}
+	buildArgs := append([]string{"build"}, container.BuildArgs...)
+	fakeroot, err := sy.UseFakeroot(sysCfg)
+	if err != nil {
+		return err
+	}
+	if fakeroot {
 		cmd.BinPath = sysCfg.SingularityBin
-		cmd.CmdArgs = []string{"build", "--fakeroot", container.Path, container.DefFile}
-	} else if sy.IsSudoCmd("build", sysCfg) {
+		cmd.CmdArgs = append(append(buildArgs, "--fakeroot"), container.Path, container.DefFile)
+	} else if sy.UseSudo(sy.SudoBuild, sysCfg) {
 		cmd.BinPath = sysCfg.SudoBin
 		cmd.ManifestFileHash = append(cmd.ManifestFileHash, sysCfg.SingularityBin)
-		cmd.CmdArgs = []string{sysCfg.SingularityBin, "build", container.Path, container.DefFile}
+		cmd.CmdArgs = []string{sysCfg.SingularityBin}
+		// sudo resets the environment, the network configuration and the build-time variables
+		// must be explicitly preserved
+		envNames := append(sys.GetNetworkEnvNames(sysCfg), getBuildEnvNames(container.BuildEnv)...)
+		cmd.CmdArgs = append(sy.GetPreserveEnvArgs(envNames), sysCfg.SingularityBin)
+		cmd.CmdArgs = append(append(cmd.CmdArgs, buildArgs...), container.Path, container.DefFile)
 	} else {
 		cmd.BinPath = sysCfg.SingularityBin
-		cmd.CmdArgs = []string{"build", container.Path, container.DefFile}
+		cmd.CmdArgs = append(buildArgs, container.Path, container.DefFile)
 	}
+	cmd.StreamPrefix = "[" + sys.GetContainerRuntime(sysCfg.SingularityBin) + " build]"
+	// The build of the image executes the %post section, e.g., the build of MPI and of the
+	// application, the resource limits of the builds therefore apply
+	cmd.Limited = true
 	res := cmd.Run()
 	if res.Err != nil {
-		return fmt.Errorf("failed to execute command - stdout: %s; stderr: %s; err: %s", res.Stdout, res.Stderr, res.Err)
+		return fmt.Errorf("failed to execute command - stdout: %s; stderr: %s; err: %w", res.Stdout, res.Stderr, res.Err)
 	}
 
 	// We make all SIF file executable to make it easier to integrate with other tools
@@ -169,7 +273,7 @@ func PullContainerImage(cfg *Config, mpiImplm *implem.Info, sysCfg *sys.Config,
 
 	if sysCfg.SingularityBin == "" {
 		var err error
-		sysCfg.SingularityBin, err = exec.LookPath("singularity")
+		sysCfg.SingularityBin, err = sys.FindContainerRuntime(sysCfg.ContainerRuntime)
 		if err != nil {
 			return fmt.Errorf("failed to find Singularity binary: %s", err)
 		}
@@ -220,14 +324,19 @@ func Pull(containerInfo *Config, sysCfg *sys.Config) error {
 		return nil
 	}
 
+	err = Login(containerInfo.URL, sy.SudoPull, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to log in the registry of %s: %s", containerInfo.URL, err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), sys.CmdTimeout*2*time.Minute)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, sysCfg.SingularityBin, "pull", containerInfo.Path, containerInfo.URL)
+	cmd := newSingularityCmd(ctx, sy.UseSudo(sy.SudoPull, sysCfg), sysCfg, "pull", containerInfo.Path, containerInfo.URL)
 	cmd.Dir = containerInfo.BuildDir
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err = cmd.Run()
+	err = syexec.RunCmd(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to execute command - stdout: %s; stderr: %s; err: %s", stdout.String(), stderr.String(), err)
 	}
@@ -250,12 +359,17 @@ func Sign(container *Config, sysCfg *sys.Config) error {
 	defer cancel()
 
 	indexIdx := "0"
-	if os.Getenv(KeyIndexEnvVar) != "" {
+	if sysCfg.SignKeyFingerprint != "" {
+		indexIdx, err = getKeyIndex(sysCfg.SignKeyFingerprint, sysCfg)
+		if err != nil {
+			return fmt.Errorf("failed to find key %s: %s", sysCfg.SignKeyFingerprint, err)
+		}
+	} else if os.Getenv(KeyIndexEnvVar) != "" {
 		indexIdx = os.Getenv(KeyIndexEnvVar)
 	}
 
 	var cmd *exec.Cmd
-	if sy.IsSudoCmd("sign", sysCfg) {
+	if sy.UseSudo(sy.SudoSign, sysCfg) {
 		cmd = exec.CommandContext(ctx, sysCfg.SudoBin, sysCfg.SingularityBin, "sign", "--keyidx", indexIdx, container.Path)
 	} else {
 		cmd = exec.CommandContext(ctx, sysCfg.SingularityBin, "sign", "--keyidx", indexIdx, container.Path)
@@ -277,7 +391,7 @@ func Sign(container *Config, sysCfg *sys.Config) error {
 	cmd.Dir = container.BuildDir
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err = cmd.Run()
+	err = syexec.RunCmd(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to execute command - stdout: %s; stderr: %s; err: %s", stdout.String(), stderr.String(), err)
 	}
@@ -285,29 +399,24 @@ func Sign(container *Config, sysCfg *sys.Config) error {
 	return nil
 }
 
-// Upload uploads an image to a registry
-func Upload(containerInfo *Config, sysCfg *sys.Config) error {
+// push uploads an image to a given reference in a registry, e.g., library://user/collection/app:1.0.0
+func push(containerInfo *Config, ref string, sysCfg *sys.Config) error {
 	var stdout, stderr bytes.Buffer
 
-	err := sy.CheckIntegrity(sysCfg)
+	log.Printf("-> Uploading container %s to %s", containerInfo.Path, ref)
+	err := Login(ref, sy.SudoPush, sysCfg)
 	if err != nil {
-		return fmt.Errorf("Singularity installation has been compromised: %s", err)
+		return fmt.Errorf("failed to log in the registry of %s: %s", ref, err)
 	}
 
-	log.Printf("-> Uploading container %s to %s", containerInfo.Path, sysCfg.Registry)
 	ctx, cancel := context.WithTimeout(context.Background(), sys.CmdTimeout*2*time.Minute)
 	defer cancel()
 
-	var cmd *exec.Cmd
-	if sy.IsSudoCmd("push", sysCfg) {
-		cmd = exec.CommandContext(ctx, sysCfg.SudoBin, sysCfg.SingularityBin, "push", containerInfo.Path, sysCfg.Registry)
-	} else {
-		cmd = exec.CommandContext(ctx, sysCfg.SingularityBin, "push", containerInfo.Path, sysCfg.Registry)
-	}
+	cmd := newSingularityCmd(ctx, sy.UseSudo(sy.SudoPush, sysCfg), sysCfg, "push", containerInfo.Path, ref)
 	cmd.Dir = containerInfo.BuildDir
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err = cmd.Run()
+	err = syexec.RunCmd(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to execute command - stdout: %s; stderr: %s; err: %s", stdout.String(), stderr.String(), err)
 	}
@@ -315,9 +424,14 @@ func Upload(containerInfo *Config, sysCfg *sys.Config) error {
 	return nil
 }
 
-// GetContainerDefaultName returns the default name for any container based on the configuration details
-func GetContainerDefaultName(distro string, mpiID string, mpiVersion string, appName string, model string) string {
-	return strings.Replace(distro, ":", "-", -1) + "-" + mpiID + "-" + mpiVersion + "-" + appName + "-" + model
+// Upload uploads an image to a registry
+func Upload(containerInfo *Config, sysCfg *sys.Config) error {
+	err := sy.CheckIntegrity(sysCfg)
+	if err != nil {
+		return fmt.Errorf("Singularity installation has been compromised: %s", err)
+	}
+
+	return push(containerInfo, sysCfg.Registry, sysCfg)
 }
 
 func parseInspectOutput(output string) (Config, implem.Info) {
@@ -344,16 +458,112 @@ func parseInspectOutput(output string) (Config, implem.Info) {
 		if strings.Contains(line, "MPI_Directory: ") {
 			cfg.MPIDir = strings.Replace(line, "MPI_Directory: ", "", -1)
 		}
+		if strings.Contains(line, ThreadLevelLabel+": ") {
+			cfg.ThreadLevel = strings.TrimSpace(strings.Replace(line, ThreadLevelLabel+": ", "", -1))
+		}
+		if strings.Contains(line, BaseImageLabel+": ") {
+			cfg.BaseImage = strings.TrimSpace(strings.Replace(line, BaseImageLabel+": ", "", -1))
+		}
+		if strings.Contains(line, CheckpointToolLabel+": ") {
+			cfg.CheckpointTool = strings.TrimSpace(strings.Replace(line, CheckpointToolLabel+": ", "", -1))
+		}
+		if strings.Contains(line, TuningProfileLabel+": ") {
+			cfg.Tuning = strings.TrimSpace(strings.Replace(line, TuningProfileLabel+": ", "", -1))
+		}
+		if strings.Contains(line, ISABaselineLabel+": ") {
+			cfg.ISABaseline = strings.TrimSpace(strings.Replace(line, ISABaselineLabel+": ", "", -1))
+		}
+		if strings.Contains(line, CPUFeaturesLabel+": ") {
+			features := strings.TrimSpace(strings.Replace(line, CPUFeaturesLabel+": ", "", -1))
+			if features != "" {
+				cfg.CPUFeatures = strings.Split(features, ",")
+			}
+		}
+		if strings.Contains(line, OutputArtifactsLabel+": ") {
+			cfg.OutputArtifacts = app.ParseOutputArtifacts(strings.Replace(line, OutputArtifactsLabel+": ", "", -1))
+		}
+		if strings.Contains(line, BenchLabel+": ") {
+			bench, err := app.ParseBench(strings.TrimSpace(strings.Replace(line, BenchLabel+": ", "", -1)))
+			if err != nil {
+				log.Printf("[WARN] invalid benchmark settings in the metadata: %s", err)
+			} else {
+				cfg.Bench = bench
+			}
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), AppExeLabelPrefix) {
+			tokens := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(line), AppExeLabelPrefix), ": ", 2)
+			if len(tokens) == 2 {
+				if cfg.Apps == nil {
+					cfg.Apps = make(map[string]string)
+				}
+				cfg.Apps[tokens[0]] = tokens[1]
+			}
+		}
 	}
 
 	return cfg, mpiCfg
 }
 
-// GetMetadata inspects the container's image and gathers all the available metadata
-func GetMetadata(imgPath string, sysCfg *sys.Config) (Config, implem.Info, error) {
+// GetAppNames returns the sorted names of the applications of a multi-app container
+func (c *Config) GetAppNames() []string {
+	var names []string
+	for name := range c.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SelectApp selects the application of a multi-app container to execute
+func (c *Config) SelectApp(name string) error {
+	exe, ok := c.Apps[name]
+	if !ok {
+		if len(c.Apps) == 0 {
+			return fmt.Errorf("%s is not a multi-app container", c.Path)
+		}
+		return fmt.Errorf("%s does not include application %s, available applications: %s", c.Path, name, strings.Join(c.GetAppNames(), ", "))
+	}
+	c.AppExe = exe
+	return nil
+}
+
+// getSIFMetadata reads the metadata of a container directly from its SIF image, without executing
+// singularity; false is returned when the labels are not available in the metadata of the image
+func getSIFMetadata(imgPath string) (Config, implem.Info, bool) {
 	var metadata Config
 	var mpiCfg implem.Info
 
+	if !sif.IsSIF(imgPath) {
+		return metadata, mpiCfg, false
+	}
+	img, err := sif.Load(imgPath)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+		return metadata, mpiCfg, false
+	}
+	labels, err := img.Labels()
+	if err != nil {
+		log.Printf("[WARN] failed to read the labels of %s: %s", imgPath, err)
+		return metadata, mpiCfg, false
+	}
+	if len(labels) == 0 {
+		return metadata, mpiCfg, false
+	}
+
+	metadata, mpiCfg = parseInspectOutput(sif.FormatLabels(labels))
+	metadata.Path = imgPath
+	return metadata, mpiCfg, true
+}
+
+// GetMetadata gathers all the available metadata of the container's image. The metadata is read
+// directly from SIF images when available, so no runtime is needed; otherwise the image is inspected
+// with singularity.
+func GetMetadata(imgPath string, sysCfg *sys.Config) (Config, implem.Info, error) {
+	metadata, mpiCfg, ok := getSIFMetadata(imgPath)
+	if ok {
+		return metadata, mpiCfg, nil
+	}
+
 	err := sy.CheckIntegrity(sysCfg)
 	if err != nil {
 		return metadata, mpiCfg, fmt.Errorf("Singularity installation has been compromised: %s", err)
@@ -364,7 +574,7 @@ func GetMetadata(imgPath string, sysCfg *sys.Config) (Config, implem.Info, error
 
 	var stdout, stderr bytes.Buffer
 	var cmd *exec.Cmd
-	if sy.IsSudoCmd("inspect", sysCfg) {
+	if sy.UseSudo(sy.SudoExec, sysCfg) {
 		log.Printf("Executing %s %s inspect %s\n", sysCfg.SudoBin, sysCfg.SingularityBin, imgPath)
 		cmd = exec.CommandContext(ctx, sysCfg.SudoBin, sysCfg.SingularityBin, "inspect", imgPath)
 	} else {
@@ -373,7 +583,7 @@ func GetMetadata(imgPath string, sysCfg *sys.Config) (Config, implem.Info, error
 	}
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err = cmd.Run()
+	err = syexec.RunCmd(cmd)
 	if err != nil {
 		return metadata, mpiCfg, fmt.Errorf("failed to execute command - stdout: %s; stderr: %s; err: %s", stdout.String(), stderr.String(), err)
 	}
@@ -383,8 +593,9 @@ func GetMetadata(imgPath string, sysCfg *sys.Config) (Config, implem.Info, error
 	return metadata, mpiCfg, nil
 }
 
-func getDefaultExecArgs() []string {
+func getDefaultExecArgs(sysCfg *sys.Config) []string {
 	args := []string{"exec"}
+	args = append(args, sysCfg.GetRuntimeModeArgs()...)
 	args = append(args, strings.Split(defaultExecArgs, " ")...)
 
 	return args
@@ -406,22 +617,29 @@ func getMPIBindArguments(hostMPI *implem.Info, hostBuildenv *buildenv.Info, c *C
 
 // GetMPIExecCfg figures out the singularity exec arguments to be used for executing a container
 func GetMPIExecCfg(myHostMPICfg *implem.Info, hostBuildEnv *buildenv.Info, syContainer *Config, sysCfg *sys.Config) []string {
-	args := getDefaultExecArgs()
+	args := getDefaultExecArgs(sysCfg)
 	if sysCfg.Nopriv {
 		args = append(args, "-u")
 	}
 	bindArgs := getMPIBindArguments(myHostMPICfg, hostBuildEnv, syContainer)
+	bindArgs = append(bindArgs, syContainer.Binds...)
 	if len(bindArgs) > 0 {
-		args = append(args, "--bind")
-		args = append(args, bindArgs...)
+		args = append(args, "--bind", strings.Join(bindArgs, ","))
 	}
 	log.Printf("-> Exec args to use: %s\n", strings.Join(args, " "))
 	return args
 }
 
-// GetDefaultExecCfg returns the default way to run a container
+// GetDefaultExecCfg returns the default way to run a container, in the native runtime mode.
+//
+// Deprecated: use GetRuntimeExecCfg, which honors the runtime mode of the configuration.
 func GetDefaultExecCfg() []string {
-	args := getDefaultExecArgs()
+	return GetRuntimeExecCfg(&sys.Config{})
+}
+
+// GetRuntimeExecCfg returns the default way to run a container, in the runtime mode of the configuration
+func GetRuntimeExecCfg(sysCfg *sys.Config) []string {
+	args := getDefaultExecArgs(sysCfg)
 	log.Printf("-> Exec args to use: %s\n", strings.Join(args, " "))
 	return args
 }
@@ -0,0 +1,236 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// DockerBootstrap is the bootstrap agent of the base images from Docker/OCI registries
+	DockerBootstrap = "docker"
+
+	// LibraryBootstrap is the bootstrap agent of the base images from a library
+	LibraryBootstrap = "library"
+
+	// digestPrefix is the prefix of the digests of images, the algorithm being the only one supported
+	digestPrefix = "sha256:"
+
+	// dockerHub is the host of the registry of the images without registry, e.g., ubuntu:disco
+	dockerHub = "registry-1.docker.io"
+
+	// manifestMediaTypes are the types of manifests accepted when resolving digests, manifest lists
+	// first so multi-architecture images are pinned with their list
+	manifestMediaTypes = "application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.oci.image.index.v1+json, application/vnd.docker.distribution.manifest.v2+json, application/vnd.oci.image.manifest.v1+json"
+
+	// httpTimeout is the maximum time of the requests to the registries and the library
+	httpTimeout = time.Minute
+)
+
+var (
+	// registryURL returns the base URL of the API of a registry
+	registryURL = func(host string) string { return "https://" + host }
+
+	// libraryAPI is the base URL of the API of the library
+	libraryAPI = "https://library.sylabs.io"
+)
+
+// ValidateDigest checks that a digest is valid to pin base images, e.g., sha256:<hex>
+func ValidateDigest(digest string) error {
+	hex := strings.TrimPrefix(digest, digestPrefix)
+	if !strings.HasPrefix(digest, digestPrefix) || len(hex) != 64 || strings.Trim(hex, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid digest %s, it should be %s followed by 64 hexadecimal digits", digest, digestPrefix)
+	}
+	return nil
+}
+
+// IsPinnedImage checks whether the reference of a base image already specifies a digest
+func IsPinnedImage(bootstrap string, ref string) bool {
+	if bootstrap == LibraryBootstrap {
+		return strings.Contains(ref, ":sha256.")
+	}
+	return strings.Contains(ref, "@"+digestPrefix)
+}
+
+// PinImage returns the reference of a base image pinned to a digest, e.g., ubuntu@sha256:<hex> or,
+// in a library, library://user/collection/image:sha256.<hex>
+func PinImage(bootstrap string, ref string, digest string) string {
+	hex := strings.TrimPrefix(digest, digestPrefix)
+	if bootstrap == LibraryBootstrap {
+		ref = strings.TrimPrefix(ref, "library://")
+		if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+			ref = ref[:i]
+		}
+		return "library://" + ref + ":sha256." + hex
+	}
+	ref = strings.TrimPrefix(ref, "docker://")
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref + "@" + digestPrefix + hex
+}
+
+// parseDockerRef returns the registry, the repository and the tag of the reference of an image,
+// e.g., registry-1.docker.io, library/ubuntu and disco for ubuntu:disco
+func parseDockerRef(ref string) (string, string, string) {
+	ref = strings.TrimPrefix(ref, "docker://")
+	host := dockerHub
+	tokens := strings.SplitN(ref, "/", 2)
+	if len(tokens) == 2 && (strings.ContainsAny(tokens[0], ".:") || tokens[0] == "localhost") {
+		host = tokens[0]
+		ref = tokens[1]
+	}
+	tag := "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		tag = ref[i+1:]
+		ref = ref[:i]
+	}
+	if host == dockerHub && !strings.Contains(ref, "/") {
+		ref = "library/" + ref
+	}
+	return host, ref, tag
+}
+
+// getBearerToken gets an anonymous token from the authorization server described by the
+// WWW-Authenticate header of a registry, e.g., Bearer realm="...",service="...",scope="..."
+func getBearerToken(challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported authentication: %s", challenge)
+	}
+	params := make(map[string]string)
+	for _, p := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		tokens := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(tokens) == 2 {
+			params[tokens[0]] = strings.Trim(tokens[1], "\"")
+		}
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("invalid authentication challenge: %s", challenge)
+	}
+
+	query := url.Values{}
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			query.Set(k, params[k])
+		}
+	}
+	resp, err := sys.NewHTTPClient(httpTimeout).Get(params["realm"] + "?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("authorization server returned %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %s", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// resolveDockerDigest returns the digest of the manifest of an image in a Docker/OCI registry
+func resolveDockerDigest(ref string) (string, error) {
+	host, repo, tag := parseDockerRef(ref)
+	manifestURL := registryURL(host) + "/v2/" + repo + "/manifests/" + tag
+
+	var token string
+	for {
+		req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Accept", manifestMediaTypes)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := sys.NewHTTPClient(httpTimeout).Do(req)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized && token == "" {
+			token, err = getBearerToken(resp.Header.Get("WWW-Authenticate"))
+			if err != nil {
+				return "", fmt.Errorf("failed to authenticate with %s: %s", host, err)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s returned %s", manifestURL, resp.Status)
+		}
+		digest := resp.Header.Get("Docker-Content-Digest")
+		if !strings.HasPrefix(digest, digestPrefix) {
+			return "", fmt.Errorf("%s did not return the digest of %s", host, ref)
+		}
+		return digest, nil
+	}
+}
+
+// resolveLibraryDigest returns the hash of an image in the library
+func resolveLibraryDigest(ref string) (string, error) {
+	path := strings.TrimPrefix(ref, "library://")
+	if !strings.Contains(path, ":") {
+		path += ":latest"
+	}
+	resp, err := sys.NewHTTPClient(httpTimeout).Get(libraryAPI + "/v1/images/" + path)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("library returned %s for %s", resp.Status, ref)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var image struct {
+		Data struct {
+			Hash string `json:"hash"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(data, &image)
+	if err != nil {
+		return "", fmt.Errorf("invalid description of %s: %s", ref, err)
+	}
+	if !strings.HasPrefix(image.Data.Hash, "sha256.") {
+		return "", fmt.Errorf("library did not return the hash of %s", ref)
+	}
+	return digestPrefix + strings.TrimPrefix(image.Data.Hash, "sha256."), nil
+}
+
+// ResolveDigest looks up the current digest of a base image, e.g., sha256:<hex>, in its registry
+// (bootstrap is DockerBootstrap) or in the library (bootstrap is LibraryBootstrap)
+func ResolveDigest(bootstrap string, ref string) (string, error) {
+	switch bootstrap {
+	case DockerBootstrap:
+		return resolveDockerDigest(ref)
+	case LibraryBootstrap:
+		return resolveLibraryDigest(ref)
+	default:
+		return "", fmt.Errorf("base images cannot be resolved with the %s bootstrap agent", bootstrap)
+	}
+}
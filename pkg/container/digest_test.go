@@ -0,0 +1,81 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestPinImage(t *testing.T) {
+	tests := []struct {
+		bootstrap string
+		ref       string
+		pinned    string
+	}{
+		{bootstrap: DockerBootstrap, ref: "ubuntu:disco", pinned: "ubuntu@" + testDigest},
+		{bootstrap: DockerBootstrap, ref: "localhost:5000/team/centos", pinned: "localhost:5000/team/centos@" + testDigest},
+		{bootstrap: LibraryBootstrap, ref: "library://vallee/ubuntu/19.04:latest", pinned: "library://vallee/ubuntu/19.04:sha256." + strings.TrimPrefix(testDigest, digestPrefix)},
+	}
+	for _, tt := range tests {
+		pinned := PinImage(tt.bootstrap, tt.ref, testDigest)
+		if pinned != tt.pinned || !IsPinnedImage(tt.bootstrap, pinned) || IsPinnedImage(tt.bootstrap, tt.ref) {
+			t.Fatalf("%s pinned as %s instead of %s", tt.ref, pinned, tt.pinned)
+		}
+	}
+	if ValidateDigest(testDigest) != nil || ValidateDigest("sha256:1234") == nil {
+		t.Fatalf("invalid validation of digests")
+	}
+}
+
+func TestResolveDigest(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != "repository:library/ubuntu:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"token": "anonymous"}`))
+		case r.URL.Path == "/v2/library/ubuntu/manifests/disco":
+			if r.Header.Get("Authorization") != "Bearer anonymous" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry",scope="repository:library/ubuntu:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		case r.URL.Path == "/v1/images/vallee/ubuntu/19.04:latest":
+			w.Write([]byte(`{"data": {"hash": "sha256.` + strings.TrimPrefix(testDigest, digestPrefix) + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	defaultRegistryURL, defaultLibraryAPI := registryURL, libraryAPI
+	registryURL = func(string) string { return srv.URL }
+	libraryAPI = srv.URL
+	defer func() { registryURL, libraryAPI = defaultRegistryURL, defaultLibraryAPI }()
+
+	digest, err := ResolveDigest(DockerBootstrap, host+"/library/ubuntu:disco")
+	if err != nil || digest != testDigest {
+		t.Fatalf("ResolveDigest() returned %s: %v", digest, err)
+	}
+	digest, err = ResolveDigest(LibraryBootstrap, "library://vallee/ubuntu/19.04:latest")
+	if err != nil || digest != testDigest {
+		t.Fatalf("ResolveDigest() returned %s: %v", digest, err)
+	}
+	_, err = ResolveDigest(DockerBootstrap, host+"/library/centos:7")
+	if err == nil {
+		t.Fatalf("ResolveDigest() succeeded for an unknown image")
+	}
+}
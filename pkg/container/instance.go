@@ -0,0 +1,108 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// instanceURIPrefix is the prefix used to refer to a running instance in Singularity commands
+	instanceURIPrefix = "instance://"
+)
+
+// Instance is a container started as a Singularity instance, which keeps running in the
+// background so long-running services, e.g., MPI daemons, can be used by several jobs
+type Instance struct {
+	// Name is the name of the instance
+	Name string
+
+	// Container is the container the instance runs
+	Container *Config
+}
+
+// GetURI returns the URI used to refer to the instance in Singularity commands, e.g., instance://sympi
+func (i *Instance) GetURI() string {
+	return instanceURIPrefix + i.Name
+}
+
+// getInstanceStartArgs returns the arguments of 'singularity instance start' for a container
+func getInstanceStartArgs(name string, hostMPI *implem.Info, hostBuildEnv *buildenv.Info, c *Config, sysCfg *sys.Config) []string {
+	args := []string{"instance", "start"}
+	args = append(args, strings.Split(defaultExecArgs, " ")...)
+	if sysCfg.Nopriv {
+		args = append(args, "-u")
+	}
+	bindArgs := getMPIBindArguments(hostMPI, hostBuildEnv, c)
+	bindArgs = append(bindArgs, c.Binds...)
+	if len(bindArgs) > 0 {
+		args = append(args, "--bind", strings.Join(bindArgs, ","))
+	}
+	return append(args, c.Path, name)
+}
+
+// StartInstance starts a container as a Singularity instance. With the bind model, the MPI
+// installed on the host is mounted in the instance.
+func StartInstance(name string, hostMPI *implem.Info, hostBuildEnv *buildenv.Info, c *Config, sysCfg *sys.Config) (*Instance, error) {
+	if c == nil || c.Path == "" {
+		return nil, fmt.Errorf("undefined container image")
+	}
+	if sysCfg.IsOCIMode() {
+		return nil, fmt.Errorf("instances are not supported in the %s runtime mode", sys.RuntimeModeOCI)
+	}
+
+	args := getInstanceStartArgs(name, hostMPI, hostBuildEnv, c, sysCfg)
+	log.Printf("-> Starting instance %s: %s %s\n", name, sysCfg.SingularityBin, strings.Join(args, " "))
+	_, err := runSingularityCmd(sysCfg, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start instance %s of %s: %s", name, c.Path, err)
+	}
+
+	return &Instance{Name: name, Container: c}, nil
+}
+
+// Exec executes a command in a running instance
+func (i *Instance) Exec(sysCfg *sys.Config, cmdArgs ...string) syexec.Result {
+	var res syexec.Result
+	var stdout, stderr bytes.Buffer
+
+	ctx, cancel := context.WithTimeout(context.Background(), sys.CmdTimeout*time.Minute)
+	defer cancel()
+
+	args := append([]string{"exec", i.GetURI()}, cmdArgs...)
+	cmd := exec.CommandContext(ctx, sysCfg.SingularityBin, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	res.Cmd = sysCfg.SingularityBin + " " + strings.Join(args, " ")
+	res.Err = syexec.RunCmd(cmd)
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	if ctx.Err() == context.DeadlineExceeded {
+		res.Err = fmt.Errorf("command timed out: %s", res.Cmd)
+	}
+	return res
+}
+
+// Stop stops a running instance, which terminates all the processes it runs
+func (i *Instance) Stop(sysCfg *sys.Config) error {
+	log.Printf("-> Stopping instance %s\n", i.Name)
+	_, err := runSingularityCmd(sysCfg, "instance", "stop", i.Name)
+	if err != nil {
+		return fmt.Errorf("failed to stop instance %s: %s", i.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/lockfile"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// mpiLinksFilename is the name of the file in the sympi directory linking the containers of the
+	// workspace to the MPI they require on the host
+	mpiLinksFilename = "mpi_links.json"
+
+	// mpiLinksLockSuffix is the suffix of the lock file serializing the updates of the links
+	mpiLinksLockSuffix = ".lock"
+)
+
+// MPILink links a container of the workspace to the MPI it requires on the host
+type MPILink struct {
+	// Container is the name of the container in the workspace, e.g., helloworld
+	Container string `json:"container"`
+
+	// Model is the MPI model of the container, i.e., HybridModel or BindModel
+	Model string `json:"model"`
+
+	// MPI is the implementation of MPI of the container, e.g., openmpi:4.0.2
+	MPI string `json:"mpi"`
+
+	// MinVersion is the minimum version of the implementation required on the host
+	MinVersion string `json:"min_version"`
+
+	// HostMPI is the MPI of the host the container was built against, e.g., the MPI the
+	// application of a bind-model container was compiled with; empty when unknown
+	HostMPI string `json:"host_mpi,omitempty"`
+
+	// Date is the date the link was recorded
+	Date string `json:"date"`
+}
+
+// GetID returns the identifier of the implementation of MPI of the container, e.g., openmpi
+func (l *MPILink) GetID() string {
+	return strings.SplitN(l.MPI, ":", 2)[0]
+}
+
+// Accepts checks whether an installation of MPI on the host, e.g., openmpi:4.0.2, satisfies the
+// requirements of the container
+func (l *MPILink) Accepts(hostMPI string) bool {
+	tokens := strings.SplitN(hostMPI, ":", 2)
+	if len(tokens) != 2 || tokens[0] != l.GetID() {
+		return false
+	}
+	return l.MinVersion == "" || implem.CompareVersions(tokens[1], l.MinVersion) >= 0
+}
+
+// SelectHostMPI selects, among the installations of MPI on the host, e.g., openmpi:4.0.2, the one
+// to execute the container with: the MPI it was built against when installed, the oldest version
+// satisfying its requirements otherwise, i.e., the closest to the MPI of the container
+func (l *MPILink) SelectHostMPI(installs []string) (string, error) {
+	selected := ""
+	for _, i := range installs {
+		if i == l.HostMPI {
+			return i, nil
+		}
+		if !l.Accepts(i) {
+			continue
+		}
+		if selected == "" || implem.CompareVersions(strings.SplitN(i, ":", 2)[1], strings.SplitN(selected, ":", 2)[1]) < 0 {
+			selected = i
+		}
+	}
+	if selected == "" {
+		return "", fmt.Errorf("no installation of %s >= %s on the host", l.GetID(), l.MinVersion)
+	}
+	return selected, nil
+}
+
+// NewMPILink returns the link of a container of the workspace to the MPI it requires on the host:
+// the same implementation, at least the version of the container, and for the bind model the MPI
+// the application was compiled with
+func NewMPILink(name string, c *Config, mpiCfg *implem.Info) MPILink {
+	link := MPILink{
+		Container:  name,
+		Model:      c.Model,
+		MPI:        mpiCfg.ID + ":" + mpiCfg.Version,
+		MinVersion: mpiCfg.Version,
+		Date:       time.Now().Format(time.RFC3339),
+	}
+	if c.Model == BindModel {
+		link.HostMPI = link.MPI
+	}
+	return link
+}
+
+// getMPILinksFile returns the path to the file linking the containers to the MPI of the host
+func getMPILinksFile() string {
+	return filepath.Join(sys.GetSympiDir(), mpiLinksFilename)
+}
+
+// LoadMPILinks reads the links of the containers of the workspace to the MPI of the host
+func LoadMPILinks() ([]MPILink, error) {
+	var links []MPILink
+	path := getMPILinksFile()
+	if !util.FileExists(path) {
+		return links, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	err = json.Unmarshal(content, &links)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return links, nil
+}
+
+// saveMPILinks writes the links of the containers of the workspace to the MPI of the host; the
+// links are written in a temporary file then renamed so they are never read partially written
+func saveMPILinks(links []MPILink) error {
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].Container < links[j].Container
+	})
+	content, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the links of the containers to MPI: %s", err)
+	}
+	path := getMPILinksFile()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %s", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Temporary files are only readable by their owner
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = tmp.Write(content)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", tmp.Name(), err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %s", tmp.Name(), path, err)
+	}
+	return nil
+}
+
+// updateMPILinks updates the links of the containers of the workspace to the MPI of the host
+// while holding the lock of the links, so concurrent updates, e.g., two containers created at the
+// same time, are not lost; update returns the new links and whether they changed
+func updateMPILinks(update func([]MPILink) ([]MPILink, bool)) error {
+	path := getMPILinksFile()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+	lock, err := lockfile.Lock(path+mpiLinksLockSuffix, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := lockfile.Unlock(lock)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
+		}
+	}()
+
+	links, err := LoadMPILinks()
+	if err != nil {
+		return err
+	}
+	updated, changed := update(links)
+	if !changed {
+		return nil
+	}
+	return saveMPILinks(updated)
+}
+
+// FindMPILink returns the link of a container among the links of the workspace
+func FindMPILink(links []MPILink, name string) (MPILink, bool) {
+	for _, l := range links {
+		if l.Container == name {
+			return l, true
+		}
+	}
+	return MPILink{}, false
+}
+
+// RecordMPILink adds the link of a container to the MPI of the host, replacing the previous link
+// of the container if any
+func RecordMPILink(link MPILink) error {
+	return updateMPILinks(func(links []MPILink) ([]MPILink, bool) {
+		var updated []MPILink
+		for _, l := range links {
+			if l.Container != link.Container {
+				updated = append(updated, l)
+			}
+		}
+		return append(updated, link), true
+	})
+}
+
+// RemoveMPILink removes the link of a container to the MPI of the host, e.g., when the container
+// is deleted; nothing is done when the container has no link
+func RemoveMPILink(name string) error {
+	return updateMPILinks(func(links []MPILink) ([]MPILink, bool) {
+		var updated []MPILink
+		for _, l := range links {
+			if l.Container != name {
+				updated = append(updated, l)
+			}
+		}
+		return updated, len(updated) != len(links)
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestMPILinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-mpilinks-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	bind := NewMPILink("bind-app", &Config{Model: BindModel}, &implem.Info{ID: implem.OMPI, Version: "4.0.2"})
+	hybrid := NewMPILink("hybrid-app", &Config{Model: HybridModel}, &implem.Info{ID: implem.OMPI, Version: "3.1.4"})
+
+	tests := []struct {
+		link     MPILink
+		installs []string
+		expected string
+	}{
+		// The MPI the container was built against is preferred
+		{link: bind, installs: []string{"openmpi:4.0.3", "openmpi:4.0.2"}, expected: "openmpi:4.0.2"},
+		{link: bind, installs: []string{"mpich:3.3.2", "openmpi:4.1.0", "openmpi:4.0.3"}, expected: "openmpi:4.0.3"},
+		{link: bind, installs: []string{"openmpi:3.1.4", "mpich:4.0.2"}, expected: ""},
+		{link: hybrid, installs: []string{"openmpi:4.0.2", "openmpi:3.1.4"}, expected: "openmpi:3.1.4"},
+		{link: hybrid, installs: nil, expected: ""},
+	}
+	for _, tt := range tests {
+		selected, err := tt.link.SelectHostMPI(tt.installs)
+		if tt.expected == "" {
+			if err == nil {
+				t.Fatalf("%s selected for %s instead of failing", selected, tt.link.Container)
+			}
+			continue
+		}
+		if err != nil || selected != tt.expected {
+			t.Fatalf("%s selected for %s among %v instead of %s (err: %v)", selected, tt.link.Container, tt.installs, tt.expected, err)
+		}
+	}
+
+	for _, l := range []MPILink{bind, hybrid, bind} {
+		err = RecordMPILink(l)
+		if err != nil {
+			t.Fatalf("failed to record the link of %s: %s", l.Container, err)
+		}
+	}
+	links, err := LoadMPILinks()
+	if err != nil {
+		t.Fatalf("failed to load the links: %s", err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("%d links recorded instead of 2: %v", len(links), links)
+	}
+	l, ok := FindMPILink(links, "bind-app")
+	if !ok || l.HostMPI != "openmpi:4.0.2" || l.MinVersion != "4.0.2" {
+		t.Fatalf("invalid link for bind-app: %+v", l)
+	}
+
+	err = RemoveMPILink("bind-app")
+	if err != nil {
+		t.Fatalf("failed to remove the link of bind-app: %s", err)
+	}
+	links, err = LoadMPILinks()
+	if err != nil {
+		t.Fatalf("failed to load the links: %s", err)
+	}
+	if _, ok := FindMPILink(links, "bind-app"); ok || len(links) != 1 {
+		t.Fatalf("link of bind-app still recorded: %v", links)
+	}
+
+	// Concurrent updates are serialized and no temporary or lock file is left behind
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := RecordMPILink(NewMPILink(fmt.Sprintf("app-%d", i), &Config{Model: HybridModel}, &implem.Info{ID: implem.OMPI, Version: "4.0.2"}))
+			if err != nil {
+				t.Errorf("failed to record the link of app-%d: %s", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	links, err = LoadMPILinks()
+	if err != nil || len(links) != 11 {
+		t.Fatalf("%d links recorded instead of 11 (err: %v)", len(links), err)
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(getMPILinksFile()))
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", filepath.Dir(getMPILinksFile()), err)
+	}
+	for _, e := range entries {
+		if e.Name() != mpiLinksFilename {
+			t.Fatalf("%s left behind by the updates of the links", e.Name())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// DistroTag is the tag of a naming template replaced by the Linux distribution, e.g., ubuntu-disco
+	DistroTag = "{distro}"
+
+	// MPITag is the tag of a naming template replaced by the identifier of the MPI implementation
+	MPITag = "{mpi}"
+
+	// VersionTag is the tag of a naming template replaced by the version of the MPI implementation
+	VersionTag = "{version}"
+
+	// AppTag is the tag of a naming template replaced by the name of the application
+	AppTag = "{app}"
+
+	// ModelTag is the tag of a naming template replaced by the MPI model, e.g., hybrid
+	ModelTag = "{model}"
+
+	// DateTag is the tag of a naming template replaced by the date, e.g., 20191105
+	DateTag = "{date}"
+
+	// imageExt is the extension of image files
+	imageExt = ".sif"
+)
+
+var nameTagRegex = regexp.MustCompile(`{[^}]*}`)
+
+// NameInfo gathers all the values used to generate the name of a container from a template
+type NameInfo struct {
+	// Distro is the Linux distribution of the container, e.g., ubuntu:disco
+	Distro string
+
+	// MPIID is the identifier of the MPI implementation in the container
+	MPIID string
+
+	// MPIVersion is the version of the MPI implementation in the container
+	MPIVersion string
+
+	// AppName is the name of the application in the container
+	AppName string
+
+	// Model is the MPI model of the container
+	Model string
+
+	// Date is the date of the creation of the container
+	Date time.Time
+}
+
+// ValidateNameTemplate checks whether a naming template only uses known tags and does not
+// include any directory
+func ValidateNameTemplate(tmpl string) error {
+	if strings.TrimSpace(tmpl) == "" {
+		return fmt.Errorf("empty naming template")
+	}
+	if strings.Contains(tmpl, string(filepath.Separator)) {
+		return fmt.Errorf("naming template '%s' includes a directory", tmpl)
+	}
+	for _, tag := range nameTagRegex.FindAllString(tmpl, -1) {
+		switch tag {
+		case DistroTag, MPITag, VersionTag, AppTag, ModelTag, DateTag:
+		default:
+			return fmt.Errorf("unknown tag %s in naming template '%s'", tag, tmpl)
+		}
+	}
+	return nil
+}
+
+// ExpandNameTemplate generates the name of the image of a container from a template, e.g.,
+// "{app}-{mpi}-{version}-{date}"; the .sif extension is added if the template does not include it
+func ExpandNameTemplate(tmpl string, info *NameInfo) (string, error) {
+	err := ValidateNameTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	r := strings.NewReplacer(
+		DistroTag, strings.Replace(info.Distro, ":", "-", -1),
+		MPITag, info.MPIID,
+		VersionTag, info.MPIVersion,
+		AppTag, info.AppName,
+		ModelTag, info.Model,
+		DateTag, info.Date.Format("20060102"),
+	)
+	name := r.Replace(tmpl)
+	if filepath.Ext(name) != imageExt {
+		name += imageExt
+	}
+	return name, nil
+}
+
+// GetContainerDefaultName returns the default name for any container based on the configuration details
+func GetContainerDefaultName(distro string, mpiID string, mpiVersion string, appName string, model string) string {
+	return strings.Replace(distro, ":", "-", -1) + "-" + mpiID + "-" + mpiVersion + "-" + appName + "-" + model
+}
+
+// ValidateMPIPrefix checks whether the directory where MPI is installed in containers is an
+// absolute path only using the {mpi} and {version} tags, e.g., /opt/{mpi}-{version}
+func ValidateMPIPrefix(prefix string) error {
+	if !filepath.IsAbs(prefix) {
+		return fmt.Errorf("MPI prefix '%s' is not an absolute path", prefix)
+	}
+	for _, tag := range nameTagRegex.FindAllString(prefix, -1) {
+		if tag != MPITag && tag != VersionTag {
+			return fmt.Errorf("unknown tag %s in MPI prefix '%s'", tag, prefix)
+		}
+	}
+	return nil
+}
+
+// ExpandMPIPrefix returns the directory where a MPI implementation is installed in containers
+// from a prefix, e.g., /opt/openmpi-4.0.2 for /opt/{mpi}-{version}
+func ExpandMPIPrefix(prefix string, mpiID string, mpiVersion string) string {
+	r := strings.NewReplacer(MPITag, mpiID, VersionTag, mpiVersion)
+	return filepath.Clean(r.Replace(prefix))
+}
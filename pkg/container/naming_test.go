@@ -0,0 +1,100 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+func TestExpandNameTemplate(t *testing.T) {
+	info := NameInfo{
+		Distro:     "ubuntu:disco",
+		MPIID:      implem.OMPI,
+		MPIVersion: "4.0.2",
+		AppName:    "helloworld",
+		Model:      HybridModel,
+		Date:       time.Date(2019, time.November, 5, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name         string
+		tmpl         string
+		expectedName string
+		expectedErr  bool
+	}{
+		{
+			name:         "all tags",
+			tmpl:         "{app}_{distro}_{mpi}-{version}_{model}_{date}",
+			expectedName: "helloworld_ubuntu-disco_openmpi-4.0.2_hybrid_20191105.sif",
+		},
+		{
+			name:         "with extension",
+			tmpl:         "{app}-{version}.sif",
+			expectedName: "helloworld-4.0.2.sif",
+		},
+		{
+			name:        "unknown tag",
+			tmpl:        "{app}-{arch}",
+			expectedErr: true,
+		},
+		{
+			name:        "directory",
+			tmpl:        "images/{app}",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := ExpandNameTemplate(tt.tmpl, &info)
+			if tt.expectedErr {
+				if err == nil {
+					t.Fatalf("ExpandNameTemplate() succeeded with invalid template %s", tt.tmpl)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandNameTemplate() failed: %s", err)
+			}
+			if name != tt.expectedName {
+				t.Fatalf("ExpandNameTemplate() returned %s instead of %s", name, tt.expectedName)
+			}
+		})
+	}
+}
+
+func TestExpandMPIPrefix(t *testing.T) {
+	tests := []struct {
+		prefix      string
+		expected    string
+		expectedErr bool
+	}{
+		{prefix: "/usr/local", expected: "/usr/local"},
+		{prefix: "/opt/{mpi}-{version}/", expected: "/opt/openmpi-4.0.2"},
+		{prefix: "opt/{mpi}", expectedErr: true},
+		{prefix: "/opt/{app}", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateMPIPrefix(tt.prefix)
+		if tt.expectedErr {
+			if err == nil {
+				t.Fatalf("ValidateMPIPrefix() succeeded with invalid prefix %s", tt.prefix)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ValidateMPIPrefix() failed with %s: %s", tt.prefix, err)
+		}
+		dir := ExpandMPIPrefix(tt.prefix, implem.OMPI, "4.0.2")
+		if dir != tt.expected {
+			t.Fatalf("ExpandMPIPrefix() returned %s instead of %s", dir, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// libraryScheme is the scheme of the references of images in a library, which uses tokens
+	// instead of user names and passwords
+	libraryScheme = "library://"
+)
+
+// loggedIn tracks the registries the tool already logged in, so it only logs in once per registry
+var loggedIn = struct {
+	sync.Mutex
+	registries map[string]bool
+}{registries: make(map[string]bool)}
+
+// getRegistryURI returns the URI used to log in a registry from its URL, i.e., the scheme and the
+// host, e.g., oras://ghcr.io for oras://ghcr.io/user
+func getRegistryURI(url string) string {
+	tokens := strings.SplitN(url, "://", 2)
+	if len(tokens) != 2 {
+		return strings.SplitN(url, "/", 2)[0]
+	}
+	return tokens[0] + "://" + strings.SplitN(tokens[1], "/", 2)[0]
+}
+
+// getLoginArgs returns the arguments of the container runtime to log in a registry; the token is
+// either read from a file (tokenFile), with libraries, or from stdin. Libraries are logged in
+// through their remote endpoint, the default one when the registry does not name it.
+func getLoginArgs(r *sys.Registry, username string, tokenFile string) ([]string, error) {
+	if strings.HasPrefix(r.URL, libraryScheme) {
+		args := []string{"remote", "login", "--tokenfile", tokenFile}
+		if r.Remote != "" {
+			args = append(args, r.Remote)
+		}
+		return args, nil
+	}
+	if username == "" {
+		return nil, fmt.Errorf("the user of registry %s is not defined", r.Name)
+	}
+	return []string{"remote", "login", "--username", username, "--password-stdin", getRegistryURI(r.URL)}, nil
+}
+
+// newSingularityCmd returns the command executing Singularity to reach a registry, with sudo when
+// withSudo is set. The CA bundle of the configuration is set in its environment and, with sudo,
+// the network configuration is preserved.
+func newSingularityCmd(ctx context.Context, withSudo bool, sysCfg *sys.Config, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if withSudo {
+		sudoArgs := sy.GetPreserveEnvArgs(sys.GetNetworkEnvNames(sysCfg))
+		cmd = exec.CommandContext(ctx, sysCfg.SudoBin, append(append(sudoArgs, sysCfg.SingularityBin), args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, sysCfg.SingularityBin, args...)
+	}
+	caEnv := sys.GetCAEnv(sysCfg)
+	if len(caEnv) > 0 {
+		cmd.Env = append(os.Environ(), caEnv...)
+	}
+	return cmd
+}
+
+// Login logs in the registry of an image reference, e.g., before pushing or pulling it, using the
+// credentials of the tool's configuration file. Nothing is done if the reference is not in a
+// registry of the configuration file or if no credentials are configured for its registry, in
+// which case the authentication already configured with the container runtime is used. op is the
+// operation executed once logged in, e.g., sy.SudoPush, so the login is done with sudo if needed.
+func Login(ref string, op string, sysCfg *sys.Config) error {
+	r := sys.FindRegistry(sysCfg.Registries, ref)
+	if r == nil || !r.HasCredentials() {
+		return nil
+	}
+
+	withSudo := sy.UseSudo(op, sysCfg)
+	id := r.Name
+	if withSudo {
+		id += " (sudo)"
+	}
+	loggedIn.Lock()
+	defer loggedIn.Unlock()
+	if loggedIn.registries[id] {
+		return nil
+	}
+
+	username, token, err := r.GetCredentials()
+	if err != nil {
+		return err
+	}
+
+	// The token is never passed on the command line, where it would be visible and recorded
+	var tokenFile string
+	if strings.HasPrefix(r.URL, libraryScheme) {
+		f, err := ioutil.TempFile("", "sympi-token-")
+		if err != nil {
+			return fmt.Errorf("failed to create token file: %s", err)
+		}
+		tokenFile = f.Name()
+		defer os.Remove(tokenFile)
+		_, err = f.WriteString(token)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write token file: %s", err)
+		}
+	}
+	args, err := getLoginArgs(r, username, tokenFile)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("-> Logging in registry %s (%s)", r.Name, r.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), sys.CmdTimeout*time.Minute)
+	defer cancel()
+	cmd := newSingularityCmd(ctx, withSudo, sysCfg, args...)
+	if tokenFile == "" {
+		cmd.Stdin = strings.NewReader(token)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = syexec.RunCmd(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to log in registry %s - stdout: %s; stderr: %s; err: %s", r.Name, stdout.String(), stderr.String(), err)
+	}
+
+	loggedIn.registries[id] = true
+	return nil
+}
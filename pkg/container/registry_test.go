@@ -0,0 +1,37 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestGetLoginArgs(t *testing.T) {
+	tests := []struct {
+		registry sys.Registry
+		username string
+		args     string
+	}{
+		{registry: sys.Registry{Name: "ghcr", URL: "oras://ghcr.io/user"}, username: "user", args: "remote login --username user --password-stdin oras://ghcr.io"},
+		{registry: sys.Registry{Name: "library", URL: "library://user/collection"}, args: "remote login --tokenfile /tmp/token"},
+		{registry: sys.Registry{Name: "library", URL: "library://user/collection", Remote: "SylabsCloud"}, args: "remote login --tokenfile /tmp/token SylabsCloud"},
+		{registry: sys.Registry{Name: "docker", URL: "docker://registry.example.com:5000/team"}, username: "user", args: "remote login --username user --password-stdin docker://registry.example.com:5000"},
+	}
+	for _, tt := range tests {
+		args, err := getLoginArgs(&tt.registry, tt.username, "/tmp/token")
+		if err != nil || strings.Join(args, " ") != tt.args {
+			t.Fatalf("getLoginArgs() returned '%s' instead of '%s' for %s: %v", strings.Join(args, " "), tt.args, tt.registry.Name, err)
+		}
+	}
+
+	_, err := getLoginArgs(&tests[0].registry, "", "")
+	if err == nil {
+		t.Fatalf("getLoginArgs() succeeded without user")
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// VerifyRequireSigned is the verification policy where images that cannot be verified are not executed
+	VerifyRequireSigned = "require-signed"
+
+	// VerifyWarn is the verification policy where a warning is displayed when an image cannot be verified
+	VerifyWarn = "warn"
+
+	// VerifyIgnore is the verification policy where the signature of images is not checked
+	VerifyIgnore = "ignore"
+
+	// DefaultVerifyPolicy is the verification policy used when none is specified
+	DefaultVerifyPolicy = VerifyWarn
+
+	// verifyCacheFilename is the name of the file of the workspace caching the result of the
+	// verification of images with the warn policy
+	verifyCacheFilename = "verify_cache.json"
+)
+
+// verifyRecord is the cached result of the verification of an image
+type verifyRecord struct {
+	// Error is the reason why the verification failed, empty when the image was verified
+	Error string `json:"error,omitempty"`
+
+	// Date is when the image was verified
+	Date string `json:"date"`
+}
+
+// keyIndexRegex matches the first line of a key from the output of 'singularity key list --secret', e.g., "0) U: ..."
+var keyIndexRegex = regexp.MustCompile(`^([0-9]+)\) U:`)
+
+// normalizeFingerprint returns a fingerprint without spaces and in upper case so fingerprints can be compared
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+}
+
+// ValidateVerifyPolicy checks whether a verification policy is valid
+func ValidateVerifyPolicy(policy string) error {
+	switch policy {
+	case VerifyRequireSigned, VerifyWarn, VerifyIgnore:
+		return nil
+	}
+	return fmt.Errorf("invalid verification policy '%s' (must be %s, %s or %s)", policy, VerifyRequireSigned, VerifyWarn, VerifyIgnore)
+}
+
+// parseKeyList parses the output of 'singularity key list --secret' and returns the index of the key
+// with a given fingerprint
+func parseKeyList(output string, fingerprint string) (string, error) {
+	idx := ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		m := keyIndexRegex.FindStringSubmatch(line)
+		if len(m) == 2 {
+			idx = m[1]
+			continue
+		}
+		if strings.HasPrefix(line, "F:") && idx != "" && normalizeFingerprint(strings.TrimPrefix(line, "F:")) == normalizeFingerprint(fingerprint) {
+			return idx, nil
+		}
+	}
+	return "", fmt.Errorf("no private key with fingerprint %s", fingerprint)
+}
+
+// isSignedBy checks whether the output of 'singularity verify' includes a given fingerprint
+func isSignedBy(output string, fingerprint string) bool {
+	return strings.Contains(normalizeFingerprint(output), normalizeFingerprint(fingerprint))
+}
+
+// runSingularityCmd executes a Singularity command and returns its output
+func runSingularityCmd(sysCfg *sys.Config, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	ctx, cancel := context.WithTimeout(context.Background(), sys.CmdTimeout*time.Minute)
+	defer cancel()
+
+	cmd := newSingularityCmd(ctx, sy.UseSudo(sy.GetSudoOperation(args[0]), sysCfg), sysCfg, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute command - stdout: %s; stderr: %s; err: %s", stdout.String(), stderr.String(), err)
+	}
+
+	return stdout.String() + stderr.String(), nil
+}
+
+// getKeyIndex returns the index of the private key with a given fingerprint in the local keyring
+func getKeyIndex(fingerprint string, sysCfg *sys.Config) (string, error) {
+	output, err := runSingularityCmd(sysCfg, "key", "list", "--secret")
+	if err != nil {
+		return "", fmt.Errorf("failed to list private keys: %s", err)
+	}
+	return parseKeyList(output, fingerprint)
+}
+
+// Verify checks the signature of an image. When sysCfg.SignKeyFingerprint is set, the image must
+// be signed with that key.
+func Verify(imgPath string, sysCfg *sys.Config) error {
+	// Check integrity of the installation of Singularity
+	err := sy.CheckIntegrity(sysCfg)
+	if err != nil {
+		return fmt.Errorf("Singularity installation has been compromised: %s", err)
+	}
+
+	log.Printf("-> Verifying container (%s)", imgPath)
+	output, err := runSingularityCmd(sysCfg, "verify", imgPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %s", imgPath, err)
+	}
+
+	if sysCfg.SignKeyFingerprint != "" && !isSignedBy(output, sysCfg.SignKeyFingerprint) {
+		return fmt.Errorf("%s is not signed with key %s", imgPath, sysCfg.SignKeyFingerprint)
+	}
+
+	return nil
+}
+
+// getVerifyCacheFile returns the path to the file caching the result of the verification of images
+func getVerifyCacheFile() string {
+	return filepath.Join(sys.GetSympiDir(), verifyCacheFilename)
+}
+
+// getVerifyCacheKey returns the key of the cached verification of an image, i.e., the digest of the
+// image and the fingerprint the image must be signed with, if any
+func getVerifyCacheKey(imgPath string, fingerprint string) (string, error) {
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %s", imgPath, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", imgPath, err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)) + "/" + normalizeFingerprint(fingerprint), nil
+}
+
+// loadVerifyCache reads the cached verification results, an empty cache being returned when the
+// file does not exist or cannot be parsed
+func loadVerifyCache() map[string]verifyRecord {
+	cache := make(map[string]verifyRecord)
+	content, err := ioutil.ReadFile(getVerifyCacheFile())
+	if err != nil {
+		return cache
+	}
+	err = json.Unmarshal(content, &cache)
+	if err != nil {
+		log.Printf("[WARN] ignoring invalid verification cache %s: %s", getVerifyCacheFile(), err)
+		return make(map[string]verifyRecord)
+	}
+	return cache
+}
+
+// saveVerifyCache writes the cached verification results through a temporary file so concurrent
+// readers never see a partial file
+func saveVerifyCache(cache map[string]verifyRecord) error {
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the verification cache: %s", err)
+	}
+	path := getVerifyCacheFile()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", tmpPath, err)
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename %s: %s", tmpPath, err)
+	}
+	return nil
+}
+
+// verifyCached verifies an image unless the result of the verification of the same image, with
+// the same key, is cached; the result of the verification is cached
+func verifyCached(imgPath string, sysCfg *sys.Config) error {
+	key, err := getVerifyCacheKey(imgPath, sysCfg.SignKeyFingerprint)
+	if err != nil {
+		log.Printf("[WARN] unable to cache the verification of %s: %s", imgPath, err)
+		return Verify(imgPath, sysCfg)
+	}
+
+	cache := loadVerifyCache()
+	if r, ok := cache[key]; ok {
+		log.Printf("-> Using the verification of %s from %s", imgPath, r.Date)
+		if r.Error != "" {
+			return fmt.Errorf("%s", r.Error)
+		}
+		return nil
+	}
+
+	verr := Verify(imgPath, sysCfg)
+	r := verifyRecord{Date: time.Now().Format(time.RFC3339)}
+	if verr != nil {
+		r.Error = verr.Error()
+	}
+	cache[key] = r
+	err = saveVerifyCache(cache)
+	if err != nil {
+		log.Printf("[WARN] unable to cache the verification of %s: %s", imgPath, err)
+	}
+	return verr
+}
+
+// CheckSignature applies the verification policy of the system configuration to an image and
+// returns an error if the image must not be executed. With the warn policy, the result of the
+// verification is cached by digest of the image so an image is only verified once; images are
+// always verified with the require-signed policy.
+func CheckSignature(imgPath string, sysCfg *sys.Config) error {
+	policy := sysCfg.VerifyPolicy
+	if policy == "" {
+		policy = DefaultVerifyPolicy
+	}
+
+	if policy == VerifyIgnore {
+		return nil
+	}
+
+	if policy == VerifyRequireSigned {
+		return Verify(imgPath, sysCfg)
+	}
+
+	err := verifyCached(imgPath, sysCfg)
+	if err != nil {
+		fmt.Printf("[WARN] unable to verify the signature of %s: %s\n", imgPath, err)
+	}
+	return nil
+}
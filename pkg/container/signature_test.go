@@ -0,0 +1,151 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const keyListOutput = `Private key listing (/home/user/.singularity/sypgp/pgp-secret):
+
+0) U: First User (test) <first@example.com>
+   C: 2019-11-05 10:01:02 -0800 PST
+   F: 8883491F4268F173C6E5DC49EDECE4F3F38D871E
+   L: 4096
+   --------
+1) U: Second User (test) <second@example.com>
+   C: 2019-11-06 10:01:02 -0800 PST
+   F: 12F8D1AC4F0B3D7E6A7C1D5E2B9A0F4E3C2D1B0A
+   L: 4096
+   --------
+`
+
+func TestParseKeyList(t *testing.T) {
+	tests := []struct {
+		name          string
+		fingerprint   string
+		expectedIndex string
+		expectedErr   bool
+	}{
+		{
+			name:          "first key",
+			fingerprint:   "8883491F4268F173C6E5DC49EDECE4F3F38D871E",
+			expectedIndex: "0",
+		},
+		{
+			name:          "lower case",
+			fingerprint:   "12f8d1ac4f0b3d7e6a7c1d5e2b9a0f4e3c2d1b0a",
+			expectedIndex: "1",
+		},
+		{
+			name:        "unknown key",
+			fingerprint: "0000000000000000000000000000000000000000",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := parseKeyList(keyListOutput, tt.fingerprint)
+			if tt.expectedErr {
+				if err == nil {
+					t.Fatalf("parseKeyList() succeeded with an unknown key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKeyList() failed: %s", err)
+			}
+			if idx != tt.expectedIndex {
+				t.Fatalf("parseKeyList() returned %s instead of %s", idx, tt.expectedIndex)
+			}
+		})
+	}
+}
+
+func TestValidateVerifyPolicy(t *testing.T) {
+	for _, policy := range []string{VerifyRequireSigned, VerifyWarn, VerifyIgnore} {
+		if ValidateVerifyPolicy(policy) != nil {
+			t.Fatalf("%s is reported as invalid", policy)
+		}
+	}
+	if ValidateVerifyPolicy("always") == nil {
+		t.Fatalf("invalid policy is reported as valid")
+	}
+}
+
+func TestCheckSignatureCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-verify-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	img := filepath.Join(dir, "app.sif")
+	err = ioutil.WriteFile(img, []byte("image"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", img, err)
+	}
+
+	fake := syexec.NewFakeRunner()
+	fake.On("verify", syexec.FakeResult{Stderr: "no signature found", ExitCode: 255})
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	countVerify := func() int {
+		n := 0
+		for _, c := range fake.CmdLines() {
+			if strings.HasPrefix(c, "singularity verify ") {
+				n++
+			}
+		}
+		return n
+	}
+
+	sysCfg := &sys.Config{SingularityBin: filepath.Join(dir, "bin", "singularity"), VerifyPolicy: VerifyWarn}
+	for i := 0; i < 2; i++ {
+		err = CheckSignature(img, sysCfg)
+		if err != nil {
+			t.Fatalf("CheckSignature() failed with the warn policy: %s", err)
+		}
+	}
+	if countVerify() != 1 {
+		t.Fatalf("the image was verified %d times instead of once", countVerify())
+	}
+
+	// A new image, i.e., a new digest, is verified again
+	err = ioutil.WriteFile(img, []byte("rebuilt image"), 0644)
+	if err != nil {
+		t.Fatalf("failed to update %s: %s", img, err)
+	}
+	err = CheckSignature(img, sysCfg)
+	if err != nil {
+		t.Fatalf("CheckSignature() failed with the warn policy: %s", err)
+	}
+	if countVerify() != 2 {
+		t.Fatalf("the rebuilt image was not verified")
+	}
+
+	// Images are always verified with the require-signed policy
+	sysCfg.VerifyPolicy = VerifyRequireSigned
+	err = CheckSignature(img, sysCfg)
+	if err == nil {
+		t.Fatalf("CheckSignature() succeeded with an unsigned image and the require-signed policy")
+	}
+	if countVerify() != 3 {
+		t.Fatalf("the image was not verified with the require-signed policy")
+	}
+}
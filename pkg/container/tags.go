@@ -0,0 +1,350 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// TagSemver is the tag policy using the version of the application from its configuration file, e.g., 1.2.0
+	TagSemver = "semver"
+
+	// TagGit is the tag policy using the output of 'git describe' in the source directory of the application, e.g., v1.2.0-3-g1a2b3c4
+	TagGit = "git"
+
+	// TagDate is the tag policy using the date of the build, e.g., 20191203
+	TagDate = "date"
+
+	// TagLatest is the tag policy moving the 'latest' tag to the image
+	TagLatest = "latest"
+
+	// DefaultTagPolicy is the tag policy used when none is specified
+	DefaultTagPolicy = TagDate
+
+	// uploadsFilename is the name of the file in the sympi directory recording the tags that were uploaded
+	uploadsFilename = "uploads.json"
+)
+
+var semverRegexp = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?$`)
+
+// invalidTagChars matches the characters that cannot be used in a tag
+var invalidTagChars = regexp.MustCompile(`[^0-9A-Za-z_.-]`)
+
+// TagInfo gathers the data used to generate the tags of an image
+type TagInfo struct {
+	// Version is the version of the application, e.g., 1.2.0
+	Version string
+
+	// SrcDir is the local source directory of the application, used with the git tag policy
+	SrcDir string
+
+	// Date is the date of the build
+	Date time.Time
+}
+
+// UploadedTag describes a tag uploaded to a registry
+type UploadedTag struct {
+	// Repo is the image repository, e.g., library://user/collection/app
+	Repo string `json:"repo"`
+
+	// Tag is the tag of the image, e.g., 1.2.0
+	Tag string `json:"tag"`
+
+	// Path is the path to the local image that was uploaded
+	Path string `json:"path"`
+
+	// Date is the date of the upload
+	Date string `json:"date"`
+}
+
+// Ref returns the full reference of an uploaded tag, e.g., library://user/collection/app:1.2.0
+func (t *UploadedTag) Ref() string {
+	return t.Repo + ":" + t.Tag
+}
+
+// parseTagPolicy returns the list of tag policies from a comma-separated list
+func parseTagPolicy(policy string) []string {
+	var policies []string
+	for _, p := range strings.Split(policy, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+// ValidateTagPolicy checks whether a tag policy, i.e., a comma-separated list of semver, git,
+// date and latest, is valid
+func ValidateTagPolicy(policy string) error {
+	policies := parseTagPolicy(policy)
+	if len(policies) == 0 {
+		return fmt.Errorf("empty tag policy")
+	}
+	for _, p := range policies {
+		if p != TagSemver && p != TagGit && p != TagDate && p != TagLatest {
+			return fmt.Errorf("invalid tag policy %s, it should be %s, %s, %s or %s", p, TagSemver, TagGit, TagDate, TagLatest)
+		}
+	}
+	return nil
+}
+
+// gitDescribe returns a description of the current commit of a git repository based on its tags
+func gitDescribe(dir string) (string, error) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("git not found: %s", err)
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(gitBin, "describe", "--tags", "--always", "--dirty")
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	err = syexec.RunCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to describe the git repository %s: %s", dir, err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// hasTag checks whether a list of tags includes a given tag
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTags returns the tags of an image based on a tag policy, in the order of the policy
+func GetTags(policy string, info *TagInfo) ([]string, error) {
+	if policy == "" {
+		policy = DefaultTagPolicy
+	}
+	err := ValidateTagPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, p := range parseTagPolicy(policy) {
+		var tag string
+		switch p {
+		case TagSemver:
+			if !semverRegexp.MatchString(info.Version) {
+				return nil, fmt.Errorf("invalid semantic version '%s'", info.Version)
+			}
+			tag = info.Version
+		case TagGit:
+			if info.SrcDir == "" {
+				return nil, fmt.Errorf("the %s tag policy requires a local source directory", TagGit)
+			}
+			tag, err = gitDescribe(info.SrcDir)
+			if err != nil {
+				return nil, err
+			}
+			tag = invalidTagChars.ReplaceAllString(tag, "_")
+		case TagDate:
+			tag = info.Date.Format("20060102")
+		case TagLatest:
+			tag = TagLatest
+		}
+		if !hasTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// getUploadsFile returns the path to the file recording the uploaded tags
+func getUploadsFile() string {
+	return filepath.Join(sys.GetSympiDir(), uploadsFilename)
+}
+
+// loadUploads reads all the uploaded tags
+func loadUploads() ([]UploadedTag, error) {
+	var uploads []UploadedTag
+	path := getUploadsFile()
+	if !util.FileExists(path) {
+		return uploads, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	err = json.Unmarshal(content, &uploads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return uploads, nil
+}
+
+// saveUploads writes all the uploaded tags
+func saveUploads(uploads []UploadedTag) error {
+	content, err := json.MarshalIndent(uploads, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the uploaded tags: %s", err)
+	}
+	path := getUploadsFile()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// recordUpload adds an uploaded tag to the record, replacing the previous upload of the same tag
+// since a tag such as 'latest' moves from one image to another
+func recordUpload(upload UploadedTag) error {
+	uploads, err := loadUploads()
+	if err != nil {
+		return err
+	}
+	var updated []UploadedTag
+	for _, u := range uploads {
+		if u.Ref() != upload.Ref() {
+			updated = append(updated, u)
+		}
+	}
+	return saveUploads(append(updated, upload))
+}
+
+// UploadTags uploads an image to a repository with several tags, e.g., 1.2.0 and latest, and
+// records the uploaded tags so they can later be listed or retracted
+func UploadTags(containerInfo *Config, repo string, tags []string, sysCfg *sys.Config) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("no tag to upload")
+	}
+	err := sy.CheckIntegrity(sysCfg)
+	if err != nil {
+		return fmt.Errorf("Singularity installation has been compromised: %s", err)
+	}
+
+	for _, tag := range tags {
+		upload := UploadedTag{
+			Repo: repo,
+			Tag:  tag,
+			Path: containerInfo.Path,
+			Date: time.Now().Format(time.RFC3339),
+		}
+		// The registry stores the image only once, additional tags are cheap
+		err = push(containerInfo, upload.Ref(), sysCfg)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %s", upload.Ref(), err)
+		}
+		err = recordUpload(upload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListUploadedTags returns the tags previously uploaded to a repository; all the uploaded tags
+// are returned when the repository is empty
+func ListUploadedTags(repo string) ([]UploadedTag, error) {
+	uploads, err := loadUploads()
+	if err != nil {
+		return nil, err
+	}
+	var tags []UploadedTag
+	for _, u := range uploads {
+		if repo == "" || u.Repo == repo {
+			tags = append(tags, u)
+		}
+	}
+	return tags, nil
+}
+
+// RetractTag deletes a previously uploaded tag from the registry, e.g.,
+// library://user/collection/app:1.2.0. If the tag was also the latest one, 'latest' is rolled
+// back to the most recent remaining upload of the repository whose local image still exists.
+// Only tags of a library can be deleted, 'singularity delete' not supporting other registries.
+func RetractTag(ref string, sysCfg *sys.Config) error {
+	if !strings.HasPrefix(ref, libraryScheme) {
+		return fmt.Errorf("cannot retract %s: only the images of a %s registry can be deleted, use the tools of the registry instead", ref, libraryScheme)
+	}
+	idx := strings.LastIndex(ref, ":")
+	if idx == -1 || strings.Contains(ref[idx:], "/") {
+		return fmt.Errorf("invalid image reference %s, it should be of the form <repository>:<tag>", ref)
+	}
+	repo := ref[:idx]
+	tag := ref[idx+1:]
+
+	uploads, err := loadUploads()
+	if err != nil {
+		return err
+	}
+	var retracted *UploadedTag
+	var remaining []UploadedTag
+	for i := range uploads {
+		if uploads[i].Ref() == ref {
+			retracted = &uploads[i]
+			continue
+		}
+		remaining = append(remaining, uploads[i])
+	}
+	if retracted == nil {
+		return fmt.Errorf("%s was not uploaded by sympi", ref)
+	}
+
+	_, err = runSingularityCmd(sysCfg, "delete", "--force", ref)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %s", ref, err)
+	}
+	err = saveUploads(remaining)
+	if err != nil {
+		return err
+	}
+
+	// Roll back 'latest' if it pointed to the retracted image
+	if tag == TagLatest {
+		return nil
+	}
+	var latest *UploadedTag
+	var previous *UploadedTag
+	for i := range remaining {
+		u := &remaining[i]
+		if u.Repo != repo {
+			continue
+		}
+		if u.Tag == TagLatest {
+			latest = u
+			continue
+		}
+		if u.Path != retracted.Path && util.FileExists(u.Path) && (previous == nil || u.Date > previous.Date) {
+			previous = u
+		}
+	}
+	if latest == nil || latest.Path != retracted.Path {
+		return nil
+	}
+	if previous == nil {
+		fmt.Printf("No previous image of %s is available, %s:%s still points to the retracted image\n", repo, repo, TagLatest)
+		return nil
+	}
+	fmt.Printf("Rolling back %s:%s to %s\n", repo, TagLatest, previous.Ref())
+	return UploadTags(&Config{Path: previous.Path, BuildDir: filepath.Dir(previous.Path)}, repo, []string{TagLatest}, sysCfg)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestGetTags(t *testing.T) {
+	info := TagInfo{
+		Version: "1.2.0",
+		Date:    time.Date(2019, time.December, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		policy   string
+		expected []string
+		fail     bool
+	}{
+		{policy: "", expected: []string{"20191203"}},
+		{policy: "semver,latest", expected: []string{"1.2.0", "latest"}},
+		{policy: "date, semver, date", expected: []string{"20191203", "1.2.0"}},
+		{policy: "git", fail: true},
+		{policy: "nightly", fail: true},
+	}
+
+	for _, tt := range tests {
+		tags, err := GetTags(tt.policy, &info)
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("GetTags(%s) succeeded instead of failing", tt.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("GetTags(%s) failed: %s", tt.policy, err)
+		}
+		if !reflect.DeepEqual(tags, tt.expected) {
+			t.Fatalf("GetTags(%s) returned %v instead of %v", tt.policy, tags, tt.expected)
+		}
+	}
+
+	info.Version = "latest"
+	_, err := GetTags(TagSemver, &info)
+	if err == nil {
+		t.Fatalf("GetTags accepted an invalid semantic version")
+	}
+}
+
+func TestRetractTagRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-tags-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	upload := UploadedTag{Repo: "oras://ghcr.io/user/app", Tag: "1.2.0", Path: "/tmp/app.sif", Date: time.Now().Format(time.RFC3339)}
+	err = recordUpload(upload)
+	if err != nil {
+		t.Fatalf("recordUpload() failed: %s", err)
+	}
+
+	fake := syexec.NewFakeRunner()
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	err = RetractTag(upload.Ref(), &sys.Config{SingularityBin: "singularity"})
+	if err == nil {
+		t.Fatalf("RetractTag() succeeded with an image of an ORAS registry")
+	}
+	if len(fake.Calls()) != 0 {
+		t.Fatalf("commands were executed to retract an image of an ORAS registry: %q", fake.CmdLines())
+	}
+	tags, err := ListUploadedTags(upload.Repo)
+	if err != nil {
+		t.Fatalf("ListUploadedTags() failed: %s", err)
+	}
+	if len(tags) != 1 {
+		t.Fatalf("the record of the upload was modified: %v", tags)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// baseImagesFilename is the name of the file in the tool's directory recording the digest of
+	// the base images of the previous builds, one '<bootstrap> <image>\t<digest>' per line
+	baseImagesFilename = "base-images.txt"
+)
+
+// getBaseImagesFile returns the path to the file recording the digests of the base images
+func getBaseImagesFile() string {
+	return filepath.Join(sys.GetSympiDir(), baseImagesFilename)
+}
+
+// loadBaseImageDigests loads the digests of the base images of the previous builds
+func loadBaseImageDigests(path string) (map[string]string, error) {
+	digests := make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return digests, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		tokens := strings.Split(line, "\t")
+		if len(tokens) == 2 {
+			digests[tokens[0]] = tokens[1]
+		}
+	}
+	return digests, nil
+}
+
+// saveBaseImageDigests saves the digests of the base images
+func saveBaseImageDigests(path string, digests map[string]string) error {
+	var lines []string
+	for image, digest := range digests {
+		lines = append(lines, image+"\t"+digest)
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+	err = ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// pinBaseImage pins the base image of a definition file to a digest, i.e., sysCfg.BaseImageDigest
+// or the current digest of the image when sysCfg.ResolveBaseImage is set, and returns the pinned
+// reference of the image. A warning is displayed when the digest differs from the one of the
+// previous build from the same image or from the current digest of a pinned image. Nothing is done
+// when the base image is not from a registry or a library.
+func pinBaseImage(defFile string, digestsFile string, sysCfg *sys.Config) (string, error) {
+	if sysCfg.BaseImageDigest == "" && !sysCfg.ResolveBaseImage {
+		return "", nil
+	}
+
+	bootstrap, from, err := deffile.GetBaseImage(defFile)
+	if err != nil {
+		return "", err
+	}
+	if bootstrap != container.DockerBootstrap && bootstrap != container.LibraryBootstrap {
+		log.Printf("[WARN] base images cannot be pinned with the %s bootstrap agent", bootstrap)
+		return "", nil
+	}
+	if container.IsPinnedImage(bootstrap, from) {
+		log.Printf("-> Base image %s is already pinned", from)
+		return from, nil
+	}
+
+	var current string
+	if sysCfg.ResolveBaseImage {
+		current, err = container.ResolveDigest(bootstrap, from)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve the digest of %s: %s", from, err)
+		}
+		log.Printf("-> Current digest of %s is %s", from, current)
+	}
+	digest := sysCfg.BaseImageDigest
+	if digest == "" {
+		digest = current
+	} else if current != "" && current != digest {
+		log.Printf("[WARN] %s is pinned to %s but its current digest is %s", from, digest, current)
+	}
+
+	// Digests of the previous builds are only tracked to warn about changes
+	id := bootstrap + " " + from
+	digests, err := loadBaseImageDigests(digestsFile)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+		digests = make(map[string]string)
+	}
+	if prev, ok := digests[id]; ok && prev != digest {
+		log.Printf("[WARN] base image %s changed since the previous build: %s is now %s", from, prev, digest)
+	}
+	digests[id] = digest
+	err = saveBaseImageDigests(digestsFile, digests)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+	}
+
+	pinned := container.PinImage(bootstrap, from, digest)
+	err = deffile.SetBaseImage(defFile, pinned)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("-> Base image pinned to %s", pinned)
+	return pinned, nil
+}
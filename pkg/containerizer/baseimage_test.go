@@ -0,0 +1,57 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestPinBaseImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-baseimage-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defFile := filepath.Join(dir, "app.def")
+	err = ioutil.WriteFile(defFile, []byte("Bootstrap: docker\nFrom: ubuntu:disco\n\n%labels\n\tLinux_distribution ubuntu\n\n%post\n\tapt-get update\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", defFile, err)
+	}
+	digestsFile := filepath.Join(dir, baseImagesFilename)
+
+	var sysCfg sys.Config
+	pinned, err := pinBaseImage(defFile, digestsFile, &sysCfg)
+	if err != nil || pinned != "" {
+		t.Fatalf("base image pinned without digest: %s (%v)", pinned, err)
+	}
+
+	sysCfg.BaseImageDigest = "sha256:" + strings.Repeat("ab", 32)
+	pinned, err = pinBaseImage(defFile, digestsFile, &sysCfg)
+	if err != nil || pinned != "ubuntu@"+sysCfg.BaseImageDigest {
+		t.Fatalf("pinBaseImage() returned %s: %v", pinned, err)
+	}
+	bootstrap, from, err := deffile.GetBaseImage(defFile)
+	if err != nil || bootstrap != container.DockerBootstrap || from != pinned {
+		t.Fatalf("definition file bootstraps from %s %s: %v", bootstrap, from, err)
+	}
+	content, err := ioutil.ReadFile(defFile)
+	if err != nil || strings.Count(string(content), "\t"+container.BaseImageLabel+" "+pinned+"\n") != 1 {
+		t.Fatalf("invalid definition file:\n%s", content)
+	}
+	digests, err := loadBaseImageDigests(digestsFile)
+	if err != nil || digests["docker ubuntu:disco"] != sysCfg.BaseImageDigest {
+		t.Fatalf("invalid digests of the previous builds: %v (%v)", digests, err)
+	}
+}
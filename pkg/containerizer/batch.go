@@ -0,0 +1,176 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// appConfigExt is the extension of the configuration files of applications looked up in a directory in batch mode
+	appConfigExt = ".conf"
+
+	// DefaultBatchParallelism is the default number of containers built concurrently in batch mode
+	DefaultBatchParallelism = 1
+)
+
+// BatchResult is the result of the creation of the container of one application in batch mode
+type BatchResult struct {
+	// ConfigFile is the path to the configuration file of the application
+	ConfigFile string
+
+	// Image is the path to the image of the container
+	Image string
+
+	// Exists specifies whether the image already existed, in which case it was not rebuilt
+	Exists bool
+
+	// Err is the error that occurred while creating the container, if any
+	Err error
+}
+
+// BatchReport gathers the results of the creation of all the containers of a batch
+type BatchReport struct {
+	// Results is the list of results, in the order of the configuration files
+	Results []BatchResult
+}
+
+// Failed returns the number of containers that could not be created
+func (r *BatchReport) Failed() int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// String returns a summary of the batch, i.e., the images that were produced and the failures
+func (r *BatchReport) String() string {
+	var sb strings.Builder
+	for _, res := range r.Results {
+		switch {
+		case res.Err != nil:
+			sb.WriteString(fmt.Sprintf("[FAILED]  %s: %s\n", res.ConfigFile, res.Err))
+		case res.Exists:
+			sb.WriteString(fmt.Sprintf("[EXISTS]  %s: %s\n", res.ConfigFile, res.Image))
+		default:
+			sb.WriteString(fmt.Sprintf("[CREATED] %s: %s\n", res.ConfigFile, res.Image))
+		}
+	}
+	sb.WriteString(fmt.Sprintf("%d image(s) available, %d failure(s)\n", len(r.Results)-r.Failed(), r.Failed()))
+	return sb.String()
+}
+
+// loadManifest returns the configuration files listed in a manifest, one per line; empty lines
+// and lines starting with '#' are ignored and relative paths are relative to the manifest
+func loadManifest(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	var configs []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		configs = append(configs, line)
+	}
+	return configs, nil
+}
+
+// ListAppConfigs returns the configuration files of the applications to containerize in batch
+// mode, specified with either a directory (all the .conf files of the directory), a glob
+// pattern (e.g., /path/to/apps/*.conf) or a manifest listing the configuration files
+func ListAppConfigs(spec string) ([]string, error) {
+	var configs []string
+	var err error
+
+	if strings.ContainsAny(spec, "*?[") {
+		configs, err = filepath.Glob(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %s", spec, err)
+		}
+	} else {
+		info, err := os.Stat(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access %s: %s", spec, err)
+		}
+		if info.IsDir() {
+			configs, err = filepath.Glob(filepath.Join(spec, "*"+appConfigExt))
+			if err != nil {
+				return nil, fmt.Errorf("failed to list the configuration files in %s: %s", spec, err)
+			}
+		} else {
+			configs, err = loadManifest(spec)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Strings(configs)
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no configuration file found in %s", spec)
+	}
+	return configs, nil
+}
+
+// ContainerizeApps creates the containers of several applications, at most parallelism at a
+// time. The failure of a container does not stop the batch. When containers use the layered build
+// strategy, base images with the same Linux distribution and MPI are built once and shared.
+func ContainerizeApps(configs []string, parallelism int, sysCfg *sys.Config) BatchReport {
+	var report BatchReport
+	report.Results = make([]BatchResult, len(configs))
+	if parallelism <= 0 {
+		parallelism = DefaultBatchParallelism
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelism)
+	for i, cfg := range configs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, cfg string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			// ContainerizeApp updates the configuration, e.g., the scratch directory, so each
+			// container gets its own copy
+			appSysCfg := *sysCfg
+			appSysCfg.AppContainizer = cfg
+			log.Printf("* Creating container for %s...\n", cfg)
+			c, err := ContainerizeApp(&appSysCfg)
+			res := BatchResult{ConfigFile: cfg, Image: c.Path}
+			if errors.Is(err, sympierr.ErrImageExists) {
+				res.Exists = true
+			} else {
+				res.Err = err
+			}
+			report.Results[i] = res
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	return report
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListAppConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []string{"b.conf", "a.conf", "notes.txt"} {
+		err = ioutil.WriteFile(filepath.Join(dir, f), []byte("app_name = test\n"), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", f, err)
+		}
+	}
+	manifest := filepath.Join(dir, "apps.list")
+	err = ioutil.WriteFile(manifest, []byte("# apps\nb.conf\n\n/opt/apps/c.conf\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", manifest, err)
+	}
+
+	tests := []struct {
+		spec     string
+		expected []string
+	}{
+		{
+			spec:     dir,
+			expected: []string{filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")},
+		},
+		{
+			spec:     filepath.Join(dir, "b*"),
+			expected: []string{filepath.Join(dir, "b.conf")},
+		},
+		{
+			spec:     manifest,
+			expected: []string{"/opt/apps/c.conf", filepath.Join(dir, "b.conf")},
+		},
+	}
+
+	for _, tt := range tests {
+		configs, err := ListAppConfigs(tt.spec)
+		if err != nil {
+			t.Fatalf("ListAppConfigs(%s) failed: %s", tt.spec, err)
+		}
+		if !reflect.DeepEqual(configs, tt.expected) {
+			t.Fatalf("ListAppConfigs(%s) returned %v instead of %v", tt.spec, configs, tt.expected)
+		}
+	}
+
+	_, err = ListAppConfigs(filepath.Join(dir, "*.sif"))
+	if err == nil {
+		t.Fatalf("ListAppConfigs succeeded without any configuration file")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// buildStrategyKey is the key used in the application's configuration file to specify how the container is built
+	buildStrategyKey = "build_strategy"
+
+	// LayeredBuildStrategy is the build strategy where a base image with the Linux distribution
+	// and MPI is built and cached, the application being then installed on top of it
+	LayeredBuildStrategy = "layered"
+
+	// baseDefFileName is the name of the definition file of the base image
+	baseDefFileName = "base.def"
+
+	// baseImageName is the name of a cached base image
+	baseImageName = "base.sif"
+
+	// cacheIDLength is the number of characters of the hash of the definition file used to identify a base image
+	cacheIDLength = 16
+)
+
+// baseImageLocks serializes the creation of a given base image when several containers are
+// built concurrently, e.g., in batch mode, so the base image is only built once and then shared
+var baseImageLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+// lockBaseImage locks the base image with a given identifier, the returned function releasing the lock
+func lockBaseImage(id string) func() {
+	baseImageLocks.Lock()
+	l, ok := baseImageLocks.locks[id]
+	if !ok {
+		l = new(sync.Mutex)
+		baseImageLocks.locks[id] = l
+	}
+	baseImageLocks.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// getBuildCacheDir returns the directory where base images are cached
+func getBuildCacheDir() string {
+	return filepath.Join(sys.GetSympiDir(), sys.BuildCacheDir)
+}
+
+// getCacheID returns the identifier of the base image created from a given definition file
+func getCacheID(defFile string) (string, error) {
+	content, err := ioutil.ReadFile(defFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", defFile, err)
+	}
+
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])[:cacheIDLength], nil
+}
+
+// createBaseDefFile creates the definition file of the base image with the Linux distribution and
+// MPI of a hybrid container and returns its data and the pinned reference of the image it bootstraps from
+func createBaseDefFile(deffileCfg *deffile.DefFileData, mpiCfg *mpi.Config, sysCfg *sys.Config) (deffile.DefFileData, string, error) {
+	baseData := *deffileCfg
+	baseData.Path = filepath.Join(mpiCfg.Container.BuildDir, baseDefFileName)
+	err := deffile.CreateHybridBaseDefFile(&baseData, sysCfg)
+	if err != nil {
+		return baseData, "", fmt.Errorf("unable to create definition file of the base image: %s", err)
+	}
+	pinned, err := pinBaseImage(baseData.Path, getBaseImagesFile(), sysCfg)
+	if err != nil {
+		return baseData, "", fmt.Errorf("failed to pin the base image: %s", err)
+	}
+	return baseData, pinned, nil
+}
+
+// buildBaseImage builds a base image from its definition file in a directory of a cache, e.g.,
+// the build cache; nothing is left in the cache if the build fails
+func buildBaseImage(baseData *deffile.DefFileData, pinned string, installDir string, mpiCfg *mpi.Config, sysCfg *sys.Config) (string, error) {
+	var baseImage container.Config
+	baseImage.Name = baseImageName
+	baseImage.InstallDir = installDir
+	baseImage.Path = filepath.Join(baseImage.InstallDir, baseImage.Name)
+	baseImage.BuildDir = mpiCfg.Container.BuildDir
+	baseImage.DefFile = baseData.Path
+	baseImage.BaseImage = pinned
+	baseImage.BuildArgs = mpiCfg.Container.BuildArgs
+	baseImage.BuildEnv = mpiCfg.Container.BuildEnv
+
+	err := os.MkdirAll(baseImage.InstallDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", baseImage.InstallDir, err)
+	}
+
+	log.Printf("-> Base image is not in the cache, building %s\n", baseImage.Path)
+	err = container.Create(&baseImage, sysCfg)
+	if err != nil {
+		// Do not leave a partial image in the cache
+		os.RemoveAll(baseImage.InstallDir)
+		return "", fmt.Errorf("failed to create base image: %s", err)
+	}
+
+	return baseImage.Path, nil
+}
+
+// getBaseImage returns the path to the base image with the Linux distribution and MPI of a
+// hybrid container, building it if it is not already in the cache
+func getBaseImage(deffileCfg *deffile.DefFileData, mpiCfg *mpi.Config, sysCfg *sys.Config) (string, error) {
+	baseData, pinned, err := createBaseDefFile(deffileCfg, mpiCfg, sysCfg)
+	if err != nil {
+		return "", err
+	}
+
+	// The base image is identified by the content of its definition file so any change to the
+	// distribution or MPI results in a new base image
+	id, err := getCacheID(baseData.Path)
+	if err != nil {
+		return "", err
+	}
+
+	unlock := lockBaseImage(id)
+	defer unlock()
+
+	installDir := filepath.Join(getBuildCacheDir(), id)
+	path := filepath.Join(installDir, baseImageName)
+	if util.FileExists(path) {
+		log.Printf("-> Using cached base image %s\n", path)
+		return path, nil
+	}
+
+	return buildBaseImage(&baseData, pinned, installDir, mpiCfg, sysCfg)
+}
+
+// isLayered checks whether the container of an application is built on top of a cached base image
+func (app *appConfig) isLayered() bool {
+	return app.buildStrategy == LayeredBuildStrategy || app.buildStrategy == QuickBuildStrategy
+}
+
+// generateLayeredDeffile generates the definition file of a hybrid container built on top of a
+// cached base image, i.e., with the layered or the quick build strategy
+func generateLayeredDeffile(app *appConfig, deffileCfg *deffile.DefFileData, mpiCfg *mpi.Config, sysCfg *sys.Config) error {
+	var baseImage string
+	var err error
+	if app.buildStrategy == QuickBuildStrategy {
+		baseImage, err = getQuickBaseImage(deffileCfg, mpiCfg, sysCfg)
+	} else {
+		baseImage, err = getBaseImage(deffileCfg, mpiCfg, sysCfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	return deffile.CreateHybridAppDefFile(&app.info, deffileCfg, baseImage, sysCfg)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/checkpoint"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+// validateOneOf returns a function checking that a value is one of a list of values
+func validateOneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if v == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not one of %s", value, strings.Join(values, ", "))
+	}
+}
+
+func validateBuildArgs(value string) error {
+	_, err := container.ParseBuildArgs(value)
+	return err
+}
+
+// benchKeys are the keys of an application's configuration file pinning the settings of a
+// benchmark and the corresponding options of app.ParseBench
+var benchKeys = map[string]string{
+	benchSeedKey:       "seed",
+	benchIterationsKey: "iterations",
+	benchMsgSizesKey:   "msg_sizes",
+}
+
+// validateBench returns a function checking the value of one of the keys pinning the settings of
+// a benchmark, e.g., benchIterationsKey
+func validateBench(key string) func(string) error {
+	return func(value string) error {
+		_, err := app.ParseBench(benchKeys[key] + "=" + value)
+		return err
+	}
+}
+
+// getBench returns the settings of the benchmark pinned in an application's configuration file
+func getBench(kvs []kv.KV) (app.Bench, error) {
+	var opts []string
+	for _, key := range []string{benchSeedKey, benchIterationsKey, benchMsgSizesKey} {
+		val := kv.GetValue(kvs, key)
+		if val != "" {
+			opts = append(opts, benchKeys[key]+"="+val)
+		}
+	}
+	bench, err := app.ParseBench(strings.Join(opts, " "))
+	if err != nil {
+		return bench, fmt.Errorf("invalid benchmark settings: %s", err)
+	}
+	return bench, nil
+}
+
+// getAppSchema returns the schema of an application's configuration file, including the keys
+// of the applications of a multi-app container
+func getAppSchema(kvs []kv.KV) configparser.Schema {
+	schema := configparser.Schema{
+		{Name: "app_name", Required: true},
+		{Name: "app_url"},
+		{Name: "app_exe"},
+		{Name: "app_compile_cmd"},
+		{Name: "mpi"},
+		{Name: "distro"},
+		{Name: "registry"},
+		{Name: mpiModelKey, Validate: validateOneOf(container.HybridModel, container.BindModel)},
+		{Name: containerNameKey, Validate: container.ValidateNameTemplate},
+		{Name: appTypeKey, Validate: validateOneOf(app.PythonType, app.BinaryType)},
+		{Name: pythonVersionKey},
+		{Name: pythonRequirementsKey},
+		{Name: pipInstallKey},
+		{Name: condaInstallKey},
+		{Name: appsKey},
+		{Name: mirrorHostMPIKey},
+		{Name: containerMPIPrefixKey, Validate: container.ValidateMPIPrefix},
+		{Name: requiredThreadLevelKey, Validate: implem.ValidateThreadLevel},
+		{Name: appVersionKey},
+		{Name: tagPolicyKey, Validate: container.ValidateTagPolicy},
+		{Name: outputArtifactsKey},
+		{Name: baseImageDigestKey, Validate: container.ValidateDigest},
+		{Name: resolveBaseImageKey, Validate: configparser.ValidateBool},
+		{Name: dockerfileKey, Validate: configparser.ValidateBool},
+		{Name: checkpointToolKey, Validate: checkpoint.Validate},
+		{Name: hostOptimizedKey, Validate: configparser.ValidateBool},
+		{Name: benchSeedKey, Validate: validateBench(benchSeedKey)},
+		{Name: benchIterationsKey, Validate: validateBench(benchIterationsKey)},
+		{Name: benchMsgSizesKey, Validate: validateBench(benchMsgSizesKey)},
+		{Name: buildArgsKey, Validate: validateBuildArgs},
+		{Name: buildStrategyKey, Validate: validateOneOf(LayeredBuildStrategy, QuickBuildStrategy)},
+		{Name: buildEnvKeyPrefix, Prefix: true},
+	}
+
+	names := strings.FieldsFunc(kv.GetValue(kvs, appsKey), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	for _, name := range names {
+		schema = append(schema, configparser.KeySpec{Name: name + ".app_url"},
+			configparser.KeySpec{Name: name + ".app_exe"},
+			configparser.KeySpec{Name: name + ".app_compile_cmd"})
+	}
+	return schema
+}
+
+// CheckAppConfig checks the content of an application's configuration file and returns all the
+// problems found, without containerizing the application
+func CheckAppConfig(kvs []kv.KV) []error {
+	problems := getAppSchema(kvs).Check(kvs)
+
+	apps, err := loadApps(kvs)
+	if err != nil {
+		problems = append(problems, err)
+	}
+	appType := kv.GetValue(kvs, appTypeKey)
+	if kv.GetValue(kvs, "app_url") == "" && appType != app.PythonType && len(apps) == 0 {
+		problems = append(problems, fmt.Errorf("app_url is not defined"))
+	}
+	if kv.GetValue(kvs, "app_exe") == "" && len(apps) == 0 {
+		problems = append(problems, fmt.Errorf("app_exe is not defined"))
+	}
+	if len(apps) > 0 && kv.GetValue(kvs, mpiModelKey) != container.HybridModel {
+		problems = append(problems, fmt.Errorf("multi-app containers are only supported with the %s model", container.HybridModel))
+	}
+	return problems
+}
@@ -11,23 +11,116 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gvallee/go_util/pkg/util"
 	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
 	"github.com/sylabs/singularity-mpi/internal/pkg/distro"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
 	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/builder"
+	"github.com/sylabs/singularity-mpi/pkg/checkpoint"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
 	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/events"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
+	"github.com/sylabs/singularity-mpi/pkg/toolchain"
 )
 
 const (
 	mpiModelKey = "mpi_model"
+
+	// containerNameKey is the key used in the application's configuration file to specify the
+	// template used to name the image, e.g., container_name = {app}-{mpi}-{version}-{date}
+	containerNameKey = "container_name"
+
+	// appTypeKey is the key used in the application's configuration file to specify the type of
+	// the application, e.g., app_type = python
+	appTypeKey = "app_type"
+
+	// pythonVersionKey is the key used to specify the version of Python of a Python application
+	pythonVersionKey = "python_version"
+
+	// pythonRequirementsKey is the key used to specify the path to the pip requirements file of a Python application
+	pythonRequirementsKey = "python_requirements"
+
+	// pipInstallKey is the key used to specify the arguments of 'pip install' for a Python application
+	pipInstallKey = "pip_install"
+
+	// condaInstallKey is the key used to specify the arguments of 'conda install' for a Python application
+	condaInstallKey = "conda_install"
+
+	// appsKey is the key used to list the applications of a multi-app container, e.g., apps = netpipe,imb.
+	// The details of each application are specified with the same keys as a single application,
+	// prefixed by its name, e.g., netpipe.app_url
+	appsKey = "apps"
+
+	// mirrorHostMPIKey is the key used to specify the installation directory of a MPI on the host
+	// whose configuration is mirrored when building MPI in the container, e.g., mirror_host_mpi = /opt/openmpi
+	mirrorHostMPIKey = "mirror_host_mpi"
+
+	// containerMPIPrefixKey is the key used to specify the directory where MPI is installed in the
+	// container, overwriting the one of the tool's configuration file, e.g., /opt/{mpi}-{version}
+	containerMPIPrefixKey = "container_mpi_prefix"
+
+	// requiredThreadLevelKey is the key used to specify the thread level the application requires
+	// from MPI, e.g., multiple
+	requiredThreadLevelKey = "required_thread_level"
+
+	// appVersionKey is the key used to specify the version of the application, used to tag the
+	// image with the semver tag policy, e.g., app_version = 1.2.0
+	appVersionKey = "app_version"
+
+	// tagPolicyKey is the key used to specify the tags given to the image when it is uploaded,
+	// e.g., tag_policy = semver,latest
+	tagPolicyKey = "tag_policy"
+
+	// outputArtifactsKey is the key used to specify the patterns of the files produced by the
+	// application that are collected after each run, e.g., output_artifacts = np.out,*.dat
+	outputArtifactsKey = "output_artifacts"
+
+	// baseImageDigestKey is the key used to specify the digest the base image is pinned to, e.g.,
+	// base_image_digest = sha256:<hex>
+	baseImageDigestKey = "base_image_digest"
+
+	// resolveBaseImageKey is the key used to specify whether the current digest of the base image is
+	// looked up and pinned, e.g., resolve_base_image = true
+	resolveBaseImageKey = "resolve_base_image"
+
+	// dockerfileKey is the key used to specify whether a Dockerfile equivalent to the definition file
+	// is generated, e.g., dockerfile = true
+	dockerfileKey = "dockerfile"
+
+	// buildArgsKey is the key used to specify extra flags of 'singularity build', e.g., build_args = --nv
+	buildArgsKey = "build_args"
+
+	// buildEnvKeyPrefix is the prefix of the keys specifying the environment available while building
+	// the image, e.g., build_env.HTTP_PROXY = http://proxy:3128; without value, the variable takes its
+	// value from the environment of the host
+	buildEnvKeyPrefix = "build_env."
+
+	// checkpointToolKey is the key used to specify the checkpointing tool installed in the container
+	// to test checkpoint/restart, e.g., checkpoint_tool = dmtcp
+	checkpointToolKey = "checkpoint_tool"
+
+	// hostOptimizedKey is the key used to specify that the application is compiled for the CPUs of
+	// the build host, e.g., host_optimized = true when its build system uses -march=native
+	hostOptimizedKey = "host_optimized"
+
+	// benchSeedKey, benchIterationsKey and benchMsgSizesKey are the keys used to pin the settings of
+	// a benchmark, which replace the placeholders {seed}, {iterations} and {msg_sizes} of the
+	// command of the application, e.g., bench_iterations = 1000 and bench_msg_sizes = 0:22
+	benchSeedKey       = "bench_seed"
+	benchIterationsKey = "bench_iterations"
+	benchMsgSizesKey   = "bench_msg_sizes"
 )
 
 type appConfig struct {
@@ -40,12 +133,104 @@ type appConfig struct {
 	// envScript is the path to the script that the user will be
 	// able to use to set all the environment variables necessary to use the MPI installed on the host
 	envScript string
+
+	// buildStrategy specifies how the container is built, e.g., LayeredBuildStrategy or QuickBuildStrategy (empty to build
+	// the container from a single definition file)
+	buildStrategy string
+
+	// apps is the list of applications of a multi-app container, empty when the container has a single application
+	apps []app.Info
+
+	// mirrorHostMPI is the installation directory of the MPI on the host whose configuration is
+	// mirrored when building MPI in the container, empty to use the default configuration
+	mirrorHostMPI string
+
+	// mpiPrefix is the directory where MPI is installed in the container, possibly with the {mpi}
+	// and {version} tags; empty to use the directory of the installation of MPI on the host
+	mpiPrefix string
+
+	// checkpointTool is the checkpointing tool installed in the container, e.g., checkpoint.DMTCP;
+	// empty when no tool is installed
+	checkpointTool string
+
+	// hostOptimized specifies whether the application is compiled for the CPUs of the build host,
+	// whose features are then required to run the container
+	hostOptimized bool
+}
+
+// hostOptimizationFlags are the compiler flags generating code for the CPUs of the build host
+var hostOptimizationFlags = []string{"-march=native", "-mcpu=native", "-mtune=native", "-xHost"}
+
+// isHostOptimized checks whether the application is compiled for the CPUs of the build host, i.e.,
+// whether its compile commands or the environment of the build use a flag such as -march=native
+func (a *appConfig) isHostOptimized(buildEnv []string) bool {
+	if a.hostOptimized {
+		return true
+	}
+	values := append([]string{a.info.InstallCmd}, buildEnv...)
+	for _, other := range a.apps {
+		values = append(values, other.InstallCmd)
+	}
+	for _, v := range values {
+		for _, flag := range hostOptimizationFlags {
+			if strings.Contains(v, flag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadBuildEnv loads the environment available while building the image from the configuration
+func loadBuildEnv(kvs []kv.KV) ([]string, error) {
+	var env []string
+	for _, e := range kvs {
+		if !strings.HasPrefix(e.Key, buildEnvKeyPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(e.Key, buildEnvKeyPrefix)
+		v, ok, err := container.ParseBuildEnv(name + "=" + e.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("[WARN] %s is not set on the host, it will not be available while building the image\n", name)
+			continue
+		}
+		env = append(env, v)
+	}
+	return env, nil
+}
+
+// loadApps loads the applications of a multi-app container from the configuration
+func loadApps(kvs []kv.KV) ([]app.Info, error) {
+	var apps []app.Info
+	names := strings.FieldsFunc(kv.GetValue(kvs, appsKey), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	for _, name := range names {
+		var a app.Info
+		a.Name = name
+		a.Source = kv.GetValue(kvs, name+".app_url")
+		a.BinName = kv.GetValue(kvs, name+".app_exe")
+		a.InstallCmd = kv.GetValue(kvs, name+".app_compile_cmd")
+		if a.Source == "" || a.BinName == "" {
+			return nil, fmt.Errorf("%s.app_url and %s.app_exe must be defined", name, name)
+		}
+		for _, prev := range apps {
+			if prev.Name == name {
+				return nil, fmt.Errorf("application %s is defined more than once", name)
+			}
+		}
+		apps = append(apps, a)
+	}
+	return apps, nil
 }
 
 func getMPIURL(mpi string, version string, sysCfg *sys.Config) string {
 	mpiCfgFile := sys.GetMPIConfigFileName(mpi)
 	path := filepath.Join(sysCfg.EtcDir, mpiCfgFile)
-	kvs, err := kv.LoadKeyValueConfig(path)
+	kvs, err := configparser.Load(path)
 	if err != nil {
 		log.Printf("[WARN] Cannot load configuration from %s: %s", path, err)
 		return ""
@@ -121,6 +306,25 @@ func generateStandardDeffile(app *appConfig, container *container.Config, sysCfg
 	return deffileCfg, nil
 }
 
+// mergeConfigureArgs adds arguments of configure to a list, replacing the arguments of the list
+// with the same name, e.g., --enable-threads=multiple replaces --enable-threads=funneled
+func mergeConfigureArgs(args []string, extraArgs []string) []string {
+	var merged []string
+	for _, arg := range args {
+		overwritten := false
+		for _, extra := range extraArgs {
+			if strings.SplitN(arg, "=", 2)[0] == strings.SplitN(extra, "=", 2)[0] {
+				overwritten = true
+				break
+			}
+		}
+		if !overwritten {
+			merged = append(merged, arg)
+		}
+	}
+	return append(merged, extraArgs...)
+}
+
 func generateMPIDeffile(app *appConfig, mpiCfg *mpi.Config, sysCfg *sys.Config) (deffile.DefFileData, error) {
 	deffileCfg := deffile.DefFileData{
 		Path:     mpiCfg.Container.DefFile,
@@ -135,15 +339,85 @@ func generateMPIDeffile(app *appConfig, mpiCfg *mpi.Config, sysCfg *sys.Config)
 	log.Printf("-> Creating definition file %s for application %s\n", mpiCfg.Container.DefFile, app.info.Name)
 
 	deffileCfg.MpiImplm = &mpiCfg.Implem
+	deffileCfg.Apps = app.apps
 	deffileCfg.InternalEnv = &mpiCfg.Buildenv
 	deffileCfg.InternalEnv.InstallDir = filepath.Join(sysCfg.Persistent, sys.MPIInstallDirPrefix+mpiCfg.Implem.ID+"-"+mpiCfg.Implem.Version)
-	log.Printf("-> Installing MPI in container in %s\n", deffileCfg.InternalEnv.InstallDir)
 	deffileCfg.Model = mpiCfg.Container.Model
+	deffileCfg.MPIEnv = mpiplugin.Get(mpiCfg.Implem.ID).ContainerEnv(&mpiCfg.Implem, sysCfg)
+	deffileCfg.CheckpointTool = app.checkpointTool
+
+	// Binaries compiled with -march=native only run on CPUs with the features of the build host,
+	// which are therefore recorded so sympi can check them before running the container
+	var err error
+	if app.isHostOptimized(mpiCfg.Container.BuildEnv) {
+		cpuInfo, err := sys.GetCPUInfo()
+		if err == nil {
+			deffileCfg.BuildHostCPU = cpuInfo
+		} else {
+			log.Printf("[WARN] unable to get the features of the CPUs of the host: %s", err)
+		}
+	}
+
+	// MPI and the application are built in the container with the same toolchain as on the host
+	deffileCfg.Toolchain, err = toolchain.Get(sysCfg.Toolchain, sysCfg.ToolchainDir)
+	if err != nil {
+		return deffileCfg, err
+	}
+	mpiCfg.Container.BuildArgs = append(mpiCfg.Container.BuildArgs, deffile.GetToolchainBuildArgs(&deffileCfg)...)
+
+	// The tuning file of the site is copied in the container and MPI pointed to it
+	tuning := sys.GetTuningProfile(sysCfg, mpiCfg.Implem.ID)
+	if tuning != nil {
+		env := mpiplugin.Get(mpiCfg.Implem.ID).TuningEnv(&mpiCfg.Implem, tuning.ContainerPath())
+		if len(env) == 0 {
+			return deffileCfg, fmt.Errorf("%s does not support tuning files, remove the %s%s keys from the tool's configuration file", mpiCfg.Implem.ID, sy.TuningKeyPrefix, mpiCfg.Implem.ID)
+		}
+		log.Printf("-> Using tuning profile %s (%s)\n", tuning.Name, tuning.File)
+		deffileCfg.Tuning = tuning
+		for _, e := range env {
+			deffileCfg.MPIEnv = append(deffileCfg.MPIEnv, "export "+e)
+		}
+	}
+
+	if app.mirrorHostMPI != "" && mpiCfg.Container.Model == container.HybridModel {
+		args, err := mpiplugin.Get(mpiCfg.Implem.ID).MirrorConfigureArgs(app.mirrorHostMPI)
+		if err != nil {
+			return deffileCfg, fmt.Errorf("failed to mirror the configuration of %s: %s", app.mirrorHostMPI, err)
+		}
+		log.Printf("-> Mirroring the configuration of %s: %s\n", app.mirrorHostMPI, strings.Join(args, " "))
+		deffileCfg.MPIConfigureArgs = args
+	}
+
+	// The thread level is provided by MPI in the container with the hybrid model and by MPI on the
+	// host, which is built with the configure arguments of the tool's configuration, with the bind model
+	if app.info.ThreadLevel != "" {
+		if mpiCfg.Container.Model == container.HybridModel {
+			args := mpiplugin.Get(mpiCfg.Implem.ID).ThreadLevelConfigureArgs(&mpiCfg.Implem, app.info.ThreadLevel)
+			deffileCfg.MPIConfigureArgs = mergeConfigureArgs(deffileCfg.MPIConfigureArgs, args)
+		} else {
+			sysCfg.ThreadLevel = app.info.ThreadLevel
+		}
+	}
+
+	if app.info.IsBinary() {
+		return generateBinaryDeffile(app, &deffileCfg, mpiCfg, sysCfg)
+	}
 
 	switch mpiCfg.Container.Model {
 	case container.HybridModel:
-		// todo: should call the builder and not directly that function
-		err := deffile.CreateHybridDefFile(&app.info, &deffileCfg, sysCfg)
+		if app.mpiPrefix != "" {
+			deffileCfg.InternalEnv.InstallDir = container.ExpandMPIPrefix(app.mpiPrefix, mpiCfg.Implem.ID, mpiCfg.Implem.Version)
+		}
+		log.Printf("-> Installing MPI in container in %s\n", deffileCfg.InternalEnv.InstallDir)
+		if app.isLayered() {
+			err := generateLayeredDeffile(app, &deffileCfg, mpiCfg, sysCfg)
+			if err != nil {
+				return deffileCfg, fmt.Errorf("unable to create container: %s", err)
+			}
+			break
+		}
+
+		err := mpiplugin.Get(mpiCfg.Implem.ID).CreateHybridDeffile(&app.info, &deffileCfg, sysCfg)
 		if err != nil {
 			return deffileCfg, fmt.Errorf("unable to create container: %s", err)
 		}
@@ -160,8 +434,17 @@ func generateMPIDeffile(app *appConfig, mpiCfg *mpi.Config, sysCfg *sys.Config)
 			return deffileCfg, fmt.Errorf("failed to compile the application on the host: %s", err)
 		}
 
+		// MPI is mounted where it is installed on the host, unless a prefix is specified, in which
+		// case the build environment on the host is left untouched
+		if app.mpiPrefix != "" {
+			internalEnv := mpiCfg.Buildenv
+			internalEnv.InstallDir = container.ExpandMPIPrefix(app.mpiPrefix, mpiCfg.Implem.ID, mpiCfg.Implem.Version)
+			deffileCfg.InternalEnv = &internalEnv
+		} else {
+			deffileCfg.InternalEnv.InstallDir = mpiCfg.Buildenv.InstallDir
+		}
+
 		// todo: should call the builder and not directly that function
-		deffileCfg.InternalEnv.InstallDir = mpiCfg.Buildenv.InstallDir
 		err = deffile.CreateBindDefFile(&app.info, &deffileCfg, sysCfg)
 		if err != nil {
 			return deffileCfg, fmt.Errorf("unable to create container: %s", err)
@@ -171,14 +454,93 @@ func generateMPIDeffile(app *appConfig, mpiCfg *mpi.Config, sysCfg *sys.Config)
 	return deffileCfg, nil
 }
 
+// getDockerContextDir returns the build context directory of the Dockerfile of a container, next
+// to its image, e.g., /path/to/app.docker for /path/to/app.sif
+func getDockerContextDir(c *container.Config) string {
+	return strings.TrimSuffix(c.Path, filepath.Ext(c.Path)) + ".docker"
+}
+
+// generateBinaryDeffile creates the definition file of an application only available as prebuilt
+// binaries: the binaries are copied on the host, without compiling anything, and packaged in the container
+func generateBinaryDeffile(app *appConfig, deffileCfg *deffile.DefFileData, mpiCfg *mpi.Config, sysCfg *sys.Config) (deffile.DefFileData, error) {
+	b, err := builder.Load(&mpiCfg.Implem)
+	if err != nil {
+		return *deffileCfg, fmt.Errorf("unable to instantiate builder")
+	}
+
+	if app.isLayered() {
+		log.Printf("-> The %s build strategy is not supported with prebuilt binaries, building the container from a single definition file\n", app.buildStrategy)
+	}
+
+	var hostAppBuildEnv buildenv.Info
+	log.Println("Prebuilt binaries: getting the application on the host...")
+	err = b.GetMPIBinaryOnHost(&app.info, mpiCfg, &hostAppBuildEnv, sysCfg)
+	if err != nil {
+		return *deffileCfg, fmt.Errorf("failed to get the application on the host: %s", err)
+	}
+
+	switch mpiCfg.Container.Model {
+	case container.HybridModel:
+		if app.mpiPrefix != "" {
+			deffileCfg.InternalEnv.InstallDir = container.ExpandMPIPrefix(app.mpiPrefix, mpiCfg.Implem.ID, mpiCfg.Implem.Version)
+		}
+		log.Printf("-> Installing MPI in container in %s\n", deffileCfg.InternalEnv.InstallDir)
+	case container.BindModel:
+		// Like when the application is compiled on the host, the build environment on the host
+		// is left untouched when a prefix is specified
+		if app.mpiPrefix != "" {
+			internalEnv := mpiCfg.Buildenv
+			internalEnv.InstallDir = container.ExpandMPIPrefix(app.mpiPrefix, mpiCfg.Implem.ID, mpiCfg.Implem.Version)
+			deffileCfg.InternalEnv = &internalEnv
+		} else {
+			deffileCfg.InternalEnv.InstallDir = mpiCfg.Buildenv.InstallDir
+		}
+	}
+
+	err = deffile.CreateBinaryDefFile(&app.info, deffileCfg, sysCfg)
+	if err != nil {
+		return *deffileCfg, fmt.Errorf("unable to create container: %s", err)
+	}
+
+	return *deffileCfg, nil
+}
+
+// checkTuningLabel checks that the tuning profile recorded in the labels of an existing image, e.g.,
+// mca-params@sha256:<hex>, is the current tuning profile of the site, nil when the site has none:
+// the tuning file is copied in the image when it is built, so an image built with another version
+// of the file is not tuned like the new containers
+func checkTuningLabel(label string, want *sys.TuningProfile) error {
+	name, digest := sys.ParseTuningLabel(label)
+	switch {
+	case want == nil && label == "":
+		return nil
+	case want == nil:
+		return fmt.Errorf("the image has the tuning profile %s while the site has none anymore", name)
+	case label == "":
+		return fmt.Errorf("the image has no tuning profile instead of %s", want.Name)
+	case name != want.Name:
+		return fmt.Errorf("the image has the tuning profile %s instead of %s", name, want.Name)
+	case digest != want.Digest:
+		return fmt.Errorf("the image has a previous version of the tuning file of %s (%s instead of %s)", name, digest, want.Digest)
+	}
+	return nil
+}
+
 // ContainerizeApp will parse the configuration file specific to an app, install
 // the appropriate MPI on the host, as well as create the container.
 func ContainerizeApp(sysCfg *sys.Config) (container.Config, error) {
+	start := time.Now()
+	c, err := containerizeApp(sysCfg)
+	events.EmitBuild(events.PhaseBuild, sysCfg.AppContainizer, start, err)
+	return c, err
+}
+
+func containerizeApp(sysCfg *sys.Config) (container.Config, error) {
 	var containerMPI mpi.Config
 
 	log.Printf("* Loading configuration from %s\n", sysCfg.AppContainizer)
 	// Load config file
-	kvs, err := kv.LoadKeyValueConfig(sysCfg.AppContainizer)
+	kvs, err := configparser.Load(sysCfg.AppContainizer)
 	if err != nil {
 		return containerMPI.Container, fmt.Errorf("Impossible to load configuration file: %s", err)
 	}
@@ -187,16 +549,38 @@ func ContainerizeApp(sysCfg *sys.Config) (container.Config, error) {
 	if kv.GetValue(kvs, "app_name") == "" {
 		return containerMPI.Container, fmt.Errorf("Application's name is not defined")
 	}
-	if kv.GetValue(kvs, "app_url") == "" {
+	appType := kv.GetValue(kvs, appTypeKey)
+	if appType != "" && appType != app.PythonType && appType != app.BinaryType {
+		return containerMPI.Container, fmt.Errorf("unsupported application type: %s", appType)
+	}
+	apps, err := loadApps(kvs)
+	if err != nil {
+		return containerMPI.Container, fmt.Errorf("invalid list of applications: %s", err)
+	}
+	if len(apps) > 0 && kv.GetValue(kvs, mpiModelKey) != container.HybridModel {
+		return containerMPI.Container, fmt.Errorf("multi-app containers are only supported with the %s model", container.HybridModel)
+	}
+	// Python applications can come only from PyPI or conda and the applications of a multi-app
+	// container are defined separately
+	if kv.GetValue(kvs, "app_url") == "" && appType != app.PythonType && len(apps) == 0 {
 		return containerMPI.Container, fmt.Errorf("Application URL is not defined")
 	}
-	if kv.GetValue(kvs, "app_exe") == "" {
+	if appType == app.BinaryType && kv.GetValue(kvs, mpiModelKey) != container.HybridModel && kv.GetValue(kvs, mpiModelKey) != container.BindModel {
+		return containerMPI.Container, fmt.Errorf("applications with prebuilt binaries are only supported with the %s and %s models", container.HybridModel, container.BindModel)
+	}
+	if appType == app.BinaryType && len(apps) > 0 {
+		return containerMPI.Container, fmt.Errorf("applications with prebuilt binaries cannot be part of multi-app containers")
+	}
+	if appType == app.PythonType && kv.GetValue(kvs, mpiModelKey) != container.HybridModel {
+		return containerMPI.Container, fmt.Errorf("Python applications are only supported with the %s model", container.HybridModel)
+	}
+	if kv.GetValue(kvs, "app_exe") == "" && len(apps) == 0 {
 		return containerMPI.Container, fmt.Errorf("Application executable is not defined")
 	}
 
 	// Put together the container's metadata
 	var containerBuildEnv buildenv.Info
-	var cleanup func()
+	var cleanup func(bool)
 
 	switch kv.GetValue(kvs, mpiModelKey) {
 	case container.HybridModel:
@@ -217,28 +601,131 @@ func ContainerizeApp(sysCfg *sys.Config) (container.Config, error) {
 		}
 	}
 
+	// failed is reset once the container is successfully created
+	failed := true
 	if cleanup != nil {
-		defer cleanup()
+		defer func() {
+			cleanup(failed)
+		}()
+	}
+
+	err = setContainerPath(kvs, &containerMPI, &containerBuildEnv, sysCfg)
+	if err != nil {
+		return containerMPI.Container, err
 	}
 
 	containerMPI.Buildenv = containerBuildEnv
 
 	// Load some generic data
 	curTime := time.Now()
+	// The registry is either the name of a registry of the tool's configuration file or a URL
 	url := kv.GetValue(kvs, "registry")
+	if r, ok := sysCfg.Registries[url]; ok {
+		url = r.URL
+	}
 	if url != "" && string(url[len(url)-1]) != "/" {
 		url = url + "/"
 	}
-	sysCfg.Registry = url + kv.GetValue(kvs, "app_name") + ":" + curTime.Format("20060102")
+	repo := url + kv.GetValue(kvs, "app_name")
+	if sysCfg.BaseImageDigest == "" && kv.GetValue(kvs, baseImageDigestKey) != "" {
+		err = container.ValidateDigest(kv.GetValue(kvs, baseImageDigestKey))
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("invalid %s: %s", baseImageDigestKey, err)
+		}
+		sysCfg.BaseImageDigest = kv.GetValue(kvs, baseImageDigestKey)
+	}
+	if kv.GetValue(kvs, resolveBaseImageKey) != "" {
+		resolve, err := strconv.ParseBool(kv.GetValue(kvs, resolveBaseImageKey))
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("invalid %s: %s", resolveBaseImageKey, err)
+		}
+		sysCfg.ResolveBaseImage = sysCfg.ResolveBaseImage || resolve
+	}
+	if kv.GetValue(kvs, dockerfileKey) != "" {
+		dockerfile, err := strconv.ParseBool(kv.GetValue(kvs, dockerfileKey))
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("invalid %s: %s", dockerfileKey, err)
+		}
+		sysCfg.Dockerfile = sysCfg.Dockerfile || dockerfile
+	}
+	tagPolicy := sysCfg.TagPolicy
+	if tagPolicy == "" {
+		tagPolicy = kv.GetValue(kvs, tagPolicyKey)
+	}
+	var tags []string
+	if sysCfg.Upload {
+		tagInfo := container.TagInfo{
+			Version: kv.GetValue(kvs, appVersionKey),
+			Date:    curTime,
+		}
+		if app.IsLocalDir(kv.GetValue(kvs, "app_url")) {
+			tagInfo.SrcDir = app.GetLocalPath(kv.GetValue(kvs, "app_url"))
+		}
+		tags, err = container.GetTags(tagPolicy, &tagInfo)
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("failed to get the tags of the image: %s", err)
+		}
+		sysCfg.Registry = repo + ":" + tags[0]
+	} else {
+		sysCfg.Registry = repo + ":" + curTime.Format("20060102")
+	}
 
 	// Load the app configuration
+	outputArtifacts := app.ParseOutputArtifacts(kv.GetValue(kvs, outputArtifactsKey))
 	var app appConfig
 	app.info.Name = kv.GetValue(kvs, "app_name")
 	app.info.Source = kv.GetValue(kvs, "app_url")
 	app.tarball = path.Base(app.info.Source)
 	app.info.BinName = kv.GetValue(kvs, "app_exe")
 	app.info.InstallCmd = kv.GetValue(kvs, "app_compile_cmd")
-	if app.info.Source == "" {
+	app.buildStrategy = kv.GetValue(kvs, buildStrategyKey)
+	if sysCfg.QuickBuild {
+		app.buildStrategy = QuickBuildStrategy
+	}
+	app.info.Type = appType
+	app.apps = apps
+	app.mirrorHostMPI = sysCfg.MirrorHostMPI
+	if app.mirrorHostMPI == "" {
+		app.mirrorHostMPI = kv.GetValue(kvs, mirrorHostMPIKey)
+	}
+	app.mpiPrefix = kv.GetValue(kvs, containerMPIPrefixKey)
+	if app.mpiPrefix == "" {
+		app.mpiPrefix = sysCfg.ContainerMPIPrefix
+	}
+	if app.mpiPrefix != "" {
+		err = container.ValidateMPIPrefix(app.mpiPrefix)
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("invalid MPI prefix: %s", err)
+		}
+	}
+	app.info.ThreadLevel = kv.GetValue(kvs, requiredThreadLevelKey)
+	app.info.OutputArtifacts = outputArtifacts
+	app.info.Bench, err = getBench(kvs)
+	if err != nil {
+		return containerMPI.Container, err
+	}
+	if app.info.ThreadLevel != "" {
+		err = implem.ValidateThreadLevel(app.info.ThreadLevel)
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("invalid %s: %s", requiredThreadLevelKey, err)
+		}
+	}
+	containerMPI.Container.BuildArgs, err = container.ParseBuildArgs(kv.GetValue(kvs, buildArgsKey))
+	if err != nil {
+		return containerMPI.Container, fmt.Errorf("invalid %s: %s", buildArgsKey, err)
+	}
+	containerMPI.Container.BuildEnv, err = loadBuildEnv(kvs)
+	if err != nil {
+		return containerMPI.Container, err
+	}
+	app.info.Python.Version = kv.GetValue(kvs, pythonVersionKey)
+	app.info.Python.Requirements = kv.GetValue(kvs, pythonRequirementsKey)
+	app.info.Python.PipInstall = kv.GetValue(kvs, pipInstallKey)
+	app.info.Python.CondaInstall = kv.GetValue(kvs, condaInstallKey)
+	if app.info.Python.Requirements != "" && !util.FileExists(app.info.Python.Requirements) {
+		return containerMPI.Container, fmt.Errorf("requirements file %s does not exist", app.info.Python.Requirements)
+	}
+	if app.info.Source == "" && !app.info.IsPython() && len(app.apps) == 0 {
 		return containerMPI.Container, fmt.Errorf("application's URL is not defined")
 	}
 	if app.tarball == "" {
@@ -247,6 +734,34 @@ func ContainerizeApp(sysCfg *sys.Config) (container.Config, error) {
 	if app.info.InstallCmd == "" {
 		log.Println("-> Application does not need the execution of an install command")
 	}
+	if app.buildStrategy != "" && !app.isLayered() {
+		return containerMPI.Container, fmt.Errorf("unsupported build strategy: %s", app.buildStrategy)
+	}
+	if app.isLayered() && containerMPI.Container.Model != container.HybridModel {
+		log.Printf("-> The %s build strategy is only supported with the hybrid model, building the container from a single definition file\n", app.buildStrategy)
+		app.buildStrategy = ""
+	}
+	if kv.GetValue(kvs, hostOptimizedKey) != "" {
+		app.hostOptimized, err = strconv.ParseBool(kv.GetValue(kvs, hostOptimizedKey))
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("invalid %s: %s", hostOptimizedKey, err)
+		}
+	}
+	app.checkpointTool = kv.GetValue(kvs, checkpointToolKey)
+	if app.checkpointTool != "" {
+		err = checkpoint.Validate(app.checkpointTool)
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("invalid %s: %s", checkpointToolKey, err)
+		}
+		// The tool is built with the MPI of the container
+		if containerMPI.Container.Model != container.HybridModel || app.info.IsBinary() {
+			return containerMPI.Container, fmt.Errorf("%s is only supported with the hybrid model", checkpointToolKey)
+		}
+		if app.isLayered() {
+			log.Printf("-> The checkpointing tool is not supported with the %s build strategy, building the container from a single definition file\n", app.buildStrategy)
+			app.buildStrategy = ""
+		}
+	}
 
 	// Generate images
 	log.Println("* Container configuration:")
@@ -269,10 +784,20 @@ func ContainerizeApp(sysCfg *sys.Config) (container.Config, error) {
 		return containerMPI.Container, fmt.Errorf("failed to initialize build environment: %s", err)
 	}
 
-	// Make sure the image already exists, if so, stop, we do not overwrite images, ever
+	// Make sure the image already exists, if so, stop, we do not overwrite images, ever. The image
+	// is only reused when it includes the current tuning file of the site; the base images of
+	// layered builds are checked the same way when they are reused (see BaseImageInfo).
 	if util.FileExists(containerMPI.Container.Path) {
-		fmt.Printf("%s already exists, stopping\n", containerMPI.Container.Path)
-		return containerMPI.Container, nil
+		if kv.GetValue(kvs, "mpi") != "" {
+			metadata, _, err := container.GetMetadata(containerMPI.Container.Path, sysCfg)
+			if err != nil {
+				log.Printf("[WARN] failed to get the metadata of %s, its tuning profile is not checked: %s", containerMPI.Container.Path, err)
+			} else if err := checkTuningLabel(metadata.Tuning, sys.GetTuningProfile(sysCfg, containerMPI.Implem.ID)); err != nil {
+				return containerMPI.Container, fmt.Errorf("%s cannot be reused: %s; remove it to create it again", containerMPI.Container.Path, err)
+			}
+		}
+		failed = false
+		return containerMPI.Container, sympierr.Wrap(sympierr.ErrImageExists, nil, "%s", containerMPI.Container.Path)
 	}
 
 	// Generate definition file
@@ -296,11 +821,55 @@ func ContainerizeApp(sysCfg *sys.Config) (container.Config, error) {
 		deffileData.Backup(&containerBuildEnv)
 	}
 
-	// Create container
+	// Layered builds pin the base image of the cached image they are built on
+	if !app.isLayered() {
+		containerMPI.Container.BaseImage, err = pinBaseImage(containerMPI.Container.DefFile, getBaseImagesFile(), sysCfg)
+		if err != nil {
+			return containerMPI.Container, fmt.Errorf("failed to pin the base image: %s", err)
+		}
+	}
+
+	// The Dockerfile is generated from the final definition file, e.g., with the pinned base image
+	if sysCfg.Dockerfile {
+		if app.isLayered() {
+			log.Printf("[WARN] Dockerfiles are not supported with the %s build strategy\n", app.buildStrategy)
+		} else {
+			contextDir := getDockerContextDir(&containerMPI.Container)
+			err = deffile.CreateDockerfile(containerMPI.Container.DefFile, contextDir, &deffileData)
+			if err != nil {
+				return containerMPI.Container, fmt.Errorf("failed to generate the Dockerfile: %s", err)
+			}
+			fmt.Printf("Dockerfile: %s\n", filepath.Join(contextDir, deffile.DockerfileName))
+		}
+	}
+
+	// Create container; the image is built in the fast scratch root, if any, and then copied back
+	// to its final location
 	log.Println("* Creating container image...")
+	imagePath := containerMPI.Container.Path
+	if buildenv.IsInScratchRoot(containerBuildEnv.ScratchDir, sysCfg) && !buildenv.IsInScratchRoot(imagePath, sysCfg) && !util.FileExists(imagePath) {
+		containerMPI.Container.Path = filepath.Join(containerBuildEnv.ScratchDir, filepath.Base(imagePath))
+	}
 	err = container.Create(&containerMPI.Container, sysCfg)
+	if err == nil && containerMPI.Container.Path != imagePath {
+		err = buildenv.CopyBack(containerMPI.Container.Path, imagePath)
+	}
+	containerMPI.Container.Path = imagePath
 	if err != nil {
-		return containerMPI.Container, fmt.Errorf("failed to create container: %s", err)
+		return containerMPI.Container, fmt.Errorf("failed to create container: %w", err)
+	}
+
+	// Applications silently compiled with another MPI, e.g., the MPI of the Linux distribution,
+	// only fail at run time, the wrapper compiler is therefore checked once the image is created
+	if kv.GetValue(kvs, "mpi") != "" && containerMPI.Container.Model == container.HybridModel && !app.info.IsBinary() {
+		err = verifyWrapperCompiler(&containerMPI.Container, &containerMPI.Implem, deffileData.InternalEnv.InstallDir, sysCfg)
+		if err != nil {
+			// The image is removed so the next build does not reuse it
+			if err := os.Remove(containerMPI.Container.Path); err != nil {
+				log.Printf("[WARN] failed to remove %s: %s", containerMPI.Container.Path, err)
+			}
+			return containerMPI.Container, fmt.Errorf("invalid wrapper compiler in %s: %s", containerMPI.Container.Path, err)
+		}
 	}
 
 	// todo: Upload image if necessary
@@ -314,13 +883,24 @@ func ContainerizeApp(sysCfg *sys.Config) (container.Config, error) {
 			return containerMPI.Container, fmt.Errorf("failed to sign image: %s", err)
 		}
 
-		err = container.Upload(&containerMPI.Container, sysCfg)
+		err = container.UploadTags(&containerMPI.Container, repo, tags, sysCfg)
 		if err != nil {
 			return containerMPI.Container, fmt.Errorf("failed to upload image: %s", err)
 		}
 	}
 
+	// Containers of the workspace are linked to the MPI they require on the host, which is used
+	// to select the MPI to execute them with and to prevent its uninstallation
+	if kv.GetValue(kvs, "mpi") != "" && sys.IsPersistent(sysCfg) && filepath.Dir(containerMPI.Container.Path) == containerBuildEnv.InstallDir {
+		name := strings.TrimPrefix(filepath.Base(containerBuildEnv.InstallDir), sys.ContainerInstallDirPrefix)
+		err = container.RecordMPILink(container.NewMPILink(name, &containerMPI.Container, &containerMPI.Implem))
+		if err != nil {
+			log.Printf("[WARN] failed to link %s to the MPI of the host: %s", name, err)
+		}
+	}
+
 	fmt.Printf("Container image path: %s\n", containerMPI.Container.Path)
 
+	failed = false
 	return containerMPI.Container, nil
 }
@@ -8,7 +8,9 @@ package containerizer
 import (
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
@@ -17,7 +19,7 @@ import (
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
-func getCommonContainerConfiguration(kvs []kv.KV, container *container.Config, sysCfg *sys.Config) (buildenv.Info, func(), error) {
+func getCommonContainerConfiguration(kvs []kv.KV, container *container.Config, sysCfg *sys.Config) (buildenv.Info, func(bool), error) {
 	var containerBuildEnv buildenv.Info
 
 	cleanup, err := buildenv.CreateDefaultContainerEnvCfg(&containerBuildEnv, kvs, sysCfg)
@@ -29,15 +31,6 @@ func getCommonContainerConfiguration(kvs []kv.KV, container *container.Config, s
 	container.Name = kv.GetValue(kvs, "app_name") + ".sif"
 	container.Distro = kv.GetValue(kvs, "distro")
 
-	// These different structures are used during different stage of the creation of the container
-	// so yes we have some duplication in term of value stored in elements of different structures
-	// but this allows us to have fairly independent components without dependency circles.
-	if sysCfg.Persistent == "" {
-		container.Path = filepath.Join(containerBuildEnv.ScratchDir, container.Name)
-	} else {
-		container.Path = filepath.Join(containerBuildEnv.InstallDir, container.Name)
-	}
-
 	container.BuildDir = containerBuildEnv.BuildDir
 	container.InstallDir = containerBuildEnv.InstallDir
 	container.DefFile = filepath.Join(containerBuildEnv.BuildDir, kv.GetValue(kvs, "app_name")+".def")
@@ -49,14 +42,14 @@ func getCommonContainerConfiguration(kvs []kv.KV, container *container.Config, s
 	return containerBuildEnv, cleanup, nil
 }
 
-func getCommonMPIContainerConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.Config) (buildenv.Info, func(), error) {
+func getCommonMPIContainerConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.Config) (buildenv.Info, func(bool), error) {
 	containerMPI.Implem.ID, containerMPI.Implem.Version = sys.ParseDistroID(kv.GetValue(kvs, "mpi"))
 	containerMPI.Implem.URL = getMPIURL(containerMPI.Implem.ID, containerMPI.Implem.Version, sysCfg)
 
 	return getCommonContainerConfiguration(kvs, &containerMPI.Container, sysCfg)
 }
 
-func getHybridConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.Config) (buildenv.Info, func(), error) {
+func getHybridConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.Config) (buildenv.Info, func(bool), error) {
 	containerBuildEnv, cleanup, err := getCommonMPIContainerConfiguration(kvs, containerMPI, sysCfg)
 	if err != nil {
 		return containerBuildEnv, cleanup, err
@@ -65,7 +58,7 @@ func getHybridConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.C
 	return containerBuildEnv, cleanup, nil
 }
 
-func getBindConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.Config) (buildenv.Info, func(), error) {
+func getBindConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.Config) (buildenv.Info, func(bool), error) {
 	containerBuildEnv, cleanup, err := getCommonMPIContainerConfiguration(kvs, containerMPI, sysCfg)
 	if err != nil {
 		return containerBuildEnv, cleanup, err
@@ -73,3 +66,45 @@ func getBindConfiguration(kvs []kv.KV, containerMPI *mpi.Config, sysCfg *sys.Con
 	containerMPI.Container.Model = container.BindModel
 	return containerBuildEnv, cleanup, nil
 }
+
+// setContainerPath sets the name of the image of a container, based on the naming template if
+// any, and its path, in the output directory if any
+func setContainerPath(kvs []kv.KV, containerMPI *mpi.Config, containerBuildEnv *buildenv.Info, sysCfg *sys.Config) error {
+	tmpl := sysCfg.ContainerNameTemplate
+	if tmpl == "" {
+		tmpl = kv.GetValue(kvs, containerNameKey)
+	}
+	if tmpl != "" {
+		info := container.NameInfo{
+			Distro:     containerMPI.Container.Distro,
+			MPIID:      containerMPI.Implem.ID,
+			MPIVersion: containerMPI.Implem.Version,
+			AppName:    kv.GetValue(kvs, "app_name"),
+			Model:      containerMPI.Container.Model,
+			Date:       time.Now(),
+		}
+		name, err := container.ExpandNameTemplate(tmpl, &info)
+		if err != nil {
+			return fmt.Errorf("failed to generate the name of the container: %s", err)
+		}
+		containerMPI.Container.Name = name
+	}
+
+	// These different structures are used during different stage of the creation of the container
+	// so yes we have some duplication in term of value stored in elements of different structures
+	// but this allows us to have fairly independent components without dependency circles.
+	switch {
+	case sysCfg.OutputDir != "":
+		err := os.MkdirAll(sysCfg.OutputDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %s", sysCfg.OutputDir, err)
+		}
+		containerMPI.Container.Path = filepath.Join(sysCfg.OutputDir, containerMPI.Container.Name)
+	case sysCfg.Persistent == "":
+		containerMPI.Container.Path = filepath.Join(containerBuildEnv.ScratchDir, containerMPI.Container.Name)
+	default:
+		containerMPI.Container.Path = filepath.Join(containerBuildEnv.InstallDir, containerMPI.Container.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestIsHostOptimized(t *testing.T) {
+	tests := []struct {
+		name      string
+		app       appConfig
+		buildEnv  []string
+		optimized bool
+	}{
+		{
+			name: "portable build",
+			app:  appConfig{info: app.Info{InstallCmd: "mpicc -O2 -o app app.c"}},
+		},
+		{
+			name:      "compile command",
+			app:       appConfig{info: app.Info{InstallCmd: "mpicc -O3 -march=native -o app app.c"}},
+			optimized: true,
+		},
+		{
+			name:      "build environment",
+			app:       appConfig{info: app.Info{InstallCmd: "make"}},
+			buildEnv:  []string{"CFLAGS=-O3 -xHost"},
+			optimized: true,
+		},
+		{
+			name:      "application of a multi-app container",
+			app:       appConfig{apps: []app.Info{{Name: "netpipe", InstallCmd: "make"}, {Name: "imb", InstallCmd: "make CFLAGS=-mcpu=native"}}},
+			optimized: true,
+		},
+		{
+			name:      "configuration",
+			app:       appConfig{info: app.Info{InstallCmd: "make"}, hostOptimized: true},
+			optimized: true,
+		},
+	}
+	for _, tt := range tests {
+		if tt.app.isHostOptimized(tt.buildEnv) != tt.optimized {
+			t.Fatalf("isHostOptimized() did not return %v for %s", tt.optimized, tt.name)
+		}
+	}
+}
+
+func TestCheckTuningLabel(t *testing.T) {
+	profile := &sys.TuningProfile{MPI: "openmpi", Name: "site-ib", Digest: "sha256:1234"}
+	tests := []struct {
+		label string
+		want  *sys.TuningProfile
+		valid bool
+	}{
+		{label: "", want: nil, valid: true},
+		{label: "site-ib@sha256:1234", want: profile, valid: true},
+		{label: "site-ib@sha256:5678", want: profile},
+		{label: "other@sha256:1234", want: profile},
+		{label: "", want: profile},
+		{label: "site-ib@sha256:1234", want: nil},
+	}
+	for _, tt := range tests {
+		err := checkTuningLabel(tt.label, tt.want)
+		if (err == nil) != tt.valid {
+			t.Fatalf("checkTuningLabel(%q) returned %v", tt.label, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// QuickBuildStrategy is the build strategy where the application is installed on top of a
+	// pre-built base image with the Linux distribution and MPI, one base image being maintained
+	// per distribution and version of MPI
+	QuickBuildStrategy = "quick"
+
+	// baseImageInfoName is the name of the file describing a base image of the quick build strategy
+	baseImageInfoName = "base.json"
+)
+
+// BaseImageInfo describes a base image of the quick build strategy
+type BaseImageInfo struct {
+	// Name is the identifier of the base image, e.g., ubuntu-20.04_openmpi-4.0.2
+	Name string `json:"name"`
+
+	// Distro is the Linux distribution of the image, e.g., ubuntu:20.04
+	Distro string `json:"distro"`
+
+	// MPI is the implementation and version of MPI in the image, e.g., openmpi:4.0.2
+	MPI string `json:"mpi"`
+
+	// MPIDir is the directory where MPI is installed in the image
+	MPIDir string `json:"mpi_dir"`
+
+	// ConfigureArgs are the extra arguments used to configure MPI, e.g., for a thread level
+	ConfigureArgs []string `json:"configure_args,omitempty"`
+
+	// Toolchain is the name of the toolchain used to build MPI, empty for the compilers of the
+	// Linux distribution
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// Tuning is the tuning profile of the site copied in the image and the digest of its file,
+	// e.g., mca-params@sha256:<hex>; empty when none
+	Tuning string `json:"tuning,omitempty"`
+
+	// Created is when the image was built
+	Created time.Time `json:"created"`
+
+	// Path is the path to the image
+	Path string `json:"-"`
+}
+
+// getBaseImagesDir returns the directory where the base images of the quick build strategy are stored
+func getBaseImagesDir() string {
+	return filepath.Join(sys.GetSympiDir(), sys.BaseImagesDir)
+}
+
+// newBaseImageInfo returns the description of the base image required by a hybrid container
+func newBaseImageInfo(deffileCfg *deffile.DefFileData) BaseImageInfo {
+	var info BaseImageInfo
+	info.Name = deffileCfg.DistroID.Name + "-" + deffileCfg.DistroID.Version + "_" + deffileCfg.MpiImplm.ID + "-" + deffileCfg.MpiImplm.Version
+	info.Distro = deffileCfg.DistroID.Name + ":" + deffileCfg.DistroID.Version
+	info.MPI = deffileCfg.MpiImplm.ID + ":" + deffileCfg.MpiImplm.Version
+	info.MPIDir = deffileCfg.InternalEnv.InstallDir
+	info.ConfigureArgs = deffileCfg.MPIConfigureArgs
+	if deffileCfg.Toolchain != nil {
+		info.Toolchain = deffileCfg.Toolchain.Name
+	}
+	if deffileCfg.Tuning != nil {
+		info.Tuning = deffileCfg.Tuning.Label()
+	}
+	return info
+}
+
+// checkCompatible checks whether a base image can be used instead of the one described by want,
+// i.e., MPI is installed in the same directory and built the same way
+func (info *BaseImageInfo) checkCompatible(want *BaseImageInfo) error {
+	if info.MPIDir != want.MPIDir {
+		return fmt.Errorf("MPI is installed in %s instead of %s", info.MPIDir, want.MPIDir)
+	}
+	if strings.Join(info.ConfigureArgs, " ") != strings.Join(want.ConfigureArgs, " ") {
+		return fmt.Errorf("MPI is configured with '%s' instead of '%s'", strings.Join(info.ConfigureArgs, " "), strings.Join(want.ConfigureArgs, " "))
+	}
+	if info.Toolchain != want.Toolchain {
+		return fmt.Errorf("MPI is built with the toolchain '%s' instead of '%s'", info.Toolchain, want.Toolchain)
+	}
+	if info.Tuning != want.Tuning {
+		return fmt.Errorf("the image has the tuning profile '%s' instead of '%s'", info.Tuning, want.Tuning)
+	}
+	return nil
+}
+
+// loadBaseImageInfo loads the description of the base image stored in a directory
+func loadBaseImageInfo(dir string) (BaseImageInfo, error) {
+	var info BaseImageInfo
+	path := filepath.Join(dir, baseImageInfoName)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return info, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	err = json.Unmarshal(content, &info)
+	if err != nil {
+		return info, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	info.Path = filepath.Join(dir, baseImageName)
+	return info, nil
+}
+
+// saveBaseImageInfo saves the description of a base image next to it
+func saveBaseImageInfo(info *BaseImageInfo) error {
+	content, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode the description of %s: %s", info.Name, err)
+	}
+	path := filepath.Join(filepath.Dir(info.Path), baseImageInfoName)
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// ListBaseImages returns the base images of the quick build strategy available in the workspace,
+// sorted by name
+func ListBaseImages() ([]BaseImageInfo, error) {
+	entries, err := ioutil.ReadDir(getBaseImagesDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", getBaseImagesDir(), err)
+	}
+
+	var images []BaseImageInfo
+	for _, e := range entries {
+		dir := filepath.Join(getBaseImagesDir(), e.Name())
+		if !e.IsDir() || !util.FileExists(filepath.Join(dir, baseImageName)) {
+			continue
+		}
+		info, err := loadBaseImageInfo(dir)
+		if err != nil {
+			log.Printf("[WARN] ignoring base image in %s: %s\n", dir, err)
+			continue
+		}
+		images = append(images, info)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+	return images, nil
+}
+
+// RemoveBaseImage removes a base image of the quick build strategy from the workspace, e.g.,
+// ubuntu-20.04_openmpi-4.0.2, so it is built again the next time it is needed
+func RemoveBaseImage(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid base image %s", name)
+	}
+	dir := filepath.Join(getBaseImagesDir(), name)
+	if !util.PathExists(dir) {
+		return fmt.Errorf("base image %s does not exist", name)
+	}
+	unlock := lockBaseImage(QuickBuildStrategy + "/" + name)
+	defer unlock()
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %s", dir, err)
+	}
+	return nil
+}
+
+// getQuickBaseImage returns the path to the pre-built base image with the Linux distribution and
+// MPI of a hybrid container. The image is built if it is not available and the automatic build
+// of the base images is enabled; when the available image does not install or build MPI the way
+// the container requires it, the base image of the layered build strategy is used instead.
+func getQuickBaseImage(deffileCfg *deffile.DefFileData, mpiCfg *mpi.Config, sysCfg *sys.Config) (string, error) {
+	want := newBaseImageInfo(deffileCfg)
+	installDir := filepath.Join(getBaseImagesDir(), want.Name)
+	want.Path = filepath.Join(installDir, baseImageName)
+
+	unlock := lockBaseImage(QuickBuildStrategy + "/" + want.Name)
+	defer unlock()
+
+	if util.FileExists(want.Path) {
+		info, err := loadBaseImageInfo(installDir)
+		if err == nil {
+			err = info.checkCompatible(&want)
+		}
+		if err != nil {
+			log.Printf("-> Base image %s cannot be used (%s), using the %s build strategy\n", want.Name, err, LayeredBuildStrategy)
+			return getBaseImage(deffileCfg, mpiCfg, sysCfg)
+		}
+		log.Printf("-> Using pre-built base image %s\n", want.Path)
+		return want.Path, nil
+	}
+
+	if !sysCfg.BaseImageAutobuild {
+		return "", fmt.Errorf("base image %s is not available in %s and base_image_autobuild is disabled", want.Name, getBaseImagesDir())
+	}
+
+	baseData, pinned, err := createBaseDefFile(deffileCfg, mpiCfg, sysCfg)
+	if err != nil {
+		return "", err
+	}
+	_, err = buildBaseImage(&baseData, pinned, installDir, mpiCfg, sysCfg)
+	if err != nil {
+		return "", err
+	}
+	want.Created = time.Now()
+	err = saveBaseImageInfo(&want)
+	if err != nil {
+		os.RemoveAll(installDir)
+		return "", err
+	}
+	return want.Path, nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/internal/pkg/distro"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestQuickBaseImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	deffileCfg := deffile.DefFileData{
+		DistroID:    distro.ID{Name: "ubuntu", Version: "20.04"},
+		MpiImplm:    &implem.Info{ID: "openmpi", Version: "4.0.2"},
+		InternalEnv: &buildenv.Info{InstallDir: "/opt/openmpi"},
+	}
+	var mpiCfg mpi.Config
+	sysCfg := sys.Config{BaseImageAutobuild: false}
+
+	// The base image is not available and cannot be built
+	_, err = getQuickBaseImage(&deffileCfg, &mpiCfg, &sysCfg)
+	if err == nil {
+		t.Fatalf("a missing base image was not reported")
+	}
+
+	// The base image is pre-built
+	info := newBaseImageInfo(&deffileCfg)
+	if info.Name != "ubuntu-20.04_openmpi-4.0.2" {
+		t.Fatalf("invalid name of the base image: %s", info.Name)
+	}
+	info.Path = filepath.Join(getBaseImagesDir(), info.Name, baseImageName)
+	info.Created = time.Now()
+	err = os.MkdirAll(filepath.Dir(info.Path), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", filepath.Dir(info.Path), err)
+	}
+	err = ioutil.WriteFile(info.Path, []byte("image"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", info.Path, err)
+	}
+	err = saveBaseImageInfo(&info)
+	if err != nil {
+		t.Fatalf("failed to save the description of the base image: %s", err)
+	}
+
+	path, err := getQuickBaseImage(&deffileCfg, &mpiCfg, &sysCfg)
+	if err != nil || path != info.Path {
+		t.Fatalf("failed to get the pre-built base image: %s (%s)", path, err)
+	}
+
+	images, err := ListBaseImages()
+	if err != nil {
+		t.Fatalf("failed to list the base images: %s", err)
+	}
+	if len(images) != 1 || images[0].Name != info.Name || images[0].MPI != "openmpi:4.0.2" || images[0].Path != info.Path {
+		t.Fatalf("invalid list of base images: %v", images)
+	}
+
+	// A container requiring MPI to be configured differently cannot use the base image
+	other := deffileCfg
+	other.MPIConfigureArgs = []string{"--enable-mpi-thread-multiple"}
+	want := newBaseImageInfo(&other)
+	if images[0].checkCompatible(&want) == nil {
+		t.Fatalf("a base image with a different configuration of MPI was accepted")
+	}
+
+	err = RemoveBaseImage(info.Name)
+	if err != nil {
+		t.Fatalf("failed to remove the base image: %s", err)
+	}
+	images, err = ListBaseImages()
+	if err != nil || len(images) != 0 {
+		t.Fatalf("base image still listed after its removal: %v (%v)", images, err)
+	}
+	if RemoveBaseImage("../"+info.Name) == nil {
+		t.Fatalf("an invalid base image was removed")
+	}
+}
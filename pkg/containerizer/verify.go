@@ -0,0 +1,88 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// getWrapperShowCmd returns the command executed in the container to get the path to the wrapper
+// compiler used to compile the applications found in the PATH, e.g., mpicc, and the command line it
+// executes; -show is supported by Open MPI, MPICH and Intel MPI, -showme by the OpenSHMEM wrappers
+func getWrapperShowCmd(compiler string, mpiCfg *implem.Info) string {
+	showFlag := "-show"
+	if implem.IsSHMEM(mpiCfg) {
+		showFlag = "-showme"
+	}
+	return "command -v " + compiler + " && " + compiler + " " + showFlag
+}
+
+// isInPrefix checks whether a path is a MPI installation prefix or one of its sub-directories
+func isInPrefix(path string, prefix string) bool {
+	path = filepath.Clean(path)
+	prefix = filepath.Clean(prefix)
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// checkWrapperOutput checks the output of getWrapperShowCmd, i.e., the path to the wrapper compiler
+// and the command line it executes, against the prefix where MPI is installed in the container:
+// the wrapper compiler must be in the prefix, e.g., <prefix>/bin/mpicc, or in one of its
+// sub-directories with Intel MPI, and, when the command line has include (-I) or library (-L) paths,
+// at least one of each must be in the prefix
+func checkWrapperOutput(output string, compiler string, prefix string) error {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return fmt.Errorf("unexpected output of the %s wrapper: %s", compiler, output)
+	}
+	wrapper := strings.TrimSpace(lines[0])
+	if !isInPrefix(wrapper, prefix) {
+		return fmt.Errorf("%s in the PATH of the container is %s instead of %s, the application was compiled with another MPI", compiler, wrapper, filepath.Join(prefix, "bin", compiler))
+	}
+
+	show := strings.Join(lines[1:], " ")
+	for _, flag := range []string{"-I", "-L"} {
+		var paths []string
+		found := false
+		for _, word := range strings.Fields(show) {
+			if !strings.HasPrefix(word, flag) || len(word) == len(flag) {
+				continue
+			}
+			p := strings.TrimPrefix(word, flag)
+			paths = append(paths, p)
+			if isInPrefix(p, prefix) {
+				found = true
+			}
+		}
+		if len(paths) > 0 && !found {
+			return fmt.Errorf("%s uses the %s paths %s, none of them being in %s: '%s'", compiler, flag, strings.Join(paths, ", "), prefix, show)
+		}
+	}
+	return nil
+}
+
+// verifyWrapperCompiler checks that the wrapper compiler of a hybrid container, used to compile
+// the application, is the one of the MPI installed in the container in a given prefix
+func verifyWrapperCompiler(c *container.Config, mpiCfg *implem.Info, prefix string, sysCfg *sys.Config) error {
+	log.Printf("* Verifying the wrapper compiler of %s...\n", c.Path)
+	compiler := implem.GetCompiler(mpiCfg, false)
+	showCmd := getWrapperShowCmd(compiler, mpiCfg)
+	var cmd syexec.SyCmd
+	cmd.BinPath = sysCfg.SingularityBin
+	cmd.CmdArgs = append(container.GetRuntimeExecCfg(sysCfg), c.Path, "sh", "-c", showCmd)
+	res := cmd.Run()
+	if res.Err != nil {
+		return fmt.Errorf("failed to execute '%s' in %s: %s (stdout: %s; stderr: %s)", showCmd, c.Path, res.Err, res.Stdout, res.Stderr)
+	}
+	return checkWrapperOutput(res.Stdout, compiler, prefix)
+}
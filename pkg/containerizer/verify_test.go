@@ -0,0 +1,46 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"testing"
+)
+
+func TestCheckWrapperOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		valid  bool
+	}{
+		{
+			output: "/opt/openmpi-4.0.2/bin/mpicc\ngcc -I/opt/openmpi-4.0.2/include -pthread -Wl,-rpath -Wl,/opt/openmpi-4.0.2/lib -L/opt/openmpi-4.0.2/lib -lmpi\n",
+			valid:  true,
+		},
+		{
+			// MPI installed in a system directory, without include or library paths
+			output: "/opt/openmpi-4.0.2/bin/mpicc\ngcc -pthread -lmpi\n",
+			valid:  true,
+		},
+		{
+			output: "/usr/bin/mpicc\ngcc -I/usr/lib/x86_64-linux-gnu/openmpi/include -L/usr/lib/x86_64-linux-gnu/openmpi/lib -lmpi\n",
+			valid:  false,
+		},
+		{
+			output: "/opt/openmpi-4.0.2/bin/mpicc\ngcc -I/opt/openmpi-4.0.2-old/include -L/opt/openmpi-4.0.2/lib -lmpi\n",
+			valid:  false,
+		},
+		{
+			output: "/opt/openmpi-4.0.2/bin/mpicc\n",
+			valid:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		err := checkWrapperOutput(tt.output, "mpicc", "/opt/openmpi-4.0.2")
+		if (err == nil) != tt.valid {
+			t.Fatalf("checkWrapperOutput() returned %v for %q", err, tt.output)
+		}
+	}
+}
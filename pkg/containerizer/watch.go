@@ -0,0 +1,144 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// DefaultWatchInterval is the default interval between two checks of the source directory in watch mode
+	DefaultWatchInterval = 2 * time.Second
+)
+
+// getSourceFingerprint returns a hash of the names, sizes and modification times of all the files
+// in a source directory; it changes as soon as a file is added, removed or modified
+func getSourceFingerprint(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		_, err = h.Write([]byte(rel + "\t" + strconv.FormatInt(info.Size(), 10) + "\t" + strconv.FormatInt(info.ModTime().UnixNano(), 10) + "\n"))
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to scan %s: %s", dir, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rebuildApp creates the container of the application, replacing the image created by a previous
+// build. The new image is built in a temporary directory next to the previous one, which is only
+// replaced once the build succeeds so a failed build keeps the last working image.
+func rebuildApp(imgPath string, sysCfg *sys.Config) (string, error) {
+	if imgPath == "" {
+		c, err := ContainerizeApp(sysCfg)
+		if errors.Is(err, sympierr.ErrImageExists) {
+			// First build of the session, the image is only replaced once the sources change
+			fmt.Printf("%s, it will be rebuilt when the sources change\n", err)
+			return c.Path, nil
+		}
+		return c.Path, err
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(imgPath), "."+filepath.Base(imgPath)+"-")
+	if err != nil {
+		return imgPath, fmt.Errorf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	buildCfg := *sysCfg
+	buildCfg.OutputDir = tmpDir
+	c, err := ContainerizeApp(&buildCfg)
+	if err != nil {
+		return imgPath, err
+	}
+	err = os.Rename(c.Path, imgPath)
+	if err != nil {
+		return imgPath, fmt.Errorf("failed to replace %s: %s", imgPath, err)
+	}
+	return imgPath, nil
+}
+
+// WatchApp creates the container of an application whose source is a local directory and
+// rebuilds it every time the sources change, until stop is closed. When the hybrid model is
+// used with the layered or the quick build strategy, the base image with MPI is cached and only the
+// application is rebuilt.
+func WatchApp(sysCfg *sys.Config, interval time.Duration, stop <-chan struct{}) error {
+	kvs, err := configparser.Load(sysCfg.AppContainizer)
+	if err != nil {
+		return fmt.Errorf("impossible to load configuration file: %s", err)
+	}
+	source := kv.GetValue(kvs, "app_url")
+	if !app.IsLocalDir(source) {
+		return fmt.Errorf("watch mode requires the application's URL to be a local directory, e.g., file:///path/to/src")
+	}
+	if strategy := kv.GetValue(kvs, buildStrategyKey); strategy != LayeredBuildStrategy && strategy != QuickBuildStrategy && !sysCfg.QuickBuild {
+		log.Printf("-> Use the %s build strategy to only rebuild the application when the sources change\n", LayeredBuildStrategy)
+	}
+	srcDir := app.GetLocalPath(source)
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	fingerprint, err := getSourceFingerprint(srcDir)
+	if err != nil {
+		return err
+	}
+	imgPath, err := rebuildApp("", sysCfg)
+	if err != nil {
+		fmt.Printf("Build failed: %s\n", err)
+	}
+
+	fmt.Printf("Watching %s for changes...\n", srcDir)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		newFingerprint, err := getSourceFingerprint(srcDir)
+		if err != nil {
+			return err
+		}
+		if newFingerprint == fingerprint {
+			continue
+		}
+		fingerprint = newFingerprint
+
+		fmt.Printf("Sources changed, rebuilding %s...\n", filepath.Base(srcDir))
+		imgPath, err = rebuildApp(imgPath, sysCfg)
+		if err != nil {
+			// A build failure is usually a compilation error, we wait for the next change
+			fmt.Printf("Build failed: %s\n", err)
+		}
+	}
+}
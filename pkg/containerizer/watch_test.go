@@ -0,0 +1,51 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package containerizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestRebuildAppFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-watch-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgPath := filepath.Join(dir, "app.sif")
+	err = ioutil.WriteFile(imgPath, []byte("image"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", imgPath, err)
+	}
+
+	// The configuration of the application is invalid so the build fails
+	var sysCfg sys.Config
+	sysCfg.AppContainizer = filepath.Join(dir, "app.conf")
+	err = ioutil.WriteFile(sysCfg.AppContainizer, []byte("app_url=file:///tmp/src\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", sysCfg.AppContainizer, err)
+	}
+	path, err := rebuildApp(imgPath, &sysCfg)
+	if err == nil || path != imgPath {
+		t.Fatalf("rebuildApp() succeeded with an invalid configuration: %s", path)
+	}
+
+	// The image of the previous build is kept and no temporary directory is left
+	data, err := ioutil.ReadFile(imgPath)
+	if err != nil || string(data) != "image" {
+		t.Fatalf("the image of the previous build was not kept: %s (%v)", data, err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("invalid content of %s after the failed build: %v (%v)", dir, entries, err)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deps
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/ldd"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// ProviderHost is the provider of the libraries found on the host, including the ones bound
+	// from the host in a container
+	ProviderHost = "host"
+
+	// ProviderContainer is the provider of the libraries found in the image of a container
+	ProviderContainer = "container"
+
+	// ProviderMissing is the provider of the libraries that cannot be found
+	ProviderMissing = "missing"
+
+	lddNotFound         = "not found"
+	lddLibrarySeparator = "=>"
+)
+
+// Library is a shared library a binary depends on
+type Library struct {
+	// Name is the name of the library, e.g., libmpi.so.40
+	Name string
+
+	// Path is the path to the library, in the container for a container, empty when missing
+	Path string
+
+	// Provider specifies where the library comes from, i.e., ProviderHost, ProviderContainer or
+	// ProviderMissing
+	Provider string
+
+	// HostPath is the path to a missing library found on the host, which means the library is
+	// not made available in the container, e.g., because a directory is not bound
+	HostPath string
+}
+
+// Report gathers the shared libraries a binary depends on
+type Report struct {
+	// Binary is the path to the binary, in the container for a container
+	Binary string
+
+	// Image is the path to the image of the container, empty for a binary on the host
+	Image string
+
+	// Libraries is the list of the libraries the binary depends on, in the order ldd lists them
+	Libraries []Library
+
+	// Packages is the list of the packages of the Linux distribution providing the libraries of a
+	// binary on the host, empty when the package manager is not supported
+	Packages []string
+}
+
+// ParseLddOutput returns the libraries listed by ldd, the libraries found being attributed to a
+// given provider. The virtual library of the kernel (vDSO), which is not a file, is not listed.
+func ParseLddOutput(output string, provider string) []Library {
+	var libs []Library
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// We drop the load address, e.g., '/lib64/libc.so.6 (0x00007f3a1c000000)'
+		tokens := strings.SplitN(line, lddLibrarySeparator, 2)
+		if len(tokens) == 1 {
+			// Libraries without name, e.g., '/lib64/ld-linux-x86-64.so.2 (0x00007f3a1c000000)'
+			path := strings.TrimSpace(strings.Split(tokens[0], " (")[0])
+			if filepath.IsAbs(path) {
+				libs = append(libs, Library{Name: filepath.Base(path), Path: path, Provider: provider})
+			}
+			continue
+		}
+		lib := Library{Name: strings.TrimSpace(tokens[0]), Provider: provider}
+		path := strings.TrimSpace(tokens[1])
+		if path == lddNotFound {
+			lib.Provider = ProviderMissing
+		} else {
+			lib.Path = strings.TrimSpace(strings.Split(path, " (")[0])
+		}
+		libs = append(libs, lib)
+	}
+	return libs
+}
+
+// runLdd runs a ldd command and returns its output
+func runLdd(bin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute %s %s: %s (stderr: %s)", bin, strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// GetHostReport returns the shared libraries a binary of the host depends on, with the packages
+// providing them when the package manager of the Linux distribution is supported
+func GetHostReport(binary string) (*Report, error) {
+	if !util.FileExists(binary) {
+		return nil, fmt.Errorf("%s does not exist", binary)
+	}
+	output, err := runLdd("ldd", binary)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{Binary: binary, Libraries: ParseLddOutput(output, ProviderHost)}
+	mod, err := ldd.Detect()
+	if err == nil {
+		r.Packages = mod.GetDependencies(output)
+	}
+	return r, nil
+}
+
+// isInDir checks whether a path is in a directory or one of its sub-directories
+func isInDir(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// GetContainerReport returns the shared libraries a binary of a container depends on. The binary
+// is analyzed in the container executed with the exec arguments of the container runtime, e.g.,
+// the directories bound from the host; the libraries found in boundDirs, i.e., the directories of
+// the container bound from the host, are attributed to the host, the other ones to the container.
+func GetContainerReport(image string, binary string, execArgs []string, boundDirs []string, sysCfg *sys.Config) (*Report, error) {
+	args := append(append([]string{}, execArgs...), image, "ldd", binary)
+	output, err := runLdd(sysCfg.SingularityBin, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{Binary: binary, Image: image, Libraries: ParseLddOutput(output, ProviderContainer)}
+	for i := range r.Libraries {
+		for _, dir := range boundDirs {
+			if r.Libraries[i].Path != "" && isInDir(r.Libraries[i].Path, dir) {
+				r.Libraries[i].Provider = ProviderHost
+			}
+		}
+	}
+	return r, nil
+}
+
+// LocateMissing looks for the missing libraries in directories of the host, e.g., the lib
+// directory of MPI, and records where they are found
+func (r *Report) LocateMissing(dirs []string) {
+	for i := range r.Libraries {
+		if r.Libraries[i].Provider != ProviderMissing {
+			continue
+		}
+		for _, dir := range dirs {
+			path := filepath.Join(dir, r.Libraries[i].Name)
+			if util.FileExists(path) {
+				r.Libraries[i].HostPath = path
+				break
+			}
+		}
+	}
+}
+
+// Missing returns the libraries that cannot be found
+func (r *Report) Missing() []Library {
+	var missing []Library
+	for _, lib := range r.Libraries {
+		if lib.Provider == ProviderMissing {
+			missing = append(missing, lib)
+		}
+	}
+	return missing
+}
+
+// String returns a human-readable report, the libraries being grouped by provider
+func (r *Report) String() string {
+	s := "Dependencies of " + r.Binary
+	if r.Image != "" {
+		s += " in " + r.Image
+	}
+	s += ":\n"
+
+	for _, provider := range []string{ProviderContainer, ProviderHost, ProviderMissing} {
+		var libs []string
+		for _, lib := range r.Libraries {
+			if lib.Provider != provider {
+				continue
+			}
+			switch {
+			case lib.Path != "":
+				libs = append(libs, "\t"+lib.Name+" => "+lib.Path)
+			case lib.HostPath != "":
+				libs = append(libs, "\t"+lib.Name+" (available on the host in "+lib.HostPath+")")
+			default:
+				libs = append(libs, "\t"+lib.Name)
+			}
+		}
+		if len(libs) == 0 {
+			continue
+		}
+		sort.Strings(libs)
+		switch provider {
+		case ProviderContainer:
+			s += "Satisfied by the container:\n"
+		case ProviderHost:
+			s += "Satisfied by the host:\n"
+		case ProviderMissing:
+			s += "Missing:\n"
+		}
+		s += strings.Join(libs, "\n") + "\n"
+	}
+
+	if len(r.Packages) > 0 {
+		s += "Packages: " + strings.Join(r.Packages, ", ") + "\n"
+	}
+	return s
+}
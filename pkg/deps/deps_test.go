@@ -0,0 +1,65 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package deps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const lddOutput = `	linux-vdso.so.1 (0x00007ffd3c5f2000)
+	libmpi.so.40 => /opt/openmpi/lib/libmpi.so.40 (0x00007f3a1c200000)
+	libopen-rte.so.40 => not found
+	libc.so.6 => /lib/x86_64-linux-gnu/libc.so.6 (0x00007f3a1c000000)
+	/lib64/ld-linux-x86-64.so.2 (0x00007f3a1c400000)
+`
+
+func TestGetContainerReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-deps-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "libopen-rte.so.40"), nil, 0644)
+	if err != nil {
+		t.Fatalf("failed to create library: %s", err)
+	}
+
+	fake := syexec.NewFakeRunner()
+	fake.On("singularity exec app.sif ldd /opt/app/bin/app", syexec.FakeResult{Stdout: lddOutput})
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	var sysCfg sys.Config
+	sysCfg.SingularityBin = "singularity"
+	r, err := GetContainerReport("app.sif", "/opt/app/bin/app", []string{"exec", "--bind", "/host/openmpi:/opt/openmpi"}, []string{"/opt/openmpi"}, &sysCfg)
+	if err != nil {
+		t.Fatalf("GetContainerReport() failed: %s", err)
+	}
+	r.LocateMissing([]string{dir})
+
+	expected := []Library{
+		{Name: "libmpi.so.40", Path: "/opt/openmpi/lib/libmpi.so.40", Provider: ProviderHost},
+		{Name: "libopen-rte.so.40", Provider: ProviderMissing, HostPath: filepath.Join(dir, "libopen-rte.so.40")},
+		{Name: "libc.so.6", Path: "/lib/x86_64-linux-gnu/libc.so.6", Provider: ProviderContainer},
+		{Name: "ld-linux-x86-64.so.2", Path: "/lib64/ld-linux-x86-64.so.2", Provider: ProviderContainer},
+	}
+	if !reflect.DeepEqual(r.Libraries, expected) {
+		t.Fatalf("GetContainerReport() returned %v instead of %v", r.Libraries, expected)
+	}
+	if len(r.Missing()) != 1 || !strings.Contains(r.String(), "Missing:\n\tlibopen-rte.so.40 (available on the host in ") {
+		t.Fatalf("invalid report:\n%s", r)
+	}
+	if !strings.Contains(strings.Join(fake.CmdLines(), "\n"), "singularity exec --bind /host/openmpi:/opt/openmpi app.sif ldd /opt/app/bin/app") {
+		t.Fatalf("invalid commands: %v", fake.CmdLines())
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SchemaVersion is the version of the format of the events, which is only incremented when
+	// the format changes in a way that is not backward compatible, e.g., a field is renamed
+	SchemaVersion = 1
+
+	// ExperimentStarted is the type of the events emitted when the execution of an experiment
+	// starts, e.g., the execution of a container with 'sympi -run'
+	ExperimentStarted = "experiment_started"
+
+	// PhaseCompleted is the type of the events emitted when a phase successfully completed, e.g.,
+	// the installation of MPI on the host
+	PhaseCompleted = "phase_completed"
+
+	// BuildFailed is the type of the events emitted when the installation of MPI on the host or
+	// the creation of a container failed
+	BuildFailed = "build_failed"
+
+	// RunPassed and RunFailed are the types of the events emitted when an experiment completed
+	RunPassed = "run_passed"
+	RunFailed = "run_failed"
+
+	// PhaseInstall, PhaseBuild and PhaseRun are the phases of the events: the installation of MPI
+	// on the host, the creation of a container and the execution of an experiment
+	PhaseInstall = "install"
+	PhaseBuild   = "build"
+	PhaseRun     = "run"
+
+	// fdPrefix is the prefix of the targets of the events that are file descriptors, e.g., fd:3
+	fdPrefix = "fd:"
+)
+
+// Event is a progress event, written as a line of JSON in the event stream
+type Event struct {
+	// Version is the version of the format of the event, i.e., SchemaVersion
+	Version int `json:"version"`
+
+	// Time is when the event was emitted
+	Time time.Time `json:"time"`
+
+	// Type is the type of the event, e.g., ExperimentStarted
+	Type string `json:"type"`
+
+	// Phase is the phase the event refers to, e.g., PhaseBuild
+	Phase string `json:"phase,omitempty"`
+
+	// Experiment is the name of the experiment, e.g., '4.0.2-3.1.4' or the container executed
+	// with 'sympi -run'
+	Experiment string `json:"experiment,omitempty"`
+
+	// Target is what the phase installs or creates, e.g., openmpi:4.0.2
+	Target string `json:"target,omitempty"`
+
+	// Current and Total are the number of the experiment and the total number of experiments
+	// when a set of experiments is executed, e.g., by the scheduler
+	Current int `json:"current,omitempty"`
+	Total   int `json:"total,omitempty"`
+
+	// ErrorCategory is the classification of the failure of an experiment, if any
+	ErrorCategory string `json:"error_category,omitempty"`
+
+	// Error is the error message of a failure, if any
+	Error string `json:"error,omitempty"`
+
+	// Duration is the time it took to complete the phase
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+var (
+	streamLock sync.Mutex
+	stream     io.WriteCloser
+)
+
+// openTarget opens the target of the event stream: a file, which is created if it does not
+// exist and appended to otherwise, or an open file descriptor, e.g., fd:3
+func openTarget(target string) (io.WriteCloser, error) {
+	if strings.HasPrefix(target, fdPrefix) {
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, fdPrefix))
+		if err != nil || fd < 1 {
+			return nil, fmt.Errorf("invalid file descriptor %s", target)
+		}
+		return os.NewFile(uintptr(fd), target), nil
+	}
+	f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", target, err)
+	}
+	return f, nil
+}
+
+// Open starts the emission of the events to a file or a file descriptor, e.g., fd:3; the events
+// are appended to the file when it already exists
+func Open(target string) error {
+	w, err := openTarget(target)
+	if err != nil {
+		return err
+	}
+
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if stream != nil {
+		stream.Close()
+	}
+	stream = w
+	return nil
+}
+
+// Close stops the emission of the events
+func Close() error {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if stream == nil {
+		return nil
+	}
+	err := stream.Close()
+	stream = nil
+	return err
+}
+
+// Enabled checks whether the events are emitted
+func Enabled() bool {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	return stream != nil
+}
+
+// Emit writes an event in the event stream, if any, with a single write so that the lines of
+// concurrent emitters are not interleaved. Emitting events is not critical, failures are only
+// logged.
+func Emit(e Event) {
+	streamLock.Lock()
+	defer streamLock.Unlock()
+	if stream == nil {
+		return
+	}
+
+	e.Version = SchemaVersion
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[WARN] failed to encode event: %s", err)
+		return
+	}
+	_, err = stream.Write(append(data, '\n'))
+	if err != nil {
+		log.Printf("[WARN] failed to emit event: %s", err)
+	}
+}
+
+// EmitBuild emits the event of the completion of the installation of MPI on the host or of the
+// creation of a container, i.e., PhaseCompleted or BuildFailed depending on err
+func EmitBuild(phase string, target string, start time.Time, err error) {
+	e := Event{Type: PhaseCompleted, Phase: phase, Target: target, Duration: time.Since(start)}
+	if err != nil {
+		e.Type = BuildFailed
+		e.Error = err.Error()
+	}
+	Emit(e)
+}
+
+// EmitRun emits the event of the completion of an experiment, i.e., RunPassed or RunFailed
+// depending on pass
+func EmitRun(experiment string, pass bool, errorCategory string, errorMsg string, start time.Time) {
+	e := Event{Type: RunPassed, Phase: PhaseRun, Experiment: experiment, Duration: time.Since(start)}
+	if !pass {
+		e.Type = RunFailed
+		e.ErrorCategory = errorCategory
+		e.Error = errorMsg
+	}
+	Emit(e)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-events-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Nothing is emitted until the stream is opened
+	Emit(Event{Type: ExperimentStarted})
+	if Enabled() {
+		t.Fatalf("events are emitted without stream")
+	}
+
+	path := filepath.Join(dir, "events.jsonl")
+	err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() failed: %s", err)
+	}
+	start := time.Now()
+	Emit(Event{Type: ExperimentStarted, Phase: PhaseRun, Experiment: "4.0.2-3.1.4", Current: 1, Total: 2})
+	EmitBuild(PhaseBuild, "openmpi:3.1.4", start, fmt.Errorf("make failed"))
+	EmitRun("4.0.2-3.1.4", true, "", "", start)
+	err = Close()
+	if err != nil {
+		t.Fatalf("Close() failed: %s", err)
+	}
+	Emit(Event{Type: ExperimentStarted})
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	expected := []string{ExperimentStarted, BuildFailed, RunPassed}
+	if len(lines) != len(expected) {
+		t.Fatalf("%d events were emitted instead of %d: %s", len(lines), len(expected), data)
+	}
+	for i, line := range lines {
+		var e Event
+		err := json.Unmarshal([]byte(line), &e)
+		if err != nil {
+			t.Fatalf("invalid event %s: %s", line, err)
+		}
+		if e.Type != expected[i] || e.Version != SchemaVersion || e.Time.IsZero() {
+			t.Fatalf("invalid event %s, expected a %s event", line, expected[i])
+		}
+	}
+	if !strings.Contains(lines[1], `"error":"make failed"`) || !strings.Contains(lines[0], `"current":1`) {
+		t.Fatalf("invalid details of the events: %s", data)
+	}
+
+	if Open("fd:abc") == nil || Open(filepath.Join(dir, "missing", "events.jsonl")) == nil {
+		t.Fatalf("Open() succeeded with an invalid target")
+	}
+}
@@ -5,6 +5,11 @@
 
 package implem
 
+import (
+	"strconv"
+	"strings"
+)
+
 const (
 	// OMPI is the identifier for Open MPI
 	OMPI = "openmpi"
@@ -15,6 +20,12 @@ const (
 	// IMPI is the identifier for Intel MPI
 	IMPI = "intel"
 
+	// OSHMEM is the identifier for OSHMEM, the OpenSHMEM implementation of Open MPI
+	OSHMEM = "oshmem"
+
+	// SOS is the identifier for Sandia OpenSHMEM
+	SOS = "sos"
+
 	// Singularity is the identifier for Singularity
 	SY = "singularity"
 )
@@ -34,11 +45,110 @@ type Info struct {
 	Tarball string
 }
 
+// mpiIDs is the set of identifiers of the implementations of MPI
+var mpiIDs = map[string]bool{OMPI: true, MPICH: true, IMPI: true}
+
+// RegisterMPI adds an identifier to the set of implementations of MPI, it is used when
+// implementations are registered (see the mpiplugin package)
+func RegisterMPI(id string) {
+	mpiIDs[id] = true
+}
+
 // IsMPI checks if information passed in is an MPI implementation
 func IsMPI(i *Info) bool {
-	if i != nil && (i.ID == OMPI || i.ID == MPICH || i.ID == IMPI) {
+	if i != nil && mpiIDs[i.ID] {
 		return true
 	}
 
 	return false
 }
+
+// shmemIDs is the set of identifiers of the implementations of OpenSHMEM; they are handled like
+// the implementations of MPI but their applications are compiled with the OpenSHMEM wrapper
+// compilers and started with oshrun
+var shmemIDs = map[string]bool{OSHMEM: true, SOS: true}
+
+// IsSHMEM checks if information passed in is an OpenSHMEM implementation
+func IsSHMEM(i *Info) bool {
+	return i != nil && shmemIDs[i.ID]
+}
+
+// GetCompiler returns the wrapper compiler used to compile the C, or Fortran, applications with
+// an implementation, e.g., mpicc or oshcc for OpenSHMEM
+func GetCompiler(i *Info, fortran bool) string {
+	switch {
+	case IsSHMEM(i) && fortran:
+		return "oshfort"
+	case IsSHMEM(i):
+		return "oshcc"
+	case fortran:
+		return "mpif90"
+	}
+	return "mpicc"
+}
+
+// parseVersion converts a version string (e.g., 4.0.2 or 3.4b1) into a slice of integers.
+// Only the leading digits of each component are considered.
+func parseVersion(version string) ([]int, bool) {
+	var numbers []int
+	for _, token := range strings.Split(version, ".") {
+		i := 0
+		for i < len(token) && token[i] >= '0' && token[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return nil, false
+		}
+		n, err := strconv.Atoi(token[:i])
+		if err != nil {
+			return nil, false
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, true
+}
+
+// CompareVersions compares two versions and returns -1 if v1 is older than v2, 0 if the two
+// versions are identical and 1 if v1 is newer than v2. Versions that cannot be parsed, e.g.,
+// master, are considered newer than any release.
+func CompareVersions(v1 string, v2 string) int {
+	n1, ok1 := parseVersion(v1)
+	n2, ok2 := parseVersion(v2)
+	switch {
+	case !ok1 && !ok2:
+		return 0
+	case !ok1:
+		return 1
+	case !ok2:
+		return -1
+	}
+
+	for i := 0; i < len(n1) || i < len(n2); i++ {
+		var c1, c2 int
+		if i < len(n1) {
+			c1 = n1[i]
+		}
+		if i < len(n2) {
+			c2 = n2[i]
+		}
+		if c1 < c2 {
+			return -1
+		}
+		if c1 > c2 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// VersionInRange checks whether a version is in the [minVersion, maxVersion) range. An empty
+// bound means the range is not bounded on that side.
+func VersionInRange(version string, minVersion string, maxVersion string) bool {
+	if minVersion != "" && CompareVersions(version, minVersion) < 0 {
+		return false
+	}
+	if maxVersion != "" && CompareVersions(version, maxVersion) >= 0 {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package implem
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		v1       string
+		v2       string
+		expected int
+	}{
+		{v1: "4.0.2", v2: "4.0.2", expected: 0},
+		{v1: "4.0", v2: "4.0.0", expected: 0},
+		{v1: "3.1.4", v2: "4.0.0", expected: -1},
+		{v1: "4.0.10", v2: "4.0.9", expected: 1},
+		{v1: "3.4b1", v2: "3.4.0", expected: 0},
+		{v1: "master", v2: "5.0.0", expected: 1},
+		{v1: "3.3.2", v2: "master", expected: -1},
+	}
+
+	for _, tt := range tests {
+		res := CompareVersions(tt.v1, tt.v2)
+		if res != tt.expected {
+			t.Fatalf("CompareVersions(%s, %s) returned %d instead of %d", tt.v1, tt.v2, res, tt.expected)
+		}
+	}
+}
+
+func TestCompareThreadLevels(t *testing.T) {
+	tests := []struct {
+		l1       string
+		l2       string
+		expected int
+	}{
+		{l1: ThreadMultiple, l2: ThreadMultiple, expected: 0},
+		{l1: ThreadSingle, l2: ThreadFunneled, expected: -1},
+		{l1: ThreadSerialized, l2: ThreadMultiple, expected: -1},
+		{l1: ThreadMultiple, l2: ThreadFunneled, expected: 1},
+	}
+
+	for _, tt := range tests {
+		res := CompareThreadLevels(tt.l1, tt.l2)
+		if res != tt.expected {
+			t.Fatalf("CompareThreadLevels(%s, %s) returned %d instead of %d", tt.l1, tt.l2, res, tt.expected)
+		}
+	}
+
+	if ValidateThreadLevel("MPI_THREAD_MULTIPLE") == nil {
+		t.Fatalf("ValidateThreadLevel() accepted an invalid thread level")
+	}
+}
+
+func TestGetCompiler(t *testing.T) {
+	tests := []struct {
+		id       string
+		fortran  bool
+		expected string
+	}{
+		{id: OMPI, fortran: false, expected: "mpicc"},
+		{id: MPICH, fortran: true, expected: "mpif90"},
+		{id: OSHMEM, fortran: false, expected: "oshcc"},
+		{id: SOS, fortran: true, expected: "oshfort"},
+	}
+
+	for _, tt := range tests {
+		res := GetCompiler(&Info{ID: tt.id}, tt.fortran)
+		if res != tt.expected {
+			t.Fatalf("GetCompiler(%s, %v) returned %s instead of %s", tt.id, tt.fortran, res, tt.expected)
+		}
+	}
+	if IsSHMEM(&Info{ID: OMPI}) || !IsSHMEM(&Info{ID: OSHMEM}) || IsSHMEM(nil) {
+		t.Fatalf("IsSHMEM() does not identify the OpenSHMEM implementations")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package implem
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ThreadSingle is the MPI_THREAD_SINGLE thread level
+	ThreadSingle = "single"
+
+	// ThreadFunneled is the MPI_THREAD_FUNNELED thread level
+	ThreadFunneled = "funneled"
+
+	// ThreadSerialized is the MPI_THREAD_SERIALIZED thread level
+	ThreadSerialized = "serialized"
+
+	// ThreadMultiple is the MPI_THREAD_MULTIPLE thread level
+	ThreadMultiple = "multiple"
+)
+
+// threadLevels is the list of the thread levels, from the lowest to the highest
+var threadLevels = []string{ThreadSingle, ThreadFunneled, ThreadSerialized, ThreadMultiple}
+
+// getThreadLevelIndex returns the rank of a thread level, -1 when the level is unknown
+func getThreadLevelIndex(level string) int {
+	for i, l := range threadLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidateThreadLevel checks whether a thread level is valid, e.g., multiple
+func ValidateThreadLevel(level string) error {
+	if getThreadLevelIndex(level) < 0 {
+		return fmt.Errorf("unknown thread level %s, supported levels: %s", level, strings.Join(threadLevels, ", "))
+	}
+	return nil
+}
+
+// CompareThreadLevels compares two valid thread levels: it returns -1 if the first one is lower than
+// the second one, 1 if it is higher and 0 if they are identical
+func CompareThreadLevels(level1 string, level2 string) int {
+	i1 := getThreadLevelIndex(level1)
+	i2 := getThreadLevelIndex(level2)
+	switch {
+	case i1 < i2:
+		return -1
+	case i1 > i2:
+		return 1
+	}
+	return 0
+}
@@ -6,12 +6,14 @@
 package jm
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/gvallee/go_util/pkg/util"
 	"github.com/sylabs/singularity-mpi/internal/pkg/job"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
@@ -52,3 +54,23 @@ func TestTempFile(t *testing.T) {
 		t.Fatalf("temporary file %s still exists even after cleanup", j.BatchScript)
 	}
 }
+
+func TestPrepareStdSubmit(t *testing.T) {
+	var j job.Job
+	var sycmd syexec.SyCmd
+	var env buildenv.Info
+	sysCfg := sys.Config{SingularityBin: "/usr/local/bin/singularity"}
+
+	j.Container = &container.Config{Path: "/tmp/app.sif"}
+	j.App.BinPath = "/opt/app"
+	j.App.Args = []string{"-n", "10", "input file.dat"}
+
+	err := prepareStdSubmit(&sycmd, &j, &env, &sysCfg)
+	if err != nil {
+		t.Fatalf("prepareStdSubmit() failed: %s", err)
+	}
+	cmd := strings.Join(sycmd.CmdArgs, "|")
+	if sycmd.BinPath != sysCfg.SingularityBin || !strings.HasSuffix(cmd, "|/tmp/app.sif|/opt/app|-n|10|input file.dat") {
+		t.Fatalf("invalid command: %s %s", sycmd.BinPath, strings.Join(sycmd.CmdArgs, " "))
+	}
+}
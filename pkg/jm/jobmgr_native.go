@@ -10,14 +10,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 
-	"github.com/sylabs/singularity-mpi/internal/pkg/impi"
 	"github.com/sylabs/singularity-mpi/internal/pkg/job"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
@@ -37,18 +37,18 @@ func NativeGetConfig() error {
 }
 
 func getEnvPath(mpiCfg *implem.Info, env *buildenv.Info) string {
-	// Intel MPI is installing the binaries and libraries in a quite complex setup
-	if mpiCfg != nil && mpiCfg.ID == implem.IMPI {
-		return filepath.Join(env.InstallDir, impi.IntelInstallPathPrefix, "bin") + ":" + os.Getenv("PATH")
+	// The layout of the installation is specific to the implementation of MPI
+	if mpiCfg != nil {
+		return mpiplugin.Get(mpiCfg.ID).EnvPath(env)
 	}
 
 	return env.GetEnvPath()
 }
 
 func getEnvLDPath(mpiCfg *implem.Info, env *buildenv.Info) string {
-	// Intel MPI is installing the binaries and libraries in a quite complex setup
-	if mpiCfg != nil && mpiCfg.ID == implem.IMPI {
-		return filepath.Join(env.InstallDir, impi.IntelInstallPathPrefix, "lib") + ":" + os.Getenv("LD_LIBRARY_PATH")
+	// The layout of the installation is specific to the implementation of MPI
+	if mpiCfg != nil {
+		return mpiplugin.Get(mpiCfg.ID).EnvLDPath(env)
 	}
 
 	return env.GetEnvLDPath()
@@ -65,22 +65,25 @@ func NativeGetError(j *job.Job, sysCfg *sys.Config) string {
 }
 
 func prepareMPISubmit(sycmd *syexec.SyCmd, j *job.Job, env *buildenv.Info, sysCfg *sys.Config) error {
+	var launchInfo mpi.LaunchInfo
 	var err error
-	sycmd.BinPath, err = mpi.GetPathToMpirun(j.HostCfg, env)
+	launchInfo.Mpirun, err = mpi.GetPathToMpirun(j.HostCfg, env)
 	if err != nil {
 		return err
 	}
-	if j.NP > 0 {
-		sycmd.CmdArgs = append(sycmd.CmdArgs, "-np")
-		sycmd.CmdArgs = append(sycmd.CmdArgs, strconv.Itoa(j.NP))
-	}
+	launchInfo.NP = j.NP
+	launchInfo.Hostfile = j.Hostfile
+	launchInfo.Rankfile = j.Rankfile
 
-	mpirunArgs, err := mpi.GetMpirunArgs(j.HostCfg, env, &j.App, j.Container, sysCfg)
+	launchInfo.Cmd, err = mpi.GetMpirunArgs(j.HostCfg, env, &j.App, j.Container, sysCfg)
 	if err != nil {
 		return fmt.Errorf("unable to get mpirun arguments: %s", err)
 	}
-	if len(mpirunArgs) > 0 {
-		sycmd.CmdArgs = append(sycmd.CmdArgs, mpirunArgs...)
+	launchInfo.Cmd = j.Wrap(launchInfo.Cmd)
+
+	sycmd.BinPath, sycmd.CmdArgs, err = mpi.ExpandLaunchTemplate(mpi.GetLaunchTemplate(j.HostCfg, sysCfg), &launchInfo)
+	if err != nil {
+		return fmt.Errorf("unable to generate the launch command: %s", err)
 	}
 
 	newPath := getEnvPath(j.HostCfg, env)
@@ -91,14 +94,44 @@ func prepareMPISubmit(sycmd *syexec.SyCmd, j *job.Job, env *buildenv.Info, sysCf
 	log.Printf("Using %s as LD_LIBRARY_PATH\n", newLDPath)
 	sycmd.Env = append([]string{"LD_LIBRARY_PATH=" + newLDPath}, os.Environ()...)
 	sycmd.Env = append([]string{"PATH=" + newPath}, os.Environ()...)
+	// With the bind model, MPI is used from another directory in the container
+	relocationEnv := mpi.GetRelocationEnv(j.HostCfg, env, j.Container, sysCfg)
+	if len(relocationEnv) > 0 {
+		log.Printf("-> Relocation environment: %s\n", strings.Join(relocationEnv, " "))
+		sycmd.Env = append(sycmd.Env, relocationEnv...)
+	}
+	tuningEnv := mpi.GetTuningEnv(j.HostCfg, sysCfg)
+	if len(tuningEnv) > 0 {
+		log.Printf("-> Tuning environment: %s\n", strings.Join(tuningEnv, " "))
+		sycmd.Env = append(sycmd.Env, tuningEnv...)
+	}
+
+	// In the tool-in-container mode, MPI was built in the builder container and mpirun is
+	// therefore executed there, mpirun executing the container runtime of the host when it is
+	// not installed in a system directory (otherwise, the runtime of the builder image is used)
+	builder := syexec.NewBuilderContainer(sysCfg, env.InstallDir, filepath.Dir(j.Container.Path), getRuntimePrefix(sysCfg.SingularityBin))
+	if builder != nil {
+		sycmd.BinPath, sycmd.CmdArgs, sycmd.Env = builder.Wrap(sycmd.BinPath, sycmd.CmdArgs, "", sycmd.Env)
+	}
 
 	return nil
 }
 
+// getRuntimePrefix returns the installation directory of the container runtime of a binary,
+// e.g., /opt/singularity for /opt/singularity/bin/singularity
+func getRuntimePrefix(bin string) string {
+	if bin == "" {
+		return ""
+	}
+	return filepath.Dir(filepath.Dir(bin))
+}
+
 func prepareStdSubmit(sycmd *syexec.SyCmd, j *job.Job, env *buildenv.Info, sysCfg *sys.Config) error {
-	sycmd.BinPath = sysCfg.SingularityBin
-	sycmd.CmdArgs = container.GetDefaultExecCfg()
-	sycmd.CmdArgs = append(sycmd.CmdArgs, j.Container.Path, j.App.BinPath)
+	cmd := append([]string{sysCfg.SingularityBin}, container.GetRuntimeExecCfg(sysCfg)...)
+	cmd = append(cmd, j.Container.Path, j.App.BinPath)
+	cmd = j.Wrap(append(cmd, j.App.Args...))
+	sycmd.BinPath = cmd[0]
+	sycmd.CmdArgs = cmd[1:]
 
 	return nil
 }
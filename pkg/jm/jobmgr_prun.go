@@ -15,6 +15,7 @@ import (
 	"github.com/sylabs/singularity-mpi/internal/pkg/job"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
@@ -66,6 +67,7 @@ func PrunSubmit(j *job.Job, env *buildenv.Info, sysCfg *sys.Config) (syexec.SyCm
 	sycmd.CmdArgs = append(sycmd.CmdArgs, "SY_EXEC_ARGS")
 	sycmd.CmdArgs = append(sycmd.CmdArgs, j.Container.Path)
 	sycmd.CmdArgs = append(sycmd.CmdArgs, j.Container.AppExe)
+	sycmd.CmdArgs = append(sycmd.CmdArgs, j.App.Args...)
 
 	// Get the exec arguments and set the env var
 	execArgs := container.GetMPIExecCfg(j.HostCfg, env, j.Container, sysCfg)
@@ -82,6 +84,8 @@ func PrunSubmit(j *job.Job, env *buildenv.Info, sysCfg *sys.Config) (syexec.SyCm
 	sycmd.Env = append([]string{"LD_LIBRARY_PATH=" + newLDPath}, os.Environ()...)
 	sycmd.Env = append([]string{"PATH=" + newPath}, sycmd.Env...)
 	sycmd.Env = append([]string{syExecArgsEnv}, sycmd.Env...)
+	sycmd.Env = append(sycmd.Env, mpi.GetRelocationEnv(j.HostCfg, env, j.Container, sysCfg)...)
+	sycmd.Env = append(sycmd.Env, mpi.GetTuningEnv(j.HostCfg, sysCfg)...)
 
 	j.GetOutput = PrunGetOutput
 	j.GetError = PrunGetError
@@ -6,6 +6,7 @@
 package jm
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -19,6 +20,7 @@ import (
 	"github.com/sylabs/singularity-mpi/internal/pkg/slurm"
 	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
 	"github.com/sylabs/singularity-mpi/pkg/mpi"
 	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
@@ -87,7 +89,7 @@ func SlurmSetConfig() error {
 // SlurmLoad is the function called when trying to load a JM module
 func SlurmLoad(jm *JM, sysCfg *sys.Config) error {
 	log.Println("* Slurm detected, updating the configuration file")
-	kvs, err := kv.LoadKeyValueConfig(sysCfg.SyConfigFile)
+	kvs, err := configparser.Load(sysCfg.SyConfigFile)
 	if err != nil {
 		return fmt.Errorf("unable to load configuration from %s: %s", sysCfg.SyConfigFile, err)
 	}
@@ -149,7 +151,7 @@ func generateJobScript(j *job.Job, env *buildenv.Info, sysCfg *sys.Config, kvs [
 	// Create the batch script
 	err := TempFile(j, env, sysCfg)
 	if err != nil {
-		if err == sympierr.ErrFileExists {
+		if errors.Is(err, sympierr.ErrFileExists) {
 			log.Printf("* Script %s already esists, skipping\n", j.BatchScript)
 			return nil
 		}
@@ -180,15 +182,40 @@ func generateJobScript(j *job.Job, env *buildenv.Info, sysCfg *sys.Config, kvs [
 
 	// Set PATH and LD_LIBRARY_PATH
 	scriptText += "\nexport PATH=" + env.InstallDir + "/bin:$PATH\n"
-	scriptText += "export LD_LIBRARY_PATH=" + env.InstallDir + "/lib:$LD_LIBRARY_PATH\n\n"
-
-	// Add the mpirun command
-	mpirunPath := filepath.Join(env.InstallDir, "bin", "mpirun")
-	mpirunArgs, err := mpi.GetMpirunArgs(j.HostCfg, env, &j.App, j.Container, sysCfg)
+	scriptText += "export LD_LIBRARY_PATH=" + env.InstallDir + "/lib:$LD_LIBRARY_PATH\n"
+	for _, e := range mpi.GetRelocationEnv(j.HostCfg, env, j.Container, sysCfg) {
+		scriptText += "export " + e + "\n"
+	}
+	for _, e := range mpi.GetTuningEnv(j.HostCfg, sysCfg) {
+		scriptText += "export " + e + "\n"
+	}
+	scriptText += "\n"
+
+	// Add the mpirun command; the number of ranks is handled by Slurm
+	var launchInfo mpi.LaunchInfo
+	launchInfo.Mpirun = filepath.Join(env.InstallDir, "bin", "mpirun")
+	launchInfo.Hostfile = j.Hostfile
+	launchInfo.Rankfile = j.Rankfile
+	launchInfo.Cmd, err = mpi.GetMpirunArgs(j.HostCfg, env, &j.App, j.Container, sysCfg)
 	if err != nil {
 		return fmt.Errorf("unable to get mpirun arguments: %s", err)
 	}
-	scriptText += "\n" + mpirunPath + " " + strings.Join(mpirunArgs, " ") + "\n"
+	launchInfo.Cmd = j.Wrap(launchInfo.Cmd)
+	launcherBin, launcherArgs, err := mpi.ExpandLaunchTemplate(mpi.GetLaunchTemplate(j.HostCfg, sysCfg), &launchInfo)
+	if err != nil {
+		return fmt.Errorf("unable to generate the launch command: %s", err)
+	}
+	// In the tool-in-container mode, MPI was built in the builder container and mpirun is
+	// therefore executed there, like with the native job manager; PATH is passed to the container
+	builder := syexec.NewBuilderContainer(sysCfg, env.InstallDir, filepath.Dir(j.Container.Path), getRuntimePrefix(sysCfg.SingularityBin))
+	if builder != nil {
+		scriptText += "export " + sys.GetContainerEnvPrefix(sysCfg.SingularityBin) + "PATH=$PATH\n"
+		launcherBin, launcherArgs, _ = builder.Wrap(launcherBin, launcherArgs, "", nil)
+	}
+	for i := range launcherArgs {
+		launcherArgs[i] = quoteScriptArg(launcherArgs[i])
+	}
+	scriptText += "\n" + launcherBin + " " + strings.Join(launcherArgs, " ") + "\n"
 
 	err = ioutil.WriteFile(j.BatchScript, []byte(scriptText), 0644)
 	if err != nil {
@@ -198,6 +225,15 @@ func generateJobScript(j *job.Job, env *buildenv.Info, sysCfg *sys.Config, kvs [
 	return nil
 }
 
+// quoteScriptArg quotes an argument of a command of a batch script when it has characters
+// interpreted by the shell, e.g., the shell command of a wrapper
+func quoteScriptArg(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"'$`\\;&|<>*?()") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
 // SlurmSubmit prepares the batch script necessary to start a given job.
 //
 // Note that a script does not need any specific environment to be submitted
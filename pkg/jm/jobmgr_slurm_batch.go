@@ -0,0 +1,246 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package jm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/slurm"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/scheduler"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// batchScriptPrefix is the prefix of the name of the script of an experiment submitted in batch
+	batchScriptPrefix = "experiment-"
+
+	// batchArrayScript is the name of the script submitted as a job array
+	batchArrayScript = "experiments-array.sh"
+
+	// batchResultsDir is the name of the directory where the jobs write the result of their experiment
+	batchResultsDir = "results"
+
+	// batchLogsDir is the name of the directory with the output and error files of the jobs
+	batchLogsDir = "logs"
+)
+
+// BatchCmdFn is a "function pointer" returning the command to execute a given experiment in a job
+type BatchCmdFn func(*scheduler.Experiment, *sys.Config) ([]string, error)
+
+// SlurmBatch gathers the experiments that are submitted to Slurm instead of being executed
+// serially on the current node. Each experiment is rendered into its own batch script that
+// writes the result of the experiment in a result file once the job terminates.
+type SlurmBatch struct {
+	// Dir is the directory where the scripts, the output of the jobs and the results are stored
+	Dir string
+
+	// Cmd returns the command to execute a given experiment
+	Cmd BatchCmdFn
+
+	// Scripts is the ordered list of the batch scripts of the experiments
+	Scripts []string
+
+	kvs []kv.KV
+}
+
+// NewSlurmBatch creates a new batch of experiments to submit to Slurm. Since the jobs are executed
+// after the MPI implementations and containers are built, the persistent mode is required.
+func NewSlurmBatch(dir string, cmd BatchCmdFn, sysCfg *sys.Config) (*SlurmBatch, error) {
+	if !sys.IsPersistent(sysCfg) {
+		return nil, fmt.Errorf("batch submission requires the persistent mode")
+	}
+
+	for _, d := range []string{dir, filepath.Join(dir, batchResultsDir), filepath.Join(dir, batchLogsDir)} {
+		err := os.MkdirAll(d, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %s", d, err)
+		}
+	}
+
+	b := new(SlurmBatch)
+	b.Dir = dir
+	b.Cmd = cmd
+
+	// The configuration is optional, it only specifies the partition
+	if sysCfg.SyConfigFile != "" {
+		kvs, err := configparser.Load(sysCfg.SyConfigFile)
+		if err != nil {
+			log.Printf("[WARN] unable to load configuration from %s: %s", sysCfg.SyConfigFile, err)
+		}
+		b.kvs = kvs
+	}
+
+	return b, nil
+}
+
+func getBatchResultFile(dir string, idx int) string {
+	return filepath.Join(dir, batchResultsDir, batchScriptPrefix+strconv.Itoa(idx)+".txt")
+}
+
+// getSbatchHeader returns the Slurm directives common to all the scripts of a batch
+func (b *SlurmBatch) getSbatchHeader(name string) string {
+	header := "#!/bin/bash\n#\n"
+	partition := kv.GetValue(b.kvs, slurm.PartitionKey)
+	if partition != "" {
+		header += slurm.ScriptCmdPrefix + " --partition=" + partition + "\n"
+	}
+	header += slurm.ScriptCmdPrefix + " --job-name=" + name + "\n"
+	return header
+}
+
+// Add renders an experiment into a batch script
+func (b *SlurmBatch) Add(e *scheduler.Experiment, sysCfg *sys.Config) error {
+	cmd, err := b.Cmd(e, sysCfg)
+	if err != nil {
+		return fmt.Errorf("unable to get the command of the experiment: %s", err)
+	}
+	if len(cmd) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	idx := len(b.Scripts)
+	name := batchScriptPrefix + strconv.Itoa(idx)
+	r := e.NewResult()
+
+	script := b.getSbatchHeader(name)
+	script += slurm.ScriptCmdPrefix + " --output=" + filepath.Join(b.Dir, batchLogsDir, name+".out") + "\n"
+	script += slurm.ScriptCmdPrefix + " --error=" + filepath.Join(b.Dir, batchLogsDir, name+".err") + "\n\n"
+	script += strings.Join(cmd, " ") + "\n"
+	script += "if [ $? -eq 0 ]; then STATUS=PASS; else STATUS=FAIL; fi\n"
+	script += "printf \"%s\\t%s\\n\" \"" + results.GetKey(&r) + "\" \"$STATUS\" > " + getBatchResultFile(b.Dir, idx) + "\n"
+
+	path := filepath.Join(b.Dir, name+".sh")
+	err = ioutil.WriteFile(path, []byte(script), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to write to file %s: %s", path, err)
+	}
+	b.Scripts = append(b.Scripts, path)
+
+	return nil
+}
+
+// Run adds an experiment to the batch, it can be used as scheduler.Ops.Run to render a plan
+// into batch scripts; the result of the experiment is only available after the job terminated.
+func (b *SlurmBatch) Run(e *scheduler.Experiment, sysCfg *sys.Config) results.Result {
+	r := e.NewResult()
+	err := b.Add(e, sysCfg)
+	if err != nil {
+		r.Note = fmt.Sprintf("failed to create batch script: %s", err)
+		return r
+	}
+	r.Note = "submitted in batch"
+	return r
+}
+
+// generateArrayScript creates the script to submit all the experiments of the batch as a job array
+func (b *SlurmBatch) generateArrayScript() (string, error) {
+	script := b.getSbatchHeader("experiments")
+	script += slurm.ScriptCmdPrefix + " --array=0-" + strconv.Itoa(len(b.Scripts)-1) + "\n"
+	script += slurm.ScriptCmdPrefix + " --output=" + filepath.Join(b.Dir, batchLogsDir, "array-%a.out") + "\n"
+	script += slurm.ScriptCmdPrefix + " --error=" + filepath.Join(b.Dir, batchLogsDir, "array-%a.err") + "\n\n"
+	script += "bash " + filepath.Join(b.Dir, batchScriptPrefix) + "${SLURM_ARRAY_TASK_ID}.sh\n"
+
+	path := filepath.Join(b.Dir, batchArrayScript)
+	err := ioutil.WriteFile(path, []byte(script), 0755)
+	if err != nil {
+		return "", fmt.Errorf("unable to write to file %s: %s", path, err)
+	}
+	return path, nil
+}
+
+// parseSbatchOutput returns the job ID from the output of 'sbatch --parsable', i.e., "<jobid>[;<cluster>]"
+func parseSbatchOutput(output string) string {
+	return strings.Split(strings.TrimSpace(output), ";")[0]
+}
+
+func sbatch(script string) (string, error) {
+	var cmd syexec.SyCmd
+	cmd.BinPath = "sbatch"
+	cmd.CmdArgs = []string{"--parsable", script}
+	res := cmd.Run()
+	if res.Err != nil {
+		return "", fmt.Errorf("failed to submit %s: %s (stdout: %s; stderr: %s)", script, res.Err, res.Stdout, res.Stderr)
+	}
+	return parseSbatchOutput(res.Stdout), nil
+}
+
+// Submit submits the experiments of the batch, as a single job array or as individual jobs, and
+// returns the IDs of the submitted jobs
+func (b *SlurmBatch) Submit(array bool) ([]string, error) {
+	var jobIDs []string
+
+	if len(b.Scripts) == 0 {
+		return nil, fmt.Errorf("no experiment to submit")
+	}
+
+	if array {
+		script, err := b.generateArrayScript()
+		if err != nil {
+			return nil, err
+		}
+		id, err := sbatch(script)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("* %d experiment(s) submitted as job array %s\n", len(b.Scripts), id)
+		return []string{id}, nil
+	}
+
+	for _, script := range b.Scripts {
+		id, err := sbatch(script)
+		if err != nil {
+			return jobIDs, err
+		}
+		jobIDs = append(jobIDs, id)
+	}
+	log.Printf("* %d experiment(s) submitted as individual jobs\n", len(b.Scripts))
+
+	return jobIDs, nil
+}
+
+// CollectSlurmBatchResults gathers the result files written by the jobs of a batch into a
+// unified results file and returns the results, as well as the number of experiments that did
+// not complete yet. It can be executed as many times as needed while jobs are running.
+func CollectSlurmBatchResults(dir string, outputFile string) ([]results.Result, int, error) {
+	var collected []results.Result
+	pending := 0
+
+	scripts, err := filepath.Glob(filepath.Join(dir, batchScriptPrefix+"*.sh"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list the scripts in %s: %s", dir, err)
+	}
+
+	for idx := 0; idx < len(scripts); idx++ {
+		resultFile := getBatchResultFile(dir, idx)
+		if !util.FileExists(resultFile) {
+			pending++
+			continue
+		}
+		r, err := results.Load(resultFile)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to load %s: %s", resultFile, err)
+		}
+		collected = append(collected, r...)
+	}
+
+	err = results.Save(outputFile, collected)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return collected, pending, nil
+}
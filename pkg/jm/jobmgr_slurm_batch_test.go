@@ -0,0 +1,94 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package jm
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/scheduler"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestSlurmBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-batch-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var sysCfg sys.Config
+	_, err = NewSlurmBatch(dir, nil, &sysCfg)
+	if err == nil {
+		t.Fatalf("NewSlurmBatch() succeeded in non-persistent mode")
+	}
+
+	// The experiment with the container for Open MPI 3.1.4 fails
+	cmd := func(e *scheduler.Experiment, sysCfg *sys.Config) ([]string, error) {
+		if e.ContainerMPI.Version == "3.1.4" {
+			return []string{"false"}, nil
+		}
+		return []string{"true"}, nil
+	}
+	sysCfg.Persistent = dir
+	b, err := NewSlurmBatch(dir, cmd, &sysCfg)
+	if err != nil {
+		t.Fatalf("NewSlurmBatch() failed: %s", err)
+	}
+
+	exps := []scheduler.Experiment{
+		{HostMPI: implem.Info{ID: implem.OMPI, Version: "4.0.2"}, ContainerMPI: implem.Info{ID: implem.OMPI, Version: "4.0.2"}},
+		{HostMPI: implem.Info{ID: implem.OMPI, Version: "4.0.2"}, ContainerMPI: implem.Info{ID: implem.OMPI, Version: "3.1.4"}},
+		{App: "lolcow.sif"},
+	}
+	for i := range exps {
+		err = b.Add(&exps[i], &sysCfg)
+		if err != nil {
+			t.Fatalf("Add() failed: %s", err)
+		}
+	}
+
+	// Run the first two jobs, the Slurm directives being comments for bash
+	for _, script := range b.Scripts[:2] {
+		err = exec.Command("bash", script).Run()
+		if err != nil {
+			t.Fatalf("failed to execute %s: %s", script, err)
+		}
+	}
+
+	outputFile := filepath.Join(dir, "results.txt")
+	res, pending, err := CollectSlurmBatchResults(dir, outputFile)
+	if err != nil {
+		t.Fatalf("CollectSlurmBatchResults() failed: %s", err)
+	}
+	if pending != 1 {
+		t.Fatalf("%d pending experiment(s) instead of 1", pending)
+	}
+	if len(res) != 2 || !res[0].Pass || res[1].Pass || res[1].ContainerMPI.Version != "3.1.4" {
+		t.Fatalf("invalid results: %v", res)
+	}
+
+	arrayScript, err := b.generateArrayScript()
+	if err != nil {
+		t.Fatalf("generateArrayScript() failed: %s", err)
+	}
+	content, err := ioutil.ReadFile(arrayScript)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", arrayScript, err)
+	}
+	if !strings.Contains(string(content), "--array=0-2") {
+		t.Fatalf("invalid array script:\n%s", content)
+	}
+
+	if parseSbatchOutput("1234;cluster\n") != "1234" {
+		t.Fatalf("invalid job ID from sbatch output")
+	}
+}
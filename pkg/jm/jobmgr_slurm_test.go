@@ -8,10 +8,14 @@ package jm
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sylabs/singularity-mpi/internal/pkg/job"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
@@ -80,3 +84,35 @@ func TestSlurmSubmit(t *testing.T) {
 	t.Logf("Slurm batch script: %s\n", job.BatchScript)
 
 }
+
+func TestGenerateJobScriptBuilder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-slurm-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var j job.Job
+	j.HostCfg = &implem.Info{ID: implem.MPICH, Version: "3.3.2"}
+	j.Container = &container.Config{Name: "app", Path: filepath.Join(dir, "app.sif"), Model: container.HybridModel}
+	j.App.BinPath = "/opt/app"
+	env := buildenv.Info{InstallDir: filepath.Join(dir, "mpi_install_mpich-3.3.2")}
+	sysCfg := sys.Config{ScratchDir: dir, SingularityBin: "/opt/singularity/bin/singularity", BuilderImage: filepath.Join(dir, "builder.sif")}
+
+	err = generateJobScript(&j, &env, &sysCfg, nil)
+	if err != nil {
+		t.Fatalf("generateJobScript() failed: %s", err)
+	}
+	defer os.Remove(j.BatchScript)
+	content, err := ioutil.ReadFile(j.BatchScript)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", j.BatchScript, err)
+	}
+
+	// mpirun, built in the builder container, is executed there
+	script := string(content)
+	mpirun := filepath.Join(env.InstallDir, "bin", "mpirun")
+	if !strings.Contains(script, "export SINGULARITYENV_PATH=$PATH\n") || !strings.Contains(script, "\n/opt/singularity/bin/singularity exec --bind ") || !strings.Contains(script, sysCfg.BuilderImage+" "+mpirun+" ") {
+		t.Fatalf("mpirun is not executed in the builder container:\n%s", script)
+	}
+}
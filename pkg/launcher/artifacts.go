@@ -0,0 +1,94 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// artifactsFilename is the name of the archive of the build and scratch directories of a failed experiment
+	artifactsFilename = "artifacts.tar.gz"
+)
+
+// getDirSize returns the size in bytes of all the regular files in a directory
+func getDirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// SaveErrorArtifacts archives the build and scratch directories of a failed experiment in the
+// errors directory, next to stdout.txt and stderr.txt. The directories are not archived if their
+// total size exceeds sysCfg.ArtifactsMaxSize.
+func SaveErrorArtifacts(hostMPI *implem.Info, containerMPI *implem.Info, sysCfg *sys.Config, dirs []string) error {
+	var totalSize int64
+	var relDirs []string
+	for _, d := range dirs {
+		if d == "" || !util.IsDir(d) {
+			continue
+		}
+		size, err := getDirSize(d)
+		if err != nil {
+			return fmt.Errorf("failed to get the size of %s: %s", d, err)
+		}
+		totalSize += size
+		// tar is executed from / to preserve the complete path of the directories in the archive
+		relDirs = append(relDirs, strings.TrimPrefix(filepath.Clean(d), "/"))
+	}
+
+	if len(relDirs) == 0 {
+		log.Println("No build or scratch directory to archive")
+		return nil
+	}
+
+	if totalSize > sysCfg.ArtifactsMaxSize {
+		return fmt.Errorf("directories are too big to be archived (%d bytes, limit is %d bytes)", totalSize, sysCfg.ArtifactsMaxSize)
+	}
+
+	targetDir := getErrorDir(hostMPI, containerMPI, sysCfg)
+	if !util.PathExists(targetDir) {
+		err := os.MkdirAll(targetDir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %s", targetDir, err)
+		}
+	}
+
+	tarPath, err := exec.LookPath("tar")
+	if err != nil {
+		return fmt.Errorf("tar is not available: %s", err)
+	}
+
+	archive := filepath.Join(targetDir, artifactsFilename)
+	log.Printf("-> Archiving %s into %s", strings.Join(dirs, ", "), archive)
+	var cmd syexec.SyCmd
+	cmd.BinPath = tarPath
+	cmd.CmdArgs = append([]string{"-czf", archive, "-C", "/"}, relDirs...)
+	res := cmd.Run()
+	if res.Err != nil {
+		return fmt.Errorf("failed to create %s: %s (stdout: %s; stderr: %s)", archive, res.Err, res.Stdout, res.Stderr)
+	}
+
+	return nil
+}
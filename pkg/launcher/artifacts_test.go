@@ -0,0 +1,73 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestSaveErrorArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-artifacts-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	buildDir := filepath.Join(dir, "build")
+	err = os.MkdirAll(buildDir, 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", buildDir, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(buildDir, "config.log"), []byte("configure: error: no acceptable C compiler found"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create configure log: %s", err)
+	}
+
+	hostMPI := implem.Info{ID: implem.OMPI, Version: "4.0.2"}
+	containerMPI := implem.Info{ID: implem.OMPI, Version: "4.0.2"}
+	tests := []struct {
+		name            string
+		maxSize         int64
+		expectedArchive bool
+	}{
+		{
+			name:            "too big",
+			maxSize:         1,
+			expectedArchive: false,
+		},
+		{
+			name:            "archived",
+			maxSize:         1024 * 1024,
+			expectedArchive: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sysCfg sys.Config
+			sysCfg.BinPath = dir
+			sysCfg.ArtifactsMaxSize = tt.maxSize
+			err := SaveErrorArtifacts(&hostMPI, &containerMPI, &sysCfg, []string{buildDir, ""})
+			if tt.expectedArchive && err != nil {
+				t.Fatalf("SaveErrorArtifacts() failed: %s", err)
+			}
+			if !tt.expectedArchive && err == nil {
+				t.Fatalf("SaveErrorArtifacts() succeeded while the size limit is exceeded")
+			}
+			archive := filepath.Join(getErrorDir(&hostMPI, &containerMPI, &sysCfg), artifactsFilename)
+			if util.FileExists(archive) != tt.expectedArchive {
+				t.Fatalf("%s exists: %t", archive, !tt.expectedArchive)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/job"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/checkpoint"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/jm"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// checkpointRun gathers the details of the checkpoint/restart of a job
+type checkpointRun struct {
+	// tool is the checkpointing tool of the container
+	tool *checkpoint.Tool
+
+	// singularityBin is the path to the container runtime executing the commands of the tool
+	singularityBin string
+
+	// image is the path to the image of the container
+	image string
+
+	// execArgs are the arguments of the container runtime executing a command in the container,
+	// e.g., in the runtime mode of the job
+	execArgs []string
+
+	// ckptDir is the directory where the checkpoint is saved
+	ckptDir string
+
+	// port is the port of the coordinator of the tool
+	port int
+}
+
+// getFreePort returns a TCP port currently available on the host
+func getFreePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("failed to find an available port: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// setupCheckpoint prepares the checkpoint/restart of a job: the coordinator of the checkpointing
+// tool of the container is started and the application is executed under the tool. Nothing is
+// done when checkpoint/restart is not requested.
+func setupCheckpoint(j *job.Job, c *container.Config, sysCfg *sys.Config) (*checkpointRun, error) {
+	if sysCfg.CheckpointDelay == 0 {
+		return nil, nil
+	}
+	if c == nil || c.CheckpointTool == "" {
+		return nil, fmt.Errorf("the container does not include a checkpointing tool")
+	}
+
+	tool, err := checkpoint.Get(c.CheckpointTool)
+	if err != nil {
+		return nil, err
+	}
+	port, err := getFreePort()
+	if err != nil {
+		return nil, err
+	}
+	// The directory must be available in the container, /tmp being bound by default
+	ckptDir, err := ioutil.TempDir("", "sympi-checkpoint-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the checkpoint directory: %s", err)
+	}
+
+	ckpt := &checkpointRun{
+		tool:           tool,
+		singularityBin: sysCfg.SingularityBin,
+		image:          c.Path,
+		execArgs:       container.GetRuntimeExecCfg(sysCfg),
+		ckptDir:        ckptDir,
+		port:           port,
+	}
+	err = ckpt.runToolCmd(tool.Coordinator)
+	if err != nil {
+		ckpt.cleanup()
+		return nil, fmt.Errorf("failed to start the coordinator: %s", err)
+	}
+	j.AppWrapper = checkpoint.Expand(tool.Launch, ckptDir, port)
+	return ckpt, nil
+}
+
+// getToolCmdArgs returns the arguments of the container runtime executing a command of the
+// checkpointing tool in the container
+func (c *checkpointRun) getToolCmdArgs(cmd []string) []string {
+	args := append(append([]string{}, c.execArgs...), c.image)
+	return append(args, checkpoint.Expand(cmd, c.ckptDir, c.port)...)
+}
+
+// runToolCmd executes a command of the checkpointing tool in the container
+func (c *checkpointRun) runToolCmd(cmd []string) error {
+	var stdout, stderr bytes.Buffer
+	toolCmd := exec.Command(c.singularityBin, c.getToolCmdArgs(cmd)...)
+	toolCmd.Stdout = &stdout
+	toolCmd.Stderr = &stderr
+	err := syexec.RunCmd(toolCmd)
+	if err != nil {
+		return fmt.Errorf("failed to execute %s: %s (stderr: %s)", strings.Join(cmd, " "), err, stderr.String())
+	}
+	return nil
+}
+
+// cleanup removes the checkpoint
+func (c *checkpointRun) cleanup() {
+	err := os.RemoveAll(c.ckptDir)
+	if err != nil {
+		log.Printf("[WARN] failed to remove %s: %s", c.ckptDir, err)
+	}
+}
+
+// setRestartApp replaces the application of a job by the command restarting it from its
+// checkpoint, the options of the command coming before the directory of the checkpoint, which
+// ends the command. The arguments of the application are restored from the checkpoint and are
+// therefore not passed.
+func setRestartApp(j *job.Job, restart []string) {
+	j.AppWrapper = restart[:len(restart)-1]
+	j.App.BinPath = restart[len(restart)-1]
+	j.App.Args = nil
+}
+
+// run executes a job, checkpoints it once the delay is over, terminates it and restarts it from
+// its checkpoint. The command restarting the job replaces the command of the job so the status and
+// the output of the restarted job are checked like the ones of any job.
+func (c *checkpointRun) run(submitCmd *syexec.SyCmd, j *job.Job, jobmgr *jm.JM, hostEnv *buildenv.Info, expRes *results.Result, sysCfg *sys.Config) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- syexec.RunCmd(submitCmd.Cmd)
+	}()
+
+	select {
+	case err := <-done:
+		expRes.ErrorCategory = results.ErrorCheckpoint
+		if err != nil {
+			return fmt.Errorf("the job failed before the checkpoint: %s", err)
+		}
+		return fmt.Errorf("the job completed before the checkpoint, the application must run longer than %s", sysCfg.CheckpointDelay)
+	case <-time.After(sysCfg.CheckpointDelay):
+	}
+
+	log.Printf("* Checkpointing the job with %s...\n", c.tool.Name)
+	start := time.Now()
+	err := c.runToolCmd(c.tool.Checkpoint)
+	if err != nil {
+		expRes.ErrorCategory = results.ErrorCheckpoint
+		c.runToolCmd(c.tool.Quit)
+		<-done
+		return fmt.Errorf("failed to checkpoint the job: %s", err)
+	}
+	expRes.CheckpointTime = time.Since(start)
+	expRes.CheckpointSize, err = getDirSize(c.ckptDir)
+	if err != nil {
+		log.Printf("[WARN] failed to get the size of the checkpoint: %s", err)
+	}
+
+	// The job is terminated, as if it failed, its status being therefore ignored
+	err = c.runToolCmd(c.tool.Quit)
+	if err != nil {
+		log.Printf("[WARN] failed to terminate the job: %s", err)
+	}
+	<-done
+
+	log.Println("* Restarting the job from its checkpoint...")
+	err = c.runToolCmd(c.tool.Coordinator)
+	if err != nil {
+		expRes.ErrorCategory = results.ErrorRestart
+		return fmt.Errorf("failed to start the coordinator: %s", err)
+	}
+	restart := checkpoint.Expand(c.tool.Restart, c.ckptDir, c.port)
+	var restartCmd syexec.SyCmd
+	if c.tool.RestartWithMPI {
+		setRestartApp(j, restart)
+		restartCmd, err = prepareLaunchCmd(j, jobmgr, hostEnv, sysCfg)
+		if err != nil {
+			expRes.ErrorCategory = results.ErrorRestart
+			return fmt.Errorf("failed to prepare the restart command: %s", err)
+		}
+	} else {
+		restartCmd.Ctx, restartCmd.CancelFn = context.WithTimeout(context.Background(), sys.CmdTimeout*time.Minute)
+		restartCmd.Cmd = exec.CommandContext(restartCmd.Ctx, c.singularityBin, c.getToolCmdArgs(c.tool.Restart)...)
+	}
+	restartCmd.Cmd.Stdout = submitCmd.Cmd.Stdout
+	restartCmd.Cmd.Stderr = submitCmd.Cmd.Stderr
+	submitCmd.CancelFn()
+	*submitCmd = restartCmd
+
+	start = time.Now()
+	err = syexec.RunCmd(restartCmd.Cmd)
+	expRes.RestartTime = time.Since(start)
+	if err != nil {
+		expRes.ErrorCategory = results.ErrorRestart
+		return fmt.Errorf("the restarted job failed: %s", err)
+	}
+	return nil
+}
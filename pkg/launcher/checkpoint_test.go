@@ -0,0 +1,119 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/job"
+	"github.com/sylabs/singularity-mpi/pkg/checkpoint"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestCheckpointRestart(t *testing.T) {
+	tests := []struct {
+		name        string
+		jobDuration time.Duration
+		category    string
+		expected    []string
+	}{
+		{
+			name:        "restarted",
+			jobDuration: 500 * time.Millisecond,
+			expected: []string{
+				"singularity exec --no-home app.sif dmtcp_coordinator --daemon --exit-on-last",
+				"mpirun -np 2 singularity exec app.sif dmtcp_launch --join-coordinator",
+				"singularity exec --no-home app.sif dmtcp_command --coord-port",
+				"singularity exec --no-home app.sif dmtcp_command --coord-port",
+				"singularity exec --no-home app.sif dmtcp_coordinator --daemon --exit-on-last",
+				"singularity exec --no-home app.sif sh",
+			},
+		},
+		{
+			name:     "completed before the checkpoint",
+			category: results.ErrorCheckpoint,
+			expected: []string{
+				"singularity exec --no-home app.sif dmtcp_coordinator --daemon --exit-on-last",
+				"mpirun -np 2 singularity exec app.sif dmtcp_launch --join-coordinator",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		fake := syexec.NewFakeRunner()
+		fake.On("mpirun", syexec.FakeResult{Duration: tt.jobDuration})
+		fake.On("app.sif sh", syexec.FakeResult{Stdout: "Hello, I am rank 0/2"})
+		restore := syexec.SetRunner(fake)
+
+		var sysCfg sys.Config
+		sysCfg.SingularityBin = "singularity"
+		sysCfg.CheckpointDelay = 50 * time.Millisecond
+		c := container.Config{Path: "app.sif", CheckpointTool: checkpoint.DMTCP}
+		j := job.Job{Container: &c}
+		ckpt, err := setupCheckpoint(&j, &c, &sysCfg)
+		if err != nil {
+			t.Fatalf("setupCheckpoint() failed (%s): %s", tt.name, err)
+		}
+
+		var stdout bytes.Buffer
+		var submitCmd syexec.SyCmd
+		submitCmd.Ctx, submitCmd.CancelFn = context.WithCancel(context.Background())
+		submitCmd.Cmd = exec.Command("mpirun", j.Wrap([]string{"-np", "2", "singularity", "exec", "app.sif", "/opt/app"})...)
+		submitCmd.Cmd.Stdout = &stdout
+		var expRes results.Result
+		err = ckpt.run(&submitCmd, &j, nil, nil, &expRes, &sysCfg)
+		ckpt.cleanup()
+		syexec.SetRunner(restore)
+
+		if (err == nil) != (tt.category == "") || expRes.ErrorCategory != tt.category {
+			t.Fatalf("run() returned %v with category %q instead of %q (%s)", err, expRes.ErrorCategory, tt.category, tt.name)
+		}
+		cmdLines := fake.CmdLines()
+		if len(cmdLines) != len(tt.expected) {
+			t.Fatalf("%d commands executed instead of %d (%s): %v", len(cmdLines), len(tt.expected), tt.name, cmdLines)
+		}
+		for i := range tt.expected {
+			if !strings.Contains(cmdLines[i], tt.expected[i]) {
+				t.Fatalf("command %d is %s instead of %s (%s)", i, cmdLines[i], tt.expected[i], tt.name)
+			}
+		}
+		if tt.category == "" && (stdout.String() != "Hello, I am rank 0/2" || submitCmd.Cmd.Args[0] != "singularity") {
+			t.Fatalf("the restarted job did not replace the job: %s (output: %s)", strings.Join(submitCmd.Cmd.Args, " "), stdout.String())
+		}
+	}
+
+	// With MANA, mpirun starts the restart command, which ends with the directory of the checkpoint
+	tool, err := checkpoint.Get(checkpoint.MANA)
+	if err != nil {
+		t.Fatalf("checkpoint.Get() failed: %s", err)
+	}
+	j := job.Job{Container: &container.Config{Path: "app.sif"}}
+	j.App.BinPath = "/opt/app"
+	j.App.Args = []string{"-n", "10"}
+	setRestartApp(&j, checkpoint.Expand(tool.Restart, "/tmp/ckpt", 7779))
+	cmd := strings.Join(j.Wrap(append([]string{"-np", "2", "singularity", "exec", "app.sif", j.App.BinPath}, j.App.Args...)), " ")
+	expected := "-np 2 singularity exec app.sif mana_restart --coord-port 7779 --restartdir /tmp/ckpt"
+	if cmd != expected {
+		t.Fatalf("the restart command is %s instead of %s", cmd, expected)
+	}
+
+	// Containers without checkpointing tool cannot be checkpointed
+	var sysCfg sys.Config
+	sysCfg.CheckpointDelay = time.Second
+	j = job.Job{}
+	_, err = setupCheckpoint(&j, &container.Config{Path: "app.sif"}, &sysCfg)
+	if err == nil {
+		t.Fatalf("setupCheckpoint() succeeded with a container without checkpointing tool")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/job"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// DVM is a persistent distributed virtual machine, i.e., MPI daemons running in a Singularity
+// instance, to which several jobs can be submitted without paying the startup cost of MPI each time
+type DVM struct {
+	// instance is the instance running the daemons
+	instance *container.Instance
+
+	// cmds is the set of commands used to start, use and stop the daemons
+	cmds mpiplugin.DVMCommands
+
+	// uriFile is the file where the daemons report their URI
+	uriFile string
+}
+
+// expandDVMCommand replaces the tags of a DVM command
+func expandDVMCommand(cmd []string, uriFile string, np int) []string {
+	var expanded []string
+	for _, a := range cmd {
+		a = strings.ReplaceAll(a, mpiplugin.DVMURITag, uriFile)
+		a = strings.ReplaceAll(a, mpiplugin.DVMNPTag, strconv.Itoa(np))
+		expanded = append(expanded, a)
+	}
+	return expanded
+}
+
+// shellQuote quotes a string so it is passed as a single argument by the shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// getDVMStartCommand returns the command starting the DVM. When the DVM daemonizes, the process
+// started returns before the daemons are ready, so the daemons report their URI to a named pipe
+// and the command only returns once the URI was read from the pipe and saved in the URI file.
+func getDVMStartCommand(cmds mpiplugin.DVMCommands, uriFile string) []string {
+	if !cmds.ReportsURI {
+		return expandDVMCommand(cmds.Start, uriFile, 0)
+	}
+
+	pipe := uriFile + ".pipe"
+	var start []string
+	for _, a := range expandDVMCommand(cmds.Start, pipe, 0) {
+		start = append(start, shellQuote(a))
+	}
+	script := fmt.Sprintf("rm -f %[1]s && mkfifo %[1]s && %[2]s && head -n 1 %[1]s > %[3]s; rc=$?; rm -f %[1]s; exit $rc",
+		shellQuote(pipe), strings.Join(start, " "), shellQuote(uriFile))
+	return []string{"sh", "-c", script}
+}
+
+// StartDVM starts a container as a Singularity instance and the persistent MPI daemons in it.
+// With the hybrid model, the daemons of the MPI of the container are used; with the bind model,
+// the daemons of the MPI of the host, which is mounted in the container.
+func StartDVM(name string, hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, containerMPI *mpi.Config, sysCfg *sys.Config) (*DVM, error) {
+	if hostMPI == nil || containerMPI == nil {
+		return nil, fmt.Errorf("invalid parameter(s)")
+	}
+
+	dvmMPI := &containerMPI.Implem
+	if containerMPI.Container.Model == container.BindModel {
+		dvmMPI = &hostMPI.Implem
+	}
+	cmds, err := mpiplugin.Get(dvmMPI.ID).DVMCommands(dvmMPI)
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(DVM)
+	d.cmds = cmds
+	// /tmp is shared between the host and the instance
+	d.uriFile = filepath.Join(os.TempDir(), "sympi-dvm-"+name+".uri")
+	d.instance, err = container.StartInstance(name, &hostMPI.Implem, hostBuildEnv, &containerMPI.Container, sysCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("-> Starting %s %s daemons in instance %s\n", dvmMPI.ID, dvmMPI.Version, name)
+	res := d.instance.Exec(sysCfg, getDVMStartCommand(d.cmds, d.uriFile)...)
+	if res.Err != nil {
+		stopErr := d.instance.Stop(sysCfg)
+		if stopErr != nil {
+			log.Printf("[WARN] %s", stopErr)
+		}
+		return nil, fmt.Errorf("failed to start the MPI daemons: %s (stdout: %s; stderr: %s)", res.Err, res.Stdout, res.Stderr)
+	}
+
+	return d, nil
+}
+
+// Run submits a job to the DVM, the job being checked the same way as the jobs started by launcher.Run
+func (d *DVM) Run(appInfo *app.Info, np int, args []string, sysCfg *sys.Config) (results.Result, syexec.Result) {
+	var expRes results.Result
+	expRes.Pass = true
+
+	if np <= 0 {
+		np = 2
+	}
+	bench := appInfo.Bench.WithSeed(appInfo.BinPath, args)
+	expRes.Bench = bench
+	cmd := expandDVMCommand(d.cmds.Submit, d.uriFile, np)
+	cmd = append(cmd, bench.Expand(appInfo.BinPath))
+	cmd = append(cmd, bench.ExpandArgs(args)...)
+	execRes := d.instance.Exec(sysCfg, cmd...)
+
+	if execRes.Err != nil {
+		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorExec
+		log.Printf("[ERROR] Command failed - stdout: %s - stderr: %s - err: %s\n", execRes.Stdout, execRes.Stderr, execRes.Err)
+		return expRes, execRes
+	}
+
+	newjob := job.Job{NP: np}
+	if !expectedOutput(execRes.Stdout, execRes.Stderr, appInfo, &newjob) {
+		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorOutput
+		log.Printf("[ERROR] Run succeeded but output is not matching expectation - stdout: %s - stderr: %s\n", execRes.Stdout, execRes.Stderr)
+	}
+
+	return expRes, execRes
+}
+
+// Stop stops the MPI daemons and the instance running them
+func (d *DVM) Stop(sysCfg *sys.Config) error {
+	if len(d.cmds.Stop) > 0 {
+		res := d.instance.Exec(sysCfg, expandDVMCommand(d.cmds.Stop, d.uriFile, 0)...)
+		if res.Err != nil {
+			// Stopping the instance terminates the daemons anyway
+			log.Printf("[WARN] failed to stop the MPI daemons: %s", res.Err)
+		}
+	}
+	os.Remove(d.uriFile)
+	return d.instance.Stop(sysCfg)
+}
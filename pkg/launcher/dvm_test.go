@@ -0,0 +1,63 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+)
+
+func TestGetDVMStartCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-dvm-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	uriFile := filepath.Join(dir, "dvm.uri")
+
+	// The DVM does not report its URI, the start command is used as is
+	cmds := mpiplugin.DVMCommands{Start: []string{"sh", "-c", "hydra_persist > " + mpiplugin.DVMURITag + " 2>&1 &"}}
+	cmd := getDVMStartCommand(cmds, uriFile)
+	expected := []string{"sh", "-c", "hydra_persist > " + uriFile + " 2>&1 &"}
+	if !reflect.DeepEqual(cmd, expected) {
+		t.Fatalf("getDVMStartCommand() returned %q instead of %q", cmd, expected)
+	}
+
+	// The daemon returns immediately and reports its URI later, the command must wait for it
+	cmds = mpiplugin.DVMCommands{
+		Start:      []string{"sh", "-c", `(sleep 1; echo "dvm-uri;tcp://127.0.0.1:1234" > "$1") &`, "dvm", mpiplugin.DVMURITag},
+		ReportsURI: true,
+	}
+	cmd = getDVMStartCommand(cmds, uriFile)
+	out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to execute %q: %s (%s)", cmd, err, out)
+	}
+	uri, err := ioutil.ReadFile(uriFile)
+	if err != nil {
+		t.Fatalf("the URI file was not created before the command returned: %s", err)
+	}
+	if string(uri) != "dvm-uri;tcp://127.0.0.1:1234\n" {
+		t.Fatalf("invalid URI reported: %q", uri)
+	}
+	if _, err := os.Stat(uriFile + ".pipe"); err == nil {
+		t.Fatalf("the pipe was not removed")
+	}
+
+	// The command fails when the daemon cannot be started
+	cmds.Start = []string{"false", mpiplugin.DVMURITag}
+	cmd = getDVMStartCommand(cmds, uriFile)
+	err = exec.Command(cmd[0], cmd[1:]...).Run()
+	if err == nil {
+		t.Fatalf("%q succeeded while the daemon failed to start", cmd)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// errorIndexFilename is the name of the index of the failed experiments in the errors directory
+	errorIndexFilename = "index.json"
+)
+
+// ErrorIndexEntry describes a failed experiment in the index of the errors directory
+type ErrorIndexEntry struct {
+	// HostMPI is the MPI used on the host, e.g., openmpi:4.0.2
+	HostMPI string `json:"host_mpi"`
+
+	// ContainerMPI is the MPI used in the container, e.g., openmpi:3.1.4
+	ContainerMPI string `json:"container_mpi"`
+
+	// Category is the classification of the failure, e.g., timeout
+	Category string `json:"category"`
+
+	// Dir is the directory where the details of the failure are saved
+	Dir string `json:"dir"`
+
+	// Date is the date of the failure
+	Date string `json:"date"`
+}
+
+// getErrorsDir returns the directory where the details about all the failed experiments are stored
+func getErrorsDir(sysCfg *sys.Config) string {
+	return filepath.Join(sysCfg.BinPath, "errors")
+}
+
+// LoadErrorIndex reads the index of the failed experiments from the errors directory
+func LoadErrorIndex(sysCfg *sys.Config) ([]ErrorIndexEntry, error) {
+	var entries []ErrorIndexEntry
+	path := filepath.Join(getErrorsDir(sysCfg), errorIndexFilename)
+	if !util.FileExists(path) {
+		return entries, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return entries, nil
+}
+
+// UpdateErrorIndex adds the failure of an experiment to the index of the errors directory,
+// replacing the previous failure of the same experiment if any
+func UpdateErrorIndex(r *results.Result, sysCfg *sys.Config) error {
+	entries, err := LoadErrorIndex(sysCfg)
+	if err != nil {
+		return err
+	}
+
+	entry := ErrorIndexEntry{
+		HostMPI:      r.HostMPI.ID + ":" + r.HostMPI.Version,
+		ContainerMPI: r.ContainerMPI.ID + ":" + r.ContainerMPI.Version,
+		Category:     r.ErrorCategory,
+		Dir:          r.ErrorDir,
+		Date:         time.Now().Format(time.RFC3339),
+	}
+	replaced := false
+	for i := range entries {
+		if entries[i].Dir == entry.Dir {
+			entries[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Dir < entries[j].Dir
+	})
+
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the index of the errors: %s", err)
+	}
+	err = os.MkdirAll(getErrorsDir(sysCfg), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", getErrorsDir(sysCfg), err)
+	}
+	path := filepath.Join(getErrorsDir(sysCfg), errorIndexFilename)
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
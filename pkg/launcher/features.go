@@ -0,0 +1,120 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/job"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/jm"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	featuresBinName   = "mpifeatures"
+	featurePrefix     = "MPI_FEATURE:"
+	featuresDirPrefix = "sympi-features-"
+	featureNotFound   = "not reported"
+)
+
+// FeatureNames are the names of the MPI features probed in the containers, in the order of the
+// feature vector
+var FeatureNames = []string{"rma", "datatypes", "mpi-io", "threads"}
+
+// parseFeaturesOutput returns the result of each feature reported by the feature probes, e.g.,
+// 'MPI_FEATURE:mpi-io:fail:MPI_File_open() failed'; the features that are not reported, for
+// instance because the job crashed, failed
+func parseFeaturesOutput(output string) []results.Feature {
+	reported := make(map[string]results.Feature)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, featurePrefix) {
+			continue
+		}
+		tokens := strings.SplitN(strings.TrimPrefix(line, featurePrefix), ":", 3)
+		if len(tokens) < 2 {
+			continue
+		}
+		f := results.Feature{Name: tokens[0], Status: tokens[1]}
+		if len(tokens) == 3 {
+			f.Detail = tokens[2]
+		}
+		reported[f.Name] = f
+	}
+
+	var features []results.Feature
+	for _, name := range FeatureNames {
+		f, ok := reported[name]
+		if !ok {
+			f = results.Feature{Name: name, Status: results.FeatureFail, Detail: featureNotFound}
+		}
+		features = append(features, f)
+	}
+	return features
+}
+
+// RunFeatureProbes compiles the MPI feature probes with the MPI installed on the host and executes
+// them in the container as a MPI job, like the application, to get which features beyond
+// point-to-point communications work: one-sided communications, derived datatypes, MPI-IO in a
+// directory of the workspace bound in the container, and threads.
+func RunFeatureProbes(hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, containerMPI *mpi.Config, jobmgr *jm.JM, sysCfg *sys.Config, args []string) ([]results.Feature, error) {
+	// The directory must be shared by the nodes of the job, the workspace is used instead of /tmp
+	dir, err := ioutil.TempDir(sys.GetSympiDir(), featuresDirPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin, err := compileTestSource(hostBuildEnv, dir, sysCfg, app.MPIFeaturesC, featuresBinName, "-pthread")
+	if err != nil {
+		return nil, err
+	}
+
+	c := containerMPI.Container
+	c.Binds = append(append([]string{}, c.Binds...), dir)
+
+	var j job.Job
+	j.HostCfg = &hostMPI.Implem
+	j.Container = &c
+	j.App.BinPath = bin
+	j.App.Args = []string{dir}
+	if len(args) == 0 {
+		j.NNodes = 2
+		j.NP = 2
+	} else {
+		j.Args = args
+	}
+	j.Hostfile = sysCfg.Hostfile
+	j.Rankfile = sysCfg.Rankfile
+
+	cmd, err := prepareLaunchCmd(&j, jobmgr, hostBuildEnv, sysCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare the launch command: %s", err)
+	}
+	defer cmd.CancelFn()
+	var stdout, stderr bytes.Buffer
+	cmd.Cmd.Stdout = &stdout
+	cmd.Cmd.Stderr = &stderr
+
+	log.Printf("* Running the MPI feature probes in %s\n", c.Path)
+	err = syexec.RunCmd(cmd.Cmd)
+	output := stdout.String() + j.GetOutput(&j, sysCfg)
+	if err != nil {
+		// A crash of the job is reported as the failure of the features that were not probed yet
+		log.Printf("[WARN] the MPI feature probes failed: %s (stderr: %s)", err, stderr.String()+j.GetError(&j, sysCfg))
+	}
+	return parseFeaturesOutput(output), nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/results"
+)
+
+func TestParseFeaturesOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected string
+		detail   string
+	}{
+		{
+			name:     "all features",
+			output:   "MPI_FEATURE:rma:pass\nMPI_FEATURE:datatypes:pass\nMPI_FEATURE:mpi-io:pass\nMPI_FEATURE:threads:pass\n",
+			expected: "rma:pass datatypes:pass mpi-io:pass threads:pass",
+		},
+		{
+			name:     "failure with detail",
+			output:   "Hello\nMPI_FEATURE:rma:pass\nMPI_FEATURE:datatypes:pass\n  MPI_FEATURE:mpi-io:fail:MPI_File_open() failed\nMPI_FEATURE:threads:skip:MPI_THREAD_MULTIPLE not provided\n",
+			expected: "rma:pass datatypes:pass mpi-io:fail threads:skip",
+			detail:   "MPI_File_open() failed",
+		},
+		{
+			name:     "crash",
+			output:   "MPI_FEATURE:rma:pass\nSegmentation fault",
+			expected: "rma:pass datatypes:fail mpi-io:fail threads:fail",
+			detail:   featureNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		features := parseFeaturesOutput(tt.output)
+		if results.FormatFeatures(features) != tt.expected {
+			t.Fatalf("%s: parseFeaturesOutput() returned %s instead of %s", tt.name, results.FormatFeatures(features), tt.expected)
+		}
+		if tt.detail != "" && features[2].Detail != tt.detail {
+			t.Fatalf("%s: the detail of %s is %q instead of %q", tt.name, features[2].Name, features[2].Detail, tt.detail)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// GlibcSkewWarn is the policy logging a warning when the versions of glibc on the host and in
+	// the container are too different, the experiment being executed anyway
+	GlibcSkewWarn = "warn"
+
+	// GlibcSkewSkip is the policy skipping the experiments for which the versions of glibc on the
+	// host and in the container are too different
+	GlibcSkewSkip = "skip"
+)
+
+// GlibcCheck is the result of the comparison of the versions of glibc on the host and in a container
+type GlibcCheck struct {
+	// Host is the version of glibc on the host, e.g., 2.31
+	Host string
+
+	// Container is the version of glibc in the container
+	Container string
+
+	// Skew is the number of minor versions between the two versions of glibc
+	Skew int
+}
+
+// ValidateGlibcSkewPolicy checks that a policy is valid for sysCfg.GlibcSkewPolicy
+func ValidateGlibcSkewPolicy(policy string) error {
+	if policy != GlibcSkewWarn && policy != GlibcSkewSkip {
+		return fmt.Errorf("invalid glibc skew policy %s, it should be %s or %s", policy, GlibcSkewWarn, GlibcSkewSkip)
+	}
+	return nil
+}
+
+// parseGlibcVersion returns the version of glibc from the output of 'ldd --version', whose first
+// line ends with the version, e.g., 'ldd (Ubuntu GLIBC 2.31-0ubuntu9) 2.31'
+func parseGlibcVersion(output string) (string, error) {
+	line := strings.TrimSpace(strings.SplitN(output, "\n", 2)[0])
+	words := strings.Fields(line)
+	if len(words) == 0 || !strings.Contains(strings.ToLower(line), "libc") {
+		return "", fmt.Errorf("unable to find the version of glibc in '%s'", line)
+	}
+	version := words[len(words)-1]
+	if _, _, err := splitGlibcVersion(version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// splitGlibcVersion returns the major and minor numbers of a version of glibc
+func splitGlibcVersion(version string) (int, int, error) {
+	tokens := strings.Split(version, ".")
+	if len(tokens) < 2 {
+		return 0, 0, fmt.Errorf("invalid glibc version %s", version)
+	}
+	major, err := strconv.Atoi(tokens[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid glibc version %s", version)
+	}
+	minor, err := strconv.Atoi(tokens[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid glibc version %s", version)
+	}
+	return major, minor, nil
+}
+
+// getGlibcSkew returns the number of minor versions between two versions of glibc; versions with
+// different major numbers are considered 100 minor versions apart for each major version
+func getGlibcSkew(v1 string, v2 string) (int, error) {
+	major1, minor1, err := splitGlibcVersion(v1)
+	if err != nil {
+		return 0, err
+	}
+	major2, minor2, err := splitGlibcVersion(v2)
+	if err != nil {
+		return 0, err
+	}
+	skew := (major1-major2)*100 + minor1 - minor2
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew, nil
+}
+
+// getGlibcVersion executes a command running 'ldd --version' and returns the version of glibc
+func getGlibcVersion(bin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute %s: %s (stderr: %s)", strings.Join(cmd.Args, " "), err, stderr.String())
+	}
+	return parseGlibcVersion(stdout.String())
+}
+
+// CheckGlibc compares the versions of glibc on the host and in a container, the version of the
+// container being obtained with 'singularity exec <image> ldd --version'
+func CheckGlibc(containerInfo *container.Config, sysCfg *sys.Config) (*GlibcCheck, error) {
+	lddPath, err := exec.LookPath("ldd")
+	if err != nil {
+		return nil, fmt.Errorf("ldd not found on the host: %s", err)
+	}
+
+	c := new(GlibcCheck)
+	c.Host, err = getGlibcVersion(lddPath, "--version")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the version of glibc on the host: %s", err)
+	}
+	c.Container, err = getGlibcVersion(sysCfg.SingularityBin, "exec", containerInfo.Path, "ldd", "--version")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the version of glibc in %s: %s", containerInfo.Path, err)
+	}
+	c.Skew, err = getGlibcSkew(c.Host, c.Container)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkGlibcSkew applies the glibc skew policy of the configuration to an experiment: the versions
+// of glibc are recorded in its result and false is returned when the experiment must be skipped. The
+// experiment is executed when the versions of glibc cannot be checked.
+func checkGlibcSkew(containerInfo *container.Config, sysCfg *sys.Config, r *results.Result) bool {
+	if sysCfg.GlibcSkewPolicy == "" {
+		return true
+	}
+
+	c, err := CheckGlibc(containerInfo, sysCfg)
+	if err != nil {
+		log.Printf("[WARN] unable to compare the versions of glibc: %s", err)
+		return true
+	}
+	r.HostGlibc = c.Host
+	r.ContainerGlibc = c.Container
+	r.GlibcSkew = c.Skew
+	if c.Skew <= sysCfg.GlibcMaxSkew {
+		return true
+	}
+
+	msg := fmt.Sprintf("glibc %s on the host and %s in the container differ by %d minor versions (maximum: %d)", c.Host, c.Container, c.Skew, sysCfg.GlibcMaxSkew)
+	if sysCfg.GlibcSkewPolicy == GlibcSkewSkip {
+		log.Printf("* Skipping %s: %s\n", containerInfo.Path, msg)
+		r.Note = msg
+		return false
+	}
+	log.Printf("[WARN] %s\n", msg)
+	return true
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"testing"
+)
+
+func TestGlibcSkew(t *testing.T) {
+	tests := []struct {
+		hostOutput      string
+		containerOutput string
+		skew            int
+	}{
+		{
+			hostOutput:      "ldd (Ubuntu GLIBC 2.31-0ubuntu9.9) 2.31\nCopyright (C) 2020 Free Software Foundation, Inc.\n",
+			containerOutput: "ldd (GNU libc) 2.17\nCopyright (C) 2012 Free Software Foundation, Inc.\n",
+			skew:            14,
+		},
+		{
+			hostOutput:      "ldd (GNU libc) 2.28\n",
+			containerOutput: "ldd (Debian GLIBC 2.36-9+deb12u4) 2.36\n",
+			skew:            8,
+		},
+		{
+			hostOutput:      "ldd (GNU libc) 2.28\n",
+			containerOutput: "ldd (GNU libc) 2.28\n",
+			skew:            0,
+		},
+	}
+
+	for _, tt := range tests {
+		host, err := parseGlibcVersion(tt.hostOutput)
+		if err != nil {
+			t.Fatalf("parseGlibcVersion() failed: %s", err)
+		}
+		c, err := parseGlibcVersion(tt.containerOutput)
+		if err != nil {
+			t.Fatalf("parseGlibcVersion() failed: %s", err)
+		}
+		skew, err := getGlibcSkew(host, c)
+		if err != nil {
+			t.Fatalf("getGlibcSkew() failed: %s", err)
+		}
+		if skew != tt.skew {
+			t.Fatalf("skew between %s and %s is %d instead of %d", host, c, skew, tt.skew)
+		}
+	}
+
+	// musl does not provide glibc
+	for _, output := range []string{"", "musl libc (x86_64)\nVersion 1.2.2\n", "ldd (GNU libc) unknown\n"} {
+		_, err := parseGlibcVersion(output)
+		if err == nil {
+			t.Fatalf("parseGlibcVersion() succeeded with %q", output)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// ISARefuse is the policy refusing to run a container requiring CPU features that the host lacks
+	ISARefuse = "refuse"
+
+	// ISAWarn is the policy logging a warning when the host lacks CPU features required by a
+	// container, the container being executed anyway
+	ISAWarn = "warn"
+
+	// ISAIgnore is the policy disabling the check of the CPU features
+	ISAIgnore = "ignore"
+
+	// DefaultISAPolicy is the policy used when none is specified: the features recorded in images
+	// are the ones of the build host, not necessarily the ones used by the binaries
+	DefaultISAPolicy = ISAWarn
+)
+
+// ValidateISAPolicy checks that a policy is valid for sysCfg.ISAPolicy
+func ValidateISAPolicy(policy string) error {
+	if policy != ISARefuse && policy != ISAWarn && policy != ISAIgnore {
+		return fmt.Errorf("invalid ISA policy '%s' (must be %s, %s or %s)", policy, ISARefuse, ISAWarn, ISAIgnore)
+	}
+	return nil
+}
+
+// getISAPolicy returns the ISA policy of the configuration
+func getISAPolicy(sysCfg *sys.Config) string {
+	if sysCfg.ISAPolicy == "" {
+		return DefaultISAPolicy
+	}
+	return sysCfg.ISAPolicy
+}
+
+// checkISA applies the ISA policy of the configuration to an experiment: the CPU features of the
+// host where the container was built are compared to the ones of the host, the instruction sets
+// being recorded in its result, and false is returned when the experiment must not be executed.
+// Containers without CPU features in their labels, e.g., built by a previous version of the tool,
+// are not checked.
+func checkISA(containerInfo *container.Config, sysCfg *sys.Config, r *results.Result) bool {
+	if getISAPolicy(sysCfg) == ISAIgnore || containerInfo.ISABaseline == "" {
+		return true
+	}
+
+	host, err := sys.GetCPUInfo()
+	if err != nil {
+		log.Printf("[WARN] unable to check the CPU features required by %s: %s", containerInfo.Path, err)
+		return true
+	}
+	return applyISAPolicy(host, containerInfo, getISAPolicy(sysCfg), r)
+}
+
+// applyISAPolicy compares the CPU features of a host to the ones required by a container and
+// applies a policy (see checkISA)
+func applyISAPolicy(host *sys.CPUInfo, containerInfo *container.Config, policy string, r *results.Result) bool {
+	r.HostISA = host.ISABaseline
+	r.ContainerISA = containerInfo.ISABaseline
+	r.MissingCPUFeatures = host.GetMissingCPUFeatures(containerInfo.CPUFeatures)
+	if len(r.MissingCPUFeatures) == 0 {
+		return true
+	}
+
+	msg := fmt.Sprintf("%s was built on a %s host and the %s host lacks the CPU features %s", containerInfo.Path, containerInfo.ISABaseline, host.ISABaseline, strings.Join(r.MissingCPUFeatures, ", "))
+	if policy == ISARefuse {
+		log.Printf("* Not running %s: %s\n", containerInfo.Path, msg)
+		r.Note = msg
+		return false
+	}
+	log.Printf("[WARN] %s, the container may crash with SIGILL\n", msg)
+	return true
+}
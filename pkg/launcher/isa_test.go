@@ -0,0 +1,45 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestApplyISAPolicy(t *testing.T) {
+	host := sys.CPUInfo{Arch: "amd64", ISABaseline: "x86-64-v2", Features: []string{"cx16", "popcnt", "sse4_2"}}
+	tests := []struct {
+		policy   string
+		features []string
+		run      bool
+		missing  string
+	}{
+		{policy: ISARefuse, features: []string{"popcnt", "sse4_2"}, run: true},
+		{policy: ISARefuse, features: []string{"avx2", "popcnt"}, run: false, missing: "avx2"},
+		{policy: ISAWarn, features: []string{"avx2", "fma"}, run: true, missing: "avx2,fma"},
+	}
+
+	for _, tt := range tests {
+		c := container.Config{Path: "app.sif", ISABaseline: "x86-64-v3", CPUFeatures: tt.features}
+		var r results.Result
+		run := applyISAPolicy(&host, &c, tt.policy, &r)
+		if run != tt.run || strings.Join(r.MissingCPUFeatures, ",") != tt.missing {
+			t.Fatalf("applyISAPolicy() with %s and %v returned %t and %v", tt.policy, tt.features, run, r.MissingCPUFeatures)
+		}
+		if r.HostISA != "x86-64-v2" || r.ContainerISA != "x86-64-v3" || (!run && r.Note == "") {
+			t.Fatalf("the result of the check was not recorded: %+v", r)
+		}
+	}
+
+	if ValidateISAPolicy("skip") == nil {
+		t.Fatalf("ValidateISAPolicy() accepted an invalid policy")
+	}
+}
@@ -25,6 +25,8 @@ import (
 	"github.com/sylabs/singularity-mpi/internal/pkg/slurm"
 	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/jm"
 	"github.com/sylabs/singularity-mpi/pkg/mpi"
@@ -32,6 +34,7 @@ import (
 	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
+	"github.com/sylabs/singularity-mpi/pkg/toolchain"
 )
 
 // Info gathers all the details to start a job
@@ -78,8 +81,18 @@ func Load() (sys.Config, jm.JM, network.Info, error) {
 		return cfg, jobmgr, net, fmt.Errorf("cannot detect the directory of the binary")
 	}
 	cfg.BinPath = filepath.Dir(bin)
-	cfg.EtcDir = filepath.Join(os.Getenv("GOPATH"), "etc")
-	cfg.TemplateDir = filepath.Join(cfg.EtcDir, "templates")
+	etcDir, err := sys.FindEtcDir()
+	if err != nil {
+		if os.Getenv(sys.SYMPI_ETC_ENV) != "" {
+			return cfg, jobmgr, net, err
+		}
+		// Nothing is installed yet, we default to the first location that does not require any specific setting
+		etcDir = sys.GetEtcDirCandidates()[0]
+		log.Printf("[WARN] %s, using %s\n", err, etcDir.Path)
+	}
+	cfg.EtcDir = etcDir.Path
+	cfg.EtcDirOrigin = etcDir.Origin
+	cfg.TemplateDir = sys.GetTemplateDir(cfg.EtcDir)
 	cfg.OfiCfgFile = filepath.Join(cfg.EtcDir, "sympi_ofi.conf")
 	cfg.CurPath, err = os.Getwd()
 	if err != nil {
@@ -87,8 +100,8 @@ func Load() (sys.Config, jm.JM, network.Info, error) {
 	}
 
 	cfg.SyConfigFile = sy.GetPathToSyMPIConfigFile()
-	if util.PathExists(cfg.SyConfigFile) {
-		kvs, err := kv.LoadKeyValueConfig(cfg.SyConfigFile)
+	if util.PathExists(configparser.GetConfigFilePath(cfg.SyConfigFile)) {
+		kvs, err := configparser.Load(cfg.SyConfigFile)
 		if err != nil {
 			return cfg, jobmgr, net, fmt.Errorf("unable to load the tool's configuration: %s", err)
 		}
@@ -106,13 +119,12 @@ func Load() (sys.Config, jm.JM, network.Info, error) {
 		}
 		log.Printf("... %s successfully created\n", path)
 	}
-	cfg.SingularityBin, err = exec.LookPath("singularity")
-	if err != nil {
-		log.Printf("[WARN] failed to find the Singularity binary")
-	}
+	// sudo is not available on many clusters, the operations requiring it are then executed with
+	// --fakeroot or skipped (see sy.UseSudo and sy.UseFakeroot)
 	cfg.SudoBin, err = exec.LookPath("sudo")
 	if err != nil {
-		return cfg, jobmgr, net, fmt.Errorf("sudo not available: %s", err)
+		log.Printf("[WARN] sudo not available, the operations requiring privileges will be executed with --fakeroot or skipped: %s", err)
+		cfg.SudoBin = ""
 	}
 
 	// Parse and load the sympi configuration file
@@ -128,22 +140,299 @@ func Load() (sys.Config, jm.JM, network.Info, error) {
 	}
 	val = kv.GetValue(sympiKVs, sy.SudoCmdsKey)
 	if val != "" {
-		cfg.SudoSyCmds = strings.Split(val, " ")
+		cfg.SudoSyCmds = strings.Fields(val)
+	}
+	cfg.SudoPolicies, err = sy.LoadSudoPolicies(sympiKVs)
+	if err != nil {
+		return cfg, jobmgr, net, fmt.Errorf("invalid sudo policies in the tool's configuration file: %s", err)
+	}
+	cfg.LaunchTemplate = kv.GetValue(sympiKVs, mpi.LauncherKey)
+	if cfg.LaunchTemplate != "" {
+		err = mpi.ValidateLaunchTemplate(cfg.LaunchTemplate)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid launcher in the tool's configuration file: %s", err)
+		}
+	}
+	// The jobs are not executed from the current directory, the paths to the hostfile and the
+	// rankfile must therefore be absolute
+	cfg.Hostfile = kv.GetValue(sympiKVs, mpi.HostfileKey)
+	if cfg.Hostfile != "" {
+		err = mpi.ValidateHostfile(cfg.Hostfile)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid hostfile in the tool's configuration file: %s", err)
+		}
+		cfg.Hostfile, err = filepath.Abs(cfg.Hostfile)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("failed to get the absolute path to the hostfile: %s", err)
+		}
+	}
+	cfg.Rankfile = kv.GetValue(sympiKVs, mpi.RankfileKey)
+	if cfg.Rankfile != "" {
+		err = mpi.ValidateHostfile(cfg.Rankfile)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid rankfile in the tool's configuration file: %s", err)
+		}
+		cfg.Rankfile, err = filepath.Abs(cfg.Rankfile)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("failed to get the absolute path to the rankfile: %s", err)
+		}
+	}
+	cfg.SignKeyFingerprint = kv.GetValue(sympiKVs, sy.SignKeyFingerprintKey)
+	cfg.VerifyPolicy = kv.GetValue(sympiKVs, sy.VerifyPolicyKey)
+	if cfg.VerifyPolicy != "" {
+		err = container.ValidateVerifyPolicy(cfg.VerifyPolicy)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid verification policy in the tool's configuration file: %s", err)
+		}
+	}
+	cfg.ISAPolicy = kv.GetValue(sympiKVs, sy.ISAPolicyKey)
+	if cfg.ISAPolicy != "" {
+		err = ValidateISAPolicy(cfg.ISAPolicy)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid ISA policy in the tool's configuration file: %s", err)
+		}
+	}
+	cfg.Toolchain = kv.GetValue(sympiKVs, sy.ToolchainKey)
+	cfg.ToolchainDir = kv.GetValue(sympiKVs, sy.ToolchainDirKey)
+	if cfg.Toolchain != "" {
+		_, err = toolchain.Get(cfg.Toolchain, cfg.ToolchainDir)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid toolchain in the tool's configuration file: %s", err)
+		}
+	}
+
+	cfg.BuildProfiles, err = sy.LoadBuildProfiles(sympiKVs)
+	if err != nil {
+		return cfg, jobmgr, net, fmt.Errorf("invalid build profile in the tool's configuration file: %s", err)
+	}
+	for name, p := range cfg.BuildProfiles {
+		_, err = toolchain.Get(p.Toolchain, p.ToolchainDir)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid toolchain of build profile %s in the tool's configuration file: %s", name, err)
+		}
+	}
+	if kv.GetValue(sympiKVs, sy.BuildProfileKey) != "" {
+		err = cfg.SelectBuildProfile(kv.GetValue(sympiKVs, sy.BuildProfileKey))
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.BuildProfileKey, err)
+		}
+	}
+
+	cfg.Registries, err = sy.LoadRegistries(sympiKVs)
+	if err != nil {
+		return cfg, jobmgr, net, fmt.Errorf("invalid registry in the tool's configuration file: %s", err)
+	}
+	cfg.Tuning, err = sy.LoadTuningProfiles(sympiKVs)
+	if err != nil {
+		return cfg, jobmgr, net, fmt.Errorf("invalid tuning file in the tool's configuration file: %s", err)
+	}
+
+	cfg.ContainerMPIPrefix = kv.GetValue(sympiKVs, sy.ContainerMPIPrefixKey)
+	if cfg.ContainerMPIPrefix != "" {
+		err = container.ValidateMPIPrefix(cfg.ContainerMPIPrefix)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid MPI prefix in the tool's configuration file: %s", err)
+		}
+	}
+
+	cfg.ContainerRuntime = kv.GetValue(sympiKVs, sy.ContainerRuntimeKey)
+	if cfg.ContainerRuntime != "" {
+		err = sys.ValidateContainerRuntime(cfg.ContainerRuntime)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid container runtime in the tool's configuration file: %s", err)
+		}
+	}
+	cfg.SingularityBin, err = sys.FindContainerRuntime(cfg.ContainerRuntime)
+	if err != nil {
+		log.Printf("[WARN] failed to find the Singularity or Apptainer binary: %s", err)
+	}
+
+	cfg.DownloadRateLimit = kv.GetValue(sympiKVs, sy.DownloadRateLimitKey)
+	if cfg.DownloadRateLimit != "" {
+		err = buildenv.ValidateRateLimit(cfg.DownloadRateLimit)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid download rate limit in the tool's configuration file: %s", err)
+		}
+	}
+
+	cfg.Proxy = kv.GetValue(sympiKVs, sy.ProxyKey)
+	if cfg.Proxy != "" {
+		err = sys.ValidateProxy(cfg.Proxy)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.ProxyKey, err)
+		}
+	}
+	cfg.NoProxy = kv.GetValue(sympiKVs, sy.NoProxyKey)
+	cfg.CAFile = kv.GetValue(sympiKVs, sy.CAFileKey)
+	if cfg.CAFile != "" {
+		err = sys.ValidateCAFile(cfg.CAFile)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.CAFileKey, err)
+		}
+	}
+
+	cfg.BuilderImage = kv.GetValue(sympiKVs, sy.BuilderImageKey)
+	if cfg.BuilderImage != "" {
+		err = container.ValidateBuilderImage(cfg.BuilderImage)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid builder image in the tool's configuration file: %s", err)
+		}
+	}
+
+	val = kv.GetValue(sympiKVs, sy.SandboxEnvKey)
+	if val != "" {
+		cfg.SandboxEnv, err = strconv.ParseBool(val)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.SandboxEnvKey, err)
+		}
+	}
+	cfg.EnvAllowlist = sys.ParseEnvAllowlist(kv.GetValue(sympiKVs, sy.EnvAllowlistKey))
+	val = kv.GetValue(sympiKVs, sy.FeatureProbesKey)
+	if val != "" {
+		cfg.FeatureProbes, err = strconv.ParseBool(val)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.FeatureProbesKey, err)
+		}
+	}
+
+	limits, err := loadBuildLimits(sympiKVs)
+	if err != nil {
+		return cfg, jobmgr, net, fmt.Errorf("invalid resource limits in the tool's configuration file: %s", err)
+	}
+	cfg.MakeJobs = limits.Jobs
+	cfg.BuildNice = limits.Nice
+	cfg.BuildIONice = limits.IONice
+	cfg.BuildMemoryMax = limits.MemoryMax
+	cfg.BuildCPUQuota = limits.CPUQuota
+	val = kv.GetValue(sympiKVs, sy.HeartbeatKey)
+	if val != "" {
+		err = ValidateHeartbeat(val)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.HeartbeatKey, err)
+		}
+		cfg.Heartbeat, _ = time.ParseDuration(val)
+	}
+
+	cfg.ScratchGC = kv.GetValue(sympiKVs, sy.ScratchGCKey)
+	if cfg.ScratchGC != "" {
+		err = buildenv.ValidateScratchGCPolicy(cfg.ScratchGC)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.ScratchGCKey, err)
+		}
+	}
+	val = kv.GetValue(sympiKVs, sy.ScratchMaxAgeKey)
+	if val != "" {
+		err = buildenv.ValidateScratchMaxAge(val)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.ScratchMaxAgeKey, err)
+		}
+		cfg.ScratchMaxAge, _ = time.ParseDuration(val)
+	}
+	cfg.ScratchRoot = kv.GetValue(sympiKVs, sy.ScratchRootKey)
+	if cfg.ScratchRoot != "" {
+		err = buildenv.ValidateScratchRoot(cfg.ScratchRoot)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.ScratchRootKey, err)
+		}
+	}
+	val = kv.GetValue(sympiKVs, sy.ScratchMinFreeKey)
+	if val != "" {
+		cfg.ScratchMinFree, err = buildenv.ParseSize(val)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.ScratchMinFreeKey, err)
+		}
+	}
+
+	cfg.RuntimeMode = kv.GetValue(sympiKVs, sy.RuntimeModeKey)
+	if cfg.RuntimeMode != "" {
+		err = sys.ValidateRuntimeMode(cfg.RuntimeMode)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.RuntimeModeKey, err)
+		}
+	}
+
+	cfg.BaseImageAutobuild = true
+	val = kv.GetValue(sympiKVs, sy.BaseImageAutobuildKey)
+	if val != "" {
+		cfg.BaseImageAutobuild, err = strconv.ParseBool(val)
+		if err != nil {
+			return cfg, jobmgr, net, fmt.Errorf("invalid value of %s in the tool's configuration file: %s", sy.BaseImageAutobuildKey, err)
+		}
 	}
 
 	// Load the job manager component first
 	jobmgr = jm.Detect()
 
-	// Load the network configuration
-	_ = network.Detect(&cfg)
+	// Load the network configuration, including the network interface used by MPI
+	net = network.Detect(&cfg)
 
 	return cfg, jobmgr, net, nil
 }
 
+// buildLimitKeys are the keys of the tool's configuration file specifying the resource limits of
+// the builds and the corresponding options of syexec.ParseLimits
+var buildLimitKeys = map[string]string{
+	sy.MakeJobsKey:       "jobs",
+	sy.BuildNiceKey:      "nice",
+	sy.BuildIONiceKey:    "ionice",
+	sy.BuildMemoryMaxKey: "memory_max",
+	sy.BuildCPUQuotaKey:  "cpu_quota",
+}
+
+// ValidateBuildLimit returns a function checking the value of one of the keys specifying the
+// resource limits of the builds, e.g., sy.MakeJobsKey
+func ValidateBuildLimit(key string) func(string) error {
+	return func(value string) error {
+		_, err := syexec.ParseLimits(buildLimitKeys[key] + "=" + value)
+		return err
+	}
+}
+
+// ValidateHeartbeat checks the value of sy.HeartbeatKey, a positive duration, e.g., 5m
+func ValidateHeartbeat(value string) error {
+	heartbeat, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if heartbeat <= 0 {
+		return fmt.Errorf("the heartbeat must be positive")
+	}
+	return nil
+}
+
+// loadBuildLimits returns the resource limits of the builds specified in the tool's configuration file
+func loadBuildLimits(kvs []kv.KV) (syexec.Limits, error) {
+	var opts []string
+	for _, key := range []string{sy.MakeJobsKey, sy.BuildNiceKey, sy.BuildIONiceKey, sy.BuildMemoryMaxKey, sy.BuildCPUQuotaKey} {
+		val := kv.GetValue(kvs, key)
+		if val != "" {
+			opts = append(opts, buildLimitKeys[key]+"="+val)
+		}
+	}
+	return syexec.ParseLimits(strings.Join(opts, " "))
+}
+
+// GetBuildLimits returns the resource limits of the builds of a configuration, to be applied with
+// syexec.SetLimits
+func GetBuildLimits(cfg *sys.Config) syexec.Limits {
+	return syexec.Limits{
+		Jobs:      cfg.MakeJobs,
+		Nice:      cfg.BuildNice,
+		IONice:    cfg.BuildIONice,
+		MemoryMax: cfg.BuildMemoryMax,
+		CPUQuota:  cfg.BuildCPUQuota,
+	}
+}
+
+// getErrorDir returns the directory where the details about a failed experiment are stored
+func getErrorDir(hostMPI *implem.Info, containerMPI *implem.Info, sysCfg *sys.Config) string {
+	experimentName := hostMPI.Version + "-" + containerMPI.Version
+	return filepath.Join(getErrorsDir(sysCfg), hostMPI.ID, experimentName)
+}
+
 // SaveErrorDetails gathers and stores execution details when the execution of a container failed.
 func SaveErrorDetails(hostMPI *implem.Info, containerMPI *implem.Info, sysCfg *sys.Config, res *syexec.Result) error {
-	experimentName := hostMPI.Version + "-" + containerMPI.Version
-	targetDir := filepath.Join(sysCfg.BinPath, "errors", hostMPI.ID, experimentName)
+	targetDir := getErrorDir(hostMPI, containerMPI, sysCfg)
 
 	// If the directory exists, we delete it to start fresh
 	err := util.DirInit(targetDir)
@@ -220,19 +509,88 @@ func Run(appInfo *app.Info, hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, co
 
 	if hostMPI != nil {
 		newjob.HostCfg = &hostMPI.Implem
+		expRes.HostMPI = hostMPI.Implem
 	}
 
 	if containerMPI != nil {
 		newjob.Container = &containerMPI.Container
+		expRes.ContainerMPI = containerMPI.Implem
 	}
 
-	newjob.App.BinPath = appInfo.BinPath
+	// The benchmark settings of the run, including its seed, replace their placeholders in the
+	// command of the application and are recorded with the result so the run can be repeated
+	bench := appInfo.Bench.WithSeed(appInfo.BinPath, appInfo.Args)
+	log.Printf("-> Benchmark settings: %s\n", bench.String())
+	newjob.App.BinPath = bench.Expand(appInfo.BinPath)
+	newjob.App.Args = bench.ExpandArgs(appInfo.Args)
+	expRes.AppArgs = newjob.App.Args
+	expRes.Bench = bench
+	if sysCfg.IsOCIMode() {
+		expRes.RuntimeMode = sys.RuntimeModeOCI
+	}
 	if len(args) == 0 {
 		newjob.NNodes = 2
 		newjob.NP = 2
 	} else {
 		newjob.Args = args
 	}
+	newjob.Hostfile = sysCfg.Hostfile
+	newjob.Rankfile = sysCfg.Rankfile
+
+	wrapperDir, err := setupWrapper(&newjob, sysCfg)
+	if err != nil {
+		execRes.Err = fmt.Errorf("failed to set up the wrapper: %s", err)
+		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorLaunch
+		return expRes, execRes
+	}
+
+	// Mismatches between the glibc of the host and of the container are only discovered at run
+	// time, they are therefore checked before starting the job
+	if containerMPI != nil && !checkGlibcSkew(&containerMPI.Container, sysCfg, &expRes) {
+		execRes.Err = fmt.Errorf("glibc skew: %s", expRes.Note)
+		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorGlibcSkew
+		return expRes, execRes
+	}
+
+	// Binaries compiled for the CPUs of the build host crash with SIGILL on CPUs lacking some of
+	// their features, which are therefore checked before starting the job
+	if containerMPI != nil && !checkISA(&containerMPI.Container, sysCfg, &expRes) {
+		execRes.Err = fmt.Errorf("incompatible CPUs: %s", expRes.Note)
+		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorISA
+		return expRes, execRes
+	}
+
+	// Applications requiring a thread level fail confusingly when MPI does not provide it, it is
+	// therefore checked before starting the job
+	if hostMPI != nil && hostBuildEnv != nil && containerMPI != nil {
+		err = CheckThreadLevel(hostMPI, hostBuildEnv, &containerMPI.Container, sysCfg)
+		if err != nil {
+			execRes.Err = fmt.Errorf("invalid thread level: %s", err)
+			expRes.Pass = false
+			expRes.ErrorCategory = results.ErrorThreadLevel
+			return expRes, execRes
+		}
+	}
+
+	// The coordinator of the checkpointing tool must be started before the job
+	var ckpt *checkpointRun
+	if containerMPI != nil {
+		ckpt, err = setupCheckpoint(&newjob, &containerMPI.Container, sysCfg)
+	} else {
+		ckpt, err = setupCheckpoint(&newjob, nil, sysCfg)
+	}
+	if err != nil {
+		execRes.Err = fmt.Errorf("failed to set up the checkpoint/restart of the job: %s", err)
+		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorCheckpoint
+		return expRes, execRes
+	}
+	if ckpt != nil {
+		defer ckpt.cleanup()
+	}
 
 	// We submit the job
 	var submitCmd syexec.SyCmd
@@ -240,18 +598,31 @@ func Run(appInfo *app.Info, hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, co
 	if execRes.Err != nil {
 		execRes.Err = fmt.Errorf("failed to prepare the launch command: %s", execRes.Err)
 		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorLaunch
 		return expRes, execRes
 	}
 
 	var stdout, stderr bytes.Buffer
 	submitCmd.Cmd.Stdout = &stdout
 	submitCmd.Cmd.Stderr = &stderr
-	defer submitCmd.CancelFn()
+	// The command is replaced by the command restarting the job with checkpoint/restart
+	defer func() {
+		submitCmd.CancelFn()
+	}()
 
 	// Regex to catch errors where mpirun returns 0 but is known to have failed because displaying the help message
 	var re = regexp.MustCompile(`^(\n?)Usage:`)
 
-	err := submitCmd.Cmd.Run()
+	execRes.Cmd = strings.Join(submitCmd.Cmd.Args, " ")
+	start := time.Now()
+	if ckpt != nil {
+		err = ckpt.run(&submitCmd, &newjob, jobmgr, hostBuildEnv, &expRes, sysCfg)
+		if expRes.CheckpointSize > 0 {
+			fmt.Printf("Checkpoint of %d bytes taken in %s, restarted job completed in %s\n", expRes.CheckpointSize, expRes.CheckpointTime, expRes.RestartTime)
+		}
+	} else {
+		err = syexec.RunCmd(submitCmd.Cmd)
+	}
 	// Get the command out/err
 	execRes.Stderr = stderr.String()
 	execRes.Stdout = stdout.String()
@@ -262,28 +633,46 @@ func Run(appInfo *app.Info, hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, co
 
 	// We can be facing different types of error
 	if err != nil {
-		// The command simply failed and the Go runtime caught it
+		// The command simply failed and the Go runtime caught it, unless the checkpoint or the
+		// restart of the job failed
 		expRes.Pass = false
+		if expRes.ErrorCategory == "" {
+			expRes.ErrorCategory = results.ErrorExec
+		}
 		log.Printf("[ERROR] Command failed - stdout: %s - stderr: %s - err: %s\n", stdout.String(), stderr.String(), err)
 	}
 	if submitCmd.Ctx.Err() == context.DeadlineExceeded {
 		// The command timed out
 		expRes.Pass = false
+		expRes.ErrorCategory = results.ErrorTimeout
 		log.Printf("[ERROR] Command timed out - stdout: %s - stderr: %s\n", stdout.String(), stderr.String())
 	}
 	if expRes.Pass {
 		if re.Match(stdout.Bytes()) {
 			// mpirun actually failed, exited with 0 as return code but displayed the usage message (so nothing really ran)
 			expRes.Pass = false
+			expRes.ErrorCategory = results.ErrorUsage
 			log.Printf("[ERROR] mpirun failed and returned help messafe - stdout: %s - stderr: %s\n", stdout.String(), stderr.String())
 		}
-		if !expectedOutput(execRes.Stdout, execRes.Stderr, appInfo, &newjob) {
+		if expRes.Pass && !expectedOutput(execRes.Stdout, execRes.Stderr, appInfo, &newjob) {
 			// The output is NOT the expected output
 			expRes.Pass = false
+			expRes.ErrorCategory = results.ErrorOutput
 			log.Printf("[ERROR] Run succeeded but output is not matching expectation - stdout: %s - stderr: %s\n", stdout.String(), stderr.String())
 		}
 	}
 
+	// The feature probes only tell something about containers where the application works; their
+	// failure does not make the experiment fail
+	if expRes.Pass && sysCfg.FeatureProbes && hostMPI != nil && hostBuildEnv != nil && containerMPI != nil {
+		expRes.Features, err = RunFeatureProbes(hostMPI, hostBuildEnv, containerMPI, jobmgr, sysCfg, args)
+		if err != nil {
+			log.Printf("[WARN] impossible to run the MPI feature probes: %s", err)
+		} else {
+			fmt.Printf("MPI features: %s\n", results.FormatFeatures(expRes.Features))
+		}
+	}
+
 	// For any error, we save details to give a chance to the user to analyze what happened
 	if !expRes.Pass {
 		if hostMPI != nil && containerMPI != nil {
@@ -292,11 +681,78 @@ func Run(appInfo *app.Info, hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, co
 				// We only log the error because the most important error is the error
 				// that happened while executing the command
 				log.Printf("impossible to cleanly handle error: %s", err)
+			} else {
+				// The result and the index of the errors directory link to the details
+				expRes.ErrorDir = getErrorDir(&hostMPI.Implem, &containerMPI.Implem, sysCfg)
+				err = UpdateErrorIndex(&expRes, sysCfg)
+				if err != nil {
+					log.Printf("impossible to update the index of the errors: %s", err)
+				}
+			}
+
+			if sysCfg.ArtifactsMaxSize > 0 {
+				dirs := []string{containerMPI.Container.BuildDir}
+				if hostBuildEnv != nil {
+					dirs = append(dirs, hostBuildEnv.BuildDir, hostBuildEnv.ScratchDir)
+				}
+				err = SaveErrorArtifacts(&hostMPI.Implem, &containerMPI.Implem, sysCfg, dirs)
+				if err != nil {
+					log.Printf("impossible to save the build and scratch directories: %s", err)
+				}
 			}
 		} else {
 			log.Println("Not an MPI job, not saving error details")
 		}
 	}
 
+	// The output files of the wrapper go with the details of the failure or the results
+	if wrapperDir != "" {
+		if hostMPI != nil && containerMPI != nil {
+			targetDir := getResultsDir(&hostMPI.Implem, &containerMPI.Implem, sysCfg)
+			if !expRes.Pass {
+				targetDir = getErrorDir(&hostMPI.Implem, &containerMPI.Implem, sysCfg)
+			}
+			wrapperDir, err = saveWrapperOutput(wrapperDir, targetDir)
+			if err != nil {
+				log.Printf("impossible to save the output of the wrapper: %s", err)
+			}
+		}
+		fmt.Printf("Output of the wrapper: %s\n", wrapperDir)
+	}
+
+	// The hostfile and the rankfile go with the details of the failure or the results so the
+	// placement of the ranks is known
+	if (newjob.Hostfile != "" || newjob.Rankfile != "") && hostMPI != nil && containerMPI != nil {
+		targetDir := getResultsDir(&hostMPI.Implem, &containerMPI.Implem, sysCfg)
+		if !expRes.Pass {
+			targetDir = getErrorDir(&hostMPI.Implem, &containerMPI.Implem, sysCfg)
+		}
+		err = saveLaunchFiles(&newjob, targetDir)
+		if err != nil {
+			log.Printf("impossible to save the hostfile and the rankfile of the job: %s", err)
+		}
+	}
+
+	// The files produced by the application would otherwise be overwritten by the next experiment
+	if len(appInfo.OutputArtifacts) > 0 {
+		launchDir := submitCmd.Cmd.Dir
+		if launchDir == "" {
+			launchDir, _ = os.Getwd()
+		}
+		var hostImplem, containerImplem *implem.Info
+		if hostMPI != nil && containerMPI != nil {
+			hostImplem = &hostMPI.Implem
+			containerImplem = &containerMPI.Implem
+		}
+		artifactsDir := getOutputArtifactsDir(appInfo, hostImplem, containerImplem, expRes.Pass, sysCfg)
+		expRes.Artifacts, err = CollectOutputArtifacts(appInfo.OutputArtifacts, launchDir, start, artifactsDir)
+		if err != nil {
+			log.Printf("impossible to collect the output artifacts of the application: %s", err)
+		} else if len(expRes.Artifacts) > 0 {
+			expRes.ArtifactsDir = artifactsDir
+			fmt.Printf("Output artifacts of the application: %s\n", artifactsDir)
+		}
+	}
+
 	return expRes, execRes
 }
@@ -0,0 +1,125 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/job"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// outputArtifactsDirName is the name of the directory where the output artifacts of an
+	// application are saved, in the results or errors directory of the experiment
+	outputArtifactsDirName = "output"
+
+	// hostfileName and rankfileName are the names of the copies of the hostfile and the rankfile
+	// of a job, in the results or errors directory of the experiment
+	hostfileName = "hostfile"
+	rankfileName = "rankfile"
+)
+
+// getOutputArtifactsDir returns the directory where the output artifacts of an experiment are saved,
+// next to the details of the failure if the experiment failed
+func getOutputArtifactsDir(appInfo *app.Info, hostMPI *implem.Info, containerMPI *implem.Info, pass bool, sysCfg *sys.Config) string {
+	if hostMPI == nil || containerMPI == nil {
+		return filepath.Join(sysCfg.BinPath, "results", results.StandaloneCategory, appInfo.Name, outputArtifactsDirName)
+	}
+	if !pass {
+		return filepath.Join(getErrorDir(hostMPI, containerMPI, sysCfg), outputArtifactsDirName)
+	}
+	return filepath.Join(getResultsDir(hostMPI, containerMPI, sysCfg), outputArtifactsDirName)
+}
+
+// CollectOutputArtifacts copies the files matching a list of patterns that were modified since a
+// given time, i.e., produced by the application, in a target directory. Relative patterns are
+// relative to the directory from where the application was started and the files keep their
+// relative path; files matching absolute patterns are copied at the top of the target directory.
+// The list of the files that are copied, relative to the target directory, is returned.
+func CollectOutputArtifacts(patterns []string, launchDir string, since time.Time, targetDir string) ([]string, error) {
+	// Some file systems only store modification times with a one-second granularity
+	since = since.Truncate(time.Second)
+
+	copies := make(map[string]string)
+	for _, p := range patterns {
+		pattern := p
+		if !filepath.IsAbs(p) {
+			pattern = filepath.Join(launchDir, p)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output artifact pattern %s: %s", p, err)
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(since) {
+				continue
+			}
+			dest := filepath.Base(m)
+			if !filepath.IsAbs(p) {
+				dest, err = filepath.Rel(launchDir, m)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get the path of %s relative to %s: %s", m, launchDir, err)
+				}
+			}
+			copies[dest] = m
+		}
+	}
+
+	// Artifacts of a previous execution of the same experiment are replaced
+	err := os.RemoveAll(targetDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove %s: %s", targetDir, err)
+	}
+	if len(copies) == 0 {
+		return nil, nil
+	}
+
+	var artifacts []string
+	for dest, src := range copies {
+		path := filepath.Join(targetDir, dest)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+		}
+		err = util.CopyFile(src, path)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, dest)
+	}
+	sort.Strings(artifacts)
+	return artifacts, nil
+}
+
+// saveLaunchFiles copies the hostfile and the rankfile of a job, if any, in the results or errors
+// directory of an experiment
+func saveLaunchFiles(j *job.Job, targetDir string) error {
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", targetDir, err)
+	}
+	copies := map[string]string{hostfileName: j.Hostfile, rankfileName: j.Rankfile}
+	for name, src := range copies {
+		if src == "" {
+			continue
+		}
+		err = util.CopyFile(src, filepath.Join(targetDir, name))
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %s", src, err)
+		}
+	}
+	return nil
+}
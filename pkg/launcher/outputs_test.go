@@ -0,0 +1,68 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+func TestCollectOutputArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-outputs-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	launchDir := filepath.Join(dir, "launch")
+	err = os.MkdirAll(filepath.Join(launchDir, "data"), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", launchDir, err)
+	}
+	// The output of a previous run must not be collected
+	old := filepath.Join(launchDir, "old.dat")
+	err = ioutil.WriteFile(old, []byte("old"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", old, err)
+	}
+	err = os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to change the modification time of %s: %s", old, err)
+	}
+	start := time.Now()
+	for _, f := range []string{"np.out", "data/run.dat", "other.txt"} {
+		err = ioutil.WriteFile(filepath.Join(launchDir, f), []byte(f), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", f, err)
+		}
+	}
+
+	targetDir := filepath.Join(dir, "results", outputArtifactsDirName)
+	artifacts, err := CollectOutputArtifacts([]string{"np.out", "*.dat", "data/*.dat", "missing.out"}, launchDir, start, targetDir)
+	if err != nil {
+		t.Fatalf("CollectOutputArtifacts() failed: %s", err)
+	}
+	if strings.Join(artifacts, ",") != "data/run.dat,np.out" {
+		t.Fatalf("invalid artifacts: %q", artifacts)
+	}
+	for _, a := range artifacts {
+		if !util.FileExists(filepath.Join(targetDir, a)) {
+			t.Fatalf("%s was not copied to %s", a, targetDir)
+		}
+	}
+
+	// Nothing matches, the artifacts of the previous run are removed
+	artifacts, err = CollectOutputArtifacts([]string{"*.dat"}, launchDir, time.Now().Add(time.Hour), targetDir)
+	if err != nil || len(artifacts) != 0 || util.PathExists(targetDir) {
+		t.Fatalf("invalid artifacts: %q (%v)", artifacts, err)
+	}
+}
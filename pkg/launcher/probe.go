@@ -0,0 +1,289 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/deps"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	probeBinName         = "mpiprobe"
+	probeCompiledVersion = "COMPILED_MPI_VERSION:"
+	probeRuntimeVersion  = "MPI_VERSION:"
+	probeLibraryVersion  = "MPI_LIBRARY_VERSION:"
+	probeThreadLevel     = "MPI_THREAD_PROVIDED:"
+	probeDirPrefix       = "sympi-probe-"
+	probeCompilerName    = "mpicc"
+)
+
+// ProbeResult gathers what the compatibility probe found out about the MPI library used in a container
+type ProbeResult struct {
+	// CompiledVersion is the version of the MPI standard the probe was compiled against on the host, e.g., 3.1
+	CompiledVersion string
+
+	// RuntimeVersion is the version of the MPI standard of the library used in the container
+	RuntimeVersion string
+
+	// LibraryVersion is the string returned by MPI_Get_library_version in the container
+	LibraryVersion string
+
+	// Libraries maps the libraries the probe depends on to their path in the container
+	Libraries map[string]string
+
+	// RequiredThreadLevel is the thread level the application of the container requires, empty
+	// when the application has no specific requirement
+	RequiredThreadLevel string
+
+	// ProvidedThreadLevel is the thread level provided by the MPI library of the container, only
+	// checked when the application requires a thread level
+	ProvidedThreadLevel string
+
+	// MissingLibraries is the list of the libraries the probe depends on that cannot be found in the container
+	MissingLibraries []string
+
+	// Compatible specifies whether the application of the container is expected to run with MPI on the host
+	Compatible bool
+
+	// Reason explains why the configuration is not expected to work
+	Reason string
+}
+
+// String returns a human-readable report of the probe
+func (r *ProbeResult) String() string {
+	var libs []string
+	for lib, path := range r.Libraries {
+		libs = append(libs, "\t"+lib+" => "+path)
+	}
+	sort.Strings(libs)
+
+	s := "MPI version on the host: " + r.CompiledVersion + "\n"
+	s += "MPI version in the container: " + r.RuntimeVersion + "\n"
+	s += "MPI library in the container: " + r.LibraryVersion + "\n"
+	if r.RequiredThreadLevel != "" {
+		s += "Thread level required by the application: " + r.RequiredThreadLevel + "\n"
+		s += "Thread level provided in the container: " + r.ProvidedThreadLevel + "\n"
+	}
+	if len(libs) > 0 {
+		s += "Libraries:\n" + strings.Join(libs, "\n") + "\n"
+	}
+	if r.Compatible {
+		return s + "Prediction: compatible\n"
+	}
+	return s + "Prediction: incompatible (" + r.Reason + ")\n"
+}
+
+// getLddLibraries returns the libraries listed by ldd in the container along with their path, as
+// well as the libraries that ldd cannot find
+func getLddLibraries(output string) (map[string]string, []string) {
+	libs := make(map[string]string)
+	var missing []string
+	for _, lib := range deps.ParseLddOutput(output, deps.ProviderContainer) {
+		if lib.Provider == deps.ProviderMissing {
+			missing = append(missing, lib.Name)
+			continue
+		}
+		libs[lib.Name] = lib.Path
+	}
+	return libs, missing
+}
+
+// parseProbeOutput stores the versions displayed by the probe in a probe result
+func parseProbeOutput(output string, r *ProbeResult) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, probeCompiledVersion):
+			r.CompiledVersion = strings.TrimSpace(strings.TrimPrefix(line, probeCompiledVersion))
+		case strings.HasPrefix(line, probeRuntimeVersion):
+			r.RuntimeVersion = strings.TrimSpace(strings.TrimPrefix(line, probeRuntimeVersion))
+		case strings.HasPrefix(line, probeLibraryVersion):
+			r.LibraryVersion = strings.TrimSpace(strings.TrimPrefix(line, probeLibraryVersion))
+		case strings.HasPrefix(line, probeThreadLevel):
+			r.ProvidedThreadLevel = strings.TrimSpace(strings.TrimPrefix(line, probeThreadLevel))
+		}
+	}
+}
+
+// predict figures out whether an application is expected to run in the container based on the
+// result of the probe, libPrefix being the beginning of the library version expected for the MPI
+// implementation of the host (no check when empty)
+func predict(r *ProbeResult, libPrefix string) {
+	r.Compatible = false
+	switch {
+	case len(r.MissingLibraries) > 0:
+		r.Reason = fmt.Sprintf("libraries not found in the container: %s", strings.Join(r.MissingLibraries, ", "))
+	case r.RuntimeVersion == "" || r.LibraryVersion == "":
+		r.Reason = "the probe did not report the MPI library used in the container"
+	case libPrefix != "" && !strings.HasPrefix(r.LibraryVersion, libPrefix):
+		r.Reason = fmt.Sprintf("the container uses %s instead of %s", r.LibraryVersion, libPrefix)
+	case r.CompiledVersion != "" && implem.CompareVersions(r.RuntimeVersion, r.CompiledVersion) < 0:
+		r.Reason = fmt.Sprintf("the container implements MPI %s while MPI %s is used on the host", r.RuntimeVersion, r.CompiledVersion)
+	case r.RequiredThreadLevel != "" && !threadLevelProvided(r.RequiredThreadLevel, r.ProvidedThreadLevel):
+		r.Reason = getThreadLevelError(r.RequiredThreadLevel, r.ProvidedThreadLevel)
+	default:
+		r.Compatible = true
+		r.Reason = ""
+	}
+}
+
+// threadLevelProvided checks whether the thread level provided by MPI satisfies the required one
+func threadLevelProvided(required string, provided string) bool {
+	return implem.ValidateThreadLevel(provided) == nil && implem.CompareThreadLevels(provided, required) >= 0
+}
+
+// getThreadLevelError returns the description of a thread level that is not provided
+func getThreadLevelError(required string, provided string) string {
+	if provided == "" {
+		return fmt.Sprintf("the application requires thread level %s but MPI could not be initialized in the container", required)
+	}
+	return fmt.Sprintf("the application requires thread level %s but MPI only provides %s in the container", required, provided)
+}
+
+// compileTestSource compiles a test source embedded in the tool with the MPI installed on the host
+// and returns the path to the binary. In the tool-in-container mode, MPI was built in the builder
+// container against its libraries, the source is therefore compiled there.
+func compileTestSource(hostBuildEnv *buildenv.Info, dir string, sysCfg *sys.Config, name string, binName string, extraArgs ...string) (string, error) {
+	src, err := app.WriteTestSource(name, dir)
+	if err != nil {
+		return "", err
+	}
+	bin := filepath.Join(dir, binName)
+	mpicc := filepath.Join(hostBuildEnv.InstallDir, "bin", probeCompilerName)
+	var stderr bytes.Buffer
+	cmd := exec.Command(mpicc, append([]string{"-o", bin, src}, extraArgs...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PATH="+hostBuildEnv.GetEnvPath(), "LD_LIBRARY_PATH="+hostBuildEnv.GetEnvLDPath())
+	builder := syexec.NewBuilderContainer(sysCfg, hostBuildEnv.InstallDir, dir)
+	if builder != nil {
+		bin, args, env := builder.Wrap(cmd.Path, cmd.Args[1:], dir, cmd.Env)
+		cmd = exec.Command(bin, args...)
+		cmd.Dir = dir
+		cmd.Env = env
+	}
+	cmd.Stderr = &stderr
+	err = syexec.RunCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to compile %s: %s (stderr: %s)", src, err, stderr.String())
+	}
+	return bin, nil
+}
+
+// compileProbe compiles the probe with the MPI installed on the host and returns the path to the binary
+func compileProbe(hostBuildEnv *buildenv.Info, dir string, sysCfg *sys.Config) (string, error) {
+	return compileTestSource(hostBuildEnv, dir, sysCfg, app.MPIProbeC, probeBinName)
+}
+
+// execInContainer executes a command in the container, the directory of the probe being bound
+func execInContainer(hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, containerInfo *container.Config, dir string, sysCfg *sys.Config, cmdArgs ...string) (string, error) {
+	args := container.GetMPIExecCfg(&hostMPI.Implem, hostBuildEnv, containerInfo, sysCfg)
+	args = append(args, "--bind", dir, containerInfo.Path)
+	args = append(args, cmdArgs...)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(sysCfg.SingularityBin, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	log.Printf("-> Running %s %s\n", sysCfg.SingularityBin, strings.Join(args, " "))
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return stdout.String(), fmt.Errorf("failed to execute %s in %s: %s (stderr: %s)", strings.Join(cmdArgs, " "), containerInfo.Path, err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// Probe compiles a tiny MPI program with the MPI installed on the host and executes it in the
+// container, without mpirun, to check which MPI library is used there. It is much faster than
+// running the application and predicts whether the configuration is expected to work.
+func Probe(hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, containerInfo *container.Config, sysCfg *sys.Config) (*ProbeResult, error) {
+	dir, err := ioutil.TempDir("", probeDirPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin, err := compileProbe(hostBuildEnv, dir, sysCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(ProbeResult)
+	lddOutput, err := execInContainer(hostMPI, hostBuildEnv, containerInfo, dir, sysCfg, "ldd", bin)
+	if err != nil {
+		return nil, err
+	}
+	r.Libraries, r.MissingLibraries = getLddLibraries(lddOutput)
+
+	// With a thread level, the probe also initializes MPI to get the provided thread level
+	r.RequiredThreadLevel = containerInfo.ThreadLevel
+	probeArgs := []string{bin}
+	if r.RequiredThreadLevel != "" {
+		probeArgs = append(probeArgs, r.RequiredThreadLevel)
+	}
+	probeOutput, err := execInContainer(hostMPI, hostBuildEnv, containerInfo, dir, sysCfg, probeArgs...)
+	if err != nil {
+		// The probe failing is a valid outcome, for instance because of missing libraries
+		log.Printf("* Probe failed: %s", err)
+	}
+	parseProbeOutput(probeOutput, r)
+	predict(r, mpiplugin.Get(hostMPI.Implem.ID).LibraryVersionPrefix())
+	return r, nil
+}
+
+// CheckThreadLevel runs the probe in the container to check that the MPI library of the container
+// provides the thread level required by its application. An error is returned when the thread level
+// is not provided; when the probe itself cannot be executed, a warning is logged and the check passes
+// so the application is still executed.
+func CheckThreadLevel(hostMPI *mpi.Config, hostBuildEnv *buildenv.Info, containerInfo *container.Config, sysCfg *sys.Config) error {
+	required := containerInfo.ThreadLevel
+	if required == "" {
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", probeDirPrefix)
+	if err != nil {
+		log.Printf("[WARN] unable to check the thread level: failed to create temporary directory: %s", err)
+		return nil
+	}
+	defer os.RemoveAll(dir)
+
+	bin, err := compileProbe(hostBuildEnv, dir, sysCfg)
+	if err != nil {
+		log.Printf("[WARN] unable to check the thread level: %s", err)
+		return nil
+	}
+
+	log.Printf("* Checking that MPI provides thread level %s in %s\n", required, containerInfo.Path)
+	output, err := execInContainer(hostMPI, hostBuildEnv, containerInfo, dir, sysCfg, bin, required)
+	r := new(ProbeResult)
+	parseProbeOutput(output, r)
+	if r.ProvidedThreadLevel == "" {
+		// Without any output, we cannot tell whether MPI_Init_thread or the probe itself failed
+		log.Printf("[WARN] unable to check the thread level: %v", err)
+		return nil
+	}
+	if !threadLevelProvided(required, r.ProvidedThreadLevel) {
+		return errors.New(getThreadLevelError(required, r.ProvidedThreadLevel))
+	}
+	return nil
+}
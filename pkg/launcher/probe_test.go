@@ -0,0 +1,131 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestProbePrediction(t *testing.T) {
+	ldd := "\tlinux-vdso.so.1 (0x00007ffd5b1f0000)\n" +
+		"\tlibmpi.so.40 => /opt/openmpi/lib/libmpi.so.40 (0x00007f3a1c000000)\n" +
+		"\tlibopen-pal.so.40 => not found\n" +
+		"\t/lib64/ld-linux-x86-64.so.2 (0x00007f3a1c400000)\n"
+	libs, missing := getLddLibraries(ldd)
+	if len(libs) != 2 || libs["libmpi.so.40"] != "/opt/openmpi/lib/libmpi.so.40" || libs["ld-linux-x86-64.so.2"] != "/lib64/ld-linux-x86-64.so.2" {
+		t.Fatalf("invalid libraries: %v", libs)
+	}
+	if len(missing) != 1 || missing[0] != "libopen-pal.so.40" {
+		t.Fatalf("invalid missing libraries: %v", missing)
+	}
+
+	tests := []struct {
+		name        string
+		output      string
+		missing     []string
+		libPrefix   string
+		threadLevel string
+		compatible  bool
+	}{
+		{
+			name:       "compatible",
+			output:     "COMPILED_MPI_VERSION: 3.1\nMPI_VERSION: 3.1\nMPI_LIBRARY_VERSION: Open MPI v4.0.2, package: Open MPI\n",
+			libPrefix:  "Open MPI",
+			compatible: true,
+		},
+		{
+			name:       "missing libraries",
+			output:     "",
+			missing:    []string{"libmpi.so.40"},
+			libPrefix:  "Open MPI",
+			compatible: false,
+		},
+		{
+			name:       "other implementation",
+			output:     "COMPILED_MPI_VERSION: 3.1\nMPI_VERSION: 3.1\nMPI_LIBRARY_VERSION: MPICH Version: 3.3\n",
+			libPrefix:  "Open MPI",
+			compatible: false,
+		},
+		{
+			name:       "older standard",
+			output:     "COMPILED_MPI_VERSION: 3.1\nMPI_VERSION: 3.0\nMPI_LIBRARY_VERSION: Open MPI v2.0.4\n",
+			libPrefix:  "Open MPI",
+			compatible: false,
+		},
+		{
+			name:        "thread level provided",
+			output:      "COMPILED_MPI_VERSION: 3.1\nMPI_VERSION: 3.1\nMPI_LIBRARY_VERSION: Open MPI v4.0.2\nMPI_THREAD_PROVIDED: multiple\n",
+			libPrefix:   "Open MPI",
+			threadLevel: "multiple",
+			compatible:  true,
+		},
+		{
+			name:        "thread level not provided",
+			output:      "COMPILED_MPI_VERSION: 3.1\nMPI_VERSION: 3.1\nMPI_LIBRARY_VERSION: Open MPI v2.0.4\nMPI_THREAD_PROVIDED: serialized\n",
+			libPrefix:   "Open MPI",
+			threadLevel: "multiple",
+			compatible:  false,
+		},
+		{
+			name:        "MPI not initialized",
+			output:      "COMPILED_MPI_VERSION: 3.1\nMPI_VERSION: 3.1\nMPI_LIBRARY_VERSION: Open MPI v4.0.2\n",
+			libPrefix:   "Open MPI",
+			threadLevel: "funneled",
+			compatible:  false,
+		},
+		{
+			name:       "unknown implementation",
+			output:     "COMPILED_MPI_VERSION: 3.1\nMPI_VERSION: 3.1\nMPI_LIBRARY_VERSION: Some MPI 1.0\n",
+			compatible: true,
+		},
+	}
+
+	for _, tt := range tests {
+		var r ProbeResult
+		r.MissingLibraries = tt.missing
+		r.RequiredThreadLevel = tt.threadLevel
+		parseProbeOutput(tt.output, &r)
+		predict(&r, tt.libPrefix)
+		if r.Compatible != tt.compatible {
+			t.Fatalf("%s: prediction is %v instead of %v (%s)", tt.name, r.Compatible, tt.compatible, r.Reason)
+		}
+		if !r.Compatible && r.Reason == "" {
+			t.Fatalf("%s: no reason given for the incompatibility", tt.name)
+		}
+	}
+}
+
+func TestCompileTestSourceBuilder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-probe-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := syexec.NewFakeRunner()
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	// In the tool-in-container mode, MPI was built in the builder container where the probe is compiled
+	hostBuildEnv := buildenv.Info{InstallDir: filepath.Join(dir, "mpi_install_openmpi-4.0.2")}
+	sysCfg := sys.Config{SingularityBin: "/usr/local/bin/singularity", BuilderImage: filepath.Join(dir, "builder.sif")}
+	_, err = compileProbe(&hostBuildEnv, dir, &sysCfg)
+	if err != nil {
+		t.Fatalf("compileProbe() failed: %s", err)
+	}
+	cmdLines := fake.CmdLines()
+	mpicc := filepath.Join(hostBuildEnv.InstallDir, "bin", probeCompilerName)
+	if len(cmdLines) != 1 || !strings.HasPrefix(cmdLines[0], "singularity exec ") || !strings.Contains(cmdLines[0], sysCfg.BuilderImage+" "+mpicc+" ") {
+		t.Fatalf("the probe is not compiled in the builder container: %q", cmdLines)
+	}
+}
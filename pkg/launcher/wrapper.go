@@ -0,0 +1,72 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package launcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/job"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// wrapperDirName is the name of the directory gathering the output files of the wrapper of
+	// the ranks, e.g., the logs of valgrind, in the errors or results directory of an experiment
+	wrapperDirName = "wrapper"
+)
+
+// getResultsDir returns the directory where the artifacts of a successful experiment are stored
+func getResultsDir(hostMPI *implem.Info, containerMPI *implem.Info, sysCfg *sys.Config) string {
+	experimentName := hostMPI.Version + "-" + containerMPI.Version
+	return filepath.Join(sysCfg.BinPath, "results", hostMPI.ID, experimentName)
+}
+
+// setupWrapper sets the wrapper of the ranks of a job and returns the temporary directory where
+// its output files are saved; nothing is done when no wrapper is configured
+func setupWrapper(j *job.Job, sysCfg *sys.Config) (string, error) {
+	if sysCfg.Wrapper == "" {
+		return "", nil
+	}
+
+	// The directory must be accessible from all the nodes running ranks
+	err := os.MkdirAll(sysCfg.BinPath, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", sysCfg.BinPath, err)
+	}
+	outputDir, err := ioutil.TempDir(sysCfg.BinPath, "wrapper-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the output directory of the wrapper: %s", err)
+	}
+	j.Wrapper, err = job.GetWrapper(sysCfg.Wrapper, outputDir)
+	if err != nil {
+		os.RemoveAll(outputDir)
+		return "", err
+	}
+	return outputDir, nil
+}
+
+// saveWrapperOutput moves the output files of the wrapper of the ranks to the errors or results
+// directory of an experiment
+func saveWrapperOutput(outputDir string, targetDir string) (string, error) {
+	dest := filepath.Join(targetDir, wrapperDirName)
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", targetDir, err)
+	}
+	err = os.RemoveAll(dest)
+	if err != nil {
+		return "", fmt.Errorf("failed to remove %s: %s", dest, err)
+	}
+	err = os.Rename(outputDir, dest)
+	if err != nil {
+		return "", fmt.Errorf("failed to move %s to %s: %s", outputDir, dest, err)
+	}
+	return dest, nil
+}
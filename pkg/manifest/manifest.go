@@ -13,6 +13,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/gvallee/go_util/pkg/util"
@@ -67,7 +68,8 @@ func Create(filepath string, entries []string) error {
 }
 
 // Check parses a given manifest and check that all hash there are in the manifest are the same than current
-// files
+// files; only the lines with the hash of a file, i.e., '<absolute path>: <hash>', are checked, the
+// manifests of commands also describing the command, e.g., 'Command: ./configure'
 func Check(path string) error {
 	if !util.FileExists(path) {
 		// This is currently not an error, just log the fact there is no manifest
@@ -85,7 +87,7 @@ func Check(path string) error {
 		lines := strings.Split(content, "\n")
 		for _, line := range lines {
 			tokens := strings.Split(line, ": ")
-			if len(tokens) == 2 {
+			if len(tokens) == 2 && filepath.IsAbs(tokens[0]) {
 				file := tokens[0]
 				recordedHash := tokens[1]
 				curFileHash := HashFiles([]string{file})
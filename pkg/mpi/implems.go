@@ -0,0 +1,16 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpi
+
+// The implementations of MPI register themselves with the mpiplugin package when their package
+// is imported. Implementations maintained out of tree are added by importing their package here.
+import (
+	_ "github.com/sylabs/singularity-mpi/internal/pkg/impi"
+	_ "github.com/sylabs/singularity-mpi/internal/pkg/mpich"
+	_ "github.com/sylabs/singularity-mpi/internal/pkg/openmpi"
+	_ "github.com/sylabs/singularity-mpi/internal/pkg/oshmem"
+	_ "github.com/sylabs/singularity-mpi/internal/pkg/sos"
+)
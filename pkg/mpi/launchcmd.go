@@ -0,0 +1,234 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// LauncherKey is the key used in the tool's configuration file to specify the template of the
+	// command used to start MPI jobs, e.g., launcher = /path/to/wrapper {np} {hostfile} {cmd}
+	LauncherKey = "launcher"
+
+	// HostfileKey and RankfileKey are the keys used in the tool's configuration file to specify the
+	// hostfile and the rankfile of the jobs, e.g., to reproduce a failure specific to a site
+	HostfileKey = "hostfile"
+	RankfileKey = "rankfile"
+
+	// MpirunTag is the tag of a launch command template replaced by the path to the default
+	// launcher of the MPI implementation, e.g., <path/to/mpi/install>/bin/mpirun
+	MpirunTag = "{mpirun}"
+
+	// NPTag is the tag of a launch command template replaced by the number of ranks
+	NPTag = "{np}"
+
+	// HostfileTag is the tag of a launch command template replaced by the path to the hostfile
+	HostfileTag = "{hostfile}"
+
+	// RankfileTag is the tag of a launch command template replaced by the path to the rankfile
+	RankfileTag = "{rankfile}"
+
+	// CmdTag is the tag of a launch command template replaced by the command to start on each rank
+	CmdTag = "{cmd}"
+
+	// defaultLaunchTemplate is the template used when the MPI implementation does not require a specific one
+	defaultLaunchTemplate = MpirunTag + " -np " + NPTag + " " + CmdTag
+
+	// ompiMapByRankfileVersion is the version of Open MPI from which the rankfile is specified with
+	// --map-by rankfile:file=<path>, -rf being deprecated
+	ompiMapByRankfileVersion = "5.0"
+
+	// ompiMapByRankfileLaunchTemplate is the template of the launch command of Open MPI and OSHMEM
+	// from ompiMapByRankfileVersion
+	ompiMapByRankfileLaunchTemplate = MpirunTag + " -np " + NPTag + " -hostfile " + HostfileTag + " --map-by rankfile:file=" + RankfileTag + " " + CmdTag
+)
+
+// defaultLaunchTemplates is the template of the launch command used by default for each MPI
+// implementation, with the option of its launcher specifying the hostfile (and the rankfile),
+// which is dropped when no hostfile is specified. oshrun, the launcher of the OpenSHMEM
+// implementations, is orterun with OSHMEM and a wrapper of the Hydra mpiexec with Sandia OpenSHMEM.
+var defaultLaunchTemplates = map[string]string{
+	implem.OMPI:   MpirunTag + " -np " + NPTag + " -hostfile " + HostfileTag + " -rf " + RankfileTag + " " + CmdTag,
+	implem.MPICH:  MpirunTag + " -np " + NPTag + " -f " + HostfileTag + " " + CmdTag,
+	implem.IMPI:   MpirunTag + " -np " + NPTag + " -machinefile " + HostfileTag + " " + CmdTag,
+	implem.OSHMEM: MpirunTag + " -np " + NPTag + " -hostfile " + HostfileTag + " -rf " + RankfileTag + " " + CmdTag,
+	implem.SOS:    MpirunTag + " -np " + NPTag + " -f " + HostfileTag + " " + CmdTag,
+}
+
+var tagRegex = regexp.MustCompile(`{[^}]*}`)
+
+// LaunchInfo gathers all the values used to generate a launch command from a template
+type LaunchInfo struct {
+	// Mpirun is the path to the default launcher of the MPI implementation
+	Mpirun string
+
+	// NP is the number of ranks (0 if unknown)
+	NP int
+
+	// Hostfile is the path to the hostfile (optional)
+	Hostfile string
+
+	// Rankfile is the path to the rankfile (optional)
+	Rankfile string
+
+	// Cmd is the command to start on each rank, with its arguments
+	Cmd []string
+}
+
+// GetDefaultLaunchTemplate returns the default template of the command used to start jobs with
+// a given MPI implementation
+func GetDefaultLaunchTemplate(mpiID string) string {
+	if tmpl, ok := defaultLaunchTemplates[mpiID]; ok {
+		return tmpl
+	}
+	return defaultLaunchTemplate
+}
+
+// getDefaultVersionLaunchTemplate returns the default template of the command used to start jobs
+// with a given version of a MPI implementation, e.g., Open MPI 5 deprecated the -rf option
+func getDefaultVersionLaunchTemplate(mpiCfg *implem.Info) string {
+	if (mpiCfg.ID == implem.OMPI || mpiCfg.ID == implem.OSHMEM) && mpiCfg.Version != "" && implem.CompareVersions(mpiCfg.Version, ompiMapByRankfileVersion) >= 0 {
+		return ompiMapByRankfileLaunchTemplate
+	}
+	return GetDefaultLaunchTemplate(mpiCfg.ID)
+}
+
+// GetLaunchTemplate returns the template of the command used to start jobs with a given MPI
+// implementation: the template from the system configuration if set, the default one for the
+// version of the MPI implementation otherwise
+func GetLaunchTemplate(mpiCfg *implem.Info, sysCfg *sys.Config) string {
+	if sysCfg.LaunchTemplate != "" {
+		return sysCfg.LaunchTemplate
+	}
+	if mpiCfg == nil {
+		return defaultLaunchTemplate
+	}
+	return getDefaultVersionLaunchTemplate(mpiCfg)
+}
+
+// ValidateLaunchTemplate checks whether a launch command template is valid, i.e., only uses
+// known tags, includes the command to execute and, when the launcher is specified with an
+// absolute path, points to an existing file
+func ValidateLaunchTemplate(tmpl string) error {
+	tokens := strings.Fields(tmpl)
+	if len(tokens) == 0 {
+		return fmt.Errorf("empty launch command template")
+	}
+
+	for _, tag := range tagRegex.FindAllString(tmpl, -1) {
+		if tag != MpirunTag && tag != NPTag && tag != HostfileTag && tag != RankfileTag && tag != CmdTag {
+			return fmt.Errorf("unknown tag %s in launch command template '%s'", tag, tmpl)
+		}
+	}
+
+	if !strings.Contains(tmpl, CmdTag) {
+		return fmt.Errorf("launch command template '%s' does not include %s", tmpl, CmdTag)
+	}
+
+	if filepath.IsAbs(tokens[0]) && !util.FileExists(tokens[0]) {
+		return fmt.Errorf("launcher %s does not exist", tokens[0])
+	}
+
+	return nil
+}
+
+// ValidateHostfile checks whether a hostfile or a rankfile can be used by the jobs, i.e., it is an
+// existing file that is not empty
+func ValidateHostfile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to access %s: %s", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a file", path)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	return nil
+}
+
+// ExpandLaunchTemplate generates a launch command from a template and returns the binary to
+// execute and its arguments. When a tag has no value, e.g., the number of ranks is unknown, the
+// argument with the tag is removed, as well as the preceding option of the template (e.g., -np) if
+// any. A hostfile or a rankfile cannot be used with a template that does not include its tag,
+// e.g., the launchers of MPICH and Intel MPI do not support rankfiles.
+func ExpandLaunchTemplate(tmpl string, info *LaunchInfo) (string, []string, error) {
+	err := ValidateLaunchTemplate(tmpl)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.Hostfile != "" && !strings.Contains(tmpl, HostfileTag) {
+		return "", nil, fmt.Errorf("launch command template '%s' does not support hostfiles (%s)", tmpl, HostfileTag)
+	}
+	if info.Rankfile != "" && !strings.Contains(tmpl, RankfileTag) {
+		return "", nil, fmt.Errorf("launch command template '%s' does not support rankfiles (%s)", tmpl, RankfileTag)
+	}
+
+	np := ""
+	if info.NP > 0 {
+		np = strconv.Itoa(info.NP)
+	}
+	values := map[string]string{
+		MpirunTag:   info.Mpirun,
+		NPTag:       np,
+		HostfileTag: info.Hostfile,
+		RankfileTag: info.Rankfile,
+	}
+
+	var cmd []string
+	// lastOption is the index in cmd of the last option of the template without tag, which is
+	// removed with the following argument if its tag has no value; the arguments of the command
+	// are never removed
+	lastOption := -1
+	for _, token := range strings.Fields(tmpl) {
+		if token == CmdTag {
+			cmd = append(cmd, info.Cmd...)
+			continue
+		}
+
+		tagged := false
+		dropped := false
+		for tag, value := range values {
+			if !strings.Contains(token, tag) {
+				continue
+			}
+			tagged = true
+			if value == "" {
+				dropped = true
+				break
+			}
+			token = strings.Replace(token, tag, value, -1)
+		}
+		if dropped {
+			if lastOption >= 0 && lastOption == len(cmd)-1 {
+				cmd = cmd[:lastOption]
+			}
+			lastOption = -1
+			continue
+		}
+		if !tagged && strings.HasPrefix(token, "-") {
+			lastOption = len(cmd)
+		}
+		cmd = append(cmd, token)
+	}
+
+	if len(cmd) == 0 {
+		return "", nil, fmt.Errorf("launch command template '%s' results in an empty command", tmpl)
+	}
+
+	return cmd[0], cmd[1:], nil
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestExpandLaunchTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl        string
+		np          int
+		hostfile    string
+		rankfile    string
+		cmd         []string
+		expectedCmd string
+		expectError bool
+	}{
+		{
+			tmpl:        defaultLaunchTemplate,
+			np:          2,
+			expectedCmd: "/opt/mpi/bin/mpirun -np 2 singularity exec app.sif app",
+		},
+		{
+			tmpl:        defaultLaunchTemplate,
+			expectedCmd: "/opt/mpi/bin/mpirun singularity exec app.sif app",
+		},
+		{
+			tmpl:        "mpiexec.hydra -n {np} -f {hostfile} {cmd}",
+			np:          4,
+			hostfile:    "/tmp/hosts",
+			expectedCmd: "mpiexec.hydra -n 4 -f /tmp/hosts singularity exec app.sif app",
+		},
+		{
+			tmpl:        "wrapper --hostfile={hostfile} {cmd}",
+			np:          4,
+			expectedCmd: "wrapper singularity exec app.sif app",
+		},
+		{
+			tmpl:        GetDefaultLaunchTemplate(implem.OMPI),
+			np:          2,
+			hostfile:    "/tmp/hosts",
+			rankfile:    "/tmp/ranks",
+			expectedCmd: "/opt/mpi/bin/mpirun -np 2 -hostfile /tmp/hosts -rf /tmp/ranks singularity exec app.sif app",
+		},
+		{
+			tmpl:        GetLaunchTemplate(&implem.Info{ID: implem.OMPI, Version: "5.0.2"}, &sys.Config{}),
+			np:          2,
+			hostfile:    "/tmp/hosts",
+			rankfile:    "/tmp/ranks",
+			expectedCmd: "/opt/mpi/bin/mpirun -np 2 -hostfile /tmp/hosts --map-by rankfile:file=/tmp/ranks singularity exec app.sif app",
+		},
+		{
+			tmpl:        GetLaunchTemplate(&implem.Info{ID: implem.OMPI, Version: "5.0.2"}, &sys.Config{}),
+			np:          2,
+			expectedCmd: "/opt/mpi/bin/mpirun -np 2 singularity exec app.sif app",
+		},
+		{
+			tmpl:        GetLaunchTemplate(&implem.Info{ID: implem.OMPI, Version: "4.1.5"}, &sys.Config{}),
+			rankfile:    "/tmp/ranks",
+			expectedCmd: "/opt/mpi/bin/mpirun -rf /tmp/ranks singularity exec app.sif app",
+		},
+		{
+			tmpl:        GetDefaultLaunchTemplate(implem.MPICH),
+			np:          2,
+			expectedCmd: "/opt/mpi/bin/mpirun -np 2 singularity exec app.sif app",
+		},
+		{
+			tmpl:        GetDefaultLaunchTemplate(implem.IMPI),
+			hostfile:    "/tmp/hosts",
+			expectedCmd: "/opt/mpi/bin/mpirun -machinefile /tmp/hosts singularity exec app.sif app",
+		},
+		{
+			tmpl:        "mpirun {cmd} -hostfile {hostfile}",
+			cmd:         []string{"app", "-v"},
+			expectedCmd: "mpirun app -v",
+		},
+		{
+			tmpl:        "{mpirun} {cmd} {hostfile}",
+			cmd:         []string{"app", "-v"},
+			expectedCmd: "/opt/mpi/bin/mpirun app -v",
+		},
+		{
+			tmpl:        GetDefaultLaunchTemplate(implem.MPICH),
+			rankfile:    "/tmp/ranks",
+			expectError: true,
+		},
+		{
+			tmpl:        defaultLaunchTemplate,
+			hostfile:    "/tmp/hosts",
+			expectError: true,
+		},
+		{
+			tmpl:        "mpirun -np {np}",
+			expectError: true,
+		},
+		{
+			tmpl:        "mpirun -np {nranks} {cmd}",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		var info LaunchInfo
+		info.Mpirun = "/opt/mpi/bin/mpirun"
+		info.NP = tt.np
+		info.Hostfile = tt.hostfile
+		info.Rankfile = tt.rankfile
+		info.Cmd = []string{"singularity", "exec", "app.sif", "app"}
+		if tt.cmd != nil {
+			info.Cmd = tt.cmd
+		}
+		bin, args, err := ExpandLaunchTemplate(tt.tmpl, &info)
+		if tt.expectError {
+			if err == nil {
+				t.Fatalf("template '%s' was expected to be invalid", tt.tmpl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ExpandLaunchTemplate() failed for '%s': %s", tt.tmpl, err)
+		}
+		cmd := bin + " " + strings.Join(args, " ")
+		if cmd != tt.expectedCmd {
+			t.Fatalf("command is '%s' instead of '%s'", cmd, tt.expectedCmd)
+		}
+	}
+}
@@ -9,14 +9,14 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
+	"strings"
 
-	"github.com/sylabs/singularity-mpi/internal/pkg/impi"
-	"github.com/sylabs/singularity-mpi/internal/pkg/openmpi"
 	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/container"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
@@ -41,11 +41,7 @@ func GetPathToMpirun(mpiCfg *implem.Info, env *buildenv.Info) (string, error) {
 		return "", fmt.Errorf("invalid parameter(s)")
 	}
 
-	path := filepath.Join(env.InstallDir, "bin", "mpirun")
-	// Intel MPI is installing the binaries and libraries in a quite complex setup
-	if mpiCfg.ID == implem.IMPI {
-		path = impi.GetPathToMpirun(env)
-	}
+	path := mpiplugin.Get(mpiCfg.ID).MpirunPath(env)
 
 	// the path to mpiexec is something like <path_to_mpi_install/bin/mpiexec> and we need <path_to_mpi_install>
 	basedir := filepath.Dir(path)
@@ -58,23 +54,20 @@ func GetPathToMpirun(mpiCfg *implem.Info, env *buildenv.Info) (string, error) {
 	return path, nil
 }
 
-// GetMpirunArgs returns the arguments required by a mpirun
+// GetMpirunArgs returns the arguments required by a mpirun. The ranks are executed with the
+// Singularity binary of the configuration, e.g., the version pinned by an experiment, and with the
+// container runtime found in the PATH of the compute nodes when none is set.
 func GetMpirunArgs(myHostMPICfg *implem.Info, hostBuildEnv *buildenv.Info, app *app.Info, syContainer *container.Config, sysCfg *sys.Config) ([]string, error) {
-	var extraArgs []string
-	args := []string{"singularity"}
+	runtime := sysCfg.SingularityBin
+	if runtime == "" {
+		runtime = sys.GetContainerRuntime(runtime)
+	}
+	args := []string{runtime}
 	args = append(args, container.GetMPIExecCfg(myHostMPICfg, hostBuildEnv, syContainer, sysCfg)...)
 	args = append(args, syContainer.Path, app.BinPath)
+	args = append(args, app.Args...)
 
-	// We really do not want to do this but MPICH is being picky about args so for now, it will do the job.
-	switch myHostMPICfg.ID {
-	/*
-		case implem.IMPI:
-			extraArgs := impi.GetExtraMpirunArgs(myHostMPICfg, sysCfg)
-	*/
-	case implem.OMPI:
-		extraArgs = append(extraArgs, openmpi.GetExtraMpirunArgs(sysCfg)...)
-	}
-
+	extraArgs := mpiplugin.Get(myHostMPICfg.ID).MpirunArgs(myHostMPICfg, hostBuildEnv, sysCfg)
 	if len(extraArgs) > 0 {
 		args = append(extraArgs, args...)
 	}
@@ -82,6 +75,39 @@ func GetMpirunArgs(myHostMPICfg *implem.Info, hostBuildEnv *buildenv.Info, app *
 	return args, nil
 }
 
+// GetRelocationEnv returns the environment variables of the launch command making the MPI of the
+// host find its files when bind-mounted in a container (bind model): mpirun and the daemons use
+// the installation of the host while the ranks use it from the directory where it is mounted in
+// the container, e.g., OPAL_PREFIX=/opt/sympi/mpi_install_openmpi-4.0.2 and
+// SINGULARITYENV_OPAL_PREFIX=/opt/openmpi. No variable is required with the other models.
+func GetRelocationEnv(hostMPI *implem.Info, hostBuildEnv *buildenv.Info, syContainer *container.Config, sysCfg *sys.Config) []string {
+	if hostMPI == nil || hostBuildEnv == nil || syContainer == nil || syContainer.Model != container.BindModel || syContainer.MPIDir == "" {
+		return nil
+	}
+
+	plugin := mpiplugin.Get(hostMPI.ID)
+	env := plugin.RelocationEnv(hostMPI, hostBuildEnv.InstallDir)
+	for _, e := range plugin.RelocationEnv(hostMPI, syContainer.MPIDir) {
+		tokens := strings.SplitN(e, "=", 2)
+		env = append(env, sysCfg.ContainerEnv(tokens[0], tokens[1]))
+	}
+	return env
+}
+
+// GetTuningEnv returns the environment variables of the launch command pointing mpirun to the
+// tuning file of the site for the MPI of the host; empty when the site has none. The ranks use the
+// copy of the tuning file in the container, set in its environment when the container was created.
+func GetTuningEnv(hostMPI *implem.Info, sysCfg *sys.Config) []string {
+	if hostMPI == nil {
+		return nil
+	}
+	p := sys.GetTuningProfile(sysCfg, hostMPI.ID)
+	if p == nil {
+		return nil
+	}
+	return mpiplugin.Get(hostMPI.ID).TuningEnv(hostMPI, p.File)
+}
+
 // GetMPIConfigFile returns the path to the configuration file for a given MPI implementation
 func GetMPIConfigFile(id string, sysCfg *sys.Config) string {
 	return filepath.Join(sysCfg.EtcDir, sys.GetMPIConfigFileName(id))
@@ -0,0 +1,81 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestGetRelocationEnv(t *testing.T) {
+	tests := []struct {
+		model    string
+		version  string
+		bin      string
+		expected string
+	}{
+		{
+			model:    container.BindModel,
+			version:  "4.0.2",
+			bin:      "/usr/bin/singularity",
+			expected: "OPAL_PREFIX=/sympi/mpi_install_openmpi-4.0.2 SINGULARITYENV_OPAL_PREFIX=/opt/openmpi",
+		},
+		{
+			model:    container.BindModel,
+			version:  "5.0.0",
+			bin:      "/usr/bin/apptainer",
+			expected: "OPAL_PREFIX=/sympi/mpi_install_openmpi-5.0.0 PRTE_PREFIX=/sympi/mpi_install_openmpi-5.0.0 PMIX_PREFIX=/sympi/mpi_install_openmpi-5.0.0 APPTAINERENV_OPAL_PREFIX=/opt/openmpi APPTAINERENV_PRTE_PREFIX=/opt/openmpi APPTAINERENV_PMIX_PREFIX=/opt/openmpi",
+		},
+		{
+			model:   container.HybridModel,
+			version: "4.0.2",
+			bin:     "/usr/bin/singularity",
+		},
+	}
+
+	for _, tt := range tests {
+		hostMPI := implem.Info{ID: implem.OMPI, Version: tt.version}
+		env := buildenv.Info{InstallDir: "/sympi/mpi_install_openmpi-" + tt.version}
+		c := container.Config{Model: tt.model, MPIDir: "/opt/openmpi"}
+		sysCfg := sys.Config{SingularityBin: tt.bin}
+		relocationEnv := strings.Join(GetRelocationEnv(&hostMPI, &env, &c, &sysCfg), " ")
+		if relocationEnv != tt.expected {
+			t.Fatalf("invalid relocation environment for Open MPI %s with the %s model: %s instead of %s", tt.version, tt.model, relocationEnv, tt.expected)
+		}
+	}
+}
+
+func TestGetMpirunArgs(t *testing.T) {
+	hostMPI := implem.Info{ID: implem.MPICH, Version: "3.3.2"}
+	hostBuildEnv := buildenv.Info{InstallDir: "/opt/sympi/mpi_install_mpich-3.3.2"}
+	c := container.Config{Path: "/opt/sympi/app.sif", Model: container.HybridModel}
+	a := app.Info{BinPath: "/opt/app", Args: []string{"-v"}}
+
+	tests := []struct {
+		bin      string
+		expected string
+	}{
+		// The version of Singularity pinned by an experiment is used on the compute nodes
+		{bin: "/home/user/.sympi/singularity_install_3.5.3/bin/singularity", expected: "/home/user/.sympi/singularity_install_3.5.3/bin/singularity"},
+		{bin: "", expected: sys.RuntimeSingularity},
+	}
+	for _, tt := range tests {
+		sysCfg := sys.Config{SingularityBin: tt.bin}
+		args, err := GetMpirunArgs(&hostMPI, &hostBuildEnv, &a, &c, &sysCfg)
+		if err != nil {
+			t.Fatalf("GetMpirunArgs() failed: %s", err)
+		}
+		if len(args) == 0 || args[0] != tt.expected || args[len(args)-1] != "-v" {
+			t.Fatalf("GetMpirunArgs() returned %s with the binary '%s'", strings.Join(args, " "), tt.bin)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+/*
+ * mpiplugin is a package that provides the interface implemented by all the MPI implementations
+ * and the registry used by the rest of the code to get the implementation associated to a
+ * given identifier. Implementations register themselves from the init() function of their
+ * package, which only needs to be imported (see pkg/mpi/implems.go for the built-in ones).
+ */
+package mpiplugin
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/autotools"
+	"github.com/sylabs/singularity-mpi/internal/pkg/deffile"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/checker"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// MPIImplementation is the interface implemented by the MPI implementations, it gathers all the
+// operations specific to an implementation of MPI
+type MPIImplementation interface {
+	// ID returns the identifier of the implementation, e.g., openmpi
+	ID() string
+
+	// ConfigFileName returns the name of the configuration file listing the versions that can be installed
+	ConfigFileName() string
+
+	// Versions returns the versions that can be installed, the value of each key/value pair being the URL of the source code
+	Versions(*sys.Config) ([]kv.KV, error)
+
+	// Configure configures the source code of the implementation
+	Configure(*buildenv.Info, *sys.Config, []string) error
+
+	// ConfigureArgs returns the extra arguments of configure for a given version
+	ConfigureArgs(*implem.Info, *sys.Config) []string
+
+	// DeffileTemplateTags returns the tags used in the definition file templates
+	DeffileTemplateTags() deffile.TemplateTags
+
+	// DeffileTemplate returns the name of the definition file template for a given distribution, an
+	// empty string when the definition file is created from scratch
+	DeffileTemplate(string, *sys.Config) string
+
+	// CreateHybridDeffile creates the definition file of a container with an application and the
+	// implementation installed in the container (hybrid model)
+	CreateHybridDeffile(*app.Info, *deffile.DefFileData, *sys.Config) error
+
+	// ContainerEnv returns the commands setting up the runtime environment of a given version in
+	// containers, added to the environment section of the definition files once MPI_DIR is set to
+	// the installation directory
+	ContainerEnv(*implem.Info, *sys.Config) []string
+
+	// RelocationEnv returns the environment variables a given version needs to find its files
+	// when the installation is accessed in a directory other than the one where it was installed,
+	// e.g., OPAL_PREFIX=/opt/openmpi when bind-mounted in /opt/openmpi in a container
+	RelocationEnv(*implem.Info, string) []string
+
+	// TuningEnv returns the environment variables pointing a given version to a tuning file of the
+	// site, e.g., OMPI_MCA_mca_base_param_files=/etc/sympi/tuning/openmpi/mca-params.conf; empty when
+	// the implementation does not support tuning files
+	TuningEnv(*implem.Info, string) []string
+
+	// MpirunArgs returns the extra arguments of mpirun for a given version installed in the build
+	// environment
+	MpirunArgs(*implem.Info, *buildenv.Info, *sys.Config) []string
+
+	// MpirunPath returns the path to mpirun in an installation
+	MpirunPath(*buildenv.Info) string
+
+	// EnvPath returns the value of PATH to use an installation
+	EnvPath(*buildenv.Info) string
+
+	// EnvLDPath returns the value of LD_LIBRARY_PATH to use an installation
+	EnvLDPath(*buildenv.Info) string
+
+	// HasInstaller specifies whether the implementation is installed with its own installer
+	// instead of make, in which case Install and Uninstall are used
+	HasInstaller() bool
+
+	// Install installs the implementation with its own installer
+	Install(*buildenv.Info, *sys.Config) syexec.Result
+
+	// Uninstall removes an installation performed by Install
+	Uninstall(*buildenv.Info, *sys.Config) syexec.Result
+
+	// MirrorConfigureArgs introspects an installation on the host, based on its installation
+	// directory, and returns the arguments of configure to build MPI with the same important
+	// settings, e.g., threading level, Fortran bindings or CUDA support
+	MirrorConfigureArgs(string) ([]string, error)
+
+	// DVMCommands returns the commands used to start a persistent distributed virtual machine
+	// (DVM), submit jobs to it and stop it, for a given version
+	DVMCommands(*implem.Info) (DVMCommands, error)
+
+	// ThreadLevelConfigureArgs returns the extra arguments of configure for a given version to
+	// provide a thread level, e.g., implem.ThreadMultiple; empty when nothing specific is required
+	ThreadLevelConfigureArgs(*implem.Info, string) []string
+
+	// LibraryVersionPrefix returns the beginning of the string returned by MPI_Get_library_version,
+	// e.g., 'Open MPI', used to identify the implementation used at run time; it is empty when unknown
+	LibraryVersionPrefix() string
+}
+
+const (
+	// DVMURITag is the tag replaced, in the DVM commands, by the path to the file where the DVM reports
+	// its URI (or its output when the DVM has no URI)
+	DVMURITag = "#URI"
+
+	// DVMNPTag is the tag replaced by the number of ranks in the command submitting a job to the DVM
+	DVMNPTag = "#NP"
+)
+
+// DVMCommands gathers the commands to use a persistent distributed virtual machine (DVM), i.e.,
+// MPI daemons that keep running between jobs to reduce their startup cost
+type DVMCommands struct {
+	// Start starts the DVM, the command must return once the DVM is running
+	Start []string
+
+	// ReportsURI specifies that Start daemonizes and that the DVM is only ready once it has written its URI to
+	// DVMURITag; the URI is then reported through a pipe which is read before the start completes
+	ReportsURI bool
+
+	// Submit is the command submitting a job to the DVM, the job's command is appended to it
+	Submit []string
+
+	// Stop stops the DVM, empty when the DVM is stopped with the instance running it
+	Stop []string
+}
+
+// Base provides the default behavior of an implementation based on autotools and make; an
+// implementation embeds it and overwrites the operations that are specific to it
+type Base struct {
+	// Name is the identifier of the implementation, e.g., openmpi
+	Name string
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]MPIImplementation)
+)
+
+// Register adds an implementation of MPI to the registry, it is meant to be called from the init()
+// function of the package of the implementation
+func Register(impl MPIImplementation) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	id := impl.ID()
+	if _, ok := registry[id]; ok {
+		panic(fmt.Sprintf("MPI implementation %s already registered", id))
+	}
+	registry[id] = impl
+	implem.RegisterMPI(id)
+
+	// Tags left in a definition file are reported before building a container
+	tags := impl.DeffileTemplateTags()
+	checker.RegisterTemplateTags(tags.Version, tags.Tarball, tags.URL, tags.Dir, tags.InstallConffile, tags.UninstallConffile, tags.Ifnet)
+}
+
+// IsRegistered checks whether an implementation of MPI is registered
+func IsRegistered(id string) bool {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	_, ok := registry[id]
+	return ok
+}
+
+// Get returns the implementation of MPI registered for a given identifier; the default behavior
+// (see Base) is used when no implementation is registered
+func Get(id string) MPIImplementation {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	if impl, ok := registry[id]; ok {
+		return impl
+	}
+	return &Base{Name: id}
+}
+
+// List returns the identifiers of all the registered implementations of MPI, sorted alphabetically
+func List() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	var ids []string
+	for id := range registry {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ID returns the identifier of the implementation
+func (b *Base) ID() string {
+	return b.Name
+}
+
+// ConfigFileName returns the name of the configuration file listing the versions that can be installed
+func (b *Base) ConfigFileName() string {
+	return sys.GetMPIConfigFileName(b.Name)
+}
+
+// Versions returns the versions listed in the configuration file of the implementation
+func (b *Base) Versions(sysCfg *sys.Config) ([]kv.KV, error) {
+	path := filepath.Join(sysCfg.EtcDir, b.ConfigFileName())
+	kvs, err := configparser.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration from %s: %s", path, err)
+	}
+	return kvs, nil
+}
+
+// Configure is a wrapper around autotool's configure
+func (b *Base) Configure(env *buildenv.Info, sysCfg *sys.Config, extraArgs []string) error {
+	var ac autotools.Config
+	ac.Install = env.InstallDir
+	ac.Source = env.SrcDir
+	ac.ExtraConfigureArgs = extraArgs
+	ac.Builder = env.Builder
+	ac.Env = env.Env
+	err := autotools.Configure(&ac)
+	if err != nil {
+		return fmt.Errorf("failed to configure MPI: %w", err)
+	}
+
+	return nil
+}
+
+// ConfigureArgs returns no extra argument for configure
+func (b *Base) ConfigureArgs(mpi *implem.Info, sysCfg *sys.Config) []string {
+	return nil
+}
+
+// DeffileTemplateTags returns no template tag
+func (b *Base) DeffileTemplateTags() deffile.TemplateTags {
+	return deffile.TemplateTags{}
+}
+
+// DeffileTemplate returns an empty string, the definition file being created from scratch
+func (b *Base) DeffileTemplate(distroName string, sysCfg *sys.Config) string {
+	return ""
+}
+
+// CreateHybridDeffile creates a definition file building the implementation with configure and make
+func (b *Base) CreateHybridDeffile(info *app.Info, data *deffile.DefFileData, sysCfg *sys.Config) error {
+	return deffile.CreateHybridDefFile(info, data, sysCfg)
+}
+
+// ContainerEnv returns the commands adding the man pages and the pkg-config files of an installation
+// with the standard layout, i.e., share/man and lib/pkgconfig, to the environment
+func (b *Base) ContainerEnv(mpi *implem.Info, sysCfg *sys.Config) []string {
+	return []string{
+		"export MANPATH=$MPI_DIR/share/man:$MANPATH",
+		"export PKG_CONFIG_PATH=$MPI_DIR/lib/pkgconfig:$PKG_CONFIG_PATH",
+	}
+}
+
+// RelocationEnv returns no variable, the libraries of the implementation being found with
+// LD_LIBRARY_PATH, e.g., MPICH
+func (b *Base) RelocationEnv(mpi *implem.Info, prefix string) []string {
+	return nil
+}
+
+// TuningEnv returns no variable, the implementation not supporting tuning files by default
+func (b *Base) TuningEnv(mpi *implem.Info, path string) []string {
+	return nil
+}
+
+// MpirunArgs returns no extra argument for mpirun
+func (b *Base) MpirunArgs(mpi *implem.Info, env *buildenv.Info, sysCfg *sys.Config) []string {
+	return nil
+}
+
+// MpirunPath returns the path to mpirun in the bin directory of the installation
+func (b *Base) MpirunPath(env *buildenv.Info) string {
+	return filepath.Join(env.InstallDir, "bin", "mpirun")
+}
+
+// GetOshrunPath returns the path to oshrun, the launcher of the OpenSHMEM jobs, in an installation,
+// e.g., for the MpirunPath of the OpenSHMEM implementations
+func GetOshrunPath(installDir string) string {
+	return filepath.Join(installDir, "bin", "oshrun")
+}
+
+// EnvPath returns the value of PATH with the bin directory of the installation
+func (b *Base) EnvPath(env *buildenv.Info) string {
+	return env.GetEnvPath()
+}
+
+// EnvLDPath returns the value of LD_LIBRARY_PATH with the lib directory of the installation
+func (b *Base) EnvLDPath(env *buildenv.Info) string {
+	return env.GetEnvLDPath()
+}
+
+// HasInstaller returns false, the implementation being installed with make
+func (b *Base) HasInstaller() bool {
+	return false
+}
+
+// Install fails, the implementation being installed with make
+func (b *Base) Install(env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	var res syexec.Result
+	res.Err = fmt.Errorf("no installer for %s", b.Name)
+	return res
+}
+
+// Uninstall fails, the installation directory simply needs to be removed
+func (b *Base) Uninstall(env *buildenv.Info, sysCfg *sys.Config) syexec.Result {
+	var res syexec.Result
+	res.Err = fmt.Errorf("no installer for %s", b.Name)
+	return res
+}
+
+// MirrorConfigureArgs fails, introspecting an installation being specific to each implementation
+func (b *Base) MirrorConfigureArgs(installDir string) ([]string, error) {
+	return nil, fmt.Errorf("mirroring the configuration of a host installation is not supported for %s", b.Name)
+}
+
+// DVMCommands fails, persistent daemons being specific to each implementation
+func (b *Base) DVMCommands(mpi *implem.Info) (DVMCommands, error) {
+	return DVMCommands{}, fmt.Errorf("persistent daemons are not supported for %s", b.Name)
+}
+
+// ThreadLevelConfigureArgs returns no argument, the thread level provided by default is used
+func (b *Base) ThreadLevelConfigureArgs(mpi *implem.Info, level string) []string {
+	return nil
+}
+
+// LibraryVersionPrefix returns an empty string, the implementation used at run time is not checked
+func (b *Base) LibraryVersionPrefix() string {
+	return ""
+}
+
+// RunInstallTool executes a tool from the bin directory of an installation of MPI on the host,
+// e.g., ompi_info, and returns its output
+func RunInstallTool(installDir string, tool string) (string, error) {
+	var env buildenv.Info
+	env.InstallDir = installDir
+
+	var stdout, stderr bytes.Buffer
+	bin := filepath.Join(installDir, "bin", tool)
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), "PATH="+env.GetEnvPath(), "LD_LIBRARY_PATH="+env.GetEnvLDPath())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute %s: %s (stderr: %s)", bin, err, stderr.String())
+	}
+	return stdout.String(), nil
+}
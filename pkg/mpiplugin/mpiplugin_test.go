@@ -0,0 +1,68 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package mpiplugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// vendorMPI is an implementation of MPI registered out of tree
+type vendorMPI struct {
+	Base
+}
+
+func (v *vendorMPI) MpirunArgs(mpi *implem.Info, env *buildenv.Info, sysCfg *sys.Config) []string {
+	return []string{"-vendor-arg"}
+}
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-mpiplugin-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	Register(&vendorMPI{Base{Name: "vendormpi"}})
+	if !IsRegistered("vendormpi") || len(List()) != 1 {
+		t.Fatalf("vendormpi is not registered: %v", List())
+	}
+	if !implem.IsMPI(&implem.Info{ID: "vendormpi"}) {
+		t.Fatalf("vendormpi is not considered as a MPI implementation")
+	}
+
+	impl := Get("vendormpi")
+	args := impl.MpirunArgs(nil, nil, nil)
+	if len(args) != 1 || args[0] != "-vendor-arg" {
+		t.Fatalf("invalid mpirun arguments: %v", args)
+	}
+	env := buildenv.Info{InstallDir: "/opt/vendormpi"}
+	if impl.MpirunPath(&env) != "/opt/vendormpi/bin/mpirun" || impl.HasInstaller() {
+		t.Fatalf("default behavior is not used")
+	}
+
+	var sysCfg sys.Config
+	sysCfg.EtcDir = dir
+	cfgFile := filepath.Join(dir, impl.ConfigFileName())
+	err = ioutil.WriteFile(cfgFile, []byte("1.0=https://example.com/vendormpi-1.0.tar.gz\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", cfgFile, err)
+	}
+	versions, err := impl.Versions(&sysCfg)
+	if err != nil || len(versions) != 1 || versions[0].Key != "1.0" {
+		t.Fatalf("invalid versions: %v (%s)", versions, err)
+	}
+
+	if IsRegistered("unknown") || Get("unknown").ID() != "unknown" {
+		t.Fatalf("invalid default implementation")
+	}
+}
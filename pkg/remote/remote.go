@@ -0,0 +1,377 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// HostKey is the key of the configuration file specifying the remote host, e.g., remote_host = login.cluster.org
+	HostKey = configparser.RemoteKeyPrefix + "host"
+
+	// UserKey is the key of the configuration file specifying the user on the remote host; the
+	// SSH configuration decides when empty
+	UserKey = configparser.RemoteKeyPrefix + "user"
+
+	// WorkdirKey is the key of the configuration file specifying the directory on the remote host
+	// where the tool, its configuration and its workspace are stored
+	WorkdirKey = configparser.RemoteKeyPrefix + "workdir"
+
+	// SSHOptionsKey is the key of the configuration file specifying additional options of ssh and
+	// scp, e.g., remote_ssh_options = -p 2222 -i ~/.ssh/cluster
+	SSHOptionsKey = configparser.RemoteKeyPrefix + "ssh_options"
+
+	// DefaultWorkdir is the default directory on the remote host, relative to the home directory
+	DefaultWorkdir = "sympi-remote"
+
+	// binDir, etcDir, sympiDir and inputDir are the directories of the remote workdir where the
+	// binaries of the tool, the etc directory, the workspace and the input files are stored
+	binDir   = "bin"
+	etcDir   = "etc"
+	sympiDir = "sympi"
+	inputDir = "input"
+
+	// resultsPattern matches the results files created in the remote workdir
+	resultsPattern = "*-results.txt"
+
+	// errorsDir is the directory next to the binaries of the tool with the details of the failed experiments
+	errorsDir = "errors"
+)
+
+// Config is the configuration of the remote host where the tool is executed
+type Config struct {
+	// Host is the remote host, e.g., a cluster login node
+	Host string
+
+	// User is the user on the remote host
+	User string
+
+	// Workdir is the directory on the remote host where everything is stored; a relative path
+	// is relative to the home directory and is made absolute once connected to the remote host
+	Workdir string
+
+	// SSHOptions are additional options of ssh and scp
+	SSHOptions []string
+}
+
+// LoadConfig gets the configuration of the remote host from key/value pairs, e.g., the tool's
+// configuration file; it returns nil when no remote host is configured
+func LoadConfig(kvs []kv.KV) *Config {
+	host := kv.GetValue(kvs, HostKey)
+	if host == "" {
+		return nil
+	}
+
+	cfg := &Config{
+		Host:       host,
+		User:       kv.GetValue(kvs, UserKey),
+		Workdir:    kv.GetValue(kvs, WorkdirKey),
+		SSHOptions: strings.Fields(kv.GetValue(kvs, SSHOptionsKey)),
+	}
+	if cfg.Workdir == "" {
+		cfg.Workdir = DefaultWorkdir
+	}
+	return cfg
+}
+
+// target returns the destination of the SSH commands, e.g., user@host
+func (c *Config) target() string {
+	if c.User == "" {
+		return c.Host
+	}
+	return c.User + "@" + c.Host
+}
+
+// remotePath returns the path of a file in the remote workdir
+func (c *Config) remotePath(elem ...string) string {
+	return filepath.Join(append([]string{c.Workdir}, elem...)...)
+}
+
+// resolveWorkdir makes the remote workdir absolute, using the home directory of the user on the
+// remote host, so the paths in the workdir are valid whatever the current directory of a command
+func (c *Config) resolveWorkdir() error {
+	if filepath.IsAbs(c.Workdir) {
+		return nil
+	}
+	out, err := c.run("cd && pwd")
+	if err != nil {
+		return fmt.Errorf("failed to get the home directory on %s: %s", c.Host, err)
+	}
+	home := strings.TrimSpace(out)
+	if !filepath.IsAbs(home) {
+		return fmt.Errorf("invalid home directory on %s: %s", c.Host, home)
+	}
+	workdir := strings.TrimPrefix(strings.TrimPrefix(c.Workdir, "~"), "/")
+	c.Workdir = filepath.Join(home, workdir)
+	return nil
+}
+
+// shellQuote quotes a string so it is passed as a single argument by the remote shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshCommand creates the command executing a shell command on the remote host
+func (c *Config) sshCommand(cmdline string) *exec.Cmd {
+	args := append([]string{}, c.SSHOptions...)
+	args = append(args, c.target(), cmdline)
+	return exec.Command("ssh", args...)
+}
+
+// scpArgs returns the arguments of scp, whose option for the port is -P instead of -p
+func (c *Config) scpArgs() []string {
+	var args []string
+	for _, o := range c.SSHOptions {
+		if o == "-p" {
+			o = "-P"
+		}
+		args = append(args, o)
+	}
+	return append(args, "-r", "-q")
+}
+
+// run executes a shell command on the remote host and returns its output
+func (c *Config) run(cmdline string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := c.sshCommand(cmdline)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute '%s' on %s - stdout: %s; stderr: %s; err: %s", cmdline, c.Host, stdout.String(), stderr.String(), err)
+	}
+	return stdout.String(), nil
+}
+
+// copyTo copies local files or directories to a directory on the remote host
+func (c *Config) copyTo(srcs []string, dst string) error {
+	if len(srcs) == 0 {
+		return nil
+	}
+	var stderr bytes.Buffer
+	args := append(c.scpArgs(), srcs...)
+	args = append(args, c.target()+":"+dst)
+	cmd := exec.Command("scp", args...)
+	cmd.Stderr = &stderr
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s:%s - stderr: %s; err: %s", strings.Join(srcs, ", "), c.Host, dst, stderr.String(), err)
+	}
+	return nil
+}
+
+// copyFrom copies a file or directory from the remote host to a local directory
+func (c *Config) copyFrom(src string, dst string) error {
+	var stderr bytes.Buffer
+	args := append(c.scpArgs(), c.target()+":"+src, dst)
+	cmd := exec.Command("scp", args...)
+	cmd.Stderr = &stderr
+	err := syexec.RunCmd(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s:%s to %s - stderr: %s; err: %s", c.Host, src, dst, stderr.String(), err)
+	}
+	return nil
+}
+
+// getToolBinaries returns the binaries of the tool, which are expected to be next to the running binary
+func getToolBinaries() ([]string, error) {
+	bin, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot detect the path to the tool: %s", err)
+	}
+	var bins []string
+	for _, name := range []string{"sympi", "sycontainerize", "syrun"} {
+		path := filepath.Join(filepath.Dir(bin), name)
+		if util.FileExists(path) {
+			bins = append(bins, path)
+		}
+	}
+	if len(bins) == 0 {
+		bins = append(bins, bin)
+	}
+	return bins, nil
+}
+
+// getConfigFiles returns the configuration files of the local workspace, e.g., the tool's configuration file
+func getConfigFiles() ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"*.conf", "*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(sys.GetSympiDir(), pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the configuration files: %s", err)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// Setup prepares the remote workdir: the binaries of the tool, the etc directory and the
+// configuration files of the workspace are copied to the remote host
+func Setup(cfg *Config, etc string) error {
+	err := cfg.resolveWorkdir()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("-> Setting up %s:%s\n", cfg.Host, cfg.Workdir)
+	dirs := []string{cfg.remotePath(binDir), cfg.remotePath(sympiDir), cfg.remotePath(inputDir)}
+	var quoted []string
+	for _, d := range dirs {
+		quoted = append(quoted, shellQuote(d))
+	}
+	_, err = cfg.run("mkdir -p " + strings.Join(quoted, " "))
+	if err != nil {
+		return err
+	}
+
+	bins, err := getToolBinaries()
+	if err != nil {
+		return err
+	}
+	err = cfg.copyTo(bins, cfg.remotePath(binDir))
+	if err != nil {
+		return err
+	}
+
+	if etc != "" {
+		// The content of the local etc directory replaces the remote one
+		_, err = cfg.run("rm -rf " + shellQuote(cfg.remotePath(etcDir)))
+		if err != nil {
+			return err
+		}
+		err = cfg.copyTo([]string{etc}, cfg.remotePath(etcDir))
+		if err != nil {
+			return err
+		}
+	}
+
+	configs, err := getConfigFiles()
+	if err != nil {
+		return err
+	}
+	return cfg.copyTo(configs, cfg.remotePath(sympiDir))
+}
+
+// uploadInputs copies the arguments that are local files, e.g., configuration files of
+// experiments, to the remote host and returns the arguments with the remote paths
+func uploadInputs(cfg *Config, args []string) ([]string, error) {
+	var remoteArgs []string
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") && util.FileExists(a) && !util.IsDir(a) {
+			err := cfg.copyTo([]string{a}, cfg.remotePath(inputDir))
+			if err != nil {
+				return nil, err
+			}
+			a = cfg.remotePath(inputDir, filepath.Base(a))
+		}
+		remoteArgs = append(remoteArgs, a)
+	}
+	return remoteArgs, nil
+}
+
+// getCommandLine returns the shell command executing the tool in the remote workdir
+func getCommandLine(cfg *Config, tool string, args []string) string {
+	cmdline := []string{
+		"cd", shellQuote(cfg.Workdir), "&&",
+		sys.SYMPI_INSTALL_DIR_ENV + "=" + shellQuote(cfg.remotePath(sympiDir)),
+		sys.SYMPI_ETC_ENV + "=" + shellQuote(cfg.remotePath(etcDir)),
+		shellQuote(cfg.remotePath(binDir, tool)),
+	}
+	for _, a := range args {
+		cmdline = append(cmdline, shellQuote(a))
+	}
+	return strings.Join(cmdline, " ")
+}
+
+// Run executes the tool on the remote host, the output being streamed to stdout and stderr
+func Run(cfg *Config, tool string, args []string, stdout io.Writer, stderr io.Writer) error {
+	err := cfg.resolveWorkdir()
+	if err != nil {
+		return err
+	}
+
+	remoteArgs, err := uploadInputs(cfg, args)
+	if err != nil {
+		return err
+	}
+
+	cmdline := getCommandLine(cfg, tool, remoteArgs)
+	log.Printf("-> Executing on %s: %s\n", cfg.Host, cmdline)
+	cmd := cfg.sshCommand(cmdline)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = syexec.RunCmd(cmd)
+	if err != nil {
+		return fmt.Errorf("remote execution of %s on %s failed: %s", tool, cfg.Host, err)
+	}
+	return nil
+}
+
+// FetchResults copies the results files and the details of the failed experiments from the
+// remote workdir to a local directory and returns the list of the copied files
+func FetchResults(cfg *Config, dst string) ([]string, error) {
+	err := cfg.resolveWorkdir()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := cfg.run("cd " + shellQuote(cfg.Workdir) + " && ls -d " + resultsPattern + " " + filepath.Join(binDir, errorsDir) + " 2>/dev/null; true")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dst, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %s", dst, err)
+	}
+	var fetched []string
+	for _, f := range strings.Fields(out) {
+		err = cfg.copyFrom(cfg.remotePath(f), dst)
+		if err != nil {
+			return fetched, err
+		}
+		fetched = append(fetched, filepath.Join(dst, filepath.Base(f)))
+	}
+	return fetched, nil
+}
+
+// Execute sets up the remote host, executes the tool with the given arguments and fetches the
+// results back into a local directory
+func Execute(cfg *Config, etc string, tool string, args []string, resultsDir string) error {
+	err := Setup(cfg, etc)
+	if err != nil {
+		return fmt.Errorf("failed to set up %s: %s", cfg.Host, err)
+	}
+
+	runErr := Run(cfg, tool, args, os.Stdout, os.Stderr)
+
+	// Results are fetched even when the execution failed since they include the failures
+	fetched, err := FetchResults(cfg, resultsDir)
+	for _, f := range fetched {
+		fmt.Printf("Fetched %s\n", f)
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch the results from %s: %s", cfg.Host, err)
+	}
+	return nil
+}
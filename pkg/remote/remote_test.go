@@ -0,0 +1,73 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package remote
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-remote-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "singularity-mpi.yaml")
+	content := "tool:\n  build_privilege: \"true\"\nremote:\n  host: login.cluster.org\n  user: jdoe\n  ssh_options: -p 2222\n"
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", path, err)
+	}
+	kvs, err := configparser.Load(path)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+
+	cfg := LoadConfig(kvs)
+	if cfg == nil {
+		t.Fatalf("remote host of %s is not loaded", path)
+	}
+	if cfg.target() != "jdoe@login.cluster.org" || cfg.Workdir != DefaultWorkdir {
+		t.Fatalf("invalid remote configuration: %+v", cfg)
+	}
+	scpArgs := cfg.scpArgs()
+	if scpArgs[0] != "-P" || scpArgs[1] != "2222" {
+		t.Fatalf("invalid scp arguments: %v", scpArgs)
+	}
+
+	// The workdir is relative to the home directory on the remote host
+	fake := syexec.NewFakeRunner()
+	fake.On("ssh -p 2222 jdoe@login.cluster.org cd && pwd", syexec.FakeResult{Stdout: "/home/jdoe\n"})
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+	err = cfg.resolveWorkdir()
+	if err != nil {
+		t.Fatalf("resolveWorkdir() failed: %s", err)
+	}
+	if cfg.Workdir != "/home/jdoe/sympi-remote" {
+		t.Fatalf("the remote workdir is %s instead of /home/jdoe/sympi-remote", cfg.Workdir)
+	}
+	err = cfg.resolveWorkdir()
+	if err != nil || len(fake.Calls()) != 1 {
+		t.Fatalf("the home directory on the remote host was resolved again (%d calls): %v", len(fake.Calls()), err)
+	}
+
+	expected := "cd '/home/jdoe/sympi-remote' && SYMPI_INSTALL_DIR='/home/jdoe/sympi-remote/sympi' SYMPI_ETC='/home/jdoe/sympi-remote/etc' '/home/jdoe/sympi-remote/bin/sympi' '-install' 'it'\\''s'"
+	cmdline := getCommandLine(cfg, "sympi", []string{"-install", "it's"})
+	if cmdline != expected {
+		t.Fatalf("command line is %s instead of %s", cmdline, expected)
+	}
+
+	if LoadConfig(nil) != nil {
+		t.Fatalf("a remote host is configured without %s", HostKey)
+	}
+}
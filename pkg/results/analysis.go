@@ -0,0 +1,312 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package results
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+// Cell is a cell of the pass-rate matrix of an analysis, i.e., the results of the experiments with
+// a version of MPI on the host and a version of MPI in the container, e.g., with several versions
+// of Singularity
+type Cell struct {
+	// HostVersion and ContainerVersion are the versions of MPI on the host and in the container
+	HostVersion      string
+	ContainerVersion string
+
+	// Passed is the number of experiments of the cell that succeeded and Total the number of
+	// experiments of the cell
+	Passed int
+	Total  int
+
+	// Failure is the first experiment of the cell that failed, nil when all of them succeeded
+	Failure *Result
+}
+
+// Pass checks whether all the experiments of a cell succeeded
+func (c *Cell) Pass() bool {
+	return c.Total > 0 && c.Passed == c.Total
+}
+
+// PassRate returns the percentage of the experiments of a cell that succeeded
+func (c *Cell) PassRate() int {
+	if c.Total == 0 {
+		return 0
+	}
+	return c.Passed * 100 / c.Total
+}
+
+// getFailureCategory returns the classification of the failure of a cell used in reports
+func (c *Cell) getFailureCategory() string {
+	if c.Failure == nil || c.Failure.ErrorCategory == "" {
+		return "unclassified"
+	}
+	return c.Failure.ErrorCategory
+}
+
+// Asymmetry is a pair of versions of MPI that work with one version on the host and the other in
+// the container, but not the other way around
+type Asymmetry struct {
+	// Pass is the cell that succeeded and Fail the cell with the versions swapped that failed
+	Pass *Cell
+	Fail *Cell
+}
+
+// Analysis is the analysis of the results of the experiments of a MPI implementation
+type Analysis struct {
+	// HostVersions and ContainerVersions are the versions of MPI on the host and in the
+	// containers, i.e., the rows and the columns of the pass-rate matrix, sorted
+	HostVersions      []string
+	ContainerVersions []string
+
+	// Passed is the number of experiments that succeeded and Total the number of experiments;
+	// standalone experiments are ignored
+	Passed int
+	Total  int
+
+	// Regressions are the cells that failed while they succeeded in the baseline, if any
+	Regressions []*Cell
+
+	// Asymmetries are the pairs of versions that only work one way around
+	Asymmetries []Asymmetry
+
+	// cells are the cells of the pass-rate matrix, by host and container versions
+	cells map[string]*Cell
+}
+
+// getCellKey returns the key of a cell of the pass-rate matrix
+func getCellKey(hostVersion string, containerVersion string) string {
+	return hostVersion + "\t" + containerVersion
+}
+
+// GetCell returns the cell of the pass-rate matrix with the given versions of MPI on the host and
+// in the container, nil when no experiment was executed with these versions
+func (a *Analysis) GetCell(hostVersion string, containerVersion string) *Cell {
+	return a.cells[getCellKey(hostVersion, containerVersion)]
+}
+
+// appendVersion adds a version to a list if not already in it
+func appendVersion(versions []string, v string) []string {
+	for _, existing := range versions {
+		if existing == v {
+			return versions
+		}
+	}
+	return append(versions, v)
+}
+
+// newAnalysis creates the pass-rate matrix of a list of results
+func newAnalysis(r []Result) *Analysis {
+	a := &Analysis{cells: make(map[string]*Cell)}
+	for i := range r {
+		res := &r[i]
+		if res.Category == StandaloneCategory {
+			continue
+		}
+		key := getCellKey(res.HostMPI.Version, res.ContainerMPI.Version)
+		c, ok := a.cells[key]
+		if !ok {
+			c = &Cell{HostVersion: res.HostMPI.Version, ContainerVersion: res.ContainerMPI.Version}
+			a.cells[key] = c
+			a.HostVersions = appendVersion(a.HostVersions, res.HostMPI.Version)
+			a.ContainerVersions = appendVersion(a.ContainerVersions, res.ContainerMPI.Version)
+		}
+		c.Total++
+		a.Total++
+		if res.Pass {
+			c.Passed++
+			a.Passed++
+		} else if c.Failure == nil {
+			c.Failure = res
+		}
+	}
+	sort.Slice(a.HostVersions, func(i, j int) bool {
+		return implem.CompareVersions(a.HostVersions[i], a.HostVersions[j]) < 0
+	})
+	sort.Slice(a.ContainerVersions, func(i, j int) bool {
+		return implem.CompareVersions(a.ContainerVersions[i], a.ContainerVersions[j]) < 0
+	})
+	return a
+}
+
+// findAsymmetries sets the pairs of versions of an analysis that work with one version on the
+// host and the other in the container, but not the other way around
+func (a *Analysis) findAsymmetries() {
+	for _, hostVersion := range a.HostVersions {
+		for _, containerVersion := range a.ContainerVersions {
+			if hostVersion == containerVersion {
+				continue
+			}
+			c := a.GetCell(hostVersion, containerVersion)
+			swapped := a.GetCell(containerVersion, hostVersion)
+			if c != nil && swapped != nil && c.Pass() && !swapped.Pass() {
+				a.Asymmetries = append(a.Asymmetries, Asymmetry{Pass: c, Fail: swapped})
+			}
+		}
+	}
+}
+
+// findRegressions sets the cells of an analysis that failed while they succeeded in a baseline;
+// the cells that are not part of the baseline are not regressions
+func (a *Analysis) findRegressions(baseline *Analysis) {
+	for _, hostVersion := range a.HostVersions {
+		for _, containerVersion := range a.ContainerVersions {
+			c := a.GetCell(hostVersion, containerVersion)
+			prev := baseline.GetCell(hostVersion, containerVersion)
+			if c != nil && prev != nil && prev.Pass() && !c.Pass() {
+				a.Regressions = append(a.Regressions, c)
+			}
+		}
+	}
+}
+
+// AnalyseResults analyses a list of results: it computes the pass-rate matrix by host and
+// container MPI versions, the experiments executed with several versions of Singularity or
+// runtime modes being gathered in the same cell, and identifies the asymmetric failures as well
+// as, when results of a baseline are specified, the regressions
+func AnalyseResults(r []Result, baseline []Result) *Analysis {
+	a := newAnalysis(r)
+	a.findAsymmetries()
+	if baseline != nil {
+		a.findRegressions(newAnalysis(baseline))
+	}
+	return a
+}
+
+// loadAnalysisInput reads the results to analyse from a result file or a compatibility matrix
+func loadAnalysisInput(path string) ([]Result, error) {
+	if !util.FileExists(path) {
+		return nil, fmt.Errorf("%s does not exist", path)
+	}
+	r, err := Load(path)
+	if err == nil {
+		return r, nil
+	}
+	r, matrixErr := LoadCompatibilityMatrix(path)
+	if matrixErr != nil {
+		return nil, fmt.Errorf("%s is neither a result file (%s) nor a compatibility matrix (%s)", path, err, matrixErr)
+	}
+	return r, nil
+}
+
+// AnalyseFile analyses a result file or a compatibility matrix, see AnalyseResults; the baseline,
+// i.e., a result file or a compatibility matrix of a previous execution, is optional
+func AnalyseFile(resultsFile string, baselineFile string) (*Analysis, error) {
+	r, err := loadAnalysisInput(resultsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the results: %s", err)
+	}
+	var baseline []Result
+	if baselineFile != "" {
+		baseline, err = loadAnalysisInput(baselineFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the baseline: %s", err)
+		}
+		// An empty baseline is still a baseline
+		if baseline == nil {
+			baseline = []Result{}
+		}
+	}
+	return AnalyseResults(r, baseline), nil
+}
+
+// String returns a concise summary of an analysis: the pass-rate matrix, with a row per version
+// of MPI on the host and a column per version of MPI in the container, followed by the
+// regressions and the asymmetric failures
+func (a *Analysis) String() string {
+	var sb strings.Builder
+	sb.WriteString("host\\container")
+	for _, containerVersion := range a.ContainerVersions {
+		sb.WriteString(fmt.Sprintf("\t%s", containerVersion))
+	}
+	sb.WriteString("\n")
+	for _, hostVersion := range a.HostVersions {
+		sb.WriteString(hostVersion)
+		for _, containerVersion := range a.ContainerVersions {
+			c := a.GetCell(hostVersion, containerVersion)
+			if c == nil {
+				sb.WriteString("\t-")
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("\t%d%%", c.PassRate()))
+		}
+		sb.WriteString("\n")
+	}
+
+	rate := 0
+	if a.Total > 0 {
+		rate = a.Passed * 100 / a.Total
+	}
+	sb.WriteString(fmt.Sprintf("\n%d/%d experiments passed (%d%%)\n", a.Passed, a.Total, rate))
+
+	if len(a.Regressions) > 0 {
+		sb.WriteString(fmt.Sprintf("%d regression(s) since the baseline:\n", len(a.Regressions)))
+		for _, c := range a.Regressions {
+			sb.WriteString(fmt.Sprintf("  host %s, container %s: %s\n", c.HostVersion, c.ContainerVersion, c.getFailureCategory()))
+		}
+	}
+	if len(a.Asymmetries) > 0 {
+		sb.WriteString(fmt.Sprintf("%d asymmetric failure(s):\n", len(a.Asymmetries)))
+		for _, asym := range a.Asymmetries {
+			sb.WriteString(fmt.Sprintf("  host %s, container %s passes but host %s, container %s fails: %s\n",
+				asym.Pass.HostVersion, asym.Pass.ContainerVersion, asym.Fail.HostVersion, asym.Fail.ContainerVersion, asym.Fail.getFailureCategory()))
+		}
+	}
+	return sb.String()
+}
+
+// GetTestResultsFile returns the name of the result file of a validation test of a MPI
+// implementation, e.g., openmpi-init-results.txt
+func GetTestResultsFile(mpiImplem string, test string) string {
+	return mpiImplem + "-" + test + "-results.txt"
+}
+
+// Analyse checks whether all the result files of a MPI implementation are present and if so,
+// creates the compatibility matrix, then displays its analysis (see AnalyseWithBaseline)
+func Analyse(mpiImplem string) {
+	a, err := AnalyseWithBaseline(mpiImplem, "")
+	if err != nil {
+		log.Printf("[WARN] cannot analyse the results of %s: %s", mpiImplem, err)
+		return
+	}
+	fmt.Print(a.String())
+}
+
+// AnalyseWithBaseline analyses the results of the experiments of a MPI implementation, see
+// AnalyseFile. The compatibility matrix of the MPI implementation, e.g.,
+// openmpi_compatibility_matrix.txt, is first created when the result files of all its validation
+// tests are present (see app.GetValidationTestNames), e.g., openmpi-init-results.txt,
+// openmpi-netpipe-results.txt and openmpi-imb-results.txt, and then analysed.
+func AnalyseWithBaseline(mpiImplem string, baselineFile string) (*Analysis, error) {
+	var testFiles []string
+	found := true
+	for _, test := range app.GetValidationTestNames(&implem.Info{ID: mpiImplem}) {
+		file := GetTestResultsFile(mpiImplem, test)
+		testFiles = append(testFiles, file)
+		found = found && util.FileExists(file)
+	}
+
+	if found {
+		log.Println("All expected result files found, creating compatibility matrix...")
+		err := createCompatibilityMatrix(mpiImplem, testFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the compatibility matrix: %s", err)
+		}
+	}
+
+	matrixFile := mpiImplem + CompatibilityMatrixSuffix
+	if !util.FileExists(matrixFile) {
+		return nil, fmt.Errorf("no result for %s: neither %s nor the result files of the tests exist", mpiImplem, matrixFile)
+	}
+	return AnalyseFile(matrixFile, baselineFile)
+}
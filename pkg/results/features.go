@@ -0,0 +1,42 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package results
+
+import "strings"
+
+const (
+	// FeaturePass is the status of a MPI feature that works in the container
+	FeaturePass = "pass"
+
+	// FeatureFail is the status of a MPI feature that does not work in the container
+	FeatureFail = "fail"
+
+	// FeatureSkip is the status of a MPI feature that could not be probed, e.g., threads when
+	// MPI_THREAD_MULTIPLE is not provided
+	FeatureSkip = "skip"
+)
+
+// Feature is the result of the probe of a MPI feature beyond point-to-point communications, e.g., MPI-IO
+type Feature struct {
+	// Name is the name of the feature, e.g., rma
+	Name string `json:"name"`
+
+	// Status is the outcome of the probe: FeaturePass, FeatureFail or FeatureSkip
+	Status string `json:"status"`
+
+	// Detail explains why the feature failed or was skipped, if any
+	Detail string `json:"detail,omitempty"`
+}
+
+// FormatFeatures returns the feature vector of an experiment on a single line, e.g.,
+// 'rma:pass datatypes:pass mpi-io:fail threads:skip'
+func FormatFeatures(features []Feature) string {
+	var s []string
+	for _, f := range features {
+		s = append(s, f.Name+":"+f.Status)
+	}
+	return strings.Join(s, " ")
+}
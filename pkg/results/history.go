@@ -0,0 +1,103 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package results
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// HistoryRun is the record of one execution of a set of experiments in a history file
+type HistoryRun struct {
+	// Start is the time the execution started
+	Start time.Time `json:"start"`
+
+	// Experiments is the summary of the result of each experiment
+	Experiments []ExperimentSummary `json:"experiments"`
+}
+
+// NewHistoryRun creates the record of an execution that started at a given time
+func NewHistoryRun(start time.Time, r []Result) HistoryRun {
+	return HistoryRun{Start: start, Experiments: Summarize("", r).Experiments}
+}
+
+// AppendHistory appends the record of an execution to a history file, which stores one
+// JSON record per line and is created if it does not exist
+func AppendHistory(historyFile string, run HistoryRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %s", err)
+	}
+
+	f, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", historyFile, err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", historyFile, err)
+	}
+	return nil
+}
+
+// LoadHistory reads all the records of a history file, from the oldest to the most recent one;
+// a history file that does not exist yet is an empty history
+func LoadHistory(historyFile string) ([]HistoryRun, error) {
+	f, err := os.Open(historyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", historyFile, err)
+	}
+	defer f.Close()
+
+	var runs []HistoryRun
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var run HistoryRun
+		err = json.Unmarshal(scanner.Bytes(), &run)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", historyFile, err)
+		}
+		runs = append(runs, run)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", historyFile, err)
+	}
+	return runs, nil
+}
+
+// FindRegressions returns the experiments that failed during an execution while they succeeded
+// during the previous one; experiments that were not part of the previous execution are not
+// regressions
+func FindRegressions(previous *HistoryRun, current *HistoryRun) []ExperimentSummary {
+	if previous == nil || current == nil {
+		return nil
+	}
+
+	passed := make(map[string]bool)
+	for _, e := range previous.Experiments {
+		passed[e.Name] = e.Pass
+	}
+
+	var regressions []ExperimentSummary
+	for _, e := range current.Experiments {
+		if !e.Pass && passed[e.Name] {
+			regressions = append(regressions, e)
+		}
+	}
+	return regressions
+}
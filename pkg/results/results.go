@@ -6,88 +6,267 @@
 package results
 
 import (
-	"bufio"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 )
 
+const (
+	// StandaloneCategory is the category of the results of experiments running containers without MPI
+	StandaloneCategory = "standalone"
+
+	// CompatibilityMatrixSuffix is the suffix of the files of the compatibility matrices, which are
+	// prefixed by the MPI implementation, e.g., openmpi_compatibility_matrix.txt
+	CompatibilityMatrixSuffix = "_compatibility_matrix.txt"
+
+	// singularityPrefix is the prefix of the version of Singularity in result files, e.g., singularity:3.5.3
+	singularityPrefix = "singularity:"
+
+	// runtimeModePrefix is the prefix of the runtime mode of Singularity in result files, e.g., mode:oci
+	runtimeModePrefix = "mode:"
+
+	// distroPrefix is the prefix of the Linux distribution of the container in result files, e.g., distro:centos:7
+	distroPrefix = "distro:"
+
+	// tuningPrefix is the prefix of the tuning profiles of the site active during the experiment in
+	// result files, e.g., tuning:mca-params
+	tuningPrefix = "tuning:"
+
+	// ErrorLaunch is the category of the failures to prepare the command starting the job
+	ErrorLaunch = "launch"
+
+	// ErrorExec is the category of the failures of the command starting the job
+	ErrorExec = "exec"
+
+	// ErrorTimeout is the category of the jobs that did not complete in time
+	ErrorTimeout = "timeout"
+
+	// ErrorUsage is the category of the jobs where mpirun displayed its usage message
+	ErrorUsage = "usage"
+
+	// ErrorOutput is the category of the jobs that completed without the expected output
+	ErrorOutput = "output"
+
+	// ErrorHostInstall is the category of the failures to install MPI on the host
+	ErrorHostInstall = "host-install"
+
+	// ErrorContainerBuild is the category of the failures to create the container
+	ErrorContainerBuild = "container-build"
+
+	// ErrorSingularityInstall is the category of the failures to install Singularity
+	ErrorSingularityInstall = "singularity-install"
+
+	// ErrorPrivileges is the category of the experiments not executed because they require
+	// privileges that are not available, e.g., building the container without sudo nor fakeroot
+	ErrorPrivileges = "privileges"
+
+	// ErrorProbe is the category of the experiments not executed because the compatibility probe
+	// predicted a failure
+	ErrorProbe = "probe"
+
+	// ErrorThreadLevel is the category of the jobs not executed because MPI does not provide the
+	// thread level required by the application in the container
+	ErrorThreadLevel = "thread-level"
+
+	// ErrorGlibcSkew is the category of the jobs not executed because the versions of glibc on the
+	// host and in the container are too different
+	ErrorGlibcSkew = "glibc-skew"
+
+	// ErrorISA is the category of the jobs not executed because the CPUs of the host lack features
+	// required by the container
+	ErrorISA = "isa"
+
+	// ErrorHook is the category of the experiments whose pre-run or post-run hook failed with the
+	// abort policy
+	ErrorHook = "hook"
+
+	// ErrorCheckpoint is the category of the jobs that could not be checkpointed, e.g., because
+	// they completed before the checkpoint
+	ErrorCheckpoint = "checkpoint"
+
+	// ErrorRestart is the category of the jobs that failed once restarted from their checkpoint
+	ErrorRestart = "restart"
+
+	// ErrorUnstable is the category of the experiments executed several times that failed only
+	// during some of the iterations, i.e., intermittent failures
+	ErrorUnstable = "unstable"
+)
+
 // Result represents the result of a given experiment
 type Result struct {
 	HostMPI      implem.Info
 	ContainerMPI implem.Info
 	Pass         bool
 	Note         string
+
+	// Category is the category of the experiment, StandaloneCategory for containers without MPI
+	// and empty for MPI experiments
+	Category string
+
+	// App is the container executed by a standalone experiment
+	App string
+
+	// Singularity is the version of Singularity used to execute the experiment, empty when the
+	// experiment uses the version of Singularity available on the system
+	Singularity string
+
+	// RuntimeMode is the runtime mode of Singularity the experiment was executed with, e.g.,
+	// sys.RuntimeModeOCI; empty for the native mode
+	RuntimeMode string
+
+	// Distro is the Linux distribution of the container the experiment was executed with, e.g.,
+	// centos:7; empty when the experiment uses the distribution of the tool's configuration
+	Distro string
+
+	// Tuning is the names of the tuning profiles of the site active during the experiment,
+	// separated by commas, e.g., mca-params; empty when the site has no tuning file for its MPI
+	Tuning string
+
+	// ErrorCategory is the classification of the failure of the experiment, e.g., ErrorTimeout;
+	// empty when the experiment succeeded or the failure is not classified
+	ErrorCategory string
+
+	// ErrorDir is the directory where the details of the failure (stdout.txt, stderr.txt, and
+	// possibly the build artifacts) are saved, empty when no detail is saved
+	ErrorDir string
+
+	// Duration is the time it took to execute the experiment, 0 when it was not executed; it is
+	// only reported in summaries, not in result files
+	Duration time.Duration
+
+	// HostGlibc and ContainerGlibc are the versions of glibc on the host and in the container,
+	// empty when they were not checked; they are only reported in summaries, like GlibcSkew, the
+	// number of minor versions between them
+	HostGlibc      string
+	ContainerGlibc string
+	GlibcSkew      int
+
+	// AppArgs are the arguments the application was executed with, empty when it was executed
+	// without argument; they are only reported in summaries
+	AppArgs []string
+
+	// HooksDir is the directory where the output of the pre-run and post-run hooks of the
+	// experiment is saved, empty when the experiment has no hook; it is only reported in summaries
+	HooksDir string
+
+	// HostISA and ContainerISA are the instruction sets of the CPUs of the host and of the host
+	// where the container was built, empty when they were not checked; MissingCPUFeatures are the
+	// features required by the container that the host lacks. They are only reported in summaries.
+	HostISA            string
+	ContainerISA       string
+	MissingCPUFeatures []string
+
+	// ArtifactsDir is the directory where the output artifacts of the application, e.g., np.out,
+	// are saved and Artifacts the list of these files, relative to ArtifactsDir; they are only
+	// reported in summaries
+	ArtifactsDir string
+	Artifacts    []string
+
+	// CheckpointSize is the size in bytes of the checkpoint of a checkpoint/restart experiment,
+	// CheckpointTime the time it took to checkpoint the job and RestartTime the time it took the
+	// job to complete once restarted; they are only reported in summaries
+	CheckpointSize int64
+	CheckpointTime time.Duration
+	RestartTime    time.Duration
+
+	// Iterations are the results of the iterations of an experiment executed several times and
+	// Metrics the statistics of the metrics they reported (see Aggregate); they are only reported
+	// in summaries
+	Iterations []Iteration
+	Metrics    []Metric
+
+	// Features are the results of the MPI feature probes executed in the container once its
+	// application succeeded, empty when they were not executed; they are only reported in summaries
+	Features []Feature
+
+	// Bench are the settings of the benchmark the application was executed with, including the
+	// seed of the run; they are only reported in summaries
+	Bench app.Bench
+
+	// Container is the container executed with 'sympi -run', AppName the application selected in
+	// a multi-app container and LaunchArgs the arguments of the launcher, empty for the experiments
+	// of a configuration file; they are only reported in summaries, to repeat the run
+	Container  string
+	AppName    string
+	LaunchArgs []string
 }
 
-func lookupResult(r []Result, hostVersion string, containerVersion string) bool {
+func lookupResult(r []Result, hostVersion string, containerVersion string) *Result {
 	var i int
 	for i = 0; i < len(r); i++ {
 		if r[i].HostMPI.Version == hostVersion && r[i].ContainerMPI.Version == containerVersion {
-			return r[i].Pass
+			return &r[i]
 		}
 	}
 
-	return false
+	return nil
 }
 
-func createCompatibilityMatrix(mpiImplem string, initFile string, netpipeFile string, imbFile string) error {
-	outputFile := mpiImplem + "_compatibility_matrix.txt"
-
-	initResults, err := Load(initFile)
-	if err != nil {
-		return err
+// getMatrixCell returns the content of a cell of the compatibility matrix for a result; a failing
+// cell links to the classification of the failure and to the directory with its details
+func getMatrixCell(r *Result) string {
+	if r == nil {
+		return "false"
 	}
-
-	netpipeResults, err := Load(netpipeFile)
-	if err != nil {
-		return err
+	cell := strconv.FormatBool(r.Pass)
+	if !r.Pass && (r.ErrorCategory != "" || r.ErrorDir != "") {
+		cell += "\t" + r.ErrorCategory + "\t" + r.ErrorDir
 	}
+	return cell
+}
 
-	imbResults, err := Load(imbFile)
-	if err != nil {
-		return err
+// createCompatibilityMatrix creates the compatibility matrix of a MPI implementation from the
+// result files of its validation tests, in the order they are executed (see
+// app.GetValidationTestNames): each cell reports the first test that failed
+func createCompatibilityMatrix(mpiImplem string, testFiles []string) error {
+	outputFile := mpiImplem + CompatibilityMatrixSuffix
+
+	var testResults [][]Result
+	for _, file := range testFiles {
+		r, err := Load(file)
+		if err != nil {
+			return err
+		}
+		testResults = append(testResults, r)
+	}
+	if len(testResults) == 0 {
+		return fmt.Errorf("no result file for %s", mpiImplem)
 	}
 
 	compatibilityResults := ""
 
+	initResults := testResults[0]
 	var i int
 	for i = 0; i < len(initResults); i++ {
-		testPassed := false
-
-		if initResults[i].Pass {
-			passNetpipe := lookupResult(
-				netpipeResults,
+		// The cell reports the first test that failed
+		cell := &initResults[i]
+		for _, r := range testResults[1:] {
+			if cell == nil || !cell.Pass {
+				break
+			}
+			cell = lookupResult(
+				r,
 				initResults[i].HostMPI.Version,
 				initResults[i].ContainerMPI.Version,
 			)
-			if passNetpipe {
-				passIMB := lookupResult(
-					imbResults,
-					initResults[i].HostMPI.Version,
-					initResults[i].ContainerMPI.Version,
-				)
-				if passIMB {
-					testPassed = true
-				}
-			}
 		}
 
 		compatibilityResults += initResults[i].HostMPI.Version +
 			"\t" +
 			initResults[i].ContainerMPI.Version +
 			"\t" +
-			strconv.FormatBool(testPassed) +
+			getMatrixCell(cell) +
 			"\n"
 	}
 
-	err = ioutil.WriteFile(outputFile, []byte(compatibilityResults), 0777)
+	err := ioutil.WriteFile(outputFile, []byte(compatibilityResults), 0777)
 	if err != nil {
 		return err
 	}
@@ -95,61 +274,206 @@ func createCompatibilityMatrix(mpiImplem string, initFile string, netpipeFile st
 	return nil
 }
 
-// Analyse checks whether all the result files are present and if so, create
-// the compatibility matrix.
-func Analyse(mpiImplem string) {
-	// todo we need to make that better, it should not be hardcoded here
-	initOutputFile := mpiImplem + "-init-results.txt"
-	netpipeOutputFile := mpiImplem + "-netpipe-results.txt"
-	imbOutputFile := mpiImplem + "-imb-results.txt"
-
-	if util.FileExists(initOutputFile) && util.FileExists(netpipeOutputFile) && util.FileExists(imbOutputFile) {
-		log.Println("All expected result files found, creating compatibility matrix...")
-		err := createCompatibilityMatrix(mpiImplem, initOutputFile, netpipeOutputFile, imbOutputFile)
+// LoadCompatibilityMatrix reads a compatibility matrix created by Analyse or SaveCompatibilityMatrix,
+// each cell being returned as the result of the experiment with the host and container MPI versions
+func LoadCompatibilityMatrix(matrixFile string) ([]Result, error) {
+	data, err := ioutil.ReadFile(matrixFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", matrixFile, err)
+	}
+
+	var cells []Result
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		words := strings.Split(line, "\t")
+		if len(words) < 3 || len(words) > 5 {
+			return nil, fmt.Errorf("invalid format of %s: %s", matrixFile, line)
+		}
+		var cell Result
+		cell.HostMPI.Version = words[0]
+		cell.ContainerMPI.Version = words[1]
+		cell.Pass, err = strconv.ParseBool(words[2])
 		if err != nil {
-			log.Fatalf("Cannot create the compatibility matrix")
+			return nil, fmt.Errorf("invalid experiment result in %s: %s", matrixFile, words[2])
+		}
+		if len(words) > 3 {
+			cell.ErrorCategory = words[3]
+		}
+		if len(words) > 4 {
+			cell.ErrorDir = words[4]
+		}
+		cells = append(cells, cell)
+	}
+	return cells, nil
+}
+
+// SaveCompatibilityMatrix writes the compatibility matrix of a list of results, in the format read by
+// LoadCompatibilityMatrix, ordered by host and container MPI versions. A cell executed with several
+// versions of Singularity or runtime modes only passes when all of them passed, the first failure being reported;
+// standalone experiments are ignored.
+func SaveCompatibilityMatrix(matrixFile string, r []Result) error {
+	var cells []Result
+	index := make(map[string]int)
+	for _, res := range r {
+		if res.Category == StandaloneCategory {
+			continue
+		}
+		key := res.HostMPI.Version + "\t" + res.ContainerMPI.Version
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(cells)
+			cells = append(cells, res)
+		} else if cells[i].Pass && !res.Pass {
+			cells[i] = res
+		}
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		if c := implem.CompareVersions(cells[i].HostMPI.Version, cells[j].HostMPI.Version); c != 0 {
+			return c < 0
 		}
+		return implem.CompareVersions(cells[i].ContainerMPI.Version, cells[j].ContainerMPI.Version) < 0
+	})
+
+	var sb strings.Builder
+	for i := range cells {
+		sb.WriteString(cells[i].HostMPI.Version + "\t" + cells[i].ContainerMPI.Version + "\t" + getMatrixCell(&cells[i]) + "\n")
+	}
+	err := ioutil.WriteFile(matrixFile, []byte(sb.String()), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", matrixFile, err)
+	}
+	return nil
+}
+
+// parseLine parses a line of a result file
+func parseLine(line string) (Result, error) {
+	words := strings.Split(line, "\t")
+	var newResult Result
+	if len(words) < 3 || len(words) > 9 {
+		return newResult, fmt.Errorf("invalid format: %s", line)
+	}
+	if words[0] == StandaloneCategory {
+		newResult.Category = StandaloneCategory
+		newResult.App = words[1]
+	} else {
+		newResult.HostMPI.Version = words[0]
+		newResult.ContainerMPI.Version = words[1]
 	}
+	// The version of Singularity is only specified by experiments that pin it
+	statusIdx := 2
+	if strings.HasPrefix(words[2], singularityPrefix) {
+		newResult.Singularity = strings.TrimPrefix(words[2], singularityPrefix)
+		statusIdx = 3
+	}
+	// Likewise, the runtime mode is only specified when it is not the native mode
+	if statusIdx < len(words) && strings.HasPrefix(words[statusIdx], runtimeModePrefix) {
+		newResult.RuntimeMode = strings.TrimPrefix(words[statusIdx], runtimeModePrefix)
+		statusIdx++
+	}
+	// And so is the Linux distribution of the container
+	if statusIdx < len(words) && strings.HasPrefix(words[statusIdx], distroPrefix) {
+		newResult.Distro = strings.TrimPrefix(words[statusIdx], distroPrefix)
+		statusIdx++
+	}
+	// And so are the tuning profiles of the site
+	if statusIdx < len(words) && strings.HasPrefix(words[statusIdx], tuningPrefix) {
+		newResult.Tuning = strings.TrimPrefix(words[statusIdx], tuningPrefix)
+		statusIdx++
+	}
+	if statusIdx >= len(words) {
+		return newResult, fmt.Errorf("invalid format: %s", line)
+	}
+	// Failures can be followed by their classification and the directory with their details
+	if len(words) > statusIdx+1 {
+		newResult.ErrorCategory = words[statusIdx+1]
+	}
+	if len(words) > statusIdx+2 {
+		newResult.ErrorDir = words[statusIdx+2]
+	}
+	if len(words) > statusIdx+3 {
+		return newResult, fmt.Errorf("invalid format: %s", line)
+	}
+	result := words[statusIdx]
+	switch result {
+	case "PASS":
+		newResult.Pass = true
+	case "FAIL":
+		newResult.Pass = false
+	default:
+		return newResult, fmt.Errorf("invalid experiment result: %s", result)
+	}
+	return newResult, nil
 }
 
-// Load reads a output file and load the list of experiments that are in the file
+// Load reads a output file and load the list of experiments that are in the file. A last line
+// without newline that cannot be parsed was partially written by an interrupted writer and is
+// ignored.
 func Load(outputFile string) ([]Result, error) {
 	var existingResults []Result
 
 	log.Println("Reading results from", outputFile)
 
-	f, err := os.Open(outputFile)
+	data, err := ioutil.ReadFile(outputFile)
 	if err != nil {
 		// No result file, it is okay
 		return existingResults, nil
 	}
-	defer f.Close()
-
-	lineReader := bufio.NewScanner(f)
-	if lineReader == nil {
-		return existingResults, fmt.Errorf("failed to create file reader")
-	}
 
-	for lineReader.Scan() {
-		line := lineReader.Text()
-		words := strings.Split(line, "\t")
-		var newResult Result
-		if len(words) < 3 {
-			return existingResults, fmt.Errorf("invalid format: %s", line)
+	content := string(data)
+	complete := strings.HasSuffix(content, "\n")
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	for i, line := range lines {
+		if line == "" && i == len(lines)-1 {
+			continue
 		}
-		newResult.HostMPI.Version = words[0]
-		newResult.ContainerMPI.Version = words[1]
-		result := words[2]
-		switch result {
-		case "PASS":
-			newResult.Pass = true
-		case "FAIL":
-			newResult.Pass = false
-		default:
-			return existingResults, fmt.Errorf("invalid experiment result: %s", result)
+		newResult, err := parseLine(line)
+		if err != nil {
+			if !complete && i == len(lines)-1 {
+				log.Printf("[WARN] ignoring partially written line of %s: %s", outputFile, line)
+				continue
+			}
+			return existingResults, err
 		}
 		existingResults = append(existingResults, newResult)
 	}
 
 	return existingResults, nil
 }
+
+// GetKey returns the string identifying the experiment of a result in result files, i.e.,
+// the host and container MPI versions, or the container of a standalone experiment, followed
+// by the version of Singularity when the experiment pins it, by the runtime mode when it is
+// not the native mode and by the Linux distribution of the container when the experiment pins it
+func GetKey(r *Result) string {
+	key := r.HostMPI.Version + "\t" + r.ContainerMPI.Version
+	if r.Category == StandaloneCategory {
+		key = StandaloneCategory + "\t" + r.App
+	}
+	if r.Singularity != "" {
+		key += "\t" + singularityPrefix + r.Singularity
+	}
+	if r.RuntimeMode != "" {
+		key += "\t" + runtimeModePrefix + r.RuntimeMode
+	}
+	if r.Distro != "" {
+		key += "\t" + distroPrefix + r.Distro
+	}
+	if r.Tuning != "" {
+		key += "\t" + tuningPrefix + r.Tuning
+	}
+	return key
+}
+
+// Save writes a list of results in an output file, using the format expected by Load. Failures
+// are followed by their classification and the directory where their details are saved, if any.
+// The file is atomically replaced, see Writer.Save.
+func Save(outputFile string, r []Result) error {
+	w, err := NewWriter(outputFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Save(r)
+}
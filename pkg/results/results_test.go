@@ -0,0 +1,391 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package results
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/app"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+func TestErrorDetails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-results-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := []Result{
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "4.0.2"}, Pass: true},
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "3.1.4"}, ErrorCategory: ErrorTimeout, ErrorDir: "/sympi/errors/openmpi/4.0.2-3.1.4"},
+		{HostMPI: implem.Info{Version: "3.1.4"}, ContainerMPI: implem.Info{Version: "4.0.2"}, Singularity: "3.5.3", ErrorCategory: ErrorContainerBuild},
+		{HostMPI: implem.Info{Version: "3.1.4"}, ContainerMPI: implem.Info{Version: "3.1.4"}},
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "4.0.2"}, Singularity: "4.1.0", RuntimeMode: "oci", Distro: "centos:7", Tuning: "site-ib", ErrorCategory: ErrorExec, ErrorDir: "/sympi/errors/openmpi/4.0.2-4.0.2"},
+	}
+	path := filepath.Join(dir, "results.txt")
+	err = Save(path, res)
+	if err != nil {
+		t.Fatalf("Save() failed: %s", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %s", err)
+	}
+	if len(loaded) != len(res) {
+		t.Fatalf("%d results loaded instead of %d", len(loaded), len(res))
+	}
+	for i := range res {
+		if loaded[i].Pass != res[i].Pass || loaded[i].Singularity != res[i].Singularity || loaded[i].ErrorCategory != res[i].ErrorCategory || loaded[i].ErrorDir != res[i].ErrorDir ||
+			loaded[i].RuntimeMode != res[i].RuntimeMode || loaded[i].Distro != res[i].Distro || loaded[i].Tuning != res[i].Tuning {
+			t.Fatalf("result %d loaded as %v instead of %v", i, loaded[i], res[i])
+		}
+	}
+
+	cell := getMatrixCell(&loaded[1])
+	if cell != "false\t"+ErrorTimeout+"\t/sympi/errors/openmpi/4.0.2-3.1.4" {
+		t.Fatalf("invalid cell of the compatibility matrix: %s", cell)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-results-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := []Result{
+		{HostMPI: implem.Info{ID: "openmpi", Version: "4.0.2"}, ContainerMPI: implem.Info{ID: "openmpi", Version: "4.0.2"}, Pass: true, Duration: 2 * time.Second,
+			Bench: app.Bench{Seed: 42, Iterations: 1000}, Container: "imb-openmpi-4.0.2"},
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "3.1.4"}, ErrorCategory: ErrorTimeout, ErrorDir: "/sympi/errors/openmpi/4.0.2-3.1.4", Duration: 3 * time.Second},
+		{Category: StandaloneCategory, App: "lolcow", Singularity: "3.5.3", ErrorCategory: ErrorExec},
+	}
+	resultsFile := filepath.Join(dir, "openmpi-init-results.txt")
+	path, err := SaveSummary(resultsFile, res)
+	if err != nil {
+		t.Fatalf("SaveSummary() failed: %s", err)
+	}
+	if path != filepath.Join(dir, SummaryFileName) {
+		t.Fatalf("summary saved in %s", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	var s Summary
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		t.Fatalf("invalid summary: %s", err)
+	}
+	if s.ResultsFile != resultsFile || s.Total != 3 || s.Passed != 1 || s.Failed != 2 || s.Duration != 5*time.Second {
+		t.Fatalf("invalid summary: %+v", s)
+	}
+	if len(s.FailedExperiments) != 2 || s.FailedExperiments[0] != "4.0.2 3.1.4" || s.FailedExperiments[1] != "standalone lolcow singularity:3.5.3" {
+		t.Fatalf("invalid failed experiments: %q", s.FailedExperiments)
+	}
+	if s.Categories[ErrorTimeout] != 1 || s.Categories[ErrorExec] != 1 || s.Experiments[1].ReportDir != res[1].ErrorDir {
+		t.Fatalf("invalid failures: %+v", s)
+	}
+
+	tests := []struct {
+		maxFailures int
+		code        int
+	}{
+		{maxFailures: 0, code: 1},
+		{maxFailures: 1, code: 1},
+		{maxFailures: 2, code: 0},
+	}
+	for _, tt := range tests {
+		if code := s.ExitCode(tt.maxFailures); code != tt.code {
+			t.Fatalf("ExitCode(%d) returned %d instead of %d", tt.maxFailures, code, tt.code)
+		}
+	}
+	if code := Summarize(resultsFile, res[:1]).ExitCode(0); code != 0 {
+		t.Fatalf("ExitCode() returned %d while all the experiments passed", code)
+	}
+
+	loaded, err := LoadSummary(path)
+	if err != nil {
+		t.Fatalf("LoadSummary() failed: %s", err)
+	}
+	e, err := loaded.GetExperiment("4.0.2 4.0.2")
+	if err != nil {
+		t.Fatalf("GetExperiment() failed: %s", err)
+	}
+	if e.Bench == nil || *e.Bench != res[0].Bench || e.HostMPI != "openmpi:4.0.2" || e.ContainerMPI != "openmpi:4.0.2" || e.Container != res[0].Container {
+		t.Fatalf("invalid settings of the run: %+v", e)
+	}
+	if loaded.Experiments[1].Bench != nil {
+		t.Fatalf("benchmark settings reported for an experiment without settings")
+	}
+	if _, err := loaded.GetExperiment(""); err == nil {
+		t.Fatalf("an experiment was selected among several ones")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-history-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	historyFile := filepath.Join(dir, "history.jsonl")
+
+	runs, err := LoadHistory(historyFile)
+	if err != nil || len(runs) != 0 {
+		t.Fatalf("unexpected history before the first run: %v, %s", runs, err)
+	}
+
+	first := []Result{
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "4.0.2"}, Pass: true},
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "3.1.4"}, Pass: true},
+		{HostMPI: implem.Info{Version: "3.1.4"}, ContainerMPI: implem.Info{Version: "4.0.2"}, ErrorCategory: ErrorTimeout},
+	}
+	second := []Result{
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "4.0.2"}, Pass: true},
+		{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "3.1.4"}, ErrorCategory: ErrorTimeout},
+		{HostMPI: implem.Info{Version: "3.1.4"}, ContainerMPI: implem.Info{Version: "4.0.2"}, ErrorCategory: ErrorTimeout},
+		{HostMPI: implem.Info{Version: "3.1.4"}, ContainerMPI: implem.Info{Version: "3.1.4"}},
+	}
+	start := time.Date(2020, 1, 1, 2, 0, 0, 0, time.UTC)
+	for i, r := range [][]Result{first, second} {
+		err = AppendHistory(historyFile, NewHistoryRun(start.Add(time.Duration(i)*24*time.Hour), r))
+		if err != nil {
+			t.Fatalf("failed to append to history: %s", err)
+		}
+	}
+
+	runs, err = LoadHistory(historyFile)
+	if err != nil {
+		t.Fatalf("failed to load history: %s", err)
+	}
+	if len(runs) != 2 || !runs[1].Start.Equal(start.Add(24*time.Hour)) || len(runs[1].Experiments) != len(second) {
+		t.Fatalf("unexpected history: %v", runs)
+	}
+
+	// Only the experiment that passed before and fails now is a regression: the experiment
+	// that was already failing and the new one are not
+	regressions := FindRegressions(&runs[0], &runs[1])
+	if len(regressions) != 1 || regressions[0].Name != "4.0.2 3.1.4" || regressions[0].ErrorCategory != ErrorTimeout {
+		t.Fatalf("unexpected regressions: %v", regressions)
+	}
+	if FindRegressions(nil, &runs[0]) != nil {
+		t.Fatalf("regressions reported without a previous run")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-results-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.txt")
+
+	// Concurrent writers must not interleave their results
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				err := Append(path, Result{HostMPI: implem.Info{Version: "4.0.2"}, ContainerMPI: implem.Info{Version: "3.1.4"}, ErrorCategory: ErrorTimeout, ErrorDir: "/sympi/errors/openmpi/4.0.2-3.1.4"})
+				if err != nil {
+					t.Errorf("Append() failed: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	loaded, err := Load(path)
+	if err != nil || len(loaded) != 160 {
+		t.Fatalf("Load() returned %d results: %s", len(loaded), err)
+	}
+
+	// Partially written lines are ignored when loading and removed before appending
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+	_, err = f.WriteString("4.0.2\t3.1")
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", path, err)
+	}
+	loaded, err = Load(path)
+	if err != nil || len(loaded) != 160 {
+		t.Fatalf("Load() returned %d results: %s", len(loaded), err)
+	}
+	err = Append(path, Result{HostMPI: implem.Info{Version: "3.1.4"}, ContainerMPI: implem.Info{Version: "3.1.4"}, Pass: true})
+	if err != nil {
+		t.Fatalf("Append() failed: %s", err)
+	}
+	loaded, err = Load(path)
+	if err != nil || len(loaded) != 161 || !loaded[160].Pass {
+		t.Fatalf("Load() returned %d results: %s", len(loaded), err)
+	}
+
+	err = Save(path, loaded[:1])
+	if err != nil {
+		t.Fatalf("Save() failed: %s", err)
+	}
+	loaded, err = Load(path)
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("Load() returned %d results: %s", len(loaded), err)
+	}
+	if _, err := os.Stat(path + lockSuffix); err == nil {
+		t.Fatalf("the lock file of %s was not removed", path)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		iterations []Result
+		pass       bool
+		category   string
+		bandwidth  Metric
+	}{
+		{
+			iterations: []Result{
+				{Pass: true, Note: "max bandwidth: 40 Gbps; latency: 50 nsecs", Duration: time.Second},
+				{Pass: true, Note: "max bandwidth: 44 Gbps; latency: 52 nsecs", Duration: 3 * time.Second},
+			},
+			pass:      true,
+			bandwidth: Metric{Name: "max bandwidth", Unit: "Gbps", Samples: 2, Min: 40, Max: 44, Mean: 42, Stddev: 2},
+		},
+		{
+			iterations: []Result{
+				{Pass: true, Note: "max bandwidth: 44 Gbps", Duration: time.Second},
+				{ErrorCategory: ErrorTimeout, ErrorDir: "/sympi/errors/openmpi/4.0.2-3.1.4", Duration: time.Second},
+				{Pass: true, Note: "max bandwidth: 44 Gbps", Duration: time.Second},
+			},
+			category:  ErrorUnstable,
+			bandwidth: Metric{Name: "max bandwidth", Unit: "Gbps", Samples: 2, Min: 44, Max: 44, Mean: 44},
+		},
+		{
+			iterations: []Result{
+				{ErrorCategory: ErrorLaunch, Duration: time.Second},
+				{ErrorCategory: ErrorTimeout, Duration: time.Second},
+			},
+			category: ErrorTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		r := Aggregate(tt.iterations)
+		if r.Pass != tt.pass || r.ErrorCategory != tt.category {
+			t.Fatalf("Aggregate() returned %v/%s instead of %v/%s", r.Pass, r.ErrorCategory, tt.pass, tt.category)
+		}
+		if len(r.Iterations) != len(tt.iterations) {
+			t.Fatalf("%d iterations recorded instead of %d", len(r.Iterations), len(tt.iterations))
+		}
+		if tt.bandwidth.Name == "" {
+			if len(r.Metrics) != 0 {
+				t.Fatalf("metrics reported without successful iterations: %v", r.Metrics)
+			}
+			continue
+		}
+		m := r.Metrics[0]
+		if m.Name != tt.bandwidth.Name || m.Unit != tt.bandwidth.Unit || m.Samples != tt.bandwidth.Samples || m.Min != tt.bandwidth.Min || m.Max != tt.bandwidth.Max || m.Mean != tt.bandwidth.Mean || m.Stddev != tt.bandwidth.Stddev {
+			t.Fatalf("invalid statistics %v instead of %v", m, tt.bandwidth)
+		}
+		if r.Metrics[len(r.Metrics)-1].Name != durationMetric {
+			t.Fatalf("the duration of the iterations is not reported: %v", r.Metrics)
+		}
+	}
+}
+
+func TestAnalyse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-results-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newResult := func(host string, container string, pass bool) Result {
+		r := Result{HostMPI: implem.Info{Version: host}, ContainerMPI: implem.Info{Version: container}, Pass: pass}
+		if !pass {
+			r.ErrorCategory = ErrorExec
+		}
+		return r
+	}
+	baseline := []Result{
+		newResult("3.1.4", "3.1.4", true),
+		newResult("3.1.4", "4.0.2", true),
+		newResult("4.0.2", "4.0.2", true),
+	}
+	current := []Result{
+		newResult("4.0.2", "4.0.2", true),
+		newResult("3.1.4", "3.1.4", true),
+		newResult("3.1.4", "4.0.2", false),
+		newResult("4.0.2", "3.1.4", true),
+		newResult("4.0.2", "3.1.4", false),
+		{Category: StandaloneCategory, App: "hello.sif", Pass: false},
+	}
+
+	resultsFile := filepath.Join(dir, "results.txt")
+	err = Save(resultsFile, current)
+	if err != nil {
+		t.Fatalf("Save() failed: %s", err)
+	}
+	baselineFile := filepath.Join(dir, "baseline_compatibility_matrix.txt")
+	err = SaveCompatibilityMatrix(baselineFile, baseline)
+	if err != nil {
+		t.Fatalf("SaveCompatibilityMatrix() failed: %s", err)
+	}
+	a, err := AnalyseFile(resultsFile, baselineFile)
+	if err != nil {
+		t.Fatalf("AnalyseFile() failed: %s", err)
+	}
+
+	if a.Total != 5 || a.Passed != 3 || len(a.HostVersions) != 2 || a.HostVersions[0] != "3.1.4" {
+		t.Fatalf("invalid pass-rate matrix: %s", a)
+	}
+	if a.GetCell("4.0.2", "3.1.4").PassRate() != 50 || a.GetCell("3.1.4", "4.0.2").PassRate() != 0 {
+		t.Fatalf("invalid pass rates: %s", a)
+	}
+	if len(a.Regressions) != 1 || a.Regressions[0].HostVersion != "3.1.4" || a.Regressions[0].ContainerVersion != "4.0.2" {
+		t.Fatalf("invalid regressions: %s", a)
+	}
+	// Neither way around fully passes
+	if len(a.Asymmetries) != 0 {
+		t.Fatalf("invalid asymmetric failures: %s", a)
+	}
+
+	a = AnalyseResults(current[:4], nil)
+	if len(a.Regressions) != 0 || len(a.Asymmetries) != 1 || a.Asymmetries[0].Pass.HostVersion != "4.0.2" {
+		t.Fatalf("invalid asymmetric failures: %s", a)
+	}
+
+	// The compatibility matrix of Sandia OpenSHMEM only depends on its hello-world test
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get the current directory: %s", err)
+	}
+	defer os.Chdir(cwd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("failed to change directory: %s", err)
+	}
+	err = Save(GetTestResultsFile(implem.SOS, app.InitTest), current[:4])
+	if err != nil {
+		t.Fatalf("Save() failed: %s", err)
+	}
+	a, err = AnalyseWithBaseline(implem.SOS, "")
+	if err != nil {
+		t.Fatalf("AnalyseWithBaseline() failed: %s", err)
+	}
+	if a.Total != 4 || a.Passed != 3 {
+		t.Fatalf("invalid pass-rate matrix: %s", a)
+	}
+}
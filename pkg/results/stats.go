@@ -0,0 +1,207 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package results
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// durationMetric is the name of the metric of the duration of the iterations, in seconds
+	durationMetric = "duration"
+)
+
+// Iteration is the result of one of the iterations of an experiment executed several times
+type Iteration struct {
+	// Pass specifies whether the iteration succeeded
+	Pass bool `json:"pass"`
+
+	// ErrorCategory is the classification of the failure, if any
+	ErrorCategory string `json:"error_category,omitempty"`
+
+	// Note is the note of the iteration, e.g., the performance measured by the application
+	Note string `json:"note,omitempty"`
+
+	// Duration is the time it took to execute the iteration
+	Duration time.Duration `json:"duration"`
+
+	// Seed is the seed of the benchmark during the iteration, if any
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// Metric gathers the statistics of a value measured by all the successful iterations of an
+// experiment, e.g., the bandwidth reported by NetPIPE
+type Metric struct {
+	// Name is the name of the metric, e.g., 'max bandwidth'
+	Name string `json:"name"`
+
+	// Unit is the unit of the metric, e.g., Gbps, if any
+	Unit string `json:"unit,omitempty"`
+
+	// Samples is the number of iterations that reported the metric
+	Samples int `json:"samples"`
+
+	// Min, Max, Mean and Stddev are the statistics of the values reported by the iterations
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+
+	// values are the values reported by the iterations
+	values []float64
+}
+
+// NewMetric returns a metric with the statistics of the given values
+func NewMetric(name string, unit string, values ...float64) Metric {
+	m := Metric{Name: name, Unit: unit, values: values}
+	m.computeStats()
+	return m
+}
+
+// parseMetrics returns the values reported in a note with the format used by the applications,
+// e.g., 'max bandwidth: 44.773 Gbps; latency: 50.609 nsecs'; the parts of the note that are not
+// a number are ignored
+func parseMetrics(note string) []Metric {
+	var metrics []Metric
+	for _, part := range strings.Split(note, ";") {
+		tokens := strings.SplitN(part, ":", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		words := strings.Fields(tokens[1])
+		if len(words) == 0 || len(words) > 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(words[0], 64)
+		if err != nil {
+			continue
+		}
+		m := Metric{Name: strings.TrimSpace(tokens[0]), values: []float64{value}}
+		if len(words) == 2 {
+			m.Unit = words[1]
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// computeStats sets the statistics of a metric from its values
+func (m *Metric) computeStats() {
+	m.Samples = len(m.values)
+	if m.Samples == 0 {
+		return
+	}
+	m.Min = m.values[0]
+	m.Max = m.values[0]
+	sum := 0.0
+	for _, v := range m.values {
+		m.Min = math.Min(m.Min, v)
+		m.Max = math.Max(m.Max, v)
+		sum += v
+	}
+	m.Mean = sum / float64(m.Samples)
+	variance := 0.0
+	for _, v := range m.values {
+		variance += (v - m.Mean) * (v - m.Mean)
+	}
+	m.Stddev = math.Sqrt(variance / float64(m.Samples))
+}
+
+// String returns a description of the statistics of a metric, e.g.,
+// 'max bandwidth: 44.773 Gbps (min 44.1, max 45.2, stddev 0.35, 5 samples)'
+func (m *Metric) String() string {
+	mean := strconv.FormatFloat(m.Mean, 'g', 6, 64)
+	if m.Unit != "" {
+		mean += " " + m.Unit
+	}
+	return fmt.Sprintf("%s: %s (min %g, max %g, stddev %.3g, %d samples)", m.Name, mean, m.Min, m.Max, m.Stddev, m.Samples)
+}
+
+// getMetrics returns the statistics of the metrics reported in the notes of the successful
+// iterations of an experiment, in the order they are reported, followed by their duration
+func getMetrics(iterations []Result) []Metric {
+	var metrics []Metric
+	index := make(map[string]int)
+	duration := Metric{Name: durationMetric, Unit: "s"}
+	for _, it := range iterations {
+		if !it.Pass {
+			continue
+		}
+		duration.values = append(duration.values, it.Duration.Seconds())
+		for _, m := range parseMetrics(it.Note) {
+			i, ok := index[m.Name]
+			if !ok {
+				i = len(metrics)
+				index[m.Name] = i
+				metrics = append(metrics, Metric{Name: m.Name, Unit: m.Unit})
+			}
+			metrics[i].values = append(metrics[i].values, m.values...)
+		}
+	}
+	if len(duration.values) > 0 {
+		metrics = append(metrics, duration)
+	}
+	for i := range metrics {
+		metrics[i].computeStats()
+	}
+	return metrics
+}
+
+// Aggregate returns the result of an experiment executed several times from the results of its
+// iterations: the experiment succeeded when all the iterations succeeded, and it is unstable,
+// i.e., it failed with the ErrorUnstable category, when only some of them failed. The result
+// records the result of each iteration and the statistics of the metrics reported by the
+// successful ones; the other details, e.g., the version of glibc, are the ones of the last
+// iteration.
+func Aggregate(iterations []Result) Result {
+	if len(iterations) == 0 {
+		return Result{}
+	}
+
+	r := iterations[len(iterations)-1]
+	r.Duration = 0
+	r.Iterations = nil
+	passed := 0
+	var lastFailure *Result
+	for i := range iterations {
+		it := &iterations[i]
+		r.Duration += it.Duration
+		r.Iterations = append(r.Iterations, Iteration{Pass: it.Pass, ErrorCategory: it.ErrorCategory, Note: it.Note, Duration: it.Duration, Seed: it.Bench.Seed})
+		if it.Pass {
+			passed++
+		} else {
+			lastFailure = it
+		}
+	}
+	r.Metrics = getMetrics(iterations)
+
+	switch {
+	case lastFailure == nil:
+		var stats []string
+		for i := range r.Metrics {
+			stats = append(stats, r.Metrics[i].String())
+		}
+		r.Note = strings.Join(stats, "; ")
+	case passed == 0:
+		r.Pass = false
+		r.ErrorCategory = lastFailure.ErrorCategory
+		r.ErrorDir = lastFailure.ErrorDir
+		r.Note = lastFailure.Note
+	default:
+		r.Pass = false
+		r.ErrorCategory = ErrorUnstable
+		r.ErrorDir = lastFailure.ErrorDir
+		r.Note = fmt.Sprintf("%d/%d iterations passed, last failure: %s", passed, len(iterations), lastFailure.ErrorCategory)
+		if lastFailure.Note != "" {
+			r.Note += " (" + lastFailure.Note + ")"
+		}
+	}
+	return r
+}
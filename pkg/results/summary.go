@@ -0,0 +1,293 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/app"
+)
+
+// SummaryFileName is the name of the machine-readable summary written alongside a result file
+const SummaryFileName = "summary.json"
+
+// ExperimentSummary is the summary of the result of an experiment
+type ExperimentSummary struct {
+	// Name identifies the experiment, e.g., '4.0.2 3.1.4' or 'standalone <container>', followed by the
+	// version of Singularity when the experiment pins it and the runtime mode, e.g., 'mode:oci', when
+	// it is not the native mode
+	Name string `json:"name"`
+
+	// Pass specifies whether the experiment succeeded
+	Pass bool `json:"pass"`
+
+	// ErrorCategory is the classification of the failure, if any
+	ErrorCategory string `json:"error_category,omitempty"`
+
+	// Note gives details about the failure, if any
+	Note string `json:"note,omitempty"`
+
+	// ReportDir is the directory where the details of the failure are saved, if any
+	ReportDir string `json:"report_dir,omitempty"`
+
+	// Duration is the time it took to execute the experiment, 0 when it was not executed
+	Duration time.Duration `json:"duration"`
+
+	// HostGlibc is the version of glibc on the host, when checked before the execution
+	HostGlibc string `json:"host_glibc,omitempty"`
+
+	// ContainerGlibc is the version of glibc in the container, when checked before the execution
+	ContainerGlibc string `json:"container_glibc,omitempty"`
+
+	// GlibcSkew is the number of minor versions between the glibc of the host and of the container
+	GlibcSkew int `json:"glibc_skew,omitempty"`
+
+	// AppArgs are the arguments the application was executed with, if any
+	AppArgs []string `json:"app_args,omitempty"`
+
+	// HooksDir is the directory where the output of the hooks of the experiment is saved, if any
+	HooksDir string `json:"hooks_dir,omitempty"`
+
+	// HostISA is the instruction set of the CPUs of the host, when checked before the execution
+	HostISA string `json:"host_isa,omitempty"`
+
+	// ContainerISA is the instruction set of the CPUs of the host where the container was built
+	ContainerISA string `json:"container_isa,omitempty"`
+
+	// MissingCPUFeatures are the features required by the container that the CPUs of the host lack
+	MissingCPUFeatures []string `json:"missing_cpu_features,omitempty"`
+
+	// ArtifactsDir is the directory where the output artifacts of the application are saved, if any
+	ArtifactsDir string `json:"artifacts_dir,omitempty"`
+
+	// Artifacts is the list of output artifacts of the application, relative to ArtifactsDir
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	// CheckpointSize is the size in bytes of the checkpoint, for checkpoint/restart experiments
+	CheckpointSize int64 `json:"checkpoint_size,omitempty"`
+
+	// CheckpointTime is the time it took to checkpoint the job, for checkpoint/restart experiments
+	CheckpointTime time.Duration `json:"checkpoint_time,omitempty"`
+
+	// RestartTime is the time it took the job to complete once restarted from its checkpoint
+	RestartTime time.Duration `json:"restart_time,omitempty"`
+
+	// Iterations are the results of the iterations, when the experiment was executed several times
+	Iterations []Iteration `json:"iterations,omitempty"`
+
+	// Metrics are the statistics of the metrics reported by the successful iterations
+	Metrics []Metric `json:"metrics,omitempty"`
+
+	// Features are the results of the MPI feature probes, when executed (see -features)
+	Features []Feature `json:"features,omitempty"`
+
+	// Bench are the settings of the benchmark, including the seed of the run, if any
+	Bench *app.Bench `json:"bench,omitempty"`
+
+	// HostMPI is the MPI implementation of the host, e.g., openmpi:4.0.2, when known
+	HostMPI string `json:"host_mpi,omitempty"`
+
+	// ContainerMPI is the MPI implementation of the container, e.g., openmpi:4.0.2, when known
+	ContainerMPI string `json:"container_mpi,omitempty"`
+
+	// Container is the container executed with 'sympi -run', if any
+	Container string `json:"container,omitempty"`
+
+	// AppName is the application executed in a multi-app container, if any
+	AppName string `json:"app_name,omitempty"`
+
+	// LaunchArgs are the arguments of the launcher, if any
+	LaunchArgs []string `json:"launch_args,omitempty"`
+
+	// RuntimeMode is the runtime mode of Singularity, e.g., oci, empty for the native mode
+	RuntimeMode string `json:"runtime_mode,omitempty"`
+
+	// Distro is the Linux distribution of the container pinned by the experiment, e.g., centos:7
+	Distro string `json:"distro,omitempty"`
+
+	// Tuning is the names of the tuning profiles of the site active during the experiment, e.g., mca-params
+	Tuning string `json:"tuning,omitempty"`
+}
+
+// Summary is the machine-readable summary of the execution of a set of experiments, for instance
+// for CI scripts to figure out which experiments failed
+type Summary struct {
+	// ResultsFile is the path to the result file
+	ResultsFile string `json:"results_file"`
+
+	// Total is the number of experiments
+	Total int `json:"total"`
+
+	// Passed is the number of experiments that succeeded
+	Passed int `json:"passed"`
+
+	// Failed is the number of experiments that failed
+	Failed int `json:"failed"`
+
+	// Categories maps the categories of failures to the number of experiments that failed that way
+	Categories map[string]int `json:"categories,omitempty"`
+
+	// FailedExperiments is the list of the names of the experiments that failed
+	FailedExperiments []string `json:"failed_experiments,omitempty"`
+
+	// UnstableExperiments is the list of the names of the experiments that failed only during some
+	// of their iterations, which are also failed experiments
+	UnstableExperiments []string `json:"unstable_experiments,omitempty"`
+
+	// PrivilegeSkippedExperiments is the list of the names of the experiments that were not executed
+	// because they require privileges that are not available, e.g., sudo, which are also failed experiments
+	PrivilegeSkippedExperiments []string `json:"privilege_skipped_experiments,omitempty"`
+
+	// Duration is the total time spent executing the experiments
+	Duration time.Duration `json:"duration"`
+
+	// Experiments is the summary of all the experiments, in the order of the results
+	Experiments []ExperimentSummary `json:"experiments"`
+}
+
+// getName returns the name of the experiment of a result used in summaries
+func getName(r *Result) string {
+	return strings.Replace(GetKey(r), "\t", " ", -1)
+}
+
+// Summarize creates the summary of a list of results saved in a result file
+func Summarize(resultsFile string, r []Result) *Summary {
+	s := &Summary{ResultsFile: resultsFile, Categories: make(map[string]int)}
+	for i := range r {
+		e := ExperimentSummary{
+			Name:          getName(&r[i]),
+			Pass:          r[i].Pass,
+			ErrorCategory: r[i].ErrorCategory,
+			Note:          r[i].Note,
+			ReportDir:     r[i].ErrorDir,
+			Duration:      r[i].Duration,
+
+			HostGlibc:      r[i].HostGlibc,
+			ContainerGlibc: r[i].ContainerGlibc,
+			GlibcSkew:      r[i].GlibcSkew,
+
+			AppArgs:  r[i].AppArgs,
+			HooksDir: r[i].HooksDir,
+
+			HostISA:            r[i].HostISA,
+			ContainerISA:       r[i].ContainerISA,
+			MissingCPUFeatures: r[i].MissingCPUFeatures,
+
+			ArtifactsDir: r[i].ArtifactsDir,
+			Artifacts:    r[i].Artifacts,
+
+			CheckpointSize: r[i].CheckpointSize,
+			CheckpointTime: r[i].CheckpointTime,
+			RestartTime:    r[i].RestartTime,
+
+			Iterations: r[i].Iterations,
+			Metrics:    r[i].Metrics,
+
+			Features: r[i].Features,
+
+			Container:  r[i].Container,
+			AppName:    r[i].AppName,
+			LaunchArgs: r[i].LaunchArgs,
+
+			RuntimeMode: r[i].RuntimeMode,
+			Distro:      r[i].Distro,
+			Tuning:      r[i].Tuning,
+		}
+		if r[i].Bench.IsSet() {
+			bench := r[i].Bench
+			e.Bench = &bench
+		}
+		if r[i].HostMPI.ID != "" {
+			e.HostMPI = r[i].HostMPI.ID + ":" + r[i].HostMPI.Version
+		}
+		if r[i].ContainerMPI.ID != "" {
+			e.ContainerMPI = r[i].ContainerMPI.ID + ":" + r[i].ContainerMPI.Version
+		}
+		s.Total++
+		s.Duration += r[i].Duration
+		if r[i].Pass {
+			s.Passed++
+		} else {
+			s.Failed++
+			s.FailedExperiments = append(s.FailedExperiments, e.Name)
+			if r[i].ErrorCategory == ErrorUnstable {
+				s.UnstableExperiments = append(s.UnstableExperiments, e.Name)
+			}
+			if r[i].ErrorCategory == ErrorPrivileges {
+				s.PrivilegeSkippedExperiments = append(s.PrivilegeSkippedExperiments, e.Name)
+			}
+			if r[i].ErrorCategory != "" {
+				s.Categories[r[i].ErrorCategory]++
+			}
+		}
+		s.Experiments = append(s.Experiments, e)
+	}
+	return s
+}
+
+// GetSummaryPath returns the path to the summary of a result file, which is in the same directory
+func GetSummaryPath(resultsFile string) string {
+	return filepath.Join(filepath.Dir(resultsFile), SummaryFileName)
+}
+
+// SaveSummary writes the summary of a list of results alongside the result file and returns its path
+func SaveSummary(resultsFile string, r []Result) (string, error) {
+	path := GetSummaryPath(resultsFile)
+	data, err := json.MarshalIndent(Summarize(resultsFile, r), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode summary: %s", err)
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return path, nil
+}
+
+// LoadSummary reads a summary written by SaveSummary
+func LoadSummary(path string) (*Summary, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	s := new(Summary)
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// GetExperiment returns the summary of an experiment from its name, e.g., '4.0.2 3.1.4'; the name
+// can be omitted when the summary has a single experiment
+func (s *Summary) GetExperiment(name string) (*ExperimentSummary, error) {
+	if name == "" {
+		if len(s.Experiments) != 1 {
+			return nil, fmt.Errorf("%s has %d experiments, one must be selected", s.ResultsFile, len(s.Experiments))
+		}
+		return &s.Experiments[0], nil
+	}
+	for i := range s.Experiments {
+		if s.Experiments[i].Name == name {
+			return &s.Experiments[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no experiment %s in %s", name, s.ResultsFile)
+}
+
+// ExitCode returns the exit code of a tool executing experiments: non-zero if and only if more
+// than maxFailures experiments failed, 0 being the default threshold
+func (s *Summary) ExitCode(maxFailures int) int {
+	if s.Failed > maxFailures {
+		return 1
+	}
+	return 0
+}
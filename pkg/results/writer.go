@@ -0,0 +1,182 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package results
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/lockfile"
+)
+
+// lockSuffix is the suffix of the lock file associated to a result file
+const lockSuffix = ".lock"
+
+// Writer writes a result file while other writers, e.g., other invocations of the tools, may
+// write the same file. Writers are serialized with an exclusive lock on a lock file next to the
+// result file, so the result file itself can be atomically replaced; the lock file is removed
+// once the result file is written.
+type Writer struct {
+	// Path is the path to the result file
+	Path string
+
+	lock *os.File
+}
+
+// NewWriter creates a writer for a result file
+func NewWriter(outputFile string) (*Writer, error) {
+	return &Writer{Path: outputFile}, nil
+}
+
+// Close releases the resources of the writer
+func (w *Writer) Close() error {
+	err := lockfile.Unlock(w.lock)
+	w.lock = nil
+	return err
+}
+
+func (w *Writer) acquire() error {
+	lock, err := lockfile.Lock(w.Path+lockSuffix, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	w.lock = lock
+	return nil
+}
+
+func (w *Writer) release() {
+	err := lockfile.Unlock(w.lock)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+	}
+	w.lock = nil
+}
+
+// formatLines returns the lines of a list of results in the format expected by Load
+func formatLines(r []Result) string {
+	var sb strings.Builder
+	for _, res := range r {
+		status := "FAIL"
+		if res.Pass {
+			status = "PASS"
+		}
+		line := GetKey(&res) + "\t" + status
+		if !res.Pass && (res.ErrorCategory != "" || res.ErrorDir != "") {
+			line += "\t" + res.ErrorCategory + "\t" + res.ErrorDir
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+// repairTail makes sure that a result file ends with a complete line before appending to it: a
+// valid line without its newline is terminated and a line partially written by an interrupted
+// writer is removed. It must be called with the lock held.
+func repairTail(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return err
+	}
+	if data[len(data)-1] == '\n' {
+		return nil
+	}
+	start := bytes.LastIndexByte(data, '\n') + 1
+	tail := string(data[start:])
+	if _, err := parseLine(tail); err == nil {
+		_, err = f.Write([]byte("\n"))
+		return err
+	}
+	log.Printf("[WARN] removing partially written line from %s: %s", f.Name(), tail)
+	return f.Truncate(int64(start))
+}
+
+// Append appends results to the result file, each call resulting in a single write so results
+// are never interleaved with the ones of other writers
+func (w *Writer) Append(r ...Result) error {
+	err := w.acquire()
+	if err != nil {
+		return err
+	}
+	defer w.release()
+
+	f, err := os.OpenFile(w.Path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", w.Path, err)
+	}
+	defer f.Close()
+
+	err = repairTail(f)
+	if err != nil {
+		return fmt.Errorf("failed to repair %s: %s", w.Path, err)
+	}
+	_, err = f.Write([]byte(formatLines(r)))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", w.Path, err)
+	}
+	return nil
+}
+
+// Save replaces the content of the result file with a list of results. The results are written
+// to a temporary file that is then renamed, so readers never see a partially written file.
+func (w *Writer) Save(r []Result) error {
+	err := w.acquire()
+	if err != nil {
+		return err
+	}
+	defer w.release()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(w.Path), "."+filepath.Base(w.Path)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %s", w.Path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Temporary files are only readable by their owner
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = tmp.WriteString(formatLines(r))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", tmp.Name(), err)
+	}
+
+	err = os.Rename(tmp.Name(), w.Path)
+	if err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %s", tmp.Name(), w.Path, err)
+	}
+	return nil
+}
+
+// Append appends results to a result file, see Writer.Append
+func Append(outputFile string, r ...Result) error {
+	w, err := NewWriter(outputFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	return w.Append(r...)
+}
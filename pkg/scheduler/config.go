@@ -0,0 +1,327 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// runtimeModePrefix is the prefix of the runtime mode pinned by an experiment, e.g., mode:oci
+	runtimeModePrefix = "mode:"
+
+	// distroPrefix is the prefix of the Linux distribution of the container pinned by an
+	// experiment, e.g., distro:centos:7
+	distroPrefix = "distro:"
+)
+
+// parseMPI parses the identifier of a MPI implementation, e.g., openmpi:4.0.2
+func parseMPI(str string) (implem.Info, error) {
+	var mpi implem.Info
+	tokens := strings.Split(str, ":")
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return mpi, fmt.Errorf("invalid MPI implementation %s", str)
+	}
+	mpi.ID = tokens[0]
+	mpi.Version = tokens[1]
+	return mpi, nil
+}
+
+// parseDistro parses the Linux distribution of a container, e.g., centos:7 or ubuntu:disco
+func parseDistro(str string) (string, error) {
+	tokens := strings.Split(str, ":")
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return "", fmt.Errorf("invalid Linux distribution %s, it should be of the form '<name>:<version>', e.g., centos:7", str)
+	}
+	return str, nil
+}
+
+// parseExperiment parses the description of an experiment, i.e., "<host MPI> <container MPI>",
+// e.g., "openmpi:4.0.2 openmpi:3.1.4", or "standalone <container>" for a container without MPI.
+// The version of Singularity used to execute the container can be pinned by adding it at the end
+// of the description, e.g., "openmpi:4.0.2 openmpi:3.1.4 singularity:3.5.3", and so can the
+// runtime mode, e.g., "openmpi:4.0.2 openmpi:3.1.4 mode:oci", and the Linux distribution of the
+// container, e.g., "openmpi:4.0.2 openmpi:3.1.4 distro:centos:7", in any order.
+func parseExperiment(line string) (Experiment, error) {
+	var e Experiment
+
+	words := strings.Fields(line)
+	for len(words) > 2 {
+		last := words[len(words)-1]
+		if strings.HasPrefix(last, runtimeModePrefix) {
+			mode := strings.TrimPrefix(last, runtimeModePrefix)
+			err := sys.ValidateRuntimeMode(mode)
+			if err != nil {
+				return e, err
+			}
+			// The native mode is the default mode of Singularity
+			if mode != sys.RuntimeModeNative {
+				e.RuntimeMode = mode
+			}
+		} else if strings.HasPrefix(last, distroPrefix) {
+			d, err := parseDistro(strings.TrimPrefix(last, distroPrefix))
+			if err != nil {
+				return e, err
+			}
+			e.Distro = d
+		} else {
+			break
+		}
+		words = words[:len(words)-1]
+	}
+	if len(words) != 2 && len(words) != 3 {
+		return e, fmt.Errorf("invalid experiment: %s", line)
+	}
+
+	if len(words) == 3 {
+		sy, err := parseMPI(words[2])
+		if err != nil || sy.ID != implem.SY {
+			return e, fmt.Errorf("invalid Singularity version %s, it should be of the form '%s:<version>'", words[2], implem.SY)
+		}
+		e.Singularity = sy
+	}
+
+	if words[0] == results.StandaloneCategory {
+		e.App = words[1]
+		return e, nil
+	}
+
+	var err error
+	e.HostMPI, err = parseMPI(words[0])
+	if err != nil {
+		return e, err
+	}
+	e.ContainerMPI, err = parseMPI(words[1])
+	if err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// experimentsFile is the content of a configuration file describing experiments
+type experimentsFile struct {
+	// exps is the list of experiments, before filtering and sampling
+	exps []Experiment
+
+	// filters is the list of filters applied to the experiments
+	filters []Filter
+
+	// sampling is the sampling strategy applied to the experiments once filtered, nil if the
+	// file does not specify any
+	sampling *Sampling
+
+	// preRun and postRun are the hooks executed before and after each experiment
+	preRun  []Hook
+	postRun []Hook
+}
+
+// parseSamplingLine parses a line of the configuration file of the experiments specifying the
+// sampling strategy, e.g., "sample latest-host"; the boolean is false when the line does not
+// specify a sampling strategy
+func parseSamplingLine(line string) (Sampling, bool, error) {
+	if !strings.HasPrefix(line, SampleKeyword+" ") {
+		return Sampling{}, false, nil
+	}
+	s, err := ParseSampling(strings.TrimPrefix(line, SampleKeyword+" "))
+	return s, true, err
+}
+
+// parseExperimentsFile parses all the lines of a file describing experiments and returns its
+// content, as well as the problems found, one per invalid line
+func parseExperimentsFile(path string) (*experimentsFile, []error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+	return parseExperiments(f, path)
+}
+
+// parseExperiments parses all the lines describing experiments read from r, e.g., a file or a
+// preset, and returns its content, as well as the problems found, one per invalid line
+func parseExperiments(r io.Reader, name string) (*experimentsFile, []error, error) {
+	content := new(experimentsFile)
+	var problems []error
+
+	// limits are the resource limits of the experiments that follow the last "limits" line
+	var limits syexec.Limits
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f, isFilter, err := parseFilterLine(line)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line %d: %s", n, err))
+			continue
+		}
+		if isFilter {
+			content.filters = append(content.filters, f)
+			continue
+		}
+		keyword, h, err := parseHookLine(line)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line %d: %s", n, err))
+			continue
+		}
+		if keyword == PreRunKeyword {
+			content.preRun = append(content.preRun, h)
+			continue
+		}
+		if keyword == PostRunKeyword {
+			content.postRun = append(content.postRun, h)
+			continue
+		}
+		l, isLimits, err := parseLimitsLine(line)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line %d: %s", n, err))
+			continue
+		}
+		if isLimits {
+			limits = l
+			continue
+		}
+		s, isSampling, err := parseSamplingLine(line)
+		if err == nil && isSampling && content.sampling != nil {
+			err = fmt.Errorf("sampling strategy already specified")
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line %d: %s", n, err))
+			continue
+		}
+		if isSampling {
+			content.sampling = &s
+			continue
+		}
+		e, err := parseExperiment(line)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("line %d: %s", n, err))
+			continue
+		}
+		e.Limits = limits
+		content.exps = append(content.exps, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %s", name, err)
+	}
+
+	return content, problems, nil
+}
+
+// LoadExperiments reads the list of experiments from a configuration file with one experiment per
+// line; empty lines and lines starting with '#' are ignored. Lines starting with "include" or
+// "exclude" define filters (see ParseFilter), e.g., "include host>=4.0", which are applied to
+// all the experiments of the file. A line starting with "sample" specifies a sampling strategy
+// (see ParseSampling), e.g., "sample diagonal", which is applied once the experiments are filtered.
+// Lines starting with "pre_run" or "post_run" specify hooks executed, in order, before and after
+// each experiment (see Hook), e.g., "pre_run timeout=5 on_failure=warn module purge". A line
+// starting with "limits" specifies the resource limits of the builds of the experiments that
+// follow it (see syexec.ParseLimits), e.g., "limits jobs=2 memory_max=8G".
+func LoadExperiments(path string) ([]Experiment, error) {
+	return LoadSelectedExperiments(path, nil)
+}
+
+// Selection is a selection of experiments given on the command line, applied in addition to the
+// filters of the configuration file
+type Selection struct {
+	// Filters are applied after the filters of the configuration file and before the sampling,
+	// e.g., as returned by ParseFilters
+	Filters []Filter
+
+	// Sampling, if not nil, replaces the sampling strategy of the configuration file, e.g., as
+	// returned by ParseSampling
+	Sampling *Sampling
+}
+
+// LoadSelectedExperiments returns the experiments of a configuration file (see LoadExperiments)
+// restricted by a selection from the command line, nil to only apply the configuration file
+func LoadSelectedExperiments(path string, sel *Selection) ([]Experiment, error) {
+	content, problems, err := parseExperimentsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, problems[0])
+	}
+	return content.resolve(sel), nil
+}
+
+// resolve returns the experiments of a configuration file once filtered and sampled, with their
+// hooks, the filters of the selection, if any, being applied after the filters of the file and its
+// sampling strategy replacing the one of the file
+func (content *experimentsFile) resolve(sel *Selection) []Experiment {
+	filters := content.filters
+	sampling := content.sampling
+	if sel != nil {
+		filters = append(append([]Filter{}, filters...), sel.Filters...)
+		if sel.Sampling != nil {
+			sampling = sel.Sampling
+		}
+	}
+	exps := FilterExperiments(content.exps, filters)
+	if sampling != nil {
+		exps = SampleExperiments(exps, *sampling)
+	}
+	for i := range exps {
+		exps[i].PreRun = content.preRun
+		exps[i].PostRun = content.postRun
+	}
+	return exps
+}
+
+// CheckExperiments checks a configuration file describing experiments and returns all the
+// experiments it describes, before filtering and sampling, and all the problems found
+func CheckExperiments(path string) ([]Experiment, []error) {
+	content, problems, err := parseExperimentsFile(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return content.exps, problems
+}
+
+// String returns the description of an experiment using the format of the configuration file,
+// e.g., "openmpi:4.0.2 openmpi:3.1.4"
+func (e *Experiment) String() string {
+	descr := e.HostMPI.ID + ":" + e.HostMPI.Version + " " + e.ContainerMPI.ID + ":" + e.ContainerMPI.Version
+	if e.IsStandalone() {
+		descr = results.StandaloneCategory + " " + e.App
+	}
+	if e.Singularity.Version != "" {
+		descr += " " + implem.SY + ":" + e.Singularity.Version
+	}
+	if e.RuntimeMode != "" {
+		descr += " " + runtimeModePrefix + e.RuntimeMode
+	}
+	if e.Distro != "" {
+		descr += " " + distroPrefix + e.Distro
+	}
+	return descr
+}
+
+// FormatPlan returns the resolved list of the experiments of a plan, in the order they are
+// executed and using the format of the configuration file, e.g., to display it with -dry-run
+func FormatPlan(plan []Group) string {
+	var lines []string
+	for _, g := range plan {
+		for i := range g.Experiments {
+			lines = append(lines, g.Experiments[i].String())
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
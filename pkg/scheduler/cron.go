@@ -0,0 +1,157 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField describes one of the fields of a cron expression
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+// cronFields are the fields of a cron expression, in order
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 6},
+}
+
+// maxScheduleLookAhead bounds the search of the next time of a schedule, e.g., '0 0 31 2 *' never matches
+const maxScheduleLookAhead = 5 * 366 * 24 * time.Hour
+
+// Schedule is a periodic schedule described by a cron expression: minute, hour, day of month,
+// month and day of week, e.g., '0 2 * * *' for every day at 2am
+type Schedule struct {
+	// Expr is the cron expression of the schedule
+	Expr string
+
+	// values stores, for each field, the values matching the expression
+	values [5]map[int]bool
+
+	// anyDay specifies, for the day of month and day of week, whether the field is '*'
+	anyDay [2]bool
+}
+
+// parseCronField returns the values matching a field of a cron expression, e.g., '*/15', '1-5' or '0,30'
+func parseCronField(expr string, f cronField) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, item := range strings.Split(expr, ",") {
+		step := 1
+		tokens := strings.SplitN(item, "/", 2)
+		if len(tokens) == 2 {
+			var err error
+			step, err = strconv.Atoi(tokens[1])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %s field: %s", f.name, item)
+			}
+		}
+
+		first, last := f.min, f.max
+		switch {
+		case tokens[0] == "*":
+		case strings.Contains(tokens[0], "-"):
+			bounds := strings.SplitN(tokens[0], "-", 2)
+			var err1, err2 error
+			first, err1 = strconv.Atoi(bounds[0])
+			last, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil || first > last {
+				return nil, fmt.Errorf("invalid range in %s field: %s", f.name, item)
+			}
+		default:
+			var err error
+			first, err = strconv.Atoi(tokens[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in %s field: %s", f.name, item)
+			}
+			// 'n/step' means from n to the maximum value
+			last = first
+			if len(tokens) == 2 {
+				last = f.max
+			}
+		}
+		if first < f.min || last > f.max {
+			return nil, fmt.Errorf("%s field out of range [%d-%d]: %s", f.name, f.min, f.max, item)
+		}
+		for v := first; v <= last; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// ParseSchedule parses a cron expression with 5 fields (minute, hour, day of month, month and day of
+// week, Sunday being 0); each field can be '*', a value, a range (e.g., 1-5), a list (e.g., 0,30) and
+// have a step (e.g., */15)
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule '%s': %d fields instead of %d", expr, len(fields), len(cronFields))
+	}
+
+	s := &Schedule{Expr: expr}
+	for i, f := range cronFields {
+		values, err := parseCronField(fields[i], f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %s", expr, err)
+		}
+		s.values[i] = values
+	}
+	s.anyDay[0] = fields[2] == "*"
+	s.anyDay[1] = fields[4] == "*"
+	return s, nil
+}
+
+// matchDay checks whether a day matches the schedule; like with cron, when both the day of month
+// and the day of week are restricted, a day matching either of them matches
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.values[2][t.Day()]
+	dow := s.values[4][int(t.Weekday())]
+	switch {
+	case s.anyDay[0] && s.anyDay[1]:
+		return true
+	case s.anyDay[0]:
+		return dow
+	case s.anyDay[1]:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the first time matching the schedule strictly after a given time, the zero time
+// when the schedule never matches
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	end := after.Add(maxScheduleLookAhead)
+	for t.Before(end) {
+		if !s.values[3][int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.values[1][t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.values[0][t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+const (
+	// IncludeKeyword is the keyword used in the configuration file of the experiments to only keep
+	// the experiments matching a filter, e.g., "include host>=4.0"
+	IncludeKeyword = "include"
+
+	// ExcludeKeyword is the keyword used in the configuration file of the experiments to remove
+	// the experiments matching a filter, e.g., "exclude container in {3.1.4,3.1.5}"
+	ExcludeKeyword = "exclude"
+
+	// SameVersionFilter is the filter matching the experiments using the same MPI on the host and in the container
+	SameVersionFilter = "same-version"
+
+	// UpperTriangularFilter is the filter matching the experiments whose container MPI is at least
+	// as recent as the host MPI, i.e., the upper triangle of the compatibility matrix
+	UpperTriangularFilter = "upper-triangular"
+)
+
+// filterOperators is the list of the operators comparing versions, longest operators first
+var filterOperators = []string{">=", "<=", "!=", "==", "=", ">", "<"}
+
+// Filter restricts the list of experiments, e.g., the combinations of host and container MPIs
+// generated for a compatibility matrix
+type Filter struct {
+	// Expr is the expression of the filter, e.g., host>=4.0
+	Expr string
+
+	// Exclude specifies whether the experiments matching the filter are removed instead of kept
+	Exclude bool
+
+	match func(*Experiment) bool
+}
+
+// getFilterTarget returns the MPI implementation (or version of Singularity) of an experiment a filter applies to
+func getFilterTarget(target string) (func(*Experiment) *implem.Info, error) {
+	switch target {
+	case "host":
+		return func(e *Experiment) *implem.Info { return &e.HostMPI }, nil
+	case "container":
+		return func(e *Experiment) *implem.Info { return &e.ContainerMPI }, nil
+	case implem.SY:
+		return func(e *Experiment) *implem.Info { return &e.Singularity }, nil
+	}
+	return nil, fmt.Errorf("invalid target %s, it should be host, container or %s", target, implem.SY)
+}
+
+// compareVersion applies an operator to two versions, e.g., 4.0.2 >= 4.0
+func compareVersion(v1 string, op string, v2 string) bool {
+	switch op {
+	case "=", "==":
+		return v1 == v2
+	case "!=":
+		return v1 != v2
+	}
+
+	c := implem.CompareVersions(v1, v2)
+	switch op {
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c < 0
+}
+
+// parseVersionSet parses a set of versions, e.g., {4.0.2,4.0.5}
+func parseVersionSet(str string) ([]string, error) {
+	if !strings.HasPrefix(str, "{") || !strings.HasSuffix(str, "}") {
+		return nil, fmt.Errorf("invalid set of versions %s, it should be of the form {<version>,<version>}", str)
+	}
+	var versions []string
+	for _, v := range strings.Split(strings.Trim(str, "{}"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, fmt.Errorf("invalid set of versions %s", str)
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// ParseFilter parses the expression of a filter. Supported expressions are:
+// - <target><operator><version>, e.g., host>=4.0 or container!=3.1.4,
+// - <target> in {<version>,<version>}, e.g., container in {4.0.2,4.0.5},
+// - same-version, the same MPI being used on the host and in the container,
+// - upper-triangular, the MPI in the container being at least as recent as the host MPI,
+// where the target is host, container or singularity and the operator is one of =, !=, <, <=, > and >=.
+// Standalone experiments, which do not use MPI, only match filters on the version of Singularity.
+func ParseFilter(expr string, exclude bool) (Filter, error) {
+	f := Filter{Expr: strings.TrimSpace(expr), Exclude: exclude}
+
+	switch f.Expr {
+	case SameVersionFilter:
+		f.match = func(e *Experiment) bool {
+			return !e.IsStandalone() && sameMPI(&e.HostMPI, &e.ContainerMPI)
+		}
+		return f, nil
+	case UpperTriangularFilter:
+		f.match = func(e *Experiment) bool {
+			return !e.IsStandalone() && e.HostMPI.ID == e.ContainerMPI.ID && implem.CompareVersions(e.ContainerMPI.Version, e.HostMPI.Version) >= 0
+		}
+		return f, nil
+	}
+
+	words := strings.Fields(f.Expr)
+	if len(words) >= 3 && words[1] == "in" {
+		target, err := getFilterTarget(words[0])
+		if err != nil {
+			return f, fmt.Errorf("invalid filter %s: %s", expr, err)
+		}
+		versions, err := parseVersionSet(strings.Join(words[2:], ""))
+		if err != nil {
+			return f, fmt.Errorf("invalid filter %s: %s", expr, err)
+		}
+		f.match = func(e *Experiment) bool {
+			if e.IsStandalone() && words[0] != implem.SY {
+				return false
+			}
+			for _, v := range versions {
+				if target(e).Version == v {
+					return true
+				}
+			}
+			return false
+		}
+		return f, nil
+	}
+
+	for _, op := range filterOperators {
+		idx := strings.Index(f.Expr, op)
+		if idx == -1 {
+			continue
+		}
+		name := strings.TrimSpace(f.Expr[:idx])
+		version := strings.TrimSpace(f.Expr[idx+len(op):])
+		target, err := getFilterTarget(name)
+		if err != nil {
+			return f, fmt.Errorf("invalid filter %s: %s", expr, err)
+		}
+		if version == "" || strings.ContainsAny(version, "<>=!") {
+			return f, fmt.Errorf("invalid filter %s: invalid version", expr)
+		}
+		f.match = func(e *Experiment) bool {
+			if e.IsStandalone() && name != implem.SY {
+				return false
+			}
+			return compareVersion(target(e).Version, op, version)
+		}
+		return f, nil
+	}
+
+	return f, fmt.Errorf("invalid filter %s", expr)
+}
+
+// ParseFilters parses a list of filters separated by ';', e.g., the value of an option of a
+// command, "host>=4.0;upper-triangular"
+func ParseFilters(exprs string, exclude bool) ([]Filter, error) {
+	var filters []Filter
+	for _, expr := range strings.Split(exprs, ";") {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		f, err := ParseFilter(expr, exclude)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// parseFilterLine parses a line of the configuration file of the experiments defining a filter,
+// e.g., "include host>=4.0"; the boolean is false when the line does not define a filter
+func parseFilterLine(line string) (Filter, bool, error) {
+	for _, keyword := range []string{IncludeKeyword, ExcludeKeyword} {
+		if strings.HasPrefix(line, keyword+" ") {
+			f, err := ParseFilter(strings.TrimPrefix(line, keyword+" "), keyword == ExcludeKeyword)
+			return f, true, err
+		}
+	}
+	return Filter{}, false, nil
+}
+
+// keep checks whether an experiment is kept by a list of filters, i.e., it matches all the
+// include filters and none of the exclude filters. Standalone experiments are only subject to
+// the filters on the version of Singularity.
+func keep(e *Experiment, filters []Filter) bool {
+	for _, f := range filters {
+		matched := f.match(e)
+		if f.Exclude && matched {
+			return false
+		}
+		if !f.Exclude && !matched && !(e.IsStandalone() && !strings.HasPrefix(f.Expr, implem.SY)) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterExperiments returns the experiments matching all the include filters and none of the
+// exclude filters, in the same order
+func FilterExperiments(exps []Experiment, filters []Filter) []Experiment {
+	if len(filters) == 0 {
+		return exps
+	}
+	var filtered []Experiment
+	for _, e := range exps {
+		if keep(&e, filters) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
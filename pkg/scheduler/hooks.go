@@ -0,0 +1,298 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// PreRunKeyword is the keyword used in the configuration file of the experiments to specify a
+	// command executed before each experiment, e.g., "pre_run module purge"
+	PreRunKeyword = "pre_run"
+
+	// PostRunKeyword is the keyword used in the configuration file of the experiments to specify a
+	// command executed after each experiment, e.g., "post_run /path/to/release_license.sh"
+	PostRunKeyword = "post_run"
+
+	// HookAbort is the failure policy of the hooks whose failure makes the experiment fail; a
+	// failing pre-run hook prevents the experiment from being executed
+	HookAbort = "abort"
+
+	// HookWarn is the failure policy of the hooks whose failure is only logged
+	HookWarn = "warn"
+
+	// hookTimeoutOption and hookFailureOption are the options of the hooks, e.g.,
+	// "pre_run timeout=5 on_failure=warn /path/to/script.sh"
+	hookTimeoutOption = "timeout="
+	hookFailureOption = "on_failure="
+
+	// hooksDirName is the name of the directory where the output of the hooks of an experiment is
+	// saved, in the results directory of the experiment
+	hooksDirName = "hooks"
+
+	// hookEnvFileVar is the environment variable giving pre-run hooks the file where the shell
+	// saves its environment once the command succeeds
+	hookEnvFileVar = "SYMPI_HOOK_ENV_FILE"
+)
+
+// hookEnvIgnored are the variables that are not applied from the environment of pre-run hooks:
+// the variables set for the hooks and the variables managed by the shell
+var hookEnvIgnored = map[string]bool{
+	"SYMPI_HOOK":                  true,
+	"SYMPI_HOST_MPI_ID":           true,
+	"SYMPI_HOST_MPI_VERSION":      true,
+	"SYMPI_CONTAINER_MPI_ID":      true,
+	"SYMPI_CONTAINER_MPI_VERSION": true,
+	"SYMPI_APP":                   true,
+	"SYMPI_SINGULARITY_BIN":       true,
+	"SYMPI_HOOKS_DIR":             true,
+	hookEnvFileVar:                true,
+	"_":                           true,
+	"SHLVL":                       true,
+	"PWD":                         true,
+	"OLDPWD":                      true,
+}
+
+// Hook is a command executed before or after each experiment, e.g., to set up the environment
+type Hook struct {
+	// Command is the command executed with 'sh -c'
+	Command string
+
+	// Timeout is the maximum number of minutes the command can run, sys.CmdTimeout by default
+	Timeout time.Duration
+
+	// OnFailure is the failure policy of the hook: HookAbort (default) or HookWarn
+	OnFailure string
+}
+
+// parseHookLine parses a line of the configuration file of the experiments specifying a hook, e.g.,
+// "pre_run timeout=5 on_failure=warn module purge"; the keyword is empty when the line does not
+// specify a hook
+func parseHookLine(line string) (string, Hook, error) {
+	h := Hook{OnFailure: HookAbort}
+	words := strings.Fields(line)
+	if len(words) == 0 || (words[0] != PreRunKeyword && words[0] != PostRunKeyword) {
+		return "", h, nil
+	}
+
+	args := words[1:]
+	for len(args) > 0 {
+		if strings.HasPrefix(args[0], hookTimeoutOption) {
+			timeout, err := strconv.Atoi(strings.TrimPrefix(args[0], hookTimeoutOption))
+			if err != nil || timeout <= 0 {
+				return words[0], h, fmt.Errorf("invalid hook timeout %s, it should be a number of minutes", args[0])
+			}
+			h.Timeout = time.Duration(timeout)
+		} else if strings.HasPrefix(args[0], hookFailureOption) {
+			h.OnFailure = strings.TrimPrefix(args[0], hookFailureOption)
+			if h.OnFailure != HookAbort && h.OnFailure != HookWarn {
+				return words[0], h, fmt.Errorf("invalid hook failure policy %s, it should be %s or %s", h.OnFailure, HookAbort, HookWarn)
+			}
+		} else {
+			break
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return words[0], h, fmt.Errorf("%s without command", words[0])
+	}
+	h.Command = strings.Join(args, " ")
+	return words[0], h, nil
+}
+
+// getHooksDir returns the directory where the output of the hooks of an experiment is saved
+func getHooksDir(e *Experiment, sysCfg *sys.Config) string {
+	dir := filepath.Join(sysCfg.BinPath, "results", e.HostMPI.ID, e.HostMPI.Version+"-"+e.ContainerMPI.Version)
+	if e.IsStandalone() {
+		dir = filepath.Join(sysCfg.BinPath, "results", results.StandaloneCategory, e.App)
+	}
+	if e.Singularity.Version != "" {
+		dir = filepath.Join(dir, "singularity-"+e.Singularity.Version)
+	}
+	return filepath.Join(dir, hooksDirName)
+}
+
+// getHookEnv returns the environment of the hooks of an experiment
+func getHookEnv(e *Experiment, kind string, hooksDir string, sysCfg *sys.Config) []string {
+	return append(os.Environ(), "SYMPI_HOOK="+kind,
+		"SYMPI_HOST_MPI_ID="+e.HostMPI.ID,
+		"SYMPI_HOST_MPI_VERSION="+e.HostMPI.Version,
+		"SYMPI_CONTAINER_MPI_ID="+e.ContainerMPI.ID,
+		"SYMPI_CONTAINER_MPI_VERSION="+e.ContainerMPI.Version,
+		"SYMPI_APP="+e.App,
+		"SYMPI_SINGULARITY_BIN="+sysCfg.SingularityBin,
+		"SYMPI_HOOKS_DIR="+hooksDir)
+}
+
+// getEnvCaptureCmd returns the shell command executing the command of a pre-run hook and, when it
+// succeeds, saving the environment of the shell in the file given by hookEnvFileVar
+func getEnvCaptureCmd(command string) string {
+	return command + "\nrc=$?\nif [ $rc -eq 0 ]; then env -0 > \"$" + hookEnvFileVar + "\" || exit 1; fi\nexit $rc"
+}
+
+// parseEnv parses an environment saved with 'env -0', i.e., NUL-separated name=value pairs, values
+// possibly spanning several lines
+func parseEnv(data []byte) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range strings.Split(string(data), "\x00") {
+		tokens := strings.SplitN(entry, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" || hookEnvIgnored[tokens[0]] {
+			continue
+		}
+		env[tokens[0]] = tokens[1]
+	}
+	return env
+}
+
+// setEnv replaces the environment of the tool, which the experiments and the following hooks
+// inherit, by an environment; the variables are set with syexec.Setenv so they are also kept when
+// the commands are executed in a sandboxed environment
+func setEnv(env map[string]string) {
+	for _, v := range os.Environ() {
+		name := strings.SplitN(v, "=", 2)[0]
+		if _, ok := env[name]; !ok && !hookEnvIgnored[name] {
+			syexec.Unsetenv(name)
+		}
+	}
+	for name, value := range env {
+		if os.Getenv(name) != value {
+			syexec.Setenv(name, value)
+		}
+	}
+}
+
+// getEnv returns the environment of the tool
+func getEnv() map[string]string {
+	env := make(map[string]string)
+	for _, v := range os.Environ() {
+		tokens := strings.SplitN(v, "=", 2)
+		if len(tokens) == 2 {
+			env[tokens[0]] = tokens[1]
+		}
+	}
+	return env
+}
+
+// runHook executes a hook of an experiment. The environment of the shell of a pre-run hook that
+// succeeds is applied to the tool, so commands such as 'module purge' or 'export' set up the
+// environment of the following hooks and of the experiment.
+func runHook(e *Experiment, kind string, h Hook, hooksDir string, sysCfg *sys.Config) (syexec.Result, error) {
+	var cmd syexec.SyCmd
+	cmd.BinPath = "sh"
+	cmd.CmdArgs = []string{"-c", h.Command}
+	cmd.Timeout = h.Timeout
+	cmd.Env = getHookEnv(e, kind, hooksDir, sysCfg)
+	if kind != PreRunKeyword {
+		return cmd.Run(), nil
+	}
+
+	f, err := ioutil.TempFile("", "sympi-hook-env-")
+	if err != nil {
+		return syexec.Result{}, fmt.Errorf("failed to create the file of the environment of the hook: %s", err)
+	}
+	envFile := f.Name()
+	f.Close()
+	defer os.Remove(envFile)
+	cmd.CmdArgs = []string{"-c", getEnvCaptureCmd(h.Command)}
+	cmd.Env = append(cmd.Env, hookEnvFileVar+"="+envFile)
+	res := cmd.Run()
+	if res.Err != nil {
+		return res, nil
+	}
+	// The environment is not saved when the command exits the shell itself
+	data, err := ioutil.ReadFile(envFile)
+	if err != nil || len(data) == 0 {
+		log.Printf("[WARN] the environment of %s hook '%s' is not available, it is not applied", kind, h.Command)
+		return res, nil
+	}
+	setEnv(parseEnv(data))
+	return res, nil
+}
+
+// runHooks executes the hooks of an experiment in order and saves their output in the hooks
+// directory of the experiment, e.g., pre_run-1.stdout.txt. An error is returned for the first
+// hook that fails with the HookAbort policy, the following hooks not being executed.
+func runHooks(e *Experiment, kind string, hooks []Hook, sysCfg *sys.Config) (string, error) {
+	if len(hooks) == 0 {
+		return "", nil
+	}
+
+	hooksDir := getHooksDir(e, sysCfg)
+	err := os.MkdirAll(hooksDir, 0755)
+	if err != nil {
+		return hooksDir, fmt.Errorf("failed to create %s: %s", hooksDir, err)
+	}
+
+	for i, h := range hooks {
+		log.Printf("-> Running %s hook '%s' for %s", kind, h.Command, e.getName())
+		res, err := runHook(e, kind, h, hooksDir, sysCfg)
+		if err != nil {
+			res.Err = err
+		}
+
+		prefix := filepath.Join(hooksDir, fmt.Sprintf("%s-%d", kind, i+1))
+		for suffix, output := range map[string]string{".stdout.txt": res.Stdout, ".stderr.txt": res.Stderr} {
+			err := ioutil.WriteFile(prefix+suffix, []byte(output), 0644)
+			if err != nil {
+				log.Printf("[WARN] failed to save the output of the hook: %s", err)
+			}
+		}
+
+		if res.Err == nil {
+			continue
+		}
+		if h.OnFailure == HookWarn {
+			log.Printf("[WARN] %s hook '%s' failed: %s", kind, h.Command, res.Err)
+			continue
+		}
+		return hooksDir, fmt.Errorf("%s hook '%s' failed: %s (stderr: %s)", kind, h.Command, res.Err, res.Stderr)
+	}
+	return hooksDir, nil
+}
+
+// runWithHooks executes an experiment between its pre-run and post-run hooks. The post-run hooks
+// are executed even when the experiment or its pre-run hooks failed, e.g., to release resources.
+// The environment set up by the pre-run hooks is discarded once the post-run hooks are executed.
+func runWithHooks(e *Experiment, run RunFn, sysCfg *sys.Config) results.Result {
+	if len(e.PreRun) > 0 {
+		defer setEnv(getEnv())
+	}
+	hooksDir, err := runHooks(e, PreRunKeyword, e.PreRun, sysCfg)
+	var r results.Result
+	if err != nil {
+		log.Printf("[ERROR] %s, not running %s\n", err, e.getName())
+		r = e.NewResult()
+		r.ErrorCategory = results.ErrorHook
+		r.Note = err.Error()
+	} else {
+		r = run(e, sysCfg)
+	}
+
+	dir, err := runHooks(e, PostRunKeyword, e.PostRun, sysCfg)
+	if dir != "" {
+		hooksDir = dir
+	}
+	if err != nil && r.ErrorCategory != results.ErrorHook {
+		log.Printf("[ERROR] %s\n", err)
+		r.Pass = false
+		r.ErrorCategory = results.ErrorHook
+		r.Note = err.Error()
+	}
+	r.HooksDir = hooksDir
+	return r
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+)
+
+const (
+	// LimitsKeyword is the keyword used in the configuration file of the experiments to specify
+	// the resource limits of the builds of the experiments that follow (see syexec.ParseLimits),
+	// e.g., "limits jobs=2 nice=10 memory_max=8G"
+	LimitsKeyword = "limits"
+
+	// CPUTimeMetric and MaxRSSMetric are the names of the metrics reporting the resources used by
+	// the commands executed for an experiment, i.e., the creation of its container and its execution
+	CPUTimeMetric = "cpu time"
+	MaxRSSMetric  = "max rss"
+)
+
+// parseLimitsLine parses a line of the configuration file of the experiments specifying resource
+// limits, e.g., "limits jobs=2"; the boolean is false when the line does not specify limits
+func parseLimitsLine(line string) (syexec.Limits, bool, error) {
+	words := strings.Fields(line)
+	if len(words) == 0 || words[0] != LimitsKeyword {
+		return syexec.Limits{}, false, nil
+	}
+	l, err := syexec.ParseLimits(strings.Join(words[1:], " "))
+	return l, true, err
+}
+
+// setLimits applies the resource limits of an experiment, which override the current ones, and
+// returns the limits to restore once the builds of the experiment are done
+func setLimits(e *Experiment) syexec.Limits {
+	return syexec.SetLimits(syexec.GetLimits().Override(e.Limits))
+}
+
+// addUsage adds the resources used by the commands executed for an experiment to the metrics of
+// its result; nothing is added when no command was executed on the system
+func addUsage(r *results.Result, u syexec.Usage) {
+	if u.CPUTime == 0 && u.MaxRSS == 0 {
+		return
+	}
+	r.Metrics = append(r.Metrics,
+		results.NewMetric(CPUTimeMetric, "s", u.CPUTime.Seconds()),
+		results.NewMetric(MaxRSSMetric, "MB", float64(u.MaxRSS)/1024))
+}
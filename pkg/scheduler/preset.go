@@ -0,0 +1,114 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// presetsDirName is the name of the directory with the presets, both in the package and in
+	// the directory with the configuration files
+	presetsDirName = "presets"
+
+	// presetSuffix is the suffix of the files of the presets
+	presetSuffix = ".conf"
+)
+
+// shippedPresets are the presets shipped with the tool, embedded in the binary so they are
+// available without any configuration file
+//
+//go:embed presets
+var shippedPresets embed.FS
+
+// getSitePresetsDir returns the directory of the presets of the site, in the directory with the
+// configuration files; empty when the configuration does not have such directory
+func getSitePresetsDir(sysCfg *sys.Config) string {
+	if sysCfg == nil || sysCfg.EtcDir == "" {
+		return ""
+	}
+	return filepath.Join(sysCfg.EtcDir, presetsDirName)
+}
+
+// GetPresetNames returns the names of the available presets, i.e., the presets shipped with the
+// tool and the ones of the site, e.g., openmpi-4-series
+func GetPresetNames(sysCfg *sys.Config) []string {
+	names := make(map[string]bool)
+	entries, _ := shippedPresets.ReadDir(presetsDirName)
+	for _, e := range entries {
+		names[strings.TrimSuffix(e.Name(), presetSuffix)] = true
+	}
+	dir := getSitePresetsDir(sysCfg)
+	if dir != "" && util.PathExists(dir) {
+		siteEntries, _ := ioutil.ReadDir(dir)
+		for _, e := range siteEntries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), presetSuffix) {
+				names[strings.TrimSuffix(e.Name(), presetSuffix)] = true
+			}
+		}
+	}
+
+	var list []string
+	for n := range names {
+		list = append(list, n)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// GetPreset returns the content of a preset, using the format of the configuration file of the
+// experiments. A preset of the site, i.e., <etc>/presets/<name>.conf, has precedence over the
+// preset of the same name shipped with the tool.
+func GetPreset(name string, sysCfg *sys.Config) ([]byte, error) {
+	dir := getSitePresetsDir(sysCfg)
+	if dir != "" {
+		path := filepath.Join(dir, name+presetSuffix)
+		if util.FileExists(path) {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %s", path, err)
+			}
+			return content, nil
+		}
+	}
+	content, err := shippedPresets.ReadFile(presetsDirName + "/" + name + presetSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("unknown preset %s, available presets are: %s", name, strings.Join(GetPresetNames(sysCfg), ", "))
+	}
+	return content, nil
+}
+
+// LoadPreset returns the experiments of a preset, e.g., openmpi-4-series, once filtered and
+// sampled like the experiments of a configuration file (see LoadExperiments)
+func LoadPreset(name string, sysCfg *sys.Config) ([]Experiment, error) {
+	return LoadSelectedPreset(name, nil, sysCfg)
+}
+
+// LoadSelectedPreset returns the experiments of a preset restricted by a selection from the
+// command line (see LoadSelectedExperiments)
+func LoadSelectedPreset(name string, sel *Selection, sysCfg *sys.Config) ([]Experiment, error) {
+	content, err := GetPreset(name, sysCfg)
+	if err != nil {
+		return nil, err
+	}
+	parsed, problems, err := parseExperiments(bytes.NewReader(content), "preset "+name)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("failed to parse preset %s: %s", name, problems[0])
+	}
+	return parsed.resolve(sel), nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// NotifyFn is a "function pointer" to report the experiments that fail while they succeeded
+// during the previous execution
+type NotifyFn func([]results.ExperimentSummary) error
+
+// Regression periodically executes a set of experiments to monitor the compatibility between
+// MPI implementations and Singularity over time
+type Regression struct {
+	// Schedule specifies when the experiments are executed
+	Schedule *Schedule
+
+	// Experiments is the list of experiments executed at each run, all of them being executed
+	// every time
+	Experiments []Experiment
+
+	// Ops are the operations used to execute the experiments
+	Ops *Ops
+
+	// HistoryFile is the path to the file where the results of each run are stored
+	HistoryFile string
+
+	// Notify is called only when a run has regressions, it is optional
+	Notify NotifyFn
+}
+
+// RunOnce executes all the experiments, stores their results in the history file and notifies
+// the experiments that regressed since the previous run stored in the history
+func (r *Regression) RunOnce(sysCfg *sys.Config) ([]results.ExperimentSummary, error) {
+	history, err := results.LoadHistory(r.HistoryFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load history: %s", err)
+	}
+
+	start := time.Now()
+	res := Execute(PlanExperiments(r.Experiments, nil), r.Ops, sysCfg)
+	run := results.NewHistoryRun(start, res)
+	err = results.AppendHistory(r.HistoryFile, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save results in history: %s", err)
+	}
+
+	if len(history) == 0 {
+		log.Printf("* First run recorded in %s, no previous run to compare to\n", r.HistoryFile)
+		return nil, nil
+	}
+	regressions := results.FindRegressions(&history[len(history)-1], &run)
+	if len(regressions) == 0 {
+		log.Println("* No regression since the previous run")
+		return nil, nil
+	}
+
+	for _, e := range regressions {
+		log.Printf("[WARN] regression: %s (%s)\n", e.Name, e.ErrorCategory)
+	}
+	if r.Notify != nil {
+		err = r.Notify(regressions)
+		if err != nil {
+			return regressions, fmt.Errorf("failed to notify regressions: %s", err)
+		}
+	}
+	return regressions, nil
+}
+
+// Run executes the experiments each time the schedule matches, until the stop channel is closed.
+// A failed run is logged and does not stop the monitoring.
+func (r *Regression) Run(sysCfg *sys.Config, stop <-chan struct{}) error {
+	for {
+		now := time.Now()
+		next := r.Schedule.Next(now)
+		if next.IsZero() {
+			return fmt.Errorf("schedule '%s' never matches", r.Schedule.Expr)
+		}
+		log.Printf("* Next run scheduled at %s\n", next.Format(time.RFC1123))
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		_, err := r.RunOnce(sysCfg)
+		if err != nil {
+			log.Printf("[ERROR] regression run failed: %s\n", err)
+		}
+	}
+}
+
+// CommandNotifier returns a notifier executing a command, e.g., a script sending an email, with
+// the list of the regressions, one per line, on its standard input
+func CommandNotifier(cmdline string) NotifyFn {
+	return func(regressions []results.ExperimentSummary) error {
+		var sb strings.Builder
+		for _, e := range regressions {
+			sb.WriteString(e.Name + "\t" + e.ErrorCategory + "\t" + e.Note + "\n")
+		}
+		cmd := exec.Command("/bin/sh", "-c", cmdline)
+		cmd.Stdin = strings.NewReader(sb.String())
+		err := syexec.RunCmd(cmd)
+		if err != nil {
+			return fmt.Errorf("failed to execute %s: %s", cmdline, err)
+		}
+		return nil
+	}
+}
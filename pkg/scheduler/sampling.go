@@ -0,0 +1,214 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+)
+
+const (
+	// SampleKeyword is the keyword used in the configuration file of the experiments to only
+	// execute a sample of the experiments, e.g., "sample random:10:42"
+	SampleKeyword = "sample"
+
+	// DiagonalSampling is the sampling strategy keeping the experiments using the same MPI on
+	// the host and in the container, i.e., the diagonal of the compatibility matrix
+	DiagonalSampling = "diagonal"
+
+	// LatestHostSampling is the sampling strategy keeping the experiments using the most recent
+	// host MPI of each implementation with all the container MPIs
+	LatestHostSampling = "latest-host"
+
+	// RandomSampling is the sampling strategy keeping K experiments chosen randomly, e.g.,
+	// random:10 or random:10:42 to specify the seed and get the same sample every time
+	RandomSampling = "random"
+
+	// PairwiseSampling is the sampling strategy keeping a subset of the experiments covering all
+	// the pairs of versions of host MPI, container MPI and Singularity at least once
+	PairwiseSampling = "pairwise"
+)
+
+// Sampling selects a subset of the experiments of a compatibility matrix, e.g., to run a quick
+// validation daily and the full matrix weekly
+type Sampling struct {
+	// Expr is the expression of the sampling strategy, e.g., random:10:42
+	Expr string
+
+	sample func([]Experiment) []Experiment
+}
+
+// sampleDiagonal keeps the experiments using the same MPI on the host and in the container
+func sampleDiagonal(exps []Experiment) []Experiment {
+	var sampled []Experiment
+	for _, e := range exps {
+		if sameMPI(&e.HostMPI, &e.ContainerMPI) {
+			sampled = append(sampled, e)
+		}
+	}
+	return sampled
+}
+
+// sampleLatestHost keeps the experiments using the most recent host MPI of each implementation
+func sampleLatestHost(exps []Experiment) []Experiment {
+	latest := make(map[string]string)
+	for _, e := range exps {
+		v, ok := latest[e.HostMPI.ID]
+		if !ok || implem.CompareVersions(e.HostMPI.Version, v) > 0 {
+			latest[e.HostMPI.ID] = e.HostMPI.Version
+		}
+	}
+
+	var sampled []Experiment
+	for _, e := range exps {
+		if latest[e.HostMPI.ID] == e.HostMPI.Version {
+			sampled = append(sampled, e)
+		}
+	}
+	return sampled
+}
+
+// getRandomSampler returns a function keeping k experiments chosen randomly with a given seed,
+// in the order of the list of experiments
+func getRandomSampler(k int, seed int64) func([]Experiment) []Experiment {
+	return func(exps []Experiment) []Experiment {
+		if k >= len(exps) {
+			return exps
+		}
+		idx := rand.New(rand.NewSource(seed)).Perm(len(exps))[:k]
+		sort.Ints(idx)
+		var sampled []Experiment
+		for _, i := range idx {
+			sampled = append(sampled, exps[i])
+		}
+		return sampled
+	}
+}
+
+// getPairs returns the pairs of versions of host MPI, container MPI and Singularity of an experiment
+func getPairs(e *Experiment) []string {
+	host := e.HostMPI.ID + ":" + e.HostMPI.Version
+	container := e.ContainerMPI.ID + ":" + e.ContainerMPI.Version
+	sy := e.Singularity.Version
+	return []string{"host=" + host + "/container=" + container, "host=" + host + "/sy=" + sy, "container=" + container + "/sy=" + sy}
+}
+
+// samplePairwise keeps a subset of the experiments covering all the pairs of versions of host
+// MPI, container MPI and Singularity found in the experiments. The experiment covering the most
+// pairs not covered yet is selected first, the first experiment of the list being selected in
+// case of a tie.
+func samplePairwise(exps []Experiment) []Experiment {
+	uncovered := make(map[string]bool)
+	for i := range exps {
+		for _, p := range getPairs(&exps[i]) {
+			uncovered[p] = true
+		}
+	}
+
+	selected := make([]bool, len(exps))
+	for len(uncovered) > 0 {
+		best := -1
+		bestCount := 0
+		for i := range exps {
+			if selected[i] {
+				continue
+			}
+			count := 0
+			for _, p := range getPairs(&exps[i]) {
+				if uncovered[p] {
+					count++
+				}
+			}
+			if count > bestCount {
+				best = i
+				bestCount = count
+			}
+		}
+		selected[best] = true
+		for _, p := range getPairs(&exps[best]) {
+			delete(uncovered, p)
+		}
+	}
+
+	var sampled []Experiment
+	for i, e := range exps {
+		if selected[i] {
+			sampled = append(sampled, e)
+		}
+	}
+	return sampled
+}
+
+// ParseSampling parses the expression of a sampling strategy. Supported expressions are:
+// - diagonal, the same MPI being used on the host and in the container,
+// - latest-host, the most recent host MPI of each implementation with all the container MPIs,
+// - random:<K>[:<seed>], K experiments chosen randomly; without seed, a new seed is used, and
+// logged, every time the experiments are sampled,
+// - pairwise, a subset of the experiments covering all the pairs of versions of host MPI,
+// container MPI and Singularity.
+func ParseSampling(expr string) (Sampling, error) {
+	s := Sampling{Expr: strings.TrimSpace(expr)}
+
+	switch s.Expr {
+	case DiagonalSampling:
+		s.sample = sampleDiagonal
+		return s, nil
+	case LatestHostSampling:
+		s.sample = sampleLatestHost
+		return s, nil
+	case PairwiseSampling:
+		s.sample = samplePairwise
+		return s, nil
+	}
+
+	tokens := strings.Split(s.Expr, ":")
+	if tokens[0] != RandomSampling || len(tokens) < 2 || len(tokens) > 3 {
+		return s, fmt.Errorf("invalid sampling strategy %s, it should be %s, %s, %s:<K>[:<seed>] or %s", expr, DiagonalSampling, LatestHostSampling, RandomSampling, PairwiseSampling)
+	}
+	k, err := strconv.Atoi(tokens[1])
+	if err != nil || k <= 0 {
+		return s, fmt.Errorf("invalid sampling strategy %s: invalid number of experiments %s", expr, tokens[1])
+	}
+	if len(tokens) == 3 {
+		seed, err := strconv.ParseInt(tokens[2], 10, 64)
+		if err != nil {
+			return s, fmt.Errorf("invalid sampling strategy %s: invalid seed %s", expr, tokens[2])
+		}
+		s.sample = getRandomSampler(k, seed)
+		return s, nil
+	}
+	s.sample = func(exps []Experiment) []Experiment {
+		seed := time.Now().UnixNano()
+		log.Printf("* Sampling %d experiments with seed %d\n", k, seed)
+		return getRandomSampler(k, seed)(exps)
+	}
+	return s, nil
+}
+
+// SampleExperiments returns the experiments selected by a sampling strategy, in the same order.
+// Standalone experiments, which do not use MPI, are always kept and returned last.
+func SampleExperiments(exps []Experiment, s Sampling) []Experiment {
+	var mpiExps []Experiment
+	var standalone []Experiment
+	for _, e := range exps {
+		if e.IsStandalone() {
+			standalone = append(standalone, e)
+		} else {
+			mpiExps = append(mpiExps, e)
+		}
+	}
+	if len(mpiExps) == 0 {
+		return exps
+	}
+	return append(s.sample(mpiExps), standalone...)
+}
@@ -0,0 +1,623 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/events"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// Experiment represents the execution of a container with a given MPI on the host
+type Experiment struct {
+	// HostMPI is the MPI implementation to install on the host
+	HostMPI implem.Info
+
+	// ContainerMPI is the MPI implementation to install in the container
+	ContainerMPI implem.Info
+
+	// App is the container to execute when the experiment does not use MPI
+	App string
+
+	// Singularity is the version of Singularity used to execute the container, the version
+	// available on the system being used when not specified
+	Singularity implem.Info
+
+	// RuntimeMode is the runtime mode of Singularity used to execute the container, e.g.,
+	// sys.RuntimeModeOCI; the runtime mode of the tool's configuration being used when empty
+	RuntimeMode string
+
+	// Distro is the Linux distribution of the container, e.g., centos:7; the distribution of the
+	// tool's configuration being used when empty
+	Distro string
+
+	// PreRun and PostRun are the hooks executed before and after the experiment
+	PreRun  []Hook
+	PostRun []Hook
+
+	// Limits are the resource limits of the builds of the experiment, which override the limits
+	// of the tool's configuration file
+	Limits syexec.Limits
+}
+
+// IsStandalone checks whether an experiment runs a container without MPI
+func (e *Experiment) IsStandalone() bool {
+	return e.App != ""
+}
+
+// NewResult returns a result, failed by default, for an experiment
+func (e *Experiment) NewResult() results.Result {
+	r := results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, App: e.App, Singularity: e.Singularity.Version, RuntimeMode: e.RuntimeMode, Distro: e.Distro, Pass: false}
+	if e.IsStandalone() {
+		r.Category = results.StandaloneCategory
+	}
+	return r
+}
+
+// getName returns the name of an experiment used in messages
+func (e *Experiment) getName() string {
+	name := e.HostMPI.Version + "-" + e.ContainerMPI.Version
+	if e.IsStandalone() {
+		name = e.App
+	}
+	if e.Singularity.Version != "" {
+		name += " (Singularity " + e.Singularity.Version + ")"
+	}
+	if e.RuntimeMode != "" {
+		name += " (" + e.RuntimeMode + " mode)"
+	}
+	if e.Distro != "" {
+		name += " (" + e.Distro + ")"
+	}
+	return name
+}
+
+// getConfig returns the configuration of the tool to execute an experiment with, i.e., with the
+// runtime mode and the Linux distribution of the container it pins, if any
+func (e *Experiment) getConfig(sysCfg *sys.Config) *sys.Config {
+	if e.RuntimeMode == "" && e.Distro == "" {
+		return sysCfg
+	}
+	cfg := *sysCfg
+	if e.RuntimeMode != "" {
+		cfg.RuntimeMode = e.RuntimeMode
+	}
+	if e.Distro != "" {
+		cfg.TargetDistro = e.Distro
+	}
+	return &cfg
+}
+
+// getContainerID returns the identifier of the container of an experiment, which is created
+// once for all the experiments using the same MPI and Linux distribution in the container
+func (e *Experiment) getContainerID() string {
+	id := e.ContainerMPI.ID + "-" + e.ContainerMPI.Version
+	if e.Distro != "" {
+		id += "-" + sys.GetDistroID(e.Distro)
+	}
+	return id
+}
+
+// Group is a set of experiments using the same MPI on the host, which is therefore
+// installed only once for the entire group
+type Group struct {
+	// HostMPI is the MPI implementation installed on the host for all the experiments of the group
+	HostMPI implem.Info
+
+	// Experiments is the ordered list of experiments of the group
+	Experiments []Experiment
+
+	// Standalone specifies whether the group gathers experiments without MPI, in which case
+	// nothing needs to be installed on the host or built
+	Standalone bool
+}
+
+// BuildFn is a "function pointer" to install a MPI on the host or create a container
+type BuildFn func(*implem.Info, *sys.Config) error
+
+// TeardownFn is a "function pointer" to remove a MPI installed on the host or a container
+type TeardownFn func(*implem.Info, *sys.Config) error
+
+// RunFn is a "function pointer" to execute an experiment once the host MPI and the container are ready
+type RunFn func(*Experiment, *sys.Config) results.Result
+
+// SingularityFn is a "function pointer" to install a version of Singularity, if not already
+// installed, and return the path to its binary
+type SingularityFn func(*implem.Info, *sys.Config) (string, error)
+
+// ProbeFn is a "function pointer" to quickly check whether an experiment is expected to succeed before
+// executing it; it returns false and the reason when the experiment is expected to fail
+type ProbeFn func(*Experiment, *sys.Config) (bool, string)
+
+// TuningFn is a "function pointer" to read the tuning profile recorded in the labels of the image
+// of the container of an experiment, e.g., mca-params@sha256:<hex> (see container.TuningProfileLabel);
+// empty when the image has none
+type TuningFn func(*Experiment, *sys.Config) (string, error)
+
+// ProgressFn is a "function pointer" to report the progress of the execution of a plan: the number
+// of the current experiment, the total number of experiments and the current phase
+type ProgressFn func(int, int, string)
+
+const (
+	// PhaseHostInstall is the phase during which a MPI is installed on the host
+	PhaseHostInstall = "installing MPI on the host"
+
+	// PhaseContainerBuild is the phase during which a container is created
+	PhaseContainerBuild = "creating container"
+
+	// PhaseRun is the phase during which an experiment is executed
+	PhaseRun = "running"
+)
+
+// Ops gathers the operations used to execute a plan
+type Ops struct {
+	// BuildHost installs a MPI on the host
+	BuildHost BuildFn
+
+	// TeardownHost removes a MPI from the host
+	TeardownHost TeardownFn
+
+	// BuildContainer creates a container with a given MPI
+	BuildContainer BuildFn
+
+	// TeardownContainer removes a container with a given MPI
+	TeardownContainer TeardownFn
+
+	// Run executes an experiment
+	Run RunFn
+
+	// SetupSingularity installs the versions of Singularity pinned by experiments
+	SetupSingularity SingularityFn
+
+	// Probe is an optional pre-filter: experiments it predicts to fail are not executed
+	Probe ProbeFn
+
+	// ContainerTuning is optional, it reads the tuning profile of the image of the container of an
+	// experiment so the results record the profile the container was actually built with
+	ContainerTuning TuningFn
+
+	// Progress is notified of the progress of the execution of the plan, in addition to the log messages
+	Progress ProgressFn
+}
+
+// progress tracks the number of experiments executed out of all the experiments of a plan
+type progress struct {
+	current int
+	total   int
+	fn      ProgressFn
+}
+
+func newProgress(plan []Group, fn ProgressFn) *progress {
+	p := &progress{fn: fn}
+	for _, g := range plan {
+		p.total += len(g.Experiments)
+	}
+	return p
+}
+
+// report logs the current phase of the execution of the plan
+func (p *progress) report(phase string, what string) {
+	log.Printf("* [%d/%d] %s: %s\n", p.current, p.total, phase, what)
+	if p.fn != nil {
+		p.fn(p.current, p.total, phase)
+	}
+}
+
+// emitStart emits the event of the start of the execution of an experiment and returns the time
+// it started
+func (p *progress) emitStart(e *Experiment) time.Time {
+	events.Emit(events.Event{Type: events.ExperimentStarted, Phase: events.PhaseRun, Experiment: e.getName(), Current: p.current, Total: p.total})
+	return time.Now()
+}
+
+// isDone checks whether an experiment already has a result, the results being matched with the
+// experiments by key (see results.GetKey), i.e., including the version of Singularity, the runtime
+// mode and the Linux distribution. The tuning profile is recorded with the results but is not part
+// of the description of the experiments, so it is ignored.
+func isDone(e *Experiment, done []results.Result) bool {
+	expected := e.NewResult()
+	key := results.GetKey(&expected)
+	for _, r := range done {
+		r.Tuning = ""
+		if results.GetKey(&r) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// sortByVersion sorts a list of MPI implementations by version
+func sortByVersion(mpis []implem.Info) []implem.Info {
+	sorted := make([]implem.Info, len(mpis))
+	copy(sorted, mpis)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return implem.CompareVersions(sorted[i].Version, sorted[j].Version) < 0
+	})
+	return sorted
+}
+
+// Plan creates the ordered list of groups of experiments to test all the combinations of
+// host and container MPIs, skipping the experiments that already have a result.
+func Plan(hostMPIs []implem.Info, containerMPIs []implem.Info, done []results.Result) []Group {
+	return PlanMatrix(hostMPIs, containerMPIs, nil, done)
+}
+
+// PlanMatrix creates the ordered list of groups of experiments to test all the combinations of
+// host MPIs, container MPIs and versions of Singularity, skipping the experiments that already
+// have a result. When no version of Singularity is specified, the version available on the
+// system is used.
+func PlanMatrix(hostMPIs []implem.Info, containerMPIs []implem.Info, singularities []implem.Info, done []results.Result) []Group {
+	return PlanExperiments(Matrix(hostMPIs, containerMPIs, singularities), done)
+}
+
+// Matrix returns the experiments testing all the combinations of host MPIs, container MPIs and
+// versions of Singularity; it can be restricted with FilterExperiments before creating the plan
+func Matrix(hostMPIs []implem.Info, containerMPIs []implem.Info, singularities []implem.Info) []Experiment {
+	if len(singularities) == 0 {
+		singularities = []implem.Info{{}}
+	}
+
+	var exps []Experiment
+	for _, hostMPI := range hostMPIs {
+		for _, containerMPI := range containerMPIs {
+			for _, sy := range singularities {
+				exps = append(exps, Experiment{HostMPI: hostMPI, ContainerMPI: containerMPI, Singularity: sy})
+			}
+		}
+	}
+	return exps
+}
+
+// PlanExperiments creates the ordered list of groups of experiments from a list of experiments,
+// skipping the experiments that already have a result. MPI experiments are grouped by host MPI
+// so each MPI is installed once on the host, and the containers are always used in the same
+// order within each group, each container being executed with the different versions of
+// Singularity, runtime modes and Linux distributions, in order. Standalone experiments are
+// gathered in a last group.
+func PlanExperiments(exps []Experiment, done []results.Result) []Group {
+	var plan []Group
+	var standalone Group
+	standalone.Standalone = true
+
+	var hostMPIs []implem.Info
+	var containerMPIs []implem.Info
+	var singularities []implem.Info
+	var modes []string
+	var distros []string
+	for _, e := range exps {
+		if isDone(&e, done) {
+			log.Printf("* Experiment %s already executed, skipping...\n", e.getName())
+			continue
+		}
+		if e.IsStandalone() {
+			standalone.Experiments = append(standalone.Experiments, e)
+			continue
+		}
+		hostMPIs = appendMPI(hostMPIs, e.HostMPI)
+		containerMPIs = appendMPI(containerMPIs, e.ContainerMPI)
+		singularities = appendMPI(singularities, e.Singularity)
+		modes = appendMode(modes, e.RuntimeMode)
+		distros = appendMode(distros, e.Distro)
+	}
+
+	containers := sortByVersion(containerMPIs)
+	syVersions := sortByVersion(singularities)
+	for _, hostMPI := range sortByVersion(hostMPIs) {
+		g := Group{HostMPI: hostMPI}
+		for _, containerMPI := range containers {
+			for _, sy := range syVersions {
+				for _, mode := range modes {
+					for _, d := range distros {
+						for _, e := range exps {
+							if !e.IsStandalone() && sameMPI(&e.HostMPI, &hostMPI) && sameMPI(&e.ContainerMPI, &containerMPI) && sameMPI(&e.Singularity, &sy) && e.RuntimeMode == mode && e.Distro == d && !isDone(&e, done) {
+								g.Experiments = append(g.Experiments, e)
+								break
+							}
+						}
+					}
+				}
+			}
+		}
+		if len(g.Experiments) > 0 {
+			plan = append(plan, g)
+		}
+	}
+
+	if len(standalone.Experiments) > 0 {
+		plan = append(plan, standalone)
+	}
+
+	return plan
+}
+
+// sameMPI checks whether two MPI implementations (or versions of Singularity) are identical
+func sameMPI(mpi1 *implem.Info, mpi2 *implem.Info) bool {
+	return mpi1.ID == mpi2.ID && mpi1.Version == mpi2.Version
+}
+
+// appendMode adds a runtime mode, or a Linux distribution, to a list if not already in it
+func appendMode(modes []string, mode string) []string {
+	for _, m := range modes {
+		if m == mode {
+			return modes
+		}
+	}
+	return append(modes, mode)
+}
+
+// appendMPI adds a MPI implementation to a list if not already in it
+func appendMPI(mpis []implem.Info, mpi implem.Info) []implem.Info {
+	for i := range mpis {
+		if sameMPI(&mpis[i], &mpi) {
+			return mpis
+		}
+	}
+	return append(mpis, mpi)
+}
+
+// failGroup returns the results of the experiments of a group that cannot be executed
+func failGroup(g *Group, category string, note string) []results.Result {
+	var res []results.Result
+	for _, e := range g.Experiments {
+		r := e.NewResult()
+		r.ErrorCategory = category
+		r.Note = note
+		res = append(res, r)
+	}
+	return res
+}
+
+// singularities keeps track of the versions of Singularity installed while executing a plan
+type singularities struct {
+	bins   map[string]string
+	failed map[string]error
+}
+
+// run executes an experiment with the version of Singularity it pins, if any, which is installed
+// the first time it is needed, and in the runtime mode and Linux distribution it pins, if any
+func (s *singularities) run(e *Experiment, ops *Ops, sysCfg *sys.Config) results.Result {
+	sysCfg = e.getConfig(sysCfg)
+
+	v := e.Singularity.Version
+	if v == "" {
+		return runExperiment(e, ops, sysCfg)
+	}
+
+	if _, ok := s.bins[v]; !ok && s.failed[v] == nil {
+		if ops.SetupSingularity == nil {
+			s.failed[v] = fmt.Errorf("no support to install Singularity")
+		} else {
+			log.Printf("* Installing Singularity %s\n", v)
+			bin, err := ops.SetupSingularity(&e.Singularity, sysCfg)
+			if err != nil {
+				log.Printf("[ERROR] failed to install Singularity %s: %s\n", v, err)
+				s.failed[v] = err
+			} else {
+				s.bins[v] = bin
+			}
+		}
+	}
+	if s.failed[v] != nil {
+		r := e.NewResult()
+		r.ErrorCategory = results.ErrorSingularityInstall
+		r.Note = fmt.Sprintf("failed to install Singularity %s: %s", v, s.failed[v])
+		return r
+	}
+
+	// The experiment is executed with its own configuration to not change the version of
+	// Singularity used by the other experiments
+	syCfg := *sysCfg
+	syCfg.SingularityBin = s.bins[v]
+	return runExperiment(e, ops, &syCfg)
+}
+
+// runExperiment executes an experiment with its hooks, unless the probe predicts that it will fail;
+// the experiment is executed sysCfg.Nrun times when more than once
+func runExperiment(e *Experiment, ops *Ops, sysCfg *sys.Config) results.Result {
+	if ops.Probe != nil && !e.IsStandalone() {
+		ok, reason := ops.Probe(e, sysCfg)
+		if !ok {
+			log.Printf("* Skipping %s, the probe predicts a failure: %s\n", e, reason)
+			r := e.NewResult()
+			r.ErrorCategory = results.ErrorProbe
+			r.Note = "probe: " + reason
+			return r
+		}
+	}
+	if sysCfg.Nrun <= 1 {
+		start := time.Now()
+		r := runWithHooks(e, ops.Run, sysCfg)
+		r.Duration = time.Since(start)
+		return r
+	}
+
+	// Each iteration is executed with its hooks and the results aggregated so that intermittent
+	// failures are detected
+	var iterations []results.Result
+	for i := 0; i < sysCfg.Nrun; i++ {
+		log.Printf("* Iteration %d/%d of %s\n", i+1, sysCfg.Nrun, e.getName())
+		start := time.Now()
+		r := runWithHooks(e, ops.Run, sysCfg)
+		r.Duration = time.Since(start)
+		iterations = append(iterations, r)
+	}
+	return results.Aggregate(iterations)
+}
+
+// getContainerTuning returns the name of the tuning profile recorded in the labels of the image of
+// the container of an experiment, which differs from the current profile of the site when the
+// image was built before the profile changed; empty when unknown
+func getContainerTuning(e *Experiment, ops *Ops, sysCfg *sys.Config) string {
+	if ops.ContainerTuning == nil {
+		return ""
+	}
+	label, err := ops.ContainerTuning(e, e.getConfig(sysCfg))
+	if err != nil {
+		log.Printf("[WARN] failed to get the tuning profile of the container for %s: %s\n", e.getContainerID(), err)
+		return ""
+	}
+	name, _ := sys.ParseTuningLabel(label)
+	return name
+}
+
+// getTuning returns the names of the tuning profiles active during an experiment, as recorded in
+// the results: the profile of the site for the MPI of the host, which mpirun is pointed to, and
+// the profile of the image of the container used by the ranks
+func getTuning(e *Experiment, containerTuning string, sysCfg *sys.Config) string {
+	var hostTuning string
+	p := sys.GetTuningProfile(sysCfg, e.HostMPI.ID)
+	if p != nil {
+		hostTuning = p.Name
+	}
+	return sys.JoinTuningProfileNames(hostTuning, containerTuning)
+}
+
+// Execute executes a plan. The MPI of a group is installed on the host before executing the
+// experiments of the group and, in non-persistent mode, removed once they all completed.
+// Containers are created the first time they are needed and reused by the following groups,
+// they are removed once the entire plan is executed in non-persistent mode. The versions of
+// Singularity pinned by experiments are installed the first time they are needed and kept.
+// The cleanup policy can change what is removed, non-persistent mode being only its default. The
+// builds are executed with the resource limits of their experiment and the resources used to
+// create the container of an experiment and to execute it are reported in its metrics.
+func Execute(plan []Group, ops *Ops, sysCfg *sys.Config) []results.Result {
+	var res []results.Result
+	sy := singularities{bins: make(map[string]string), failed: make(map[string]error)}
+
+	built := make(map[string]*implem.Info)
+	var containers []*Experiment
+	failed := make(map[string]error)
+	// tunings are the tuning profiles of the images of the containers, read once per container
+	tunings := make(map[string]string)
+	// containerFailed tracks the containers used by at least one failed experiment
+	containerFailed := make(map[string]bool)
+	prog := newProgress(plan, ops.Progress)
+
+	for i := range plan {
+		g := &plan[i]
+		if g.Standalone {
+			// Nothing to install or build, the containers are simply executed
+			for j := range g.Experiments {
+				prog.current++
+				prog.report(PhaseRun, g.Experiments[j].getName())
+				start := prog.emitStart(&g.Experiments[j])
+				syexec.TakeUsage()
+				prevLimits := setLimits(&g.Experiments[j])
+				r := sy.run(&g.Experiments[j], ops, sysCfg)
+				syexec.SetLimits(prevLimits)
+				events.EmitRun(g.Experiments[j].getName(), r.Pass, r.ErrorCategory, r.Note, start)
+				addUsage(&r, syexec.TakeUsage())
+				r.Category = results.StandaloneCategory
+				r.App = g.Experiments[j].App
+				r.Singularity = g.Experiments[j].Singularity.Version
+				r.RuntimeMode = g.Experiments[j].RuntimeMode
+				r.Distro = g.Experiments[j].Distro
+				res = append(res, r)
+			}
+			continue
+		}
+
+		log.Printf("* Installing %s %s on the host for %d experiment(s)\n", g.HostMPI.ID, g.HostMPI.Version, len(g.Experiments))
+		prog.report(PhaseHostInstall, g.HostMPI.ID+" "+g.HostMPI.Version)
+		// MPI is installed on the host once for the group, with the limits of its first experiment
+		prevLimits := setLimits(&g.Experiments[0])
+		start := time.Now()
+		err := ops.BuildHost(&g.HostMPI, sysCfg)
+		syexec.SetLimits(prevLimits)
+		events.EmitBuild(events.PhaseInstall, g.HostMPI.ID+":"+g.HostMPI.Version, start, err)
+		if err != nil {
+			log.Printf("[ERROR] failed to install %s %s on the host: %s\n", g.HostMPI.ID, g.HostMPI.Version, err)
+			res = append(res, failGroup(g, results.ErrorHostInstall, fmt.Sprintf("failed to install MPI on the host: %s", err))...)
+			prog.current += len(g.Experiments)
+			continue
+		}
+
+		groupFailed := false
+		for j := range g.Experiments {
+			e := &g.Experiments[j]
+			id := e.getContainerID()
+			prog.current++
+			syexec.TakeUsage()
+			prevLimits := setLimits(e)
+			if _, ok := built[id]; !ok && failed[id] == nil {
+				prog.report(PhaseContainerBuild, e.ContainerMPI.ID+" "+e.ContainerMPI.Version)
+				start := time.Now()
+				err = ops.BuildContainer(&e.ContainerMPI, e.getConfig(sysCfg))
+				events.EmitBuild(events.PhaseBuild, e.ContainerMPI.ID+":"+e.ContainerMPI.Version, start, err)
+				if err != nil {
+					log.Printf("[ERROR] failed to create container for %s: %s\n", id, err)
+					failed[id] = err
+				} else {
+					built[id] = &e.ContainerMPI
+					containers = append(containers, e)
+				}
+			}
+			if failed[id] != nil {
+				syexec.SetLimits(prevLimits)
+				r := e.NewResult()
+				r.ErrorCategory = results.ErrorContainerBuild
+				r.Note = fmt.Sprintf("failed to create container: %s", failed[id])
+				if errors.Is(failed[id], sympierr.ErrNoPrivilege) {
+					// The experiment is skipped rather than failed, the host lacks the privileges
+					r.ErrorCategory = results.ErrorPrivileges
+					r.Note = fmt.Sprintf("skipped: %s", failed[id])
+				}
+				res = append(res, r)
+				groupFailed = true
+				continue
+			}
+
+			prog.report(PhaseRun, e.getName())
+			start := prog.emitStart(e)
+			r := sy.run(e, ops, sysCfg)
+			syexec.SetLimits(prevLimits)
+			events.EmitRun(e.getName(), r.Pass, r.ErrorCategory, r.Note, start)
+			addUsage(&r, syexec.TakeUsage())
+			r.Singularity = e.Singularity.Version
+			r.RuntimeMode = e.RuntimeMode
+			r.Distro = e.Distro
+			if _, ok := tunings[id]; !ok {
+				tunings[id] = getContainerTuning(e, ops, sysCfg)
+			}
+			r.Tuning = getTuning(e, tunings[id], sysCfg)
+			res = append(res, r)
+			if !r.Pass {
+				groupFailed = true
+				containerFailed[id] = true
+			}
+		}
+
+		if sys.ShouldCleanup(sysCfg, sys.HostMPIResource, groupFailed) && ops.TeardownHost != nil {
+			err = ops.TeardownHost(&g.HostMPI, sysCfg)
+			if err != nil {
+				log.Printf("[WARN] failed to remove %s %s from the host: %s\n", g.HostMPI.ID, g.HostMPI.Version, err)
+			}
+		}
+	}
+
+	if ops.TeardownContainer != nil {
+		for _, e := range containers {
+			if !sys.ShouldCleanup(sysCfg, sys.ImageResource, containerFailed[e.getContainerID()]) {
+				continue
+			}
+			err := ops.TeardownContainer(&e.ContainerMPI, e.getConfig(sysCfg))
+			if err != nil {
+				log.Printf("[WARN] failed to remove container for %s %s: %s\n", e.ContainerMPI.ID, e.ContainerMPI.Version, err)
+			}
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,1092 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func getMPIs(versions ...string) []implem.Info {
+	var mpis []implem.Info
+	for _, v := range versions {
+		mpis = append(mpis, implem.Info{ID: implem.OMPI, Version: v})
+	}
+	return mpis
+}
+
+func TestPlan(t *testing.T) {
+	done := []results.Result{
+		{HostMPI: implem.Info{Version: "3.1.4"}, ContainerMPI: implem.Info{Version: "3.1.4"}, Pass: true},
+	}
+	plan := Plan(getMPIs("4.0.2", "3.1.4"), getMPIs("4.0.2", "3.1.4"), done)
+
+	if len(plan) != 2 {
+		t.Fatalf("plan has %d groups instead of 2", len(plan))
+	}
+	if plan[0].HostMPI.Version != "3.1.4" || len(plan[0].Experiments) != 1 {
+		t.Fatalf("invalid first group: %v", plan[0])
+	}
+	if plan[1].HostMPI.Version != "4.0.2" || len(plan[1].Experiments) != 2 {
+		t.Fatalf("invalid second group: %v", plan[1])
+	}
+	if plan[1].Experiments[0].ContainerMPI.Version != "3.1.4" {
+		t.Fatalf("experiments are not sorted by container version: %v", plan[1])
+	}
+}
+
+func TestIsDone(t *testing.T) {
+	e := Experiment{
+		HostMPI:      implem.Info{ID: implem.OMPI, Version: "4.0.2"},
+		ContainerMPI: implem.Info{ID: implem.OMPI, Version: "3.1.4"},
+		Singularity:  implem.Info{ID: implem.SY, Version: "3.5.2"},
+		RuntimeMode:  "rootless",
+	}
+	done := e.NewResult()
+	done.Pass = true
+
+	tests := []struct {
+		name     string
+		result   func(r results.Result) results.Result
+		expected bool
+	}{
+		{name: "same experiment", result: func(r results.Result) results.Result { return r }, expected: true},
+		{name: "tuned", result: func(r results.Result) results.Result { r.Tuning = "mca-params"; return r }, expected: true},
+		{name: "other Singularity", result: func(r results.Result) results.Result { r.Singularity = "3.6.0"; return r }},
+		{name: "other mode", result: func(r results.Result) results.Result { r.RuntimeMode = ""; return r }},
+		{name: "other distro", result: func(r results.Result) results.Result { r.Distro = "centos:7"; return r }},
+		{name: "other container MPI", result: func(r results.Result) results.Result { r.ContainerMPI.Version = "4.0.2"; return r }},
+		{name: "standalone", result: func(r results.Result) results.Result { r.Category = results.StandaloneCategory; return r }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if isDone(&e, []results.Result{tt.result(done)}) != tt.expected {
+				t.Fatalf("isDone() did not return %t", tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name               string
+		persistent         string
+		expectedTeardowns  int
+		expectedFailedRuns int
+		failedContainer    string
+	}{
+		{
+			name:              "non-persistent",
+			expectedTeardowns: 6,
+		},
+		{
+			name:              "persistent",
+			persistent:        "/tmp",
+			expectedTeardowns: 0,
+		},
+		{
+			name:               "container failure",
+			persistent:         "/tmp",
+			failedContainer:    "3.1.4",
+			expectedTeardowns:  0,
+			expectedFailedRuns: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builds := make(map[string]int)
+			teardowns := 0
+			lastProgress := 0
+			ops := Ops{
+				Progress: func(current int, total int, phase string) {
+					if current < lastProgress || current > total {
+						t.Fatalf("invalid progress: %d/%d", current, total)
+					}
+					lastProgress = current
+				},
+				BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+					builds["host-"+mpi.Version]++
+					return nil
+				},
+				BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+					builds["container-"+mpi.Version]++
+					if mpi.Version == tt.failedContainer {
+						return fmt.Errorf("build failed")
+					}
+					return nil
+				},
+				TeardownHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+					teardowns++
+					return nil
+				},
+				TeardownContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+					teardowns++
+					return nil
+				},
+				Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+					return results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, Pass: true}
+				},
+			}
+
+			var sysCfg sys.Config
+			sysCfg.Persistent = tt.persistent
+			versions := []string{"3.1.4", "4.0.1", "4.0.2"}
+			res := Execute(Plan(getMPIs(versions...), getMPIs(versions...), nil), &ops, &sysCfg)
+
+			if len(res) != len(versions)*len(versions) {
+				t.Fatalf("%d results instead of %d", len(res), len(versions)*len(versions))
+			}
+			for artifact, n := range builds {
+				if n != 1 {
+					t.Fatalf("%s built %d times", artifact, n)
+				}
+			}
+			if lastProgress != len(versions)*len(versions) {
+				t.Fatalf("progress stopped at experiment %d", lastProgress)
+			}
+			if teardowns != tt.expectedTeardowns {
+				t.Fatalf("%d teardowns instead of %d", teardowns, tt.expectedTeardowns)
+			}
+			failedRuns := 0
+			for _, r := range res {
+				if !r.Pass {
+					failedRuns++
+				}
+			}
+			if failedRuns != tt.expectedFailedRuns {
+				t.Fatalf("%d failed experiments instead of %d", failedRuns, tt.expectedFailedRuns)
+			}
+		})
+	}
+}
+
+func TestStandaloneExperiments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-scheduler-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgFile := filepath.Join(dir, "experiments.conf")
+	content := "# host MPI\tcontainer MPI\nopenmpi:4.0.2 openmpi:3.1.4\n\nstandalone lolcow.sif\nstandalone alpine.sif\n"
+	err = ioutil.WriteFile(cfgFile, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", cfgFile, err)
+	}
+
+	exps, err := LoadExperiments(cfgFile)
+	if err != nil {
+		t.Fatalf("LoadExperiments() failed: %s", err)
+	}
+	if len(exps) != 3 {
+		t.Fatalf("%d experiments loaded instead of 3", len(exps))
+	}
+
+	done := []results.Result{{Category: results.StandaloneCategory, App: "alpine.sif", Pass: true}}
+	plan := PlanExperiments(exps, done)
+	if len(plan) != 2 || !plan[1].Standalone || len(plan[1].Experiments) != 1 {
+		t.Fatalf("invalid plan: %v", plan)
+	}
+
+	builds := 0
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			builds++
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			builds++
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			return results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, Pass: true}
+		},
+	}
+	var sysCfg sys.Config
+	sysCfg.Persistent = dir
+	res := Execute(plan, &ops, &sysCfg)
+	if builds != 2 {
+		t.Fatalf("%d builds instead of 2", builds)
+	}
+	if len(res) != 2 || res[1].Category != results.StandaloneCategory || res[1].App != "lolcow.sif" {
+		t.Fatalf("invalid results: %v", res)
+	}
+
+	resFile := filepath.Join(dir, "results.txt")
+	err = results.Save(resFile, res)
+	if err != nil {
+		t.Fatalf("results.Save() failed: %s", err)
+	}
+	loaded, err := results.Load(resFile)
+	if err != nil {
+		t.Fatalf("results.Load() failed: %s", err)
+	}
+	if len(PlanExperiments(exps, append(loaded, done...))) != 0 {
+		t.Fatalf("experiments with saved results are not skipped")
+	}
+
+	_, err = parseExperiment("openmpi-4.0.2 openmpi:3.1.4")
+	if err == nil {
+		t.Fatalf("parseExperiment() succeeded with an invalid MPI implementation")
+	}
+}
+
+func TestSingularityMatrix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-scheduler-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	singularities := []implem.Info{{ID: implem.SY, Version: "3.5.3"}, {ID: implem.SY, Version: "3.4.2"}}
+	plan := PlanMatrix(getMPIs("4.0.2"), getMPIs("4.0.2", "3.1.4"), singularities, nil)
+	if len(plan) != 1 || len(plan[0].Experiments) != 4 {
+		t.Fatalf("invalid plan: %v", plan)
+	}
+	if plan[0].Experiments[0].ContainerMPI.Version != "3.1.4" || plan[0].Experiments[0].Singularity.Version != "3.4.2" {
+		t.Fatalf("experiments are not sorted by container and Singularity versions: %v", plan[0])
+	}
+
+	installs := make(map[string]int)
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		SetupSingularity: func(sy *implem.Info, sysCfg *sys.Config) (string, error) {
+			installs[sy.Version]++
+			if sy.Version == "3.4.2" {
+				return "", fmt.Errorf("build failed")
+			}
+			return "/sympi/singularity-" + sy.Version, nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			return results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, Pass: sysCfg.SingularityBin == "/sympi/singularity-"+e.Singularity.Version}
+		},
+	}
+	var sysCfg sys.Config
+	sysCfg.Persistent = dir
+	res := Execute(plan, &ops, &sysCfg)
+	if installs["3.5.3"] != 1 || installs["3.4.2"] != 1 {
+		t.Fatalf("invalid installations of Singularity: %v", installs)
+	}
+	for _, r := range res {
+		if r.Pass != (r.Singularity == "3.5.3") {
+			t.Fatalf("invalid result: %v", r)
+		}
+	}
+
+	resFile := filepath.Join(dir, "results.txt")
+	err = results.Save(resFile, res)
+	if err != nil {
+		t.Fatalf("results.Save() failed: %s", err)
+	}
+	loaded, err := results.Load(resFile)
+	if err != nil {
+		t.Fatalf("results.Load() failed: %s", err)
+	}
+	if len(PlanMatrix(getMPIs("4.0.2"), getMPIs("4.0.2", "3.1.4"), singularities, loaded)) != 0 {
+		t.Fatalf("experiments with saved results are not skipped")
+	}
+	if len(Plan(getMPIs("4.0.2"), getMPIs("4.0.2", "3.1.4"), loaded)) != 1 {
+		t.Fatalf("results with a pinned version of Singularity are used for experiments without")
+	}
+
+	e, err := parseExperiment("openmpi:4.0.2 openmpi:3.1.4 singularity:3.5.3")
+	if err != nil || e.Singularity.Version != "3.5.3" {
+		t.Fatalf("failed to parse an experiment pinning Singularity: %s", err)
+	}
+	_, err = parseExperiment("openmpi:4.0.2 openmpi:3.1.4 mpich:3.3")
+	if err == nil {
+		t.Fatalf("parseExperiment() succeeded with an invalid Singularity version")
+	}
+}
+
+func TestRuntimeModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-scheduler-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var exps []Experiment
+	for _, line := range []string{"openmpi:4.0.2 openmpi:3.1.4", "openmpi:4.0.2 openmpi:3.1.4 mode:oci", "openmpi:4.0.2 openmpi:3.1.4 singularity:4.1.0 mode:oci"} {
+		e, err := parseExperiment(line)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", line, err)
+		}
+		if e.String() != line {
+			t.Fatalf("%s is described as %s", line, e.String())
+		}
+		exps = append(exps, e)
+	}
+	e, err := parseExperiment("openmpi:4.0.2 openmpi:3.1.4 mode:native")
+	if err != nil || e.RuntimeMode != "" {
+		t.Fatalf("failed to parse an experiment in the native mode: %s", err)
+	}
+	_, err = parseExperiment("openmpi:4.0.2 openmpi:3.1.4 mode:docker")
+	if err == nil {
+		t.Fatalf("parseExperiment() succeeded with an invalid runtime mode")
+	}
+
+	plan := PlanExperiments(exps[:2], nil)
+	if len(plan) != 1 || len(plan[0].Experiments) != 2 {
+		t.Fatalf("invalid plan: %v", plan)
+	}
+
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			return results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, Pass: sysCfg.RuntimeMode == e.RuntimeMode}
+		},
+	}
+	var sysCfg sys.Config
+	sysCfg.Persistent = dir
+	res := Execute(plan, &ops, &sysCfg)
+	if len(res) != 2 || !res[0].Pass || !res[1].Pass || res[0].RuntimeMode == res[1].RuntimeMode {
+		t.Fatalf("experiments were not executed in their runtime mode: %v", res)
+	}
+	resFile := filepath.Join(dir, "results.txt")
+	err = results.Save(resFile, res)
+	if err != nil {
+		t.Fatalf("results.Save() failed: %s", err)
+	}
+	res, err = results.Load(resFile)
+	if err != nil {
+		t.Fatalf("results.Load() failed: %s", err)
+	}
+	if len(PlanExperiments(exps[:2], res)) != 0 {
+		t.Fatalf("experiments with results in their runtime mode are not skipped")
+	}
+	if len(PlanExperiments(exps[:1], res[1:])) != 1 {
+		t.Fatalf("results in the OCI mode are used for experiments in the native mode")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	plan := Plan(getMPIs("4.0.2"), getMPIs("4.0.2", "3.1.4"), nil)
+	runs := 0
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		Probe: func(e *Experiment, sysCfg *sys.Config) (bool, string) {
+			if e.ContainerMPI.Version != e.HostMPI.Version {
+				return false, "version mismatch"
+			}
+			return true, ""
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			runs++
+			return results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, Pass: true}
+		},
+	}
+	var sysCfg sys.Config
+	res := Execute(plan, &ops, &sysCfg)
+	if len(res) != 2 || runs != 1 {
+		t.Fatalf("%d experiments executed out of %d", runs, len(res))
+	}
+	for _, r := range res {
+		mismatch := r.ContainerMPI.Version != r.HostMPI.Version
+		if r.Pass == mismatch || mismatch != (r.ErrorCategory == results.ErrorProbe) {
+			t.Fatalf("invalid result: %v", r)
+		}
+	}
+}
+
+func TestMissingPrivileges(t *testing.T) {
+	plan := Plan(getMPIs("4.0.2"), getMPIs("4.0.2", "3.1.4"), nil)
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			if mpi.Version == "3.1.4" {
+				return fmt.Errorf("failed to create container: %w", sympierr.Wrap(sympierr.ErrNoPrivilege, nil, "sudo is not available"))
+			}
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			return results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, Pass: true}
+		},
+	}
+	var sysCfg sys.Config
+	res := Execute(plan, &ops, &sysCfg)
+	if len(res) != 2 {
+		t.Fatalf("%d results instead of 2", len(res))
+	}
+	for _, r := range res {
+		skipped := r.ContainerMPI.Version == "3.1.4"
+		if r.Pass == skipped || skipped != (r.ErrorCategory == results.ErrorPrivileges) {
+			t.Fatalf("invalid result: %v", r)
+		}
+	}
+}
+
+func TestFilterExperiments(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  string
+		exclude  string
+		expected []string
+	}{
+		{
+			name:     "no filter",
+			expected: []string{"3.1.4-3.1.4", "3.1.4-4.0.2", "3.1.4-4.0.5", "4.0.2-3.1.4", "4.0.2-4.0.2", "4.0.2-4.0.5", "4.0.5-3.1.4", "4.0.5-4.0.2", "4.0.5-4.0.5"},
+		},
+		{
+			name:     "version range and set",
+			include:  "host>=4.0;container in {4.0.2, 4.0.5}",
+			expected: []string{"4.0.2-4.0.2", "4.0.2-4.0.5", "4.0.5-4.0.2", "4.0.5-4.0.5"},
+		},
+		{
+			name:     "same version",
+			include:  "same-version",
+			expected: []string{"3.1.4-3.1.4", "4.0.2-4.0.2", "4.0.5-4.0.5"},
+		},
+		{
+			name:     "upper triangular",
+			include:  "upper-triangular",
+			exclude:  "same-version;container=4.0.5",
+			expected: []string{"3.1.4-4.0.2"},
+		},
+	}
+
+	mpis := getMPIs("3.1.4", "4.0.2", "4.0.5")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := ParseFilters(tt.include, false)
+			if err != nil {
+				t.Fatalf("ParseFilters() failed: %s", err)
+			}
+			excludeFilters, err := ParseFilters(tt.exclude, true)
+			if err != nil {
+				t.Fatalf("ParseFilters() failed: %s", err)
+			}
+			exps := FilterExperiments(Matrix(mpis, mpis, nil), append(filters, excludeFilters...))
+			var names []string
+			for _, e := range exps {
+				names = append(names, e.getName())
+			}
+			if strings.Join(names, " ") != strings.Join(tt.expected, " ") {
+				t.Fatalf("FilterExperiments() returned %v instead of %v", names, tt.expected)
+			}
+		})
+	}
+
+	for _, expr := range []string{"host>>4.0", "compiler>=4.0", "container in 4.0.2", "host>="} {
+		_, err := ParseFilter(expr, false)
+		if err == nil {
+			t.Fatalf("ParseFilter() succeeded with the invalid filter %s", expr)
+		}
+	}
+}
+
+func TestSampleExperiments(t *testing.T) {
+	mpis := getMPIs("3.1.4", "4.0.2", "4.0.5")
+	singularities := []implem.Info{{ID: implem.SY, Version: "3.5.3"}, {ID: implem.SY, Version: "3.4.2"}}
+
+	tests := []struct {
+		sampling string
+		exps     []Experiment
+		expected []string
+	}{
+		{
+			sampling: "diagonal",
+			exps:     Matrix(mpis, mpis, nil),
+			expected: []string{"3.1.4-3.1.4", "4.0.2-4.0.2", "4.0.5-4.0.5"},
+		},
+		{
+			sampling: "latest-host",
+			exps:     append(Matrix(mpis, mpis, nil), Experiment{App: "alpine.sif"}),
+			expected: []string{"4.0.5-3.1.4", "4.0.5-4.0.2", "4.0.5-4.0.5", "alpine.sif"},
+		},
+		{
+			sampling: "random:20:42",
+			exps:     Matrix(mpis, mpis, nil),
+			expected: []string{"3.1.4-3.1.4", "3.1.4-4.0.2", "3.1.4-4.0.5", "4.0.2-3.1.4", "4.0.2-4.0.2", "4.0.2-4.0.5", "4.0.5-3.1.4", "4.0.5-4.0.2", "4.0.5-4.0.5"},
+		},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSampling(tt.sampling)
+		if err != nil {
+			t.Fatalf("ParseSampling() failed: %s", err)
+		}
+		var names []string
+		for _, e := range SampleExperiments(tt.exps, s) {
+			names = append(names, e.getName())
+		}
+		if strings.Join(names, " ") != strings.Join(tt.expected, " ") {
+			t.Fatalf("SampleExperiments() returned %v instead of %v with %s", names, tt.expected, tt.sampling)
+		}
+	}
+
+	// The same seed always selects the same experiments
+	s, err := ParseSampling("random:4:42")
+	if err != nil {
+		t.Fatalf("ParseSampling() failed: %s", err)
+	}
+	sample := SampleExperiments(Matrix(mpis, mpis, singularities), s)
+	if len(sample) != 4 || fmt.Sprint(sample) != fmt.Sprint(SampleExperiments(Matrix(mpis, mpis, singularities), s)) {
+		t.Fatalf("invalid random sample: %v", sample)
+	}
+
+	// All the pairs of versions are covered with fewer experiments than the full matrix
+	s, err = ParseSampling("pairwise")
+	if err != nil {
+		t.Fatalf("ParseSampling() failed: %s", err)
+	}
+	matrix := Matrix(mpis, mpis, singularities)
+	sample = SampleExperiments(matrix, s)
+	covered := make(map[string]bool)
+	for i := range sample {
+		for _, p := range getPairs(&sample[i]) {
+			covered[p] = true
+		}
+	}
+	for i := range matrix {
+		for _, p := range getPairs(&matrix[i]) {
+			if !covered[p] {
+				t.Fatalf("pair %s not covered by %v", p, sample)
+			}
+		}
+	}
+	if len(sample) >= len(matrix) {
+		t.Fatalf("pairwise sampling selected %d experiments out of %d", len(sample), len(matrix))
+	}
+
+	for _, expr := range []string{"random", "random:0", "random:10:seed", "triangle"} {
+		_, err := ParseSampling(expr)
+		if err == nil {
+			t.Fatalf("ParseSampling() succeeded with the invalid sampling strategy %s", expr)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	// Wednesday, January 1st 2020
+	now := time.Date(2020, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{expr: "0 2 * * *", expected: time.Date(2020, 1, 2, 2, 0, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", expected: time.Date(2020, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{expr: "0,40 10-12 * * *", expected: time.Date(2020, 1, 1, 10, 40, 0, 0, time.UTC)},
+		{expr: "0 0 * * 0", expected: time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 15 * 1", expected: time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{expr: "30 4 1 3 *", expected: time.Date(2020, 3, 1, 4, 30, 0, 0, time.UTC)},
+		{expr: "0 0 31 2 *", expected: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%s) failed: %s", tt.expr, err)
+		}
+		next := s.Next(now)
+		if !next.Equal(tt.expected) {
+			t.Fatalf("next time of '%s' is %s instead of %s", tt.expr, next, tt.expected)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := ParseSchedule(expr)
+		if err == nil {
+			t.Fatalf("ParseSchedule() succeeded with the invalid schedule %s", expr)
+		}
+	}
+}
+
+func TestRegression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-regression-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The experiment 3.1.4-4.0.2 starts failing during the second run
+	runs := 0
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			r := e.NewResult()
+			r.Pass = runs == 0 || e.getName() != "3.1.4-4.0.2"
+			return r
+		},
+	}
+	var notified [][]results.ExperimentSummary
+	s, err := ParseSchedule("0 2 * * *")
+	if err != nil {
+		t.Fatalf("ParseSchedule() failed: %s", err)
+	}
+	mpis := getMPIs("3.1.4", "4.0.2")
+	reg := Regression{
+		Schedule:    s,
+		Experiments: Matrix(mpis, mpis, nil),
+		Ops:         &ops,
+		HistoryFile: filepath.Join(dir, "history.jsonl"),
+		Notify: func(regressions []results.ExperimentSummary) error {
+			notified = append(notified, regressions)
+			return nil
+		},
+	}
+
+	var sysCfg sys.Config
+	for ; runs < 3; runs++ {
+		_, err = reg.RunOnce(&sysCfg)
+		if err != nil {
+			t.Fatalf("run %d failed: %s", runs, err)
+		}
+	}
+
+	// Only the second run has a regression, the third one fails like the previous one
+	if len(notified) != 1 || len(notified[0]) != 1 || notified[0][0].Name != "3.1.4 4.0.2" {
+		t.Fatalf("unexpected notifications: %v", notified)
+	}
+	history, err := results.LoadHistory(reg.HistoryFile)
+	if err != nil || len(history) != 3 || len(history[2].Experiments) != 4 {
+		t.Fatalf("unexpected history: %v, %s", history, err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-hooks-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := "pre_run echo setup $SYMPI_CONTAINER_MPI_VERSION\npre_run on_failure=warn exit 1\npre_run export SYMPI_TEST_HOOK=$SYMPI_CONTAINER_MPI_VERSION; unset SYMPI_TEST_UNSET\npost_run timeout=1 test $SYMPI_CONTAINER_MPI_VERSION != 3.1.4\nopenmpi:4.0.2 openmpi:4.0.2\nopenmpi:4.0.2 openmpi:3.1.4\n"
+	cfgFile := filepath.Join(dir, "experiments.conf")
+	err = ioutil.WriteFile(cfgFile, []byte(cfg), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", cfgFile, err)
+	}
+	exps, err := LoadExperiments(cfgFile)
+	if err != nil || len(exps) != 2 || len(exps[0].PreRun) != 3 || exps[0].PreRun[1].OnFailure != HookWarn || exps[0].PostRun[0].Timeout != 1 {
+		t.Fatalf("LoadExperiments() failed: %v, %s", exps, err)
+	}
+
+	// The post-run hook fails for the experiment using 3.1.4 in the container
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			r := e.NewResult()
+			// The environment set up by the pre-run hooks is the environment of the experiment
+			r.Pass = os.Getenv("SYMPI_TEST_HOOK") == e.ContainerMPI.Version && os.Getenv("SYMPI_TEST_UNSET") == ""
+			return r
+		},
+	}
+	os.Setenv("SYMPI_TEST_UNSET", "1")
+	defer os.Unsetenv("SYMPI_TEST_UNSET")
+	var sysCfg sys.Config
+	sysCfg.BinPath = dir
+	res := Execute(PlanExperiments(exps, nil), &ops, &sysCfg)
+	if len(res) != 2 {
+		t.Fatalf("%d results instead of 2", len(res))
+	}
+	for _, r := range res {
+		failed := r.ContainerMPI.Version == "3.1.4"
+		if r.Pass == failed || failed != (r.ErrorCategory == results.ErrorHook) {
+			t.Fatalf("invalid result: %v", r)
+		}
+		output, err := ioutil.ReadFile(filepath.Join(r.HooksDir, "pre_run-1.stdout.txt"))
+		if err != nil || string(output) != "setup "+r.ContainerMPI.Version+"\n" {
+			t.Fatalf("invalid output of the pre-run hook: %s (%v)", output, err)
+		}
+	}
+	if os.Getenv("SYMPI_TEST_HOOK") != "" || os.Getenv("SYMPI_TEST_UNSET") != "1" {
+		t.Fatalf("the environment of the pre-run hooks was not discarded after the experiments")
+	}
+
+	invalidFile := filepath.Join(dir, "invalid.conf")
+	err = ioutil.WriteFile(invalidFile, []byte("pre_run on_failure=retry ls\npost_run timeout=5\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", invalidFile, err)
+	}
+	_, problems := CheckExperiments(invalidFile)
+	if len(problems) != 2 {
+		t.Fatalf("invalid hooks not reported: %v", problems)
+	}
+}
+
+func TestHooksSandbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-hooks-sandbox-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := "pre_run export SYMPI_TEST_HOOK=$SYMPI_CONTAINER_MPI_VERSION; export PATH=/opt/hook/bin:$PATH\nopenmpi:4.0.2 openmpi:4.0.2\n"
+	cfgFile := filepath.Join(dir, "experiments.conf")
+	err = ioutil.WriteFile(cfgFile, []byte(cfg), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", cfgFile, err)
+	}
+	exps, err := LoadExperiments(cfgFile)
+	if err != nil {
+		t.Fatalf("LoadExperiments() failed: %s", err)
+	}
+
+	// The environment exported by the pre-run hook is set by the tool so the commands of the
+	// experiment get it even if they are executed in a sandboxed environment
+	syexec.SetSandbox(true, nil)
+	defer syexec.SetSandbox(false, nil)
+	var env string
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			r := e.NewResult()
+			var cmd syexec.SyCmd
+			cmd.BinPath = "sh"
+			cmd.CmdArgs = []string{"-c", "echo $SYMPI_TEST_HOOK $PATH"}
+			res := cmd.Run()
+			env = strings.TrimSpace(res.Stdout)
+			r.Pass = res.Err == nil
+			return r
+		},
+	}
+	var sysCfg sys.Config
+	sysCfg.BinPath = dir
+	res := Execute(PlanExperiments(exps, nil), &ops, &sysCfg)
+	if len(res) != 1 || !res[0].Pass {
+		t.Fatalf("invalid results: %v", res)
+	}
+	if env != "4.0.2 /opt/hook/bin:"+syexec.SandboxPath {
+		t.Fatalf("the environment of the pre-run hook is not available in the sandboxed environment: %s", env)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-update-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	resultsFile := filepath.Join(dir, "openmpi-results.txt")
+	err = ioutil.WriteFile(resultsFile, []byte("3.1.4\t3.1.4\tPASS\n3.1.4\t4.0.2\tFAIL\ttimeout\t/errors\n4.0.2\t3.1.4\tPASS\n4.0.2\t4.0.2\tPASS\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", resultsFile, err)
+	}
+
+	// A new version of Open MPI is added: only its row and column are executed
+	var executed []string
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			executed = append(executed, e.getName())
+			r := e.NewResult()
+			r.Pass = e.HostMPI.Version != "3.1.4"
+			return r
+		},
+	}
+	mpis := getMPIs("3.1.4", "4.0.2", "4.1.0")
+	var sysCfg sys.Config
+	report, err := Update(resultsFile, Matrix(mpis, mpis, nil), &ops, &sysCfg)
+	if err != nil {
+		t.Fatalf("Update() failed: %s", err)
+	}
+	if strings.Join(executed, " ") != "3.1.4-4.1.0 4.0.2-4.1.0 4.1.0-3.1.4 4.1.0-4.0.2 4.1.0-4.1.0" || len(report.Executed) != 5 || len(report.Results) != 9 {
+		t.Fatalf("unexpected experiments executed: %v", executed)
+	}
+
+	r, err := results.Load(resultsFile)
+	if err != nil || len(r) != 9 {
+		t.Fatalf("the results were not merged: %v (%v)", r, err)
+	}
+	cells, err := results.LoadCompatibilityMatrix(filepath.Join(dir, "openmpi"+results.CompatibilityMatrixSuffix))
+	if err != nil || len(cells) != 9 || cells[1].ErrorCategory != "timeout" || cells[2].Pass || !cells[8].Pass {
+		t.Fatalf("invalid compatibility matrix: %v (%v)", cells, err)
+	}
+	if report.SummaryFile != results.GetSummaryPath(resultsFile) {
+		t.Fatalf("invalid summary: %s", report.SummaryFile)
+	}
+
+	// Nothing is executed once all the experiments have a result
+	executed = nil
+	_, err = Update(resultsFile, Matrix(mpis, mpis, nil), &ops, &sysCfg)
+	if err != nil || len(executed) != 0 {
+		t.Fatalf("experiments executed again: %v (%v)", executed, err)
+	}
+}
+
+func TestIterations(t *testing.T) {
+	// The experiment using 3.1.4 in the container fails once out of three iterations
+	runs := make(map[string]int)
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			runs[e.getName()]++
+			r := e.NewResult()
+			r.Pass = e.ContainerMPI.Version != "3.1.4" || runs[e.getName()] != 2
+			if !r.Pass {
+				r.ErrorCategory = results.ErrorTimeout
+			}
+			r.Note = fmt.Sprintf("max bandwidth: %d Gbps", 40+runs[e.getName()])
+			return r
+		},
+	}
+	var sysCfg sys.Config
+	sysCfg.Nrun = 3
+	res := Execute(PlanExperiments(Matrix(getMPIs("4.0.2"), getMPIs("3.1.4", "4.0.2"), nil), nil), &ops, &sysCfg)
+	if len(res) != 2 {
+		t.Fatalf("%d results instead of 2", len(res))
+	}
+	for _, r := range res {
+		if runs[r.HostMPI.Version+"-"+r.ContainerMPI.Version] != 3 || len(r.Iterations) != 3 {
+			t.Fatalf("the experiment was not executed 3 times: %v", r)
+		}
+		unstable := r.ContainerMPI.Version == "3.1.4"
+		if r.Pass == unstable || unstable != (r.ErrorCategory == results.ErrorUnstable) {
+			t.Fatalf("invalid result: %v", r)
+		}
+		if !unstable && (len(r.Metrics) != 2 || r.Metrics[0].Mean != 42 || r.Metrics[0].Samples != 3) {
+			t.Fatalf("invalid metrics: %v", r.Metrics)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-limits-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := "openmpi:4.0.2 openmpi:4.0.2\nlimits jobs=2 memory_max=8G\nopenmpi:4.0.2 openmpi:3.1.4\n"
+	cfgFile := filepath.Join(dir, "experiments.conf")
+	err = ioutil.WriteFile(cfgFile, []byte(cfg), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", cfgFile, err)
+	}
+	exps, err := LoadExperiments(cfgFile)
+	if err != nil || len(exps) != 2 || exps[0].Limits.Jobs != 0 || exps[1].Limits.Jobs != 2 {
+		t.Fatalf("LoadExperiments() failed: %v, %s", exps, err)
+	}
+
+	// The limits of the experiment override the global ones while its container is created
+	defer syexec.SetLimits(syexec.SetLimits(syexec.Limits{Jobs: 8, Nice: 10}))
+	built := make(map[string]syexec.Limits)
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			built[mpi.Version] = syexec.GetLimits()
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			r := e.NewResult()
+			r.Pass = true
+			return r
+		},
+	}
+	var sysCfg sys.Config
+	Execute(PlanExperiments(exps, nil), &ops, &sysCfg)
+	if built["4.0.2"].Jobs != 8 || built["3.1.4"].Jobs != 2 || built["3.1.4"].Nice != 10 || built["3.1.4"].MemoryMax != "8G" {
+		t.Fatalf("invalid limits of the builds: %v", built)
+	}
+	if l := syexec.GetLimits(); l.Jobs != 8 || l.MemoryMax != "" {
+		t.Fatalf("the global limits were not restored: %v", l)
+	}
+
+	_, problems := CheckExperiments(cfgFile)
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	err = ioutil.WriteFile(cfgFile, []byte("limits jobs=-1\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", cfgFile, err)
+	}
+	_, problems = CheckExperiments(cfgFile)
+	if len(problems) != 1 {
+		t.Fatalf("invalid limits not reported: %v", problems)
+	}
+}
+
+func TestPresets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-scheduler-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var sysCfg sys.Config
+	sysCfg.EtcDir = dir
+	for _, name := range GetPresetNames(&sysCfg) {
+		exps, err := LoadPreset(name, &sysCfg)
+		if err != nil || len(exps) == 0 {
+			t.Fatalf("failed to load preset %s: %v", name, err)
+		}
+	}
+	_, err = LoadPreset("openmpi-5-series", &sysCfg)
+	if err == nil || !strings.Contains(err.Error(), "openmpi-4-series") {
+		t.Fatalf("loading an unknown preset did not fail with the list of available presets: %v", err)
+	}
+
+	// The presets of the site have precedence over the ones of the tool
+	presetsDir := filepath.Join(dir, presetsDirName)
+	err = os.MkdirAll(presetsDir, 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", presetsDir, err)
+	}
+	for name, content := range map[string]string{
+		"mpich-abi": "mpich:3.3.2 mpich:3.3.2\n",
+		"site":      "openmpi:4.0.2 openmpi:4.0.2\n",
+	} {
+		err = ioutil.WriteFile(filepath.Join(presetsDir, name+presetSuffix), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("failed to create preset %s: %s", name, err)
+		}
+	}
+	exps, err := LoadPreset("mpich-abi", &sysCfg)
+	if err != nil || len(exps) != 1 {
+		t.Fatalf("the preset of the site does not override the one of the tool: %v, %v", exps, err)
+	}
+	if _, err := LoadPreset("site", &sysCfg); err != nil {
+		t.Fatalf("failed to load the preset of the site: %s", err)
+	}
+
+	// The experiments of the cross-distribution preset are executed in containers of their distribution
+	exps, err = LoadPreset("cross-distro-ubuntu-centos", &sysCfg)
+	if err != nil {
+		t.Fatalf("failed to load preset cross-distro-ubuntu-centos: %s", err)
+	}
+	for i := range exps {
+		e, err := parseExperiment(exps[i].String())
+		if err != nil || e.Distro == "" || e.Distro != exps[i].Distro {
+			t.Fatalf("%s is not described with its distribution: %v", exps[i].String(), err)
+		}
+	}
+	_, err = parseExperiment("openmpi:4.0.2 openmpi:4.0.2 distro:centos")
+	if err == nil {
+		t.Fatalf("parseExperiment() succeeded with an invalid Linux distribution")
+	}
+
+	distros := make(map[string]bool)
+	ops := Ops{
+		BuildHost: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			return nil
+		},
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error {
+			distros[sysCfg.TargetDistro] = true
+			return nil
+		},
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			r := e.NewResult()
+			r.Pass = sysCfg.TargetDistro == e.Distro
+			return r
+		},
+	}
+	sysCfg.Persistent = dir
+	res := Execute(PlanExperiments(exps, nil), &ops, &sysCfg)
+	if len(res) != len(exps) || len(distros) != 2 {
+		t.Fatalf("containers of %d distributions built for %d results: %v", len(distros), len(res), distros)
+	}
+	for _, r := range res {
+		if !r.Pass || r.Distro == "" {
+			t.Fatalf("experiment not executed in a container of its distribution: %v", r)
+		}
+	}
+	if len(PlanExperiments(exps, res)) != 0 {
+		t.Fatalf("experiments with results in their distribution are not skipped")
+	}
+}
+
+func TestContainerTuning(t *testing.T) {
+	plan := PlanMatrix(getMPIs("4.0.2", "3.1.4"), getMPIs("4.0.2", "3.1.4"), nil, nil)
+
+	// The image of openmpi 3.1.4 was built with a previous profile, the one of openmpi 4.0.2 has none
+	reads := make(map[string]int)
+	ops := Ops{
+		BuildHost:      func(mpi *implem.Info, sysCfg *sys.Config) error { return nil },
+		BuildContainer: func(mpi *implem.Info, sysCfg *sys.Config) error { return nil },
+		Run: func(e *Experiment, sysCfg *sys.Config) results.Result {
+			return results.Result{HostMPI: e.HostMPI, ContainerMPI: e.ContainerMPI, Pass: true}
+		},
+		ContainerTuning: func(e *Experiment, sysCfg *sys.Config) (string, error) {
+			reads[e.ContainerMPI.Version]++
+			if e.ContainerMPI.Version == "3.1.4" {
+				return "old-ib@sha256:1234", nil
+			}
+			return "", nil
+		},
+	}
+	sysCfg := sys.Config{Persistent: "yes", Tuning: map[string]sys.TuningProfile{implem.OMPI: {MPI: implem.OMPI, Name: "site-ib"}}}
+	res := Execute(plan, &ops, &sysCfg)
+	if len(res) != 4 {
+		t.Fatalf("%d results instead of 4", len(res))
+	}
+	for _, r := range res {
+		expected := "site-ib"
+		if r.ContainerMPI.Version == "3.1.4" {
+			expected = "old-ib,site-ib"
+		}
+		if r.Tuning != expected {
+			t.Fatalf("tuning of %s in a container with %s is '%s' instead of '%s'", r.HostMPI.Version, r.ContainerMPI.Version, r.Tuning, expected)
+		}
+	}
+	if reads["4.0.2"] != 1 || reads["3.1.4"] != 1 {
+		t.Fatalf("the tuning profiles of the images are read %v times instead of once", reads)
+	}
+
+	// Without the labels of the images, only the profile of the host is known
+	ops.ContainerTuning = nil
+	res = Execute(plan, &ops, &sysCfg)
+	if len(res) != 4 || res[0].Tuning != "site-ib" {
+		t.Fatalf("invalid results without the tuning profiles of the images: %v", res)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// UpdateReport describes the result of the incremental update of a set of results
+type UpdateReport struct {
+	// Executed is the list of the experiments that did not have a result and were executed
+	Executed []Experiment
+
+	// Results are all the results, the existing ones followed by the new ones
+	Results []results.Result
+
+	// SummaryFile is the path to the summary of all the results
+	SummaryFile string
+
+	// MatrixFile is the path to the compatibility matrix of all the results, empty when the
+	// experiments do not use a single MPI implementation on the host
+	MatrixFile string
+}
+
+// getMatrixImplem returns the MPI implementation used on the host by all the MPI experiments, the
+// compatibility matrix being for a single implementation; it is empty otherwise
+func getMatrixImplem(exps []Experiment) string {
+	id := ""
+	for _, e := range exps {
+		if e.IsStandalone() {
+			continue
+		}
+		if id != "" && e.HostMPI.ID != id {
+			return ""
+		}
+		id = e.HostMPI.ID
+	}
+	return id
+}
+
+// PlanUpdate returns the plan executing the experiments of a list that do not have a result in a
+// result file yet, e.g., the experiments of a new version of MPI added to the configuration file of
+// the experiments; it can be displayed with FormatPlan before executing anything
+func PlanUpdate(resultsFile string, exps []Experiment) ([]Group, []results.Result, error) {
+	done, err := results.Load(resultsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %s: %s", resultsFile, err)
+	}
+	return PlanExperiments(exps, done), done, nil
+}
+
+// Update incrementally updates a set of results: only the experiments that do not have a result in
+// the result file are executed and their results appended to the file. The report of all the
+// results is then regenerated: the summary next to the result file and, when the experiments use a
+// single MPI implementation on the host, its compatibility matrix in the same directory, e.g.,
+// openmpi_compatibility_matrix.txt.
+func Update(resultsFile string, exps []Experiment, ops *Ops, sysCfg *sys.Config) (*UpdateReport, error) {
+	plan, done, err := PlanUpdate(resultsFile, exps)
+	if err != nil {
+		return nil, err
+	}
+
+	report := new(UpdateReport)
+	for _, g := range plan {
+		report.Executed = append(report.Executed, g.Experiments...)
+	}
+	log.Printf("* %d experiment(s) already have a result in %s, %d missing\n", len(done), resultsFile, len(report.Executed))
+	report.Results = done
+	if len(plan) > 0 {
+		res := Execute(plan, ops, sysCfg)
+		err = results.Append(resultsFile, res...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save the new results in %s: %s", resultsFile, err)
+		}
+		report.Results = append(report.Results, res...)
+	}
+
+	report.SummaryFile, err = results.SaveSummary(resultsFile, report.Results)
+	if err != nil {
+		return report, err
+	}
+	if id := getMatrixImplem(exps); id != "" {
+		report.MatrixFile = filepath.Join(filepath.Dir(resultsFile), id+results.CompatibilityMatrixSuffix)
+		err = results.SaveCompatibilityMatrix(report.MatrixFile, report.Results)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+/*
+ * sif is a package that reads the metadata of SIF images (Singularity Image Format) directly from
+ * the image file, i.e., the global header and the descriptors of the data objects, such as the
+ * partitions and the labels, so images can be queried without executing singularity.
+ */
+package sif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// launchLen is the size of the launch script at the beginning of the image
+	launchLen = 32
+
+	// magic identifies SIF images, right after the launch script
+	magic = "SIF_MAGIC"
+
+	// groupMask is the mask of the group identifiers of the descriptors
+	groupMask = 0xf0000000
+)
+
+// Types of the data objects of an image
+const (
+	DataDeffile       int32 = 0x4001
+	DataEnvVar        int32 = 0x4002
+	DataLabels        int32 = 0x4003
+	DataPartition     int32 = 0x4004
+	DataSignature     int32 = 0x4005
+	DataGenericJSON   int32 = 0x4006
+	DataGeneric       int32 = 0x4007
+	DataCryptoMessage int32 = 0x4008
+)
+
+var fsTypes = map[int32]string{1: "Squashfs", 2: "Ext3", 3: "Archive", 4: "Raw", 5: "Encrypted squashfs"}
+
+var partTypes = map[int32]string{1: "System", 2: "*System", 3: "Data", 4: "Overlay"}
+
+// archs maps the architectures of SIF to the names used by Go, e.g., amd64
+var archs = map[string]string{
+	"01": "386", "02": "amd64", "03": "arm", "04": "arm64", "05": "ppc64", "06": "ppc64le",
+	"07": "mips", "08": "mipsle", "09": "mips64", "10": "mips64le", "11": "s390x",
+}
+
+// header is the global header of an image, as stored in the file
+type header struct {
+	Launch   [launchLen]byte
+	Magic    [10]byte
+	Version  [3]byte
+	Arch     [3]byte
+	ID       [16]byte
+	Ctime    int64
+	Mtime    int64
+	Dfree    int64
+	Dtotal   int64
+	Descroff int64
+	Descrlen int64
+	Dataoff  int64
+	Datalen  int64
+}
+
+// descriptor is the descriptor of a data object, as stored in the file
+type descriptor struct {
+	Datatype int32
+	Used     bool
+	ID       uint32
+	Groupid  uint32
+	Link     uint32
+	Fileoff  int64
+	Filelen  int64
+	Storelen int64
+	Ctime    int64
+	Mtime    int64
+	UID      int64
+	GID      int64
+	Name     [128]byte
+	Extra    [384]byte
+}
+
+// partitionExtra is the content of the extra field of the descriptor of a partition
+type partitionExtra struct {
+	Fstype   int32
+	Parttype int32
+	Arch     [3]byte
+}
+
+// Descriptor describes a data object of an image
+type Descriptor struct {
+	// Type is the type of the object, e.g., DataPartition
+	Type int32
+
+	// ID is the identifier of the object
+	ID uint32
+
+	// Group is the group of the object, 0 when the object is not part of a group
+	Group uint32
+
+	// Name is the name of the object, e.g., the name of a file
+	Name string
+
+	// Offset is the offset of the data of the object in the image
+	Offset int64
+
+	// Size is the size of the data of the object
+	Size int64
+
+	extra [384]byte
+}
+
+// Partition describes a partition of an image
+type Partition struct {
+	// ID is the identifier of the object of the partition
+	ID uint32
+
+	// FSType is the type of file system of the partition, e.g., Squashfs
+	FSType string
+
+	// Type is the type of the partition, e.g., '*System' for the primary system partition
+	Type string
+
+	// Arch is the hardware architecture of the partition, e.g., amd64
+	Arch string
+}
+
+// Image gives access to the metadata of a SIF image
+type Image struct {
+	// Path is the path to the image
+	Path string
+
+	// ID is the unique identifier of the image
+	ID string
+
+	// Arch is the hardware architecture of the image, e.g., amd64
+	Arch string
+
+	// Descriptors are the descriptors of all the data objects of the image
+	Descriptors []Descriptor
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func getArch(code [3]byte) string {
+	c := cString(code[:])
+	if arch, ok := archs[c]; ok {
+		return arch
+	}
+	return c
+}
+
+// checkRange checks that a range of bytes read from an image, e.g., the data of an object, is
+// within the image, whose size is fileSize
+func checkRange(off int64, length int64, fileSize int64) error {
+	if off < 0 || length < 0 || off > fileSize || length > fileSize-off {
+		return fmt.Errorf("range of %d bytes at offset %d is outside of the image (%d bytes)", length, off, fileSize)
+	}
+	return nil
+}
+
+// IsSIF checks whether a file is a SIF image
+func IsSIF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	var h header
+	err = binary.Read(f, binary.LittleEndian, &h)
+	return err == nil && cString(h.Magic[:]) == magic
+}
+
+// Load reads the global header and the descriptors of an image
+func Load(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	var h header
+	err = binary.Read(f, binary.LittleEndian, &h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header of %s: %s", path, err)
+	}
+	if cString(h.Magic[:]) != magic {
+		return nil, fmt.Errorf("%s is not a SIF image", path)
+	}
+
+	// The counts and offsets read from the file are checked before being used, a corrupted or
+	// malicious image could otherwise make the tool allocate or read arbitrary amounts of memory
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %s", path, err)
+	}
+	descrSize := int64(binary.Size(descriptor{}))
+	if h.Dtotal < 0 || h.Dtotal > fi.Size()/descrSize {
+		return nil, fmt.Errorf("invalid number of descriptors in %s: %d", path, h.Dtotal)
+	}
+	err = checkRange(h.Descroff, h.Dtotal*descrSize, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptors in %s: %s", path, err)
+	}
+
+	img := &Image{Path: path, ID: hex.EncodeToString(h.ID[:]), Arch: getArch(h.Arch)}
+	_, err = f.Seek(h.Descroff, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the descriptors of %s: %s", path, err)
+	}
+	for i := int64(0); i < h.Dtotal; i++ {
+		var d descriptor
+		err = binary.Read(f, binary.LittleEndian, &d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the descriptors of %s: %s", path, err)
+		}
+		if !d.Used {
+			continue
+		}
+		err = checkRange(d.Fileoff, d.Filelen, fi.Size())
+		if err != nil {
+			return nil, fmt.Errorf("invalid object %d in %s: %s", d.ID, path, err)
+		}
+		group := d.Groupid &^ groupMask
+		img.Descriptors = append(img.Descriptors, Descriptor{Type: d.Datatype, ID: d.ID, Group: group, Name: cString(d.Name[:]), Offset: d.Fileoff, Size: d.Filelen, extra: d.Extra})
+	}
+
+	return img, nil
+}
+
+// ReadData returns the data of an object of the image
+func (img *Image) ReadData(d *Descriptor) ([]byte, error) {
+	f, err := os.Open(img.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", img.Path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %s", img.Path, err)
+	}
+	err = checkRange(d.Offset, d.Size, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("invalid object %d in %s: %s", d.ID, img.Path, err)
+	}
+	data := make([]byte, d.Size)
+	_, err = f.ReadAt(data, d.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %d of %s: %s", d.ID, img.Path, err)
+	}
+	return data, nil
+}
+
+// Partitions returns the partitions of the image
+func (img *Image) Partitions() []Partition {
+	var parts []Partition
+	for _, d := range img.Descriptors {
+		if d.Type != DataPartition {
+			continue
+		}
+		var extra partitionExtra
+		err := binary.Read(bytes.NewReader(d.extra[:]), binary.LittleEndian, &extra)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, Partition{ID: d.ID, FSType: fsTypes[extra.Fstype], Type: partTypes[extra.Parttype], Arch: getArch(extra.Arch)})
+	}
+	return parts
+}
+
+// Archs returns the hardware architectures of the system partitions of the image
+func (img *Image) Archs() []string {
+	var list []string
+	for _, p := range img.Partitions() {
+		if p.Type == "System" || p.Type == "*System" {
+			list = append(list, p.Arch)
+		}
+	}
+	return list
+}
+
+// getJSONLabels returns the labels stored in JSON metadata, either directly as a map of labels
+// or in the metadata of the image generated by Singularity, i.e., data.attributes.labels
+func getJSONLabels(data []byte) map[string]string {
+	var metadata struct {
+		Labels map[string]string `json:"labels"`
+		Data   struct {
+			Attributes struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(data, &metadata) == nil {
+		if len(metadata.Data.Attributes.Labels) > 0 {
+			return metadata.Data.Attributes.Labels
+		}
+		if len(metadata.Labels) > 0 {
+			return metadata.Labels
+		}
+	}
+
+	var labels map[string]string
+	if json.Unmarshal(data, &labels) == nil {
+		return labels
+	}
+	return nil
+}
+
+// Labels returns the labels of the image stored in its labels or JSON objects; the labels are
+// nil when the image does not store them outside of its file system
+func (img *Image) Labels() (map[string]string, error) {
+	var labels map[string]string
+	for i := range img.Descriptors {
+		d := &img.Descriptors[i]
+		if d.Type != DataLabels && d.Type != DataGenericJSON {
+			continue
+		}
+		data, err := img.ReadData(d)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range getJSONLabels(data) {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[k] = v
+		}
+	}
+	return labels, nil
+}
+
+// FormatLabels returns the labels in the format of 'singularity inspect', i.e., one 'key: value'
+// per line, sorted by key
+func FormatLabels(labels map[string]string) string {
+	var lines []string
+	for k, v := range labels {
+		lines = append(lines, k+": "+v)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
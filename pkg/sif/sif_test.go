@@ -0,0 +1,153 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeImage writes a minimal SIF image with a primary system partition and JSON metadata
+func writeImage(t *testing.T, path string, metadata string) {
+	var h header
+	copy(h.Launch[:], "#!/usr/bin/env run-singularity\n")
+	copy(h.Magic[:], magic)
+	copy(h.Version[:], "01")
+	copy(h.Arch[:], "02")
+	h.Dtotal = 2
+	h.Descroff = int64(binary.Size(h))
+	h.Descrlen = 2 * int64(binary.Size(descriptor{}))
+	h.Dataoff = h.Descroff + h.Descrlen
+
+	part := descriptor{Datatype: DataPartition, Used: true, ID: 1, Groupid: groupMask | 1, Fileoff: h.Dataoff, Filelen: 4}
+	var extra bytes.Buffer
+	err := binary.Write(&extra, binary.LittleEndian, partitionExtra{Fstype: 1, Parttype: 2, Arch: [3]byte{'0', '2', 0}})
+	if err != nil {
+		t.Fatalf("failed to encode the partition: %s", err)
+	}
+	copy(part.Extra[:], extra.Bytes())
+	json := descriptor{Datatype: DataGenericJSON, Used: true, ID: 2, Groupid: groupMask | 1, Fileoff: h.Dataoff + 4, Filelen: int64(len(metadata))}
+	copy(json.Name[:], "inspect-metadata.json")
+
+	var buf bytes.Buffer
+	for _, v := range []interface{}{h, part, json} {
+		err = binary.Write(&buf, binary.LittleEndian, v)
+		if err != nil {
+			t.Fatalf("failed to encode the image: %s", err)
+		}
+	}
+	buf.WriteString("hsqs" + metadata)
+	err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", path, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgPath := filepath.Join(dir, "test.sif")
+	writeImage(t, imgPath, `{"data":{"attributes":{"labels":{"MPI_Implementation":"openmpi","MPI_Version":"4.0.2"}}}}`)
+	notSIF := filepath.Join(dir, "test.txt")
+	err = ioutil.WriteFile(notSIF, []byte("not an image"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", notSIF, err)
+	}
+
+	if !IsSIF(imgPath) || IsSIF(notSIF) {
+		t.Fatalf("failed to detect SIF images")
+	}
+	_, err = Load(notSIF)
+	if err == nil {
+		t.Fatalf("loading %s succeeded", notSIF)
+	}
+
+	img, err := Load(imgPath)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", imgPath, err)
+	}
+	if img.Arch != "amd64" || len(img.Descriptors) != 2 {
+		t.Fatalf("invalid image: %v", img)
+	}
+	parts := img.Partitions()
+	if len(parts) != 1 || parts[0].FSType != "Squashfs" || parts[0].Type != "*System" {
+		t.Fatalf("invalid partitions: %v", parts)
+	}
+	archs := img.Archs()
+	if len(archs) != 1 || archs[0] != "amd64" {
+		t.Fatalf("invalid architectures: %v", archs)
+	}
+
+	labels, err := img.Labels()
+	if err != nil {
+		t.Fatalf("failed to read the labels of %s: %s", imgPath, err)
+	}
+	expected := "MPI_Implementation: openmpi\nMPI_Version: 4.0.2"
+	if FormatLabels(labels) != expected {
+		t.Fatalf("invalid labels: %s", FormatLabels(labels))
+	}
+}
+
+func TestLoadCorrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgPath := filepath.Join(dir, "test.sif")
+	writeImage(t, imgPath, `{"labels":{"MPI_Version":"4.0.2"}}`)
+	content, err := ioutil.ReadFile(imgPath)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", imgPath, err)
+	}
+
+	// Offsets of the number of descriptors in the header and of the size of the data of the
+	// second object in its descriptor
+	dtotalOff := launchLen + 10 + 3 + 3 + 16 + 3*8
+	filelenOff := binary.Size(header{}) + binary.Size(descriptor{}) + 4 + 1 + 3*4 + 8
+	tests := []struct {
+		name  string
+		off   int
+		value int64
+	}{
+		{name: "huge number of descriptors", off: dtotalOff, value: 1 << 40},
+		{name: "negative number of descriptors", off: dtotalOff, value: -1},
+		{name: "huge object", off: filelenOff, value: 1 << 40},
+		{name: "negative object size", off: filelenOff, value: -4},
+	}
+	for _, tt := range tests {
+		corrupted := append([]byte{}, content...)
+		binary.LittleEndian.PutUint64(corrupted[tt.off:], uint64(tt.value))
+		path := filepath.Join(dir, "corrupted.sif")
+		err = ioutil.WriteFile(path, corrupted, 0644)
+		if err != nil {
+			t.Fatalf("failed to write %s: %s", path, err)
+		}
+		_, err = Load(path)
+		if err == nil {
+			t.Fatalf("%s: loading a corrupted image succeeded", tt.name)
+		}
+	}
+
+	// Descriptors created by the caller are checked as well
+	img, err := Load(imgPath)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", imgPath, err)
+	}
+	_, err = img.ReadData(&Descriptor{ID: 3, Offset: 0, Size: 1 << 40})
+	if err == nil {
+		t.Fatalf("reading an object larger than the image succeeded")
+	}
+}
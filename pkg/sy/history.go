@@ -0,0 +1,174 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+)
+
+const (
+	// configHistorySuffix is the suffix of the journal of the changes of a configuration file,
+	// which is next to the file, e.g., singularity-mpi.conf.history
+	configHistorySuffix = ".history"
+)
+
+// ConfigChange is an entry of the journal of the changes of the tool's configuration file
+type ConfigChange struct {
+	// ID is the number of the change, starting at 1
+	ID int `json:"id"`
+
+	// Time is when the change was made
+	Time time.Time `json:"time"`
+
+	// User and Host identify who made the change
+	User string `json:"user"`
+	Host string `json:"host"`
+
+	// Command is the command line that made the change
+	Command string `json:"command"`
+
+	// Key is the key that changed, empty when the entire configuration was reverted
+	Key string `json:"key,omitempty"`
+
+	// Previous and Value are the values of the key before and after the change
+	Previous string `json:"previous,omitempty"`
+	Value    string `json:"value,omitempty"`
+
+	// RevertedTo is the change whose previous configuration was restored, 0 for other changes
+	RevertedTo int `json:"reverted_to,omitempty"`
+
+	// Snapshot is the content of the configuration file before the change
+	Snapshot string `json:"snapshot"`
+}
+
+// GetConfigHistoryPath returns the path to the journal of the changes of a configuration file
+func GetConfigHistoryPath(configFile string) string {
+	return configparser.GetConfigFilePath(configFile) + configHistorySuffix
+}
+
+// LoadConfigHistory returns the changes of a configuration file, the oldest first; there is no
+// change when the journal does not exist
+func LoadConfigHistory(configFile string) ([]ConfigChange, error) {
+	path := GetConfigHistoryPath(configFile)
+	if !util.FileExists(path) {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	var changes []ConfigChange
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var c ConfigChange
+		err = json.Unmarshal([]byte(line), &c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid change in %s: %s", path, err)
+		}
+		changes = append(changes, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	return changes, nil
+}
+
+// recordConfigChange appends a change of a configuration file to its journal, with the user, the
+// host and the command line that made it
+func recordConfigChange(configFile string, c ConfigChange) error {
+	changes, err := LoadConfigHistory(configFile)
+	if err != nil {
+		return err
+	}
+	c.ID = len(changes) + 1
+	c.Time = time.Now()
+	c.Command = strings.Join(os.Args, " ")
+	c.User = os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		c.User = u.Username
+	}
+	c.Host, _ = os.Hostname()
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode the change: %s", err)
+	}
+	path := GetConfigHistoryPath(configFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %s", path, err)
+	}
+	return nil
+}
+
+// readSnapshot returns the content of a configuration file, empty if it does not exist
+func readSnapshot(configFile string) (string, error) {
+	path := configparser.GetConfigFilePath(configFile)
+	if !util.FileExists(path) {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	return string(data), nil
+}
+
+// RevertConfigFile restores a configuration file as it was before one of its changes, i.e., the
+// change and all the following ones are undone. The revert is itself recorded in the journal so
+// it can be reverted as well.
+func RevertConfigFile(configFile string, id int) error {
+	changes, err := LoadConfigHistory(configFile)
+	if err != nil {
+		return err
+	}
+	if id < 1 || id > len(changes) {
+		return fmt.Errorf("invalid change %d, the history of %s has %d change(s)", id, configFile, len(changes))
+	}
+
+	snapshot, err := readSnapshot(configFile)
+	if err != nil {
+		return err
+	}
+	path := configparser.GetConfigFilePath(configFile)
+	err = ioutil.WriteFile(path, []byte(changes[id-1].Snapshot), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return recordConfigChange(configFile, ConfigChange{RevertedTo: id, Snapshot: snapshot})
+}
+
+// String returns a one-line description of a change, e.g.,
+// '#2 2020-01-02 15:04:05 jdoe@node1: ifnet: eth0 -> ib0 (sympi -config)'
+func (c *ConfigChange) String() string {
+	what := fmt.Sprintf("%s: '%s' -> '%s'", c.Key, c.Previous, c.Value)
+	if c.RevertedTo > 0 {
+		what = fmt.Sprintf("reverted to the configuration before change #%d", c.RevertedTo)
+	}
+	return fmt.Sprintf("#%d %s %s@%s: %s (%s)", c.ID, c.Time.Format("2006-01-02 15:04:05"), c.User, c.Host, what, c.Command)
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
+	"github.com/sylabs/singularity-mpi/pkg/checker"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// SudoBuild, SudoPull, SudoExec, SudoSign and SudoPush are the operations for which a sudo
+	// policy can be specified. SudoExec covers the commands using an image, e.g., inspect or
+	// instance, and SudoSign the commands using the keyring, e.g., verify.
+	SudoBuild = "build"
+	SudoPull  = "pull"
+	SudoExec  = "exec"
+	SudoSign  = "sign"
+	SudoPush  = "push"
+
+	// SudoAlways is the sudo policy of the operations always executed with sudo
+	SudoAlways = "always"
+
+	// SudoNever is the sudo policy of the operations never executed with sudo
+	SudoNever = "never"
+
+	// SudoAuto is the sudo policy of the operations executed with sudo based on the capabilities
+	// of the system, e.g., builds when sudo can be used without a password (default)
+	SudoAuto = "auto"
+
+	// SudoKeyPrefix is the prefix of the keys used to specify the sudo policy of an operation,
+	// e.g., sudo_build = always
+	SudoKeyPrefix = "sudo_"
+)
+
+// SudoOperations are the operations for which a sudo policy can be specified
+var SudoOperations = []string{SudoBuild, SudoPull, SudoExec, SudoSign, SudoPush}
+
+// noSudoWarnings are the operations for which the lack of sudo was already reported
+var noSudoWarnings struct {
+	sync.Mutex
+	ops map[string]bool
+}
+
+// sudoProbes caches, for each path to sudo, whether sudo can be used without a password
+var sudoProbes struct {
+	sync.Mutex
+	usable map[string]bool
+}
+
+// sudoCmdOperations are the operations of the other Singularity commands executed by the tool,
+// e.g., verify uses the keyring like sign
+var sudoCmdOperations = map[string]string{
+	"inspect":  SudoExec,
+	"instance": SudoExec,
+	"run":      SudoExec,
+	"shell":    SudoExec,
+	"test":     SudoExec,
+	"verify":   SudoSign,
+	"key":      SudoSign,
+	"delete":   SudoPush,
+}
+
+// GetSudoKey returns the key used to specify the sudo policy of an operation
+func GetSudoKey(op string) string {
+	return SudoKeyPrefix + op
+}
+
+// GetSudoOperation returns the operation of a Singularity command, e.g., exec for inspect
+func GetSudoOperation(cmd string) string {
+	if op, ok := sudoCmdOperations[cmd]; ok {
+		return op
+	}
+	return cmd
+}
+
+// ValidateSudoPolicy checks whether a sudo policy is valid
+func ValidateSudoPolicy(policy string) error {
+	switch policy {
+	case SudoAlways, SudoNever, SudoAuto:
+		return nil
+	}
+	return fmt.Errorf("invalid sudo policy %s, it should be %s, %s or %s", policy, SudoAlways, SudoNever, SudoAuto)
+}
+
+// LoadSudoPolicies loads the sudo policies of the operations from the tool's configuration file.
+// The operations listed by the legacy singularity_sudo_cmds key are always executed with sudo,
+// unless their policy is explicitly specified.
+func LoadSudoPolicies(kvs []kv.KV) (map[string]string, error) {
+	policies := make(map[string]string)
+	for _, cmd := range strings.Fields(kv.GetValue(kvs, SudoCmdsKey)) {
+		policies[GetSudoOperation(cmd)] = SudoAlways
+	}
+	for _, op := range SudoOperations {
+		val := kv.GetValue(kvs, GetSudoKey(op))
+		if val == "" {
+			continue
+		}
+		err := ValidateSudoPolicy(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %s", GetSudoKey(op), err)
+		}
+		policies[op] = val
+	}
+	return policies, nil
+}
+
+// SudoAvailable checks whether sudo is available on the host, see launcher.Load
+func SudoAvailable(sysCfg *sys.Config) bool {
+	return sysCfg.SudoBin != ""
+}
+
+// warnNoSudo logs, once per operation, that an operation is executed without sudo because sudo
+// is not available
+func warnNoSudo(op string) {
+	noSudoWarnings.Lock()
+	defer noSudoWarnings.Unlock()
+	if noSudoWarnings.ops == nil {
+		noSudoWarnings.ops = make(map[string]bool)
+	}
+	if !noSudoWarnings.ops[op] {
+		noSudoWarnings.ops[op] = true
+		log.Printf("[WARN] %s is set to %s but sudo is not available, executing the %s operations without sudo", GetSudoKey(op), SudoAlways, op)
+	}
+}
+
+// UseFakeroot checks whether images must be built with --fakeroot: when Singularity is installed
+// without the setuid bit, or when builds require privileges but cannot be executed with sudo. In
+// the latter case, an error of class sympierr.ErrNoPrivilege is returned when the user cannot
+// build images with --fakeroot either.
+func UseFakeroot(sysCfg *sys.Config) (bool, error) {
+	if sysCfg.Nopriv {
+		return true, nil
+	}
+	if os.Geteuid() == 0 || sysCfg.SudoPolicies[SudoBuild] == SudoNever || UseSudo(SudoBuild, sysCfg) {
+		return false, nil
+	}
+	err := checker.CheckFakeroot()
+	if err != nil {
+		return false, sympierr.Wrap(sympierr.ErrNoPrivilege, err, "images cannot be built with sudo nor with --fakeroot, use 'sympi -remote' to build them on another host")
+	}
+	return true, nil
+}
+
+// canSudo checks whether sudo can be used without a password, i.e., without prompting the user
+// in the middle of a build, by executing 'sudo -n true'; the result is cached
+func canSudo(sudoBin string) bool {
+	sudoProbes.Lock()
+	defer sudoProbes.Unlock()
+	if usable, ok := sudoProbes.usable[sudoBin]; ok {
+		return usable
+	}
+	if sudoProbes.usable == nil {
+		sudoProbes.usable = make(map[string]bool)
+	}
+	err := syexec.RunCmd(exec.Command(sudoBin, "-n", "true"))
+	if err != nil {
+		log.Printf("[INFO] %s cannot be used without a password, images are built with --fakeroot; set %s = %s to build them with sudo anyway", sudoBin, GetSudoKey(SudoBuild), SudoAlways)
+	}
+	sudoProbes.usable[sudoBin] = err == nil
+	return err == nil
+}
+
+// probeSudo returns whether an operation needs sudo based on the capabilities of the system: only
+// builds do, when the tool does not already run as root and sudo can be used without a password;
+// builds rely on --fakeroot otherwise
+func probeSudo(op string, sysCfg *sys.Config) bool {
+	if op != SudoBuild || sysCfg.Nopriv || sysCfg.SudoBin == "" || os.Geteuid() == 0 {
+		return false
+	}
+	return canSudo(sysCfg.SudoBin)
+}
+
+// UseSudo checks whether an operation, e.g., SudoBuild, needs to be executed with sudo based on
+// its policy. Nothing is executed with sudo when Singularity is installed without the setuid bit
+// or when sudo is not available, in which case a warning is logged for the operations that should
+// always be executed with sudo.
+func UseSudo(op string, sysCfg *sys.Config) bool {
+	if sysCfg.Nopriv {
+		return false
+	}
+	policy := sysCfg.SudoPolicies[op]
+	if policy == "" && isLegacySudoOperation(op, sysCfg) {
+		policy = SudoAlways
+	}
+	switch policy {
+	case SudoAlways:
+		if !SudoAvailable(sysCfg) {
+			warnNoSudo(op)
+			return false
+		}
+		return true
+	case SudoNever:
+		return false
+	}
+	return probeSudo(op, sysCfg)
+}
+
+// getDefaultSudoEntries returns the entries of the tool's configuration file specifying the sudo
+// policies of the operations based on the capabilities of the system: builds are executed with
+// sudo when checker.CheckBuildPrivilege succeeded, i.e., buildPrivilege is set, and are left to
+// the probe of the system otherwise
+func getDefaultSudoEntries(buildPrivilege bool) []string {
+	var entries []string
+	for _, op := range SudoOperations {
+		policy := SudoNever
+		if op == SudoBuild {
+			policy = SudoAuto
+			if buildPrivilege && os.Geteuid() != 0 {
+				policy = SudoAlways
+			}
+		}
+		entries = append(entries, GetSudoKey(op)+" = "+policy)
+	}
+	return entries
+}
+
+// GetPreserveEnvArgs returns the arguments of sudo preserving environment variables, e.g.,
+// https_proxy, which sudo otherwise resets; empty when there is no variable to preserve
+func GetPreserveEnvArgs(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	return []string{"--preserve-env=" + strings.Join(names, ",")}
+}
+
+// isLegacySudoOperation checks whether an operation is in the legacy list of the Singularity
+// commands executed with sudo, sys.Config.SudoSyCmds
+func isLegacySudoOperation(op string, sysCfg *sys.Config) bool {
+	for _, cmd := range sysCfg.SudoSyCmds {
+		if GetSudoOperation(cmd) == op {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSudoCmd checks whether a Singularity command, e.g., build or verify, needs to be executed
+// with sudo.
+//
+// Deprecated: use UseSudo with the operation of the command, see GetSudoOperation.
+func IsSudoCmd(cmd string, sysCfg *sys.Config) bool {
+	return UseSudo(GetSudoOperation(cmd), sysCfg)
+}
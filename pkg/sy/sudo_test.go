@@ -0,0 +1,100 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sy
+
+import (
+	"os"
+	"testing"
+
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestUseSudo(t *testing.T) {
+	// sudo can be used without a password
+	fake := syexec.NewFakeRunner()
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+	probedBuild := os.Geteuid() != 0
+	tests := []struct {
+		name   string
+		kvs    []kv.KV
+		nopriv bool
+		nosudo bool
+		sudo   map[string]bool
+	}{
+		{
+			name: "defaults",
+			sudo: map[string]bool{SudoBuild: probedBuild, SudoPull: false, SudoExec: false, SudoSign: false, SudoPush: false},
+		},
+		{
+			name: "legacy list",
+			kvs:  []kv.KV{{Key: SudoCmdsKey, Value: "build verify"}},
+			sudo: map[string]bool{SudoBuild: true, SudoSign: true, GetSudoOperation("verify"): true, SudoPush: false},
+		},
+		{
+			name: "policies override the legacy list",
+			kvs:  []kv.KV{{Key: SudoCmdsKey, Value: "build push"}, {Key: "sudo_push", Value: SudoNever}, {Key: "sudo_pull", Value: SudoAlways}, {Key: "sudo_build", Value: SudoAuto}},
+			sudo: map[string]bool{SudoBuild: probedBuild, SudoPull: true, SudoPush: false, GetSudoOperation("inspect"): false},
+		},
+		{
+			name:   "without setuid",
+			kvs:    []kv.KV{{Key: "sudo_build", Value: SudoAlways}},
+			nopriv: true,
+			sudo:   map[string]bool{SudoBuild: false},
+		},
+		{
+			name:   "sudo not available",
+			kvs:    []kv.KV{{Key: "sudo_build", Value: SudoAlways}, {Key: "sudo_pull", Value: SudoAlways}},
+			nosudo: true,
+			sudo:   map[string]bool{SudoBuild: false, SudoPull: false},
+		},
+	}
+
+	for _, tt := range tests {
+		policies, err := LoadSudoPolicies(tt.kvs)
+		if err != nil {
+			t.Fatalf("%s: failed to load the sudo policies: %s", tt.name, err)
+		}
+		sysCfg := sys.Config{SudoBin: "/usr/bin/sudo", SudoPolicies: policies, Nopriv: tt.nopriv}
+		if tt.nosudo {
+			sysCfg.SudoBin = ""
+		}
+		for op, expected := range tt.sudo {
+			if UseSudo(op, &sysCfg) != expected {
+				t.Fatalf("%s: sudo for %s: %v, expected: %v", tt.name, op, !expected, expected)
+			}
+		}
+	}
+
+	_, err := LoadSudoPolicies([]kv.KV{{Key: "sudo_exec", Value: "sometimes"}})
+	if err == nil {
+		t.Fatalf("an invalid sudo policy was accepted")
+	}
+
+	// The deprecated list of commands is still honored
+	sysCfg := sys.Config{SudoBin: "/usr/bin/sudo", SudoSyCmds: []string{"verify"}}
+	if !IsSudoCmd("verify", &sysCfg) || !IsSudoCmd("key", &sysCfg) || IsSudoCmd("pull", &sysCfg) {
+		t.Fatalf("the legacy list of commands executed with sudo is not honored")
+	}
+}
+
+func TestCanSudo(t *testing.T) {
+	fake := syexec.NewFakeRunner()
+	fake.On("sudo-password -n true", syexec.FakeResult{ExitCode: 1})
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	if !canSudo("/usr/bin/sudo") {
+		t.Fatalf("sudo without password reported as not usable")
+	}
+	if canSudo("/usr/bin/sudo-password") {
+		t.Fatalf("sudo requiring a password reported as usable")
+	}
+	canSudo("/usr/bin/sudo")
+	if len(fake.CmdLines()) != 2 {
+		t.Fatalf("sudo probed several times: %v", fake.CmdLines())
+	}
+}
@@ -20,10 +20,13 @@ import (
 
 	"github.com/gvallee/go_util/pkg/util"
 	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/checker"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/sif"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
@@ -44,9 +47,130 @@ const (
 	// NoPrivKey is the key used to specify whether Singularity should be executed without any privilege
 	NoPrivKey = "force_unprivileged"
 
-	// SudoCmdsKey is the key used to specify which Singularity commands need to be executed with sudo
+	// SudoCmdsKey is the legacy key used to specify which Singularity commands need to be executed
+	// with sudo, superseded by the sudo policies of the operations, e.g., sudo_build
 	SudoCmdsKey = "singularity_sudo_cmds"
 
+	// SignKeyFingerprintKey is the key used to specify the fingerprint of the key to use to sign images
+	SignKeyFingerprintKey = "sign_key_fingerprint"
+
+	// VerifyPolicyKey is the key used to specify what to do when the signature of an image cannot be
+	// verified before running it (require-signed, warn or ignore)
+	VerifyPolicyKey = "verify_policy"
+
+	// ISAPolicyKey is the key used to specify what to do when the CPUs of the host lack features
+	// required by a container (refuse, warn or ignore)
+	ISAPolicyKey = "isa_policy"
+
+	// ToolchainKey is the key used to specify the toolchain building MPI and the applications on
+	// the host and in the containers, e.g., nvhpc
+	ToolchainKey = "toolchain"
+
+	// ToolchainDirKey is the key used to specify the directory where the toolchain is installed
+	ToolchainDirKey = "toolchain_dir"
+
+	// BuildProfileKeyPrefix is the prefix of the keys describing the named build profiles, e.g.,
+	// build_profile.nvidia.toolchain = nvhpc (see LoadBuildProfiles)
+	BuildProfileKeyPrefix = "build_profile."
+
+	// BuildProfileKey is the key used to specify the build profile selected by default, e.g.,
+	// build_profile = nvidia
+	BuildProfileKey = "build_profile"
+
+	// DownloadRateLimitKey is the key used to specify the maximum bandwidth used to download
+	// software, e.g., 10m
+	DownloadRateLimitKey = "download_rate_limit"
+
+	// ProxyKey is the key used to specify the proxy of all the network operations, e.g.,
+	// http://proxy.example.com:3128
+	ProxyKey = "proxy"
+
+	// NoProxyKey is the key used to specify the comma-separated list of the hosts reached without
+	// proxy, e.g., localhost,.example.com
+	NoProxyKey = "no_proxy"
+
+	// CAFileKey is the key used to specify a bundle of PEM certificates of extra CAs trusted by the
+	// network operations, e.g., /etc/pki/corporate-ca.pem
+	CAFileKey = "ca_file"
+
+	// ContainerRuntimeKey is the key used to specify the container runtime to use, i.e.,
+	// singularity or apptainer
+	ContainerRuntimeKey = "container_runtime"
+
+	// ContainerMPIPrefixKey is the key used to specify the directory where MPI is installed in the
+	// containers, e.g., /usr/local or /opt/{mpi}-{version}
+	ContainerMPIPrefixKey = "container_mpi_prefix"
+
+	// BuilderImageKey is the key used to specify the image of the builder container in which the
+	// software is built for the host and mpirun executed, e.g., /path/to/builder.sif
+	BuilderImageKey = "builder_image"
+
+	// SandboxEnvKey is the key used to specify whether the commands are executed with a minimal
+	// environment instead of the environment of the host
+	SandboxEnvKey = "sandbox_env"
+
+	// FeatureProbesKey is the key used to specify whether the MPI feature probes are executed in the
+	// containers once their application succeeded, including by the experiments of a configuration file
+	FeatureProbesKey = "feature_probes"
+
+	// EnvAllowlistKey is the key used to specify the comma-separated list of the host environment
+	// variables passed to the commands executed with a minimal environment, e.g., http_proxy,https_proxy
+	EnvAllowlistKey = "env_allowlist"
+
+	// MakeJobsKey is the key used to specify the number of parallel jobs of make, 4 by default
+	MakeJobsKey = "make_jobs"
+
+	// BuildNiceKey and BuildIONiceKey are the keys used to specify the scheduling priority (nice
+	// level) and the I/O priority (best-effort ionice level, 8 for idle) of the builds
+	BuildNiceKey   = "build_nice"
+	BuildIONiceKey = "build_ionice"
+
+	// BuildMemoryMaxKey and BuildCPUQuotaKey are the keys used to specify the memory and CPU limits
+	// of the cgroup created with systemd-run for the builds, e.g., 8G and 400%
+	BuildMemoryMaxKey = "build_memory_max"
+	BuildCPUQuotaKey  = "build_cpu_quota"
+
+	// HeartbeatKey is the key used to specify the time without output after which a message reports
+	// that a build is still running, e.g., 10m (5m by default)
+	HeartbeatKey = "heartbeat"
+
+	// ScratchGCKey is the key used to specify what is done with the abandoned scratch directories
+	// when the tool starts: off, warn (default) or remove
+	ScratchGCKey = "scratch_gc"
+
+	// ScratchMaxAgeKey is the key used to specify the time since their last modification from which
+	// unlocked scratch directories are abandoned, e.g., 24h (default)
+	ScratchMaxAgeKey = "scratch_max_age"
+
+	// ScratchRootKey is the key used to specify a directory on fast local storage, e.g., /dev/shm
+	// or a node-local SSD, where the scratch and build directories are created instead of the
+	// workspace
+	ScratchRootKey = "scratch_root"
+
+	// ScratchMinFreeKey is the key used to specify the space that must be available in the fast
+	// scratch root for it to be used, e.g., 20G
+	ScratchMinFreeKey = "scratch_min_free"
+
+	// BaseImageAutobuildKey is the key used to specify whether the base images of the quick build
+	// strategy that are not in the cache are built (default) or reported as missing
+	BaseImageAutobuildKey = "base_image_autobuild"
+
+	// RegistryKeyPrefix is the prefix of the keys describing a named registry, followed by its name
+	// and the name of the setting, e.g., registry.ghcr.url = oras://ghcr.io/user (see LoadRegistries)
+	RegistryKeyPrefix = "registry."
+
+	// TuningKeyPrefix is the prefix of the keys describing the tuning file of the site for an
+	// implementation of MPI, followed by its identifier and the name of the setting, e.g.,
+	// tuning.openmpi.file = /etc/site/openmpi-mca-params.conf (see LoadTuningProfiles)
+	TuningKeyPrefix = "tuning."
+
+	// RuntimeModeKey is the key used to specify the runtime mode the containers are executed
+	// with, i.e., native (default) or oci
+	RuntimeModeKey = "runtime_mode"
+
+	// ociModeMinVersion is the first version of Singularity supporting the OCI runtime mode
+	ociModeMinVersion = "4.0"
+
 	sympiConfigFilename = "sympi_singularity.conf"
 )
 
@@ -66,16 +190,16 @@ func saveMPIConfigFile(path string, data []string) error {
 }
 
 func initMPIConfigFile() ([]string, error) {
+	buildPrivilege := true
 	buildPrivilegeEntry := BuildPrivilegeKey + " = true"
 	err := checker.CheckBuildPrivilege()
 	if err != nil {
 		log.Printf("* [INFO] Cannot build singularity images: %s", err)
+		buildPrivilege = false
 		buildPrivilegeEntry = BuildPrivilegeKey + " = false"
 	}
 
-	sudoCmdsEntry := SudoCmdsKey + " = build" // By default we assume build will require sudo
-
-	data := []string{buildPrivilegeEntry, sudoCmdsEntry}
+	data := append([]string{buildPrivilegeEntry}, getDefaultSudoEntries(buildPrivilege)...)
 
 	return data, nil
 }
@@ -83,7 +207,7 @@ func initMPIConfigFile() ([]string, error) {
 // LoadMPIConfigFile loads the tool's configuration file into a slice of key/value pairs
 func LoadMPIConfigFile() ([]kv.KV, error) {
 	syMPIConfigFile := GetPathToSyMPIConfigFile()
-	kvs, err := kv.LoadKeyValueConfig(syMPIConfigFile)
+	kvs, err := configparser.Load(syMPIConfigFile)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse %s: %s", syMPIConfigFile, err)
 	}
@@ -91,6 +215,132 @@ func LoadMPIConfigFile() ([]kv.KV, error) {
 	return kvs, nil
 }
 
+// LoadRegistries loads the named registries from the tool's configuration file. Each registry is
+// described by registry.<name>.<setting> keys, the settings being url, remote, username,
+// username_env, token_file and token_env.
+func LoadRegistries(kvs []kv.KV) (map[string]sys.Registry, error) {
+	registries := make(map[string]sys.Registry)
+	for _, e := range kvs {
+		if !strings.HasPrefix(e.Key, RegistryKeyPrefix) {
+			continue
+		}
+		tokens := strings.SplitN(strings.TrimPrefix(e.Key, RegistryKeyPrefix), ".", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("invalid registry key %s", e.Key)
+		}
+		r := registries[tokens[0]]
+		r.Name = tokens[0]
+		switch tokens[1] {
+		case "url":
+			r.URL = e.Value
+		case "remote":
+			r.Remote = e.Value
+		case "username":
+			r.Username = e.Value
+		case "username_env":
+			r.UsernameEnv = e.Value
+		case "token_file":
+			r.TokenFile = e.Value
+		case "token_env":
+			r.TokenEnv = e.Value
+		default:
+			return nil, fmt.Errorf("unknown setting %s for registry %s", tokens[1], tokens[0])
+		}
+		registries[tokens[0]] = r
+	}
+	for name, r := range registries {
+		if r.URL == "" {
+			return nil, fmt.Errorf("the URL of registry %s is not defined", name)
+		}
+		if r.Remote != "" && !strings.HasPrefix(r.URL, "library://") {
+			return nil, fmt.Errorf("only library:// registries have a remote endpoint, not registry %s", name)
+		}
+		if r.TokenFile != "" && r.TokenEnv != "" {
+			return nil, fmt.Errorf("registry %s can only get its token from a file or from the environment", name)
+		}
+	}
+	return registries, nil
+}
+
+// LoadBuildProfiles loads the named build profiles from the tool's configuration file. Each profile
+// is described by build_profile.<name>.<setting> keys, the settings being toolchain and
+// toolchain_dir.
+func LoadBuildProfiles(kvs []kv.KV) (map[string]sys.BuildProfile, error) {
+	profiles := make(map[string]sys.BuildProfile)
+	for _, e := range kvs {
+		if !strings.HasPrefix(e.Key, BuildProfileKeyPrefix) {
+			continue
+		}
+		tokens := strings.SplitN(strings.TrimPrefix(e.Key, BuildProfileKeyPrefix), ".", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("invalid build profile key %s", e.Key)
+		}
+		p := profiles[tokens[0]]
+		p.Name = tokens[0]
+		switch tokens[1] {
+		case ToolchainKey:
+			p.Toolchain = e.Value
+		case ToolchainDirKey:
+			p.ToolchainDir = e.Value
+		default:
+			return nil, fmt.Errorf("unknown setting %s for build profile %s", tokens[1], tokens[0])
+		}
+		profiles[tokens[0]] = p
+	}
+	for name, p := range profiles {
+		if p.Toolchain == "" {
+			return nil, fmt.Errorf("the toolchain of build profile %s is not defined", name)
+		}
+	}
+	return profiles, nil
+}
+
+// LoadTuningProfiles loads the tuning files of the site from the tool's configuration file. Each
+// profile is described by tuning.<mpi>.<setting> keys, the settings being file, the path to the
+// tuning file, and profile, the name reported in the results.
+func LoadTuningProfiles(kvs []kv.KV) (map[string]sys.TuningProfile, error) {
+	profiles := make(map[string]sys.TuningProfile)
+	for _, e := range kvs {
+		if !strings.HasPrefix(e.Key, TuningKeyPrefix) {
+			continue
+		}
+		tokens := strings.SplitN(strings.TrimPrefix(e.Key, TuningKeyPrefix), ".", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("invalid tuning key %s", e.Key)
+		}
+		p := profiles[tokens[0]]
+		p.MPI = tokens[0]
+		switch tokens[1] {
+		case "file":
+			p.File = e.Value
+		case "profile":
+			p.Name = e.Value
+		default:
+			return nil, fmt.Errorf("unknown tuning setting %s for %s", tokens[1], tokens[0])
+		}
+		profiles[tokens[0]] = p
+	}
+	for id, p := range profiles {
+		if p.File == "" {
+			return nil, fmt.Errorf("the tuning file of %s is not defined", id)
+		}
+		var err error
+		p.Digest, err = sys.GetTuningFileDigest(p.File)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tuning file of %s: %s", id, err)
+		}
+		if p.Name == "" {
+			p.Name = strings.TrimSuffix(filepath.Base(p.File), filepath.Ext(p.File))
+		}
+		err = sys.ValidateTuningProfileName(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		profiles[id] = p
+	}
+	return profiles, nil
+}
+
 // CreateMPIConfigFile ensures that the configuration file of the tool is correctly created
 func CreateMPIConfigFile() (string, error) {
 	syMPIDir := sys.GetSympiDir()
@@ -103,7 +353,7 @@ func CreateMPIConfigFile() (string, error) {
 
 	syMPIConfigFile := GetPathToSyMPIConfigFile()
 	log.Printf("-> Creating/updating SyMPI configuration file: %s", syMPIConfigFile)
-	if !util.PathExists(syMPIConfigFile) {
+	if !util.PathExists(configparser.GetConfigFilePath(syMPIConfigFile)) {
 		data, err := initMPIConfigFile()
 		if err != nil {
 			return "", fmt.Errorf("failed to initialize MPI configuration file: %s", err)
@@ -118,7 +368,8 @@ func CreateMPIConfigFile() (string, error) {
 	return syMPIConfigFile, nil
 }
 
-// ConfigFileUpdateEntry updates the value of a key in the tool's configuration file
+// ConfigFileUpdateEntry updates the value of a key in the tool's configuration file; the change is
+// recorded in the journal of the file (see LoadConfigHistory)
 func ConfigFileUpdateEntry(configFile string, key string, value string) error {
 	kvs, err := LoadMPIConfigFile()
 	if err != nil {
@@ -145,12 +396,23 @@ func ConfigFileUpdateEntry(configFile string, key string, value string) error {
 		}
 	}
 
-	data := kv.ToStringSlice(kvs)
-	err = saveMPIConfigFile(configFile, data)
+	// The configuration may be stored in a YAML file, in which case we keep using that format
+	configFile = configparser.GetConfigFilePath(configFile)
+	snapshot, err := readSnapshot(configFile)
+	if err != nil {
+		return err
+	}
+	err = configparser.Save(configFile, configparser.SectionTool, kvs)
 	if err != nil {
 		return fmt.Errorf("unable to save configuration in %s: %s", configFile, err)
 	}
 
+	// The change is recorded so it can be reviewed and reverted
+	err = recordConfigChange(configFile, ConfigChange{Key: key, Previous: currentVal, Value: value, Snapshot: snapshot})
+	if err != nil {
+		log.Printf("[WARN] unable to record the change of %s: %s", key, err)
+	}
+
 	return nil
 }
 
@@ -163,24 +425,13 @@ func getRegistryConfigFilePath(mpiCfg *implem.Info, sysCfg *sys.Config) string {
 func GetImageURL(mpiCfg *implem.Info, sysCfg *sys.Config) string {
 	registryConfigFile := getRegistryConfigFilePath(mpiCfg, sysCfg)
 	log.Printf("* Getting image URL for %s from %s...", mpiCfg.ID+"-"+mpiCfg.Version, registryConfigFile)
-	kvs, err := kv.LoadKeyValueConfig(registryConfigFile)
+	kvs, err := configparser.Load(registryConfigFile)
 	if err != nil {
 		return ""
 	}
 	return kv.GetValue(kvs, mpiCfg.Version)
 }
 
-// IsSudoCnd checks whether a command needs to be executed with sudo based on data from
-// the tool's configuration file
-func IsSudoCmd(cmd string, sysCfg *sys.Config) bool {
-	for _, c := range sysCfg.SudoSyCmds {
-		if c == cmd {
-			return true
-		}
-	}
-	return false
-}
-
 func getSingularityConfigFilePath(sysCfg *sys.Config) string {
 	return filepath.Join(sysCfg.EtcDir, sympiConfigFilename)
 }
@@ -189,7 +440,7 @@ func getSingularityConfigFilePath(sysCfg *sys.Config) string {
 // Singularity releases that are supported
 func LoadSingularityReleaseConf(sysCfg *sys.Config) ([]kv.KV, error) {
 	file := getSingularityConfigFilePath(sysCfg)
-	kvs, err := kv.LoadKeyValueConfig(file)
+	kvs, err := configparser.Load(file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read configuration from %s: %s", file, err)
 	}
@@ -226,7 +477,7 @@ func Configure(env *buildenv.Info, sysCfg *sys.Config, extraArgs []string) error
 	cmd := exec.CommandContext(ctx, "./mconfig", "-h")
 	cmd.Dir = env.SrcDir
 	cmd.Stdout = &stdout
-	cmd.Run() // mconfig -h always returns 2 (no idea why, it just does)
+	syexec.RunCmd(cmd) // mconfig -h always returns 2 (no idea why, it just does)
 
 	args := []string{"--prefix=" + env.InstallDir}
 	if strings.Contains(stdout.String(), "-p prefix") {
@@ -261,7 +512,7 @@ func Configure(env *buildenv.Info, sysCfg *sys.Config, extraArgs []string) error
 
 	res := sycmd.Run()
 	if res.Err != nil {
-		return fmt.Errorf("failed to run mconfig: %s (stderr: %s; stdout: %s)", res.Err, res.Stderr, res.Stdout)
+		return sympierr.Wrap(sympierr.ErrConfigureFailed, res.Err, "failed to run mconfig (stderr: %s; stdout: %s)", res.Stderr, res.Stdout)
 	}
 
 	return nil
@@ -323,20 +574,26 @@ func getArchsFromSIFListOutput(output string) []string {
 // GetSIFArchs returns the list of hardware architectures supported by a given image.
 //
 // Note that we can have multiple partitions and these partitions can support different
-// hardware architectures
+// hardware architectures. The partitions are read directly from the image when possible,
+// 'singularity sif list' being used otherwise.
 func GetSIFArchs(imgPath string, sysCfg *sys.Config) ([]string, error) {
 	// Sanity checks
 	if !util.FileExists(imgPath) {
 		return nil, fmt.Errorf("image %s does not exists", imgPath)
 	}
 
+	img, err := sif.Load(imgPath)
+	if err == nil && len(img.Archs()) > 0 {
+		return img.Archs(), nil
+	}
+
 	// Singularity changed the mconfig flags over time so we need to figure out how the prefix is specified
 	ctx, cancel := context.WithTimeout(context.Background(), sys.CmdTimeout*time.Minute)
 	defer cancel()
 	var stdout bytes.Buffer
 	cmd := exec.CommandContext(ctx, sysCfg.SingularityBin, "sif", "list", imgPath)
 	cmd.Stdout = &stdout
-	err := cmd.Run()
+	err = syexec.RunCmd(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("singularity sif list command failed: %s", err)
 	}
@@ -344,7 +601,7 @@ func GetSIFArchs(imgPath string, sysCfg *sys.Config) ([]string, error) {
 	return getArchsFromSIFListOutput(stdout.String()), nil
 }
 
-// GetVersion returned the version of Singularity that is currently used
+// GetVersion returns the version of Singularity or Apptainer that is currently used, e.g., 3.5.2
 func GetVersion(sysCfg *sys.Config) string {
 	if sysCfg.SingularityBin == "" {
 		// Not a fatal error, we just log the error
@@ -357,14 +614,33 @@ func GetVersion(sysCfg *sys.Config) string {
 	var stdout bytes.Buffer
 	cmd := exec.CommandContext(ctx, sysCfg.SingularityBin, "version")
 	cmd.Stdout = &stdout
-	err := cmd.Run()
+	err := syexec.RunCmd(cmd)
 	if err != nil {
 		// Not a fatal error, we just log the error
-		log.Printf("failed to execute singularity version: %s", err)
+		log.Printf("failed to execute %s version: %s", sys.GetContainerRuntime(sysCfg.SingularityBin), err)
 		return ""
 	}
 
-	return stdout.String()
+	return sys.ParseRuntimeVersion(stdout.String())
+}
+
+// CheckRuntimeMode checks whether the container runtime supports the runtime mode of the
+// configuration: the OCI mode requires SingularityCE 4.0 or later and is not available with Apptainer
+func CheckRuntimeMode(sysCfg *sys.Config) error {
+	if !sysCfg.IsOCIMode() {
+		return nil
+	}
+	if sys.GetContainerRuntime(sysCfg.SingularityBin) == sys.RuntimeApptainer {
+		return fmt.Errorf("the %s runtime mode is not supported by %s", sys.RuntimeModeOCI, sys.RuntimeApptainer)
+	}
+	version := GetVersion(sysCfg)
+	if version == "" {
+		return fmt.Errorf("unable to get the version of Singularity")
+	}
+	if implem.CompareVersions(version, ociModeMinVersion) < 0 {
+		return fmt.Errorf("the %s runtime mode requires Singularity %s or later, Singularity %s is used", sys.RuntimeModeOCI, ociModeMinVersion, version)
+	}
+	return nil
 }
 
 // CheckIntegrity checks if the installation of Singularity has been compromised
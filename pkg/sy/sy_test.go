@@ -6,11 +6,13 @@
 package sy
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
@@ -62,3 +64,98 @@ func TestGetArchsFromSIFListOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-config-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	configFile := GetPathToSyMPIConfigFile()
+	err = os.MkdirAll(filepath.Dir(configFile), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(configFile, []byte("ifnet = eth0\n"), 0644)
+	}
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", configFile, err)
+	}
+
+	for _, value := range []string{"ib0", "ib1"} {
+		err = ConfigFileUpdateEntry(configFile, "ifnet", value)
+		if err != nil {
+			t.Fatalf("ConfigFileUpdateEntry() failed: %s", err)
+		}
+	}
+	changes, err := LoadConfigHistory(configFile)
+	if err != nil || len(changes) != 2 || changes[1].ID != 2 || changes[1].Previous != "ib0" || changes[1].Value != "ib1" || changes[0].Snapshot != "ifnet = eth0\n" {
+		t.Fatalf("invalid history: %v (%v)", changes, err)
+	}
+
+	// Reverting the first change restores the initial configuration
+	err = RevertConfigFile(configFile, 1)
+	if err != nil {
+		t.Fatalf("RevertConfigFile() failed: %s", err)
+	}
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil || string(data) != "ifnet = eth0\n" {
+		t.Fatalf("configuration not reverted: %s (%v)", data, err)
+	}
+	changes, err = LoadConfigHistory(configFile)
+	if err != nil || len(changes) != 3 || changes[2].RevertedTo != 1 || !strings.Contains(changes[2].String(), "before change #1") {
+		t.Fatalf("revert not recorded: %v (%v)", changes, err)
+	}
+	if RevertConfigFile(configFile, 4) == nil {
+		t.Fatalf("RevertConfigFile() succeeded with an unknown change")
+	}
+}
+
+func TestLoadTuningProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-tuning-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tuningFile := filepath.Join(dir, "mca-params.conf")
+	err = ioutil.WriteFile(tuningFile, []byte("btl = self,vader,tcp\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", tuningFile, err)
+	}
+
+	tests := []struct {
+		kvs          []kv.KV
+		expectedName string
+		fail         bool
+	}{
+		{kvs: []kv.KV{{Key: "tuning.openmpi.file", Value: tuningFile}}, expectedName: "mca-params"},
+		{kvs: []kv.KV{{Key: "tuning.openmpi.file", Value: tuningFile}, {Key: "tuning.openmpi.profile", Value: "site-ib"}}, expectedName: "site-ib"},
+		{kvs: []kv.KV{{Key: "tuning.openmpi.profile", Value: "site-ib"}}, fail: true},
+		{kvs: []kv.KV{{Key: "tuning.openmpi.file", Value: filepath.Join(dir, "missing.conf")}}, fail: true},
+		{kvs: []kv.KV{{Key: "tuning.openmpi.file", Value: tuningFile}, {Key: "tuning.openmpi.profile", Value: "site ib"}}, fail: true},
+		{kvs: []kv.KV{{Key: "tuning.openmpi.path", Value: tuningFile}}, fail: true},
+	}
+	for _, tt := range tests {
+		profiles, err := LoadTuningProfiles(tt.kvs)
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("LoadTuningProfiles() succeeded with %v", tt.kvs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("LoadTuningProfiles() failed with %v: %s", tt.kvs, err)
+		}
+		p, ok := profiles["openmpi"]
+		if !ok || p.Name != tt.expectedName || p.ContainerPath() != "/etc/sympi/tuning/openmpi/mca-params.conf" || !strings.HasPrefix(p.Digest, "sha256:") {
+			t.Fatalf("invalid tuning profile of openmpi: %+v", p)
+		}
+		cfg := sys.Config{Tuning: profiles}
+		if names := sys.GetTuningProfileNames(&cfg, "openmpi", "openmpi", "mpich"); names != tt.expectedName {
+			t.Fatalf("tuning profiles %s are active instead of %s", names, tt.expectedName)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// Container is a container in which commands are executed instead of the host, i.e., the
+// builder container of the tool-in-container mode (see NewBuilderContainer)
+type Container struct {
+	// RuntimeBin is the path to the binary of the container runtime, i.e., singularity or apptainer
+	RuntimeBin string
+
+	// Image is the path or the URL of the image of the container
+	Image string
+
+	// Binds is the list of the host directories mounted at the same path in the container
+	Binds []string
+}
+
+// systemDirs are the directories of the host that are never mounted in the container since they
+// would hide the ones of the image
+var systemDirs = []string{"/", "/bin", "/sbin", "/lib", "/lib64", "/usr", "/usr/bin", "/usr/sbin", "/usr/lib", "/usr/lib64", "/usr/local", "/etc"}
+
+// getBindDirs returns the directories to mount in the container, without the empty, duplicated and
+// system directories and the directories already mounted with one of their parents
+func getBindDirs(dirs []string) []string {
+	excluded := make(map[string]bool)
+	for _, d := range systemDirs {
+		excluded[d] = true
+	}
+	var candidates []string
+	for _, d := range dirs {
+		if d != "" && !excluded[filepath.Clean(d)] {
+			candidates = append(candidates, filepath.Clean(d))
+		}
+	}
+	// Parents are sorted before their subdirectories
+	sort.Strings(candidates)
+
+	var binds []string
+	for _, d := range candidates {
+		mounted := false
+		for _, b := range binds {
+			if d == b || strings.HasPrefix(d, b+"/") {
+				mounted = true
+				break
+			}
+		}
+		if !mounted {
+			binds = append(binds, d)
+		}
+	}
+	return binds
+}
+
+// NewBuilderContainer returns the builder container of the tool-in-container mode, in which the
+// workspace, the scratch directory and dirs, e.g., the build and installation directories, are
+// mounted; it returns nil when host-side builds are executed directly on the host, i.e., when no
+// builder image is configured
+func NewBuilderContainer(sysCfg *sys.Config, dirs ...string) *Container {
+	if sysCfg.BuilderImage == "" {
+		return nil
+	}
+	c := new(Container)
+	c.RuntimeBin = sysCfg.SingularityBin
+	c.Image = sysCfg.BuilderImage
+	c.Binds = getBindDirs(append([]string{sys.GetSympiDir(), sysCfg.ScratchDir}, dirs...))
+	return c
+}
+
+// Wrap returns the binary, the arguments and the environment to execute a command, i.e., bin and
+// args, in the container from a directory (the current directory when dir is empty). env is the
+// environment of the command, the environment of the host when empty; its PATH is passed to the
+// container since the container runtime otherwise replaces it with the one of the image.
+func (c *Container) Wrap(bin string, args []string, dir string, env []string) (string, []string, []string) {
+	if len(env) == 0 {
+		env = os.Environ()
+	}
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+
+	wrappedArgs := []string{"exec"}
+	binds := getBindDirs(append([]string{dir}, c.Binds...))
+	if len(binds) > 0 {
+		wrappedArgs = append(wrappedArgs, "--bind", strings.Join(binds, ","))
+	}
+	if dir != "" {
+		wrappedArgs = append(wrappedArgs, "--pwd", dir)
+	}
+	wrappedArgs = append(wrappedArgs, c.Image, bin)
+	wrappedArgs = append(wrappedArgs, args...)
+
+	wrappedEnv := append([]string{}, env...)
+	for _, e := range env {
+		if strings.HasPrefix(e, "PATH=") {
+			// The last value of PATH is the one used
+			wrappedEnv = append(wrappedEnv, sys.GetContainerEnvPrefix(c.RuntimeBin)+e)
+		}
+	}
+	return c.RuntimeBin, wrappedArgs, wrappedEnv
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestBuilderContainer(t *testing.T) {
+	var sysCfg sys.Config
+	if NewBuilderContainer(&sysCfg) != nil {
+		t.Fatalf("builder container created without builder image")
+	}
+
+	sysCfg.BuilderImage = "/images/builder.sif"
+	sysCfg.SingularityBin = "/opt/apptainer/bin/apptainer"
+	sysCfg.ScratchDir = "/tmp/sympi-scratch"
+	c := NewBuilderContainer(&sysCfg, "/tmp/sympi-scratch/openmpi-4.0.2", "/usr", "", "/data/install/")
+	if c == nil {
+		t.Fatalf("NewBuilderContainer() did not create a container")
+	}
+	for _, b := range c.Binds {
+		if b == "/usr" || b == "/tmp/sympi-scratch/openmpi-4.0.2" {
+			t.Fatalf("invalid mount of %s in the builder container: %v", b, c.Binds)
+		}
+	}
+
+	bin, args, env := c.Wrap("make", []string{"-j4", "install"}, "/data/src", []string{"PATH=/data/install/bin:/usr/bin", "OMPI_MCA_btl=self"})
+	cmdline := bin + " " + strings.Join(args, " ")
+	if bin != sysCfg.SingularityBin || !strings.HasPrefix(cmdline, bin+" exec --bind ") || !strings.HasSuffix(cmdline, " --pwd /data/src /images/builder.sif make -j4 install") {
+		t.Fatalf("invalid command: %s", cmdline)
+	}
+	for _, dir := range []string{"/data/install", "/data/src", "/tmp/sympi-scratch"} {
+		if !strings.Contains(","+args[2]+",", ","+dir+",") {
+			t.Fatalf("%s is not mounted in the builder container: %s", dir, args[2])
+		}
+	}
+	if strings.Join(env, " ") != "PATH=/data/install/bin:/usr/bin OMPI_MCA_btl=self APPTAINERENV_PATH=/data/install/bin:/usr/bin" {
+		t.Fatalf("invalid environment: %v", env)
+	}
+}
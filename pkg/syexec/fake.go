@@ -0,0 +1,198 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeResult is the scripted result of a command executed by a FakeRunner
+type FakeResult struct {
+	// Stdout is the output the command writes on stdout
+	Stdout string
+
+	// Stderr is the output the command writes on stderr
+	Stderr string
+
+	// ExitCode is the exit code of the command, the command failing when it is not 0
+	ExitCode int
+
+	// Err, when not nil, is the error returned as if the command could not be started, e.g.,
+	// exec.ErrNotFound
+	Err error
+
+	// Duration is the time the command takes to complete, e.g., to test commands executed
+	// concurrently; the command completes immediately when 0
+	Duration time.Duration
+}
+
+// FakeCall is the record of a command executed by a FakeRunner
+type FakeCall struct {
+	// Binary is the path to the binary of the command
+	Binary string
+
+	// Args is the list of the arguments of the command
+	Args []string
+
+	// Dir is the directory where the command was executed
+	Dir string
+
+	// Env is the environment of the command, nil when it inherits the environment of the host
+	Env []string
+
+	// Stdin is what the command read on its standard input
+	Stdin string
+}
+
+// CmdLine returns the command line of the call, the binary being designated by its name, e.g.,
+// singularity build app.sif app.def
+func (c *FakeCall) CmdLine() string {
+	return strings.TrimSpace(filepath.Base(c.Binary) + " " + strings.Join(c.Args, " "))
+}
+
+// FakeExitError is the error returned by a FakeRunner when a command fails with an exit code
+type FakeExitError struct {
+	Code int
+}
+
+func (e *FakeExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the exit code of the command, like exec.ExitError
+func (e *FakeExitError) ExitCode() int {
+	return e.Code
+}
+
+// fakeRule associates a pattern of command lines to their successive results
+type fakeRule struct {
+	pattern string
+	results []FakeResult
+	calls   int
+}
+
+// FakeRunner is a runner that does not execute any command: it records them and returns the
+// results scripted with On. Commands without scripted result succeed without output, unless
+// Strict is set.
+type FakeRunner struct {
+	// Strict makes the commands without scripted result fail
+	Strict bool
+
+	lock  sync.Mutex
+	rules []*fakeRule
+	calls []FakeCall
+}
+
+// NewFakeRunner returns a runner recording commands instead of executing them (see SetRunner)
+func NewFakeRunner() *FakeRunner {
+	return new(FakeRunner)
+}
+
+// On scripts the results of the commands whose command line (see FakeCall.CmdLine) includes the
+// words of pattern in order, e.g., "make install" for make -j4 install. Successive matching
+// commands get the successive results, the last one being repeated; patterns scripted last take
+// precedence.
+func (f *FakeRunner) On(pattern string, results ...FakeResult) {
+	if len(results) == 0 {
+		results = []FakeResult{{}}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.rules = append(f.rules, &fakeRule{pattern: pattern, results: results})
+}
+
+// Calls returns the commands executed so far, in order
+func (f *FakeRunner) Calls() []FakeCall {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]FakeCall{}, f.calls...)
+}
+
+// CmdLines returns the command lines of the commands executed so far, in order
+func (f *FakeRunner) CmdLines() []string {
+	var cmdLines []string
+	for _, c := range f.Calls() {
+		cmdLines = append(cmdLines, c.CmdLine())
+	}
+	return cmdLines
+}
+
+// matches checks whether a command line includes the words of a pattern, in order
+func matches(cmdLine string, pattern string) bool {
+	words := strings.Fields(pattern)
+	for _, w := range strings.Fields(cmdLine) {
+		if len(words) > 0 && w == words[0] {
+			words = words[1:]
+		}
+	}
+	return len(words) == 0
+}
+
+// Run records a command and writes its scripted outputs
+func (f *FakeRunner) Run(cmd *exec.Cmd) error {
+	call := FakeCall{Binary: cmd.Path, Dir: cmd.Dir, Env: cmd.Env}
+	if len(cmd.Args) > 1 {
+		call.Args = cmd.Args[1:]
+	}
+	if cmd.Stdin != nil {
+		stdin, err := ioutil.ReadAll(cmd.Stdin)
+		if err == nil {
+			call.Stdin = string(stdin)
+		}
+	}
+	cmdLine := call.CmdLine()
+
+	f.lock.Lock()
+	f.calls = append(f.calls, call)
+	var res *FakeResult
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		r := f.rules[i]
+		if matches(cmdLine, r.pattern) {
+			idx := r.calls
+			if idx >= len(r.results) {
+				idx = len(r.results) - 1
+			}
+			r.calls++
+			res = &r.results[idx]
+			break
+		}
+	}
+	strict := f.Strict
+	f.lock.Unlock()
+
+	if res == nil {
+		if strict {
+			return fmt.Errorf("unexpected command: %s", cmdLine)
+		}
+		return nil
+	}
+	time.Sleep(res.Duration)
+	if res.Err != nil {
+		return res.Err
+	}
+	for _, out := range []struct {
+		w    io.Writer
+		data string
+	}{{cmd.Stdout, res.Stdout}, {cmd.Stderr, res.Stderr}} {
+		if out.w != nil && out.data != "" {
+			_, err := io.WriteString(out.w, out.data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if res.ExitCode != 0 {
+		return &FakeExitError{Code: res.ExitCode}
+	}
+	return nil
+}
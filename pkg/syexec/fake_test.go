@@ -0,0 +1,70 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFakeRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-fake-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ledger := filepath.Join(dir, "test.ledger")
+	SetLedger(ledger)
+	defer SetLedger("")
+
+	fake := NewFakeRunner()
+	fake.On("make", FakeResult{Stdout: "built\n"})
+	fake.On("make install", FakeResult{Stderr: "permission denied\n", ExitCode: 2}, FakeResult{})
+	defer SetRunner(SetRunner(fake))
+
+	var cmd SyCmd
+	cmd.BinPath = "/usr/bin/make"
+	cmd.CmdArgs = []string{"-j4"}
+	cmd.ExecDir = dir
+	cmd.StreamPrefix = "[make]"
+	res := cmd.Run()
+	if res.Err != nil || res.Stdout != "built\n" {
+		t.Fatalf("invalid result of make: %+v", res)
+	}
+
+	for _, expected := range []int{2, 0, 0} {
+		var stderr bytes.Buffer
+		installCmd := exec.Command("make", "-j4", "install")
+		installCmd.Stderr = &stderr
+		err = RunCmd(installCmd)
+		if expected == 0 && err != nil || expected != 0 && (err == nil || !strings.Contains(stderr.String(), "permission denied")) {
+			t.Fatalf("invalid result of make install: %v (%s)", err, stderr.String())
+		}
+	}
+
+	fake.Strict = true
+	err = RunCmd(exec.Command("rm", "-rf", dir))
+	if err == nil || !strings.Contains(err.Error(), "unexpected command") {
+		t.Fatalf("unexpected command executed: %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("the fake runner executed a command: %s", err)
+	}
+
+	cmdLines := fake.CmdLines()
+	if len(cmdLines) != 5 || cmdLines[0] != "make -j4" || cmdLines[1] != "make -j4 install" || fake.Calls()[0].Dir != dir {
+		t.Fatalf("invalid commands: %v", cmdLines)
+	}
+	entries, err := LoadLedger(ledger)
+	if err != nil || len(entries) != 5 || entries[0].ExitCode != 0 || entries[1].ExitCode != 2 {
+		t.Fatalf("invalid ledger: %+v (%v)", entries, err)
+	}
+}
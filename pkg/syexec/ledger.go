@@ -0,0 +1,286 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// LedgerDirName is the name of the directory of the workspace where the ledgers are stored
+	LedgerDirName = "ledgers"
+
+	// ledgerSuffix is the suffix of the ledger files
+	ledgerSuffix = ".ledger"
+)
+
+// EnvPrefixes is the list of prefixes of the environment variables relevant to reproduce what the
+// tool did; other variables are not recorded since they may contain sensitive data
+var EnvPrefixes = []string{"PATH=", "LD_LIBRARY_PATH=", "SYMPI_", "SINGULARITY", "APPTAINER", "OMPI_", "PRTE_", "PMIX_", "MPICH_", "HYDRA_", "I_MPI_", "FI_", "UCX_", "SLURM_"}
+
+// LedgerEntry is the record of the execution of an external command
+type LedgerEntry struct {
+	// Binary is the path to the binary that was executed
+	Binary string `json:"binary"`
+
+	// Args is the list of the arguments of the command
+	Args []string `json:"args,omitempty"`
+
+	// Env is the subset of the environment of the command that is relevant to reproduce it (see
+	// EnvPrefixes) or, when Sandboxed, its entire environment with secret values redacted
+	Env []string `json:"env,omitempty"`
+
+	// Sandboxed specifies whether the command was executed in a sandboxed environment (see SetSandbox)
+	Sandboxed bool `json:"sandboxed,omitempty"`
+
+	// Dir is the directory where the command was executed
+	Dir string `json:"dir"`
+
+	// ExitCode is the exit code of the command, -1 if the command could not be started or was killed
+	ExitCode int `json:"exit_code"`
+
+	// Start is the time when the command started
+	Start time.Time `json:"start"`
+
+	// Duration is the time the command took to complete
+	Duration time.Duration `json:"duration"`
+
+	// Error is the error returned when executing the command, if any
+	Error string `json:"error,omitempty"`
+
+	// CPUTime and MaxRSS are the resources used by the command, see Usage
+	CPUTime time.Duration `json:"cpu_time,omitempty"`
+	MaxRSS  int64         `json:"max_rss_kb,omitempty"`
+}
+
+var (
+	ledgerLock sync.Mutex
+	ledgerPath string
+)
+
+// SetLedger specifies the file where all the commands executed from now are recorded; no command
+// is recorded when the path is empty. The file is created when the first command is recorded.
+func SetLedger(path string) {
+	ledgerLock.Lock()
+	defer ledgerLock.Unlock()
+	ledgerPath = path
+}
+
+// GetLedger returns the path to the ledger where commands are currently recorded
+func GetLedger() string {
+	ledgerLock.Lock()
+	defer ledgerLock.Unlock()
+	return ledgerPath
+}
+
+// NewLedgerPath returns the path to a new ledger in a directory, named after the current time
+// and process, e.g., 20200115-103000-1234.ledger
+func NewLedgerPath(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%d%s", time.Now().Format("20060102-150405"), os.Getpid(), ledgerSuffix))
+}
+
+// FilterEnv returns the environment variables relevant to reproduce what the tool did, sorted
+func FilterEnv(environ []string) []string {
+	var env []string
+	for _, e := range environ {
+		for _, prefix := range EnvPrefixes {
+			if strings.HasPrefix(e, prefix) {
+				env = append(env, e)
+				break
+			}
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// newLedgerEntry creates the record of a command that completed
+func newLedgerEntry(cmd *exec.Cmd, start time.Time, err error) LedgerEntry {
+	e := LedgerEntry{
+		Binary:   cmd.Path,
+		Dir:      cmd.Dir,
+		Start:    start,
+		Duration: time.Since(start),
+	}
+	if len(cmd.Args) > 1 {
+		e.Args = cmd.Args[1:]
+	}
+	environ := cmd.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+	if IsSandboxed() {
+		// The environment is minimal and built by the tool, it is entirely relevant
+		e.Sandboxed = true
+		e.Env = RedactEnv(environ)
+	} else {
+		e.Env = FilterEnv(environ)
+	}
+	if e.Dir == "" {
+		e.Dir, _ = os.Getwd()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.ExitCode = -1
+	var exitErr interface{ ExitCode() int }
+	switch {
+	case cmd.ProcessState != nil:
+		e.ExitCode = cmd.ProcessState.ExitCode()
+		e.CPUTime = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+		if rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+			e.MaxRSS = rusage.Maxrss
+		}
+	case err == nil:
+		// The command was not executed on the system but by a fake runner (see SetRunner)
+		e.ExitCode = 0
+	case errors.As(err, &exitErr):
+		e.ExitCode = exitErr.ExitCode()
+	}
+	return e
+}
+
+// Record adds a command that completed to the current ledger, if any, and its resource usage to
+// the one returned by TakeUsage; start is the time when the command started and err the error it
+// returned
+func Record(cmd *exec.Cmd, start time.Time, err error) {
+	recordUsage(cmd.ProcessState)
+
+	ledgerLock.Lock()
+	defer ledgerLock.Unlock()
+	if ledgerPath == "" {
+		return
+	}
+
+	data, jsonErr := json.Marshal(newLedgerEntry(cmd, start, err))
+	if jsonErr != nil {
+		log.Printf("[WARN] failed to record command in ledger: %s", jsonErr)
+		return
+	}
+	// Recording commands is not critical, failures are only logged
+	mkdirErr := os.MkdirAll(filepath.Dir(ledgerPath), 0755)
+	if mkdirErr != nil {
+		log.Printf("[WARN] failed to create %s: %s", filepath.Dir(ledgerPath), mkdirErr)
+		return
+	}
+	f, openErr := os.OpenFile(ledgerPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if openErr != nil {
+		log.Printf("[WARN] failed to open ledger %s: %s", ledgerPath, openErr)
+		return
+	}
+	defer f.Close()
+	_, writeErr := f.Write(append(data, '\n'))
+	if writeErr != nil {
+		log.Printf("[WARN] failed to write to ledger %s: %s", ledgerPath, writeErr)
+	}
+}
+
+// RunCmd executes a command like cmd.Run, in a sandboxed environment if enabled, and records it
+// in the current ledger
+func RunCmd(cmd *exec.Cmd) error {
+	applySandbox(cmd)
+	start := time.Now()
+	err := getRunner().Run(cmd)
+	Record(cmd, start, err)
+	return err
+}
+
+// LoadLedger reads all the entries of a ledger
+func LoadLedger(path string) ([]LedgerEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e LedgerEntry
+		err := json.Unmarshal([]byte(line), &e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse entry %d of %s: %s", len(entries)+1, path, err)
+		}
+		entries = append(entries, e)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	return entries, nil
+}
+
+// ListLedgers returns the paths to the ledgers of a directory, the most recent one first
+func ListLedgers(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %s", dir, err)
+	}
+	var ledgers []string
+	// The names start with the time and the entries are sorted by name, the last one is therefore
+	// the most recent
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].IsDir() && strings.HasSuffix(entries[i].Name(), ledgerSuffix) {
+			ledgers = append(ledgers, filepath.Join(dir, entries[i].Name()))
+		}
+	}
+	return ledgers, nil
+}
+
+// FindLatestLedger returns the path to the most recent ledger of a directory
+func FindLatestLedger(dir string) (string, error) {
+	ledgers, err := ListLedgers(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(ledgers) == 0 {
+		return "", errors.New("no ledger found in " + dir)
+	}
+	return ledgers[0], nil
+}
+
+// FormatLedger returns a human-readable description of the commands of a ledger
+func FormatLedger(entries []LedgerEntry) string {
+	var sb strings.Builder
+	for i, e := range entries {
+		status := "OK"
+		if e.ExitCode != 0 || e.Error != "" {
+			status = fmt.Sprintf("FAILED (exit code %d)", e.ExitCode)
+		}
+		fmt.Fprintf(&sb, "[%d] %s %s\n", i+1, e.Start.Format("2006-01-02 15:04:05"), status)
+		fmt.Fprintf(&sb, "\tCommand: %s\n", strings.TrimSpace(e.Binary+" "+strings.Join(e.Args, " ")))
+		fmt.Fprintf(&sb, "\tDirectory: %s\n", e.Dir)
+		fmt.Fprintf(&sb, "\tDuration: %s\n", e.Duration.Round(time.Millisecond))
+		if e.Sandboxed {
+			fmt.Fprintf(&sb, "\tEnvironment: sandboxed\n")
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&sb, "\tError: %s\n", e.Error)
+		}
+		for _, env := range e.Env {
+			fmt.Fprintf(&sb, "\tEnv: %s\n", env)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-ledger-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetLedger("")
+
+	ledgerDir := filepath.Join(dir, LedgerDirName)
+	_, err = FindLatestLedger(ledgerDir)
+	if err == nil {
+		t.Fatalf("FindLatestLedger() succeeded without ledger")
+	}
+
+	path := NewLedgerPath(ledgerDir)
+	SetLedger(path)
+
+	var cmd SyCmd
+	cmd.BinPath = "true"
+	cmd.ExecDir = dir
+	res := cmd.Run()
+	if res.Err != nil {
+		t.Fatalf("failed to run command: %s", res.Err)
+	}
+	failingCmd := exec.Command("sh", "-c", "exit 3")
+	failingCmd.Env = []string{"PATH=/usr/bin:/bin", "OMPI_MCA_btl=self", "SECRET_TOKEN=secret"}
+	err = RunCmd(failingCmd)
+	if err == nil {
+		t.Fatalf("RunCmd() succeeded with a failing command")
+	}
+
+	latest, err := FindLatestLedger(ledgerDir)
+	if err != nil || latest != path {
+		t.Fatalf("FindLatestLedger() returned %s instead of %s: %v", latest, path, err)
+	}
+	entries, err := LoadLedger(path)
+	if err != nil {
+		t.Fatalf("LoadLedger() failed: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d commands recorded instead of 2", len(entries))
+	}
+	if filepath.Base(entries[0].Binary) != "true" || entries[0].Dir != dir || entries[0].ExitCode != 0 {
+		t.Fatalf("invalid entry: %+v", entries[0])
+	}
+	e := entries[1]
+	if e.ExitCode != 3 || e.Error == "" || strings.Join(e.Args, " ") != "-c exit 3" {
+		t.Fatalf("invalid entry: %+v", e)
+	}
+	if strings.Join(e.Env, " ") != "OMPI_MCA_btl=self PATH=/usr/bin:/bin" {
+		t.Fatalf("invalid environment: %v", e.Env)
+	}
+
+	output := FormatLedger(entries)
+	if !strings.Contains(output, "[2]") || !strings.Contains(output, "FAILED (exit code 3)") || strings.Contains(output, "SECRET_TOKEN") {
+		t.Fatalf("invalid ledger output:\n%s", output)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultMakeJobs is the number of parallel jobs of make when none is specified
+	DefaultMakeJobs = 4
+
+	// maxNice and maxIONice are the lowest priorities of nice and of the best-effort class of
+	// ionice; an I/O priority of maxIONice+1 selects the idle class
+	maxNice   = 19
+	maxIONice = 7
+)
+
+// limitFlag identifies a resource limit in the limits that are explicitly specified
+type limitFlag uint8
+
+const (
+	jobsLimit limitFlag = 1 << iota
+	niceLimit
+	ioniceLimit
+	memoryMaxLimit
+	cpuQuotaLimit
+)
+
+// noLimit is the value of memory_max and cpu_quota resetting the limit, e.g., to override the
+// limit of the tool's configuration file
+const noLimit = "none"
+
+// limitOptions are the names of the options describing resource limits, e.g., "jobs=2 nice=10"
+var limitOptions = []string{"jobs", "nice", "ionice", "memory_max", "cpu_quota"}
+
+// memoryMaxFormat is the format of the memory limit of the builds, as accepted by systemd, e.g., 8G
+var memoryMaxFormat = regexp.MustCompile(`^[0-9]+[KMGT]?$`)
+
+// cpuQuotaFormat is the format of the CPU limit of the builds, as accepted by systemd, e.g., 400%
+var cpuQuotaFormat = regexp.MustCompile(`^[0-9]+%$`)
+
+// Limits are the resource limits of the builds, i.e., the commands executed with SyCmd.Limited,
+// so that builds do not use all the cores or all the memory of shared nodes, e.g., login nodes
+type Limits struct {
+	// Jobs is the number of parallel jobs of make, DefaultMakeJobs when 0
+	Jobs int
+
+	// Nice is the scheduling priority of the builds, from 0 (unchanged) to 19 (lowest)
+	Nice int
+
+	// IONice is the I/O priority of the builds in the best-effort class, from 1 to 7 (lowest), or
+	// 8 for the idle class; 0 leaves it unchanged
+	IONice int
+
+	// MemoryMax and CPUQuota are the limits of the cgroup in which the builds are executed with
+	// systemd-run, e.g., 8G and 400%; the builds are not executed in a specific cgroup when
+	// both are empty
+	MemoryMax string
+	CPUQuota  string
+
+	// set records the limits specified with ParseLimits, including the ones reset with 0 or
+	// none; when empty, e.g., the limits are not parsed, the limits that are not zero are set
+	set limitFlag
+}
+
+// Usage is the resource usage of the commands executed by the tool
+type Usage struct {
+	// CPUTime is the user and system time used by the commands and their children
+	CPUTime time.Duration
+
+	// MaxRSS is the largest resident set size of the commands, in kilobytes
+	MaxRSS int64
+}
+
+var limits struct {
+	sync.Mutex
+	l Limits
+}
+
+var usage struct {
+	sync.Mutex
+	u Usage
+}
+
+// Validate checks whether resource limits are valid
+func (l *Limits) Validate() error {
+	if l.Jobs < 0 {
+		return fmt.Errorf("invalid number of make jobs %d", l.Jobs)
+	}
+	if l.Nice < 0 || l.Nice > maxNice {
+		return fmt.Errorf("invalid nice level %d, it should be between 0 and %d", l.Nice, maxNice)
+	}
+	if l.IONice < 0 || l.IONice > maxIONice+1 {
+		return fmt.Errorf("invalid ionice level %d, it should be between 0 and %d", l.IONice, maxIONice+1)
+	}
+	if l.MemoryMax != "" && !memoryMaxFormat.MatchString(l.MemoryMax) {
+		return fmt.Errorf("invalid memory limit %s, it should be a size in bytes with an optional K, M, G or T suffix, e.g., 8G", l.MemoryMax)
+	}
+	if l.CPUQuota != "" && !cpuQuotaFormat.MatchString(l.CPUQuota) {
+		return fmt.Errorf("invalid CPU quota %s, it should be a percentage of one CPU, e.g., 400%%", l.CPUQuota)
+	}
+	return nil
+}
+
+// ParseLimits parses resource limits described by options, e.g., "jobs=2 nice=10 ionice=8
+// memory_max=8G cpu_quota=400%"; the limits that are not specified are left unset while the limits
+// set to 0, or to none for memory_max and cpu_quota, reset the limits they override
+func ParseLimits(str string) (Limits, error) {
+	var l Limits
+	for _, opt := range strings.Fields(str) {
+		tokens := strings.SplitN(opt, "=", 2)
+		if len(tokens) != 2 || tokens[1] == "" {
+			return l, fmt.Errorf("invalid resource limit %s, it should be of the form <name>=<value> with name in %s", opt, strings.Join(limitOptions, ", "))
+		}
+		var err error
+		switch tokens[0] {
+		case "jobs":
+			l.Jobs, err = strconv.Atoi(tokens[1])
+			l.set |= jobsLimit
+		case "nice":
+			l.Nice, err = strconv.Atoi(tokens[1])
+			l.set |= niceLimit
+		case "ionice":
+			l.IONice, err = strconv.Atoi(tokens[1])
+			l.set |= ioniceLimit
+		case "memory_max":
+			if tokens[1] != noLimit {
+				l.MemoryMax = tokens[1]
+			}
+			l.set |= memoryMaxLimit
+		case "cpu_quota":
+			if tokens[1] != noLimit {
+				l.CPUQuota = tokens[1]
+			}
+			l.set |= cpuQuotaLimit
+		default:
+			return l, fmt.Errorf("unknown resource limit %s, supported limits: %s", tokens[0], strings.Join(limitOptions, ", "))
+		}
+		if err != nil {
+			return l, fmt.Errorf("invalid value of %s: %s", tokens[0], err)
+		}
+	}
+	return l, l.Validate()
+}
+
+// Override returns the limits of l overridden by the limits that are set in o, e.g., the limits
+// of an experiment overriding the limits of the tool's configuration file; a limit of o that is
+// explicitly set to 0, e.g., "nice=0", resets the limit of l
+func (l Limits) Override(o Limits) Limits {
+	if o.isSet(jobsLimit, o.Jobs != 0) {
+		l.Jobs = o.Jobs
+	}
+	if o.isSet(niceLimit, o.Nice != 0) {
+		l.Nice = o.Nice
+	}
+	if o.isSet(ioniceLimit, o.IONice != 0) {
+		l.IONice = o.IONice
+	}
+	if o.isSet(memoryMaxLimit, o.MemoryMax != "") {
+		l.MemoryMax = o.MemoryMax
+	}
+	if o.isSet(cpuQuotaLimit, o.CPUQuota != "") {
+		l.CPUQuota = o.CPUQuota
+	}
+	l.set |= o.set
+	return l
+}
+
+// isSet checks whether a limit is set; nonZero specifies whether its value is not 0, which is the
+// only way to know it when the limits are not parsed
+func (l *Limits) isSet(flag limitFlag, nonZero bool) bool {
+	if l.set == 0 {
+		return nonZero
+	}
+	return l.set&flag != 0
+}
+
+// GetJobs returns the number of parallel jobs of make
+func (l *Limits) GetJobs() int {
+	if l.Jobs == 0 {
+		return DefaultMakeJobs
+	}
+	return l.Jobs
+}
+
+// SetLimits specifies the resource limits of all the builds executed from now and returns the
+// previous limits, e.g., defer syexec.SetLimits(syexec.SetLimits(l))
+func SetLimits(l Limits) Limits {
+	limits.Lock()
+	defer limits.Unlock()
+	prev := limits.l
+	limits.l = l
+	return prev
+}
+
+// GetLimits returns the resource limits currently applied to the builds
+func GetLimits() Limits {
+	limits.Lock()
+	defer limits.Unlock()
+	return limits.l
+}
+
+// wrapLimits returns the command executing a binary with the current resource limits, e.g.,
+// systemd-run --user --scope --quiet -p MemoryMax=8G nice -n 10 make -j2
+func wrapLimits(bin string, args []string) (string, []string) {
+	l := GetLimits()
+	cmdline := append([]string{bin}, args...)
+	if l.IONice > maxIONice {
+		cmdline = append([]string{"ionice", "-c", "3"}, cmdline...)
+	} else if l.IONice > 0 {
+		cmdline = append([]string{"ionice", "-c", "2", "-n", strconv.Itoa(l.IONice)}, cmdline...)
+	}
+	if l.Nice > 0 {
+		cmdline = append([]string{"nice", "-n", strconv.Itoa(l.Nice)}, cmdline...)
+	}
+	if l.MemoryMax != "" || l.CPUQuota != "" {
+		systemdRun := []string{"systemd-run", "--user", "--scope", "--quiet"}
+		if l.MemoryMax != "" {
+			systemdRun = append(systemdRun, "-p", "MemoryMax="+l.MemoryMax)
+		}
+		if l.CPUQuota != "" {
+			systemdRun = append(systemdRun, "-p", "CPUQuota="+l.CPUQuota)
+		}
+		cmdline = append(systemdRun, cmdline...)
+	}
+	return cmdline[0], cmdline[1:]
+}
+
+// recordUsage adds the resource usage of a command that completed to the usage returned by TakeUsage
+func recordUsage(state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+	usage.Lock()
+	defer usage.Unlock()
+	usage.u.CPUTime += state.UserTime() + state.SystemTime()
+	if rusage.Maxrss > usage.u.MaxRSS {
+		usage.u.MaxRSS = rusage.Maxrss
+	}
+}
+
+// TakeUsage returns the resource usage of the commands that completed since the previous call and
+// resets it, e.g., to get the resources used by an experiment
+func TakeUsage() Usage {
+	usage.Lock()
+	defer usage.Unlock()
+	u := usage.u
+	usage.u = Usage{}
+	return u
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		options string
+		cmdLine string
+		valid   bool
+	}{
+		{
+			options: "",
+			cmdLine: "make -j4 install",
+			valid:   true,
+		},
+		{
+			options: "jobs=2 nice=10 ionice=7",
+			cmdLine: "nice -n 10 ionice -c 2 -n 7 make -j2 install",
+			valid:   true,
+		},
+		{
+			options: "ionice=8 memory_max=8G cpu_quota=400%",
+			cmdLine: "systemd-run --user --scope --quiet -p MemoryMax=8G -p CPUQuota=400% ionice -c 3 make -j4 install",
+			valid:   true,
+		},
+		{options: "nice=20"},
+		{options: "memory_max=8GB"},
+		{options: "cpus=4"},
+		{options: "jobs"},
+		{
+			options: "nice=0 memory_max=none cpu_quota=none",
+			cmdLine: "make -j4 install",
+			valid:   true,
+		},
+	}
+
+	fake := NewFakeRunner()
+	defer SetRunner(SetRunner(fake))
+	defer SetLimits(SetLimits(Limits{}))
+	for _, tt := range tests {
+		l, err := ParseLimits(tt.options)
+		if (err == nil) != tt.valid {
+			t.Fatalf("ParseLimits(%s) returned %v", tt.options, err)
+		}
+		if !tt.valid {
+			continue
+		}
+		SetLimits(l)
+		var cmd SyCmd
+		cmd.BinPath = "make"
+		cmd.CmdArgs = []string{"-j" + strconv.Itoa(l.GetJobs()), "install"}
+		cmd.Limited = true
+		res := cmd.Run()
+		if res.Err != nil {
+			t.Fatalf("failed to execute %s: %s", tt.cmdLine, res.Err)
+		}
+		calls := fake.Calls()
+		if calls[len(calls)-1].CmdLine() != tt.cmdLine {
+			t.Fatalf("%s executed instead of %s", calls[len(calls)-1].CmdLine(), tt.cmdLine)
+		}
+	}
+
+	// The limits of an experiment override the ones of the configuration file
+	global := Limits{Jobs: 8, Nice: 5}
+	l := global.Override(Limits{Jobs: 2, MemoryMax: "4G"})
+	if l.Jobs != 2 || l.Nice != 5 || l.MemoryMax != "4G" {
+		t.Fatalf("invalid limits: %v", l)
+	}
+
+	// Limits explicitly set to 0 reset the limits they override
+	global, err := ParseLimits("jobs=8 nice=5 ionice=8 memory_max=8G cpu_quota=400%")
+	if err != nil {
+		t.Fatalf("failed to parse limits: %s", err)
+	}
+	o, err := ParseLimits("jobs=2 nice=0 memory_max=none")
+	if err != nil {
+		t.Fatalf("failed to parse limits: %s", err)
+	}
+	l = global.Override(o)
+	if l.Jobs != 2 || l.Nice != 0 || l.IONice != 8 || l.MemoryMax != "" || l.CPUQuota != "400%" {
+		t.Fatalf("invalid limits: %v", l)
+	}
+}
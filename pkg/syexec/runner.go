@@ -0,0 +1,51 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// Runner executes the external commands of the tool. The default runner executes them on the
+// system; tests replace it with a FakeRunner to check the commands without executing them.
+type Runner interface {
+	// Run executes a command and waits for its completion, like exec.Cmd.Run
+	Run(cmd *exec.Cmd) error
+}
+
+// systemRunner is the runner executing the commands on the system
+type systemRunner struct{}
+
+// Run executes a command on the system
+func (systemRunner) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+var currentRunner = struct {
+	sync.Mutex
+	r Runner
+}{r: systemRunner{}}
+
+// SetRunner specifies the runner executing all the commands from now, the system being used when
+// r is nil, and returns the previous runner, e.g., defer syexec.SetRunner(syexec.SetRunner(fake))
+func SetRunner(r Runner) Runner {
+	if r == nil {
+		r = systemRunner{}
+	}
+	currentRunner.Lock()
+	defer currentRunner.Unlock()
+	prev := currentRunner.r
+	currentRunner.r = r
+	return prev
+}
+
+// getRunner returns the runner currently executing the commands
+func getRunner() Runner {
+	currentRunner.Lock()
+	defer currentRunner.Unlock()
+	return currentRunner.r
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// SandboxPath is the PATH of the commands executed in a sandboxed environment, to which the
+	// directories added by the tool, e.g., the bin directory of MPI, are prepended
+	SandboxPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// Redacted is the value recorded instead of secret values, e.g., in ledgers and manifests
+	Redacted = "<redacted>"
+)
+
+// SandboxAllowlist is the list of the host environment variables always passed to the commands
+// executed in a sandboxed environment, i.e., the variables required by the tools we execute
+var SandboxAllowlist = []string{"HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "TZ", "TMPDIR"}
+
+// pathListVars are the variables listing directories, from which the directories of the host are
+// removed in a sandboxed environment
+var pathListVars = map[string]bool{"PATH": true, "LD_LIBRARY_PATH": true}
+
+// secretWords are the words identifying environment variables with secret values, e.g., API_TOKEN
+var secretWords = []string{"PASSWORD", "PASSWD", "PASSPHRASE", "SECRET", "TOKEN", "CREDENTIAL", "KEY", "AUTH"}
+
+var sandbox struct {
+	sync.Mutex
+	enabled   bool
+	allowlist []string
+}
+
+// hostEnv are the values of the host of the variables the tool changed in its own environment
+// (see Setenv), the variables not defined on the host being recorded with hostUnset
+var hostEnv struct {
+	sync.Mutex
+	values map[string]string
+}
+
+// hostUnset is the value recorded in hostEnv for the variables not defined on the host
+const hostUnset = "\x00"
+
+// SetSandbox specifies whether all the commands executed from now run in a minimal environment,
+// made of SandboxAllowlist, the extra host variables of allowlist (e.g., http_proxy) and the
+// variables explicitly set by the tool, instead of the environment of the host
+func SetSandbox(enabled bool, allowlist []string) {
+	sandbox.Lock()
+	defer sandbox.Unlock()
+	sandbox.enabled = enabled
+	sandbox.allowlist = allowlist
+}
+
+// recordHostEnv records the value of the host of a variable before the tool changes it
+func recordHostEnv(name string) {
+	hostEnv.Lock()
+	defer hostEnv.Unlock()
+	if hostEnv.values == nil {
+		hostEnv.values = make(map[string]string)
+	}
+	if _, ok := hostEnv.values[name]; ok {
+		return
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		value = hostUnset
+	}
+	hostEnv.values[name] = value
+}
+
+// lookupHostEnv returns the value of a variable on the host, i.e., before the tool changed it
+// with Setenv or Unsetenv
+func lookupHostEnv(name string) (string, bool) {
+	hostEnv.Lock()
+	value, ok := hostEnv.values[name]
+	hostEnv.Unlock()
+	if !ok {
+		return os.LookupEnv(name)
+	}
+	return value, value != hostUnset
+}
+
+// Setenv sets a variable in the environment of the tool, which the commands executed from now
+// inherit, e.g., the environment exported by a pre-run hook. Unlike with os.Setenv, the variable
+// is considered as set by the tool, so it is kept in sandboxed environments (see SandboxEnv).
+func Setenv(name string, value string) error {
+	recordHostEnv(name)
+	return os.Setenv(name, value)
+}
+
+// Unsetenv removes a variable from the environment of the tool, see Setenv
+func Unsetenv(name string) error {
+	recordHostEnv(name)
+	return os.Unsetenv(name)
+}
+
+// IsSandboxed returns whether commands are currently executed in a sandboxed environment
+func IsSandboxed() bool {
+	sandbox.Lock()
+	defer sandbox.Unlock()
+	return sandbox.enabled
+}
+
+// IsSecretEnvVar checks whether the value of an environment variable must not be recorded, based on
+// its name, e.g., LICENSE_TOKEN, or its value, e.g., a proxy URL with a password
+func IsSecretEnvVar(name string, value string) bool {
+	upper := strings.ToUpper(name)
+	for _, w := range secretWords {
+		if strings.Contains(upper, w) {
+			return true
+		}
+	}
+	u, err := url.Parse(value)
+	if err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactEnv returns a sorted copy of an environment where secret values are redacted
+func RedactEnv(environ []string) []string {
+	var env []string
+	for _, e := range environ {
+		tokens := strings.SplitN(e, "=", 2)
+		if len(tokens) == 2 && IsSecretEnvVar(tokens[0], tokens[1]) {
+			e = tokens[0] + "=" + Redacted
+		}
+		env = append(env, e)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// removeHostDirs removes from a list of directories, e.g., the value of PATH, the directories of
+// the same variable on the host
+func removeHostDirs(value string, hostValue string) []string {
+	hostDirs := make(map[string]bool)
+	for _, d := range strings.Split(hostValue, ":") {
+		hostDirs[d] = true
+	}
+	var dirs []string
+	for _, d := range strings.Split(value, ":") {
+		if d != "" && !hostDirs[d] {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+// SandboxEnv returns the minimal environment of a command whose environment would otherwise be
+// environ, i.e., the host environment (os.Environ()) possibly completed by the tool. Only the
+// allowed host variables and the variables whose value was set by the tool, including with Setenv,
+// are kept; the directories of the host are removed from PATH and LD_LIBRARY_PATH, PATH ending
+// with SandboxPath.
+func SandboxEnv(environ []string, allowlist []string) []string {
+	allowed := make(map[string]bool)
+	for _, name := range append(SandboxAllowlist, allowlist...) {
+		allowed[name] = true
+	}
+
+	// Like exec.Cmd, the last value of a variable defined more than once is used
+	values := make(map[string]string)
+	var names []string
+	for _, e := range environ {
+		tokens := strings.SplitN(e, "=", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		if _, ok := values[tokens[0]]; !ok {
+			names = append(names, tokens[0])
+		}
+		values[tokens[0]] = tokens[1]
+	}
+
+	var env []string
+	for _, name := range names {
+		value := values[name]
+		hostValue, onHost := lookupHostEnv(name)
+		switch {
+		case allowed[name]:
+			env = append(env, name+"="+value)
+		case pathListVars[name]:
+			dirs := removeHostDirs(value, hostValue)
+			if name == "PATH" {
+				dirs = append(dirs, SandboxPath)
+			}
+			if len(dirs) > 0 {
+				env = append(env, name+"="+strings.Join(dirs, ":"))
+			}
+		case !onHost || value != hostValue:
+			env = append(env, name+"="+value)
+		}
+	}
+	if _, ok := values["PATH"]; !ok {
+		env = append(env, "PATH="+SandboxPath)
+	}
+	return env
+}
+
+// applySandbox sets the environment of a command to its sandboxed environment when the sandbox is
+// enabled
+func applySandbox(cmd *exec.Cmd) {
+	sandbox.Lock()
+	defer sandbox.Unlock()
+	if !sandbox.enabled {
+		return
+	}
+	environ := cmd.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+	cmd.Env = SandboxEnv(environ, sandbox.allowlist)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+var heartbeat struct {
+	sync.Mutex
+	d time.Duration
+}
+
+// SetHeartbeat specifies the time without output after which a message is logged for all the
+// commands with streamed output executed from now, sys.HeartbeatInterval when 0, and returns the
+// previous one
+func SetHeartbeat(d time.Duration) time.Duration {
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	prev := heartbeat.d
+	heartbeat.d = d
+	return prev
+}
+
+// GetHeartbeat returns the time without output after which a message is logged for the commands
+// with streamed output
+func GetHeartbeat() time.Duration {
+	heartbeat.Lock()
+	defer heartbeat.Unlock()
+	if heartbeat.d <= 0 {
+		return sys.HeartbeatInterval
+	}
+	return heartbeat.d
+}
+
+// logFn is a "function pointer" to write a message to the log, e.g., log.Printf
+type logFn func(string, ...interface{})
+
+// outputStream writes the output of a command to the log line by line while the command is running
+type outputStream struct {
+	mu         sync.Mutex
+	prefix     string
+	lastOutput time.Time
+	log        logFn
+}
+
+// lineWriter is the writer of the stdout or stderr of a command with streamed output: the output
+// is stored in a buffer and each complete line is written to the log
+type lineWriter struct {
+	stream  *outputStream
+	buf     *bytes.Buffer
+	partial []byte
+}
+
+func newOutputStream(prefix string, fn logFn) *outputStream {
+	return &outputStream{prefix: prefix, lastOutput: time.Now(), log: fn}
+}
+
+func (s *outputStream) writer(buf *bytes.Buffer) *lineWriter {
+	return &lineWriter{stream: s, buf: buf}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.stream.mu.Lock()
+	defer w.stream.mu.Unlock()
+
+	w.stream.lastOutput = time.Now()
+	w.buf.Write(p)
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		w.stream.log("%s %s", w.stream.prefix, string(w.partial[:idx]))
+		w.partial = w.partial[idx+1:]
+	}
+	return len(p), nil
+}
+
+// flush writes to the log the last line of the output when it does not end with a new line
+func (w *lineWriter) flush() {
+	w.stream.mu.Lock()
+	defer w.stream.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.stream.log("%s %s", w.stream.prefix, string(w.partial))
+		w.partial = nil
+	}
+}
+
+// checkHeartbeat logs a message when the command did not produce any output for at least interval
+func (s *outputStream) checkHeartbeat(start time.Time, now time.Time, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	silence := now.Sub(s.lastOutput)
+	if silence >= interval {
+		s.log("%s still running after %s, no output for %s", s.prefix, now.Sub(start).Round(time.Second), silence.Round(time.Second))
+	}
+}
+
+// run executes a command, checking every interval whether the command is silent
+func (s *outputStream) run(cmd *exec.Cmd, interval time.Duration) error {
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				s.checkHeartbeat(start, now, interval)
+			}
+		}
+	}()
+
+	err := getRunner().Run(cmd)
+	close(done)
+	for _, w := range []io.Writer{cmd.Stdout, cmd.Stderr} {
+		if lw, ok := w.(*lineWriter); ok {
+			lw.flush()
+		}
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package syexec
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+type testLog struct {
+	lines []string
+}
+
+func (l *testLog) printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestStreamOutput(t *testing.T) {
+	var l testLog
+	var buf bytes.Buffer
+	s := newOutputStream("[make]", l.printf)
+	w := s.writer(&buf)
+	fmt.Fprint(w, "CC  file1.o\nCC  fi")
+	fmt.Fprint(w, "le2.o\nlinking")
+	if len(l.lines) != 2 || l.lines[0] != "[make] CC  file1.o" || l.lines[1] != "[make] CC  file2.o" {
+		t.Fatalf("invalid log: %q", l.lines)
+	}
+	w.flush()
+	if len(l.lines) != 3 || l.lines[2] != "[make] linking" {
+		t.Fatalf("the last line was not flushed: %q", l.lines)
+	}
+	if buf.String() != "CC  file1.o\nCC  file2.o\nlinking" {
+		t.Fatalf("invalid captured output: %q", buf.String())
+	}
+
+	// No heartbeat as long as the command produces output
+	now := time.Now()
+	s.checkHeartbeat(now.Add(-time.Hour), now, time.Minute)
+	if len(l.lines) != 3 {
+		t.Fatalf("unexpected heartbeat: %q", l.lines)
+	}
+	s.checkHeartbeat(now.Add(-time.Hour), now.Add(10*time.Minute), 5*time.Minute)
+	if len(l.lines) != 4 || !strings.HasPrefix(l.lines[3], "[make] still running after 1h10m0s") {
+		t.Fatalf("invalid heartbeat: %q", l.lines)
+	}
+
+	if GetHeartbeat() != sys.HeartbeatInterval {
+		t.Fatalf("default heartbeat is %s instead of %s", GetHeartbeat(), sys.HeartbeatInterval)
+	}
+	defer SetHeartbeat(SetHeartbeat(30 * time.Second))
+	if GetHeartbeat() != 30*time.Second {
+		t.Fatalf("heartbeat is %s instead of 30s", GetHeartbeat())
+	}
+}
+
+func TestRunStreamedCommand(t *testing.T) {
+	var l testLog
+	var stdout, stderr bytes.Buffer
+	s := newOutputStream("[test]", l.printf)
+	cmd := exec.Command("sh", "-c", "echo out; echo err >&2; printf last")
+	cmd.Stdout = s.writer(&stdout)
+	cmd.Stderr = s.writer(&stderr)
+	err := s.run(cmd, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to run command: %s", err)
+	}
+	if stdout.String() != "out\nlast" || stderr.String() != "err\n" {
+		t.Fatalf("invalid output: %q / %q", stdout.String(), stderr.String())
+	}
+	if len(l.lines) != 3 || l.lines[2] != "[test] last" {
+		t.Fatalf("invalid log: %q", l.lines)
+	}
+}
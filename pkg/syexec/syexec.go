@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/internal/pkg/sympierr"
 	"github.com/sylabs/singularity-mpi/pkg/manifest"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
@@ -27,6 +28,8 @@ type Result struct {
 	Stdout string
 	// Stderr is the messages that were displayed on stderr during the execution of the command
 	Stderr string
+	// Cmd is the command line that was executed
+	Cmd string
 }
 
 // SyCmd represents a command to be executed
@@ -37,6 +40,15 @@ type SyCmd struct {
 	// Timeout is the maximum time a command can run
 	Timeout time.Duration
 
+	// StreamPrefix, when not empty, makes the output of the command written to the log line by
+	// line while the command is running, each line being prefixed by StreamPrefix, e.g., [make];
+	// the entire output is still available in the result
+	StreamPrefix string
+
+	// Heartbeat is the time without output after which a message is logged when the output is
+	// streamed, the heartbeat set with SetHeartbeat (sys.HeartbeatInterval by default) when 0
+	Heartbeat time.Duration
+
 	// BinPath is the path to the binary to execute
 	BinPath string
 
@@ -49,6 +61,14 @@ type SyCmd struct {
 	// Env is a slice of string representing the environment to be used with the command
 	Env []string
 
+	// Container, when not nil, is the container in which the command is executed instead of the
+	// host, e.g., the builder container (see NewBuilderContainer)
+	Container *Container
+
+	// Limited specifies whether the command is a build to which the resource limits apply, e.g.,
+	// make (see SetLimits)
+	Limited bool
+
 	// Ctx is the context of the command to execute to submit a job
 	Ctx context.Context
 
@@ -81,19 +101,53 @@ func (c *SyCmd) Run() Result {
 	defer cancel()
 
 	var stderr, stdout bytes.Buffer
+	var stream *outputStream
+	// The timeout and the streaming of the output only apply to the commands we create
+	withTimeout := c.Cmd == nil
 	if c.Cmd == nil {
-		c.Cmd = exec.CommandContext(ctx, c.BinPath, c.CmdArgs...)
+		bin, args, env := c.BinPath, c.CmdArgs, c.Env
+		if c.Container != nil {
+			bin, args, env = c.Container.Wrap(c.BinPath, c.CmdArgs, c.ExecDir, env)
+		}
+		if c.Limited {
+			bin, args = wrapLimits(bin, args)
+		}
+		c.Cmd = exec.CommandContext(ctx, bin, args...)
 		c.Cmd.Dir = c.ExecDir
+		if len(env) > 0 {
+			c.Cmd.Env = env
+		}
 		c.Cmd.Stdout = &stdout
 		c.Cmd.Stderr = &stderr
+		if c.StreamPrefix != "" {
+			stream = newOutputStream(c.StreamPrefix, log.Printf)
+			c.Cmd.Stdout = stream.writer(&stdout)
+			c.Cmd.Stderr = stream.writer(&stderr)
+		}
 	}
 
-	log.Printf("-> Running %s %s\n", c.BinPath, strings.Join(c.CmdArgs, " "))
-	err := c.Cmd.Run()
+	applySandbox(c.Cmd)
+	res.Cmd = strings.Join(c.Cmd.Args, " ")
+	log.Printf("-> Running %s\n", res.Cmd)
+	var err error
+	start := time.Now()
+	if stream != nil {
+		heartbeat := c.Heartbeat
+		if heartbeat == 0 {
+			heartbeat = GetHeartbeat()
+		}
+		err = stream.run(c.Cmd, heartbeat)
+	} else {
+		err = getRunner().Run(c.Cmd)
+	}
+	Record(c.Cmd, start, err)
 	res.Stderr = stderr.String()
 	res.Stdout = stdout.String()
 	if err != nil {
 		res.Err = err
+		if withTimeout && ctx.Err() == context.DeadlineExceeded {
+			res.Err = sympierr.Wrap(sympierr.ErrTimeout, err, "%s did not complete within %d minutes", c.BinPath, cmdTimeout)
+		}
 		return res
 	}
 
@@ -116,6 +170,14 @@ func (c *SyCmd) Run() Result {
 			data = append(data, "Execution path: "+c.ExecDir)
 			data = append(data, "Execution time: "+currentTime.Format("2006-01-02 15:04:05"))
 			data = append(data, c.ManifestData...)
+			if c.Container != nil {
+				data = append(data, "Container: "+c.Container.Image)
+			}
+			if IsSandboxed() {
+				for _, e := range RedactEnv(c.Cmd.Env) {
+					data = append(data, "Environment: "+e)
+				}
+			}
 
 			// We transform relative paths into absolute path
 			if c.BinPath[0] == '.' && c.BinPath[1] == '/' {
@@ -0,0 +1,394 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/checker"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// osReleaseFile is the file describing the Linux distribution of the host
+	osReleaseFile = "/etc/os-release"
+)
+
+// bundle is a directory or a tarball gathering everything needed to reproduce a run
+type bundle struct {
+	// target is the directory or tarball (.tar, .tar.gz or .tgz) where the bundle is exported
+	target string
+
+	// dir is the directory where the content of the bundle is gathered
+	dir string
+
+	// compress specifies whether the tarball is compressed, it is only used when exporting to a tarball
+	compress bool
+
+	// tarball specifies whether the bundle is exported to a tarball
+	tarball bool
+}
+
+func newBundle(target string) (*bundle, error) {
+	b := new(bundle)
+	b.target = target
+
+	switch {
+	case strings.HasSuffix(target, ".tar.gz") || strings.HasSuffix(target, ".tgz"):
+		b.compress = true
+		b.tarball = true
+	case strings.HasSuffix(target, ".tar"):
+		b.tarball = true
+	}
+
+	if util.PathExists(target) {
+		entries, err := ioutil.ReadDir(target)
+		if b.tarball || err != nil || len(entries) > 0 {
+			return nil, fmt.Errorf("%s already exists", target)
+		}
+	}
+
+	if b.tarball {
+		tempDir, err := ioutil.TempDir("", "sympi-bundle-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary directory: %s", err)
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(filepath.Base(target), ".tgz"), ".gz"), ".tar")
+		b.dir = filepath.Join(tempDir, name)
+	} else {
+		b.dir = target
+	}
+
+	err := os.MkdirAll(b.dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %s", b.dir, err)
+	}
+
+	return b, nil
+}
+
+// writeFile creates a file in the bundle
+func (b *bundle) writeFile(name string, content string) error {
+	path := filepath.Join(b.dir, name)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err)
+	}
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// copyFile copies a file in the bundle
+func (b *bundle) copyFile(src string, name string) error {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", src, err)
+	}
+	return b.writeFile(name, string(content))
+}
+
+// copyDir copies the regular files of a directory, which match a filter, in a directory of the bundle
+func (b *bundle) copyDir(src string, name string, filter func(string) bool) error {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", src, err)
+	}
+	for _, e := range entries {
+		if !e.Mode().IsRegular() || !filter(e.Name()) {
+			continue
+		}
+		err = b.copyFile(filepath.Join(src, e.Name()), filepath.Join(name, e.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close exports the bundle to its target, it creates the tarball if necessary
+func (b *bundle) close() error {
+	if !b.tarball {
+		return nil
+	}
+	defer os.RemoveAll(filepath.Dir(b.dir))
+
+	tarPath, err := exec.LookPath("tar")
+	if err != nil {
+		return fmt.Errorf("tar is not available: %s", err)
+	}
+
+	target, err := filepath.Abs(b.target)
+	if err != nil {
+		return fmt.Errorf("failed to get the absolute path of %s: %s", b.target, err)
+	}
+	flags := "-cf"
+	if b.compress {
+		flags = "-czf"
+	}
+	var cmd syexec.SyCmd
+	cmd.BinPath = tarPath
+	cmd.CmdArgs = []string{flags, target, "-C", filepath.Dir(b.dir), filepath.Base(b.dir)}
+	res := cmd.Run()
+	if res.Err != nil {
+		return fmt.Errorf("failed to create %s: %s (stdout: %s; stderr: %s)", target, res.Err, res.Stdout, res.Stderr)
+	}
+	return nil
+}
+
+// getBundleEnv returns the environment variables relevant to reproduce a run; other variables
+// may contain sensitive data
+func getBundleEnv(environ []string) []string {
+	return syexec.FilterEnv(environ)
+}
+
+// registryPublicFields are the fields of the registries of the configuration kept in the bundles,
+// the other ones describing the credentials used to log in
+var registryPublicFields = map[string]bool{"Name": true, "URL": true}
+
+// redactConfigValue replaces the secret values of a configuration serialized to JSON, i.e., the
+// values whose name suggests a secret or URLs with a password, e.g., a proxy, with syexec.Redacted
+func redactConfigValue(name string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v != "" && syexec.IsSecretEnvVar(name, v) {
+			return syexec.Redacted
+		}
+	case map[string]interface{}:
+		for k, kv := range v {
+			v[k] = redactConfigValue(k, kv)
+		}
+	case []interface{}:
+		for i, iv := range v {
+			v[i] = redactConfigValue(name, iv)
+		}
+	}
+	return value
+}
+
+// getBundleConfig returns the configuration of the tool saved in a bundle, which is meant to be
+// shared: the credentials of the registries and all the secret values are redacted
+func getBundleConfig(sysCfg *sys.Config) (string, error) {
+	content, err := json.Marshal(sysCfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize the configuration: %s", err)
+	}
+	var cfg map[string]interface{}
+	err = json.Unmarshal(content, &cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the serialized configuration: %s", err)
+	}
+	if registries, ok := cfg["Registries"].(map[string]interface{}); ok {
+		for _, r := range registries {
+			fields, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for k, v := range fields {
+				if s, ok := v.(string); ok && s != "" && !registryPublicFields[k] {
+					fields[k] = syexec.Redacted
+				}
+			}
+		}
+	}
+	redactConfigValue("", cfg)
+	content, err = json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize the configuration: %s", err)
+	}
+	return string(content), nil
+}
+
+// getHostSnapshot returns a description of the host: platform, system checks and Linux distribution
+func getHostSnapshot() string {
+	hostname, _ := os.Hostname()
+	snapshot := fmt.Sprintf("Hostname: %s\nPlatform: %s/%s\nCPUs: %d\n", hostname, runtime.GOOS, runtime.GOARCH, runtime.NumCPU())
+	out, err := exec.Command("uname", "-a").Output()
+	if err == nil {
+		snapshot += "Kernel: " + string(out)
+	}
+	snapshot += "\nSystem configuration:\n" + checker.RunSystemChecks().String()
+	return snapshot
+}
+
+// save gathers the details of a run in the bundle: configuration, definition files, manifests,
+// host snapshot, command lines, environment and results
+func (b *bundle) save(run *runDetails, runErr error, sysCfg *sys.Config) error {
+	status := "PASS"
+	if runErr != nil {
+		status = "FAIL: " + runErr.Error()
+	}
+	container := run.containerDesc
+	if run.appName != "" {
+		container += " (application: " + run.appName + ")"
+	}
+	if len(run.appArgs) > 0 {
+		container += "\nApplication arguments: " + strings.Join(run.appArgs, " ")
+	}
+	mode := sys.RuntimeModeNative
+	if sysCfg.IsOCIMode() {
+		mode = sys.RuntimeModeOCI
+	}
+	summary := fmt.Sprintf("Container: %s\nImage: %s\nContainer MPI: %s %s\nHost MPI: %s %s\nRuntime mode: %s\nDate: %s\nStatus: %s\n",
+		container, run.imgPath, run.containerMPI.ID, run.containerMPI.Version, run.hostMPI.ID, run.hostMPI.Version,
+		mode, time.Now().Format(time.RFC3339), status)
+	cmdline := fmt.Sprintf("SyMPI command: %s\nLaunch command: %s\n", strings.Join(os.Args, " "), run.execRes.Cmd)
+	files := map[string]string{
+		"summary.txt":        summary,
+		"cmdline.txt":        cmdline,
+		"environment.txt":    strings.Join(getBundleEnv(os.Environ()), "\n") + "\n",
+		"host.txt":           getHostSnapshot(),
+		"results/stdout.txt": run.execRes.Stdout,
+		"results/stderr.txt": run.execRes.Stderr,
+	}
+	// All the commands executed so far, including the installation of MPI when needed
+	if ledger := syexec.GetLedger(); ledger != "" && util.FileExists(ledger) {
+		entries, err := syexec.LoadLedger(ledger)
+		if err != nil {
+			return err
+		}
+		files["ledger.txt"] = syexec.FormatLedger(entries)
+	}
+	for name, content := range files {
+		err := b.writeFile(name, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Resolved configuration of the tool and configuration files
+	cfg, err := getBundleConfig(sysCfg)
+	if err != nil {
+		return err
+	}
+	err = b.writeFile(filepath.Join("config", "sysconfig.json"), cfg)
+	if err != nil {
+		return err
+	}
+	isConfigFile := func(name string) bool {
+		return strings.HasSuffix(name, ".conf") || strings.HasSuffix(name, ".yaml")
+	}
+	if sysCfg.EtcDir != "" && util.IsDir(sysCfg.EtcDir) {
+		err = b.copyDir(sysCfg.EtcDir, filepath.Join("config", "etc"), isConfigFile)
+		if err != nil {
+			return err
+		}
+	}
+	if sysCfg.SyConfigFile != "" && util.FileExists(sysCfg.SyConfigFile) && filepath.Dir(sysCfg.SyConfigFile) != sysCfg.EtcDir {
+		err = b.copyFile(sysCfg.SyConfigFile, filepath.Join("config", filepath.Base(sysCfg.SyConfigFile)))
+		if err != nil {
+			return err
+		}
+	}
+	// The placement of the ranks is part of the run
+	if sysCfg.Hostfile != "" {
+		err = b.copyFile(sysCfg.Hostfile, filepath.Join("config", "hostfile"))
+		if err != nil {
+			return err
+		}
+	}
+	if sysCfg.Rankfile != "" {
+		err = b.copyFile(sysCfg.Rankfile, filepath.Join("config", "rankfile"))
+		if err != nil {
+			return err
+		}
+	}
+	if util.FileExists(osReleaseFile) {
+		err = b.copyFile(osReleaseFile, filepath.Join("host", filepath.Base(osReleaseFile)))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Definition files and manifests of the container, the image itself is not included
+	if run.imgPath != "" {
+		err = b.copyDir(filepath.Dir(run.imgPath), "container", func(name string) bool {
+			return !strings.HasSuffix(name, ".sif")
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Manifests of MPI and Singularity on the host
+	isManifest := func(name string) bool {
+		return strings.HasSuffix(name, ".MANIFEST")
+	}
+	if run.hostInstallDir != "" && util.IsDir(run.hostInstallDir) {
+		err = b.copyDir(run.hostInstallDir, "mpi", isManifest)
+		if err != nil {
+			return err
+		}
+	}
+	if sysCfg.SingularityBin != "" {
+		syInstallDir := filepath.Dir(filepath.Dir(sysCfg.SingularityBin))
+		if util.IsDir(syInstallDir) {
+			err = b.copyDir(syInstallDir, "singularity", isManifest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The result uses the same format as the results of the experiments
+	r := results.Result{HostMPI: run.hostMPI, ContainerMPI: run.containerMPI, Pass: runErr == nil, AppArgs: run.appArgs,
+		Bench: run.bench, Container: run.containerDesc, AppName: run.appName, LaunchArgs: run.args}
+	if sysCfg.IsOCIMode() {
+		r.RuntimeMode = sys.RuntimeModeOCI
+	}
+	resultFile := filepath.Join(b.dir, "results", "result.txt")
+	err = results.Save(resultFile, []results.Result{r})
+	if err != nil {
+		return err
+	}
+	_, err = results.SaveSummary(resultFile, []results.Result{r})
+	return err
+}
+
+// RunContainerWithBundle executes a container like RunContainer and exports a bundle with
+// everything needed to reproduce the run, whether it succeeded or not, into a directory or a
+// tarball (when target ends with .tar, .tar.gz or .tgz). appName is the name of the application to
+// execute in a multi-app container, empty for the default one, and appArgs its arguments.
+func RunContainerWithBundle(containerDesc string, appName string, args []string, appArgs []string, target string, sysCfg *sys.Config) error {
+	b, err := newBundle(target)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %s", err)
+	}
+
+	run := runDetails{containerDesc: containerDesc, appName: appName, args: args, appArgs: appArgs}
+	runErr := runContainer(&run, sysCfg)
+
+	err = b.save(&run, runErr, sysCfg)
+	if err == nil {
+		err = b.close()
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to export bundle to %s: %s", target, err)
+		if runErr != nil {
+			// The failure of the run is the most important error
+			log.Printf("[ERROR] %s\n", err)
+			return runErr
+		}
+		return err
+	}
+	fmt.Printf("Reproducibility bundle: %s\n", target)
+
+	return runErr
+}
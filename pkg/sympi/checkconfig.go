@@ -0,0 +1,381 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/internal/pkg/mpich"
+	"github.com/sylabs/singularity-mpi/internal/pkg/network"
+	"github.com/sylabs/singularity-mpi/internal/pkg/slurm"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/builder"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/containerizer"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/launcher"
+	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/remote"
+	"github.com/sylabs/singularity-mpi/pkg/scheduler"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+	"github.com/sylabs/singularity-mpi/pkg/toolchain"
+)
+
+const (
+	// urlCheckTimeout is the maximum time to wait for a server when checking whether a URL is reachable
+	urlCheckTimeout = 30 * time.Second
+
+	// ifnetPlaceholder is the beginning of the value of the network interface in the default OFI
+	// configuration file, which must be replaced by an actual interface
+	ifnetPlaceholder = "<"
+)
+
+// sourceURLSchemes are the schemes of the URLs of the source code of MPI and Singularity
+var sourceURLSchemes = []string{"http://", "https://", "ftp://", "file://"}
+
+// imageURLSchemes are the schemes of the URLs of pre-built images
+var imageURLSchemes = []string{"library://", "docker://", "oras://", "shub://"}
+
+func hasScheme(url string, schemes []string) bool {
+	for _, s := range schemes {
+		if strings.HasPrefix(url, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// getToolSchema returns the schema of the tool's configuration file
+func getToolSchema() configparser.Schema {
+	schema := configparser.Schema{
+		{Name: sy.BuildPrivilegeKey, Validate: configparser.ValidateBool},
+		{Name: sy.NoPrivKey, Validate: configparser.ValidateBool},
+		{Name: sy.SudoCmdsKey},
+		{Name: sy.SignKeyFingerprintKey},
+		{Name: sy.VerifyPolicyKey, Validate: container.ValidateVerifyPolicy},
+		{Name: sy.ISAPolicyKey, Validate: launcher.ValidateISAPolicy},
+		{Name: sy.ToolchainKey, Validate: toolchain.Validate},
+		{Name: sy.ToolchainDirKey},
+		{Name: sy.BuildProfileKey},
+		{Name: sy.BuildProfileKeyPrefix, Prefix: true},
+		{Name: sy.DownloadRateLimitKey, Validate: buildenv.ValidateRateLimit},
+		{Name: sy.ProxyKey, Validate: sys.ValidateProxy},
+		{Name: sy.NoProxyKey},
+		{Name: sy.CAFileKey, Validate: sys.ValidateCAFile},
+		{Name: sy.ContainerRuntimeKey, Validate: sys.ValidateContainerRuntime},
+		{Name: sy.ContainerMPIPrefixKey, Validate: container.ValidateMPIPrefix},
+		{Name: sy.BuilderImageKey, Validate: container.ValidateBuilderImage},
+		{Name: sy.SandboxEnvKey, Validate: configparser.ValidateBool},
+		{Name: sy.FeatureProbesKey, Validate: configparser.ValidateBool},
+		{Name: sy.EnvAllowlistKey},
+		{Name: sy.MakeJobsKey, Validate: launcher.ValidateBuildLimit(sy.MakeJobsKey)},
+		{Name: sy.BuildNiceKey, Validate: launcher.ValidateBuildLimit(sy.BuildNiceKey)},
+		{Name: sy.BuildIONiceKey, Validate: launcher.ValidateBuildLimit(sy.BuildIONiceKey)},
+		{Name: sy.BuildMemoryMaxKey, Validate: launcher.ValidateBuildLimit(sy.BuildMemoryMaxKey)},
+		{Name: sy.BuildCPUQuotaKey, Validate: launcher.ValidateBuildLimit(sy.BuildCPUQuotaKey)},
+		{Name: sy.HeartbeatKey, Validate: launcher.ValidateHeartbeat},
+		{Name: sy.ScratchGCKey, Validate: buildenv.ValidateScratchGCPolicy},
+		{Name: sy.ScratchMaxAgeKey, Validate: buildenv.ValidateScratchMaxAge},
+		{Name: sy.ScratchRootKey, Validate: buildenv.ValidateScratchRoot},
+		{Name: sy.ScratchMinFreeKey, Validate: buildenv.ValidateScratchMinFree},
+		{Name: sy.BaseImageAutobuildKey, Validate: configparser.ValidateBool},
+		{Name: sy.RuntimeModeKey, Validate: sys.ValidateRuntimeMode},
+		{Name: sy.RegistryKeyPrefix, Prefix: true},
+		{Name: sy.TuningKeyPrefix, Prefix: true},
+		{Name: mpi.LauncherKey, Validate: mpi.ValidateLaunchTemplate},
+		{Name: mpi.HostfileKey, Validate: mpi.ValidateHostfile},
+		{Name: mpi.RankfileKey, Validate: mpi.ValidateHostfile},
+		{Name: slurm.EnabledKey, Validate: configparser.ValidateBool},
+		{Name: slurm.PartitionKey},
+		{Name: network.IfnetKey},
+		{Name: network.IBForceKey, Validate: configparser.ValidateBool},
+		{Name: network.MXMDirKey},
+		{Name: network.KNEMDirKey},
+		{Name: network.UCXDirKey},
+		{Name: mpich.DeviceKey},
+		{Name: remote.HostKey},
+		{Name: remote.UserKey},
+		{Name: remote.WorkdirKey},
+		{Name: remote.SSHOptionsKey},
+	}
+	for _, op := range sy.SudoOperations {
+		schema = append(schema, configparser.KeySpec{Name: sy.GetSudoKey(op), Validate: sy.ValidateSudoPolicy})
+	}
+	for _, key := range builder.GetHookKeys() {
+		schema = append(schema, configparser.KeySpec{Name: key})
+	}
+	return schema
+}
+
+// SetToolConfig checks and sets the value of a key of the tool's configuration file, e.g.,
+// sudo_build = never; the change is recorded in the history of the configuration
+func SetToolConfig(key string, value string, sysCfg *sys.Config) error {
+	problems := getToolSchema().Check([]kv.KV{{Key: key, Value: value}})
+	if len(problems) > 0 {
+		return problems[0]
+	}
+	return sy.ConfigFileUpdateEntry(sysCfg.SyConfigFile, key, value)
+}
+
+// checkTool checks the content of the tool's configuration file
+func checkTool(kvs []kv.KV) []error {
+	problems := getToolSchema().Check(kvs)
+	_, err := sy.LoadRegistries(kvs)
+	if err != nil {
+		problems = append(problems, err)
+	}
+	_, err = sy.LoadTuningProfiles(kvs)
+	if err != nil {
+		problems = append(problems, err)
+	}
+	profiles, err := sy.LoadBuildProfiles(kvs)
+	if err != nil {
+		problems = append(problems, err)
+	}
+	for name, p := range profiles {
+		_, err = toolchain.Get(p.Toolchain, p.ToolchainDir)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("invalid toolchain of build profile %s: %s", name, err))
+		}
+	}
+	if name := kv.GetValue(kvs, sy.BuildProfileKey); name != "" {
+		if _, ok := profiles[name]; !ok {
+			problems = append(problems, fmt.Errorf("unknown build profile %s", name))
+		}
+	}
+	return problems
+}
+
+// checkVersions checks the content of a configuration file listing the versions of a software
+// and the URL of the associated source code
+func checkVersions(kvs []kv.KV) []error {
+	var problems []error
+	for _, e := range kvs {
+		if strings.ContainsAny(e.Key, " \t") {
+			problems = append(problems, fmt.Errorf("invalid version %s", e.Key))
+		}
+		if !hasScheme(e.Value, sourceURLSchemes) {
+			problems = append(problems, fmt.Errorf("invalid URL %s for version %s, it should start with %s", e.Value, e.Key, strings.Join(sourceURLSchemes, ", ")))
+		}
+	}
+	return problems
+}
+
+// checkRegistry checks the content of a configuration file listing the versions of MPI and the
+// URL of the associated pre-built image
+func checkRegistry(kvs []kv.KV) []error {
+	var problems []error
+	for _, e := range kvs {
+		if !hasScheme(e.Value, imageURLSchemes) {
+			problems = append(problems, fmt.Errorf("invalid image URL %s for version %s, it should start with %s", e.Value, e.Key, strings.Join(imageURLSchemes, ", ")))
+		}
+	}
+	return problems
+}
+
+// checkNetwork checks the content of the OFI configuration file
+func checkNetwork(kvs []kv.KV) []error {
+	var problems []error
+	for _, e := range kvs {
+		if strings.HasPrefix(e.Value, ifnetPlaceholder) {
+			problems = append(problems, fmt.Errorf("%s is not set, replace %s with an actual value", e.Key, e.Value))
+		}
+	}
+	return problems
+}
+
+// checkVersionExists checks whether a version of MPI or Singularity is listed in the
+// configuration file of the software
+func checkVersionExists(id string, version string, sysCfg *sys.Config) error {
+	cfgFile := singularityConfigFile
+	if id != implem.SY {
+		cfgFile = mpiplugin.Get(id).ConfigFileName()
+	}
+	path := filepath.Join(sysCfg.EtcDir, cfgFile)
+	kvs, err := configparser.Load(path)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %s", path, err)
+	}
+	if !kv.KeyExists(kvs, version) {
+		return fmt.Errorf("%s:%s is not defined in %s", id, version, configparser.GetConfigFilePath(path))
+	}
+	return nil
+}
+
+// checkApp checks the content of an application's configuration file, including whether the MPI
+// used in the container is defined
+func checkApp(kvs []kv.KV, sysCfg *sys.Config) []error {
+	problems := containerizer.CheckAppConfig(kvs)
+	if mpiID := kv.GetValue(kvs, "mpi"); mpiID != "" {
+		tokens := strings.Split(mpiID, ":")
+		if len(tokens) != 2 {
+			problems = append(problems, fmt.Errorf("invalid MPI %s, it should be of the form <implementation>:<version>", mpiID))
+		} else if err := checkVersionExists(tokens[0], tokens[1], sysCfg); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// checkExperiments checks a file describing experiments, including whether the versions of MPI
+// and Singularity they use are defined
+func checkExperiments(path string, sysCfg *sys.Config) []error {
+	exps, problems := scheduler.CheckExperiments(path)
+	for _, e := range exps {
+		if !e.IsStandalone() {
+			for _, m := range []implem.Info{e.HostMPI, e.ContainerMPI} {
+				if err := checkVersionExists(m.ID, m.Version, sysCfg); err != nil {
+					problems = append(problems, fmt.Errorf("experiment %s: %s", e.String(), err))
+				}
+			}
+		}
+		if e.Singularity.Version != "" {
+			if err := checkVersionExists(implem.SY, e.Singularity.Version, sysCfg); err != nil {
+				problems = append(problems, fmt.Errorf("experiment %s: %s", e.String(), err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkURL checks whether a URL is reachable; only http(s) and file URLs are checked
+func checkURL(url string) error {
+	if strings.HasPrefix(url, "file://") {
+		if !util.PathExists(strings.TrimPrefix(url, "file://")) {
+			return fmt.Errorf("%s does not exist", url)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil
+	}
+
+	client := sys.NewHTTPClient(urlCheckTimeout)
+	resp, err := client.Head(url)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		// Some servers do not support HEAD requests
+		resp, err = client.Get(url)
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not reachable: %s", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s is not reachable: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// checkURLs checks whether the URLs of the source code specified in a configuration file are reachable
+func checkURLs(section string, kvs []kv.KV) []error {
+	var problems []error
+	for _, e := range kvs {
+		if section == configparser.SectionApp && e.Key != "app_url" && !strings.HasSuffix(e.Key, ".app_url") {
+			continue
+		}
+		if err := checkURL(e.Value); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// checkSection checks the key/value pairs of a section of a configuration file
+func checkSection(section string, kvs []kv.KV, withURLs bool, sysCfg *sys.Config) []error {
+	var problems []error
+	switch section {
+	case configparser.SectionTool:
+		problems = checkTool(kvs)
+	case configparser.SectionVersions:
+		problems = checkVersions(kvs)
+	case configparser.SectionRegistry:
+		problems = checkRegistry(kvs)
+	case configparser.SectionNetwork:
+		problems = checkNetwork(kvs)
+	case configparser.SectionApp:
+		problems = checkApp(kvs, sysCfg)
+	}
+	if withURLs && (section == configparser.SectionVersions || section == configparser.SectionApp) {
+		problems = append(problems, checkURLs(section, kvs)...)
+	}
+	return problems
+}
+
+// isExperimentsFile checks whether a configuration file describes experiments, i.e., its lines
+// are not key/value pairs
+func isExperimentsFile(path string) bool {
+	if configparser.IsYAMLFile(path) {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	for s := bufio.NewScanner(f); s.Scan(); {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return !strings.Contains(line, "=")
+	}
+	return false
+}
+
+// CheckConfigFile checks a configuration file, i.e., the tool's configuration file, a file
+// listing versions of MPI or Singularity, a registry file, the OFI configuration file, an
+// application's configuration file or a file describing experiments, and returns all the
+// problems found. The kind of the file is figured out from its name and content. When checkURLs
+// is true, the URLs of the source code are also checked for reachability.
+func CheckConfigFile(path string, checkURLs bool, sysCfg *sys.Config) ([]error, error) {
+	if !util.FileExists(path) {
+		return nil, fmt.Errorf("%s does not exist", path)
+	}
+
+	if isExperimentsFile(path) {
+		return checkExperiments(path, sysCfg), nil
+	}
+
+	if !configparser.IsYAMLFile(path) {
+		kvs, err := kv.LoadKeyValueConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %s", path, err)
+		}
+		return checkSection(configparser.GetSection(path, kvs), kvs, checkURLs, sysCfg), nil
+	}
+
+	cfg, err := configparser.LoadYAMLConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var problems []error
+	for _, section := range []string{configparser.SectionTool, configparser.SectionVersions, configparser.SectionRegistry, configparser.SectionNetwork, configparser.SectionApp} {
+		kvs := cfg.GetSectionKV(section)
+		if section == configparser.SectionTool {
+			// The keys of the remote section are checked with the tool's settings
+			kvs = append(kvs, cfg.GetSectionKV(configparser.SectionRemote)...)
+		}
+		if len(kvs) == 0 {
+			continue
+		}
+		for _, p := range checkSection(section, kvs, checkURLs, sysCfg) {
+			problems = append(problems, fmt.Errorf("%s: %s", section, p))
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestToolSchema(t *testing.T) {
+	// The keys of the tool's configuration file are the *Key and *KeyPrefix constants of pkg/sy, a
+	// prefix being in the schema either as is or through the keys it starts, e.g., sudo_build
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, filepath.Join("..", "sy"), func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("failed to parse pkg/sy: %s", err)
+	}
+	keys := make(map[string]string)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.CONST {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					for i, name := range spec.(*ast.ValueSpec).Names {
+						values := spec.(*ast.ValueSpec).Values
+						if !name.IsExported() || !(strings.HasSuffix(name.Name, "Key") || strings.HasSuffix(name.Name, "KeyPrefix")) || i >= len(values) {
+							continue
+						}
+						lit, ok := values[i].(*ast.BasicLit)
+						if ok && lit.Kind == token.STRING {
+							keys[name.Name] = strings.Trim(lit.Value, "\"")
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(keys) == 0 {
+		t.Fatalf("no key found in pkg/sy")
+	}
+
+	schema := getToolSchema()
+	for name, key := range keys {
+		found := false
+		for _, spec := range schema {
+			if spec.Name == key || (strings.HasSuffix(name, "KeyPrefix") && strings.HasPrefix(spec.Name, key)) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("sy.%s (%s) is not in the schema of the tool's configuration file", name, key)
+		}
+	}
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-checkconfig-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var sysCfg sys.Config
+	sysCfg.EtcDir = dir
+	err = ioutil.WriteFile(filepath.Join(dir, sys.GetMPIConfigFileName("openmpi")), []byte("4.0.2=https://download.open-mpi.org/release/open-mpi/v4.0/openmpi-4.0.2.tar.bz2\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create configuration file: %s", err)
+	}
+
+	tests := []struct {
+		filename string
+		content  string
+		expected []string
+	}{
+		{
+			filename: "sympi_mpich.conf",
+			content:  "3.3.2=https://www.mpich.org/static/downloads/3.3.2/mpich-3.3.2.tar.gz\n",
+		},
+		{
+			filename: "sympi_mpich.yaml",
+			content:  "versions:\n  \"3.3.2\": www.mpich.org/mpich-3.3.2.tar.gz\n",
+			expected: []string{"versions: invalid URL www.mpich.org/mpich-3.3.2.tar.gz"},
+		},
+		{
+			filename: "singularity-mpi.conf",
+			content:  "build_privilege=maybe\nforce_unprivileged=false\nsandbox_envs=true\n",
+			expected: []string{"invalid value of build_privilege", "unknown key sandbox_envs, did you mean sandbox_env?"},
+		},
+		{
+			filename: "singularity-mpi.conf",
+			content:  "heartbeat=-5m\nsandbox_env=true\n",
+			expected: []string{"invalid value of heartbeat"},
+		},
+		{
+			filename: "netpipe.conf",
+			content:  "app_name=netpipe\napp_url=http://bitspjoule.org/netpipe/code/NetPIPE-5.1.4.tar.gz\napp_exe=NPmpi\nmpi_model=hybrid\nmpi=openmpi:4.0.9\nregistery=oras://ghcr.io/user\n",
+			expected: []string{"unknown key registery, did you mean registry?", "openmpi:4.0.9 is not defined"},
+		},
+		{
+			filename: "experiments.txt",
+			content:  "# Experiments\nopenmpi:4.0.2 openmpi:4.0.2\nopenmpi:4.0.2 openmpi:3.1.4\nopenmpi:4.0.2\n",
+			expected: []string{"experiment openmpi:4.0.2 openmpi:3.1.4: openmpi:3.1.4 is not defined", "line 4: invalid experiment"},
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.filename)
+		err := ioutil.WriteFile(path, []byte(tt.content), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+		problems, err := CheckConfigFile(path, false, &sysCfg)
+		if err != nil {
+			t.Fatalf("failed to check %s: %s", path, err)
+		}
+		if len(problems) != len(tt.expected) {
+			t.Fatalf("%d problem(s) found in %s instead of %d: %v", len(problems), tt.filename, len(tt.expected), problems)
+		}
+		for _, e := range tt.expected {
+			found := false
+			for _, p := range problems {
+				if strings.Contains(p.Error(), e) {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("problem '%s' not reported for %s: %v", e, tt.filename, problems)
+			}
+		}
+	}
+}
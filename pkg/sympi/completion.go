@@ -0,0 +1,276 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/mpiplugin"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// ShellBash is the identifier of bash for the completion scripts and shell hooks
+	ShellBash = "bash"
+
+	// ShellZsh is the identifier of zsh for the completion scripts and shell hooks
+	ShellZsh = "zsh"
+
+	// SYMPI_ENVFILE_ENV is the name of the environment variable set by sympi_init to specify
+	// the environment file of the shell
+	SYMPI_ENVFILE_ENV = "SYMPI_ENVFILE"
+
+	// binTag is the tag used to refer to the sympi binary in the scripts
+	binTag = "SYMPIBIN"
+
+	// optionsTag is the tag used to refer to the list of options of sympi in the completion scripts
+	optionsTag = "SYMPIOPTIONS"
+
+	// wordOptionsTag is the tag used to refer to the options completed by sympi in the completion scripts
+	wordOptionsTag = "SYMPIWORDOPTIONS"
+
+	// fileOptionsTag is the tag used to refer to the options expecting a file in the completion scripts
+	fileOptionsTag = "SYMPIFILEOPTIONS"
+)
+
+// wordsFn is a "function pointer" returning the possible values of an option
+type wordsFn func(*sys.Config) []string
+
+func staticWords(words ...string) wordsFn {
+	return func(*sys.Config) []string {
+		return words
+	}
+}
+
+// completionWords maps the options of sympi to the function returning their possible values
+var completionWords = map[string]wordsFn{
+	"-load":             getLoadableSoftware,
+	"-unload":           staticWords("mpi", "singularity", RoleCompile, RoleRun),
+	"-role":             staticWords(RoleCompile, RoleRun, RoleAll),
+	"-uninstall":        getInstalledMPIs,
+	"-install":          getAvailableSoftware,
+	"-run":              getInstalledContainers,
+	"run":               getInstalledContainers,
+	"-probe":            getInstalledContainers,
+	"-deps":             getInstalledContainers,
+	"-show-ledger":      staticWords("last"),
+	"-export":           getInstalledContainers,
+	"-export-mpi":       getInstalledMPIs,
+	"-list":             staticWords("singularity", "mpi", "container"),
+	"-config":           staticWords("paths", "show", "history", "revert"),
+	"-completion":       staticWords(ShellBash, ShellZsh),
+	"-shell-hook":       staticWords(ShellBash, ShellZsh),
+	"-workspace":        getWorkspaces,
+	"-delete-workspace": getWorkspaces,
+}
+
+// completionFileOptions is the list of the options of sympi expecting a path
+var completionFileOptions = []string{"-import", "-export-tests", "-convert-config", "-check-config", "-bundle", "-import-mpi"}
+
+const bashCompletionTemplate = `# bash completion for sympi, generated by 'sympi -completion bash'
+_sympi() {
+	local cur prev
+	if declare -F _get_comp_words_by_ref >/dev/null; then
+		_get_comp_words_by_ref -n : cur prev
+	else
+		cur="${COMP_WORDS[COMP_CWORD]}"
+		prev="${COMP_WORDS[COMP_CWORD-1]}"
+	fi
+
+	case "${prev}" in
+	SYMPIWORDOPTIONS)
+		COMPREPLY=($(compgen -W "$(SYMPIBIN -completion-words ${prev} 2>/dev/null)" -- "${cur}"))
+		;;
+	SYMPIFILEOPTIONS)
+		COMPREPLY=($(compgen -f -- "${cur}"))
+		;;
+	*)
+		COMPREPLY=($(compgen -W "SYMPIOPTIONS" -- "${cur}"))
+		;;
+	esac
+
+	# Versions are specified as <software>:<version> and ':' is a word separator for bash
+	if declare -F __ltrim_colon_completions >/dev/null; then
+		__ltrim_colon_completions "${cur}"
+	fi
+}
+complete -F _sympi sympi
+`
+
+const zshCompletionTemplate = `#compdef sympi
+# zsh completion for sympi, generated by 'sympi -completion zsh'
+_sympi() {
+	local prev=${words[CURRENT-1]}
+	local -a candidates
+
+	case ${prev} in
+	SYMPIWORDOPTIONS)
+		candidates=(${(f)"$(SYMPIBIN -completion-words ${prev} 2>/dev/null)"})
+		compadd -a candidates
+		;;
+	SYMPIFILEOPTIONS)
+		_files
+		;;
+	*)
+		compadd -- SYMPIOPTIONS
+		;;
+	esac
+}
+compdef _sympi sympi
+`
+
+const bashHookTemplate = `# Initialization of the SyMPI shells, generated by 'sympi -shell-hook bash'
+if [ -f ~/.bashrc ]; then
+	source ~/.bashrc
+fi
+
+_sympi_load_env() {
+	if [ -f "${SYMPI_ENVFILE}" ]; then
+		source "${SYMPI_ENVFILE}"
+	fi
+}
+PROMPT_COMMAND="_sympi_load_env${PROMPT_COMMAND:+; ${PROMPT_COMMAND}}"
+
+source <(SYMPIBIN -completion bash)
+`
+
+const zshHookTemplate = `# Initialization of the SyMPI shells, generated by 'sympi -shell-hook zsh'
+# The hook is used as .zshrc by sympi_init, which sets SYMPI_ZDOTDIR to the original ZDOTDIR
+ZDOTDIR="${SYMPI_ZDOTDIR:-${HOME}}"
+if [ -f "${ZDOTDIR}/.zshenv" ]; then
+	source "${ZDOTDIR}/.zshenv"
+fi
+if [ -f "${ZDOTDIR}/.zshrc" ]; then
+	source "${ZDOTDIR}/.zshrc"
+fi
+
+_sympi_load_env() {
+	if [ -f "${SYMPI_ENVFILE}" ]; then
+		source "${SYMPI_ENVFILE}"
+	fi
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook precmd _sympi_load_env
+
+if ! (( $+functions[compdef] )); then
+	autoload -Uz compinit && compinit
+fi
+source <(SYMPIBIN -completion zsh)
+`
+
+func getTemplate(shell string, bashTemplate string, zshTemplate string) (string, error) {
+	switch shell {
+	case ShellBash:
+		return bashTemplate, nil
+	case ShellZsh:
+		return zshTemplate, nil
+	}
+	return "", fmt.Errorf("unsupported shell %s, supported shells are %s and %s", shell, ShellBash, ShellZsh)
+}
+
+// GetCompletionScript returns the completion script of sympi for a given shell. bin is the path
+// to the sympi binary used to get the possible values of the options and options is the list of
+// all the options of sympi, e.g., -install.
+func GetCompletionScript(shell string, bin string, options []string) (string, error) {
+	script, err := getTemplate(shell, bashCompletionTemplate, zshCompletionTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var wordOptions []string
+	for opt := range completionWords {
+		wordOptions = append(wordOptions, opt)
+	}
+	sort.Strings(wordOptions)
+
+	script = strings.ReplaceAll(script, wordOptionsTag, strings.Join(wordOptions, "|"))
+	script = strings.ReplaceAll(script, fileOptionsTag, strings.Join(completionFileOptions, "|"))
+	script = strings.ReplaceAll(script, optionsTag, strings.Join(options, " "))
+	script = strings.ReplaceAll(script, binTag, bin)
+	return script, nil
+}
+
+// GetShellHook returns the initialization script of the shells started by sympi_init, which
+// loads the environment set by sympi before displaying the prompt and enables the completion
+func GetShellHook(shell string, bin string) (string, error) {
+	hook, err := getTemplate(shell, bashHookTemplate, zshHookTemplate)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(hook, binTag, bin), nil
+}
+
+// GetCompletionWords returns the possible values of an option of sympi, e.g., the versions of
+// MPI that can be installed for -install
+func GetCompletionWords(option string, sysCfg *sys.Config) []string {
+	fn, ok := completionWords[option]
+	if !ok {
+		return nil
+	}
+	return fn(sysCfg)
+}
+
+func readSympiDir() []os.FileInfo {
+	entries, err := ioutil.ReadDir(sys.GetSympiDir())
+	if err != nil {
+		return nil
+	}
+	return entries
+}
+
+func getInstalledMPIs(sysCfg *sys.Config) []string {
+	mpis, _ := GetHostMPIInstalls(readSympiDir())
+	return mpis
+}
+
+func getInstalledContainers(sysCfg *sys.Config) []string {
+	containers, _ := GetContainerInstalls(readSympiDir())
+	return containers
+}
+
+func getWorkspaces(sysCfg *sys.Config) []string {
+	workspaces, _ := ListWorkspaces()
+	return workspaces
+}
+
+func getLoadableSoftware(sysCfg *sys.Config) []string {
+	words := getInstalledMPIs(sysCfg)
+	for _, entry := range readSympiDir() {
+		if strings.HasPrefix(entry.Name(), sys.SingularityInstallDirPrefix) {
+			words = append(words, "singularity:"+strings.TrimPrefix(entry.Name(), sys.SingularityInstallDirPrefix))
+		}
+	}
+	return words
+}
+
+// getAvailableSoftware returns the versions of Singularity and MPI that can be installed, based
+// on the configuration files
+func getAvailableSoftware(sysCfg *sys.Config) []string {
+	var words []string
+	kvs, err := configparser.Load(filepath.Join(sysCfg.EtcDir, "sympi_singularity.conf"))
+	if err == nil {
+		for _, e := range kvs {
+			words = append(words, implem.SY+":"+e.Key)
+		}
+	}
+	for _, id := range mpiplugin.List() {
+		kvs, err := mpiplugin.Get(id).Versions(sysCfg)
+		if err != nil {
+			continue
+		}
+		for _, e := range kvs {
+			words = append(words, id+":"+e.Key)
+		}
+	}
+	return words
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestCompletion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-completion-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = GetCompletionScript("fish", "/usr/bin/sympi", nil)
+	if err == nil {
+		t.Fatalf("GetCompletionScript() succeeded with an unsupported shell")
+	}
+
+	// The bash scripts must be valid
+	_, err = exec.LookPath("bash")
+	if err == nil {
+		completion, err := GetCompletionScript(ShellBash, "/usr/bin/sympi", []string{"-install", "-list"})
+		if err != nil {
+			t.Fatalf("GetCompletionScript() failed: %s", err)
+		}
+		hook, err := GetShellHook(ShellBash, "/usr/bin/sympi")
+		if err != nil {
+			t.Fatalf("GetShellHook() failed: %s", err)
+		}
+		for _, script := range []string{completion, hook} {
+			path := filepath.Join(dir, "script.sh")
+			err = ioutil.WriteFile(path, []byte(script), 0644)
+			if err != nil {
+				t.Fatalf("failed to create %s: %s", path, err)
+			}
+			out, err := exec.Command("bash", "-n", path).CombinedOutput()
+			if err != nil {
+				t.Fatalf("invalid script: %s\n%s", out, script)
+			}
+		}
+	}
+
+	// The versions that can be installed come from the configuration files
+	var sysCfg sys.Config
+	sysCfg.EtcDir = dir
+	err = ioutil.WriteFile(filepath.Join(dir, sys.GetMPIConfigFileName("openmpi")), []byte("4.0.2=https://example.com/openmpi-4.0.2.tar.bz2\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create configuration file: %s", err)
+	}
+	words := strings.Join(GetCompletionWords("-install", &sysCfg), " ")
+	if words != "openmpi:4.0.2" {
+		t.Fatalf("invalid completion words for -install: %s", words)
+	}
+	if len(GetCompletionWords("-unknown", &sysCfg)) != 0 {
+		t.Fatalf("completion words returned for an unknown option")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/deps"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// getContainerDependencyReport analyzes the application of a container executed the way sympi
+// executes it, i.e., with the compatible MPI of the host bound in the container with the bind model
+func getContainerDependencyReport(imgPath string, appName string, sysCfg *sys.Config) (*deps.Report, error) {
+	containerInfo, containerMPI, err := container.GetMetadata(imgPath, sysCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract container's metadata: %s", err)
+	}
+	if appName != "" {
+		err = containerInfo.SelectApp(appName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if containerInfo.AppExe == "" {
+		return nil, fmt.Errorf("the application of %s is unknown", imgPath)
+	}
+	bin := strings.Fields(containerInfo.AppExe)[0]
+
+	execArgs := container.GetRuntimeExecCfg(sysCfg)
+	var boundDirs []string
+	var hostLibDirs []string
+	if containerMPI.ID != "" && containerMPI.Version != "" {
+		hostMPI, err := findCompatibleMPI(&containerMPI)
+		if err != nil {
+			log.Printf("[WARN] no MPI compatible with %s %s installed on the host: %s", containerMPI.ID, containerMPI.Version, err)
+		} else {
+			var hostBuildEnv buildenv.Info
+			err = buildenv.CreateDefaultHostEnvCfg(&hostBuildEnv, &hostMPI, sysCfg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create default host environment configuration: %s", err)
+			}
+			execArgs = container.GetMPIExecCfg(&hostMPI, &hostBuildEnv, &containerInfo, sysCfg)
+			hostLibDirs = append(hostLibDirs, filepath.Join(hostBuildEnv.InstallDir, "lib"))
+			if containerInfo.Model == container.BindModel {
+				boundDirs = append(boundDirs, containerInfo.MPIDir)
+			}
+		}
+	}
+
+	r, err := deps.GetContainerReport(imgPath, bin, execArgs, boundDirs, sysCfg)
+	if err != nil {
+		return nil, err
+	}
+	r.LocateMissing(hostLibDirs)
+	return r, nil
+}
+
+// GetDependencyReport returns the shared libraries a binary depends on and where they come from.
+// The target is either a binary of the host or a container, i.e., the name of a container of the
+// workspace or the path to an image, in which case the application of the container, or the
+// application appName of a multi-app container, is analyzed.
+func GetDependencyReport(target string, appName string, sysCfg *sys.Config) (*deps.Report, error) {
+	sysCfg.Persistent = sys.GetSympiDir()
+
+	imgPath, err := getImagePath(target, sysCfg)
+	if err != nil && filepath.Ext(target) == ".sif" && util.FileExists(target) {
+		imgPath, err = target, nil
+	}
+	if err != nil {
+		return deps.GetHostReport(target)
+	}
+	return getContainerDependencyReport(imgPath, appName, sysCfg)
+}
@@ -0,0 +1,397 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/sif"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// packagesQuery lists the packages installed in an image with the package manager of its Linux
+	// distribution, one "<name> <version>" per line
+	packagesQuery = `if command -v dpkg-query >/dev/null 2>&1; then dpkg-query -W -f='${Package} ${Version}\n'; else rpm -qa --qf '%{NAME} %{VERSION}-%{RELEASE}\n'; fi`
+
+	// manifestQuery lists the files of a directory of an image, given as first argument, with
+	// their checksum, one "<checksum>  ./<path>" per line
+	manifestQuery = `cd "$0" && find . -type f -exec sha256sum {} +`
+)
+
+// volatileEnvVars are the environment variables of the containers that differ from one execution
+// to another, ignored when comparing images
+var volatileEnvVars = []string{"PWD", "OLDPWD", "SHLVL", "_", "HOME", "USER", "LOGNAME", "HOSTNAME", "TERM", "PS1"}
+
+// volatileEnvPrefixes are the prefixes of the environment variables set by the container runtime,
+// e.g., SINGULARITY_CONTAINER, ignored when comparing images
+var volatileEnvPrefixes = []string{"SINGULARITY_", "APPTAINER_"}
+
+// imageExec executes a command of the container runtime, e.g., exec <image> env, and returns its output
+type imageExec func(args ...string) (string, error)
+
+// ImageContent is the content of an image compared by DiffImages
+type ImageContent struct {
+	// Path is the path to the image
+	Path string
+
+	// Labels are the labels of the image
+	Labels map[string]string
+
+	// Env is the environment of the container, without the variables set by the runtime
+	Env map[string]string
+
+	// MPI is the implementation of MPI of the image: its implementation, version, model and
+	// installation directory from the labels, and the version reported by mpirun when installed
+	MPI map[string]string
+
+	// Packages are the packages of the Linux distribution installed in the image, with their version
+	Packages map[string]string
+
+	// MPIFiles are the files of the installation directory of MPI, with their checksum
+	MPIFiles map[string]string
+
+	// Unavailable are the parts of the content that could not be gathered and why, e.g.,
+	// "packages": "dpkg-query and rpm not found"
+	Unavailable map[string]string
+}
+
+// DiffSection is the difference between two images for a part of their content, e.g., labels
+type DiffSection struct {
+	// Name is the name of the part of the content, e.g., Labels
+	Name string
+
+	// Changes are the differences, one per line: "- <key>" for what is only in the first image,
+	// "+ <key>" for what is only in the second one and "~ <key>" for what differs
+	Changes []string
+
+	// Notes explain why the part could not be compared, e.g., no package manager in an image
+	Notes []string
+}
+
+// ImageDiff is the difference between two images, as returned by DiffImages
+type ImageDiff struct {
+	// A and B are the paths to the images that are compared
+	A string
+	B string
+
+	// Sections are the differences of each part of the content of the images
+	Sections []DiffSection
+}
+
+// runImageCmd executes a command of the container runtime and returns its standard output
+func runImageCmd(sysCfg *sys.Config) imageExec {
+	return func(args ...string) (string, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(sysCfg.SingularityBin, args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := syexec.RunCmd(cmd)
+		if err != nil {
+			return "", fmt.Errorf("failed to execute %s: %s (stderr: %s)", strings.Join(cmd.Args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), nil
+	}
+}
+
+// parseKeyValues parses lines of key/value pairs separated by sep, e.g., "MPI_Version: 4.0.2";
+// lines without separator are ignored
+func parseKeyValues(output string, sep string) map[string]string {
+	kvs := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		tokens := strings.SplitN(strings.TrimSpace(line), sep, 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			continue
+		}
+		kvs[tokens[0]] = strings.TrimSpace(tokens[1])
+	}
+	return kvs
+}
+
+// isVolatileEnvVar checks whether an environment variable differs from one execution to another
+func isVolatileEnvVar(name string) bool {
+	for _, v := range volatileEnvVars {
+		if name == v {
+			return true
+		}
+	}
+	for _, p := range volatileEnvPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEnv parses the output of env executed in a container, without the volatile variables
+func parseEnv(output string) map[string]string {
+	env := parseKeyValues(output, "=")
+	for name := range env {
+		if isVolatileEnvVar(name) {
+			delete(env, name)
+		}
+	}
+	return env
+}
+
+// parsePackages parses the list of the packages of an image, one "<name> <version>" per line
+func parsePackages(output string) map[string]string {
+	return parseKeyValues(output, " ")
+}
+
+// parseManifest parses the checksums of the files of a directory, one "<checksum>  ./<path>" per
+// line, and returns the checksum of each file by path relative to the directory
+func parseManifest(output string) map[string]string {
+	files := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		path := strings.TrimPrefix(strings.Join(fields[1:], " "), "./")
+		files[path] = fields[0]
+	}
+	return files
+}
+
+// getImageLabels returns the labels of an image, read from its SIF metadata when available and
+// from the output of inspect otherwise
+func getImageLabels(imgPath string, run imageExec) (map[string]string, error) {
+	if sif.IsSIF(imgPath) {
+		img, err := sif.Load(imgPath)
+		if err == nil {
+			labels, err := img.Labels()
+			if err == nil && len(labels) > 0 {
+				return labels, nil
+			}
+		}
+	}
+	output, err := run("inspect", "--labels", imgPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyValues(output, ": "), nil
+}
+
+// getImageContent gathers the content of an image compared by DiffImages; the parts that cannot
+// be gathered are recorded as unavailable rather than making the comparison fail
+func getImageContent(imgPath string, run imageExec, execArgs []string) *ImageContent {
+	c := &ImageContent{Path: imgPath, Unavailable: make(map[string]string)}
+	inImage := func(cmd ...string) (string, error) {
+		return run(append(append(append([]string{}, execArgs...), imgPath), cmd...)...)
+	}
+
+	var err error
+	c.Labels, err = getImageLabels(imgPath, run)
+	if err != nil {
+		c.Unavailable["labels"] = err.Error()
+	}
+
+	output, err := inImage("env")
+	if err == nil {
+		c.Env = parseEnv(output)
+	} else {
+		c.Unavailable["environment"] = err.Error()
+	}
+
+	// The metadata of the image gives the MPI it was built with; the version reported by mpirun
+	// is only available when MPI is installed in the image, i.e., not with the bind model
+	c.MPI = make(map[string]string)
+	for key, label := range map[string]string{"implementation": "MPI_Implementation", "version": "MPI_Version", "model": "Model", "directory": "MPI_Directory"} {
+		if v, ok := c.Labels[label]; ok {
+			c.MPI[key] = v
+		}
+	}
+	mpiDir := c.MPI["directory"]
+	if mpiDir != "" && c.MPI["model"] != container.BindModel {
+		output, err = inImage(filepath.Join(mpiDir, "bin", "mpirun"), "--version")
+		if err == nil && strings.TrimSpace(output) != "" {
+			c.MPI["mpirun --version"] = strings.TrimSpace(strings.SplitN(strings.TrimSpace(output), "\n", 2)[0])
+		}
+	}
+
+	output, err = inImage("sh", "-c", packagesQuery)
+	if err == nil {
+		c.Packages = parsePackages(output)
+	} else {
+		c.Unavailable["packages"] = err.Error()
+	}
+
+	if mpiDir == "" {
+		c.Unavailable["MPI files"] = "the image has no MPI_Directory label"
+	} else if c.MPI["model"] == container.BindModel {
+		c.Unavailable["MPI files"] = "MPI is bind-mounted from the host"
+	} else {
+		output, err = inImage("sh", "-c", manifestQuery, mpiDir)
+		if err == nil {
+			c.MPIFiles = parseManifest(output)
+		} else {
+			c.Unavailable["MPI files"] = err.Error()
+		}
+	}
+
+	return c
+}
+
+// diffMaps returns the differences between two sets of key/value pairs, sorted by key. The values
+// are displayed with the keys that differ when showValues is set, e.g., for the versions of packages
+// but not for the checksums of files.
+func diffMaps(a map[string]string, b map[string]string, showValues bool) []string {
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []string
+	for _, k := range sorted {
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inB:
+			if showValues {
+				changes = append(changes, "- "+k+": "+va)
+			} else {
+				changes = append(changes, "- "+k)
+			}
+		case !inA:
+			if showValues {
+				changes = append(changes, "+ "+k+": "+vb)
+			} else {
+				changes = append(changes, "+ "+k)
+			}
+		case va != vb:
+			if showValues {
+				changes = append(changes, "~ "+k+": "+va+" -> "+vb)
+			} else {
+				changes = append(changes, "~ "+k)
+			}
+		}
+	}
+	return changes
+}
+
+// diffSection compares a part of the content of two images
+func diffSection(name string, key string, a *ImageContent, b *ImageContent, va map[string]string, vb map[string]string, showValues bool) DiffSection {
+	s := DiffSection{Name: name}
+	for _, c := range []*ImageContent{a, b} {
+		if reason, ok := c.Unavailable[key]; ok {
+			s.Notes = append(s.Notes, fmt.Sprintf("not available for %s: %s", c.Path, reason))
+		}
+	}
+	if len(s.Notes) == 0 {
+		s.Changes = diffMaps(va, vb, showValues)
+	}
+	return s
+}
+
+// compareImageContents compares the content of two images
+func compareImageContents(a *ImageContent, b *ImageContent) *ImageDiff {
+	return &ImageDiff{
+		A: a.Path,
+		B: b.Path,
+		Sections: []DiffSection{
+			diffSection("Labels", "labels", a, b, a.Labels, b.Labels, true),
+			diffSection("Environment", "environment", a, b, a.Env, b.Env, true),
+			diffSection("MPI", "MPI", a, b, a.MPI, b.MPI, true),
+			diffSection("Packages", "packages", a, b, a.Packages, b.Packages, true),
+			diffSection("MPI files", "MPI files", a, b, a.MPIFiles, b.MPIFiles, false),
+		},
+	}
+}
+
+// Differs checks whether differences were found between the images
+func (d *ImageDiff) Differs() bool {
+	for _, s := range d.Sections {
+		if len(s.Changes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Incomplete checks whether parts of the content of the images could not be compared, e.g., the
+// packages of an image without package manager
+func (d *ImageDiff) Incomplete() bool {
+	for _, s := range d.Sections {
+		if len(s.Notes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Identical checks whether the images are identical, i.e., all the parts of their content could be
+// compared and no difference was found
+func (d *ImageDiff) Identical() bool {
+	return !d.Differs() && !d.Incomplete()
+}
+
+// String returns the difference between the images in a readable form, one section per part of
+// the content of the images
+func (d *ImageDiff) String() string {
+	str := "--- " + d.A + "\n+++ " + d.B + "\n"
+	for _, s := range d.Sections {
+		str += "\n" + s.Name + ":\n"
+		for _, n := range s.Notes {
+			str += "\t" + n + "\n"
+		}
+		if len(s.Notes) == 0 && len(s.Changes) == 0 {
+			str += "\tidentical\n"
+		}
+		for _, c := range s.Changes {
+			str += "\t" + c + "\n"
+		}
+	}
+	return str
+}
+
+// resolveImage returns the path to an image given as the name of a container of the workspace or
+// as a path
+func resolveImage(target string, sysCfg *sys.Config) (string, error) {
+	imgPath, err := getImagePath(target, sysCfg)
+	if err == nil {
+		return imgPath, nil
+	}
+	if util.FileExists(target) {
+		return target, nil
+	}
+	return "", fmt.Errorf("%s is neither a container of the workspace nor an image", target)
+}
+
+// DiffImages compares two images, e.g., two builds of the same configuration, given as the names
+// of containers of the workspace or as paths: their labels, environment, MPI, packages of the Linux
+// distribution and the files of the installation directory of MPI
+func DiffImages(targetA string, targetB string, sysCfg *sys.Config) (*ImageDiff, error) {
+	imgA, err := resolveImage(targetA, sysCfg)
+	if err != nil {
+		return nil, err
+	}
+	imgB, err := resolveImage(targetB, sysCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The environment of the host is not passed to the containers so only their own is compared
+	execArgs := append(container.GetRuntimeExecCfg(sysCfg), "--cleanenv")
+	run := runImageCmd(sysCfg)
+	return compareImageContents(getImageContent(imgA, run, execArgs), getImageContent(imgB, run, execArgs)), nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestDiffImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-diff-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgA := filepath.Join(dir, "a.sif")
+	imgB := filepath.Join(dir, "b.sif")
+	for _, img := range []string{imgA, imgB} {
+		err = ioutil.WriteFile(img, []byte("not a SIF image"), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", img, err)
+		}
+	}
+
+	fake := syexec.NewFakeRunner()
+	fake.On(imgA+" /opt/mpi/bin/mpirun --version", syexec.FakeResult{Stdout: "mpirun (Open MPI) 4.0.2\n\nReport bugs\n"})
+	fake.On(imgB+" /opt/mpi/bin/mpirun --version", syexec.FakeResult{Stdout: "mpirun (Open MPI) 4.0.3\n\nReport bugs\n"})
+	fake.On("inspect --labels "+imgA, syexec.FakeResult{Stdout: "MPI_Implementation: openmpi\nMPI_Version: 4.0.2\nMPI_Directory: /opt/mpi\nModel: hybrid\n"})
+	fake.On("inspect --labels "+imgB, syexec.FakeResult{Stdout: "MPI_Implementation: openmpi\nMPI_Version: 4.0.3\nMPI_Directory: /opt/mpi\nModel: hybrid\n"})
+	fake.On(imgA+" env", syexec.FakeResult{Stdout: "PATH=/opt/mpi/bin:/usr/bin\nPWD=/tmp\nSINGULARITY_NAME=a.sif\nOMPI_MCA_btl=self,vader\n"})
+	fake.On(imgB+" env", syexec.FakeResult{Stdout: "PATH=/opt/mpi/bin:/usr/bin\nPWD=/home\nSINGULARITY_NAME=b.sif\n"})
+	fake.On(imgA+" dpkg-query", syexec.FakeResult{Stdout: "libc6 2.27-3ubuntu1\nlibibverbs1 17.1-1\n"})
+	fake.On(imgB+" dpkg-query", syexec.FakeResult{Stdout: "libc6 2.27-3ubuntu1.2\nlibucx0 1.8.0-1\n"})
+	fake.On(imgA+" sha256sum", syexec.FakeResult{Stdout: "1111  ./bin/mpirun\n2222  ./lib/libmpi.so.40\n3333  ./lib/libmpi_java.so\n"})
+	fake.On(imgB+" sha256sum", syexec.FakeResult{Stdout: "1111  ./bin/mpirun\n4444  ./lib/libmpi.so.40\n5555  ./lib/libucx glue.so\n"})
+	defer syexec.SetRunner(syexec.SetRunner(fake))
+
+	sysCfg := &sys.Config{SingularityBin: "singularity"}
+
+	d, err := DiffImages(imgA, imgB, sysCfg)
+	if err != nil {
+		t.Fatalf("DiffImages() failed: %s", err)
+	}
+	expected := map[string][]string{
+		"Labels":      {"~ MPI_Version: 4.0.2 -> 4.0.3"},
+		"Environment": {"- OMPI_MCA_btl: self,vader"},
+		"MPI":         {"~ mpirun --version: mpirun (Open MPI) 4.0.2 -> mpirun (Open MPI) 4.0.3", "~ version: 4.0.2 -> 4.0.3"},
+		"Packages":    {"~ libc6: 2.27-3ubuntu1 -> 2.27-3ubuntu1.2", "- libibverbs1: 17.1-1", "+ libucx0: 1.8.0-1"},
+		"MPI files":   {"~ lib/libmpi.so.40", "- lib/libmpi_java.so", "+ lib/libucx glue.so"},
+	}
+	if len(d.Sections) != len(expected) {
+		t.Fatalf("DiffImages() returned %d sections instead of %d", len(d.Sections), len(expected))
+	}
+	for _, s := range d.Sections {
+		if len(s.Notes) > 0 {
+			t.Fatalf("unexpected notes for %s: %s", s.Name, strings.Join(s.Notes, "; "))
+		}
+		if !reflect.DeepEqual(s.Changes, expected[s.Name]) {
+			t.Fatalf("%s: got %q instead of %q", s.Name, s.Changes, expected[s.Name])
+		}
+	}
+	if d.Identical() {
+		t.Fatalf("images with differences reported as identical")
+	}
+	if !strings.Contains(d.String(), "--- "+imgA+"\n+++ "+imgB+"\n") {
+		t.Fatalf("the images are not displayed with the difference:\n%s", d.String())
+	}
+
+	// An image is identical to itself
+	d, err = DiffImages(imgA, imgA, sysCfg)
+	if err != nil {
+		t.Fatalf("DiffImages() failed: %s", err)
+	}
+	if !d.Identical() {
+		t.Fatalf("an image is different from itself:\n%s", d.String())
+	}
+
+	// The parts that cannot be gathered are reported instead of failing the comparison
+	fake.On(imgB+" dpkg-query", syexec.FakeResult{Stderr: "rpm: command not found", ExitCode: 127})
+	d, err = DiffImages(imgA, imgB, sysCfg)
+	if err != nil {
+		t.Fatalf("DiffImages() failed: %s", err)
+	}
+	for _, s := range d.Sections {
+		if s.Name == "Packages" && (len(s.Notes) != 1 || len(s.Changes) != 0 || !strings.Contains(s.Notes[0], imgB)) {
+			t.Fatalf("unexpected comparison of the packages with an image without package manager: %q %q", s.Notes, s.Changes)
+		}
+	}
+	if !d.Incomplete() {
+		t.Fatalf("comparison without the packages of %s not reported as incomplete", imgB)
+	}
+
+	// An image whose content cannot be entirely gathered is not identical to itself
+	d, err = DiffImages(imgB, imgB, sysCfg)
+	if err != nil {
+		t.Fatalf("DiffImages() failed: %s", err)
+	}
+	if d.Differs() || !d.Incomplete() || d.Identical() {
+		t.Fatalf("incomplete comparison reported as identical or different:\n%s", d.String())
+	}
+
+	_, err = DiffImages(imgA, filepath.Join(dir, "missing.sif"), sysCfg)
+	if err == nil {
+		t.Fatalf("DiffImages() succeeded with a missing image")
+	}
+}
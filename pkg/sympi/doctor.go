@@ -0,0 +1,368 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/builder"
+	"github.com/sylabs/singularity-mpi/pkg/checker"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// SeverityError is the severity of the problems preventing SyMPI from working correctly
+	SeverityError = iota
+
+	// SeverityWarning is the severity of the problems that may lead to failures
+	SeverityWarning
+
+	// SeverityInfo is the severity of the problems that only waste resources, e.g., disk space
+	SeverityInfo
+)
+
+const (
+	// orphanedScratchAge is the age from which a scratch or build directory that was not removed
+	// is considered orphaned, younger directories may be used by a running installation
+	orphanedScratchAge = time.Hour
+
+	// minSingularityVersion is the oldest version of Singularity supported by SyMPI
+	minSingularityVersion = "3.0.0"
+
+	// mpiManifestName is the name of the manifest of the installations of MPI
+	mpiManifestName = "mpi.MANIFEST"
+
+	// singularityManifestName is the name of the manifest of the installations of Singularity
+	singularityManifestName = "singularity.MANIFEST"
+)
+
+// Problem is a problem detected by the doctor
+type Problem struct {
+	// Severity is the severity of the problem, e.g., SeverityError
+	Severity int
+
+	// Component is the part of the system the problem relates to, e.g., workspace
+	Component string
+
+	// Msg describes the problem
+	Msg string
+
+	// Fix is the suggested fix of the problem
+	Fix string
+
+	// repair performs the fix, nil when the problem cannot be safely repaired automatically
+	repair func() error
+}
+
+// CanRepair checks whether a problem can be safely repaired automatically
+func (p *Problem) CanRepair() bool {
+	return p.repair != nil
+}
+
+// DoctorReport is the prioritized list of the problems detected by the doctor
+type DoctorReport struct {
+	// Problems is the list of the problems, the most severe first
+	Problems []Problem
+}
+
+func getSeverityName(severity int) string {
+	switch severity {
+	case SeverityError:
+		return "ERROR"
+	case SeverityWarning:
+		return "WARN"
+	}
+	return "INFO"
+}
+
+func (r *DoctorReport) add(p Problem) {
+	r.Problems = append(r.Problems, p)
+}
+
+// String renders the report so it can be displayed to users
+func (r *DoctorReport) String() string {
+	if len(r.Problems) == 0 {
+		return "No problem detected\n"
+	}
+	var sb strings.Builder
+	for i, p := range r.Problems {
+		sb.WriteString(fmt.Sprintf("%d. [%s] %s: %s\n", i+1, getSeverityName(p.Severity), p.Component, p.Msg))
+		if p.Fix != "" {
+			sb.WriteString(fmt.Sprintf("    fix: %s", p.Fix))
+			if p.CanRepair() {
+				sb.WriteString(" (automatic with -fix)")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// Fix performs the safe automatic repairs and returns the number of repaired problems; the
+// problems that cannot be repaired are kept in the report
+func (r *DoctorReport) Fix() (int, error) {
+	var remaining []Problem
+	repaired := 0
+	var errs []string
+	for _, p := range r.Problems {
+		if !p.CanRepair() {
+			remaining = append(remaining, p)
+			continue
+		}
+		err := p.repair()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", p.Msg, err))
+			remaining = append(remaining, p)
+			continue
+		}
+		repaired++
+	}
+	r.Problems = remaining
+	if len(errs) > 0 {
+		return repaired, fmt.Errorf("failed to repair %d problem(s):\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+	return repaired, nil
+}
+
+// checkSystem adds the failed checks of the system configuration to the report
+func checkSystem(r *DoctorReport, sysReport *checker.Report) {
+	for _, c := range sysReport.Checks {
+		if c.Pass {
+			continue
+		}
+		severity := SeverityWarning
+		if c.Required {
+			severity = SeverityError
+		}
+		r.add(Problem{Severity: severity, Component: "system", Msg: fmt.Sprintf("%s: %s", c.Name, c.Err), Fix: c.Hint})
+	}
+}
+
+func removeDirFn(dir string) func() error {
+	return func() error {
+		return os.RemoveAll(dir)
+	}
+}
+
+// addIncompleteDir reports a directory left by an installation or a creation of container that did not
+// terminate; it is only removed by the repair when it is old enough to not be used by a running installation
+func addIncompleteDir(r *DoctorReport, entry os.FileInfo, dir string, severity int, msg string, fix string, now time.Time) {
+	if !isOrphaned(entry, now) {
+		r.add(Problem{Severity: severity, Component: "workspace", Msg: msg + " (it may still be in progress)",
+			Fix: fmt.Sprintf("once it is terminated, %s", fix)})
+		return
+	}
+	r.add(Problem{Severity: severity, Component: "workspace", Msg: msg, Fix: fix, repair: removeDirFn(dir)})
+}
+
+// checkInstall checks an installation of MPI or Singularity in the workspace against its manifest
+func checkInstall(r *DoctorReport, entry os.FileInfo, dir string, manifestName string, descr string, now time.Time) {
+	if !util.IsDir(filepath.Join(dir, "bin")) || builder.GetInstallStatus(dir) == builder.InstallStatusInProgress {
+		addIncompleteDir(r, entry, dir, SeverityError, fmt.Sprintf("installation of %s in %s is incomplete", descr, dir),
+			fmt.Sprintf("remove %s and reinstall with 'sympi -install %s'", dir, descr), now)
+		return
+	}
+	manifestPath := filepath.Join(dir, manifestName)
+	if !util.FileExists(manifestPath) {
+		r.add(Problem{Severity: SeverityInfo, Component: "workspace", Msg: fmt.Sprintf("no manifest for %s, its integrity cannot be checked", descr),
+			Fix: fmt.Sprintf("reinstall with 'sympi -install %s'", descr)})
+		return
+	}
+	err := manifest.Check(manifestPath)
+	if err != nil {
+		r.add(Problem{Severity: SeverityError, Component: "workspace", Msg: fmt.Sprintf("installation of %s was modified since it was installed: %s", descr, err),
+			Fix: fmt.Sprintf("reinstall with 'sympi -install %s'", descr)})
+	}
+}
+
+// checkContainer checks that the directory of a container of the workspace has an image
+func checkContainer(r *DoctorReport, entry os.FileInfo, dir string, name string, now time.Time) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		r.add(Problem{Severity: SeverityWarning, Component: "workspace", Msg: fmt.Sprintf("failed to read %s: %s", dir, err)})
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".sif") {
+			return
+		}
+	}
+	addIncompleteDir(r, entry, dir, SeverityWarning, fmt.Sprintf("container %s has no image, its creation probably failed", name),
+		fmt.Sprintf("remove %s", dir), now)
+}
+
+// isOrphaned checks whether a scratch or build directory is old enough to not be used by a running installation
+func isOrphaned(entry os.FileInfo, now time.Time) bool {
+	return entry.IsDir() && now.Sub(entry.ModTime()) > orphanedScratchAge
+}
+
+// checkWorkspace checks the integrity of the installations and containers of a workspace and
+// detects the scratch and build directories left by interrupted or failed installations
+func checkWorkspace(r *DoctorReport, sympiDir string, now time.Time) {
+	entries, err := ioutil.ReadDir(sympiDir)
+	if err != nil {
+		r.add(Problem{Severity: SeverityError, Component: "workspace", Msg: fmt.Sprintf("failed to read %s: %s", sympiDir, err),
+			Fix: "set SYMPI_INSTALL_DIR to a valid directory and run 'sympi_init'"})
+		return
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(sympiDir, e.Name())
+		switch {
+		case strings.HasPrefix(e.Name(), sys.MPIInstallDirPrefix):
+			checkInstall(r, e, path, mpiManifestName, strings.Replace(strings.TrimPrefix(e.Name(), sys.MPIInstallDirPrefix), "-", ":", 1), now)
+		case strings.HasPrefix(e.Name(), sys.SingularityInstallDirPrefix):
+			version := strings.TrimPrefix(e.Name(), sys.SingularityInstallDirPrefix)
+			checkInstall(r, e, path, singularityManifestName, implem.SY+":"+version, now)
+			if implem.CompareVersions(version, minSingularityVersion) < 0 {
+				r.add(Problem{Severity: SeverityWarning, Component: "versions", Msg: fmt.Sprintf("Singularity %s is not supported, SyMPI requires Singularity >= %s", version, minSingularityVersion),
+					Fix: "install a more recent version with 'sympi -install singularity:<version>'"})
+			}
+		case e.Name() == builder.QuarantineDirName && e.IsDir():
+			// Incomplete installations kept for debugging
+			subEntries, err := ioutil.ReadDir(path)
+			if err != nil {
+				continue
+			}
+			for _, sub := range subEntries {
+				subPath := filepath.Join(path, sub.Name())
+				r.add(Problem{Severity: SeverityInfo, Component: "workspace", Msg: fmt.Sprintf("incomplete installation kept in %s", subPath),
+					Fix: fmt.Sprintf("remove %s", subPath), repair: removeDirFn(subPath)})
+			}
+		case strings.HasPrefix(e.Name(), sys.ContainerInstallDirPrefix):
+			checkContainer(r, e, path, strings.TrimPrefix(e.Name(), sys.ContainerInstallDirPrefix), now)
+		case strings.HasPrefix(e.Name(), sys.SingularityBuildDirPrefix) || strings.HasPrefix(e.Name(), sys.SingularityScratchDirPrefix):
+			if isOrphaned(e, now) && !buildenv.IsScratchInUse(path) {
+				r.add(Problem{Severity: SeverityInfo, Component: "scratch", Msg: fmt.Sprintf("orphaned directory %s", path),
+					Fix: fmt.Sprintf("remove %s", path), repair: removeDirFn(path)})
+			}
+		case strings.HasPrefix(e.Name(), "scratch-") && e.IsDir():
+			// Scratch directories of the installations of MPI, the directories they contain are
+			// normally removed once an installation terminates
+			if buildenv.IsScratchInUse(path) {
+				continue
+			}
+			subEntries, err := ioutil.ReadDir(path)
+			if err != nil {
+				continue
+			}
+			for _, sub := range subEntries {
+				if isOrphaned(sub, now) {
+					subPath := filepath.Join(path, sub.Name())
+					r.add(Problem{Severity: SeverityInfo, Component: "scratch", Msg: fmt.Sprintf("orphaned directory %s", subPath),
+						Fix: fmt.Sprintf("remove %s", subPath), repair: removeDirFn(subPath)})
+				}
+			}
+		}
+	}
+}
+
+// readEnvFilePath returns the value of PATH set in an environment file
+func readEnvFilePath(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %s", file, err)
+	}
+	defer f.Close()
+
+	path := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "export PATH=") {
+			path = strings.TrimPrefix(line, "export PATH=")
+		}
+	}
+	return path, scanner.Err()
+}
+
+// checkEnvFile checks the environment file of the current shell: SyMPI must be initialized, the
+// software loaded must still be installed and a single MPI must be loaded
+func checkEnvFile(r *DoctorReport, envFile string, sympiDir string) {
+	if envFile == "" || !util.FileExists(envFile) {
+		r.add(Problem{Severity: SeverityError, Component: "environment", Msg: "SyMPI is not initialized in this shell", Fix: "run 'sympi_init'"})
+		return
+	}
+	_, err := getEnvFileOwner(envFile)
+	if err != nil {
+		r.add(Problem{Severity: SeverityWarning, Component: "environment", Msg: err.Error(), Fix: "exit the shell and run 'sympi_init' again"})
+	}
+
+	pathEnv, err := readEnvFilePath(envFile)
+	if err != nil {
+		r.add(Problem{Severity: SeverityWarning, Component: "environment", Msg: err.Error()})
+		return
+	}
+	var mpis []string
+	for _, p := range strings.Split(pathEnv, ":") {
+		if !strings.HasPrefix(p, sympiDir) {
+			continue
+		}
+		installDir := filepath.Dir(p)
+		name := filepath.Base(installDir)
+		if !util.IsDir(p) {
+			r.add(Problem{Severity: SeverityError, Component: "environment", Msg: fmt.Sprintf("%s is loaded but not installed anymore", name),
+				Fix: "run 'sympi -unload mpi' or 'sympi -unload singularity'"})
+		}
+		if strings.HasPrefix(name, sys.MPIInstallDirPrefix) {
+			mpis = append(mpis, strings.Replace(strings.TrimPrefix(name, sys.MPIInstallDirPrefix), "-", ":", 1))
+		}
+	}
+	if len(mpis) > 1 {
+		r.add(Problem{Severity: SeverityWarning, Component: "versions", Msg: fmt.Sprintf("several versions of MPI are loaded: %s", strings.Join(mpis, ", ")),
+			Fix: fmt.Sprintf("run 'sympi -unload mpi' and 'sympi -load %s'", mpis[0])})
+	}
+}
+
+// checkStaleEnvFiles detects the environment files of shells that are not running anymore
+func checkStaleEnvFiles(r *DoctorReport) {
+	var stale []string
+	for _, d := range getEnvFileDirs() {
+		files, err := getStaleEnvFiles(d, processIsAlive)
+		if err != nil {
+			continue
+		}
+		stale = append(stale, files...)
+	}
+	if len(stale) == 0 {
+		return
+	}
+	r.add(Problem{Severity: SeverityInfo, Component: "environment", Msg: fmt.Sprintf("%d stale environment file(s)", len(stale)),
+		Fix: "run 'sympi -cleanup-env'", repair: func() error {
+			_, err := ReapStaleEnvFiles()
+			return err
+		}})
+}
+
+// Doctor diagnoses the system, the workspace and the environment of SyMPI: system configuration,
+// integrity of the installations and containers, environment files, orphaned scratch directories
+// and versions of the software. It returns the list of problems, the most severe first.
+func Doctor() *DoctorReport {
+	var r DoctorReport
+	sympiDir := sys.GetSympiDir()
+	envFile, _ := GetEnvFile()
+
+	checkSystem(&r, checker.RunSystemChecks())
+	checkWorkspace(&r, sympiDir, time.Now())
+	checkEnvFile(&r, envFile, sympiDir)
+	checkStaleEnvFiles(&r)
+
+	sort.SliceStable(r.Problems, func(i, j int) bool {
+		return r.Problems[i].Severity < r.Problems[j].Severity
+	})
+	return &r
+}
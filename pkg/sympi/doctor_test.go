@@ -0,0 +1,68 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestDoctorWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-doctor-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	incompleteInstall := filepath.Join(dir, sys.MPIInstallDirPrefix+"openmpi-4.0.2")
+	emptyContainer := filepath.Join(dir, sys.ContainerInstallDirPrefix+"netpipe")
+	oldScratch := filepath.Join(dir, "scratch-openmpi", "mpi_build_openmpi_4.0.2")
+	newScratch := filepath.Join(dir, "scratch-openmpi", "mpi_build_openmpi_3.1.4")
+	runningInstall := filepath.Join(dir, sys.MPIInstallDirPrefix+"mpich-3.3.2")
+	for _, d := range []string{incompleteInstall, emptyContainer, oldScratch, newScratch, runningInstall} {
+		err = os.MkdirAll(d, 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+	old := time.Now().Add(-2 * orphanedScratchAge)
+	for _, d := range []string{incompleteInstall, emptyContainer, oldScratch} {
+		err = os.Chtimes(d, old, old)
+		if err != nil {
+			t.Fatalf("failed to change the modification time of %s: %s", d, err)
+		}
+	}
+
+	var r DoctorReport
+	checkWorkspace(&r, dir, time.Now())
+	if len(r.Problems) != 4 {
+		t.Fatalf("%d problems detected instead of 4: %s", len(r.Problems), r.String())
+	}
+
+	repaired, err := r.Fix()
+	if err != nil {
+		t.Fatalf("Fix() failed: %s", err)
+	}
+	if repaired != 3 || len(r.Problems) != 1 {
+		t.Fatalf("%d problems repaired, %d remaining", repaired, len(r.Problems))
+	}
+	for _, d := range []string{incompleteInstall, emptyContainer, oldScratch} {
+		if util.PathExists(d) {
+			t.Fatalf("%s was not removed", d)
+		}
+	}
+	for _, d := range []string{newScratch, runningInstall} {
+		if !util.PathExists(d) {
+			t.Fatalf("%s, which may be used by a running installation, was removed", d)
+		}
+	}
+}
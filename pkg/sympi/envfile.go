@@ -0,0 +1,317 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// envFilePrefix is the prefix of the files automatically sourced by the shells started with sympi_init
+	envFilePrefix = "sympi_"
+
+	// envFileOwnerHeader is the comment, at the beginning of an environment file, that
+	// specifies the PID of the shell owning the file
+	envFileOwnerHeader = "# sympi owner: "
+
+	// envFileCreatedHeader is the comment, at the beginning of an environment file, that
+	// specifies when the file was created (UNIX timestamp)
+	envFileCreatedHeader = "# sympi created: "
+
+	// defaultEnvFileDir is the directory where environment files are stored when XDG_RUNTIME_DIR
+	// is not available
+	defaultEnvFileDir = "/tmp"
+
+	// clockTicks is the number of clock ticks per second the start time of processes is
+	// expressed in by /proc, i.e., USER_HZ which is always 100 on Linux
+	clockTicks = 100
+)
+
+var envFileRegex = regexp.MustCompile(`^` + envFilePrefix + `([0-9]+)$`)
+
+// GetEnvFileDir returns the directory where the environment files are stored, i.e.,
+// $XDG_RUNTIME_DIR when available, /tmp otherwise. It must match what sympi_init is using.
+func GetEnvFileDir() string {
+	xdgDir := os.Getenv("XDG_RUNTIME_DIR")
+	if xdgDir != "" && util.IsDir(xdgDir) {
+		return xdgDir
+	}
+	return defaultEnvFileDir
+}
+
+// getEnvFileHeader parses the header of an environment file and returns the PID of the
+// owner and the creation timestamp. Values that are not available are set to 0.
+func getEnvFileHeader(file string) (int, int64) {
+	owner := 0
+	var created int64
+
+	f, err := os.Open(file)
+	if err != nil {
+		return owner, created
+	}
+	defer f.Close()
+
+	for s := bufio.NewScanner(f); s.Scan(); {
+		line := s.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if strings.HasPrefix(line, envFileOwnerHeader) {
+			owner, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, envFileOwnerHeader)))
+		}
+		if strings.HasPrefix(line, envFileCreatedHeader) {
+			created, _ = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, envFileCreatedHeader)), 10, 64)
+		}
+	}
+
+	return owner, created
+}
+
+// getEnvFileOwner returns the PID of the shell owning an environment file. The header of the
+// file is used when available, otherwise the PID is extracted from the name of the file.
+func getEnvFileOwner(file string) (int, error) {
+	owner, _ := getEnvFileHeader(file)
+	if owner > 0 {
+		return owner, nil
+	}
+
+	m := envFileRegex.FindStringSubmatch(filepath.Base(file))
+	if len(m) != 2 {
+		return -1, fmt.Errorf("%s is not a SyMPI environment file", file)
+	}
+	return strconv.Atoi(m[1])
+}
+
+// UpdateEnvFile updates the file that is automatically sources while using
+// SyMPI and setting the environment.
+func UpdateEnvFile(file string, pathEnv string, ldlibEnv string) error {
+	return writeEnvFile(file, pathEnv, ldlibEnv, nil, nil)
+}
+
+// writeEnvFile writes an environment file setting PATH and LD_LIBRARY_PATH, with extra header
+// lines and commands
+func writeEnvFile(file string, pathEnv string, ldlibEnv string, header []string, cmds []string) error {
+	// sanity checks
+	if len(pathEnv) == 0 {
+		return fmt.Errorf("invalid parameter, empty PATH")
+	}
+
+	// We preserve the owner and creation time of the file when rewriting it
+	owner, err := getEnvFileOwner(file)
+	if err != nil {
+		return err
+	}
+	_, created := getEnvFileHeader(file)
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+
+	content := envFileOwnerHeader + strconv.Itoa(owner) + "\n" + envFileCreatedHeader + strconv.FormatInt(created, 10) + "\n"
+	for _, h := range header {
+		content += h + "\n"
+	}
+	content += "export PATH=" + pathEnv + "\n"
+	content += "export LD_LIBRARY_PATH=" + ldlibEnv + "\n"
+	for _, c := range cmds {
+		content += c + "\n"
+	}
+	err = ioutil.WriteFile(file, []byte(content), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %s", file, err)
+	}
+	return nil
+}
+
+func getPPPID() (int, error) {
+	// We need to find the parent of our parent process
+	ppid := os.Getppid()
+	pppid := 0 // Only for now
+	parentInfoFile := filepath.Join("/proc", strconv.Itoa(ppid), "status")
+	procFile, err := os.Open(parentInfoFile)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open %s: %s", parentInfoFile, err)
+	}
+	defer procFile.Close()
+	for s := bufio.NewScanner(procFile); s.Scan(); {
+		var temp int
+		if n, _ := fmt.Sscanf(s.Text(), "PPid:\t%d", &temp); n == 1 {
+			pppid = temp
+		}
+	}
+
+	return pppid, nil
+}
+
+// GetEnvFile returns the absolute path to the file that is automatically sources while using
+// SyMPI, i.e., the file specified by sympi_init through SYMPI_ENVFILE or, by default, the file
+// owned by the parent of the shell.
+func GetEnvFile() (string, error) {
+	if os.Getenv(SYMPI_ENVFILE_ENV) != "" {
+		return os.Getenv(SYMPI_ENVFILE_ENV), nil
+	}
+
+	pppid, err := getPPPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get PPPID: %s", err)
+	}
+	filename := envFilePrefix + strconv.Itoa(pppid)
+	return filepath.Join(GetEnvFileDir(), filename), nil
+}
+
+// getProcessStartTime returns when a process was started, from its start time since the boot in
+// /proc/<pid>/stat and the boot time in /proc/stat
+func getProcessStartTime(pid int) (time.Time, error) {
+	statFile := filepath.Join("/proc", strconv.Itoa(pid), "stat")
+	data, err := ioutil.ReadFile(statFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read %s: %s", statFile, err)
+	}
+	// The name of the command may include spaces, the fields are counted from its end; the start
+	// time is the 22nd field, the state being the 3rd
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("invalid format of %s", statFile)
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start time in %s: %s", statFile, err)
+	}
+
+	data, err = ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read /proc/stat: %s", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var btime int64
+		if n, _ := fmt.Sscanf(line, "btime %d", &btime); n == 1 {
+			return time.Unix(btime, 0).Add(time.Duration(ticks) * time.Second / clockTicks), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("boot time not found in /proc/stat")
+}
+
+// processIsAlive checks whether the shell owning an environment file created at a given time
+// (UNIX timestamp, 0 if unknown) is running. The process must exist and have been started before
+// the file was created, the PID being otherwise reused by another process since the shell exited.
+func processIsAlive(pid int, created int64) bool {
+	if !util.PathExists(filepath.Join("/proc", strconv.Itoa(pid))) {
+		return false
+	}
+	if created == 0 {
+		return true
+	}
+	start, err := getProcessStartTime(pid)
+	if err != nil {
+		log.Printf("[WARN] unable to check whether the PID %d was reused: %s", pid, err)
+		return true
+	}
+	// The boot time being in seconds, the start time may be up to a second late
+	return start.Unix() <= created+1
+}
+
+// getStaleEnvFiles returns the environment files of a directory for which isAlive returns false
+// for the owner and the creation time of the file
+func getStaleEnvFiles(dir string, isAlive func(int, int64) bool) ([]string, error) {
+	return filterEnvFiles(dir, func(owner int, created int64) bool { return !isAlive(owner, created) })
+}
+
+// getLiveEnvFiles returns the environment files of a directory for which isAlive returns true
+// for the owner and the creation time of the file, i.e., the files of the running shells
+func getLiveEnvFiles(dir string, isAlive func(int, int64) bool) ([]string, error) {
+	return filterEnvFiles(dir, isAlive)
+}
+
+// filterEnvFiles returns the environment files of a directory for which keep returns true for the
+// owner and the creation time of the file (0 if unknown)
+func filterEnvFiles(dir string, keep func(int, int64) bool) ([]string, error) {
+	var files []string
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", dir, err)
+	}
+
+	for _, e := range entries {
+		if !e.Mode().IsRegular() || !envFileRegex.MatchString(e.Name()) {
+			continue
+		}
+		file := filepath.Join(dir, e.Name())
+		owner, err := getEnvFileOwner(file)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
+			continue
+		}
+		_, created := getEnvFileHeader(file)
+		if !keep(owner, created) {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// reapEnvFiles removes from a directory all the environment files for which isAlive
+// returns false for the owner and the creation time of the file
+func reapEnvFiles(dir string, isAlive func(int, int64) bool) ([]string, error) {
+	var removed []string
+
+	stale, err := getStaleEnvFiles(dir, isAlive)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range stale {
+		log.Printf("-> Removing stale environment file %s", file)
+		err = os.Remove(file)
+		if err != nil {
+			log.Printf("[WARN] failed to remove %s: %s", file, err)
+			continue
+		}
+		removed = append(removed, file)
+	}
+
+	return removed, nil
+}
+
+// getEnvFileDirs returns the directories where environment files may be stored, /tmp being
+// checked so files created before the environment files were moved are also found
+func getEnvFileDirs() []string {
+	dirs := []string{defaultEnvFileDir}
+	if d := GetEnvFileDir(); d != defaultEnvFileDir {
+		dirs = append(dirs, d)
+	}
+	return dirs
+}
+
+// ReapStaleEnvFiles removes the environment files of shells started with sympi_init that
+// are not running anymore. Both /tmp and $XDG_RUNTIME_DIR are checked so files created
+// before the environment files were moved are also reaped. The list of removed files is
+// returned.
+func ReapStaleEnvFiles() ([]string, error) {
+	var removed []string
+	for _, d := range getEnvFileDirs() {
+		files, err := reapEnvFiles(d, processIsAlive)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, files...)
+	}
+
+	return removed, nil
+}
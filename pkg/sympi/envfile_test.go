@@ -0,0 +1,121 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+func TestReapEnvFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-envfiles-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		filename string
+		content  string
+		reaped   bool
+	}{
+		{
+			filename: "sympi_1",
+			content:  "",
+			reaped:   false,
+		},
+		{
+			filename: "sympi_2",
+			content:  "",
+			reaped:   true,
+		},
+		{
+			// The header has precedence over the name of the file
+			filename: "sympi_3",
+			content:  envFileOwnerHeader + "1\n" + envFileCreatedHeader + "1570000000\nexport PATH=/usr/bin\n",
+			reaped:   false,
+		},
+		{
+			filename: "sympi_singularity.conf",
+			content:  "",
+			reaped:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		err := ioutil.WriteFile(filepath.Join(dir, tt.filename), []byte(tt.content), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", tt.filename, err)
+		}
+	}
+
+	isAlive := func(pid int, created int64) bool { return pid == 1 }
+	removed, err := reapEnvFiles(dir, isAlive)
+	if err != nil {
+		t.Fatalf("reapEnvFiles() failed: %s", err)
+	}
+	if len(removed) != 1 {
+		t.Fatalf("%d files were removed instead of 1", len(removed))
+	}
+	for _, tt := range tests {
+		if util.FileExists(filepath.Join(dir, tt.filename)) == tt.reaped {
+			t.Fatalf("%s: reaped is %t", tt.filename, !tt.reaped)
+		}
+	}
+}
+
+func TestProcessIsAlive(t *testing.T) {
+	start, err := getProcessStartTime(os.Getpid())
+	if err != nil {
+		t.Fatalf("getProcessStartTime() failed: %s", err)
+	}
+	if start.After(time.Now()) || time.Since(start) > time.Hour {
+		t.Fatalf("invalid start time of the current process: %s", start)
+	}
+
+	tests := []struct {
+		created int64
+		alive   bool
+	}{
+		{created: 0, alive: true},
+		{created: time.Now().Unix(), alive: true},
+		// The file was created before the process was started, the PID was reused
+		{created: start.Add(-time.Hour).Unix(), alive: false},
+	}
+	for _, tt := range tests {
+		if processIsAlive(os.Getpid(), tt.created) != tt.alive {
+			t.Fatalf("processIsAlive() returned %t for a file created at %d", !tt.alive, tt.created)
+		}
+	}
+}
+
+func TestUpdateEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-envfiles-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "sympi_42")
+	err = ioutil.WriteFile(file, []byte(envFileOwnerHeader+"42\n"+envFileCreatedHeader+"1570000000\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", file, err)
+	}
+
+	err = UpdateEnvFile(file, "/usr/bin", "/usr/lib")
+	if err != nil {
+		t.Fatalf("UpdateEnvFile() failed: %s", err)
+	}
+	owner, created := getEnvFileHeader(file)
+	if owner != 42 || created != 1570000000 {
+		t.Fatalf("header of the environment file was not preserved: owner=%d, created=%d", owner, created)
+	}
+}
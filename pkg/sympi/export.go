@@ -0,0 +1,432 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+)
+
+const (
+	// CompressionGzip is the compression of exported images with gzip, e.g., app.sif.gz
+	CompressionGzip = "gz"
+
+	// CompressionZstd is the compression of exported images with zstd, e.g., app.sif.zst, which
+	// requires the zstd command
+	CompressionZstd = "zstd"
+
+	// ExportManifestSuffix is the suffix of the manifest describing an exported image, e.g.,
+	// app.export.json, used to import it
+	ExportManifestSuffix = ".export.json"
+)
+
+// importPartSuffix is the suffix of the image being imported in a directory, e.g., app.sif.part,
+// which is renamed once the image is complete and checked
+const importPartSuffix = ".part"
+
+// compressionExts are the extensions of the compressed images
+var compressionExts = map[string]string{
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// ExportOptions specifies how an image is exported
+type ExportOptions struct {
+	// Dir is the directory where the image is exported
+	Dir string
+
+	// Compression is the compression of the image, i.e., CompressionGzip or CompressionZstd; the
+	// image is not compressed when empty
+	Compression string
+
+	// ChunkSize is the maximum size in bytes of the files the image is split into, the image is
+	// not split when 0
+	ChunkSize int64
+}
+
+// ExportChunk is a file of an exported image
+type ExportChunk struct {
+	// File is the name of the file, in the directory of the manifest
+	File string `json:"file"`
+
+	// Size is the size of the file
+	Size int64 `json:"size"`
+
+	// SHA256 is the checksum of the file
+	SHA256 string `json:"sha256"`
+}
+
+// ExportManifest describes an exported image so it can be reassembled and verified when imported
+type ExportManifest struct {
+	// Image is the name of the image, e.g., app.sif
+	Image string `json:"image"`
+
+	// Size is the size of the image
+	Size int64 `json:"size"`
+
+	// SHA256 is the checksum of the image
+	SHA256 string `json:"sha256"`
+
+	// Compression is the compression of the image, empty when not compressed
+	Compression string `json:"compression,omitempty"`
+
+	// Chunks are the files of the exported image, in order
+	Chunks []ExportChunk `json:"chunks"`
+}
+
+// ValidateCompression checks whether a compression of exported images is supported
+func ValidateCompression(compression string) error {
+	if _, ok := compressionExts[compression]; !ok {
+		return fmt.Errorf("invalid compression %s, it should be %s or %s", compression, CompressionGzip, CompressionZstd)
+	}
+	return nil
+}
+
+// hashFile returns the size and the checksum of a file
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// runZstd executes zstd to compress or, with -d, decompress a file
+func runZstd(args ...string) error {
+	zstdBin, err := exec.LookPath("zstd")
+	if err != nil {
+		return fmt.Errorf("zstd not found: %s", err)
+	}
+	cmd := exec.Command(zstdBin, append([]string{"-q", "-f"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = syexec.RunCmd(cmd)
+	if err != nil {
+		return fmt.Errorf("zstd failed: %s; stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// compressFile compresses a file into another file
+func compressFile(src string, dst string, compression string) error {
+	if compression == CompressionZstd {
+		return runZstd("-T0", "-o", dst, src)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", src, err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", dst, err)
+	}
+	defer out.Close()
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to compress %s: %s", src, err)
+	}
+	return out.Close()
+}
+
+// decompressFile decompresses a file into another file
+func decompressFile(src string, dst string, compression string) error {
+	if compression == CompressionZstd {
+		return runZstd("-d", "-o", dst, src)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", src, err)
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %s", src, err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", dst, err)
+	}
+	defer out.Close()
+	_, err = io.Copy(out, zr)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %s", src, err)
+	}
+	return out.Close()
+}
+
+// splitFile splits a file into chunks of a maximum size in a directory, the chunks being named
+// after the file, e.g., app.sif.gz.000
+func splitFile(path string, dir string, chunkSize int64) ([]ExportChunk, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer in.Close()
+
+	var chunks []ExportChunk
+	for {
+		chunk := ExportChunk{File: fmt.Sprintf("%s.%03d", filepath.Base(path), len(chunks))}
+		chunkPath := filepath.Join(dir, chunk.File)
+		out, err := os.Create(chunkPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %s", chunkPath, err)
+		}
+		h := sha256.New()
+		chunk.Size, err = io.CopyN(io.MultiWriter(out, h), in, chunkSize)
+		out.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to write %s: %s", chunkPath, err)
+		}
+		if chunk.Size == 0 && len(chunks) > 0 {
+			// The previous chunk ended with the file
+			os.Remove(chunkPath)
+			return chunks, nil
+		}
+		chunk.SHA256 = hex.EncodeToString(h.Sum(nil))
+		chunks = append(chunks, chunk)
+		if err == io.EOF {
+			return chunks, nil
+		}
+	}
+}
+
+// ExportImage exports an image in a directory, compressed and split into chunks if requested, and
+// returns the path to the exported image or, when compressed or split, to the manifest describing
+// the files of the exported image (see ImportExportedImage)
+func ExportImage(imgPath string, opts *ExportOptions) (string, error) {
+	err := os.MkdirAll(opts.Dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", opts.Dir, err)
+	}
+
+	imgName := filepath.Base(imgPath)
+	exportedPath := filepath.Join(opts.Dir, imgName)
+	if opts.Compression == "" && opts.ChunkSize == 0 {
+		err = util.CopyFile(imgPath, exportedPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to copy %s to %s: %s", imgPath, opts.Dir, err)
+		}
+		return exportedPath, nil
+	}
+
+	var m ExportManifest
+	m.Image = imgName
+	m.Compression = opts.Compression
+	m.Size, m.SHA256, err = hashFile(imgPath)
+	if err != nil {
+		return "", err
+	}
+
+	// The file that is split, i.e., the compressed image or the image itself
+	filePath := imgPath
+	if opts.Compression != "" {
+		filePath = exportedPath + compressionExts[opts.Compression]
+		err = compressFile(imgPath, filePath, opts.Compression)
+		if err != nil {
+			return "", err
+		}
+	}
+	if opts.ChunkSize > 0 {
+		m.Chunks, err = splitFile(filePath, opts.Dir, opts.ChunkSize)
+		if filePath != imgPath {
+			os.Remove(filePath)
+		}
+		if err != nil {
+			return "", err
+		}
+	} else {
+		var chunk ExportChunk
+		chunk.File = filepath.Base(filePath)
+		chunk.Size, chunk.SHA256, err = hashFile(filePath)
+		if err != nil {
+			return "", err
+		}
+		m.Chunks = []ExportChunk{chunk}
+	}
+
+	manifestPath := filepath.Join(opts.Dir, strings.TrimSuffix(imgName, ".sif")+ExportManifestSuffix)
+	data, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the manifest of %s: %s", imgName, err)
+	}
+	err = ioutil.WriteFile(manifestPath, append(data, '\n'), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %s", manifestPath, err)
+	}
+	return manifestPath, nil
+}
+
+// loadExportManifest loads and checks the manifest of an exported image
+func loadExportManifest(path string) (*ExportManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	var m ExportManifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", path, err)
+	}
+	if m.Image == "" || filepath.Base(m.Image) != m.Image || len(m.Chunks) == 0 {
+		return nil, fmt.Errorf("invalid manifest %s: no image", path)
+	}
+	if m.Compression != "" {
+		err = ValidateCompression(m.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %s", path, err)
+		}
+	}
+	return &m, nil
+}
+
+// GetExportedImageName returns the name of the image imported from an exported image with
+// ImportExportedImage, e.g., app.sif for app.export.json or app.sif.gz
+func GetExportedImageName(path string) (string, error) {
+	if strings.HasSuffix(path, ExportManifestSuffix) {
+		m, err := loadExportManifest(path)
+		if err != nil {
+			return "", err
+		}
+		return m.Image, nil
+	}
+	for _, ext := range compressionExts {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(filepath.Base(path), ext), nil
+		}
+	}
+	return filepath.Base(path), nil
+}
+
+// ImportExportedImage imports an image exported with ExportImage in a directory, checking the
+// checksums of all the files and of the image, and returns the path to the image. path is either
+// the manifest of the exported image, an image compressed with gzip or zstd (in which case
+// nothing is checked) or an image that is copied as is. The image is created with the
+// importPartSuffix suffix and only renamed once complete, an existing image being never replaced
+// by an incomplete or corrupted one.
+func ImportExportedImage(path string, dir string) (string, error) {
+	name, err := GetExportedImageName(path)
+	if err != nil {
+		return "", err
+	}
+	imgPath := filepath.Join(dir, name)
+	partPath := imgPath + importPartSuffix
+	defer os.Remove(partPath)
+
+	if !strings.HasSuffix(path, ExportManifestSuffix) {
+		err = importFile(path, partPath)
+		if err != nil {
+			return "", err
+		}
+		return imgPath, renamePart(partPath, imgPath)
+	}
+
+	m, err := loadExportManifest(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Chunks are checked while they are reassembled
+	filePath := partPath + compressionExts[m.Compression]
+	out, err := os.Create(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", filePath, err)
+	}
+	defer out.Close()
+	defer os.Remove(filePath)
+	for _, c := range m.Chunks {
+		chunkPath := filepath.Join(filepath.Dir(path), filepath.Base(c.File))
+		in, err := os.Open(chunkPath)
+		if err != nil {
+			return "", fmt.Errorf("missing file of the exported image: %s", err)
+		}
+		h := sha256.New()
+		size, err := io.Copy(io.MultiWriter(out, h), in)
+		in.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %s", chunkPath, err)
+		}
+		if size != c.Size || hex.EncodeToString(h.Sum(nil)) != c.SHA256 {
+			return "", fmt.Errorf("%s is corrupted: checksum mismatch", chunkPath)
+		}
+	}
+	err = out.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %s", filePath, err)
+	}
+
+	if m.Compression != "" {
+		err = decompressFile(filePath, partPath, m.Compression)
+		if err != nil {
+			return "", err
+		}
+	}
+	size, sum, err := hashFile(partPath)
+	if err != nil {
+		return "", err
+	}
+	if size != m.Size || sum != m.SHA256 {
+		return "", fmt.Errorf("reassembled image %s is corrupted: checksum mismatch", m.Image)
+	}
+	return imgPath, renamePart(partPath, imgPath)
+}
+
+// importFile decompresses an image compressed with gzip or zstd, other images being copied as is
+func importFile(path string, dst string) error {
+	for compression, ext := range compressionExts {
+		if strings.HasSuffix(path, ext) {
+			return decompressFile(path, dst, compression)
+		}
+	}
+	err := util.CopyFile(path, dst)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %s", path, dst, err)
+	}
+	return nil
+}
+
+// renamePart renames an imported image once complete
+func renamePart(partPath string, imgPath string) error {
+	err := os.Rename(partPath, imgPath)
+	if err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %s", partPath, imgPath, err)
+	}
+	return nil
+}
+
+// ExportContainer exports the image of a container of the workspace (see ExportImage)
+func ExportContainer(containerDesc string, opts *ExportOptions) (string, error) {
+	imgPath, err := getImagePath(containerDesc, nil)
+	if err != nil {
+		return "", err
+	}
+	return ExportImage(imgPath, opts)
+}
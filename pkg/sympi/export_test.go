@@ -0,0 +1,91 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImage(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "sympi-export-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	imgPath := filepath.Join(tempDir, "app.sif")
+	img := bytes.Repeat([]byte("SIF image content "), 1000)
+	err = ioutil.WriteFile(imgPath, img, 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", imgPath, err)
+	}
+
+	tests := []struct {
+		name   string
+		opts   ExportOptions
+		chunks int
+	}{
+		{name: "copy", opts: ExportOptions{}},
+		{name: "gz", opts: ExportOptions{Compression: CompressionGzip}, chunks: 1},
+		{name: "split", opts: ExportOptions{ChunkSize: 4096}, chunks: 5},
+		{name: "gz-split", opts: ExportOptions{Compression: CompressionGzip, ChunkSize: 64}},
+	}
+
+	for _, tt := range tests {
+		tt.opts.Dir = filepath.Join(tempDir, tt.name)
+		exported, err := ExportImage(imgPath, &tt.opts)
+		if err != nil {
+			t.Fatalf("%s: ExportImage() failed: %s", tt.name, err)
+		}
+		if tt.chunks > 0 {
+			files, _ := filepath.Glob(filepath.Join(tt.opts.Dir, "app.sif*"))
+			if len(files) != tt.chunks {
+				t.Fatalf("%s: ExportImage() created %d files instead of %d", tt.name, len(files), tt.chunks)
+			}
+		}
+
+		importDir := filepath.Join(tempDir, tt.name+"-import")
+		err = os.MkdirAll(importDir, 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", importDir, err)
+		}
+		imported, err := ImportExportedImage(exported, importDir)
+		if err != nil {
+			t.Fatalf("%s: ImportExportedImage() failed: %s", tt.name, err)
+		}
+		data, err := ioutil.ReadFile(imported)
+		if err != nil || !bytes.Equal(data, img) {
+			t.Fatalf("%s: imported image %s differs from the exported image", tt.name, imported)
+		}
+	}
+
+	// A corrupted chunk is detected
+	chunk := filepath.Join(tempDir, "split", "app.sif.002")
+	err = ioutil.WriteFile(chunk, bytes.Repeat([]byte("x"), 4096), 0644)
+	if err != nil {
+		t.Fatalf("failed to corrupt %s: %s", chunk, err)
+	}
+	importDir := filepath.Join(tempDir, "split-import")
+	_, err = ImportExportedImage(filepath.Join(tempDir, "split", "app"+ExportManifestSuffix), importDir)
+	if err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Fatalf("ImportExportedImage() did not detect the corrupted chunk: %v", err)
+	}
+
+	// The image previously imported is not replaced and the partial image is removed
+	data, err := ioutil.ReadFile(filepath.Join(importDir, "app.sif"))
+	if err != nil || !bytes.Equal(data, img) {
+		t.Fatalf("the imported image was replaced by the corrupted image (%v)", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(importDir, "*"+importPartSuffix+"*"))
+	if len(files) != 0 {
+		t.Fatalf("partial images left after the failed import: %v", files)
+	}
+}
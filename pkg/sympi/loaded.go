@@ -0,0 +1,341 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// RoleCompile is the role of a MPI used to compile applications: its compiler wrappers are
+	// specified with MPICC, MPICXX and MPIFC
+	RoleCompile = "compile"
+
+	// RoleRun is the role of a MPI used to run applications: its mpirun and libraries have
+	// precedence in PATH and LD_LIBRARY_PATH
+	RoleRun = "run"
+
+	// RoleAll is the role of a component used both to compile and run applications
+	RoleAll = "all"
+
+	// envFileLoadedHeader is the comment, at the beginning of an environment file, that specifies
+	// a loaded component and its role, e.g., '# sympi loaded: run openmpi:4.0.2'; components are
+	// listed by order of precedence
+	envFileLoadedHeader = "# sympi loaded: "
+
+	// singularityPrefix is the prefix of the names of the installations of Singularity
+	singularityPrefix = "singularity:"
+)
+
+// compilerVars are the environment variables specifying the compiler wrappers of the MPI loaded
+// to compile applications, which build systems such as mpi4py's use
+var compilerVars = []struct {
+	name    string
+	wrapper string
+}{
+	{"MPICC", "mpicc"},
+	{"MPICXX", "mpicxx"},
+	{"MPIFC", "mpifort"},
+}
+
+// LoadedComponent is a component loaded in the environment, i.e., an installation of MPI or
+// Singularity in the workspace
+type LoadedComponent struct {
+	// Name is the name of the installation, e.g., openmpi:4.0.2 or singularity:3.5.2
+	Name string
+
+	// Role is the role of the component, i.e., RoleCompile, RoleRun or RoleAll; Singularity
+	// always has RoleAll
+	Role string
+}
+
+// IsSingularity checks whether the component is an installation of Singularity
+func (c *LoadedComponent) IsSingularity() bool {
+	return strings.HasPrefix(c.Name, singularityPrefix)
+}
+
+// HasRole checks whether the component is used for a given role
+func (c *LoadedComponent) HasRole(role string) bool {
+	return c.Role == RoleAll || c.Role == role
+}
+
+// getInstallDir returns the directory of the installation of the component in the workspace
+func (c *LoadedComponent) getInstallDir() string {
+	if c.IsSingularity() {
+		return filepath.Join(sys.GetSympiDir(), sys.SingularityInstallDirPrefix+strings.TrimPrefix(c.Name, singularityPrefix))
+	}
+	return filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+strings.Replace(c.Name, ":", "-", 1))
+}
+
+// ValidateRole checks whether a role of a loaded MPI is valid
+func ValidateRole(role string) error {
+	switch role {
+	case RoleCompile, RoleRun, RoleAll:
+		return nil
+	}
+	return fmt.Errorf("invalid role %s, it should be %s, %s or %s", role, RoleCompile, RoleRun, RoleAll)
+}
+
+// getOtherRole returns the role of a MPI loaded for both roles once another MPI is loaded for one
+func getOtherRole(role string) string {
+	if role == RoleCompile {
+		return RoleRun
+	}
+	return RoleCompile
+}
+
+// sortByPrecedence sorts a stack of components so that the components used to run applications
+// have precedence over the ones only used to compile them, the order being preserved otherwise
+func sortByPrecedence(stack []LoadedComponent) {
+	sort.SliceStable(stack, func(i, j int) bool {
+		return stack[i].HasRole(RoleRun) && !stack[j].HasRole(RoleRun)
+	})
+}
+
+// getLoadedFromPath returns the components whose bin directory is in a PATH, which is the case
+// of the components loaded before the environment file recorded them
+func getLoadedFromPath(path string) []LoadedComponent {
+	var stack []LoadedComponent
+	for _, dir := range strings.Split(path, ":") {
+		if filepath.Base(dir) != "bin" || filepath.Dir(filepath.Dir(dir)) != filepath.Clean(sys.GetSympiDir()) {
+			continue
+		}
+		installDir := filepath.Base(filepath.Dir(dir))
+		switch {
+		case strings.HasPrefix(installDir, sys.MPIInstallDirPrefix):
+			name := strings.Replace(strings.TrimPrefix(installDir, sys.MPIInstallDirPrefix), "-", ":", 1)
+			stack = append(stack, LoadedComponent{Name: name, Role: RoleAll})
+		case strings.HasPrefix(installDir, sys.SingularityInstallDirPrefix):
+			stack = append(stack, LoadedComponent{Name: singularityPrefix + strings.TrimPrefix(installDir, sys.SingularityInstallDirPrefix), Role: RoleAll})
+		}
+	}
+	return stack
+}
+
+// getLoadedFromEnvFile returns the components recorded in the header of an environment file
+func getLoadedFromEnvFile(file string) []LoadedComponent {
+	var stack []LoadedComponent
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	for s := bufio.NewScanner(f); s.Scan(); {
+		line := s.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		tokens := strings.Fields(strings.TrimPrefix(line, envFileLoadedHeader))
+		if strings.HasPrefix(line, envFileLoadedHeader) && len(tokens) == 2 {
+			stack = append(stack, LoadedComponent{Name: tokens[1], Role: tokens[0]})
+		}
+	}
+	return stack
+}
+
+// GetLoaded returns the components loaded in the environment by order of precedence, as recorded
+// in an environment file or, when the file does not record any, as found in PATH
+func GetLoaded(file string) []LoadedComponent {
+	stack := getLoadedFromEnvFile(file)
+	if len(stack) == 0 {
+		stack = getLoadedFromPath(os.Getenv("PATH"))
+	}
+	return stack
+}
+
+// pushLoaded adds a component to a stack, replacing the components it supersedes: the previous
+// Singularity, or the MPI previously loaded for the same role
+func pushLoaded(stack []LoadedComponent, c LoadedComponent) []LoadedComponent {
+	newStack := []LoadedComponent{c}
+	for _, e := range stack {
+		switch {
+		case e.Name == c.Name || e.IsSingularity() && c.IsSingularity():
+			continue
+		case e.IsSingularity() || c.IsSingularity():
+		case c.Role == RoleAll || e.Role == c.Role:
+			continue
+		case e.Role == RoleAll:
+			e.Role = getOtherRole(c.Role)
+		}
+		newStack = append(newStack, e)
+	}
+	sortByPrecedence(newStack)
+	return newStack
+}
+
+// popLoaded removes components from a stack: all the MPIs ('mpi'), Singularity ('singularity'),
+// the MPI loaded for a role ('compile' or 'run') or a component designated by its name
+func popLoaded(stack []LoadedComponent, what string) ([]LoadedComponent, error) {
+	var newStack []LoadedComponent
+	found := false
+	for _, e := range stack {
+		switch {
+		case what == "mpi" && !e.IsSingularity(),
+			what == "singularity" && e.IsSingularity(),
+			what == e.Name,
+			what == e.Role && !e.IsSingularity():
+			found = true
+			continue
+		case (what == RoleCompile || what == RoleRun) && e.Role == RoleAll && !e.IsSingularity():
+			found = true
+			e.Role = getOtherRole(what)
+		}
+		newStack = append(newStack, e)
+	}
+	if !found && what != "mpi" && what != "singularity" {
+		return nil, fmt.Errorf("%s is not loaded", what)
+	}
+	return newStack, nil
+}
+
+// removeInstallDirs removes the directories of the installations of the workspace from a list
+// of directories, e.g., PATH
+func removeInstallDirs(dirs string) []string {
+	var cleaned []string
+	for _, d := range strings.Split(dirs, ":") {
+		if !strings.Contains(d, sys.MPIInstallDirPrefix) && !strings.Contains(d, sys.SingularityInstallDirPrefix) {
+			cleaned = append(cleaned, d)
+		}
+	}
+	return cleaned
+}
+
+// getLoadedEnv returns PATH, LD_LIBRARY_PATH and the commands setting the compiler wrappers for
+// a stack of components
+func getLoadedEnv(stack []LoadedComponent) (string, string, []string) {
+	var binDirs, libDirs, cmds []string
+	compileDir := ""
+	for _, c := range stack {
+		dir := c.getInstallDir()
+		binDirs = append(binDirs, filepath.Join(dir, "bin"))
+		libDirs = append(libDirs, filepath.Join(dir, "lib"))
+		if compileDir == "" && !c.IsSingularity() && c.HasRole(RoleCompile) {
+			compileDir = dir
+		}
+	}
+	path := append(binDirs, removeInstallDirs(os.Getenv("PATH"))...)
+	ldlib := append(libDirs, removeInstallDirs(os.Getenv("LD_LIBRARY_PATH"))...)
+
+	for _, v := range compilerVars {
+		if compileDir != "" {
+			cmds = append(cmds, "export "+v.name+"="+filepath.Join(compileDir, "bin", v.wrapper))
+		} else if strings.Contains(os.Getenv(v.name), sys.MPIInstallDirPrefix) {
+			// Variables set by the tool are the only ones unset
+			cmds = append(cmds, "unset "+v.name)
+		}
+	}
+	return strings.Join(path, ":"), strings.Join(ldlib, ":"), cmds
+}
+
+// updateLoaded updates the environment file to load a stack of components
+func updateLoaded(file string, stack []LoadedComponent) error {
+	var header []string
+	for _, c := range stack {
+		header = append(header, envFileLoadedHeader+c.Role+" "+c.Name)
+	}
+	path, ldlib, cmds := getLoadedEnv(stack)
+	return writeEnvFile(file, path, ldlib, header, cmds)
+}
+
+// getEnvFileForUpdate returns the environment file, which must exist, i.e., sympi must be used
+// from a shell started with sympi_init
+func getEnvFileForUpdate() (string, error) {
+	file, err := GetEnvFile()
+	if err != nil || !util.FileExists(file) {
+		return "", fmt.Errorf("file %s does not exist", file)
+	}
+	return file, nil
+}
+
+// Load loads an installation of MPI or Singularity, e.g., openmpi:4.0.2 or singularity:3.5.2, in
+// the current environment. MPI is loaded for a role, i.e., RoleCompile, RoleRun or RoleAll, and
+// replaces the MPI previously loaded for that role; the MPI loaded to run applications has
+// precedence over the MPI only loaded to compile them.
+func Load(name string, role string) error {
+	c := LoadedComponent{Name: name, Role: role}
+	if c.IsSingularity() {
+		c.Role = RoleAll
+	}
+	err := ValidateRole(c.Role)
+	if err != nil {
+		return err
+	}
+	if len(strings.Split(name, ":")) != 2 || !util.IsDir(c.getInstallDir()) {
+		return fmt.Errorf("%s is not installed, execute 'sympi -list' to get the list of available installations", name)
+	}
+
+	file, err := getEnvFileForUpdate()
+	if err != nil {
+		return err
+	}
+	err = updateLoaded(file, pushLoaded(GetLoaded(file), c))
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %s", file, err)
+	}
+	return nil
+}
+
+// Unload removes components from the current environment: all the MPIs ('mpi'), Singularity
+// ('singularity'), the MPI loaded for a role ('compile' or 'run') or a component designated by its
+// name, e.g., openmpi:4.0.2
+func Unload(what string) error {
+	file, err := getEnvFileForUpdate()
+	if err != nil {
+		return err
+	}
+	stack, err := popLoaded(GetLoaded(file), what)
+	if err != nil {
+		return err
+	}
+	err = updateLoaded(file, stack)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %s", file, err)
+	}
+	return nil
+}
+
+// removeDirsWithPrefix removes the directories including a prefix from a list of directories
+func removeDirsWithPrefix(dirs string, prefix string) []string {
+	var cleaned []string
+	for _, d := range strings.Split(dirs, ":") {
+		if !strings.Contains(d, prefix) {
+			cleaned = append(cleaned, d)
+		}
+	}
+	return cleaned
+}
+
+// GetCleanedUpSyEnvVars parses the current environment and returns PATH and LD_LIBRARY_PATH
+// without the installations of Singularity of the workspace.
+//
+// Deprecated: the environment is computed from the loaded components, use Load and Unload.
+func GetCleanedUpSyEnvVars() ([]string, []string) {
+	return removeDirsWithPrefix(os.Getenv("PATH"), sys.SingularityInstallDirPrefix), removeDirsWithPrefix(os.Getenv("LD_LIBRARY_PATH"), sys.SingularityInstallDirPrefix)
+}
+
+// GetCleanedUpMPIEnvVars parses the current environment and returns PATH and LD_LIBRARY_PATH
+// without the installations of MPI of the workspace.
+//
+// Deprecated: the environment is computed from the loaded components, use Load and Unload.
+func GetCleanedUpMPIEnvVars() ([]string, []string) {
+	return removeDirsWithPrefix(os.Getenv("PATH"), sys.MPIInstallDirPrefix), removeDirsWithPrefix(os.Getenv("LD_LIBRARY_PATH"), sys.MPIInstallDirPrefix)
+}
+
+// LoadMPI loads a specific implementation of MPI, e.g., openmpi:4.0.2, in the current environment,
+// to both compile and run applications.
+//
+// Deprecated: use Load, which specifies the role of the MPI.
+func LoadMPI(id string) error {
+	return Load(id, RoleAll)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-loaded-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	defer os.Setenv(SYMPI_ENVFILE_ENV, os.Getenv(SYMPI_ENVFILE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	for _, d := range []string{sys.MPIInstallDirPrefix + "openmpi-4.0.2", sys.MPIInstallDirPrefix + "mpich-3.3.2", sys.SingularityInstallDirPrefix + "3.5.2"} {
+		err = os.MkdirAll(filepath.Join(sys.GetSympiDir(), d, "bin"), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+	envFile := filepath.Join(dir, "sympi_42")
+	err = ioutil.WriteFile(envFile, []byte(envFileOwnerHeader+"42\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", envFile, err)
+	}
+	os.Setenv(SYMPI_ENVFILE_ENV, envFile)
+
+	tests := []struct {
+		load     string
+		role     string
+		unload   string
+		expected []LoadedComponent
+	}{
+		{load: "openmpi:4.0.2", role: RoleAll, expected: []LoadedComponent{{"openmpi:4.0.2", RoleAll}}},
+		{load: "mpich:3.3.2", role: RoleCompile, expected: []LoadedComponent{{"openmpi:4.0.2", RoleRun}, {"mpich:3.3.2", RoleCompile}}},
+		{load: "singularity:3.5.2", role: RoleAll, expected: []LoadedComponent{{"singularity:3.5.2", RoleAll}, {"openmpi:4.0.2", RoleRun}, {"mpich:3.3.2", RoleCompile}}},
+		{load: "mpich:3.3.2", role: RoleRun, expected: []LoadedComponent{{"mpich:3.3.2", RoleRun}, {"singularity:3.5.2", RoleAll}}},
+		{unload: "mpi", expected: []LoadedComponent{{"singularity:3.5.2", RoleAll}}},
+		{load: "mpich:3.3.2", role: RoleRun, expected: []LoadedComponent{{"mpich:3.3.2", RoleRun}, {"singularity:3.5.2", RoleAll}}},
+	}
+
+	for _, tt := range tests {
+		if tt.load != "" {
+			err = Load(tt.load, tt.role)
+		} else {
+			err = Unload(tt.unload)
+		}
+		if err != nil {
+			t.Fatalf("failed to load %s/unload %s: %s", tt.load, tt.unload, err)
+		}
+		stack := GetLoaded(envFile)
+		if !reflect.DeepEqual(stack, tt.expected) {
+			t.Fatalf("after loading %s/unloading %s, loaded components are %v instead of %v", tt.load, tt.unload, stack, tt.expected)
+		}
+	}
+
+	// The MPI loaded to run applications has precedence in PATH, the one loaded to compile them
+	// provides the compiler wrappers
+	err = Load("openmpi:4.0.2", RoleCompile)
+	if err != nil {
+		t.Fatalf("failed to load openmpi:4.0.2: %s", err)
+	}
+	content, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", envFile, err)
+	}
+	mpichBin := filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+"mpich-3.3.2", "bin")
+	ompiBin := filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+"openmpi-4.0.2", "bin")
+	if !strings.Contains(string(content), "export PATH="+mpichBin+":") || !strings.Contains(string(content), "/bin:"+ompiBin+":") ||
+		!strings.Contains(string(content), "export MPICC="+ompiBin+"/mpicc\n") {
+		t.Fatalf("invalid environment file:\n%s", content)
+	}
+
+	if Load("openmpi:5.0.0", RoleAll) == nil || Load("mpich:3.3.2", "debug") == nil || Unload("intel:2019") == nil {
+		t.Fatalf("invalid load/unload succeeded")
+	}
+}
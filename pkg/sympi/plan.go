@@ -0,0 +1,56 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"fmt"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/scheduler"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// GetExperimentsSelection returns the selection of experiments given on the command line: the
+// include and exclude filters, each being a list of filters separated by ';', and the sampling
+// strategy, empty to use the one of the configuration file
+func GetExperimentsSelection(include string, exclude string, sample string) (*scheduler.Selection, error) {
+	sel := new(scheduler.Selection)
+	filters, err := scheduler.ParseFilters(include, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include filters: %s", err)
+	}
+	sel.Filters = append(sel.Filters, filters...)
+	filters, err = scheduler.ParseFilters(exclude, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude filters: %s", err)
+	}
+	sel.Filters = append(sel.Filters, filters...)
+	if sample != "" {
+		s, err := scheduler.ParseSampling(sample)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sampling strategy: %s", err)
+		}
+		sel.Sampling = &s
+	}
+	return sel, nil
+}
+
+// PlanExperiments returns the resolved list of the experiments of a configuration file, or of a
+// preset when no file exists at the given path, restricted by a selection from the command line,
+// in the order they are executed and without executing anything (dry run)
+func PlanExperiments(src string, sel *scheduler.Selection, sysCfg *sys.Config) (string, error) {
+	var exps []scheduler.Experiment
+	var err error
+	if util.FileExists(src) {
+		exps, err = scheduler.LoadSelectedExperiments(src, sel)
+	} else {
+		exps, err = scheduler.LoadSelectedPreset(src, sel, sysCfg)
+	}
+	if err != nil {
+		return "", err
+	}
+	return scheduler.FormatPlan(scheduler.PlanExperiments(exps, nil)), nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestPlanExperiments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-plan-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "experiments.conf")
+	content := "exclude host=3.1.4\nopenmpi:3.1.4 openmpi:3.1.4\nopenmpi:4.0.2 openmpi:3.1.4\nopenmpi:4.0.2 openmpi:4.0.2\nopenmpi:4.0.5 openmpi:4.0.2\n"
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", path, err)
+	}
+
+	tests := []struct {
+		name     string
+		include  string
+		exclude  string
+		sample   string
+		expected string
+	}{
+		{
+			name:     "configuration file only",
+			expected: "openmpi:4.0.2 openmpi:3.1.4\nopenmpi:4.0.2 openmpi:4.0.2\nopenmpi:4.0.5 openmpi:4.0.2\n",
+		},
+		{
+			name:     "include and exclude",
+			include:  "upper-triangular",
+			exclude:  "host=4.0.5",
+			expected: "openmpi:4.0.2 openmpi:4.0.2\n",
+		},
+		{
+			name:     "sampling",
+			sample:   "diagonal",
+			expected: "openmpi:4.0.2 openmpi:4.0.2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := GetExperimentsSelection(tt.include, tt.exclude, tt.sample)
+			if err != nil {
+				t.Fatalf("GetExperimentsSelection() failed: %s", err)
+			}
+			plan, err := PlanExperiments(path, sel, &sys.Config{})
+			if err != nil {
+				t.Fatalf("PlanExperiments() failed: %s", err)
+			}
+			if plan != tt.expected {
+				t.Fatalf("PlanExperiments() returned:\n%s\ninstead of:\n%s", plan, tt.expected)
+			}
+		})
+	}
+
+	_, err = GetExperimentsSelection("host>>4.0", "", "")
+	if err == nil {
+		t.Fatalf("GetExperimentsSelection() succeeded with an invalid filter")
+	}
+	_, err = GetExperimentsSelection("", "", "random:0")
+	if err == nil {
+		t.Fatalf("GetExperimentsSelection() succeeded with an invalid sampling strategy")
+	}
+	_, err = PlanExperiments("unknown-preset", nil, &sys.Config{})
+	if err == nil {
+		t.Fatalf("PlanExperiments() succeeded with an unknown preset")
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// relocationFilename is the name of the file of an installation of MPI recording the
+	// directory where MPI was installed, i.e., the prefix used in the installed files
+	relocationFilename = "relocation.json"
+
+	// manifestSuffix is the suffix of the manifests of an installation
+	manifestSuffix = ".MANIFEST"
+
+	// textProbeSize is the number of bytes checked to figure out whether a file is a text file
+	textProbeSize = 8000
+)
+
+// RelocationInfo is the relocation metadata of an installation of MPI
+type RelocationInfo struct {
+	// Prefix is the directory where MPI was installed
+	Prefix string `json:"prefix"`
+
+	// MPI is the MPI implementation and its version, e.g., openmpi:4.0.2
+	MPI string `json:"mpi"`
+
+	// Arch is the architecture of the host where MPI was built, e.g., amd64
+	Arch string `json:"arch"`
+
+	// Date is the date of the installation or of the last relocation
+	Date string `json:"date"`
+}
+
+// getMPIInstallDir returns the installation directory of a MPI in the workspace, e.g., openmpi:4.0.2
+func getMPIInstallDir(mpiDesc string) (string, error) {
+	id, version := GetMPIDetails(mpiDesc)
+	if id == "" {
+		return "", fmt.Errorf("invalid MPI %s", mpiDesc)
+	}
+	return filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+id+"-"+version), nil
+}
+
+// SaveRelocationInfo records the prefix used in the files of an installation of MPI, usually
+// its installation directory, so it can be relocated
+func SaveRelocationInfo(installDir string, prefix string, mpiDesc string) error {
+	info := RelocationInfo{
+		Prefix: prefix,
+		MPI:    mpiDesc,
+		Arch:   runtime.GOARCH,
+		Date:   time.Now().Format(time.RFC3339),
+	}
+	content, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize the relocation metadata: %s", err)
+	}
+	path := filepath.Join(installDir, relocationFilename)
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return nil
+}
+
+// detectPrefix figures out the prefix of an installation without relocation metadata from its
+// pkg-config files, e.g., the prefix=/path/to/install line of lib/pkgconfig/mpich.pc
+func detectPrefix(installDir string) string {
+	pcFiles, _ := filepath.Glob(filepath.Join(installDir, "lib", "pkgconfig", "*.pc"))
+	for _, pc := range pcFiles {
+		f, err := os.Open(pc)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "prefix=") {
+				f.Close()
+				return strings.TrimPrefix(line, "prefix=")
+			}
+		}
+		f.Close()
+	}
+	return ""
+}
+
+// LoadRelocationInfo returns the relocation metadata of an installation of MPI; the prefix is
+// detected from the installed files for installations without metadata
+func LoadRelocationInfo(installDir string) (RelocationInfo, error) {
+	var info RelocationInfo
+	path := filepath.Join(installDir, relocationFilename)
+	if !util.FileExists(path) {
+		info.Prefix = detectPrefix(installDir)
+		if info.Prefix == "" {
+			return info, fmt.Errorf("unable to figure out the prefix of %s", installDir)
+		}
+		return info, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return info, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	err = json.Unmarshal(content, &info)
+	if err != nil {
+		return info, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	return info, nil
+}
+
+// isTextFile checks whether a file is a text file, e.g., a wrapper script or a pkg-config file,
+// based on the absence of NUL bytes at its beginning
+func isTextFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, textProbeSize)
+	n, _ := f.Read(buf)
+	return !bytes.Contains(buf[:n], []byte{0})
+}
+
+// rollback gathers the operations restoring the files modified by a relocation, so an
+// installation is never left partially relocated
+type rollback []func() error
+
+// restoreFile adds the restoration of the original content of a file to the rollback
+func (r *rollback) restoreFile(path string, content []byte, mode os.FileMode) {
+	*r = append(*r, func() error {
+		// The file may be read-only, e.g., a manifest
+		os.Remove(path)
+		return ioutil.WriteFile(path, content, mode)
+	})
+}
+
+// restoreSymlink adds the restoration of the original target of a symlink to the rollback
+func (r *rollback) restoreSymlink(path string, target string) {
+	*r = append(*r, func() error {
+		os.Remove(path)
+		return os.Symlink(target, path)
+	})
+}
+
+// run undoes the modifications in the reverse order
+func (r rollback) run() error {
+	var errs []string
+	for i := len(r) - 1; i >= 0; i-- {
+		err := r[i]()
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to restore %d file(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// rewritePrefix replaces the old prefix by the new one in a text file, keeping its permissions;
+// the boolean is true when the file was modified
+func rewritePrefix(path string, info os.FileInfo, oldPrefix string, newPrefix string, rb *rollback) (bool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	if !bytes.Contains(content, []byte(oldPrefix)) {
+		return false, nil
+	}
+	rb.restoreFile(path, content, info.Mode().Perm())
+	err = ioutil.WriteFile(path, bytes.ReplaceAll(content, []byte(oldPrefix), []byte(newPrefix)), info.Mode().Perm())
+	if err != nil {
+		return false, fmt.Errorf("failed to write %s: %s", path, err)
+	}
+	return true, nil
+}
+
+// relocateManifest updates the paths and hashes of the files of the installation recorded in a
+// manifest, the content of some of them having changed; the other lines are kept as is
+func relocateManifest(path string, oldPrefix string, newPrefix string, rb *rollback) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %s", path, err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		tokens := strings.Split(line, ": ")
+		if len(tokens) != 2 || !strings.HasPrefix(tokens[0], oldPrefix+"/") {
+			continue
+		}
+		lines[i] = manifest.HashFiles([]string{newPrefix + strings.TrimPrefix(tokens[0], oldPrefix)})[0]
+	}
+
+	// Manifests are read-only
+	rb.restoreFile(path, content, fi.Mode().Perm())
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %s", path, err)
+	}
+	return manifest.Create(path, lines)
+}
+
+// RelocateMPI rewrites the references to the prefix of an installation of MPI, e.g., in the
+// compiler wrappers, the pkg-config files and the libtool .la files, after the installation was
+// moved or imported. It returns the number of modified files. Compiled binaries are not
+// modified, the prefix of Open MPI must be set with OPAL_PREFIX when it changed. When the
+// relocation fails, the files that were already modified are restored.
+func RelocateMPI(installDir string) (int, error) {
+	installDir = filepath.Clean(installDir)
+	info, err := LoadRelocationInfo(installDir)
+	if err != nil {
+		return 0, err
+	}
+	oldPrefix := filepath.Clean(info.Prefix)
+	if oldPrefix == installDir {
+		return 0, nil
+	}
+
+	var rb rollback
+	modified, err := relocateFiles(installDir, oldPrefix, &rb)
+	if err == nil {
+		err = SaveRelocationInfo(installDir, installDir, info.MPI)
+	}
+	if err != nil {
+		rbErr := rb.run()
+		if rbErr != nil {
+			return 0, fmt.Errorf("%s; the installation is partially relocated: %s", err, rbErr)
+		}
+		return 0, err
+	}
+	return modified, nil
+}
+
+// relocateFiles rewrites the references to the old prefix in the files of an installation and
+// returns the number of modified files, the operations undoing the modifications being added to
+// the rollback
+func relocateFiles(installDir string, oldPrefix string, rb *rollback) (int, error) {
+	modified := 0
+	var manifests []string
+	err := filepath.Walk(installDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil || !strings.HasPrefix(target, oldPrefix) {
+				return nil
+			}
+			err = os.Remove(path)
+			if err != nil {
+				return fmt.Errorf("failed to remove %s: %s", path, err)
+			}
+			rb.restoreSymlink(path, target)
+			err = os.Symlink(installDir+strings.TrimPrefix(target, oldPrefix), path)
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %s", path, err)
+			}
+			modified++
+		case !fi.Mode().IsRegular() || fi.Name() == relocationFilename:
+			// Nothing to relocate
+		case strings.HasSuffix(fi.Name(), manifestSuffix):
+			// Manifests are updated once all the files are relocated
+			manifests = append(manifests, path)
+		case isTextFile(path):
+			changed, err := rewritePrefix(path, fi, oldPrefix, installDir, rb)
+			if err != nil {
+				return err
+			}
+			if changed {
+				modified++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return modified, fmt.Errorf("failed to relocate %s: %s", installDir, err)
+	}
+
+	for _, m := range manifests {
+		err = relocateManifest(m, oldPrefix, installDir, rb)
+		if err != nil {
+			return modified, err
+		}
+	}
+	return modified, nil
+}
+
+// RelocateWorkspace relocates all the installations of MPI of the workspace whose prefix does
+// not match their current location, e.g., after the workspace was moved, and returns their list
+func RelocateWorkspace() ([]string, error) {
+	var relocated []string
+	entries, err := ioutil.ReadDir(sys.GetSympiDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", sys.GetSympiDir(), err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), sys.MPIInstallDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(sys.GetSympiDir(), e.Name())
+		n, err := RelocateMPI(dir)
+		if err != nil {
+			return relocated, err
+		}
+		if n > 0 {
+			relocated = append(relocated, dir)
+		}
+	}
+	return relocated, nil
+}
+
+// runTar executes tar with the given arguments
+func runTar(args ...string) (string, error) {
+	tarPath, err := exec.LookPath("tar")
+	if err != nil {
+		return "", fmt.Errorf("tar is not available: %s", err)
+	}
+	var cmd syexec.SyCmd
+	cmd.BinPath = tarPath
+	cmd.CmdArgs = args
+	res := cmd.Run()
+	if res.Err != nil {
+		return "", fmt.Errorf("tar %s failed: %s (stdout: %s; stderr: %s)", strings.Join(args, " "), res.Err, res.Stdout, res.Stderr)
+	}
+	return res.Stdout, nil
+}
+
+// ExportMPI creates a tarball of an installation of MPI of the workspace, e.g., openmpi:4.0.2,
+// with its relocation metadata so it can be imported in another workspace, possibly on another
+// machine. The tarball is created in a given directory and its path returned.
+func ExportMPI(mpiDesc string, targetDir string) (string, error) {
+	installDir, err := getMPIInstallDir(mpiDesc)
+	if err != nil {
+		return "", err
+	}
+	if !util.IsDir(installDir) {
+		return "", fmt.Errorf("%s is not installed", mpiDesc)
+	}
+
+	// Installations created before relocation was supported do not have any metadata
+	if !util.FileExists(filepath.Join(installDir, relocationFilename)) {
+		info, err := LoadRelocationInfo(installDir)
+		if err != nil {
+			return "", err
+		}
+		err = SaveRelocationInfo(installDir, info.Prefix, mpiDesc)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	target, err := filepath.Abs(filepath.Join(targetDir, filepath.Base(installDir)+".tar.gz"))
+	if err != nil {
+		return "", fmt.Errorf("failed to get the absolute path of the tarball: %s", err)
+	}
+	if util.PathExists(target) {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+	_, err = runTar("-czf", target, "-C", filepath.Dir(installDir), filepath.Base(installDir))
+	if err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// ImportMPI extracts a tarball created by ExportMPI in the workspace and relocates the
+// installation of MPI to its new location; nothing is left in the workspace when the import fails
+func ImportMPI(tarball string) (string, error) {
+	list, err := runTar("-tzf", tarball)
+	if err != nil {
+		return "", err
+	}
+	name := strings.Split(strings.TrimPrefix(strings.SplitN(list, "\n", 2)[0], "./"), "/")[0]
+	if !strings.HasPrefix(name, sys.MPIInstallDirPrefix) {
+		return "", fmt.Errorf("%s is not an export of an installation of MPI", tarball)
+	}
+	installDir := filepath.Join(sys.GetSympiDir(), name)
+	if util.PathExists(installDir) {
+		return "", fmt.Errorf("%s already exists", installDir)
+	}
+
+	_, err = runTar("-xzf", tarball, "-C", sys.GetSympiDir())
+	if err != nil {
+		os.RemoveAll(installDir)
+		return "", err
+	}
+	info, err := LoadRelocationInfo(installDir)
+	if err != nil {
+		os.RemoveAll(installDir)
+		return "", err
+	}
+	if info.Arch != "" && info.Arch != runtime.GOARCH {
+		os.RemoveAll(installDir)
+		return "", fmt.Errorf("%s was built for %s, which is incompatible with the host (%s)", info.MPI, info.Arch, runtime.GOARCH)
+	}
+	_, err = RelocateMPI(installDir)
+	if err != nil {
+		os.RemoveAll(installDir)
+		return "", err
+	}
+	return installDir, nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRelocateMPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-relocate-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldPrefix := filepath.Join(dir, "old", "mpi_install_mpich-3.3")
+	newPrefix := filepath.Join(dir, "new", "mpi_install_mpich-3.3")
+	binary := "\x7fELF\x00" + oldPrefix
+	files := map[string]string{
+		"bin/mpicc":                "#!/bin/sh\nprefix=" + oldPrefix + "\nexec gcc -I${prefix}/include \"$@\"\n",
+		"lib/pkgconfig/mpich.pc":   "prefix=" + oldPrefix + "\nlibdir=${prefix}/lib\n",
+		"lib/libmpi.la":            "libdir='" + oldPrefix + "/lib'\n",
+		"lib/libmpi.so.12":         binary,
+		"include/mpi.h":            "#define MPI_VERSION 3\n",
+		"share/doc/README.txt":     "nothing to relocate\n",
+		"lib/pkgconfig/mpich-c.pc": "prefix=" + oldPrefix + "\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(oldPrefix, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", filepath.Dir(path), err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+	}
+	err = os.Symlink(filepath.Join(oldPrefix, "lib", "libmpi.so.12"), filepath.Join(oldPrefix, "lib", "libmpi.so"))
+	if err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	err = SaveRelocationInfo(oldPrefix, oldPrefix, "mpich:3.3")
+	if err != nil {
+		t.Fatalf("SaveRelocationInfo() failed: %s", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(newPrefix), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", filepath.Dir(newPrefix), err)
+	}
+	err = os.Rename(oldPrefix, newPrefix)
+	if err != nil {
+		t.Fatalf("failed to move the installation: %s", err)
+	}
+
+	modified, err := RelocateMPI(newPrefix)
+	if err != nil {
+		t.Fatalf("RelocateMPI() failed: %s", err)
+	}
+	if modified != 5 {
+		t.Fatalf("%d files were modified instead of 5", modified)
+	}
+
+	for name, content := range files {
+		expected := content
+		if name != "lib/libmpi.so.12" {
+			expected = strings.ReplaceAll(content, oldPrefix, newPrefix)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(newPrefix, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+		if string(data) != expected {
+			t.Fatalf("%s is %q instead of %q", name, string(data), expected)
+		}
+	}
+	target, err := os.Readlink(filepath.Join(newPrefix, "lib", "libmpi.so"))
+	if err != nil {
+		t.Fatalf("failed to read symlink: %s", err)
+	}
+	if target != filepath.Join(newPrefix, "lib", "libmpi.so.12") {
+		t.Fatalf("symlink points to %s", target)
+	}
+
+	info, err := LoadRelocationInfo(newPrefix)
+	if err != nil {
+		t.Fatalf("LoadRelocationInfo() failed: %s", err)
+	}
+	if info.Prefix != newPrefix || info.MPI != "mpich:3.3" {
+		t.Fatalf("invalid relocation metadata: %+v", info)
+	}
+
+	// Relocating again does not modify anything
+	modified, err = RelocateMPI(newPrefix)
+	if err != nil || modified != 0 {
+		t.Fatalf("second relocation modified %d files: %v", modified, err)
+	}
+}
+
+func TestRelocateMPIRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-relocate-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldPrefix := "/opt/old/mpi_install_mpich-3.3"
+	installDir := filepath.Join(dir, "mpi_install_mpich-3.3")
+	files := map[string]string{
+		"bin/mpicc":              "#!/bin/sh\nprefix=" + oldPrefix + "\n",
+		"lib/pkgconfig/mpich.pc": "prefix=" + oldPrefix + "\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(installDir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", filepath.Dir(path), err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+	}
+	link := filepath.Join(installDir, "lib", "libmpi.so")
+	err = os.Symlink(oldPrefix+"/lib/libmpi.so.12", link)
+	if err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	// The relocation metadata cannot be saved once the files are relocated
+	err = os.Mkdir(filepath.Join(installDir, relocationFilename), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", relocationFilename, err)
+	}
+
+	_, err = RelocateMPI(installDir)
+	if err == nil {
+		t.Fatalf("RelocateMPI() succeeded while the relocation metadata cannot be saved")
+	}
+	for name, content := range files {
+		data, err := ioutil.ReadFile(filepath.Join(installDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+		if string(data) != content {
+			t.Fatalf("%s was not restored: %q instead of %q", name, string(data), content)
+		}
+	}
+	target, err := os.Readlink(link)
+	if err != nil || target != oldPrefix+"/lib/libmpi.so.12" {
+		t.Fatalf("symlink was not restored: %s (%v)", target, err)
+	}
+}
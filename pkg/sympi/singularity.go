@@ -0,0 +1,151 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/gvallee/kv/pkg/kv"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/builder"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/manifest"
+	"github.com/sylabs/singularity-mpi/pkg/sy"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// GetSingularityBin returns the path to the binary of a version of Singularity installed in the workspace
+func GetSingularityBin(version string) string {
+	return filepath.Join(sys.GetSympiDir(), sys.SingularityInstallDirPrefix+version, "bin", "singularity")
+}
+
+// SetupSingularity installs a version of Singularity in the workspace if it is not already
+// installed and returns the path to its binary. It can be used as scheduler.Ops.SetupSingularity
+// to execute experiments with a specific version of Singularity.
+func SetupSingularity(syInfo *implem.Info, sysCfg *sys.Config) (string, error) {
+	bin := GetSingularityBin(syInfo.Version)
+	if util.FileExists(bin) {
+		return bin, nil
+	}
+
+	err := InstallSingularity(implem.SY+":"+syInfo.Version, nil, sysCfg)
+	if err != nil {
+		return "", err
+	}
+	if !util.FileExists(bin) {
+		return "", fmt.Errorf("%s not found after the installation of Singularity %s", bin, syInfo.Version)
+	}
+	return bin, nil
+}
+
+func parseSingularityInstallParams(params []string, sysCfg *sys.Config) error {
+	for _, p := range params {
+		switch p {
+		case "no-suid":
+			sysCfg.Nopriv = true
+			sysCfg.SudoSyCmds = []string{}
+		}
+	}
+
+	return nil
+}
+
+// InstallSingularity installs a version of Singularity, e.g., singularity:3.5.3, in the workspace.
+// The parameters can be used to change the way Singularity is installed, e.g., no-suid.
+func InstallSingularity(id string, params []string, sysCfg *sys.Config) error {
+	// We create a new sysCfg structure just for this command since we may have passed
+	// installation parameters that will change the behavior extracted from the configuration
+	// file.
+	var mySysCfg sys.Config
+	mySysCfg = *sysCfg
+	err := parseSingularityInstallParams(params, &mySysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to parse Singularity installation parameters: %s", err)
+	}
+	// Without sudo, the setuid bit cannot be set so Singularity is installed as with no-suid
+	if !mySysCfg.Nopriv && !sy.SudoAvailable(&mySysCfg) && os.Geteuid() != 0 {
+		log.Printf("[WARN] sudo not available, installing %s without the setuid bit", id)
+		mySysCfg.Nopriv = true
+	}
+
+	kvs, err := sy.LoadSingularityReleaseConf(&mySysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to load data about Singularity releases: %s", err)
+	}
+
+	var syInfo implem.Info
+	syInfo.ID = implem.SY
+	tokens := strings.Split(id, ":")
+	if len(tokens) != 2 {
+		return fmt.Errorf("%s had an invalid format, it should of the form 'singularity:<version>'", id)
+	}
+
+	syInfo.Version = tokens[1]
+	syInfo.URL = kv.GetValue(kvs, syInfo.Version)
+
+	b, err := builder.Load(&syInfo)
+	if err != nil {
+		return fmt.Errorf("failed to load a builder: %s", err)
+	}
+	if !mySysCfg.Nopriv {
+		b.PrivInstall = true
+	}
+
+	var buildEnv buildenv.Info
+	buildEnv.InstallDir = filepath.Join(sys.GetSympiDir(), sys.SingularityInstallDirPrefix+syInfo.Version)
+	scratchBase := buildenv.GetScratchBase(&mySysCfg)
+	buildEnv.ScratchDir = filepath.Join(scratchBase, sys.SingularityScratchDirPrefix+syInfo.Version)
+
+	// Building any version of Singularity, even if limiting ourselves to Singularity >= 3.0.0, in
+	// a generic way is not trivial, the installation procedure changed quite a bit over time. The
+	// best option at the moment is to assume that Singularity is simply a standard Go software
+	// with all the associated requirements, e.g., to be built from:
+	//   GOPATH/src/github.com/sylab/singularity
+	buildEnv.BuildDir = filepath.Join(scratchBase, sys.SingularityBuildDirPrefix+syInfo.Version, "src", "github.com", "sylabs")
+	lock, err := buildenv.LockScratch(buildEnv.ScratchDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %s", buildEnv.ScratchDir, err)
+	}
+	defer lock.Unlock()
+	err = util.DirInit(buildEnv.ScratchDir)
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s: %s", buildEnv.ScratchDir, err)
+	}
+	installFailed := false
+	defer func() {
+		buildenv.RemoveScratch(installFailed, &mySysCfg, sys.ScratchDirResource, buildEnv.ScratchDir)
+	}()
+	err = util.DirInit(buildEnv.BuildDir)
+	if err != nil {
+		return fmt.Errorf("failed to initializat %s: %s", buildEnv.BuildDir, err)
+	}
+	defer func() {
+		buildenv.RemoveScratch(installFailed, &mySysCfg, sys.BuildDirResource, buildEnv.BuildDir)
+	}()
+
+	execRes := b.InstallOnHost(&syInfo, &buildEnv, &mySysCfg)
+	if execRes.Err != nil {
+		installFailed = true
+		return fmt.Errorf("failed to install %s: %w", id, execRes.Err)
+	}
+
+	// Create manifest for the Singularity binary
+	syBin := filepath.Join(buildEnv.InstallDir, "bin", "singularity")
+	manifestPath := filepath.Join(buildEnv.InstallDir, "singularity.MANIFEST")
+	hashes := manifest.HashFiles([]string{syBin})
+	err = manifest.Create(manifestPath, hashes)
+	if err != nil {
+		// This is not an error, we just log the error
+		log.Printf("failed to create the MANIFEST for %s\n", id)
+	}
+
+	return nil
+}
@@ -6,163 +6,34 @@
 package sympi
 
 import (
-	"bufio"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gvallee/go_util/pkg/util"
 	"github.com/gvallee/kv/pkg/kv"
 	"github.com/sylabs/singularity-mpi/pkg/app"
 	"github.com/sylabs/singularity-mpi/pkg/buildenv"
 	"github.com/sylabs/singularity-mpi/pkg/builder"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
 	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/events"
 	"github.com/sylabs/singularity-mpi/pkg/implem"
 	"github.com/sylabs/singularity-mpi/pkg/jm"
 	"github.com/sylabs/singularity-mpi/pkg/launcher"
 	"github.com/sylabs/singularity-mpi/pkg/manifest"
 	"github.com/sylabs/singularity-mpi/pkg/mpi"
+	"github.com/sylabs/singularity-mpi/pkg/results"
 	"github.com/sylabs/singularity-mpi/pkg/sy"
 	"github.com/sylabs/singularity-mpi/pkg/syexec"
 	"github.com/sylabs/singularity-mpi/pkg/sys"
 )
 
-// UpdateEnvFile updates the file that is automatically sources while using
-// SyMPI and setting the environment.
-func UpdateEnvFile(file string, pathEnv string, ldlibEnv string) error {
-	// sanity checks
-	if len(pathEnv) == 0 {
-		return fmt.Errorf("invalid parameter, empty PATH")
-	}
-
-	f, err := os.Create(file)
-	if err != nil {
-		return fmt.Errorf("failed to create %s: %s", file, err)
-	}
-	defer f.Close()
-	_, err = f.WriteString("export PATH=" + pathEnv + "\n")
-	if err != nil {
-		return fmt.Errorf("failed to write to %s: %s", file, err)
-	}
-	_, err = f.WriteString("export LD_LIBRARY_PATH=" + ldlibEnv + "\n")
-	if err != nil {
-		return fmt.Errorf("failed to write to %s: %s", file, err)
-	}
-	return nil
-}
-
-func getPPPID() (int, error) {
-	// We need to find the parent of our parent process
-	ppid := os.Getppid()
-	pppid := 0 // Only for now
-	parentInfoFile := filepath.Join("/proc", strconv.Itoa(ppid), "status")
-	procFile, err := os.Open(parentInfoFile)
-	if err != nil {
-		return -1, fmt.Errorf("failed to open %s: %s", parentInfoFile, err)
-	}
-	defer procFile.Close()
-	for s := bufio.NewScanner(procFile); s.Scan(); {
-		var temp int
-		if n, _ := fmt.Sscanf(s.Text(), "PPid:\t%d", &temp); n == 1 {
-			pppid = temp
-		}
-	}
-
-	return pppid, nil
-}
-
-// GetEnvFile returns the absolute path to the file that is automatically sources while using
-// SyMPI.
-func GetEnvFile() (string, error) {
-	pppid, err := getPPPID()
-	if err != nil {
-		return "", fmt.Errorf("failed to get PPPID: %s", err)
-	}
-	filename := "sympi_" + strconv.Itoa(pppid)
-	return filepath.Join("/tmp", filename), nil
-}
-
-func cleanupEnvVar(prefix string) ([]string, []string) {
-	var newPath []string
-	var newLDLIB []string
-
-	curPath := os.Getenv("PATH")
-	curLDLIB := os.Getenv("LD_LIBRARY_PATH")
-
-	pathTokens := strings.Split(curPath, ":")
-	for _, t := range pathTokens {
-		if !strings.Contains(t, prefix) {
-			newPath = append(newPath, t)
-		}
-	}
-
-	ldlibTokens := strings.Split(curLDLIB, ":")
-	for _, t := range ldlibTokens {
-		if !strings.Contains(t, prefix) {
-			newLDLIB = append(newLDLIB, t)
-		}
-	}
-
-	return newPath, newLDLIB
-}
-
-// GetCleanedUpSyEnvVars parses the current environment and cleans up to
-// ensure that is not interference between the currently loaded installation
-// of Singularity and what was previously used.
-func GetCleanedUpSyEnvVars() ([]string, []string) {
-	return cleanupEnvVar(sys.SingularityInstallDirPrefix)
-}
-
-// GetCleanedUpMPIEnvVars parses the current environment and cleans up to
-// ensure that is not interference between the currently loaded installation
-// of MPI and what was previously used.
-func GetCleanedUpMPIEnvVars() ([]string, []string) {
-	return cleanupEnvVar(sys.MPIInstallDirPrefix)
-}
-
-// LoadMPI loads a specific implementation of MPI in the current environment.
-func LoadMPI(id string) error {
-	// We can change the env multiple times during the execution of a single command
-	// and these modifications will NOT be reflected in the actual environment until
-	// we exit the command and let bash do some magic to update it. Fortunately, we
-	// know that we can have one and only one MPI in the environment of a single time
-	// so when we load a MPI, we make sure that we remove a previous load changes.
-	cleanedPath, cleanedLDLIB := GetCleanedUpMPIEnvVars()
-
-	implem, ver := GetMPIDetails(id)
-	if implem == "" || ver == "" {
-		fmt.Println("invalid installation of MPI, execute 'sympi -list' to get the list of available installations")
-		return nil
-	}
-
-	sympiDir := sys.GetSympiDir()
-	mpiBaseDir := filepath.Join(sympiDir, sys.MPIInstallDirPrefix+implem+"-"+ver)
-	mpiBinDir := filepath.Join(mpiBaseDir, "bin")
-	mpiLibDir := filepath.Join(mpiBaseDir, "lib")
-
-	path := strings.Join(cleanedPath, ":")
-	ldlib := strings.Join(cleanedLDLIB, ":")
-	path = mpiBinDir + ":" + path
-	ldlib = mpiLibDir + ":" + ldlib
-
-	file, err := GetEnvFile()
-	if err != nil || !util.FileExists(file) {
-		return fmt.Errorf("file %s does not exist", file)
-	}
-
-	err = UpdateEnvFile(file, path, ldlib)
-	if err != nil {
-		return fmt.Errorf("failed to update %s: %s", file, err)
-	}
-
-	return nil
-}
-
 func getImagePath(containerDesc string, sysCfg *sys.Config) (string, error) {
 	containerInstallDir := filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+containerDesc)
 	imgPath := filepath.Join(containerInstallDir, containerDesc+".sif")
@@ -184,7 +55,7 @@ func GetDefaultSysConfig() sys.Config {
 	return sysCfg
 }
 
-func runStandardContainer(args []string, containerInfo *container.Config, sysCfg *sys.Config) (syexec.Result, error) {
+func runStandardContainer(args []string, containerInfo *container.Config, run *runDetails, sysCfg *sys.Config) (syexec.Result, error) {
 	var hostBuildEnv buildenv.Info
 	var hostCfg mpi.Config
 	var containerCfg mpi.Config
@@ -200,10 +71,14 @@ func runStandardContainer(args []string, containerInfo *container.Config, sysCfg
 	containerCfg.Container = *containerInfo
 	appInfo.Name = containerInfo.Name
 	appInfo.BinPath = containerInfo.AppExe
+	appInfo.Args = run.appArgs
+	appInfo.OutputArtifacts = containerInfo.OutputArtifacts
+	appInfo.Bench = containerInfo.Bench.Override(run.bench)
 
 	// Launch the container
 	jobmgr := jm.Detect()
 	expRes, execRes := launcher.Run(&appInfo, nil, &hostBuildEnv, &containerCfg, &jobmgr, sysCfg, args)
+	run.bench = expRes.Bench
 	if !expRes.Pass {
 		return execRes, fmt.Errorf("failed to run the container: %s (stdout: %s; stderr: %s)", execRes.Err, execRes.Stderr, execRes.Stdout)
 	}
@@ -211,14 +86,21 @@ func runStandardContainer(args []string, containerInfo *container.Config, sysCfg
 	return execRes, nil
 }
 
-func runMPIContainer(args []string, containerMPI *implem.Info, containerInfo *container.Config, sysCfg *sys.Config) (syexec.Result, error) {
+func runMPIContainer(args []string, containerMPI *implem.Info, containerInfo *container.Config, run *runDetails, sysCfg *sys.Config) (syexec.Result, error) {
 	var execRes syexec.Result
 	fmt.Printf("Container based on %s %s\n", containerMPI.ID, containerMPI.Version)
 	fmt.Println("Looking for available compatible version...")
-	hostMPI, err := findCompatibleMPI(containerMPI)
+	hostMPI, err := selectHostMPI(run.containerDesc, containerMPI)
+	if run.pinnedHostMPI.ID != "" {
+		// The exact same MPI is required, the run would not be comparable otherwise
+		hostMPI, err = findCompatibleMPI(&run.pinnedHostMPI)
+		if err != nil || hostMPI.Version != run.pinnedHostMPI.Version {
+			return execRes, fmt.Errorf("%s %s is not installed on the host, install it with 'sympi -install %s:%s'", run.pinnedHostMPI.ID, run.pinnedHostMPI.Version, run.pinnedHostMPI.ID, run.pinnedHostMPI.Version)
+		}
+	}
 	if err != nil {
 		fmt.Printf("No compatible MPI found, installing the appropriate version...")
-		err := InstallMPIonHost(containerMPI.ID+"-"+containerMPI.Version, sysCfg)
+		err := InstallMPIonHost(containerMPI.ID+":"+containerMPI.Version, sysCfg)
 		if err != nil {
 			return execRes, fmt.Errorf("failed to install %s %s", containerMPI.ID, containerMPI.Version)
 		}
@@ -232,7 +114,7 @@ func runMPIContainer(args []string, containerMPI *implem.Info, containerInfo *co
 		fmt.Printf("Binding/mounting %s %s on host -> %s\n", hostMPI.ID, hostMPI.Version, containerInfo.MPIDir)
 	}
 
-	err = LoadMPI(hostMPI.ID + ":" + hostMPI.Version)
+	err = Load(hostMPI.ID+":"+hostMPI.Version, RoleRun)
 	if err != nil {
 		return execRes, fmt.Errorf("failed to load MPI %s %s on host: %s", hostMPI.ID, hostMPI.Version, err)
 	}
@@ -242,6 +124,8 @@ func runMPIContainer(args []string, containerMPI *implem.Info, containerInfo *co
 	if err != nil {
 		return execRes, fmt.Errorf("failed to create default host environment configuration: %s", err)
 	}
+	run.hostMPI = hostMPI
+	run.hostInstallDir = hostBuildEnv.InstallDir
 	var hostMPICfg mpi.Config
 	var containerMPICfg mpi.Config
 	var appInfo app.Info
@@ -253,10 +137,14 @@ func runMPIContainer(args []string, containerMPI *implem.Info, containerInfo *co
 	containerMPICfg.Container = *containerInfo
 	appInfo.Name = containerInfo.Name
 	appInfo.BinPath = containerInfo.AppExe
+	appInfo.Args = run.appArgs
+	appInfo.OutputArtifacts = containerInfo.OutputArtifacts
+	appInfo.Bench = containerInfo.Bench.Override(run.bench)
 
 	// Launch the container
 	jobmgr := jm.Detect()
 	expRes, execRes := launcher.Run(&appInfo, &hostMPICfg, &hostBuildEnv, &containerMPICfg, &jobmgr, sysCfg, args)
+	run.bench = expRes.Bench
 	if !expRes.Pass {
 		return execRes, fmt.Errorf("failed to run the container: %s (stdout: %s; stderr: %s)", execRes.Err, execRes.Stderr, execRes.Stdout)
 	}
@@ -264,9 +152,59 @@ func runMPIContainer(args []string, containerMPI *implem.Info, containerInfo *co
 	return execRes, nil
 }
 
+// runDetails gathers what is known about the execution of a container, which is used to
+// export a reproducibility bundle (see RunContainerWithBundle)
+type runDetails struct {
+	containerDesc  string
+	appName        string
+	args           []string
+	appArgs        []string
+	imgPath        string
+	containerMPI   implem.Info
+	hostMPI        implem.Info
+	hostInstallDir string
+	execRes        syexec.Result
+
+	// bench are the settings of the benchmark overriding the ones of the container, e.g., to repeat
+	// a run, then the settings the application was executed with
+	bench app.Bench
+
+	// pinnedHostMPI is the MPI of the host the container must be executed with, e.g., to repeat a
+	// run; a compatible MPI is selected when not set
+	pinnedHostMPI implem.Info
+}
+
 // RunContainer is a high-level function to execute a container that was created with the
 // SyMPI framework (it relies on metadata)
 func RunContainer(containerDesc string, args []string, sysCfg *sys.Config) error {
+	return RunContainerApp(containerDesc, "", args, nil, sysCfg)
+}
+
+// RunContainerApp executes a container like RunContainer, appName being the name of the
+// application to execute in a multi-app container; when empty, the default application is executed.
+// appArgs are the arguments of the application, args being the arguments of the launcher.
+func RunContainerApp(containerDesc string, appName string, args []string, appArgs []string, sysCfg *sys.Config) error {
+	run := runDetails{containerDesc: containerDesc, appName: appName, args: args, appArgs: appArgs}
+	return runContainer(&run, sysCfg)
+}
+
+// runContainer executes a container and emits the events of its execution, see execContainer
+func runContainer(run *runDetails, sysCfg *sys.Config) error {
+	events.Emit(events.Event{Type: events.ExperimentStarted, Phase: events.PhaseRun, Experiment: run.containerDesc})
+	start := time.Now()
+	err := execContainer(run, sysCfg)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	events.EmitRun(run.containerDesc, err == nil, "", errMsg, start)
+	return err
+}
+
+func execContainer(run *runDetails, sysCfg *sys.Config) error {
+	containerDesc := run.containerDesc
+	args := run.args
+
 	// When running containers with sympi, we are always in the context of persistent installs
 	sysCfg.Persistent = sys.GetSympiDir()
 
@@ -275,6 +213,7 @@ func RunContainer(containerDesc string, args []string, sysCfg *sys.Config) error
 	if err != nil {
 		return fmt.Errorf("failed to get path to image for container %s: %s", containerDesc, err)
 	}
+	run.imgPath = imgPath
 
 	// Inspect the image and extract the metadata
 	err = sy.CheckIntegrity(sysCfg)
@@ -283,21 +222,42 @@ func RunContainer(containerDesc string, args []string, sysCfg *sys.Config) error
 		return fmt.Errorf("Compromised Singularity installation")
 	}
 
+	err = sy.CheckRuntimeMode(sysCfg)
+	if err != nil {
+		return err
+	}
+
+	err = container.CheckSignature(imgPath, sysCfg)
+	if err != nil {
+		return fmt.Errorf("signature of %s cannot be verified: %s", imgPath, err)
+	}
+
 	fmt.Printf("Analyzing %s to figure out the correct configuration for execution...\n", imgPath)
 	containerInfo, containerMPI, err := container.GetMetadata(imgPath, sysCfg)
 	if err != nil {
 		return fmt.Errorf("failed to extract container's metadata: %s", err)
 	}
 	containerInfo.Name = containerDesc
+	if run.appName != "" {
+		err = containerInfo.SelectApp(run.appName)
+		if err != nil {
+			return err
+		}
+	} else if len(containerInfo.Apps) > 1 {
+		fmt.Printf("%s includes several applications (%s), executing the default one; use -app to select another one\n", containerDesc, strings.Join(containerInfo.GetAppNames(), ", "))
+	}
+	run.containerMPI = containerMPI
 	var execRes syexec.Result
 	if containerMPI.ID != "" && containerMPI.Version != "" {
-		execRes, err = runMPIContainer(args, &containerMPI, &containerInfo, sysCfg)
+		execRes, err = runMPIContainer(args, &containerMPI, &containerInfo, run, sysCfg)
+		run.execRes = execRes
 		if err != nil {
 			return fmt.Errorf("failed to run MPI container: %s", err)
 		}
 	} else {
 		log.Println("Container is not using MPI")
-		execRes, err = runStandardContainer(args, &containerInfo, sysCfg)
+		execRes, err = runStandardContainer(args, &containerInfo, run, sysCfg)
+		run.execRes = execRes
 		if err != nil {
 			return fmt.Errorf("failed to run standard container: %s", err)
 		}
@@ -308,6 +268,103 @@ func RunContainer(containerDesc string, args []string, sysCfg *sys.Config) error
 	return nil
 }
 
+// getRepeatedContainer returns the container to execute to repeat an experiment recorded in a
+// summary: the container executed with 'sympi -run' or, for the experiments of a configuration
+// file, the container created for the MPI of the container, e.g., openmpi-4.0.2
+func getRepeatedContainer(e *results.ExperimentSummary) (string, error) {
+	if e.Container != "" {
+		return e.Container, nil
+	}
+	if e.ContainerMPI == "" {
+		return "", fmt.Errorf("experiment %s records neither its container nor the MPI of its container, it cannot be repeated", e.Name)
+	}
+	id, version := GetMPIDetails(e.ContainerMPI)
+	if id == "" {
+		return "", fmt.Errorf("invalid MPI of the container of experiment %s: %s", e.Name, e.ContainerMPI)
+	}
+	containerDesc := id + "-" + version
+	if e.Distro != "" {
+		containerDesc += "-" + sys.GetDistroID(e.Distro)
+	}
+	return containerDesc, nil
+}
+
+// RepeatExact executes again the run of a container recorded in a summary, e.g., the summary of a
+// reproducibility bundle, with identical parameters: the same container, application, arguments,
+// MPI on the host and benchmark settings, including the seed. name is the name of the experiment
+// in the summary, it can be empty when the summary has a single experiment.
+func RepeatExact(summaryFile string, name string, sysCfg *sys.Config) error {
+	s, err := results.LoadSummary(summaryFile)
+	if err != nil {
+		return err
+	}
+	e, err := s.GetExperiment(name)
+	if err != nil {
+		return err
+	}
+	containerDesc, err := getRepeatedContainer(e)
+	if err != nil {
+		return err
+	}
+	if _, err := getImagePath(containerDesc, sysCfg); err != nil {
+		return fmt.Errorf("container %s of experiment %s is not available: %s", containerDesc, e.Name, err)
+	}
+
+	run := runDetails{containerDesc: containerDesc, appName: e.AppName, args: e.LaunchArgs, appArgs: e.AppArgs}
+	if e.Container == "" {
+		// The experiments of a configuration file execute the application with the arguments of
+		// the container, the recorded arguments are the ones with the benchmark settings replaced
+		run.appArgs = nil
+	}
+	if e.Bench != nil {
+		run.bench = *e.Bench
+	}
+	if e.HostMPI != "" {
+		run.pinnedHostMPI.ID, run.pinnedHostMPI.Version = GetMPIDetails(e.HostMPI)
+	}
+	// The run is repeated in the same runtime mode
+	repeatCfg := *sysCfg
+	repeatCfg.RuntimeMode = sys.RuntimeModeNative
+	if e.RuntimeMode != "" {
+		repeatCfg.RuntimeMode = e.RuntimeMode
+	}
+	fmt.Printf("Repeating %s with %s\n", e.Name, run.bench.String())
+	return runContainer(&run, &repeatCfg)
+}
+
+// ProbeContainer checks whether a container created with the SyMPI framework is expected to run
+// with the compatible MPI installed on the host, without running its application (see launcher.Probe)
+func ProbeContainer(containerDesc string, sysCfg *sys.Config) (*launcher.ProbeResult, error) {
+	sysCfg.Persistent = sys.GetSympiDir()
+
+	imgPath, err := getImagePath(containerDesc, sysCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get path to image for container %s: %s", containerDesc, err)
+	}
+	containerInfo, containerMPI, err := container.GetMetadata(imgPath, sysCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract container's metadata: %s", err)
+	}
+	if containerMPI.ID == "" || containerMPI.Version == "" {
+		return nil, fmt.Errorf("container %s is not using MPI", containerDesc)
+	}
+	containerInfo.Name = containerDesc
+
+	hostMPI, err := findCompatibleMPI(&containerMPI)
+	if err != nil {
+		return nil, fmt.Errorf("no MPI compatible with %s %s installed on the host: %s", containerMPI.ID, containerMPI.Version, err)
+	}
+	fmt.Printf("Probing %s with %s %s from the host...\n", containerDesc, hostMPI.ID, hostMPI.Version)
+
+	var hostMPICfg mpi.Config
+	hostMPICfg.Implem = hostMPI
+	err = buildenv.CreateDefaultHostEnvCfg(&hostMPICfg.Buildenv, &hostMPI, sysCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create default host environment configuration: %s", err)
+	}
+	return launcher.Probe(&hostMPICfg, &hostMPICfg.Buildenv, &containerInfo, sysCfg)
+}
+
 // GetHostMPIInstalls returns all the MPI implementations installed in the current
 // workspace
 func GetHostMPIInstalls(entries []os.FileInfo) ([]string, error) {
@@ -327,6 +384,18 @@ func GetHostMPIInstalls(entries []os.FileInfo) ([]string, error) {
 	return hostInstalls, nil
 }
 
+// GetContainerInstalls returns the list of containers stored in the workspace from the content
+// of the workspace directory
+func GetContainerInstalls(entries []os.FileInfo) ([]string, error) {
+	var containers []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), sys.ContainerInstallDirPrefix) {
+			containers = append(containers, strings.TrimPrefix(entry.Name(), sys.ContainerInstallDirPrefix))
+		}
+	}
+	return containers, nil
+}
+
 func findCompatibleMPI(targetMPI *implem.Info) (implem.Info, error) {
 	var mpi implem.Info
 	mpi.ID = targetMPI.ID
@@ -374,6 +443,40 @@ func findCompatibleMPI(targetMPI *implem.Info) (implem.Info, error) {
 	return mpi, fmt.Errorf("no compatible version available")
 }
 
+// getHostMPIs returns the installations of MPI of the workspace, e.g., openmpi:4.0.2
+func getHostMPIs() ([]string, error) {
+	entries, err := ioutil.ReadDir(sys.GetSympiDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", sys.GetSympiDir(), err)
+	}
+	return GetHostMPIInstalls(entries)
+}
+
+// selectHostMPI selects the MPI of the host to execute a container of the workspace with: the MPI
+// satisfying the requirements recorded in the link of the container to the MPI of the host, if
+// any, a compatible MPI otherwise (see findCompatibleMPI)
+func selectHostMPI(containerDesc string, containerMPI *implem.Info) (implem.Info, error) {
+	var hostMPI implem.Info
+	links, err := container.LoadMPILinks()
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+	}
+	link, ok := container.FindMPILink(links, containerDesc)
+	if !ok {
+		return findCompatibleMPI(containerMPI)
+	}
+	installs, err := getHostMPIs()
+	if err != nil {
+		return hostMPI, err
+	}
+	selected, err := link.SelectHostMPI(installs)
+	if err != nil {
+		return hostMPI, err
+	}
+	hostMPI.ID, hostMPI.Version = GetMPIDetails(selected)
+	return hostMPI, nil
+}
+
 // GetMPIDetails extract the details of a specific MPI implementation from its description
 func GetMPIDetails(desc string) (string, string) {
 	tokens := strings.Split(desc, ":")
@@ -386,21 +489,37 @@ func GetMPIDetails(desc string) (string, string) {
 
 // InstallMPIonHost installs a specific implementation of MPI on the host
 func InstallMPIonHost(mpiDesc string, sysCfg *sys.Config) error {
+	start := time.Now()
+	err := installMPIonHost(mpiDesc, sysCfg)
+	events.EmitBuild(events.PhaseInstall, mpiDesc, start, err)
+	return err
+}
+
+func installMPIonHost(mpiDesc string, sysCfg *sys.Config) error {
 	var mpiCfg implem.Info
 	mpiCfg.ID, mpiCfg.Version = GetMPIDetails(mpiDesc)
 
-	sysCfg.ScratchDir = buildenv.GetDefaultScratchDir(&mpiCfg)
+	sysCfg.ScratchDir = buildenv.GetDefaultScratchDir(&mpiCfg, sysCfg)
 	// When installing a MPI with sympi, we are always in persistent mode
 	sysCfg.Persistent = sys.GetSympiDir()
 
-	err := util.DirInit(sysCfg.ScratchDir)
+	// The scratch directory is locked so it is not garbage collected while MPI is installed
+	lock, err := buildenv.LockScratch(sysCfg.ScratchDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %s", sysCfg.ScratchDir, err)
+	}
+	defer lock.Unlock()
+	err = util.DirInit(sysCfg.ScratchDir)
 	if err != nil {
 		return fmt.Errorf("unable to initialize scratch directory %s: %s", sysCfg.ScratchDir, err)
 	}
-	defer os.RemoveAll(sysCfg.ScratchDir)
+	installFailed := false
+	defer func() {
+		buildenv.RemoveScratch(installFailed, sysCfg, sys.ScratchDirResource, sysCfg.ScratchDir)
+	}()
 
 	mpiConfigFile := mpi.GetMPIConfigFile(mpiCfg.ID, sysCfg)
-	kvs, err := kv.LoadKeyValueConfig(mpiConfigFile)
+	kvs, err := configparser.Load(mpiConfigFile)
 	if err != nil {
 		return fmt.Errorf("unable to load configuration file %s: %s", mpiConfigFile, err)
 	}
@@ -416,17 +535,31 @@ func InstallMPIonHost(mpiDesc string, sysCfg *sys.Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to set host build environment: %s", err)
 	}
-	defer os.RemoveAll(buildEnv.BuildDir)
+	defer func() {
+		buildenv.RemoveScratch(installFailed, sysCfg, sys.BuildDirResource, buildEnv.BuildDir)
+	}()
 
 	execRes := b.InstallOnHost(&mpiCfg, &buildEnv, sysCfg)
 	if execRes.Err != nil {
-		return fmt.Errorf("failed to install MPI on the host: %s", execRes.Err)
+		installFailed = true
+		return fmt.Errorf("failed to install MPI on the host: %w", execRes.Err)
+	}
+
+	// 'make install' may succeed without installing everything, in which case the installation
+	// must not be used later on
+	mpiBin := filepath.Join(buildEnv.InstallDir, "bin", "mpiexec")
+	if !util.FileExists(mpiBin) {
+		installFailed = true
+		_, err := builder.RollbackInstall(buildEnv.InstallDir, sysCfg)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
+		}
+		return fmt.Errorf("installation of %s is incomplete: %s is missing", mpiDesc, mpiBin)
 	}
 
 	// Create the manifest for the MPI installation we just completed
 	mpiManifest := filepath.Join(buildEnv.InstallDir, "mpi.MANIFEST")
 	if !util.PathExists(mpiManifest) {
-		mpiBin := filepath.Join(buildEnv.InstallDir, "bin", "mpiexec")
 		fileHashes := manifest.HashFiles([]string{mpiBin})
 
 		err = manifest.Create(mpiManifest, fileHashes)
@@ -439,5 +572,32 @@ func InstallMPIonHost(mpiDesc string, sysCfg *sys.Config) error {
 		log.Println("Manifest for MPI installation already exists, skipping...")
 	}
 
+	// Record where MPI is installed so the installation can be relocated or exported
+	err = SaveRelocationInfo(buildEnv.InstallDir, buildEnv.InstallDir, mpiDesc)
+	if err != nil {
+		// This is not a fatal error, the prefix can also be detected from the installed files
+		log.Printf("failed to save the relocation metadata of the MPI installation: %s", err)
+	}
+
+	return nil
+}
+
+// DeleteContainer removes a container from the workspace
+func DeleteContainer(containerDesc string) error {
+	if containerDesc == "" || strings.Contains(containerDesc, "/") {
+		return fmt.Errorf("invalid container %q", containerDesc)
+	}
+	containerInstallDir := filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+containerDesc)
+	if !util.IsDir(containerInstallDir) {
+		return fmt.Errorf("container %s does not exist", containerDesc)
+	}
+	err := os.RemoveAll(containerInstallDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %s", containerInstallDir, err)
+	}
+	err = container.RemoveMPILink(containerDesc)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+	}
 	return nil
 }
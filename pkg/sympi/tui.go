@@ -0,0 +1,701 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/launcher"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/syexec"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// tuiMaxRuns is the maximum number of recent runs displayed
+	tuiMaxRuns = 20
+
+	// tuiMaxLogLines is the number of lines displayed from the end of the output of a failure
+	tuiMaxLogLines = 40
+
+	viewHome       = "home"
+	viewMPIs       = "mpis"
+	viewContainers = "containers"
+	viewRuns       = "runs"
+	viewMatrices   = "matrices"
+	viewMatrix     = "matrix"
+	viewFailures   = "failures"
+	viewDetails    = "details"
+
+	keyEnter = "enter"
+	keyBack  = "back"
+	keyUp    = "up"
+	keyDown  = "down"
+
+	// clearScreen moves the cursor to the top of the terminal and clears it
+	clearScreen = "\033[H\033[2J"
+)
+
+// tuiItem is an entry of a list displayed by the terminal UI
+type tuiItem struct {
+	// label is the line displayed for the item
+	label string
+
+	// id identifies what the item refers to, e.g., the name of a container or the path to a ledger
+	id string
+}
+
+// tuiView is a screen of the terminal UI: a list of items, e.g., the containers, or the details
+// of an item, e.g., the output of a failure
+type tuiView struct {
+	kind     string
+	title    string
+	arg      string
+	items    []tuiItem
+	selected int
+
+	// text is the content of a view with details, which has no item
+	text string
+}
+
+// TUI is an interactive terminal user interface to browse the workspace, i.e., the MPIs installed
+// on the host, the containers, the recent runs, the compatibility matrices and the failures, and to
+// run, delete or export containers with a single key
+type TUI struct {
+	// ResultsDir is the directory where the compatibility matrices are searched, i.e., the directory
+	// where the experiments were executed
+	ResultsDir string
+
+	// ExportDir is the directory where the containers are exported
+	ExportDir string
+
+	sysCfg  *sys.Config
+	in      *bufio.Reader
+	input   io.Reader
+	out     io.Writer
+	raw     bool
+	pending []string
+	views   []*tuiView
+	status  string
+}
+
+// NewTUI returns a terminal UI reading the keys from in and displaying the screens on out. When
+// in is a terminal, keys are read as soon as they are pressed; otherwise keys are read by line, an
+// empty line being the enter key and a number opening the item with that number.
+func NewTUI(in io.Reader, out io.Writer, sysCfg *sys.Config) *TUI {
+	return &TUI{
+		ResultsDir: ".",
+		ExportDir:  "/tmp",
+		sysCfg:     sysCfg,
+		in:         bufio.NewReader(in),
+		input:      in,
+		out:        out,
+	}
+}
+
+// getTerminal returns the input of the UI when it is a terminal, nil otherwise
+func (t *TUI) getTerminal() *os.File {
+	f, ok := t.input.(*os.File)
+	if !ok {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return f
+}
+
+// stty configures the terminal of the UI
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to configure the terminal: %s", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// setRawMode makes the keys available as soon as they are pressed, without echo, and returns the
+// previous configuration of the terminal
+func (t *TUI) setRawMode() (string, error) {
+	tty := t.getTerminal()
+	if tty == nil {
+		return "", nil
+	}
+	state, err := stty(tty, "-g")
+	if err != nil {
+		return "", err
+	}
+	_, err = stty(tty, "-icanon", "-echo", "min", "1")
+	if err != nil {
+		return "", err
+	}
+	t.raw = true
+	return state, nil
+}
+
+// restoreMode restores the configuration of the terminal saved by setRawMode
+func (t *TUI) restoreMode(state string) {
+	if !t.raw {
+		return
+	}
+	t.raw = false
+	stty(t.getTerminal(), state)
+}
+
+// restoreOnSignal restores the configuration of the terminal saved by setRawMode when the tool is
+// interrupted, e.g., with Ctrl-C, before exiting; the returned function stops restoring it
+func (t *TUI) restoreOnSignal(state string) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			if tty := t.getTerminal(); tty != nil {
+				stty(tty, state)
+			}
+			fmt.Fprintln(t.out)
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// Run displays the UI until the user quits
+func (t *TUI) Run() error {
+	state, err := t.setRawMode()
+	if err != nil {
+		return err
+	}
+	defer t.restoreMode(state)
+	if t.raw {
+		defer t.restoreOnSignal(state)()
+	}
+
+	t.views = []*tuiView{{kind: viewHome, title: "SyMPI workspace " + sys.GetWorkspace()}}
+	t.reload(t.views[0])
+	for {
+		t.draw()
+		key, err := t.readKey()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the input: %s", err)
+		}
+		if key == "q" {
+			return nil
+		}
+		t.handleKey(key, &state)
+	}
+}
+
+// readKey returns the next key pressed by the user
+func (t *TUI) readKey() (string, error) {
+	if !t.raw {
+		for len(t.pending) == 0 {
+			line, err := t.in.ReadString('\n')
+			if err != nil && line == "" {
+				return "", err
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				return keyEnter, nil
+			}
+			if _, err := strconv.Atoi(line); err == nil {
+				return line, nil
+			}
+			for _, c := range line {
+				t.pending = append(t.pending, string(c))
+			}
+		}
+		key := t.pending[0]
+		t.pending = t.pending[1:]
+		return key, nil
+	}
+
+	b, err := t.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch b {
+	case '\r', '\n':
+		return keyEnter, nil
+	case 127, '\b':
+		return keyBack, nil
+	case 27:
+		// Arrow keys are escape sequences, e.g., ESC [ A for the up arrow
+		if t.in.Buffered() < 2 {
+			return keyBack, nil
+		}
+		seq := make([]byte, 2)
+		_, err = io.ReadFull(t.in, seq)
+		if err != nil {
+			return "", err
+		}
+		switch seq[1] {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		case 'C':
+			return keyEnter, nil
+		case 'D':
+			return keyBack, nil
+		}
+		return "", nil
+	}
+	return string(b), nil
+}
+
+// current returns the view currently displayed
+func (t *TUI) current() *tuiView {
+	return t.views[len(t.views)-1]
+}
+
+// selectedItem returns the selected item of the current view, nil when the view has no item
+func (t *TUI) selectedItem() *tuiItem {
+	v := t.current()
+	if v.selected >= len(v.items) {
+		return nil
+	}
+	return &v.items[v.selected]
+}
+
+// handleKey performs the action bound to a key
+func (t *TUI) handleKey(key string, state *string) {
+	v := t.current()
+	t.status = ""
+	switch key {
+	case "j", keyDown:
+		if v.selected < len(v.items)-1 {
+			v.selected++
+		}
+	case "k", keyUp:
+		if v.selected > 0 {
+			v.selected--
+		}
+	case "b", "h", keyBack:
+		if len(t.views) > 1 {
+			t.views = t.views[:len(t.views)-1]
+			t.reload(t.current())
+		}
+	case "l", keyEnter:
+		t.open()
+	case "r":
+		if v.kind == viewContainers && t.selectedItem() != nil {
+			t.runContainer(t.selectedItem().id, state)
+		}
+	case "e":
+		if v.kind == viewContainers && t.selectedItem() != nil {
+			t.exportContainer(t.selectedItem().id)
+		}
+	case "d":
+		if (v.kind == viewContainers || v.kind == viewMPIs) && t.selectedItem() != nil {
+			t.delete(t.selectedItem().id)
+		}
+	default:
+		// A number opens the item with that number
+		n, err := strconv.Atoi(key)
+		if err == nil && n >= 1 && n <= len(v.items) {
+			v.selected = n - 1
+			t.open()
+		}
+	}
+}
+
+// open displays the view of the selected item
+func (t *TUI) open() {
+	item := t.selectedItem()
+	if item == nil {
+		return
+	}
+	v := &tuiView{kind: viewDetails, title: item.label, arg: item.id}
+	switch t.current().kind {
+	case viewHome:
+		v.kind = item.id
+		v.title = strings.Split(item.label, " (")[0]
+	case viewMatrices:
+		v.kind = viewMatrix
+		v.title = "Compatibility matrix " + filepath.Base(item.id)
+	case viewMPIs:
+		v.text = t.getMPIDetails(item.id)
+	case viewContainers:
+		v.text = t.getContainerDetails(item.id)
+	case viewRuns:
+		v.text = getRunDetails(item.id)
+	case viewMatrix, viewFailures:
+		v.text = getFailureDetails(item.id)
+	default:
+		return
+	}
+	t.reload(v)
+	t.views = append(t.views, v)
+}
+
+// reload updates the items of a view
+func (t *TUI) reload(v *tuiView) {
+	var err error
+	switch v.kind {
+	case viewHome:
+		v.items = nil
+		for _, section := range []struct {
+			kind  string
+			label string
+		}{
+			{viewMPIs, "MPIs installed on the host"},
+			{viewContainers, "Containers"},
+			{viewRuns, "Recent runs"},
+			{viewMatrices, "Compatibility matrices"},
+			{viewFailures, "Failures"},
+		} {
+			tmp := tuiView{kind: section.kind}
+			t.reload(&tmp)
+			v.items = append(v.items, tuiItem{label: fmt.Sprintf("%s (%d)", section.label, len(tmp.items)), id: section.kind})
+		}
+	case viewMPIs:
+		v.items, err = getInstalledItems(GetHostMPIInstalls)
+	case viewContainers:
+		v.items, err = getInstalledItems(GetContainerInstalls)
+	case viewRuns:
+		v.items, err = getRunItems()
+	case viewMatrices:
+		v.items, err = t.getMatrixItems()
+	case viewMatrix:
+		v.items, err = getMatrixCellItems(v.arg)
+	case viewFailures:
+		v.items, err = t.getFailureItems()
+	}
+	if err != nil {
+		t.status = err.Error()
+	}
+	if v.selected >= len(v.items) && len(v.items) > 0 {
+		v.selected = len(v.items) - 1
+	}
+}
+
+// getHelp returns the keys available in a view
+func getHelp(kind string) string {
+	switch kind {
+	case viewDetails:
+		return "[b] back  [q] quit"
+	case viewContainers:
+		return "[j/k] move  [enter] details  [r] run  [e] export  [d] delete  [b] back  [q] quit"
+	case viewMPIs:
+		return "[j/k] move  [enter] details  [d] uninstall  [b] back  [q] quit"
+	}
+	return "[j/k] move  [enter] open  [b] back  [q] quit"
+}
+
+// draw displays the current view
+func (t *TUI) draw() {
+	v := t.current()
+	if t.raw {
+		fmt.Fprint(t.out, clearScreen)
+	}
+	fmt.Fprintf(t.out, "%s\n\n", v.title)
+	if v.kind == viewDetails {
+		fmt.Fprintln(t.out, strings.TrimSuffix(v.text, "\n"))
+	} else if len(v.items) == 0 {
+		fmt.Fprintln(t.out, "Nothing to display")
+	}
+	for i, item := range v.items {
+		cursor := " "
+		if i == v.selected {
+			cursor = ">"
+		}
+		fmt.Fprintf(t.out, "%s %d. %s\n", cursor, i+1, item.label)
+	}
+	fmt.Fprintln(t.out)
+	if t.status != "" {
+		fmt.Fprintln(t.out, t.status)
+	}
+	fmt.Fprintln(t.out, getHelp(v.kind))
+}
+
+// confirm asks the user to confirm an action
+func (t *TUI) confirm(question string) bool {
+	fmt.Fprintf(t.out, "%s [y/N] ", question)
+	key, err := t.readKey()
+	fmt.Fprintln(t.out)
+	return err == nil && (key == "y" || key == "Y")
+}
+
+// runContainer executes a container; the terminal is restored while the container is running so
+// its output is displayed as usual
+func (t *TUI) runContainer(containerDesc string, state *string) {
+	if t.raw {
+		fmt.Fprint(t.out, clearScreen)
+	}
+	wasRaw := t.raw
+	t.restoreMode(*state)
+	fmt.Fprintf(t.out, "Running %s...\n", containerDesc)
+	err := RunContainer(containerDesc, nil, t.sysCfg)
+	if err != nil {
+		t.status = fmt.Sprintf("Impossible to run container %s: %s", containerDesc, err)
+	} else {
+		t.status = fmt.Sprintf("Container %s successfully executed", containerDesc)
+	}
+	if wasRaw {
+		fmt.Fprintf(t.out, "%s\nPress enter to continue", t.status)
+		t.in.ReadString('\n')
+		newState, err := t.setRawMode()
+		if err == nil {
+			*state = newState
+		}
+	}
+	t.reload(t.current())
+}
+
+// exportContainer exports the image of a container in the export directory
+func (t *TUI) exportContainer(containerDesc string) {
+	path, err := ExportContainer(containerDesc, &ExportOptions{Dir: t.ExportDir})
+	if err != nil {
+		t.status = fmt.Sprintf("Failed to export %s: %s", containerDesc, err)
+		return
+	}
+	t.status = fmt.Sprintf("Container successfully exported: %s", path)
+}
+
+// delete removes a container or uninstalls MPI, once confirmed by the user
+func (t *TUI) delete(id string) {
+	v := t.current()
+	if !t.confirm(fmt.Sprintf("Delete %s?", id)) {
+		return
+	}
+	var err error
+	if v.kind == viewContainers {
+		err = DeleteContainer(id)
+	} else {
+		err = UninstallMPIfromHostWithOptions(id, &UninstallOptions{}, t.sysCfg)
+	}
+	if err != nil {
+		t.status = fmt.Sprintf("Failed to delete %s: %s", id, err)
+	} else {
+		t.status = fmt.Sprintf("%s deleted", id)
+	}
+	t.reload(v)
+}
+
+// getInstalledItems returns the items of the software of the workspace, e.g., the containers
+func getInstalledItems(getInstalls func([]os.FileInfo) ([]string, error)) ([]tuiItem, error) {
+	entries, err := ioutil.ReadDir(sys.GetSympiDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %s", sys.GetSympiDir(), err)
+	}
+	installs, err := getInstalls(entries)
+	if err != nil {
+		return nil, err
+	}
+	var items []tuiItem
+	for _, i := range installs {
+		items = append(items, tuiItem{label: i, id: i})
+	}
+	return items, nil
+}
+
+// getMPIDetails returns the details of an installation of MPI
+func (t *TUI) getMPIDetails(mpiDesc string) string {
+	installDir := filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+strings.Replace(mpiDesc, ":", "-", -1))
+	details := fmt.Sprintf("Installation directory: %s\n", installDir)
+	envFile, _ := GetEnvFile()
+	for _, c := range GetLoaded(envFile) {
+		if c.Name == mpiDesc {
+			details += fmt.Sprintf("Loaded for: %s\n", c.Role)
+		}
+	}
+	info, err := LoadRelocationInfo(installDir)
+	if err == nil && info.Prefix != installDir {
+		details += fmt.Sprintf("Built for: %s (execute 'sympi -relocate')\n", info.Prefix)
+	}
+	return details
+}
+
+// getContainerDetails returns the metadata of a container
+func (t *TUI) getContainerDetails(containerDesc string) string {
+	imgPath, err := getImagePath(containerDesc, t.sysCfg)
+	if err != nil {
+		return err.Error()
+	}
+	details := fmt.Sprintf("Image: %s\n", imgPath)
+	c, mpiCfg, err := container.GetMetadata(imgPath, t.sysCfg)
+	if err != nil {
+		return details + fmt.Sprintf("Failed to get the metadata: %s\n", err)
+	}
+	if mpiCfg.ID != "" {
+		details += fmt.Sprintf("MPI: %s:%s\n", mpiCfg.ID, mpiCfg.Version)
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"Model", c.Model},
+		{"Linux distribution", c.Distro},
+		{"Application", c.AppExe},
+		{"MPI directory", c.MPIDir},
+		{"Thread level", c.ThreadLevel},
+		{"Base image", c.BaseImage},
+		{"Checkpointing tool", c.CheckpointTool},
+	} {
+		if field.value != "" {
+			details += fmt.Sprintf("%s: %s\n", field.name, field.value)
+		}
+	}
+	var apps []string
+	for name := range c.Apps {
+		apps = append(apps, name)
+	}
+	sort.Strings(apps)
+	if len(apps) > 0 {
+		details += fmt.Sprintf("Applications: %s\n", strings.Join(apps, ", "))
+	}
+	return details
+}
+
+// getRunItems returns the items of the most recent runs, i.e., their ledgers
+func getRunItems() ([]tuiItem, error) {
+	ledgers, err := syexec.ListLedgers(filepath.Join(sys.GetSympiDir(), syexec.LedgerDirName))
+	if err != nil {
+		return nil, err
+	}
+	var items []tuiItem
+	for _, l := range ledgers {
+		if len(items) == tuiMaxRuns {
+			break
+		}
+		entries, err := syexec.LoadLedger(l)
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		failed := 0
+		for _, e := range entries {
+			if e.ExitCode != 0 || e.Error != "" {
+				failed++
+			}
+		}
+		label := fmt.Sprintf("%s\t%d command(s)", entries[0].Start.Format("2006-01-02 15:04:05"), len(entries))
+		if failed > 0 {
+			label += fmt.Sprintf(", %d failed", failed)
+		}
+		items = append(items, tuiItem{label: label, id: l})
+	}
+	return items, nil
+}
+
+// getRunDetails returns the commands executed during a run
+func getRunDetails(ledger string) string {
+	entries, err := syexec.LoadLedger(ledger)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Ledger %s\n%s", ledger, syexec.FormatLedger(entries))
+}
+
+// getMatrixItems returns the items of the compatibility matrices of the results directory
+func (t *TUI) getMatrixItems() ([]tuiItem, error) {
+	files, err := filepath.Glob(filepath.Join(t.ResultsDir, "*"+results.CompatibilityMatrixSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for compatibility matrices: %s", err)
+	}
+	var items []tuiItem
+	for _, f := range files {
+		items = append(items, tuiItem{label: strings.TrimSuffix(filepath.Base(f), results.CompatibilityMatrixSuffix), id: f})
+	}
+	return items, nil
+}
+
+// getMatrixCellItems returns the items of the cells of a compatibility matrix, a failing cell
+// referring to the details of the failure
+func getMatrixCellItems(matrixFile string) ([]tuiItem, error) {
+	cells, err := results.LoadCompatibilityMatrix(matrixFile)
+	if err != nil {
+		return nil, err
+	}
+	var items []tuiItem
+	for _, c := range cells {
+		status := "PASS"
+		if !c.Pass {
+			status = strings.TrimSpace("FAIL\t" + c.ErrorCategory)
+		}
+		items = append(items, tuiItem{label: fmt.Sprintf("host %s\tcontainer %s\t%s", c.HostMPI.Version, c.ContainerMPI.Version, status), id: c.ErrorDir})
+	}
+	return items, nil
+}
+
+// getFailureItems returns the items of the failures of the index of the errors directory
+func (t *TUI) getFailureItems() ([]tuiItem, error) {
+	entries, err := launcher.LoadErrorIndex(t.sysCfg)
+	if err != nil {
+		return nil, err
+	}
+	var items []tuiItem
+	for _, e := range entries {
+		items = append(items, tuiItem{label: fmt.Sprintf("%s\t%s -> %s\t%s", e.Date, e.HostMPI, e.ContainerMPI, e.Category), id: e.Dir})
+	}
+	return items, nil
+}
+
+// getLastLines returns the last lines of a file
+func getLastLines(path string, n int) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// getFailureDetails returns the details of a failure saved in a directory: the end of the output
+// of the job and the other files, e.g., the build artifacts
+func getFailureDetails(errorDir string) string {
+	if errorDir == "" || !util.IsDir(errorDir) {
+		return "No detail available"
+	}
+	details := fmt.Sprintf("Directory: %s\n", errorDir)
+	for _, name := range []string{"stderr.txt", "stdout.txt"} {
+		lines, err := getLastLines(filepath.Join(errorDir, name), tuiMaxLogLines)
+		if err != nil {
+			continue
+		}
+		details += fmt.Sprintf("\n--- %s (last %d lines) ---\n%s\n", name, tuiMaxLogLines, lines)
+	}
+	entries, err := ioutil.ReadDir(errorDir)
+	if err == nil {
+		var others []string
+		for _, e := range entries {
+			if e.Name() != "stderr.txt" && e.Name() != "stdout.txt" {
+				others = append(others, e.Name())
+			}
+		}
+		if len(others) > 0 {
+			details += fmt.Sprintf("\nOther files: %s\n", strings.Join(others, ", "))
+		}
+	}
+	return details
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/results"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestTUI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-tui-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	containerDir := filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+"app")
+	errorDir := filepath.Join(dir, "errors", "openmpi", "4.0.2-3.1.4")
+	files := map[string]string{
+		filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+"openmpi-4.0.2", "bin", "mpiexec"): "",
+		filepath.Join(containerDir, "app.sif"):                                                      "image",
+		filepath.Join(dir, "openmpi"+results.CompatibilityMatrixSuffix):                             "4.0.2\t4.0.2\ttrue\n4.0.2\t3.1.4\tfalse\ttimeout\t" + errorDir + "\n",
+		filepath.Join(errorDir, "stderr.txt"):                                                       "mpirun: timeout\n",
+	}
+	for path, content := range files {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", path, err)
+		}
+	}
+
+	// Containers view: export and delete the container; matrices view: open the details of the
+	// failing cell
+	input := strings.Join([]string{"2", "e", "d", "y", "b", "4", "", "j", "", "b", "b", "b", "q"}, "\n") + "\n"
+	var out bytes.Buffer
+	var sysCfg sys.Config
+	sysCfg.BinPath = dir
+	ui := NewTUI(strings.NewReader(input), &out, &sysCfg)
+	ui.ResultsDir = dir
+	ui.ExportDir = filepath.Join(dir, "export")
+	err = ui.Run()
+	if err != nil {
+		t.Fatalf("Run() failed: %s", err)
+	}
+
+	for _, expected := range []string{
+		"> 1. MPIs installed on the host (1)",
+		"  2. Containers (1)",
+		"  4. Compatibility matrices (1)",
+		"Container successfully exported: " + filepath.Join(dir, "export", "app.sif"),
+		"Delete app? [y/N]",
+		"app deleted",
+		"> 2. host 4.0.2\tcontainer 3.1.4\tFAIL\ttimeout",
+		"--- stderr.txt (last 40 lines) ---\nmpirun: timeout",
+		"  2. Containers (0)",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("output does not include %q:\n%s", expected, out.String())
+		}
+	}
+	if util.PathExists(containerDir) || !util.FileExists(filepath.Join(dir, "export", "app.sif")) {
+		t.Fatalf("the container was not exported and deleted")
+	}
+}
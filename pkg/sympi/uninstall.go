@@ -0,0 +1,323 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/buildenv"
+	"github.com/sylabs/singularity-mpi/pkg/builder"
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// UninstalledDirName is the name of the directory of the workspace where the manifests of
+	// the uninstalled installations of MPI are archived
+	UninstalledDirName = "uninstalled"
+)
+
+// UninstallOptions are the options of the uninstallation of MPI from the host
+type UninstallOptions struct {
+	// Force uninstalls MPI even when it is loaded in a shell or used by containers of the workspace
+	Force bool
+}
+
+// mpiUsers are the users of an installation of MPI that prevent it from being uninstalled
+type mpiUsers struct {
+	// envFiles are the environment files of the running shells where MPI is loaded
+	envFiles []string
+
+	// containers are the containers of the workspace executed with MPI
+	containers []string
+}
+
+func (u *mpiUsers) empty() bool {
+	return len(u.envFiles) == 0 && len(u.containers) == 0
+}
+
+func (u *mpiUsers) String() string {
+	var users []string
+	if len(u.envFiles) > 0 {
+		users = append(users, "loaded in "+strings.Join(u.envFiles, ", "))
+	}
+	if len(u.containers) > 0 {
+		users = append(users, "used by containers "+strings.Join(u.containers, ", "))
+	}
+	return strings.Join(users, " and ")
+}
+
+// isLoaded checks whether a component is in a stack of loaded components
+func isLoaded(stack []LoadedComponent, name string) bool {
+	for _, c := range stack {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getEnvFilesLoading returns the environment files of the running shells where a component is
+// loaded: the file of the current shell and the ones of the directories where they are stored
+func getEnvFilesLoading(name string, dirs []string, isAlive func(int, int64) bool) []string {
+	var files []string
+	current, err := GetEnvFile()
+	if err == nil && isLoaded(GetLoaded(current), name) {
+		files = append(files, current)
+	}
+	for _, d := range dirs {
+		live, err := getLiveEnvFiles(d, isAlive)
+		if err != nil {
+			continue
+		}
+		for _, file := range live {
+			if file != current && isLoaded(getLoadedFromEnvFile(file), name) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// getContainerMPI returns the implementation of MPI of a container of the workspace, from the
+// metadata of its image
+func getContainerMPI(containerDesc string, sysCfg *sys.Config) (implem.Info, error) {
+	imgPath, err := getImagePath(containerDesc, sysCfg)
+	if err != nil {
+		return implem.Info{}, err
+	}
+	_, mpiCfg, err := container.GetMetadata(imgPath, sysCfg)
+	return mpiCfg, err
+}
+
+// getContainersUsing returns the containers of the workspace that are executed with an
+// installation of MPI: the containers linked to it in the workspace (see container.MPILink) and
+// the other containers for which it is the compatible MPI on the host
+func getContainersUsing(mpiCfg *implem.Info, getMPI func(string) (implem.Info, error)) ([]string, error) {
+	entries, err := ioutil.ReadDir(sys.GetSympiDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", sys.GetSympiDir(), err)
+	}
+	containers, err := GetContainerInstalls(entries)
+	if err != nil {
+		return nil, err
+	}
+	installs, err := GetHostMPIInstalls(entries)
+	if err != nil {
+		return nil, err
+	}
+	links, err := container.LoadMPILinks()
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+	}
+
+	var users []string
+	name := mpiCfg.ID + ":" + mpiCfg.Version
+	for _, c := range containers {
+		if link, ok := container.FindMPILink(links, c); ok {
+			selected, err := link.SelectHostMPI(installs)
+			if err == nil && selected == name {
+				users = append(users, c)
+			}
+			continue
+		}
+		containerMPI, err := getMPI(c)
+		if err != nil {
+			log.Printf("[WARN] failed to get the MPI of container %s: %s", c, err)
+			continue
+		}
+		if containerMPI.ID != mpiCfg.ID {
+			continue
+		}
+		hostMPI, err := findCompatibleMPI(&containerMPI)
+		if err == nil && hostMPI.Version == mpiCfg.Version {
+			users = append(users, c)
+		}
+	}
+	return users, nil
+}
+
+// getBrokenContainers returns, among containers using an installation of MPI, the ones linked to
+// the MPI of the host that no other installation can execute once it is uninstalled
+func getBrokenContainers(name string, containers []string) []string {
+	links, err := container.LoadMPILinks()
+	if err != nil {
+		return nil
+	}
+	installs, err := getHostMPIs()
+	if err != nil {
+		return nil
+	}
+	var remaining []string
+	for _, i := range installs {
+		if i != name {
+			remaining = append(remaining, i)
+		}
+	}
+
+	var broken []string
+	for _, c := range containers {
+		link, ok := container.FindMPILink(links, c)
+		if !ok {
+			continue
+		}
+		_, err := link.SelectHostMPI(remaining)
+		if err != nil {
+			broken = append(broken, c)
+		}
+	}
+	return broken
+}
+
+// archiveManifest copies the manifest of an installation of MPI in the archive of the workspace
+// before it is uninstalled, so what was installed can still be checked; the path of the archived
+// manifest is returned, empty when the installation has no manifest
+func archiveManifest(installDir string) (string, error) {
+	src := filepath.Join(installDir, mpiManifestName)
+	if !util.FileExists(src) {
+		log.Printf("[WARN] no manifest for %s, nothing to archive", installDir)
+		return "", nil
+	}
+	archiveDir := filepath.Join(sys.GetSympiDir(), UninstalledDirName)
+	err := os.MkdirAll(archiveDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", archiveDir, err)
+	}
+	dst := filepath.Join(archiveDir, filepath.Base(installDir)+"-"+time.Now().Format("20060102-150405")+".MANIFEST")
+	err = util.CopyFile(src, dst)
+	if err != nil {
+		return "", fmt.Errorf("failed to copy %s to %s: %s", src, dst, err)
+	}
+	return dst, nil
+}
+
+// getUninstallLeftovers checks that an installation of MPI was completely uninstalled and returns
+// what was left behind: the installation directory or the archived manifest missing
+func getUninstallLeftovers(installDir string, archivedManifest string) []string {
+	var leftovers []string
+	if util.PathExists(installDir) {
+		entries, _ := ioutil.ReadDir(installDir)
+		leftovers = append(leftovers, fmt.Sprintf("%s still exists (%d entries)", installDir, len(entries)))
+	}
+	if archivedManifest != "" && !util.FileExists(archivedManifest) {
+		leftovers = append(leftovers, fmt.Sprintf("archived manifest %s is missing", archivedManifest))
+	}
+	return leftovers
+}
+
+// getEnvFilesStillLoading returns, among environment files of running shells, the ones where a
+// component is still loaded; they can only be updated from their own shell
+func getEnvFilesStillLoading(name string, envFiles []string) []string {
+	var files []string
+	for _, file := range envFiles {
+		if isLoaded(getLoadedFromEnvFile(file), name) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// UninstallMPIfromHost removes a specific implementation of MPI from the host, with the default
+// options.
+//
+// Deprecated: use UninstallMPIfromHostWithOptions, which can force the uninstallation.
+func UninstallMPIfromHost(mpiDesc string, sysCfg *sys.Config) error {
+	return UninstallMPIfromHostWithOptions(mpiDesc, &UninstallOptions{}, sysCfg)
+}
+
+// UninstallMPIfromHostWithOptions removes a specific implementation of MPI from the host. MPI is
+// not uninstalled when it is loaded in a running shell or used by containers of the workspace,
+// unless forced. Its manifest is archived in the workspace and the uninstallation is then
+// verified, what was left behind being reported as an error; the other shells where MPI is still
+// loaded are only reported as warnings since they can only be updated from their own shell.
+func UninstallMPIfromHostWithOptions(mpiDesc string, opts *UninstallOptions, sysCfg *sys.Config) error {
+	var mpiCfg implem.Info
+	mpiCfg.ID, mpiCfg.Version = GetMPIDetails(mpiDesc)
+	if mpiCfg.ID == "" {
+		return fmt.Errorf("invalid MPI %s", mpiDesc)
+	}
+	name := mpiCfg.ID + ":" + mpiCfg.Version
+
+	var buildEnv buildenv.Info
+	err := buildenv.CreateDefaultHostEnvCfg(&buildEnv, &mpiCfg, sysCfg)
+	if err != nil {
+		return fmt.Errorf("failed to set host build environment: %s", err)
+	}
+	if !util.PathExists(buildEnv.InstallDir) {
+		return fmt.Errorf("%s is not installed, execute 'sympi -list' to get the list of available installations", name)
+	}
+	if sys.IsPersistent(sysCfg) && !sys.HasCleanupPolicy(sysCfg, sys.HostMPIResource) {
+		log.Printf("Persistent installs mode, not uninstalling MPI from host")
+		return nil
+	}
+
+	var users mpiUsers
+	users.envFiles = getEnvFilesLoading(name, getEnvFileDirs(), processIsAlive)
+	users.containers, err = getContainersUsing(&mpiCfg, func(c string) (implem.Info, error) {
+		return getContainerMPI(c, sysCfg)
+	})
+	if err != nil {
+		return err
+	}
+	if !users.empty() {
+		broken := getBrokenContainers(name, users.containers)
+		if !opts.Force {
+			if len(broken) > 0 {
+				return fmt.Errorf("%s is %s, and %s would not run anymore; use -force to uninstall it anyway", name, users.String(), strings.Join(broken, ", "))
+			}
+			return fmt.Errorf("%s is %s; use -force to uninstall it anyway", name, users.String())
+		}
+		log.Printf("[WARN] %s is %s, uninstalling it anyway", name, users.String())
+		for _, c := range broken {
+			log.Printf("[WARN] no other installation of MPI satisfies the requirements of container %s, it will not run anymore", c)
+		}
+	}
+
+	archivedManifest, err := archiveManifest(buildEnv.InstallDir)
+	if err != nil {
+		return fmt.Errorf("failed to archive the manifest of %s: %s", name, err)
+	}
+
+	b, err := builder.Load(&mpiCfg)
+	if err != nil {
+		return fmt.Errorf("failed to load a builder: %s", err)
+	}
+
+	execRes := b.UninstallHost(&mpiCfg, &buildEnv, sysCfg)
+	if execRes.Err != nil {
+		return fmt.Errorf("failed to uninstall MPI from the host: %s", execRes.Err)
+	}
+
+	// The environment of the current shell cannot refer to an installation that does not exist
+	// anymore; the other shells are reported since their environment cannot be computed from here
+	current, err := GetEnvFile()
+	if err == nil && isLoaded(getLoadedFromEnvFile(current), name) {
+		err = Unload(name)
+		if err != nil {
+			log.Printf("[WARN] failed to unload %s: %s", name, err)
+		}
+	}
+
+	for _, file := range getEnvFilesStillLoading(name, users.envFiles) {
+		log.Printf("[WARN] %s is still loaded in %s, execute 'sympi -unload %s' in that shell", name, file, name)
+	}
+	leftovers := getUninstallLeftovers(buildEnv.InstallDir, archivedManifest)
+	if len(leftovers) > 0 {
+		return fmt.Errorf("uninstallation of %s is incomplete: %s", name, strings.Join(leftovers, "; "))
+	}
+	if archivedManifest != "" {
+		fmt.Printf("%s uninstalled, its manifest is archived in %s\n", name, archivedManifest)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/container"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestUninstallChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-uninstall-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	defer os.Setenv(SYMPI_ENVFILE_ENV, os.Getenv(SYMPI_ENVFILE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	installDir := filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+"openmpi-4.0.2")
+	for _, d := range []string{
+		filepath.Join(installDir, "bin"),
+		filepath.Join(sys.GetSympiDir(), sys.MPIInstallDirPrefix+"mpich-3.3.2", "bin"),
+		filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+"ompi-app"),
+		filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+"mpich-app"),
+		filepath.Join(sys.GetSympiDir(), sys.ContainerInstallDirPrefix+"ompi-old-app"),
+	} {
+		err = os.MkdirAll(d, 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", d, err)
+		}
+	}
+
+	// Environment files of the current shell (43), of a running shell (42) and of a terminated one (41)
+	envFileDir := filepath.Join(dir, "run")
+	err = os.MkdirAll(envFileDir, 0700)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", envFileDir, err)
+	}
+	envFiles := map[int]string{
+		41: envFileOwnerHeader + "41\n" + envFileLoadedHeader + "all openmpi:4.0.2\n",
+		42: envFileOwnerHeader + "42\n" + envFileLoadedHeader + "run openmpi:4.0.2\n" + envFileLoadedHeader + "compile mpich:3.3.2\n",
+		43: envFileOwnerHeader + "43\n" + envFileLoadedHeader + "all mpich:3.3.2\n",
+	}
+	for pid, content := range envFiles {
+		file := filepath.Join(envFileDir, fmt.Sprintf("%s%d", envFilePrefix, pid))
+		err = ioutil.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %s", file, err)
+		}
+	}
+	os.Setenv(SYMPI_ENVFILE_ENV, filepath.Join(envFileDir, envFilePrefix+"43"))
+	isAlive := func(pid int, created int64) bool { return pid != 41 }
+
+	loadingTests := []struct {
+		name     string
+		expected []string
+	}{
+		{name: "openmpi:4.0.2", expected: []string{filepath.Join(envFileDir, envFilePrefix+"42")}},
+		{name: "mpich:3.3.2", expected: []string{filepath.Join(envFileDir, envFilePrefix+"43"), filepath.Join(envFileDir, envFilePrefix+"42")}},
+		{name: "mpich:3.4", expected: nil},
+	}
+	for _, tt := range loadingTests {
+		files := getEnvFilesLoading(tt.name, []string{envFileDir}, isAlive)
+		if !reflect.DeepEqual(files, tt.expected) {
+			t.Fatalf("%s is loaded in %v instead of %v", tt.name, files, tt.expected)
+		}
+	}
+
+	containerMPIs := map[string]implem.Info{
+		"ompi-app":     {ID: implem.OMPI, Version: "4.0.2"},
+		"mpich-app":    {ID: implem.MPICH, Version: "3.3.2"},
+		"ompi-old-app": {ID: implem.OMPI, Version: "3.1.4"},
+	}
+	getMPI := func(c string) (implem.Info, error) {
+		return containerMPIs[c], nil
+	}
+	usingTests := []struct {
+		mpi      implem.Info
+		expected []string
+	}{
+		{mpi: implem.Info{ID: implem.OMPI, Version: "4.0.2"}, expected: []string{"ompi-app", "ompi-old-app"}},
+		{mpi: implem.Info{ID: implem.MPICH, Version: "3.3.2"}, expected: []string{"mpich-app"}},
+		{mpi: implem.Info{ID: implem.MPICH, Version: "3.4"}, expected: nil},
+	}
+	for _, tt := range usingTests {
+		containers, err := getContainersUsing(&tt.mpi, getMPI)
+		if err != nil {
+			t.Fatalf("failed to get the containers using %s:%s: %s", tt.mpi.ID, tt.mpi.Version, err)
+		}
+		if !reflect.DeepEqual(containers, tt.expected) {
+			t.Fatalf("%s:%s is used by %v instead of %v", tt.mpi.ID, tt.mpi.Version, containers, tt.expected)
+		}
+	}
+
+	// Links of the workspace have precedence over the metadata of the images: ompi-app requires a
+	// more recent Open MPI than the one installed and mpich-app cannot run with another MPICH
+	for _, l := range []container.MPILink{
+		{Container: "ompi-app", MPI: "openmpi:4.0.3", MinVersion: "4.0.3"},
+		{Container: "mpich-app", MPI: "mpich:3.3.2", MinVersion: "3.3.2", HostMPI: "mpich:3.3.2"},
+	} {
+		err = container.RecordMPILink(l)
+		if err != nil {
+			t.Fatalf("failed to record the link of %s: %s", l.Container, err)
+		}
+	}
+	containers, err := getContainersUsing(&implem.Info{ID: implem.OMPI, Version: "4.0.2"}, getMPI)
+	if err != nil || !reflect.DeepEqual(containers, []string{"ompi-old-app"}) {
+		t.Fatalf("openmpi:4.0.2 is used by %v instead of [ompi-old-app] (err: %v)", containers, err)
+	}
+	broken := getBrokenContainers("mpich:3.3.2", []string{"mpich-app"})
+	if !reflect.DeepEqual(broken, []string{"mpich-app"}) {
+		t.Fatalf("containers broken by the uninstallation of mpich:3.3.2 are %v instead of [mpich-app]", broken)
+	}
+
+	// Without manifest, nothing is archived
+	archived, err := archiveManifest(installDir)
+	if err != nil || archived != "" {
+		t.Fatalf("archiving a missing manifest returned %q, %v", archived, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(installDir, mpiManifestName), []byte("bin/mpiexec: 1234\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to create the manifest: %s", err)
+	}
+	archived, err = archiveManifest(installDir)
+	if err != nil {
+		t.Fatalf("failed to archive the manifest: %s", err)
+	}
+	if filepath.Dir(archived) != filepath.Join(sys.GetSympiDir(), UninstalledDirName) {
+		t.Fatalf("manifest archived in %s instead of the %s directory of the workspace", archived, UninstalledDirName)
+	}
+
+	loadingFiles := getEnvFilesLoading("openmpi:4.0.2", []string{envFileDir}, isAlive)
+	leftovers := getUninstallLeftovers(installDir, archived)
+	if len(leftovers) != 1 {
+		t.Fatalf("%d leftovers reported instead of 1 (installation directory): %v", len(leftovers), leftovers)
+	}
+	stillLoading := getEnvFilesStillLoading("openmpi:4.0.2", loadingFiles)
+	if !reflect.DeepEqual(stillLoading, loadingFiles) {
+		t.Fatalf("openmpi:4.0.2 still loaded in %v instead of %v", stillLoading, loadingFiles)
+	}
+
+	err = os.RemoveAll(installDir)
+	if err != nil {
+		t.Fatalf("failed to remove %s: %s", installDir, err)
+	}
+	err = ioutil.WriteFile(loadingFiles[0], []byte(envFileOwnerHeader+"42\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to update %s: %s", loadingFiles[0], err)
+	}
+	leftovers = getUninstallLeftovers(installDir, archived)
+	if len(leftovers) != 0 {
+		t.Fatalf("leftovers reported after a complete uninstallation: %v", leftovers)
+	}
+	stillLoading = getEnvFilesStillLoading("openmpi:4.0.2", loadingFiles)
+	if len(stillLoading) != 0 {
+		t.Fatalf("openmpi:4.0.2 reported as still loaded in %v once unloaded", stillLoading)
+	}
+	os.Remove(archived)
+	leftovers = getUninstallLeftovers(installDir, archived)
+	if len(leftovers) != 1 {
+		t.Fatalf("missing archived manifest not reported: %v", leftovers)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/configparser"
+	"github.com/sylabs/singularity-mpi/pkg/implem"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+const (
+	// githubTokenEnv is the environment variable with the token used to query the GitHub API,
+	// which avoids the rate limit of anonymous queries
+	githubTokenEnv = "GITHUB_TOKEN"
+
+	// singularityConfigFile is the name of the configuration file listing the versions of
+	// Singularity that can be installed
+	singularityConfigFile = "sympi_singularity.conf"
+)
+
+// releaseTagRegexp matches the tags of final releases, e.g., v4.0.2, but not release candidates
+var releaseTagRegexp = regexp.MustCompile(`^v?([0-9]+(\.[0-9]+)+)$`)
+
+// githubAPI is the base URL of the GitHub API, overridden in tests
+var githubAPI = "https://api.github.com"
+
+// maxGithubPages is the maximum number of pages of releases queried, which bounds the number of
+// queries when a repository has a very long history
+const maxGithubPages = 10
+
+// linkNextRegexp matches the URL of the next page in the Link header of a GitHub API response,
+// e.g., <https://api.github.com/repositories/1/releases?per_page=100&page=2>; rel="next"
+var linkNextRegexp = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// githubRelease is the subset of the description of a release returned by the GitHub API
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Assets     []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// UpstreamFeed describes where the releases of a software are published
+type UpstreamFeed struct {
+	// ID is the identifier of the software, e.g., openmpi
+	ID string
+
+	// Repo is the GitHub repository publishing the releases, e.g., open-mpi/ompi
+	Repo string
+
+	// ConfigFile is the name of the configuration file listing the versions that can be installed,
+	// empty when the software cannot be installed by the tool
+	ConfigFile string
+
+	// getURL returns the URL of the tarball of a release, empty if not available
+	getURL func(version string, r *githubRelease) string
+}
+
+// UpstreamRelease is a version of a software published upstream
+type UpstreamRelease struct {
+	// Version is the version of the release, e.g., 4.0.2
+	Version string
+
+	// URL is the URL of the tarball of the release
+	URL string
+}
+
+// getReleaseAsset returns the URL of the asset of a release named after the version, e.g.,
+// singularity-ce-3.11.4.tar.gz
+func getReleaseAsset(prefixes []string, version string, r *githubRelease) string {
+	for _, a := range r.Assets {
+		for _, p := range prefixes {
+			if a.Name == p+version+".tar.gz" {
+				return a.URL
+			}
+		}
+	}
+	return ""
+}
+
+// GetUpstreamFeeds returns the release feeds of the software the tool knows about
+func GetUpstreamFeeds() []UpstreamFeed {
+	return []UpstreamFeed{
+		{
+			ID:         "openmpi",
+			Repo:       "open-mpi/ompi",
+			ConfigFile: sys.GetMPIConfigFileName("openmpi"),
+			getURL: func(version string, r *githubRelease) string {
+				// Tarballs are published on the download page of the series, e.g., v4.0
+				tokens := strings.Split(version, ".")
+				return "https://download.open-mpi.org/release/open-mpi/v" + tokens[0] + "." + tokens[1] + "/openmpi-" + version + ".tar.bz2"
+			},
+		},
+		{
+			ID:         "mpich",
+			Repo:       "pmodels/mpich",
+			ConfigFile: sys.GetMPIConfigFileName("mpich"),
+			getURL: func(version string, r *githubRelease) string {
+				return "https://www.mpich.org/static/downloads/" + version + "/mpich-" + version + ".tar.gz"
+			},
+		},
+		{
+			ID:         "singularity",
+			Repo:       "sylabs/singularity",
+			ConfigFile: singularityConfigFile,
+			getURL: func(version string, r *githubRelease) string {
+				return getReleaseAsset([]string{"singularity-ce-", "singularity-"}, version, r)
+			},
+		},
+		{
+			// Apptainer is listed for information, it cannot be installed by the tool
+			ID:   "apptainer",
+			Repo: "apptainer/apptainer",
+			getURL: func(version string, r *githubRelease) string {
+				return getReleaseAsset([]string{"apptainer-"}, version, r)
+			},
+		},
+	}
+}
+
+// getGithubReleasesPage queries a page of releases of a repository and returns the releases and
+// the URL of the next page, empty on the last page
+func getGithubReleasesPage(client *http.Client, repo string, url string) ([]githubRelease, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if token := os.Getenv(githubTokenEnv); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query the releases of %s: %s", repo, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to query the releases of %s: %s", repo, resp.Status)
+	}
+
+	var releases []githubRelease
+	err = json.NewDecoder(resp.Body).Decode(&releases)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid releases of %s: %s", repo, err)
+	}
+	var next string
+	if m := linkNextRegexp.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		next = m[1]
+	}
+	return releases, next, nil
+}
+
+// getGithubReleases queries the GitHub API for the releases of a repository, the most recent
+// first, following the pages of the response up to maxGithubPages
+func getGithubReleases(repo string) ([]githubRelease, error) {
+	client := sys.NewHTTPClient(time.Minute)
+	url := githubAPI + "/repos/" + repo + "/releases?per_page=100"
+	var releases []githubRelease
+	for page := 0; url != "" && page < maxGithubPages; page++ {
+		pageReleases, next, err := getGithubReleasesPage(client, repo, url)
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, pageReleases...)
+		url = next
+	}
+	return releases, nil
+}
+
+// Fetch returns the final releases of the software, the most recent first, ignoring drafts,
+// release candidates and releases without tarball
+func (f *UpstreamFeed) Fetch() ([]UpstreamRelease, error) {
+	releases, err := getGithubReleases(f.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var upstream []UpstreamRelease
+	for i := range releases {
+		r := &releases[i]
+		m := releaseTagRegexp.FindStringSubmatch(r.TagName)
+		if r.Draft || r.Prerelease || m == nil {
+			continue
+		}
+		url := f.getURL(m[1], r)
+		if url != "" {
+			upstream = append(upstream, UpstreamRelease{Version: m[1], URL: url})
+		}
+	}
+	sort.Slice(upstream, func(i, j int) bool {
+		return implem.CompareVersions(upstream[i].Version, upstream[j].Version) > 0
+	})
+	return upstream, nil
+}
+
+// GetNewReleases returns the releases that are newer than all the versions of a configuration
+// file, the most recent first; all the releases are new when the file does not exist
+func GetNewReleases(releases []UpstreamRelease, configFile string) ([]UpstreamRelease, error) {
+	latest := ""
+	if util.FileExists(configFile) {
+		kvs, err := configparser.Load(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration from %s: %s", configFile, err)
+		}
+		for _, e := range kvs {
+			if latest == "" || implem.CompareVersions(e.Key, latest) > 0 {
+				latest = e.Key
+			}
+		}
+	}
+
+	var newReleases []UpstreamRelease
+	for _, r := range releases {
+		if latest == "" || implem.CompareVersions(r.Version, latest) > 0 {
+			newReleases = append(newReleases, r)
+		}
+	}
+	return newReleases, nil
+}
+
+// AppendReleases adds releases to a configuration file listing the versions that can be
+// installed, the oldest first like the rest of the file
+func AppendReleases(configFile string, releases []UpstreamRelease) error {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %s", configFile, err)
+	}
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	for i := len(releases) - 1; i >= 0; i-- {
+		content += releases[i].Version + "=" + releases[i].URL + "\n"
+	}
+
+	err = os.MkdirAll(filepath.Dir(configFile), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(configFile), err)
+	}
+	err = ioutil.WriteFile(configFile, []byte(content), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", configFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpstreamReleases(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/sylabs/singularity/releases" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`[
+			{"tag_name": "v3.5.1", "assets": [{"name": "singularity-3.5.1.tar.gz", "browser_download_url": "https://example.com/singularity-3.5.1.tar.gz"}]}
+		]`))
+			return
+		}
+		w.Header().Set("Link", `<`+srv.URL+`/repos/sylabs/singularity/releases?per_page=100&page=2>; rel="next", <`+srv.URL+`/repos/sylabs/singularity/releases?per_page=100&page=2>; rel="last"`)
+		w.Write([]byte(`[
+			{"tag_name": "v3.11.0-rc.1", "prerelease": true},
+			{"tag_name": "v3.10.0", "assets": [{"name": "singularity-ce-3.10.0.tar.gz", "browser_download_url": "https://example.com/singularity-ce-3.10.0.tar.gz"}]},
+			{"tag_name": "v3.9.0", "draft": true, "assets": [{"name": "singularity-ce-3.9.0.tar.gz", "browser_download_url": "https://example.com/singularity-ce-3.9.0.tar.gz"}]},
+			{"tag_name": "v3.5.2", "assets": [{"name": "singularity-3.5.2.tar.gz", "browser_download_url": "https://example.com/singularity-3.5.2.tar.gz"}]},
+			{"tag_name": "v3.8.7", "assets": [{"name": "singularity-ce-3.8.7.tar.gz", "browser_download_url": "https://example.com/singularity-ce-3.8.7.tar.gz"}]},
+			{"tag_name": "v3.8.6"}
+		]`))
+	}))
+	defer srv.Close()
+	defaultGithubAPI := githubAPI
+	githubAPI = srv.URL
+	defer func() { githubAPI = defaultGithubAPI }()
+
+	var feed UpstreamFeed
+	for _, f := range GetUpstreamFeeds() {
+		if f.ID == "singularity" {
+			feed = f
+		}
+	}
+	releases, err := feed.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch() failed: %s", err)
+	}
+	if len(releases) != 4 || releases[0].Version != "3.10.0" || releases[2].Version != "3.5.2" || releases[3].Version != "3.5.1" {
+		t.Fatalf("invalid releases: %v", releases)
+	}
+
+	dir, err := ioutil.TempDir("", "sympi-upstream-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	cfgFile := filepath.Join(dir, feed.ConfigFile)
+	err = ioutil.WriteFile(cfgFile, []byte("3.5.2=https://example.com/singularity-3.5.2.tar.gz"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", cfgFile, err)
+	}
+
+	newReleases, err := GetNewReleases(releases, cfgFile)
+	if err != nil || len(newReleases) != 2 {
+		t.Fatalf("GetNewReleases() returned %v: %v", newReleases, err)
+	}
+	err = AppendReleases(cfgFile, newReleases)
+	if err != nil {
+		t.Fatalf("AppendReleases() failed: %s", err)
+	}
+	newReleases, err = GetNewReleases(releases, cfgFile)
+	if err != nil || len(newReleases) != 0 {
+		t.Fatalf("GetNewReleases() returned %v after adding them: %v", newReleases, err)
+	}
+	data, _ := ioutil.ReadFile(cfgFile)
+	expected := "3.5.2=https://example.com/singularity-3.5.2.tar.gz\n3.8.7=https://example.com/singularity-ce-3.8.7.tar.gz\n3.10.0=https://example.com/singularity-ce-3.10.0.tar.gz\n"
+	if string(data) != expected {
+		t.Fatalf("invalid configuration file:\n%s", data)
+	}
+
+	feed.Repo = "unknown/unknown"
+	_, err = feed.Fetch()
+	if err == nil {
+		t.Fatalf("Fetch() succeeded with an unknown repository")
+	}
+}
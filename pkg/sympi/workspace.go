@@ -0,0 +1,101 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gvallee/go_util/pkg/util"
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+// ValidateWorkspaceName checks whether a name can be used for a workspace, e.g., gcc9
+func ValidateWorkspaceName(name string) error {
+	return sys.ValidateWorkspaceName(name)
+}
+
+// ListWorkspaces returns the names of all the workspaces, the default workspace first
+func ListWorkspaces() ([]string, error) {
+	workspaces := []string{sys.DefaultWorkspace}
+	dir := filepath.Join(sys.GetSympiBaseDir(), sys.WorkspacesDirName)
+	if !util.IsDir(dir) {
+		return workspaces, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return append(workspaces, names...), nil
+}
+
+// CreateWorkspace creates a new named workspace and returns its directory. The workspace gets its
+// own installations, containers and configuration files; its configuration file is created the
+// first time the workspace is used.
+func CreateWorkspace(name string) (string, error) {
+	err := ValidateWorkspaceName(name)
+	if err != nil {
+		return "", err
+	}
+	dir := sys.GetWorkspaceDir(name)
+	if name == sys.DefaultWorkspace || util.PathExists(dir) {
+		return "", fmt.Errorf("workspace %s already exists", name)
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", dir, err)
+	}
+	return dir, nil
+}
+
+// SetWorkspace selects the workspace used by the current process, which must exist
+func SetWorkspace(name string) error {
+	if name != sys.DefaultWorkspace {
+		err := ValidateWorkspaceName(name)
+		if err != nil {
+			return err
+		}
+		if !util.IsDir(sys.GetWorkspaceDir(name)) {
+			return fmt.Errorf("workspace %s does not exist, create it with 'sympi -create-workspace %s'", name, name)
+		}
+	}
+	return os.Setenv(sys.SYMPI_WORKSPACE_ENV, name)
+}
+
+// DeleteWorkspace deletes a named workspace and everything it contains. Neither the default
+// workspace nor the current workspace can be deleted.
+func DeleteWorkspace(name string) error {
+	if name == sys.DefaultWorkspace {
+		return fmt.Errorf("the default workspace cannot be deleted")
+	}
+	err := ValidateWorkspaceName(name)
+	if err != nil {
+		return err
+	}
+	if name == sys.GetWorkspace() {
+		return fmt.Errorf("workspace %s is the current workspace", name)
+	}
+	dir := sys.GetWorkspaceDir(name)
+	if !util.IsDir(dir) {
+		return fmt.Errorf("workspace %s does not exist", name)
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %s", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sympi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity-mpi/pkg/sys"
+)
+
+func TestWorkspaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-workspaces-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, os.Getenv(sys.SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(sys.SYMPI_WORKSPACE_ENV, os.Getenv(sys.SYMPI_WORKSPACE_ENV))
+	os.Setenv(sys.SYMPI_INSTALL_DIR_ENV, dir)
+	os.Setenv(sys.SYMPI_WORKSPACE_ENV, "")
+
+	for _, name := range []string{"gcc9", "intel-19.1"} {
+		wsDir, err := CreateWorkspace(name)
+		if err != nil {
+			t.Fatalf("CreateWorkspace(%s) failed: %s", name, err)
+		}
+		if wsDir != filepath.Join(dir, sys.WorkspacesDirName, name) {
+			t.Fatalf("workspace %s created in %s", name, wsDir)
+		}
+	}
+	for _, name := range []string{"gcc9", sys.DefaultWorkspace, "../gcc9", ""} {
+		_, err = CreateWorkspace(name)
+		if err == nil {
+			t.Fatalf("CreateWorkspace(%q) succeeded", name)
+		}
+	}
+
+	workspaces, err := ListWorkspaces()
+	if err != nil {
+		t.Fatalf("ListWorkspaces() failed: %s", err)
+	}
+	if strings.Join(workspaces, ",") != "default,gcc9,intel-19.1" {
+		t.Fatalf("ListWorkspaces() returned %s", strings.Join(workspaces, ","))
+	}
+
+	err = SetWorkspace("gcc9")
+	if err != nil {
+		t.Fatalf("SetWorkspace() failed: %s", err)
+	}
+	if sys.GetSympiDir() != filepath.Join(dir, sys.WorkspacesDirName, "gcc9") {
+		t.Fatalf("sympi directory is %s", sys.GetSympiDir())
+	}
+	if SetWorkspace("missing") == nil {
+		t.Fatalf("SetWorkspace() succeeded with a workspace that does not exist")
+	}
+
+	for _, name := range []string{sys.DefaultWorkspace, "gcc9", "missing"} {
+		if DeleteWorkspace(name) == nil {
+			t.Fatalf("DeleteWorkspace(%s) succeeded", name)
+		}
+	}
+	err = DeleteWorkspace("intel-19.1")
+	if err != nil {
+		t.Fatalf("DeleteWorkspace() failed: %s", err)
+	}
+	workspaces, _ = ListWorkspaces()
+	if strings.Join(workspaces, ",") != "default,gcc9" {
+		t.Fatalf("workspaces after deletion: %s", strings.Join(workspaces, ","))
+	}
+
+	err = SetWorkspace(sys.DefaultWorkspace)
+	if err != nil || sys.GetSympiDir() != dir {
+		t.Fatalf("failed to select the default workspace: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BuildProfile is a named set of build settings of the tool's configuration file, e.g., the
+// toolchain of a vendor, selected per build instead of changing the defaults of the tool
+type BuildProfile struct {
+	// Name is the name of the profile, e.g., nvidia
+	Name string
+
+	// Toolchain is the toolchain building MPI and the applications, e.g., toolchain.NVHPC
+	Toolchain string
+
+	// ToolchainDir is the directory where the toolchain is installed
+	ToolchainDir string
+}
+
+// SelectBuildProfile applies a build profile of the tool's configuration file to the
+// configuration, i.e., its toolchain replaces the default toolchain of the tool
+func (c *Config) SelectBuildProfile(name string) error {
+	p, ok := c.BuildProfiles[name]
+	if !ok {
+		var names []string
+		for n := range c.BuildProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown build profile %s, available profiles: %s", name, strings.Join(names, ", "))
+	}
+	c.BuildProfile = p.Name
+	c.Toolchain = p.Toolchain
+	c.ToolchainDir = p.ToolchainDir
+	return nil
+}
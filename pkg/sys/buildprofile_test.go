@@ -0,0 +1,33 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"testing"
+)
+
+func TestSelectBuildProfile(t *testing.T) {
+	var cfg Config
+	cfg.Toolchain = "armclang"
+	cfg.ToolchainDir = "/opt/arm"
+	cfg.BuildProfiles = map[string]BuildProfile{
+		"nvidia": {Name: "nvidia", Toolchain: "nvhpc", ToolchainDir: "/opt/nvidia/hpc_sdk/Linux_x86_64/23.11"},
+		"gnu":    {Name: "gnu", Toolchain: "gcc"},
+	}
+
+	err := cfg.SelectBuildProfile("nvidia")
+	if err != nil || cfg.BuildProfile != "nvidia" || cfg.Toolchain != "nvhpc" || cfg.ToolchainDir != "/opt/nvidia/hpc_sdk/Linux_x86_64/23.11" {
+		t.Fatalf("SelectBuildProfile() did not select the nvidia profile: %v (%v)", cfg, err)
+	}
+	err = cfg.SelectBuildProfile("gnu")
+	if err != nil || cfg.Toolchain != "gcc" || cfg.ToolchainDir != "" {
+		t.Fatalf("SelectBuildProfile() did not select the gnu profile: %s, %s (%v)", cfg.Toolchain, cfg.ToolchainDir, err)
+	}
+	err = cfg.SelectBuildProfile("intel")
+	if err == nil || cfg.BuildProfile != "gnu" {
+		t.Fatalf("SelectBuildProfile() succeeded with an unknown profile")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// CleanupAlways specifies that a resource is always removed once it is not needed anymore
+	CleanupAlways = "always"
+
+	// CleanupOnSuccess specifies that a resource is kept for debugging when something failed
+	CleanupOnSuccess = "on-success"
+
+	// CleanupNever specifies that a resource is never removed
+	CleanupNever = "never"
+)
+
+const (
+	// HostMPIResource designates the installations of MPI on the host
+	HostMPIResource = "host-mpi"
+
+	// BuildDirResource designates the directories where software is built
+	BuildDirResource = "build"
+
+	// ScratchDirResource designates the scratch directories, e.g., where software is downloaded
+	ScratchDirResource = "scratch"
+
+	// ImageResource designates the images of the containers created by experiments
+	ImageResource = "image"
+)
+
+// CleanupPolicy maps resources, e.g., HostMPIResource, to what to do with them once they are
+// not needed anymore, e.g., CleanupOnSuccess
+type CleanupPolicy map[string]string
+
+var cleanupResources = []string{HostMPIResource, BuildDirResource, ScratchDirResource, ImageResource}
+
+func isValidCleanup(policy string) bool {
+	return policy == CleanupAlways || policy == CleanupOnSuccess || policy == CleanupNever
+}
+
+func isValidResource(resource string) bool {
+	for _, r := range cleanupResources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCleanupPolicy parses a cleanup policy, either a single policy applying to all the
+// resources, e.g., 'on-success', or a comma-separated list of '<resource>=<policy>', e.g.,
+// 'host-mpi=never,scratch=on-success'; resources that are not listed keep their default policy
+func ParseCleanupPolicy(str string) (CleanupPolicy, error) {
+	policy := make(CleanupPolicy)
+	if str == "" {
+		return policy, nil
+	}
+
+	if isValidCleanup(str) {
+		for _, r := range cleanupResources {
+			policy[r] = str
+		}
+		return policy, nil
+	}
+
+	for _, item := range strings.Split(str, ",") {
+		tokens := strings.Split(strings.TrimSpace(item), "=")
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("invalid cleanup policy %s, it should be of the form '<resource>=<policy>'", item)
+		}
+		if !isValidResource(tokens[0]) {
+			return nil, fmt.Errorf("invalid resource %s, it should be one of %s", tokens[0], strings.Join(cleanupResources, ", "))
+		}
+		if !isValidCleanup(tokens[1]) {
+			return nil, fmt.Errorf("invalid cleanup policy %s, it should be %s, %s or %s", tokens[1], CleanupAlways, CleanupOnSuccess, CleanupNever)
+		}
+		policy[tokens[0]] = tokens[1]
+	}
+	return policy, nil
+}
+
+// HasCleanupPolicy checks whether a cleanup policy is explicitly set for a resource
+func HasCleanupPolicy(sysCfg *Config, resource string) bool {
+	return sysCfg != nil && sysCfg.Cleanup[resource] != ""
+}
+
+// GetCleanupPolicy returns the cleanup policy of a resource. By default, installations of MPI on
+// the host and images are never removed in persistent mode and always removed otherwise, while
+// build and scratch directories are always removed unless KeepScratch is set, in which case they
+// are kept when something failed.
+func GetCleanupPolicy(sysCfg *Config, resource string) string {
+	if HasCleanupPolicy(sysCfg, resource) {
+		return sysCfg.Cleanup[resource]
+	}
+
+	switch resource {
+	case HostMPIResource, ImageResource:
+		if IsPersistent(sysCfg) {
+			return CleanupNever
+		}
+	case BuildDirResource, ScratchDirResource:
+		if sysCfg != nil && sysCfg.KeepScratch {
+			return CleanupOnSuccess
+		}
+	}
+	return CleanupAlways
+}
+
+// ShouldCleanup checks whether a resource must be removed, based on its cleanup policy and on
+// whether the build or experiment using it failed
+func ShouldCleanup(sysCfg *Config, resource string, failed bool) bool {
+	switch GetCleanupPolicy(sysCfg, resource) {
+	case CleanupNever:
+		return false
+	case CleanupOnSuccess:
+		return !failed
+	}
+	return true
+}
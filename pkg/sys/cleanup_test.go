@@ -0,0 +1,56 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"testing"
+)
+
+func TestCleanupPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		persistent bool
+		keep       bool
+		resource   string
+		failed     bool
+		cleanup    bool
+	}{
+		{name: "default non-persistent host MPI", resource: HostMPIResource, failed: true, cleanup: true},
+		{name: "default persistent image", persistent: true, resource: ImageResource, cleanup: false},
+		{name: "default build dir", resource: BuildDirResource, failed: true, cleanup: true},
+		{name: "keep scratch on failure", keep: true, resource: ScratchDirResource, failed: true, cleanup: false},
+		{name: "keep scratch on success", keep: true, resource: ScratchDirResource, cleanup: true},
+		{name: "global policy", policy: CleanupNever, resource: BuildDirResource, cleanup: false},
+		{name: "persistent host MPI always removed", policy: "host-mpi=always", persistent: true, resource: HostMPIResource, cleanup: true},
+		{name: "image kept on failure", policy: "image=on-success,build=never", persistent: true, resource: ImageResource, failed: true, cleanup: false},
+		{name: "image removed on success", policy: "image=on-success,build=never", persistent: true, resource: ImageResource, cleanup: true},
+		{name: "unlisted resource", policy: "image=never", keep: true, resource: BuildDirResource, failed: true, cleanup: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseCleanupPolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("ParseCleanupPolicy(%s) failed: %s", tt.policy, err)
+			}
+			sysCfg := Config{Cleanup: policy, KeepScratch: tt.keep}
+			if tt.persistent {
+				sysCfg.Persistent = "/tmp/sympi"
+			}
+			if ShouldCleanup(&sysCfg, tt.resource, tt.failed) != tt.cleanup {
+				t.Fatalf("ShouldCleanup() returned %v instead of %v", !tt.cleanup, tt.cleanup)
+			}
+		})
+	}
+
+	for _, policy := range []string{"sometimes", "host-mpi", "container=never", "scratch=later"} {
+		_, err := ParseCleanupPolicy(policy)
+		if err == nil {
+			t.Fatalf("ParseCleanupPolicy() succeeded with the invalid policy %s", policy)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	// cpuInfoFile is the file describing the CPUs of the host
+	cpuInfoFile = "/proc/cpuinfo"
+
+	// x86ISABaseline is the baseline of the x86-64 CPUs, the other ones being x86-64-v2 to x86-64-v4
+	x86ISABaseline = "x86-64"
+)
+
+// x86ISALevels are the features of the microarchitecture levels of x86-64 after the baseline, as
+// named in /proc/cpuinfo; each level also requires the features of the previous ones. These are the
+// features used by code compiled with -march=<level> or -march=native.
+var x86ISALevels = []struct {
+	name     string
+	features []string
+}{
+	{"x86-64-v2", []string{"cx16", "lahf_lm", "popcnt", "sse4_1", "sse4_2", "ssse3"}},
+	{"x86-64-v3", []string{"abm", "avx", "avx2", "bmi1", "bmi2", "f16c", "fma", "movbe", "xsave"}},
+	{"x86-64-v4", []string{"avx512bw", "avx512cd", "avx512dq", "avx512f", "avx512vl"}},
+}
+
+// CPUInfo describes the CPUs of a host
+type CPUInfo struct {
+	// Arch is the architecture of the host, e.g., amd64
+	Arch string
+
+	// ISABaseline is the most recent instruction set supported by the CPUs, e.g., x86-64-v3, or the
+	// architecture when there is no level for the architecture
+	ISABaseline string
+
+	// Features are the features of the CPUs relevant to the compatibility of binaries, sorted
+	Features []string
+}
+
+// parseCPUFeatures returns the features of the CPUs from the content of /proc/cpuinfo: the 'flags' of
+// x86 CPUs or the 'Features' of ARM CPUs
+func parseCPUFeatures(content string) []string {
+	for _, line := range strings.Split(content, "\n") {
+		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(tokens[0])
+		if key == "flags" || key == "Features" {
+			return strings.Fields(tokens[1])
+		}
+	}
+	return nil
+}
+
+// getISA returns the instruction set supported by CPUs with the given features and the features
+// relevant to the compatibility of binaries, i.e., the features of the ISA levels for x86-64 and all
+// the features for the other architectures
+func getISA(arch string, features []string) (string, []string) {
+	if arch != "amd64" {
+		sorted := append([]string{}, features...)
+		sort.Strings(sorted)
+		return arch, sorted
+	}
+
+	available := make(map[string]bool)
+	for _, f := range features {
+		available[f] = true
+	}
+	baseline := x86ISABaseline
+	complete := true
+	var relevant []string
+	for _, level := range x86ISALevels {
+		for _, f := range level.features {
+			if available[f] {
+				relevant = append(relevant, f)
+			} else {
+				complete = false
+			}
+		}
+		if complete {
+			baseline = level.name
+		}
+	}
+	sort.Strings(relevant)
+	return baseline, relevant
+}
+
+// GetCPUInfo returns the description of the CPUs of the host
+func GetCPUInfo() (*CPUInfo, error) {
+	content, err := ioutil.ReadFile(cpuInfoFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", cpuInfoFile, err)
+	}
+	info := &CPUInfo{Arch: runtime.GOARCH}
+	info.ISABaseline, info.Features = getISA(info.Arch, parseCPUFeatures(string(content)))
+	return info, nil
+}
+
+// GetMissingCPUFeatures returns the features of a list that the CPUs do not have
+func (c *CPUInfo) GetMissingCPUFeatures(required []string) []string {
+	available := make(map[string]bool)
+	for _, f := range c.Features {
+		available[f] = true
+	}
+	var missing []string
+	for _, f := range required {
+		if !available[f] {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
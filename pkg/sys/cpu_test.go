@@ -0,0 +1,52 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetISA(t *testing.T) {
+	tests := []struct {
+		arch     string
+		cpuinfo  string
+		baseline string
+		features []string
+	}{
+		{
+			arch:     "amd64",
+			cpuinfo:  "processor\t: 0\nflags\t\t: fpu sse2 ssse3 cx16 sse4_1 sse4_2 popcnt lahf_lm avx\n",
+			baseline: "x86-64-v2",
+			features: []string{"avx", "cx16", "lahf_lm", "popcnt", "sse4_1", "sse4_2", "ssse3"},
+		},
+		{
+			arch:     "amd64",
+			cpuinfo:  "flags\t\t: fpu sse2 avx2 avx512f\n",
+			baseline: "x86-64",
+			features: []string{"avx2", "avx512f"},
+		},
+		{
+			arch:     "arm64",
+			cpuinfo:  "processor\t: 0\nFeatures\t: fp asimd atomics\n",
+			baseline: "arm64",
+			features: []string{"asimd", "atomics", "fp"},
+		},
+	}
+
+	for _, tt := range tests {
+		baseline, features := getISA(tt.arch, parseCPUFeatures(tt.cpuinfo))
+		if baseline != tt.baseline || strings.Join(features, ",") != strings.Join(tt.features, ",") {
+			t.Fatalf("getISA() returned %s %v instead of %s %v", baseline, features, tt.baseline, tt.features)
+		}
+	}
+
+	cpu := CPUInfo{Arch: "amd64", ISABaseline: "x86-64-v2", Features: []string{"popcnt", "sse4_2"}}
+	missing := cpu.GetMissingCPUFeatures([]string{"avx2", "popcnt", "fma"})
+	if strings.Join(missing, ",") != "avx2,fma" {
+		t.Fatalf("GetMissingCPUFeatures() returned %v instead of [avx2 fma]", missing)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// SYMPI_ETC_ENV is the name of the environment variable to set the directory with the
+	// configuration files and templates
+	SYMPI_ETC_ENV = "SYMPI_ETC"
+
+	// etcDirName is the name of the directory with the configuration files and templates
+	etcDirName = "etc"
+
+	// templateDirName is the name of the directory with the templates in the etc directory
+	templateDirName = "templates"
+
+	// systemEtcDir is the directory with the configuration files and templates when the tool is
+	// installed with a system package
+	systemEtcDir = "/usr/share/singularity-mpi"
+
+	// etcEnvOrigin is the origin of the etc directory specified with the SYMPI_ETC environment variable
+	etcEnvOrigin = SYMPI_ETC_ENV + " environment variable"
+)
+
+// EtcDirCandidate is a directory where the configuration files and templates may be
+type EtcDirCandidate struct {
+	// Path is the path to the directory
+	Path string
+
+	// Origin describes where the path comes from, e.g., the SYMPI_ETC environment variable
+	Origin string
+}
+
+// GetEtcDirCandidates returns the list of directories where the configuration files and templates
+// are looked up, by order of precedence: the SYMPI_ETC environment variable, the etc directory of the
+// sympi directory, the etc directory of the default workspace when a named workspace is used, the etc
+// directory of GOPATH (where 'make install' copies them) and the system directory
+func GetEtcDirCandidates() []EtcDirCandidate {
+	var candidates []EtcDirCandidate
+
+	if os.Getenv(SYMPI_ETC_ENV) != "" {
+		candidates = append(candidates, EtcDirCandidate{Path: os.Getenv(SYMPI_ETC_ENV), Origin: etcEnvOrigin})
+	}
+	candidates = append(candidates, EtcDirCandidate{Path: filepath.Join(GetSympiDir(), etcDirName), Origin: "sympi directory"})
+	if GetSympiDir() != GetSympiBaseDir() {
+		// Named workspaces share the etc directory of the default workspace unless they have their own
+		candidates = append(candidates, EtcDirCandidate{Path: filepath.Join(GetSympiBaseDir(), etcDirName), Origin: "default workspace"})
+	}
+	if os.Getenv("GOPATH") != "" {
+		candidates = append(candidates, EtcDirCandidate{Path: filepath.Join(os.Getenv("GOPATH"), etcDirName), Origin: "GOPATH"})
+	}
+	candidates = append(candidates, EtcDirCandidate{Path: systemEtcDir, Origin: "system directory"})
+
+	return candidates
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// FindEtcDir returns the directory with the configuration files and templates, i.e., the first
+// existing directory from GetEtcDirCandidates, as well as where it comes from. The directory
+// specified with the SYMPI_ETC environment variable must exist.
+func FindEtcDir() (EtcDirCandidate, error) {
+	candidates := GetEtcDirCandidates()
+	for _, c := range candidates {
+		if isDir(c.Path) {
+			return c, nil
+		}
+		if c.Origin == etcEnvOrigin {
+			return c, fmt.Errorf("%s is set to %s which is not a directory", SYMPI_ETC_ENV, c.Path)
+		}
+	}
+
+	return EtcDirCandidate{}, fmt.Errorf("unable to find the etc directory")
+}
+
+// GetTemplateDir returns the directory with the templates from the etc directory
+func GetTemplateDir(etcDir string) string {
+	return filepath.Join(etcDir, templateDirName)
+}
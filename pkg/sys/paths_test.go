@@ -0,0 +1,105 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindEtcDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-paths-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sympiDir := filepath.Join(dir, "sympi")
+	envDir := filepath.Join(dir, "etc")
+	err = os.MkdirAll(filepath.Join(sympiDir, etcDirName), 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", sympiDir, err)
+	}
+	err = os.MkdirAll(envDir, 0755)
+	if err != nil {
+		t.Fatalf("failed to create %s: %s", envDir, err)
+	}
+
+	defer os.Setenv(SYMPI_INSTALL_DIR_ENV, os.Getenv(SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(SYMPI_ETC_ENV, os.Getenv(SYMPI_ETC_ENV))
+	os.Setenv(SYMPI_INSTALL_DIR_ENV, sympiDir)
+
+	tests := []struct {
+		name         string
+		etcEnv       string
+		expectedPath string
+		expectedErr  bool
+	}{
+		{
+			name:         "sympi directory",
+			expectedPath: filepath.Join(sympiDir, etcDirName),
+		},
+		{
+			name:         "environment variable",
+			etcEnv:       envDir,
+			expectedPath: envDir,
+		},
+		{
+			name:        "invalid environment variable",
+			etcEnv:      filepath.Join(dir, "missing"),
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(SYMPI_ETC_ENV, tt.etcEnv)
+			c, err := FindEtcDir()
+			if tt.expectedErr {
+				if err == nil {
+					t.Fatalf("FindEtcDir() succeeded with an invalid %s", SYMPI_ETC_ENV)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindEtcDir() failed: %s", err)
+			}
+			if c.Path != tt.expectedPath {
+				t.Fatalf("FindEtcDir() returned %s (%s) instead of %s", c.Path, c.Origin, tt.expectedPath)
+			}
+		})
+	}
+}
+
+func TestGetSympiDir(t *testing.T) {
+	defer os.Setenv(SYMPI_INSTALL_DIR_ENV, os.Getenv(SYMPI_INSTALL_DIR_ENV))
+	defer os.Setenv(SYMPI_WORKSPACE_ENV, os.Getenv(SYMPI_WORKSPACE_ENV))
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get the current directory: %s", err)
+	}
+	os.Setenv(SYMPI_INSTALL_DIR_ENV, "sympi/../workspace/")
+	base := filepath.Join(cwd, "workspace")
+
+	tests := []struct {
+		workspace string
+		expected  string
+	}{
+		{workspace: "", expected: base},
+		{workspace: "gcc9", expected: filepath.Join(base, WorkspacesDirName, "gcc9")},
+		{workspace: "../../etc", expected: base},
+		{workspace: "..", expected: base},
+	}
+	for _, tt := range tests {
+		os.Setenv(SYMPI_WORKSPACE_ENV, tt.workspace)
+		dir := GetSympiDir()
+		if dir != tt.expected {
+			t.Fatalf("GetSympiDir() returned %s instead of %s with workspace %q", dir, tt.expected, tt.workspace)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CAFileEnvVar is the environment variable specifying the CA bundle of the tools written in Go,
+	// e.g., Singularity, and of the tools based on OpenSSL
+	CAFileEnvVar = "SSL_CERT_FILE"
+
+	// caBundlePrefix is the prefix of the name of the CA bundles merging the CAs of the system
+	// and the CAs of the configuration, see writeCABundle
+	caBundlePrefix = "ca-bundle-"
+)
+
+// systemCAFiles are the usual locations of the bundle of the CAs of the system, the first one
+// found is used; it is the list of the Go standard library on Linux
+var systemCAFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// proxyEnvVars are the environment variables of the proxy; both cases are set since the tools
+// we execute do not agree on which one they use
+var proxyEnvVars = []string{"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY"}
+
+// noProxyEnvVars are the environment variables of the hosts reached without proxy
+var noProxyEnvVars = []string{"no_proxy", "NO_PROXY"}
+
+// network is the network configuration of the HTTP clients of the tool, see SetNetwork
+var network struct {
+	sync.Mutex
+	rootCAs *x509.CertPool
+
+	// caFile is the CA bundle of the configuration and caBundle the bundle merging it with the
+	// CAs of the system, see GetCABundle
+	caFile   string
+	caBundle string
+}
+
+// ValidateProxy checks that a proxy is a valid URL, e.g., http://proxy.example.com:3128
+func ValidateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid proxy %s, it should be a URL, e.g., http://proxy.example.com:3128", proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return nil
+	default:
+		return fmt.Errorf("invalid proxy %s: unsupported scheme %s (http, https or socks5)", proxy, u.Scheme)
+	}
+}
+
+// ValidateCAFile checks that a file is a bundle of PEM certificates
+func ValidateCAFile(path string) error {
+	_, err := loadCAFile(path)
+	return err
+}
+
+// loadCAFile returns the certificates of a bundle of PEM certificates
+func loadCAFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("%s does not contain any PEM certificate", path)
+	}
+	return content, nil
+}
+
+// GetProxyEnv returns the environment variables of the proxy of the configuration, e.g.,
+// https_proxy=http://proxy.example.com:3128; empty when no proxy is configured
+func GetProxyEnv(cfg *Config) []string {
+	var env []string
+	if cfg.Proxy != "" {
+		for _, name := range proxyEnvVars {
+			env = append(env, name+"="+cfg.Proxy)
+		}
+	}
+	if cfg.NoProxy != "" {
+		for _, name := range noProxyEnvVars {
+			env = append(env, name+"="+cfg.NoProxy)
+		}
+	}
+	return env
+}
+
+// loadSystemCAs returns the certificates of the bundle of the CAs of the system, the one of the
+// environment of the host first
+func loadSystemCAs() ([]byte, string, error) {
+	paths := systemCAFiles
+	if os.Getenv(CAFileEnvVar) != "" {
+		paths = append([]string{os.Getenv(CAFileEnvVar)}, paths...)
+	}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err == nil {
+			return content, path, nil
+		}
+	}
+	return nil, "", fmt.Errorf("none of %s is readable", strings.Join(paths, ", "))
+}
+
+// writeCABundle writes in dir a bundle with the CAs of the system followed by the CAs of caFile
+// and returns its path. The name of the bundle depends on its content so the bundle is written
+// once and shared by concurrent executions of the tool; it is renamed once complete so it is
+// never read partially written.
+func writeCABundle(dir string, caFile string) (string, error) {
+	extraCAs, err := loadCAFile(caFile)
+	if err != nil {
+		return "", err
+	}
+	systemCAs, _, err := loadSystemCAs()
+	if err != nil {
+		return "", fmt.Errorf("failed to load the CAs of the system: %s", err)
+	}
+	content := append(append(systemCAs, '\n'), extraCAs...)
+
+	path := filepath.Join(dir, fmt.Sprintf("%s%x.pem", caBundlePrefix, sha256.Sum256(content)))
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %s", dir, err)
+	}
+	f, err := ioutil.TempFile(dir, caBundlePrefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create a temporary file in %s: %s", dir, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %s", f.Name(), err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", fmt.Errorf("failed to rename %s to %s: %s", f.Name(), path, err)
+	}
+	return path, nil
+}
+
+// GetCABundle returns the CA bundle to give to the commands reaching the network, e.g., git or
+// singularity, empty when the configuration has no CA bundle. The commands only use the bundle
+// they are given, so it is the bundle merging the CAs of the system with the CAs of the
+// configuration written by SetNetwork; the CA bundle of the configuration is returned as is
+// when it could not be merged.
+func GetCABundle(cfg *Config) string {
+	if cfg.CAFile == "" {
+		return ""
+	}
+	network.Lock()
+	defer network.Unlock()
+	if network.caBundle != "" && network.caFile == cfg.CAFile {
+		return network.caBundle
+	}
+	return cfg.CAFile
+}
+
+// GetCAEnv returns the environment variable of the CA bundle of the configuration (see
+// GetCABundle), empty when there is none. It is only set for the commands reaching registries,
+// e.g., singularity pull.
+func GetCAEnv(cfg *Config) []string {
+	bundle := GetCABundle(cfg)
+	if bundle == "" {
+		return nil
+	}
+	return []string{CAFileEnvVar + "=" + bundle}
+}
+
+// GetNetworkEnvNames returns the names of the environment variables of the network configuration,
+// to preserve them when commands are executed with sudo or in a sandboxed environment
+func GetNetworkEnvNames(cfg *Config) []string {
+	var names []string
+	if cfg.Proxy != "" {
+		names = append(names, proxyEnvVars...)
+	}
+	if cfg.NoProxy != "" {
+		names = append(names, noProxyEnvVars...)
+	}
+	if cfg.CAFile != "" {
+		names = append(names, CAFileEnvVar)
+	}
+	return names
+}
+
+// SetNetwork applies the network configuration to the tool: the proxy is set in the environment,
+// so all the commands executed from now use it, and the CA bundle is added to the CAs of the
+// system for the HTTP clients of the tool (see NewHTTPClient) and for the commands (see
+// GetCABundle). The proxy of the environment of the host is used when no proxy is configured.
+func SetNetwork(cfg *Config) error {
+	network.Lock()
+	defer network.Unlock()
+
+	for _, e := range GetProxyEnv(cfg) {
+		tokens := strings.SplitN(e, "=", 2)
+		err := os.Setenv(tokens[0], tokens[1])
+		if err != nil {
+			return fmt.Errorf("failed to set %s: %s", tokens[0], err)
+		}
+	}
+
+	network.rootCAs = nil
+	network.caFile = ""
+	network.caBundle = ""
+	if cfg.CAFile == "" {
+		return nil
+	}
+	content, err := loadCAFile(cfg.CAFile)
+	if err != nil {
+		return err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Printf("[WARN] failed to load the CAs of the system: %s, only using %s", err, cfg.CAFile)
+		pool = x509.NewCertPool()
+	}
+	pool.AppendCertsFromPEM(content)
+	network.rootCAs = pool
+
+	bundle, err := writeCABundle(GetSympiBaseDir(), cfg.CAFile)
+	if err != nil {
+		log.Printf("[WARN] failed to merge %s with the CAs of the system: %s, the commands only trust the CAs of %s", cfg.CAFile, err, cfg.CAFile)
+		return nil
+	}
+	network.caFile = cfg.CAFile
+	network.caBundle = bundle
+	return nil
+}
+
+// NewHTTPClient returns a HTTP client using the network configuration of the tool: the proxy of
+// the environment and the CA bundle, if any
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	network.Lock()
+	defer network.Unlock()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if network.rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: network.rootCAs}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Registry gathers the details of a named registry of the tool's configuration file, including
+// how to get the credentials used to log in before pulling or pushing images
+type Registry struct {
+	// Name is the name of the registry, e.g., ghcr
+	Name string
+
+	// URL is the URL of the registry, e.g., oras://ghcr.io/user; images whose reference starts
+	// with it are pulled or pushed after logging in the registry
+	URL string
+
+	// Remote is the name of the Singularity remote endpoint serving a library:// registry, e.g.,
+	// SylabsCloud; the default remote endpoint is used when it is not set
+	Remote string
+
+	// Username is the name of the user, UsernameEnv being the name of an environment variable
+	// giving it instead
+	Username    string
+	UsernameEnv string
+
+	// TokenFile is the path to a file storing the token or password, TokenEnv being the name of
+	// an environment variable giving it instead
+	TokenFile string
+	TokenEnv  string
+}
+
+// HasCredentials checks whether credentials are configured for the registry; without credentials,
+// the authentication already configured with the container runtime is used
+func (r *Registry) HasCredentials() bool {
+	return r.TokenFile != "" || r.TokenEnv != ""
+}
+
+// GetCredentials returns the name of the user, possibly empty, and the token used to log in
+func (r *Registry) GetCredentials() (string, string, error) {
+	username := r.Username
+	if r.UsernameEnv != "" {
+		username = os.Getenv(r.UsernameEnv)
+		if username == "" {
+			return "", "", fmt.Errorf("%s is not set, unable to get the user for registry %s", r.UsernameEnv, r.Name)
+		}
+	}
+
+	var token string
+	switch {
+	case r.TokenEnv != "":
+		token = os.Getenv(r.TokenEnv)
+		if token == "" {
+			return "", "", fmt.Errorf("%s is not set, unable to get the token for registry %s", r.TokenEnv, r.Name)
+		}
+	case r.TokenFile != "":
+		data, err := ioutil.ReadFile(r.TokenFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read the token for registry %s: %s", r.Name, err)
+		}
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			return "", "", fmt.Errorf("%s is empty, unable to get the token for registry %s", r.TokenFile, r.Name)
+		}
+	default:
+		return "", "", fmt.Errorf("no credentials configured for registry %s", r.Name)
+	}
+
+	return username, token, nil
+}
+
+// FindRegistry returns the registry of an image reference, i.e., the registry with the longest URL
+// that is a prefix of the reference; nil is returned when no registry matches
+func FindRegistry(registries map[string]Registry, ref string) *Registry {
+	var found *Registry
+	for name := range registries {
+		r := registries[name]
+		url := strings.TrimSuffix(r.URL, "/")
+		if url == "" || (ref != url && !strings.HasPrefix(ref, url+"/") && !strings.HasPrefix(ref, url+":")) {
+			continue
+		}
+		if found == nil || len(r.URL) > len(found.URL) {
+			found = &r
+		}
+	}
+	return found
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sympi-registry-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", tokenFile, err)
+	}
+
+	registries := map[string]Registry{
+		"ghcr":    {Name: "ghcr", URL: "oras://ghcr.io/", Username: "user", TokenFile: tokenFile},
+		"team":    {Name: "team", URL: "oras://ghcr.io/team", UsernameEnv: "SYMPI_TEST_REGISTRY_USER", TokenEnv: "SYMPI_TEST_REGISTRY_TOKEN"},
+		"library": {Name: "library", URL: "library://user"},
+	}
+	tests := []struct {
+		ref      string
+		registry string
+	}{
+		{ref: "oras://ghcr.io/user/app:1.0", registry: "ghcr"},
+		{ref: "oras://ghcr.io/team/app:1.0", registry: "team"},
+		{ref: "oras://ghcr.io/teamwork/app:1.0", registry: "ghcr"},
+		{ref: "library://user/app:latest", registry: "library"},
+		{ref: "docker://ubuntu:20.04", registry: ""},
+	}
+	for _, tt := range tests {
+		r := FindRegistry(registries, tt.ref)
+		if (r == nil && tt.registry != "") || (r != nil && r.Name != tt.registry) {
+			t.Fatalf("FindRegistry(%s) returned %v instead of %s", tt.ref, r, tt.registry)
+		}
+	}
+
+	r := registries["ghcr"]
+	user, token, err := r.GetCredentials()
+	if err != nil || user != "user" || token != "secret" {
+		t.Fatalf("GetCredentials() returned %s, %s: %v", user, token, err)
+	}
+	r = registries["team"]
+	_, _, err = r.GetCredentials()
+	if err == nil {
+		t.Fatalf("GetCredentials() succeeded while the environment variables are not set")
+	}
+	r = registries["library"]
+	if r.HasCredentials() {
+		t.Fatalf("registry %s has credentials", r.Name)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RuntimeSingularity is the name of the Singularity container runtime
+	RuntimeSingularity = "singularity"
+
+	// RuntimeApptainer is the name of the Apptainer container runtime, the successor of Singularity
+	RuntimeApptainer = "apptainer"
+
+	// singularityEnvPrefix is the prefix of the environment variables Singularity passes to containers
+	singularityEnvPrefix = "SINGULARITYENV_"
+
+	// apptainerEnvPrefix is the prefix of the environment variables Apptainer passes to containers
+	apptainerEnvPrefix = "APPTAINERENV_"
+
+	// RuntimeModeNative is the runtime mode where containers are executed by the native runtime of
+	// Singularity (default)
+	RuntimeModeNative = "native"
+
+	// RuntimeModeOCI is the runtime mode where containers are executed by the OCI runtime of
+	// Singularity, i.e., singularity exec --oci, which has different mount and environment semantics
+	RuntimeModeOCI = "oci"
+
+	// RuntimeModeBoth executes the containers in both runtime modes, e.g., to validate them
+	RuntimeModeBoth = "both"
+
+	// ociModeFlag is the flag of the Singularity commands selecting the OCI runtime mode
+	ociModeFlag = "--oci"
+)
+
+// runtimeVersionRegexp matches the version in the output of 'singularity version', 'singularity --version'
+// or 'apptainer --version', e.g., 'singularity-ce version 3.9.4' or '1.1.0-1.el8'
+var runtimeVersionRegexp = regexp.MustCompile(`([0-9]+\.[0-9]+(\.[0-9]+)?)`)
+
+// ValidateContainerRuntime checks whether a container runtime is supported
+func ValidateContainerRuntime(runtime string) error {
+	if runtime != RuntimeSingularity && runtime != RuntimeApptainer {
+		return fmt.Errorf("invalid container runtime %s, it should be %s or %s", runtime, RuntimeSingularity, RuntimeApptainer)
+	}
+	return nil
+}
+
+// FindContainerRuntime returns the path to the binary of a container runtime. When no runtime is
+// specified, singularity is looked up first and apptainer second.
+func FindContainerRuntime(runtime string) (string, error) {
+	candidates := []string{RuntimeSingularity, RuntimeApptainer}
+	if runtime != "" {
+		err := ValidateContainerRuntime(runtime)
+		if err != nil {
+			return "", err
+		}
+		candidates = []string{runtime}
+	}
+
+	for _, c := range candidates {
+		path, err := exec.LookPath(c)
+		if err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found", strings.Join(candidates, " or "))
+}
+
+// GetContainerRuntime returns the container runtime of a binary, e.g., /usr/bin/apptainer; Singularity
+// is assumed for any other binary, including the singularity compatibility link of Apptainer
+func GetContainerRuntime(bin string) string {
+	if filepath.Base(bin) == RuntimeApptainer {
+		return RuntimeApptainer
+	}
+	return RuntimeSingularity
+}
+
+// GetContainerEnvPrefix returns the prefix of the environment variables passed to the containers
+// by the container runtime of a binary, i.e., SINGULARITYENV_ or APPTAINERENV_
+func GetContainerEnvPrefix(bin string) string {
+	if GetContainerRuntime(bin) == RuntimeApptainer {
+		return apptainerEnvPrefix
+	}
+	return singularityEnvPrefix
+}
+
+// ContainerEnv returns the definition of an environment variable set in the containers started
+// by the container runtime of the configuration, e.g., APPTAINERENV_OPAL_PREFIX=/opt/openmpi
+func (c *Config) ContainerEnv(name string, value string) string {
+	return GetContainerEnvPrefix(c.SingularityBin) + name + "=" + value
+}
+
+// ValidateRuntimeMode checks whether a runtime mode is valid, i.e., native or oci
+func ValidateRuntimeMode(mode string) error {
+	if mode != RuntimeModeNative && mode != RuntimeModeOCI {
+		return fmt.Errorf("invalid runtime mode %s, it should be %s or %s", mode, RuntimeModeNative, RuntimeModeOCI)
+	}
+	return nil
+}
+
+// GetRuntimeModes returns the runtime modes the containers are executed with for a runtime mode
+// given by users, e.g., native and oci for both; the native mode is used when empty
+func GetRuntimeModes(mode string) []string {
+	switch mode {
+	case "":
+		return []string{RuntimeModeNative}
+	case RuntimeModeBoth:
+		return []string{RuntimeModeNative, RuntimeModeOCI}
+	}
+	return []string{mode}
+}
+
+// IsOCIMode checks whether the containers are executed by the OCI runtime of Singularity
+func (c *Config) IsOCIMode() bool {
+	return c.RuntimeMode == RuntimeModeOCI
+}
+
+// GetRuntimeModeArgs returns the arguments of 'singularity exec' selecting the runtime mode of
+// the configuration, e.g., --oci, none for the native mode
+func (c *Config) GetRuntimeModeArgs() []string {
+	if c.IsOCIMode() {
+		return []string{ociModeFlag}
+	}
+	return nil
+}
+
+// ParseRuntimeVersion returns the version number from the output of the version command of
+// Singularity or Apptainer, e.g., 3.5.2 from 'singularity version 3.5.2-1.el7'
+func ParseRuntimeVersion(output string) string {
+	return runtimeVersionRegexp.FindString(output)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"testing"
+)
+
+func TestContainerRuntime(t *testing.T) {
+	tests := []struct {
+		bin       string
+		output    string
+		runtime   string
+		envPrefix string
+		version   string
+	}{
+		{
+			bin:       "/usr/local/bin/singularity",
+			output:    "3.5.2\n",
+			runtime:   RuntimeSingularity,
+			envPrefix: "SINGULARITYENV_",
+			version:   "3.5.2",
+		},
+		{
+			bin:       "/usr/bin/singularity",
+			output:    "singularity-ce version 3.9.4-focal\n",
+			runtime:   RuntimeSingularity,
+			envPrefix: "SINGULARITYENV_",
+			version:   "3.9.4",
+		},
+		{
+			bin:       "/usr/bin/apptainer",
+			output:    "apptainer version 1.1.0-1.el8\n",
+			runtime:   RuntimeApptainer,
+			envPrefix: "APPTAINERENV_",
+			version:   "1.1.0",
+		},
+		{
+			bin:       "",
+			output:    "",
+			runtime:   RuntimeSingularity,
+			envPrefix: "SINGULARITYENV_",
+			version:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		if GetContainerRuntime(tt.bin) != tt.runtime {
+			t.Fatalf("runtime of %s is %s instead of %s", tt.bin, GetContainerRuntime(tt.bin), tt.runtime)
+		}
+		cfg := Config{SingularityBin: tt.bin}
+		env := cfg.ContainerEnv("OPAL_PREFIX", "/opt/mpi")
+		if env != tt.envPrefix+"OPAL_PREFIX=/opt/mpi" {
+			t.Fatalf("environment variable for %s is %s", tt.bin, env)
+		}
+		if ParseRuntimeVersion(tt.output) != tt.version {
+			t.Fatalf("version from %q is %s instead of %s", tt.output, ParseRuntimeVersion(tt.output), tt.version)
+		}
+	}
+
+	if ValidateContainerRuntime("docker") == nil {
+		t.Fatalf("docker was accepted as container runtime")
+	}
+}
@@ -6,11 +6,14 @@
 package sys
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"time"
 )
 
 const (
@@ -22,9 +25,23 @@ const (
 	// image containers and install MPI
 	DefaultSympiInstallDir = ".sympi"
 
+	// SYMPI_WORKSPACE_ENV is the name of the environment variable to select a named workspace
+	SYMPI_WORKSPACE_ENV = "SYMPI_WORKSPACE"
+
+	// DefaultWorkspace is the name of the default workspace, i.e., the sympi directory itself
+	DefaultWorkspace = "default"
+
+	// WorkspacesDirName is the name of the directory of the sympi directory where the named
+	// workspaces are stored
+	WorkspacesDirName = "workspaces"
+
 	// CmdTimeout is the maximum time we allow a command to run
 	CmdTimeout = 30
 
+	// HeartbeatInterval is the default time without output after which a message is logged to
+	// show that a command with streamed output is still running
+	HeartbeatInterval = 5 * time.Minute
+
 	// DefaultUbuntuDistro is the default Ubuntu distribution we use
 	DefaultUbuntuDistro = "disco"
 
@@ -46,6 +63,13 @@ const (
 	// ContainerInstallDirPrefix is the default prefix for the directory name where an MPI-based container is stored
 	ContainerInstallDirPrefix = "mpi_container_"
 
+	// BuildCacheDir is the name of the directory in the sympi directory where cached base images are stored
+	BuildCacheDir = "build_cache"
+
+	// BaseImagesDir is the name of the directory in the sympi directory where the base images of
+	// the quick build strategy, one per Linux distribution and MPI, are stored
+	BaseImagesDir = "base_images"
+
 	confFilePrefix = "sympi_"
 )
 
@@ -76,6 +100,9 @@ type Config struct {
 	// EtcDir is the path to the directory with the configuration files
 	EtcDir string
 
+	// EtcDirOrigin describes where the path to the directory with the configuration files comes from
+	EtcDirOrigin string
+
 	// TemplateDir is the path where the template are
 	TemplateDir string
 
@@ -85,9 +112,13 @@ type Config struct {
 	// SedBin is the path to the sed binary
 	SedBin string
 
-	// SingularityBin is the path to the singularity binary
+	// SingularityBin is the path to the binary of the container runtime, i.e., singularity or apptainer
 	SingularityBin string
 
+	// ContainerRuntime is the container runtime to use, i.e., singularity or apptainer; singularity
+	// is looked up first and apptainer second when empty
+	ContainerRuntime string
+
 	// OutputFile is the path the output file
 	OutputFile string
 
@@ -136,21 +167,261 @@ type Config struct {
 	// Nopriv specifies whether we need to use the '-u' option when running singularity
 	Nopriv bool
 
-	// SudoSyCmds is the list of Singularity commands that need to be executed with sudo
+	// SudoSyCmds is the list of Singularity commands that need to be executed with sudo, loaded
+	// from the legacy singularity_sudo_cmds key; the sudo policies take precedence.
+	//
+	// Deprecated: use SudoPolicies and sy.UseSudo.
 	SudoSyCmds []string
 
+	// SudoPolicies are the sudo policies of the operations, e.g., build, specified in the tool's
+	// configuration file; use sy.UseSudo to know whether an operation needs sudo
+	SudoPolicies map[string]string
+
 	// SudoBin is the path to sudo on the host
 	SudoBin string
+
+	// KeepScratch specifies whether the scratch and build directories must be kept when a build fails
+	KeepScratch bool
+
+	// Cleanup specifies, for each type of resource, what to do with it once it is not needed anymore,
+	// e.g., keep the build directories when something failed; resources that are not listed follow
+	// the default policy (see GetCleanupPolicy)
+	Cleanup CleanupPolicy
+
+	// ArtifactsMaxSize is the maximum size, in bytes, of the build and scratch directories archived
+	// in the errors directory when an experiment fails. Directories are not archived when set to 0.
+	ArtifactsMaxSize int64
+
+	// LaunchTemplate is the template of the command used to start MPI jobs, e.g.,
+	// '/path/to/wrapper {np} {hostfile} {cmd}'; the default of the MPI implementation is used when empty
+	LaunchTemplate string
+
+	// Hostfile and Rankfile are the paths to the hostfile and the rankfile of the MPI jobs, e.g., to
+	// reproduce a failure specific to a site; the launcher of MPI decides where the ranks are
+	// executed when empty
+	Hostfile string
+	Rankfile string
+
+	// DownloadRateLimit is the maximum bandwidth used to download software, e.g., 10m; there is
+	// no limit when empty
+	DownloadRateLimit string
+
+	// Proxy is the proxy of all the network operations, e.g., http://proxy.example.com:3128, and
+	// NoProxy the comma-separated list of the hosts reached without proxy; the proxy of the
+	// environment of the host is used when empty (see SetNetwork)
+	Proxy   string
+	NoProxy string
+
+	// CAFile is the bundle of PEM certificates of the CAs trusted by the network operations in
+	// addition to the CAs of the system, e.g., the CA of a proxy inspecting TLS traffic
+	CAFile string
+
+	// SignKeyFingerprint is the fingerprint of the key used to sign images; the key index from the
+	// environment is used when empty
+	SignKeyFingerprint string
+
+	// VerifyPolicy specifies what to do when the signature of an image cannot be verified before
+	// running it
+	VerifyPolicy string
+
+	// ISAPolicy specifies what to do when the CPUs of the host lack features of the CPUs of the host
+	// where a container was built; a warning is logged when empty
+	ISAPolicy string
+
+	// Toolchain is the toolchain building MPI and the applications, e.g., toolchain.NVHPC; the
+	// compilers of the Linux distribution are used when empty
+	Toolchain string
+
+	// ToolchainDir is the directory where the toolchain is installed
+	ToolchainDir string
+
+	// BuildProfiles are the named build profiles of the tool's configuration file
+	BuildProfiles map[string]BuildProfile
+
+	// BuildProfile is the name of the selected build profile, empty when the default settings of
+	// the tool are used
+	BuildProfile string
+
+	// Registries are the named registries of the tool's configuration file, with their credentials
+	Registries map[string]Registry
+
+	// Tuning are the tuning files of the site, per implementation of MPI, injected in the
+	// containers and used by mpirun
+	Tuning map[string]TuningProfile
+
+	// BuilderImage is the path or the URL of the image of the builder container in which the
+	// software is built for the host, e.g., MPI, and mpirun executed (tool-in-container mode); the
+	// host is used directly when empty
+	BuilderImage string
+
+	// SandboxEnv specifies whether the commands are executed with a minimal environment instead of
+	// the environment of the host (see syexec.SetSandbox)
+	SandboxEnv bool
+
+	// EnvAllowlist is the list of the extra host environment variables passed to the commands when
+	// SandboxEnv is set, e.g., http_proxy
+	EnvAllowlist []string
+
+	// MakeJobs, BuildNice, BuildIONice, BuildMemoryMax and BuildCPUQuota are the resource limits
+	// of the builds, e.g., to share login nodes (see syexec.Limits)
+	MakeJobs       int
+	BuildNice      int
+	BuildIONice    int
+	BuildMemoryMax string
+	BuildCPUQuota  string
+
+	// Heartbeat is the time without output after which a message is logged to show that a build
+	// with streamed output is still running, sys.HeartbeatInterval when 0
+	Heartbeat time.Duration
+
+	// ScratchGC is the policy applied to the abandoned scratch directories when the tool starts and
+	// ScratchMaxAge the age from which they are abandoned (see buildenv.CollectScratch)
+	ScratchGC     string
+	ScratchMaxAge time.Duration
+
+	// ScratchRoot is the directory on fast local storage where the scratch and build directories
+	// are created, when it has at least ScratchMinFree bytes available (see buildenv.GetScratchBase)
+	ScratchRoot    string
+	ScratchMinFree int64
+
+	// ContainerNameTemplate is the template used to name the images of containers, e.g., '{app}-{mpi}-{version}';
+	// the template from the application's configuration file or the default name is used when empty
+	ContainerNameTemplate string
+
+	// OutputDir is the directory where the images of containers are created; the install directory
+	// is used when empty
+	OutputDir string
+
+	// MirrorHostMPI is the installation directory of a MPI on the host whose configuration is
+	// mirrored when building MPI in containers, e.g., threading level or CUDA support
+	MirrorHostMPI string
+
+	// ContainerMPIPrefix is the directory where MPI is installed, or mounted with the bind model, in
+	// the containers created by the tool, e.g., /usr/local or /opt/{mpi}-{version}; the directory of
+	// the installation of MPI on the host is used when empty
+	ContainerMPIPrefix string
+
+	// ThreadLevel is the thread level MPI must provide when it is built, e.g., multiple; the default
+	// thread level of the implementation is used when empty
+	ThreadLevel string
+
+	// TagPolicy is the comma-separated list of the tags given to an image when it is uploaded,
+	// e.g., 'semver,latest'; the tag policy from the application's configuration file or the date
+	// is used when empty
+	TagPolicy string
+
+	// ResolveBaseImage specifies whether the current digest of the base image of containers is
+	// looked up and pinned in their definition file
+	ResolveBaseImage bool
+
+	// Dockerfile specifies whether a Dockerfile equivalent to the definition file of containers is
+	// generated alongside their image
+	Dockerfile bool
+
+	// BaseImageDigest is the digest the base image of containers is pinned to, e.g., sha256:<hex>;
+	// the digest from the application's configuration file is used when empty
+	BaseImageDigest string
+
+	// RuntimeMode is the runtime mode of Singularity the containers are executed with, i.e.,
+	// RuntimeModeNative or RuntimeModeOCI; the native mode is used when empty
+	RuntimeMode string
+
+	// QuickBuild specifies whether containers are built with the quick build strategy, i.e., on top
+	// of a pre-built base image with MPI, whatever the strategy of the application's configuration file
+	QuickBuild bool
+
+	// BaseImageAutobuild specifies whether the base images of the quick build strategy that are not
+	// available are built, instead of failing
+	BaseImageAutobuild bool
+
+	// GlibcSkewPolicy specifies what to do when the versions of glibc on the host and in the container
+	// differ by more than GlibcMaxSkew minor versions: warn or skip the experiment; the versions
+	// of glibc are not checked when empty
+	GlibcSkewPolicy string
+
+	// GlibcMaxSkew is the maximum number of minor versions between the glibc of the host and of the
+	// container, e.g., 2.31 and 2.27 differ by 4
+	GlibcMaxSkew int
+
+	// Wrapper is the command executing each rank of the jobs, e.g., valgrind, strace or perf for
+	// the predefined wrappers, or a custom command; the ranks are executed directly when empty
+	Wrapper string
+
+	// CheckpointDelay is the time after which the jobs are checkpointed with the checkpointing
+	// tool of the container, then terminated and restarted from their checkpoint; the jobs are
+	// executed without checkpoint/restart when 0
+	CheckpointDelay time.Duration
+
+	// FeatureProbes specifies whether the MPI feature probes (one-sided communications, derived
+	// datatypes, MPI-IO and threads) are executed in the containers once their application
+	// succeeded, for all the experiments; they are opt-in since they start another job
+	FeatureProbes bool
+}
+
+var workspaceNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidateWorkspaceName checks whether a name can be used for a workspace, e.g., gcc9; the name
+// being a directory of the sympi directory, it cannot include '/' or be '..'
+func ValidateWorkspaceName(name string) error {
+	if !workspaceNameRegexp.MatchString(name) || name == ".." {
+		return fmt.Errorf("invalid workspace name '%s', only letters, digits, '_', '.' and '-' can be used", name)
+	}
+	return nil
+}
+
+// GetSympiBaseDir returns the directory of the default workspace, which also stores the named
+// workspaces, as an absolute and clean path
+func GetSympiBaseDir() string {
+	dir := filepath.Join(os.Getenv("HOME"), DefaultSympiInstallDir)
+	if os.Getenv(SYMPI_INSTALL_DIR_ENV) != "" {
+		dir = os.Getenv(SYMPI_INSTALL_DIR_ENV)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.Clean(dir)
+	}
+	return absDir
+}
+
+// GetWorkspace returns the name of the current workspace, selected with the SYMPI_WORKSPACE
+// environment variable; the default workspace is used when the name is invalid
+func GetWorkspace() string {
+	name := os.Getenv(SYMPI_WORKSPACE_ENV)
+	if name == "" {
+		return DefaultWorkspace
+	}
+	err := ValidateWorkspaceName(name)
+	if err != nil {
+		log.Printf("[WARN] %s: %s, using the default workspace\n", SYMPI_WORKSPACE_ENV, err)
+		return DefaultWorkspace
+	}
+	return name
+}
+
+// GetWorkspaceDir returns the directory of a workspace
+func GetWorkspaceDir(name string) string {
+	if name == "" || name == DefaultWorkspace || ValidateWorkspaceName(name) != nil {
+		return GetSympiBaseDir()
+	}
+	return filepath.Join(GetSympiBaseDir(), WorkspacesDirName, name)
 }
 
 // GetSympiDir returns the directory where MPI is installed and container images
-// stored
+// stored, i.e., the directory of the current workspace
 func GetSympiDir() string {
-	if os.Getenv(SYMPI_INSTALL_DIR_ENV) != "" {
-		return os.Getenv(SYMPI_INSTALL_DIR_ENV)
-	} else {
-		return filepath.Join(os.Getenv("HOME"), DefaultSympiInstallDir)
+	return GetWorkspaceDir(GetWorkspace())
+}
+
+// ParseEnvAllowlist parses a comma-separated list of environment variables, e.g., http_proxy,https_proxy
+func ParseEnvAllowlist(str string) []string {
+	var names []string
+	for _, name := range strings.Split(str, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
 	}
+	return names
 }
 
 // ParseDistroID parses the string we use to identify a specific distro into a distribution name and its version
@@ -206,7 +477,10 @@ func GetMPIConfigFileName(mpi string) string {
 		return confFilePrefix + "mpich.conf"
 	case "intel":
 		return confFilePrefix + "intel.conf"
-	default:
+	case "":
 		return ""
+	default:
+		// Implementations of MPI registered out of tree follow the same naming scheme
+		return confFilePrefix + mpi + ".conf"
 	}
 }
@@ -0,0 +1,127 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// ContainerTuningDir is the directory of the containers where the tuning files of the site are
+	// copied, in a sub-directory per implementation of MPI, e.g., /etc/sympi/tuning/openmpi
+	ContainerTuningDir = "/etc/sympi/tuning"
+)
+
+// TuningProfile is a tuning file maintained by the site for an implementation of MPI, e.g., the
+// openmpi-mca-params.conf of the cluster or an Intel MPI tuning file
+type TuningProfile struct {
+	// MPI is the identifier of the implementation of MPI the file tunes, e.g., openmpi
+	MPI string
+
+	// File is the path to the tuning file on the host
+	File string
+
+	// Name is the name of the profile reported in the results, the name of the file without its
+	// extension by default, e.g., mca-params
+	Name string
+
+	// Digest is the digest of the content of the tuning file, e.g., sha256:<hex>, so containers
+	// built with a previous version of the file can be detected
+	Digest string
+}
+
+// ContainerPath returns the path to the tuning file in the containers
+func (p *TuningProfile) ContainerPath() string {
+	return filepath.Join(ContainerTuningDir, p.MPI, filepath.Base(p.File))
+}
+
+// Label returns the tuning profile as recorded in the labels of the images, i.e., its name and the
+// digest of its file, e.g., mca-params@sha256:<hex>
+func (p *TuningProfile) Label() string {
+	if p.Digest == "" {
+		return p.Name
+	}
+	return p.Name + "@" + p.Digest
+}
+
+// ParseTuningLabel returns the name and the digest of the tuning profile recorded in the labels
+// of an image, e.g., mca-params@sha256:<hex>; the digest is empty when the label has none
+func ParseTuningLabel(label string) (string, string) {
+	tokens := strings.SplitN(strings.TrimSpace(label), "@", 2)
+	if len(tokens) == 1 {
+		return tokens[0], ""
+	}
+	return tokens[0], tokens[1]
+}
+
+// GetTuningFileDigest returns the digest of the content of a tuning file, e.g., sha256:<hex>
+func GetTuningFileDigest(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// ValidateTuningProfileName checks that the name of a tuning profile can be recorded in the
+// result files, i.e., that it is not empty and has no white space
+func ValidateTuningProfileName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n,") {
+		return fmt.Errorf("invalid tuning profile name '%s', it cannot be empty nor include white spaces or commas", name)
+	}
+	return nil
+}
+
+// GetTuningProfile returns the tuning profile of the site for an implementation of MPI, nil when
+// the site has none
+func GetTuningProfile(cfg *Config, mpiID string) *TuningProfile {
+	if cfg == nil {
+		return nil
+	}
+	p, ok := cfg.Tuning[mpiID]
+	if !ok {
+		return nil
+	}
+	return &p
+}
+
+// GetTuningProfileNames returns the names of the tuning profiles active with a set of
+// implementations of MPI, e.g., the MPI of the host and the MPI of the container, as recorded in
+// the results: sorted and separated by commas, empty when none is active
+func GetTuningProfileNames(cfg *Config, mpiIDs ...string) string {
+	var names []string
+	for _, id := range mpiIDs {
+		p := GetTuningProfile(cfg, id)
+		if p != nil {
+			names = append(names, p.Name)
+		}
+	}
+	return JoinTuningProfileNames(names...)
+}
+
+// JoinTuningProfileNames returns names of tuning profiles as recorded in the results: without
+// duplicates, sorted and separated by commas, empty when there is none
+func JoinTuningProfileNames(names ...string) string {
+	set := make(map[string]bool)
+	for _, n := range names {
+		if n != "" {
+			set[n] = true
+		}
+	}
+	var list []string
+	for n := range set {
+		list = append(list, n)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
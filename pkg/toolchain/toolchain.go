@@ -0,0 +1,149 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package toolchain
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// GCC is the identifier of the GNU compilers of the Linux distribution, used by default
+	GCC = "gcc"
+
+	// ArmClang is the identifier of the Arm Compiler for Linux (armclang, armclang++ and armflang)
+	ArmClang = "armclang"
+
+	// NVHPC is the identifier of the compilers of the NVIDIA HPC SDK (nvc, nvc++ and nvfortran)
+	NVHPC = "nvhpc"
+
+	// DefaultToolchain is the toolchain used when none is specified
+	DefaultToolchain = GCC
+)
+
+// Toolchain describes the compilers used to build MPI and the applications, on the host and in
+// the containers
+type Toolchain struct {
+	// Name is the identifier of the toolchain, e.g., NVHPC
+	Name string
+
+	// Root is the directory where the toolchain is installed, e.g., /opt/nvidia/hpc_sdk/Linux_aarch64/23.11;
+	// empty for the compilers of the Linux distribution
+	Root string
+
+	// CC, CXX and FC are the C, C++ and Fortran compilers
+	CC  string
+	CXX string
+	FC  string
+
+	// Flags are the flags required to build MPI with the toolchain, used for all the languages
+	Flags []string
+
+	// BinDir and LibDir are the directories of the compilers and of their runtime libraries,
+	// relative to Root
+	BinDir string
+	LibDir string
+}
+
+// GetToolchains returns the identifiers of the supported toolchains
+func GetToolchains() []string {
+	return []string{GCC, ArmClang, NVHPC}
+}
+
+// Validate checks whether a toolchain is supported
+func Validate(name string) error {
+	for _, t := range GetToolchains() {
+		if name == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported toolchain %s, supported toolchains: %s", name, strings.Join(GetToolchains(), ", "))
+}
+
+// Get returns the description of a toolchain installed in a directory; the directory is required
+// for the vendor toolchains, which are not installed in a standard location
+func Get(name string, root string) (*Toolchain, error) {
+	switch name {
+	case GCC, "":
+		return &Toolchain{Name: GCC, CC: "gcc", CXX: "g++", FC: "gfortran"}, nil
+	case ArmClang:
+		if root == "" {
+			return nil, fmt.Errorf("the installation directory of %s is required, e.g., /opt/arm/arm-linux-compiler-22.0.2_Ubuntu-20.04", name)
+		}
+		return &Toolchain{
+			Name:   ArmClang,
+			Root:   root,
+			CC:     "armclang",
+			CXX:    "armclang++",
+			FC:     "armflang",
+			BinDir: "bin",
+			LibDir: "lib",
+		}, nil
+	case NVHPC:
+		if root == "" {
+			return nil, fmt.Errorf("the installation directory of %s is required, e.g., /opt/nvidia/hpc_sdk/Linux_x86_64/23.11", name)
+		}
+		// The libraries of MPI are linked with the runtime of the compilers, which is only
+		// position-independent with -fPIC
+		return &Toolchain{
+			Name:   NVHPC,
+			Root:   root,
+			CC:     "nvc",
+			CXX:    "nvc++",
+			FC:     "nvfortran",
+			Flags:  []string{"-fPIC"},
+			BinDir: "compilers/bin",
+			LibDir: "compilers/lib",
+		}, nil
+	}
+	return nil, Validate(name)
+}
+
+// IsDefault checks whether a toolchain is the one of the Linux distribution, which does not
+// require any specific environment
+func (t *Toolchain) IsDefault() bool {
+	return t.Root == ""
+}
+
+// GetBinPath returns the path to the directory of the compilers, empty for the default toolchain
+func (t *Toolchain) GetBinPath() string {
+	if t.IsDefault() {
+		return ""
+	}
+	return filepath.Join(t.Root, t.BinDir)
+}
+
+// GetLibPath returns the path to the directory of the runtime libraries of the compilers, empty
+// for the default toolchain
+func (t *Toolchain) GetLibPath() string {
+	if t.IsDefault() {
+		return ""
+	}
+	return filepath.Join(t.Root, t.LibDir)
+}
+
+// GetVars returns the variables selecting the compilers and their flags, as used by configure,
+// e.g., CC=nvc
+func (t *Toolchain) GetVars() []string {
+	vars := []string{"CC=" + t.CC, "CXX=" + t.CXX, "FC=" + t.FC}
+	if len(t.Flags) > 0 {
+		flags := strings.Join(t.Flags, " ")
+		vars = append(vars, "CFLAGS="+flags, "CXXFLAGS="+flags, "FCFLAGS="+flags)
+	}
+	return vars
+}
+
+// GetEnv returns the environment variables to build with the toolchain: the variables of GetVars
+// followed by PATH and LD_LIBRARY_PATH, the directories of the toolchain being added in front of
+// the given paths, e.g., $PATH
+func (t *Toolchain) GetEnv(path string, ldPath string) []string {
+	env := t.GetVars()
+	if t.IsDefault() {
+		return env
+	}
+	return append(env, "PATH="+t.GetBinPath()+":"+path, "LD_LIBRARY_PATH="+t.GetLibPath()+":"+ldPath)
+}
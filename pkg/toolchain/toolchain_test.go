@@ -0,0 +1,66 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package toolchain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		root        string
+		expectedErr bool
+		expectedEnv string
+	}{
+		{
+			name:        "",
+			expectedEnv: "CC=gcc CXX=g++ FC=gfortran",
+		},
+		{
+			name:        GCC,
+			root:        "/usr",
+			expectedEnv: "CC=gcc CXX=g++ FC=gfortran",
+		},
+		{
+			name:        ArmClang,
+			root:        "/opt/arm/acfl",
+			expectedEnv: "CC=armclang CXX=armclang++ FC=armflang PATH=/opt/arm/acfl/bin:/usr/bin LD_LIBRARY_PATH=/opt/arm/acfl/lib:/usr/lib",
+		},
+		{
+			name:        NVHPC,
+			root:        "/opt/nvhpc/23.11/",
+			expectedEnv: "CC=nvc CXX=nvc++ FC=nvfortran CFLAGS=-fPIC CXXFLAGS=-fPIC FCFLAGS=-fPIC PATH=/opt/nvhpc/23.11/compilers/bin:/usr/bin LD_LIBRARY_PATH=/opt/nvhpc/23.11/compilers/lib:/usr/lib",
+		},
+		{
+			name:        NVHPC,
+			expectedErr: true,
+		},
+		{
+			name:        "icc",
+			root:        "/opt/intel",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tc, err := Get(tt.name, tt.root)
+		if tt.expectedErr {
+			if err == nil {
+				t.Fatalf("getting toolchain %s in '%s' succeeded while expected to fail", tt.name, tt.root)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to get toolchain %s in '%s': %s", tt.name, tt.root, err)
+		}
+		env := strings.Join(tc.GetEnv("/usr/bin", "/usr/lib"), " ")
+		if env != tt.expectedEnv {
+			t.Fatalf("environment of toolchain %s is '%s' instead of '%s'", tt.name, env, tt.expectedEnv)
+		}
+	}
+}